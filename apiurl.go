@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// baseURL returns the URL of the ServiceNow instance, from its api_url when set, e.g. https://gateway.corp/snow for
+// https://gateway.corp/snow/api/now. The default OAuth2 token URL is relative to it.
+func (c ServiceNowConfig) baseURL() string {
+	if len(c.APIURL) > 0 {
+		return strings.TrimSuffix(strings.TrimRight(c.APIURL, "/"), serviceNowAPIPath)
+	}
+	return fmt.Sprintf(serviceNowBaseURL, c.InstanceName)
+}
+
+// instanceLabel names the instance in the logs and metrics: its instance_name, or the host of its api_url
+func (c ServiceNowConfig) instanceLabel() string {
+	if len(c.InstanceName) > 0 || len(c.APIURL) == 0 {
+		return c.InstanceName
+	}
+	if u, err := url.Parse(c.APIURL); err == nil {
+		return u.Host
+	}
+	return c.APIURL
+}
+
+func validateAPIURL(c ServiceNowConfig, errs *strings.Builder) {
+	if len(c.APIURL) == 0 {
+		return
+	}
+	u, err := url.Parse(c.APIURL)
+	if err != nil || len(u.Host) == 0 || (u.Scheme != "http" && u.Scheme != "https") {
+		errs.WriteString("service_now.api_url must be an absolute http or https URL\n")
+		return
+	}
+	if c.OAuth2.enabled() && len(c.OAuth2.TokenURL) == 0 && !strings.HasSuffix(strings.TrimRight(c.APIURL, "/"), serviceNowAPIPath) {
+		errs.WriteString("service_now.oauth2.token_url is missing, it cannot be derived from an api_url not ending with /api/now\n")
+	}
+}
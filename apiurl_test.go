@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewSnClient_APIURL(t *testing.T) {
+	var path string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Write([]byte(`{"result": []}`))
+	}))
+	defer ts.Close()
+
+	client, err := newSnClient(ServiceNowConfig{APIURL: ts.URL + "/snow/api/now/", UserName: "user", Password: "password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetIncidents(context.Background(), "incident", nil); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/snow/api/now/v2/table/incident" {
+		t.Errorf("The requests should be sent to the api_url: got %s", path)
+	}
+	if snClient := client.(*ServiceNowClient); snClient.baseURL != ts.URL+"/snow" {
+		t.Errorf("Unexpected base URL: %s", snClient.baseURL)
+	}
+}
+
+func TestServiceNowConfig_InstanceLabel(t *testing.T) {
+	if label := (ServiceNowConfig{InstanceName: "instance", APIURL: "https://gateway.corp/snow/api/now"}).instanceLabel(); label != "instance" {
+		t.Errorf("Unexpected label: %s", label)
+	}
+	if label := (ServiceNowConfig{APIURL: "https://gateway.corp/snow/api/now"}).instanceLabel(); label != "gateway.corp" {
+		t.Errorf("Unexpected label: %s", label)
+	}
+}
+
+func TestValidateAPIURL(t *testing.T) {
+	var errs strings.Builder
+	validateAPIURL(ServiceNowConfig{APIURL: "gateway.corp/api/now"}, &errs)
+	validateAPIURL(ServiceNowConfig{APIURL: "https://gateway.corp/snow", OAuth2: OAuth2Config{ClientID: "id", ClientSecret: "secret"}}, &errs)
+	for _, want := range []string{"service_now.api_url must be an absolute", "service_now.oauth2.token_url is missing"} {
+		if !strings.Contains(errs.String(), want) {
+			t.Errorf("Missing validation error %q: %q", want, errs.String())
+		}
+	}
+	errs.Reset()
+	validateAPIURL(ServiceNowConfig{APIURL: "https://gateway.corp/api/now", OAuth2: OAuth2Config{ClientID: "id", ClientSecret: "secret"}}, &errs)
+	if errs.Len() > 0 {
+		t.Errorf("Unexpected validation errors: %q", errs.String())
+	}
+}
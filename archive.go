@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	archiveTimestamp     = "20060102T150405.000000000"
+	archivePruneInterval = time.Hour
+	archiveFileExt       = ".json"
+)
+
+var (
+	// notificationArchive stores the received notifications, nil when the archive is disabled
+	notificationArchive archiveStore
+
+	archivedNotifications = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "webhook_archived_notifications_total",
+			Help: "Total number of notifications written to the archive.",
+		},
+	)
+	archiveErrors = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "webhook_archive_errors_total",
+			Help: "Total number of notifications that could not be written to the archive, or archived ones that could not be pruned.",
+		},
+	)
+)
+
+// ArchiveConfig - Archive of the raw notifications received on /webhook, with the incident payloads they rendered,
+// to a local directory or to an S3 bucket, for debugging and for their replay
+type ArchiveConfig struct {
+	Directory string        `yaml:"directory"`
+	S3        S3Config      `yaml:"s3"`
+	Retention time.Duration `yaml:"retention"`
+}
+
+func (c ArchiveConfig) validate(errs *strings.Builder) {
+	if len(c.Directory) > 0 && len(c.S3.Bucket) > 0 {
+		errs.WriteString("archive.directory and archive.s3 are mutually exclusive\n")
+	}
+	if len(c.S3.Bucket) > 0 {
+		c.S3.validate("archive.s3", errs)
+	}
+	if c.Retention < 0 {
+		errs.WriteString("archive.retention must not be negative\n")
+	}
+}
+
+// archivedNotification is an archive entry, holding a notification and the incident payloads it rendered
+type archivedNotification struct {
+	ReceivedAt      time.Time          `json:"received_at"`
+	RequestID       string             `json:"request_id,omitempty"`
+	WebhookReceiver string             `json:"webhook_receiver,omitempty"`
+	DryRun          bool               `json:"dry_run,omitempty"`
+	Notification    template.Data      `json:"notification"`
+	Incidents       []archivedIncident `json:"incidents,omitempty"`
+	Error           string             `json:"error,omitempty"`
+}
+
+// archivedIncident is an incident payload sent to ServiceNow while processing the notification
+type archivedIncident struct {
+	Operation string   `json:"operation"`
+	Instance  string   `json:"instance,omitempty"`
+	Table     string   `json:"table"`
+	SysID     string   `json:"sys_id,omitempty"`
+	Payload   Incident `json:"payload"`
+}
+
+// archiveRecorder collects the incident payloads of a notification being processed
+type archiveRecorder struct {
+	mutex      sync.Mutex
+	receivedAt time.Time
+	incidents  []archivedIncident
+}
+
+type archiveRecorderContextKey struct{}
+
+// withArchive returns a context collecting the incident payloads of the notification, when the archive is enabled
+func withArchive(ctx context.Context) context.Context {
+	if notificationArchive == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, archiveRecorderContextKey{}, &archiveRecorder{receivedAt: time.Now()})
+}
+
+func archiveRecorderFrom(ctx context.Context) *archiveRecorder {
+	recorder, _ := ctx.Value(archiveRecorderContextKey{}).(*archiveRecorder)
+	return recorder
+}
+
+func (r *archiveRecorder) record(ctx context.Context, operation string, tableName string, sysID string, payload Incident) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.incidents = append(r.incidents, archivedIncident{Operation: operation, Instance: instanceFrom(ctx), Table: tableName, SysID: sysID, Payload: payload})
+}
+
+// archiveClient records the payloads of the records created and updated while processing a notification
+type archiveClient struct {
+	ServiceNow
+	recorder *archiveRecorder
+}
+
+// CreateIncident records the payload and creates the record
+func (c archiveClient) CreateIncident(ctx context.Context, tableName string, incidentParam Incident) (Incident, error) {
+	c.recorder.record(ctx, incidentCreated, tableName, "", incidentParam)
+	return c.ServiceNow.CreateIncident(ctx, tableName, incidentParam)
+}
+
+// UpdateIncident records the payload and updates the record
+func (c archiveClient) UpdateIncident(ctx context.Context, tableName string, incidentParam Incident, sysID string) (Incident, error) {
+	c.recorder.record(ctx, auditOperationFrom(ctx, incidentUpdated), tableName, sysID, incidentParam)
+	return c.ServiceNow.UpdateIncident(ctx, tableName, incidentParam, sysID)
+}
+
+// PatchIncident records the payload and patches the record
+func (c archiveClient) PatchIncident(ctx context.Context, tableName string, incidentParam Incident, sysID string) (Incident, error) {
+	c.recorder.record(ctx, auditOperationFrom(ctx, incidentUpdated), tableName, sysID, incidentParam)
+	return c.ServiceNow.PatchIncident(ctx, tableName, incidentParam, sysID)
+}
+
+// archiveNotification writes the processed notification, with the incident payloads it rendered and its processing
+// error, to the archive. A failed write is only logged, the notification being processed nonetheless.
+func archiveNotification(ctx context.Context, data template.Data, processingErr error) {
+	recorder := archiveRecorderFrom(ctx)
+	if recorder == nil || notificationArchive == nil {
+		return
+	}
+	recorder.mutex.Lock()
+	entry := archivedNotification{
+		ReceivedAt:      recorder.receivedAt.UTC(),
+		RequestID:       requestIDFrom(ctx),
+		WebhookReceiver: receiverFrom(ctx),
+		DryRun:          isDryRun(ctx),
+		Notification:    data,
+		Incidents:       recorder.incidents,
+	}
+	recorder.mutex.Unlock()
+	if processingErr != nil {
+		entry.Error = processingErr.Error()
+	}
+	content, err := json.MarshalIndent(entry, "", "  ")
+	if err == nil {
+		err = notificationArchive.put(ctx, archiveEntryName(entry), content)
+	}
+	if err != nil {
+		archiveErrors.Inc()
+		loggerFrom(ctx).Errorf("Error archiving the notification: %v", err)
+		return
+	}
+	archivedNotifications.Inc()
+}
+
+// archiveEntryName names the entry after its reception time, so that the entries sort chronologically, and its
+// request ID, stripped of the characters unsafe in a file name or an object key
+func archiveEntryName(entry archivedNotification) string {
+	name := entry.ReceivedAt.Format(archiveTimestamp)
+	if id := strings.Map(safeArchiveRune, entry.RequestID); len(id) > 0 {
+		if len(id) > 64 {
+			id = id[:64]
+		}
+		name += "-" + id
+	}
+	return name + archiveFileExt
+}
+
+func safeArchiveRune(r rune) rune {
+	if (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' || r == '_' || r == '.' {
+		return r
+	}
+	return '_'
+}
+
+// archiveStore stores the archive entries, and prunes the ones older than the retention
+type archiveStore interface {
+	put(ctx context.Context, name string, content []byte) error
+	prune(ctx context.Context, before time.Time) (int, error)
+}
+
+// directoryArchive stores the archive entries as the files of a local directory
+type directoryArchive struct {
+	directory string
+}
+
+func newDirectoryArchive(directory string) (*directoryArchive, error) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, fmt.Errorf("error creating the archive directory: %v", err)
+	}
+	return &directoryArchive{directory: directory}, nil
+}
+
+// put writes the entry through a temporary file, so that an entry is never read partially written
+func (a *directoryArchive) put(ctx context.Context, name string, content []byte) error {
+	path := filepath.Join(a.directory, name)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (a *directoryArchive) prune(ctx context.Context, before time.Time) (int, error) {
+	files, err := ioutil.ReadDir(a.directory)
+	if err != nil {
+		return 0, err
+	}
+	pruned := 0
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), archiveFileExt) || !file.ModTime().Before(before) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(a.directory, file.Name())); err != nil {
+			return pruned, err
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// s3Archive stores the archive entries as the objects of an S3 bucket, under its prefix
+type s3Archive struct {
+	client *s3Client
+}
+
+func (a *s3Archive) put(ctx context.Context, name string, content []byte) error {
+	return a.client.putObject(ctx, name, "application/json", content)
+}
+
+func (a *s3Archive) prune(ctx context.Context, before time.Time) (int, error) {
+	objects, err := a.client.listObjects(ctx)
+	if err != nil {
+		return 0, err
+	}
+	pruned := 0
+	for _, object := range objects {
+		if !strings.HasSuffix(object.Key, archiveFileExt) || !object.LastModified.Before(before) {
+			continue
+		}
+		if err := a.client.deleteObject(ctx, object.Key); err != nil {
+			return pruned, err
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// loadArchive opens the archive of the notifications, when configured
+func loadArchive(config Config) error {
+	notificationArchive = nil
+	c := config.Archive
+	switch {
+	case len(c.Directory) > 0:
+		archive, err := newDirectoryArchive(c.Directory)
+		if err != nil {
+			return err
+		}
+		notificationArchive = archive
+		baseLogger.Infof("Archiving the notifications to %s", c.Directory)
+	case len(c.S3.Bucket) > 0:
+		client, err := newS3Client(c.S3)
+		if err != nil {
+			return err
+		}
+		notificationArchive = &s3Archive{client: client}
+		baseLogger.Infof("Archiving the notifications to the S3 bucket %s", c.S3.Bucket)
+	}
+	return nil
+}
+
+// startArchivePruning starts the background task removing the archive entries older than the retention
+func startArchivePruning(config Config) {
+	archive, retention := notificationArchive, config.Archive.Retention
+	if archive == nil || retention <= 0 {
+		return
+	}
+	backgroundTasks.Go("archive pruning", func(ctx context.Context) {
+		prune := func() {
+			pruned, err := archive.prune(ctx, time.Now().Add(-retention))
+			if err != nil {
+				archiveErrors.Inc()
+				baseLogger.Errorf("Error pruning the archive: %v", err)
+			}
+			if pruned > 0 {
+				baseLogger.Infof("%d archived notification(s) older than %v pruned", pruned, retention)
+			}
+		}
+		prune()
+		runEvery(ctx, archivePruneInterval, prune)
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+func newTestArchive(t *testing.T) (string, func()) {
+	directory, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	currentConfig().Archive = ArchiveConfig{Directory: directory}
+	if err := loadArchive(*currentConfig()); err != nil {
+		t.Fatal(err)
+	}
+	return directory, func() {
+		notificationArchive = nil
+		os.RemoveAll(directory)
+	}
+}
+
+func TestWebhook_Archive(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	dedupStore = newMemoryDedupStore()
+	directory, cleanup := newTestArchive(t)
+	defer cleanup()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "incident", mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", "incident", mock.Anything).Return(Incident{"sys_id": "1", "number": "INC1"}, nil)
+
+	postAlertGroup(t, "test/alertmanager_firing.json")
+	files, _ := filepath.Glob(filepath.Join(directory, "*.json"))
+	if len(files) != 1 {
+		t.Fatalf("The notification should be archived: %v", files)
+	}
+	entry, err := readArchivedNotification(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Notification.Status != "firing" || len(entry.RequestID) == 0 || !strings.Contains(files[0], entry.RequestID) {
+		t.Errorf("Unexpected archived notification: %+v", entry)
+	}
+	if len(entry.Incidents) != 1 || entry.Incidents[0].Operation != incidentCreated || len(entry.Incidents[0].Payload) == 0 {
+		t.Errorf("The rendered incident payload should be archived: %+v", entry.Incidents)
+	}
+}
+
+func TestDirectoryArchive_Prune(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	directory, cleanup := newTestArchive(t)
+	defer cleanup()
+	ctx := context.Background()
+	notificationArchive.put(ctx, "old.json", []byte("{}"))
+	notificationArchive.put(ctx, "new.json", []byte("{}"))
+	old := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(filepath.Join(directory, "old.json"), old, old)
+
+	if pruned, err := notificationArchive.prune(ctx, time.Now().Add(-24*time.Hour)); err != nil || pruned != 1 {
+		t.Fatalf("The old entry should be pruned: %v, %v", pruned, err)
+	}
+	if _, err := os.Stat(filepath.Join(directory, "new.json")); err != nil {
+		t.Errorf("The recent entry should be kept: %v", err)
+	}
+}
+
+func TestArchiveEntryName(t *testing.T) {
+	entry := archivedNotification{ReceivedAt: time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC), RequestID: "../a b"}
+	if got, want := archiveEntryName(entry), "20200102T030405.000000006-.._a_b.json"; got != want {
+		t.Errorf("Unexpected entry name: got %q, want %q", got, want)
+	}
+}
+
+func TestArchiveConfig_Validate(t *testing.T) {
+	var errs strings.Builder
+	ArchiveConfig{Directory: "/tmp", S3: S3Config{Bucket: "b", Endpoint: "minio"}, Retention: -1}.validate(&errs)
+	for _, want := range []string{"archive.directory and archive.s3 are mutually exclusive", "archive.s3.region is missing", `archive.s3.endpoint "minio"`, "archive.retention must not be negative"} {
+		if !strings.Contains(errs.String(), want) {
+			t.Errorf("Missing validation error %q: %q", want, errs.String())
+		}
+	}
+}
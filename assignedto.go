@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+const assignedToField = "assigned_to"
+
+// AssignedToConfig - Incident assignee resolution configuration, from an alert label or annotation holding a
+// ServiceNow user name, or else from the on-call schedule of the assignment group
+type AssignedToConfig struct {
+	Label      string `yaml:"label"`
+	Annotation string `yaml:"annotation"`
+	// OnCall assigns the incidents without assignee to the user currently on call for their assignment group
+	OnCall         bool          `yaml:"on_call"`
+	OnCallCacheTTL time.Duration `yaml:"on_call_cache_ttl"`
+}
+
+func (c AssignedToConfig) validate(errs *strings.Builder) {
+	if c.OnCallCacheTTL < 0 {
+		errs.WriteString("assigned_to.on_call_cache_ttl must not be negative\n")
+	}
+}
+
+func (c AssignedToConfig) onCallCacheTTL() time.Duration {
+	if c.OnCallCacheTTL == 0 {
+		return defaultOnCallCacheTTL
+	}
+	return c.OnCallCacheTTL
+}
+
+func (c AssignedToConfig) enabled() bool {
+	return len(c.Label) > 0 || len(c.Annotation) > 0
+}
+
+// assignee returns the user name of the first alert having the configured label, or else the configured annotation
+func (c AssignedToConfig) assignee(data template.Data) string {
+	for _, alert := range data.Alerts {
+		if userName := strings.TrimSpace(alert.Labels[c.Label]); len(userName) > 0 {
+			return userName
+		}
+	}
+	for _, alert := range data.Alerts {
+		if userName := strings.TrimSpace(alert.Annotations[c.Annotation]); len(userName) > 0 {
+			return userName
+		}
+	}
+	return ""
+}
+
+// applyAssignedTo sets the incident assignee with the sys_id of the user found in the configured label or annotation.
+// The field is omitted when the user is not resolved.
+func applyAssignedTo(ctx context.Context, incident Incident, data template.Data) {
+	config := configFrom(ctx)
+	if !config.AssignedTo.enabled() {
+		return
+	}
+	userName := config.AssignedTo.assignee(data)
+	if len(userName) == 0 {
+		return
+	}
+	if sysIDs := resolveUsers(ctx, []string{userName}); len(sysIDs) > 0 {
+		incident[assignedToField] = sysIDs[0]
+	}
+}
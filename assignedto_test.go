@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+func TestAssignedToConfig_Assignee(t *testing.T) {
+	c := AssignedToConfig{Label: "owner", Annotation: "owner"}
+	data := template.Data{Alerts: template.Alerts{
+		template.Alert{Annotations: template.KV{"owner": "asmith"}},
+		template.Alert{Labels: template.KV{"owner": " jdoe "}},
+	}}
+	if userName := c.assignee(data); userName != "jdoe" {
+		t.Errorf("The label should take precedence over the annotation: got %q", userName)
+	}
+	data.Alerts[1].Labels = template.KV{}
+	if userName := c.assignee(data); userName != "asmith" {
+		t.Errorf("The annotation should be used without label: got %q", userName)
+	}
+}
+
+func TestApplyAssignedTo(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().AssignedTo = AssignedToConfig{Annotation: "owner"}
+	userCache = newLookupCache("user", time.Minute)
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	mockUserLookup(snClientMock, "jdoe", "1")
+	mockUserLookup(snClientMock, "unknown", "")
+
+	incident := Incident{}
+	applyAssignedTo(context.Background(), incident, template.Data{Alerts: template.Alerts{template.Alert{Annotations: template.KV{"owner": "jdoe"}}}})
+	if incident[assignedToField] != "1" {
+		t.Errorf("Unexpected assignee: %v", incident)
+	}
+	incident = Incident{}
+	applyAssignedTo(context.Background(), incident, template.Data{Alerts: template.Alerts{template.Alert{Annotations: template.KV{"owner": "unknown"}}}})
+	if _, ok := incident[assignedToField]; ok {
+		t.Errorf("The assignee should be omitted when the user is not resolved: %v", incident)
+	}
+}
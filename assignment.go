@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	assignmentGroupField           = "assignment_group"
+	groupTable                     = "sys_user_group"
+	defaultAssignmentRetryInterval = time.Minute
+	defaultAssignmentMaxRetries    = 5
+)
+
+var (
+	groupCache         = newLookupCache("group", defaultLookupCacheTTL)
+	pendingAssignments = newAssignmentRetryQueue()
+
+	assignmentRetriesExhausted = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "webhook_assignment_group_retries_exhausted_total",
+			Help: "Total number of deferred assignment group resolutions abandoned after the maximum number of retries.",
+		},
+	)
+)
+
+// AssignmentGroupConfig - Incident assignment group resolution configuration
+type AssignmentGroupConfig struct {
+	Label        string                `yaml:"label"`
+	DefaultGroup string                `yaml:"default_group"`
+	CacheTTL     time.Duration         `yaml:"cache_ttl"`
+	Retry        AssignmentRetryConfig `yaml:"retry"`
+}
+
+// AssignmentRetryConfig - Deferred assignment group resolution configuration
+type AssignmentRetryConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	Interval   time.Duration `yaml:"interval"`
+	MaxRetries int           `yaml:"max_retries"`
+}
+
+func (c AssignmentGroupConfig) validate(errs *strings.Builder) {
+	if c.Retry.Enabled && len(c.Label) == 0 {
+		errs.WriteString("assignment_group.label is missing, it is required by assignment_group.retry\n")
+	}
+	if c.Retry.MaxRetries < 0 {
+		errs.WriteString("assignment_group.retry.max_retries must not be negative\n")
+	}
+	if c.CacheTTL < 0 {
+		errs.WriteString("assignment_group.cache_ttl must not be negative\n")
+	}
+}
+
+func (c AssignmentRetryConfig) interval() time.Duration {
+	if c.Interval <= 0 {
+		return defaultAssignmentRetryInterval
+	}
+	return c.Interval
+}
+
+func (c AssignmentRetryConfig) maxRetries() int {
+	if c.MaxRetries == 0 {
+		return defaultAssignmentMaxRetries
+	}
+	return c.MaxRetries
+}
+
+// assignmentGroupName returns the assignment group name found in the configured label of the alert group
+func assignmentGroupName(c AssignmentGroupConfig, data template.Data) string {
+	return labelValue(c.Label, data)
+}
+
+// applyAssignmentGroup sets the incident assignment group with the sys_id of the group found in the configured label,
+// or with the default group when it is not resolved.
+// When the lookup fails and the deferred retry is enabled, the group name is returned so that the created incident is
+// re-assigned once the group is resolved.
+func applyAssignmentGroup(ctx context.Context, incident Incident, data template.Data) string {
+	config := configFrom(ctx)
+	c := config.AssignmentGroup
+	if len(c.Label) == 0 {
+		return ""
+	}
+
+	name := assignmentGroupName(c, data)
+	if len(name) == 0 {
+		setDefaultAssignmentGroup(c, incident)
+		return ""
+	}
+
+	sysID, err := lookupSysID(ctx, groupCache, groupTable, "name", name)
+	if err != nil {
+		serviceNowError.Inc()
+		setDefaultAssignmentGroup(c, incident)
+		if c.Retry.Enabled {
+			loggerFrom(ctx).Warnf("Error resolving ServiceNow group %s, the incident will be re-assigned once it is resolved: %v", name, err)
+			return name
+		}
+		loggerFrom(ctx).Errorf("Error resolving ServiceNow group %s: %v", name, err)
+		return ""
+	}
+	if len(sysID) == 0 {
+		loggerFrom(ctx).Warnf("ServiceNow group %s not found", name)
+		setDefaultAssignmentGroup(c, incident)
+		return ""
+	}
+	incident[assignmentGroupField] = sysID
+	return ""
+}
+
+func setDefaultAssignmentGroup(c AssignmentGroupConfig, incident Incident) {
+	if len(c.DefaultGroup) > 0 {
+		incident[assignmentGroupField] = c.DefaultGroup
+	} else {
+		delete(incident, assignmentGroupField)
+	}
+}
+
+// deferAssignmentGroup queues the re-assignment of the created incident to the group, once it is resolved
+func deferAssignmentGroup(ctx context.Context, tableName string, incident Incident, group string) {
+	if len(group) == 0 || incident == nil || len(incident.GetSysID()) == 0 {
+		return
+	}
+	loggerFrom(ctx).Infof("Incident %s will be re-assigned to group %s once it is resolved", incident.GetNumber(), group)
+	pendingAssignments.add(instanceFrom(ctx), tableName, incident.GetSysID(), group)
+}
+
+type pendingAssignment struct {
+	instance  string
+	tableName string
+	sysID     string
+	group     string
+	attempts  int
+}
+
+// assignmentRetryQueue holds the incidents waiting for the resolution of their assignment group, by instance, table and
+// sys_id
+type assignmentRetryQueue struct {
+	mutex   sync.Mutex
+	pending map[string]*pendingAssignment
+}
+
+func newAssignmentRetryQueue() *assignmentRetryQueue {
+	return &assignmentRetryQueue{pending: map[string]*pendingAssignment{}}
+}
+
+func (q *assignmentRetryQueue) add(instance string, tableName string, sysID string, group string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	assignment := &pendingAssignment{instance: instance, tableName: tableName, sysID: sysID, group: group}
+	q.pending[assignment.key()] = assignment
+}
+
+func (a *pendingAssignment) key() string {
+	return a.instance + "/" + a.tableName + "/" + a.sysID
+}
+
+func (q *assignmentRetryQueue) len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.pending)
+}
+
+// retry tries once to resolve the group of each pending incident and to patch the incident with it.
+// Incidents are forgotten once patched, when their group does not exist, or after the maximum number of retries.
+func (q *assignmentRetryQueue) retry(ctx context.Context, c AssignmentRetryConfig) {
+	q.mutex.Lock()
+	pending := make([]*pendingAssignment, 0, len(q.pending))
+	for _, assignment := range q.pending {
+		pending = append(pending, assignment)
+	}
+	q.mutex.Unlock()
+
+	for _, assignment := range pending {
+		done, err := patchAssignmentGroup(ctx, assignment)
+		q.mutex.Lock()
+		if err != nil {
+			serviceNowError.Inc()
+			assignment.attempts++
+			if assignment.attempts >= c.maxRetries() {
+				assignmentRetriesExhausted.Inc()
+				loggerFrom(ctx).Errorf("Giving up re-assigning incident %s to group %s after %d retries: %v", assignment.sysID, assignment.group, assignment.attempts, err)
+				done = true
+			} else {
+				loggerFrom(ctx).Warnf("Error re-assigning incident %s to group %s (retry %d/%d): %v", assignment.sysID, assignment.group, assignment.attempts, c.maxRetries(), err)
+			}
+		}
+		if done {
+			delete(q.pending, assignment.key())
+		}
+		q.mutex.Unlock()
+	}
+}
+
+// patchAssignmentGroup resolves the group of the pending incident and patches the incident with it.
+// It returns whether the incident needs no further retry.
+func patchAssignmentGroup(ctx context.Context, assignment *pendingAssignment) (bool, error) {
+	ctx = withInstance(ctx, assignment.instance)
+	sysID, err := lookupSysID(ctx, groupCache, groupTable, "name", assignment.group)
+	if err != nil {
+		return false, err
+	}
+	if len(sysID) == 0 {
+		loggerFrom(ctx).Warnf("ServiceNow group %s not found, incident %s will not be re-assigned", assignment.group, assignment.sysID)
+		return true, nil
+	}
+	if _, err := serviceNowFrom(ctx).UpdateIncident(ctx, assignment.tableName, Incident{assignmentGroupField: sysID}, assignment.sysID); err != nil {
+		return false, fmt.Errorf("error patching the incident: %v", err)
+	}
+	loggerFrom(ctx).Infof("Incident %s re-assigned to group %s", assignment.sysID, assignment.group)
+	return true, nil
+}
+
+// startAssignmentRetry starts the background task retrying the deferred assignment group resolutions
+func startAssignmentRetry(config Config) {
+	c := config.AssignmentGroup.Retry
+	if !c.Enabled {
+		return
+	}
+	backgroundTasks.Go("assignment group retry", func(ctx context.Context) {
+		ctx = withAuditCaller(ctx, auditCaller{Source: "assignment group retry"})
+		runEvery(ctx, c.interval(), func() {
+			// The standby replicas keep their pending assignments, retried once they are elected
+			if !leader.isLeader() {
+				return
+			}
+			pendingAssignments.retry(withConfigSnapshot(ctx), c)
+		})
+	})
+}
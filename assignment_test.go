@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/mock"
+)
+
+var groupLookupParams = map[string]string{"name": "Databases", "sysparm_fields": "sys_id", "sysparm_limit": "1"}
+
+func assignmentAlertGroup() template.Data {
+	return template.Data{
+		Status:       "firing",
+		GroupLabels:  template.KV{"alertname": "DiskFull"},
+		CommonLabels: template.KV{"alertname": "DiskFull", "team_group": "Databases"},
+		Alerts:       template.Alerts{template.Alert{Status: "firing", Labels: template.KV{"alertname": "DiskFull", "team_group": "Databases"}}},
+	}
+}
+
+func TestApplyAssignmentGroup(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    AssignmentGroupConfig
+		sysIDs    []Incident
+		err       error
+		want      interface{}
+		wantDefer string
+	}{
+		{name: "resolved", config: AssignmentGroupConfig{Label: "team_group", DefaultGroup: "placeholder"}, sysIDs: []Incident{Incident{"sys_id": "42"}}, want: "42"},
+		{name: "not_found", config: AssignmentGroupConfig{Label: "team_group", DefaultGroup: "placeholder"}, sysIDs: []Incident{}, want: "placeholder"},
+		{name: "error", config: AssignmentGroupConfig{Label: "team_group", DefaultGroup: "placeholder"}, err: errors.New("Error"), want: "placeholder"},
+		{name: "error_deferred", config: AssignmentGroupConfig{Label: "team_group", DefaultGroup: "placeholder", Retry: AssignmentRetryConfig{Enabled: true}}, err: errors.New("Error"), want: "placeholder", wantDefer: "Databases"},
+		{name: "error_without_default", config: AssignmentGroupConfig{Label: "team_group"}, err: errors.New("Error"), want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loadConfig("config/servicenow_example.yml")
+			currentConfig().AssignmentGroup = tt.config
+			groupCache = newLookupCache("group", time.Minute)
+			snClientMock := new(MockedSnClient)
+			useServiceNow(snClientMock)
+			snClientMock.On("GetIncidents", "sys_user_group", groupLookupParams).Return(tt.sysIDs, tt.err)
+
+			incident := Incident{assignmentGroupField: "Databases"}
+			deferred := applyAssignmentGroup(context.Background(), incident, assignmentAlertGroup())
+			if incident[assignmentGroupField] != tt.want {
+				t.Errorf("Unexpected assignment group: got %v, want %v", incident[assignmentGroupField], tt.want)
+			}
+			if deferred != tt.wantDefer {
+				t.Errorf("Unexpected deferred group: got %q, want %q", deferred, tt.wantDefer)
+			}
+		})
+	}
+}
+
+func TestOnAlertGroup_DeferredAssignmentGroup(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().AssignmentGroup = AssignmentGroupConfig{Label: "team_group", DefaultGroup: "placeholder", Retry: AssignmentRetryConfig{Enabled: true}}
+	groupCache = newLookupCache("group", time.Minute)
+	pendingAssignments = newAssignmentRetryQueue()
+	dedupStore = newMemoryDedupStore()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "sys_user_group", groupLookupParams).Return([]Incident{}, errors.New("Error")).Once()
+	snClientMock.On("GetIncidents", "sys_user_group", groupLookupParams).Return([]Incident{Incident{"sys_id": "42"}}, nil).Once()
+	snClientMock.On("GetIncidents", "incident", mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", "incident", mock.Anything).Return(Incident{"sys_id": "1", "number": "INC1"}, nil)
+	snClientMock.On("UpdateIncident", "incident", Incident{assignmentGroupField: "42"}, "1").Return(Incident{}, nil)
+
+	if err := onAlertGroup(context.Background(), assignmentAlertGroup()); err != nil {
+		t.Fatal(err)
+	}
+	for _, call := range snClientMock.Calls {
+		if call.Method == "CreateIncident" && call.Arguments.Get(1).(Incident)[assignmentGroupField] != "placeholder" {
+			t.Errorf("Incident should be created with the default group, got %v", call.Arguments.Get(1).(Incident)[assignmentGroupField])
+		}
+	}
+	if pendingAssignments.len() != 1 {
+		t.Fatalf("Unexpected pending re-assignments: got %d, want 1", pendingAssignments.len())
+	}
+
+	pendingAssignments.retry(context.Background(), currentConfig().AssignmentGroup.Retry)
+
+	snClientMock.AssertCalled(t, "UpdateIncident", "incident", Incident{assignmentGroupField: "42"}, "1")
+	if pendingAssignments.len() != 0 {
+		t.Errorf("Re-assigned incident should not be pending anymore")
+	}
+}
+
+func TestAssignmentRetryQueue_MaxRetries(t *testing.T) {
+	groupCache = newLookupCache("group", time.Minute)
+	queue := newAssignmentRetryQueue()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "sys_user_group", groupLookupParams).Return([]Incident{}, errors.New("Error"))
+
+	queue.add("", "incident", "1", "Databases")
+	c := AssignmentRetryConfig{Enabled: true, MaxRetries: 3}
+	for i := 0; i < 5; i++ {
+		queue.retry(context.Background(), c)
+	}
+
+	snClientMock.AssertNumberOfCalls(t, "GetIncidents", 3)
+	snClientMock.AssertNotCalled(t, "UpdateIncident", mock.Anything, mock.Anything, mock.Anything)
+	if queue.len() != 0 {
+		t.Errorf("Re-assignment should be abandoned after the maximum number of retries")
+	}
+}
+
+func TestAssignmentGroup_CacheTTL(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().AssignmentGroup = AssignmentGroupConfig{Label: "team_group", CacheTTL: time.Nanosecond}
+	groupCache = newLookupCache("group", time.Minute)
+	applyConfig()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "sys_user_group", groupLookupParams).Return([]Incident{Incident{"sys_id": "42"}}, nil)
+
+	for i := 0; i < 2; i++ {
+		applyAssignmentGroup(context.Background(), Incident{}, assignmentAlertGroup())
+		time.Sleep(time.Millisecond)
+	}
+	snClientMock.AssertNumberOfCalls(t, "GetIncidents", 2)
+}
+
+func TestAssignmentGroupConfig_Validate(t *testing.T) {
+	var errs strings.Builder
+	AssignmentGroupConfig{CacheTTL: -1, Retry: AssignmentRetryConfig{Enabled: true, MaxRetries: -1}}.validate(&errs)
+	if !strings.Contains(errs.String(), "assignment_group.label") || !strings.Contains(errs.String(), "max_retries") || !strings.Contains(errs.String(), "cache_ttl") {
+		t.Errorf("Unexpected validation errors: %q", errs.String())
+	}
+}
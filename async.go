@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	defaultAsyncWorkers   = 4
+	defaultAsyncQueueSize = 100
+)
+
+var (
+	// alertGroupQueue is nil when the alert groups are processed synchronously
+	alertGroupQueue *asyncQueue
+
+	asyncQueueLength = promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "webhook_async_queue_length",
+			Help: "Number of alert groups waiting in the queue of the asynchronous processing.",
+		},
+		func() float64 {
+			if alertGroupQueue == nil {
+				return 0
+			}
+			return float64(len(alertGroupQueue.jobs))
+		},
+	)
+	asyncQueueOldestAge = promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "webhook_async_queue_oldest_age_seconds",
+			Help: "Time the oldest alert group of the queue of the asynchronous processing has been waiting for a worker, 0 when empty.",
+		},
+		func() float64 {
+			if alertGroupQueue == nil {
+				return 0
+			}
+			return alertGroupQueue.oldestAge(time.Now()).Seconds()
+		},
+	)
+	asyncWorkers = promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "webhook_async_workers",
+			Help: "Number of workers of the asynchronous processing.",
+		},
+		func() float64 {
+			if alertGroupQueue == nil {
+				return 0
+			}
+			return float64(alertGroupQueue.workers)
+		},
+	)
+	asyncBusyWorkers = promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "webhook_async_busy_workers",
+			Help: "Number of workers of the asynchronous processing managing an alert group.",
+		},
+		func() float64 {
+			if alertGroupQueue == nil {
+				return 0
+			}
+			return float64(atomic.LoadInt32(&alertGroupQueue.busy))
+		},
+	)
+	asyncEnqueued = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "webhook_async_enqueued_total",
+			Help: "Total number of alert groups queued for the asynchronous processing.",
+		},
+	)
+	asyncDequeued = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "webhook_async_dequeued_total",
+			Help: "Total number of alert groups taken from the queue by a worker of the asynchronous processing.",
+		},
+	)
+	asyncDropped = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_async_dropped_total",
+			Help: "Total number of alert groups not processed by the asynchronous processing, as the queue was full or the webhook stopped, by reason.",
+		},
+		[]string{"reason"},
+	)
+	asyncErrors = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "webhook_async_errors_total",
+			Help: "Total number of alert groups whose asynchronous processing failed.",
+		},
+	)
+)
+
+// AsyncConfig - Asynchronous processing of the alert groups by a pool of workers
+type AsyncConfig struct {
+	Enabled   bool `yaml:"enabled"`
+	Workers   int  `yaml:"workers"`
+	QueueSize int  `yaml:"queue_size"`
+	// WALDirectory persists the queued alert groups until they are processed, so that they survive restarts
+	WALDirectory string `yaml:"wal_directory"`
+}
+
+func (c AsyncConfig) validate(errs *strings.Builder) {
+	if c.Workers < 0 {
+		errs.WriteString("async.workers must not be negative\n")
+	}
+	if c.QueueSize < 0 {
+		errs.WriteString("async.queue_size must not be negative\n")
+	}
+	if len(c.WALDirectory) > 0 && !c.Enabled {
+		errs.WriteString("async.wal_directory requires async.enabled\n")
+	}
+}
+
+func (c AsyncConfig) workers() int {
+	if c.Workers == 0 {
+		return defaultAsyncWorkers
+	}
+	return c.Workers
+}
+
+func (c AsyncConfig) queueSize() int {
+	if c.QueueSize == 0 {
+		return defaultAsyncQueueSize
+	}
+	return c.QueueSize
+}
+
+type alertGroupJob struct {
+	ctx  context.Context
+	data template.Data
+	// walID is the ID of the write-ahead log entry of the alert group, if any
+	walID string
+	// seq identifies the job among the queued ones
+	seq uint64
+}
+
+// asyncQueue is the bounded queue of the alert groups acknowledged to Alertmanager, waiting for a worker
+type asyncQueue struct {
+	jobs chan alertGroupJob
+	// wal is nil when the queued alert groups are not persisted
+	wal     *asyncWAL
+	workers int
+	busy    int32
+
+	// queuedAt holds the time each queued job was queued at, by seq
+	mutex    sync.Mutex
+	queuedAt map[uint64]time.Time
+	lastSeq  uint64
+}
+
+func newAsyncQueue(size int) *asyncQueue {
+	return &asyncQueue{jobs: make(chan alertGroupJob, size), queuedAt: map[uint64]time.Time{}}
+}
+
+// track records the time the job is queued at
+func (q *asyncQueue) track(job *alertGroupJob) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.lastSeq++
+	job.seq = q.lastSeq
+	q.queuedAt[job.seq] = time.Now()
+}
+
+// untrack forgets the job, once taken from the queue
+func (q *asyncQueue) untrack(job alertGroupJob) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	delete(q.queuedAt, job.seq)
+}
+
+// oldestAge returns how long the oldest queued job has been waiting, 0 when the queue is empty
+func (q *asyncQueue) oldestAge(now time.Time) time.Duration {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	var age time.Duration
+	for _, queuedAt := range q.queuedAt {
+		if d := now.Sub(queuedAt); d > age {
+			age = d
+		}
+	}
+	return age
+}
+
+// loadAlertGroupQueue starts the workers of the asynchronous processing, when enabled, and queues again the alert
+// groups of the write-ahead log
+func loadAlertGroupQueue(config Config) (*asyncQueue, error) {
+	alertGroupQueue = nil
+	c := config.Async
+	if !c.Enabled {
+		return nil, nil
+	}
+	q := newAsyncQueue(c.queueSize())
+	if len(c.WALDirectory) > 0 {
+		wal, err := newAsyncWAL(c.WALDirectory)
+		if err != nil {
+			return nil, err
+		}
+		q.wal = wal
+	}
+	alertGroupQueue = q
+	q.start(backgroundTasks, c.workers())
+	baseLogger.Infof("Asynchronous processing enabled with %d workers and a queue of %d alert groups", c.workers(), c.queueSize())
+	if q.wal != nil {
+		if err := q.recover(backgroundTasks); err != nil {
+			return nil, fmt.Errorf("Error reading the write-ahead log: %v", err)
+		}
+	}
+	return q, nil
+}
+
+// enqueue queues the alert group without waiting, once persisted to the write-ahead log. An overload error is returned
+// when the queue is full, so that Alertmanager retries later.
+func (q *asyncQueue) enqueue(ctx context.Context, data template.Data) error {
+	job := alertGroupJob{ctx: ctx, data: data}
+	if q.wal != nil {
+		id, err := q.wal.append(ctx, data)
+		if err != nil {
+			return fmt.Errorf("Error persisting the alert group to the write-ahead log: %v", err)
+		}
+		job.walID = id
+	}
+	q.track(&job)
+	select {
+	case q.jobs <- job:
+		asyncEnqueued.Inc()
+		return nil
+	default:
+		q.untrack(job)
+		q.release(job)
+		asyncDropped.WithLabelValues("queue_full").Inc()
+		return &overloadError{message: fmt.Sprintf("The queue of %d alert groups is full", cap(q.jobs))}
+	}
+}
+
+// release removes the write-ahead log entry of the job, once processed or rejected
+func (q *asyncQueue) release(job alertGroupJob) {
+	if q.wal == nil || len(job.walID) == 0 {
+		return
+	}
+	if err := q.wal.remove(job.walID); err != nil {
+		loggerFrom(job.ctx).Errorf("Error removing the write-ahead log entry %s, the alert group will be processed again on restart: %v", job.walID, err)
+	}
+}
+
+// start starts the workers. Once stopped, they process the alert groups still queued before returning.
+func (q *asyncQueue) start(tasks *taskGroup, workers int) {
+	q.workers += workers
+	for i := 0; i < workers; i++ {
+		tasks.Go("alert group worker", func(ctx context.Context) {
+			for {
+				select {
+				case job := <-q.jobs:
+					q.process(job)
+				case <-ctx.Done():
+					q.drain()
+					return
+				}
+			}
+		})
+	}
+}
+
+func (q *asyncQueue) drain() {
+	for {
+		select {
+		case job := <-q.jobs:
+			q.process(job)
+		default:
+			return
+		}
+	}
+}
+
+// abandon persists the alert groups still queued to the dead-letter queue, when the workers could not process them
+// before exiting. They are kept in the write-ahead log instead, if any, to be processed on the next start.
+func (q *asyncQueue) abandon() int {
+	abandoned := 0
+	for {
+		select {
+		case job := <-q.jobs:
+			abandoned++
+			q.untrack(job)
+			asyncDropped.WithLabelValues("shutdown").Inc()
+			if len(job.walID) > 0 {
+				loggerFrom(job.ctx).Warnf("Webhook stopped before managing incident from alert, kept in the write-ahead log")
+				continue
+			}
+			loggerFrom(job.ctx).Errorf("Webhook stopped before managing incident from alert")
+			deadLetterAlertGroup(job.ctx, job.data, errors.New("webhook stopped before processing the alert group"))
+		default:
+			return abandoned
+		}
+	}
+}
+
+func (q *asyncQueue) process(job alertGroupJob) {
+	q.untrack(job)
+	asyncDequeued.Inc()
+	atomic.AddInt32(&q.busy, 1)
+	defer atomic.AddInt32(&q.busy, -1)
+	ctx := withConfigSnapshot(job.ctx)
+	err := onAlertGroup(ctx, job.data)
+	archiveNotification(job.ctx, job.data, err)
+	if err != nil {
+		asyncErrors.Inc()
+		loggerFrom(job.ctx).Errorf("Error managing incident from alert : %v", err)
+		// Persisted as accepted like in the synchronous mode, replayed once ServiceNow is available again
+		if !acceptAlertGroup(ctx, job.data, err) {
+			deadLetterAlertGroup(job.ctx, job.data, err)
+		}
+	}
+	q.release(job)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/mock"
+)
+
+func postAlertGroup(t *testing.T, file string) *httptest.ResponseRecorder {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(webhook).ServeHTTP(rr, httptest.NewRequest("POST", "/webhook", bytes.NewReader(data)))
+	return rr
+}
+
+func TestWebhookHandler_Async(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{"sys_id": "1", "number": "INC1"}, nil)
+
+	tasks := newTaskGroup()
+	alertGroupQueue = newAsyncQueue(10)
+	defer func() { alertGroupQueue = nil }()
+	alertGroupQueue.start(tasks, 2)
+
+	rr := postAlertGroup(t, "test/alertmanager_firing.json")
+	if rr.Code != http.StatusAccepted {
+		t.Errorf("Wrong status code: got %v, want %v", rr.Code, http.StatusAccepted)
+	}
+
+	if running := tasks.Stop(time.Second); len(running) > 0 {
+		t.Fatalf("Workers still running: %v", running)
+	}
+	snClientMock.AssertNumberOfCalls(t, "CreateIncident", 1)
+}
+
+func TestWebhookHandler_AsyncQueueFull(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	useServiceNow(new(MockedSnClient))
+
+	// No worker consumes the queue
+	alertGroupQueue = newAsyncQueue(1)
+	defer func() { alertGroupQueue = nil }()
+
+	if rr := postAlertGroup(t, "test/alertmanager_firing.json"); rr.Code != http.StatusAccepted {
+		t.Fatalf("Wrong status code: got %v, want %v", rr.Code, http.StatusAccepted)
+	}
+	rr := postAlertGroup(t, "test/alertmanager_firing.json")
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Wrong status code: got %v, want %v", rr.Code, http.StatusServiceUnavailable)
+	}
+	if len(rr.Header().Get("Retry-After")) == 0 {
+		t.Errorf("Missing Retry-After header")
+	}
+}
+
+func TestAsyncQueue_Metrics(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	useServiceNow(new(MockedSnClient))
+	alertGroupQueue = newAsyncQueue(1)
+	defer func() { alertGroupQueue = nil }()
+	enqueued, dropped := testutil.ToFloat64(asyncEnqueued), testutil.ToFloat64(asyncDropped.WithLabelValues("queue_full"))
+
+	postAlertGroup(t, "test/alertmanager_firing.json")
+	postAlertGroup(t, "test/alertmanager_firing.json")
+	if testutil.ToFloat64(asyncEnqueued) != enqueued+1 || testutil.ToFloat64(asyncDropped.WithLabelValues("queue_full")) != dropped+1 {
+		t.Errorf("The queued and rejected alert groups should be counted")
+	}
+	if age := alertGroupQueue.oldestAge(time.Now().Add(time.Minute)); age < time.Minute {
+		t.Errorf("Unexpected age of the oldest queued alert group: %v", age)
+	}
+
+	alertGroupQueue.abandon()
+	if age := alertGroupQueue.oldestAge(time.Now()); age != 0 {
+		t.Errorf("The age should be 0 once the queue is empty: %v", age)
+	}
+}
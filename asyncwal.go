@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const walExtension = ".json"
+
+var walRecovered = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Name: "webhook_async_wal_recovered_total",
+		Help: "Total number of alert groups of the write-ahead log queued again on startup.",
+	},
+)
+
+// walEntry is an alert group persisted to the write-ahead log while it waits for a worker
+type walEntry struct {
+	ID         string    `json:"id"`
+	ReceivedAt time.Time `json:"received_at"`
+	RequestID  string    `json:"request_id,omitempty"`
+	// AlertmanagerGroupKey is the groupKey of the Alertmanager webhook message
+	AlertmanagerGroupKey string `json:"alertmanager_group_key,omitempty"`
+	DryRun               bool   `json:"dry_run,omitempty"`
+	// WebhookReceiver is the webhook receiver of the alert group, empty for /webhook
+	WebhookReceiver string        `json:"webhook_receiver,omitempty"`
+	Data            template.Data `json:"data"`
+}
+
+// asyncWAL persists each queued alert group as a JSON file of the directory, named after its ID, until it is processed
+type asyncWAL struct {
+	directory string
+}
+
+func newAsyncWAL(directory string) (*asyncWAL, error) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, fmt.Errorf("Error creating the write-ahead log directory: %v", err)
+	}
+	return &asyncWAL{directory: directory}, nil
+}
+
+func (w *asyncWAL) path(id string) string {
+	return filepath.Join(w.directory, id+walExtension)
+}
+
+// append persists the alert group queued with the context, and returns the ID of its entry. The file is synced before
+// being renamed into place, so that an acknowledged alert group survives a crash and is never read partially written.
+func (w *asyncWAL) append(ctx context.Context, data template.Data) (string, error) {
+	now := time.Now()
+	entry := walEntry{
+		ID:                   newDeadLetterID(now),
+		ReceivedAt:           now,
+		RequestID:            auditCallerFrom(ctx).RequestID,
+		AlertmanagerGroupKey: auditCallerFrom(ctx).AlertmanagerGroupKey,
+		DryRun:               isDryRun(ctx),
+		WebhookReceiver:      receiverFrom(ctx),
+		Data:                 data,
+	}
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	path := w.path(entry.ID)
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return entry.ID, os.Rename(tmp, path)
+}
+
+// remove deletes the entry of a processed alert group
+func (w *asyncWAL) remove(id string) error {
+	if err := os.Remove(w.path(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// pending returns the entries of the alert groups not processed yet, in the order they were queued
+func (w *asyncWAL) pending() ([]walEntry, error) {
+	files, err := ioutil.ReadDir(w.directory)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), walExtension) {
+			names = append(names, file.Name())
+		}
+	}
+	sort.Strings(names)
+	entries := make([]walEntry, 0, len(names))
+	for _, name := range names {
+		content, err := ioutil.ReadFile(filepath.Join(w.directory, name))
+		if err != nil {
+			return nil, err
+		}
+		var entry walEntry
+		if err := json.Unmarshal(content, &entry); err != nil {
+			baseLogger.Errorf("Invalid write-ahead log entry %s, skipped: %v", name, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// job returns the job of the entry, with the context of the webhook request that queued it
+func (e walEntry) job() alertGroupJob {
+	caller := auditCaller{Source: "write-ahead log", RequestID: e.RequestID, Receiver: e.Data.Receiver, GroupKey: getGroupKey(context.Background(), e.Data), AlertmanagerGroupKey: e.AlertmanagerGroupKey}
+	ctx := withReceiver(withAuditCaller(withLogger(context.Background(), newRequestLogger(context.Background(), e.RequestID, e.Data)), caller), e.WebhookReceiver)
+	if e.DryRun {
+		ctx = withDryRun(ctx)
+	}
+	return alertGroupJob{ctx: withArchive(ctx), data: e.Data, walID: e.ID}
+}
+
+// recover queues the alert groups of the write-ahead log again, waiting for room in the queue. The entries not
+// queued before the webhook stops are kept for the next start.
+func (q *asyncQueue) recover(tasks *taskGroup) error {
+	entries, err := q.wal.pending()
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+	baseLogger.Infof("Recovering %d alert group(s) from the write-ahead log", len(entries))
+	tasks.Go("write-ahead log recovery", func(ctx context.Context) {
+		for _, entry := range entries {
+			job := entry.job()
+			q.track(&job)
+			select {
+			case q.jobs <- job:
+				asyncEnqueued.Inc()
+				walRecovered.Inc()
+			case <-ctx.Done():
+				q.untrack(job)
+				return
+			}
+		}
+	})
+	return nil
+}
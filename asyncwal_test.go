@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+func TestWebhookHandler_AsyncWAL(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	dedupStore = newMemoryDedupStore()
+	directory, err := ioutil.TempDir("", "wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	wal, _ := newAsyncWAL(directory)
+
+	// No worker consumes the queue, as if the webhook stopped before processing the alert group
+	alertGroupQueue = newAsyncQueue(1)
+	alertGroupQueue.wal = wal
+	defer func() { alertGroupQueue = nil }()
+	if rr := postAlertGroup(t, "test/alertmanager_firing.json"); rr.Code != http.StatusAccepted {
+		t.Fatalf("Wrong status code: got %v, want %v", rr.Code, http.StatusAccepted)
+	}
+	if rr := postAlertGroup(t, "test/alertmanager_firing.json"); rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Wrong status code: got %v, want %v", rr.Code, http.StatusServiceUnavailable)
+	}
+	if abandoned := alertGroupQueue.abandon(); abandoned != 1 {
+		t.Errorf("The queued alert group should be abandoned: %d", abandoned)
+	}
+	entries, err := wal.pending()
+	if err != nil || len(entries) != 1 || len(entries[0].RequestID) == 0 || len(entries[0].Data.Alerts) == 0 {
+		t.Fatalf("The abandoned alert group should be kept in the write-ahead log, and the rejected one removed: %v, %+v", err, entries)
+	}
+
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{"sys_id": "1", "number": "INC1"}, nil)
+	tasks := newTaskGroup()
+	alertGroupQueue = newAsyncQueue(1)
+	alertGroupQueue.wal = wal
+	alertGroupQueue.start(tasks, 1)
+	if err := alertGroupQueue.recover(tasks); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for entries, _ := wal.pending(); len(entries) > 0 && time.Now().Before(deadline); entries, _ = wal.pending() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if running := tasks.Stop(time.Second); len(running) > 0 {
+		t.Fatalf("Workers still running: %v", running)
+	}
+	snClientMock.AssertNumberOfCalls(t, "CreateIncident", 1)
+	if entries, _ := wal.pending(); len(entries) != 0 {
+		t.Errorf("The processed alert group should be removed from the write-ahead log: %+v", entries)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	attachmentFormatJSON = "json"
+	attachmentFormatText = "text"
+
+	defaultAttachmentTextTemplate = `Status: {{ .Status }}
+Receiver: {{ .Receiver }}
+Group labels: {{ range .GroupLabels.SortedPairs }}{{ .Name }}="{{ .Value }}" {{ end }}
+External URL: {{ .ExternalURL }}
+{{ range .Alerts }}
+Alert [{{ .Status }}] since {{ .StartsAt }}
+Fingerprint: {{ .Fingerprint }}
+Labels:
+{{ range .Labels.SortedPairs }}  {{ .Name }}: {{ .Value }}
+{{ end }}Annotations:
+{{ range .Annotations.SortedPairs }}  {{ .Name }}: {{ .Value }}
+{{ end }}Source: {{ .GeneratorURL }}
+{{ end }}`
+)
+
+var attachmentErrors = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Name: "webhook_attachment_errors_total",
+		Help: "Total number of alert group attachments which could not be rendered or uploaded to the created incidents.",
+	},
+)
+
+// AttachmentConfig - Attachment of the alert group to the created incidents, with the ServiceNow Attachment API
+type AttachmentConfig struct {
+	Format   string `yaml:"format"`
+	FileName string `yaml:"file_name"`
+	Template string `yaml:"template"`
+}
+
+func (c AttachmentConfig) enabled() bool {
+	return len(c.Format) > 0
+}
+
+func (c AttachmentConfig) validate(errs *strings.Builder) {
+	switch c.Format {
+	case "", attachmentFormatJSON:
+		if len(c.Template) > 0 {
+			errs.WriteString("attachment.template only applies to the text format\n")
+		}
+	case attachmentFormatText:
+		if _, err := newTextTemplate("attachment").Parse(c.template()); err != nil {
+			errs.WriteString(fmt.Sprintf("attachment.template is invalid: %v\n", err))
+		}
+	default:
+		errs.WriteString(fmt.Sprintf("attachment.format %q must be %q or %q\n", c.Format, attachmentFormatJSON, attachmentFormatText))
+	}
+	if strings.ContainsAny(c.FileName, "/\\") {
+		errs.WriteString("attachment.file_name must not contain a path\n")
+	}
+}
+
+func (c AttachmentConfig) template() string {
+	if len(c.Template) == 0 {
+		return defaultAttachmentTextTemplate
+	}
+	return c.Template
+}
+
+func (c AttachmentConfig) fileName() string {
+	if len(c.FileName) > 0 {
+		return c.FileName
+	}
+	if c.Format == attachmentFormatText {
+		return "alertmanager_notification.txt"
+	}
+	return "alertmanager_notification.json"
+}
+
+// renderAttachment returns the content of the attachment of the alert group, and its media type: the Alertmanager
+// notification as JSON, or its summary rendered by the text template
+func renderAttachment(ctx context.Context, c AttachmentConfig, data template.Data) ([]byte, string, error) {
+	config := configFrom(ctx)
+	if c.Format == attachmentFormatText {
+		text, err := applyTemplate("attachment", c.template(), newTemplateContext(config.InstanceList, data))
+		return []byte(text), "text/plain", err
+	}
+	content, err := json.MarshalIndent(data, "", "  ")
+	return content, "application/json", err
+}
+
+// attachAlertGroup attaches the alert group to the incident created for it, when configured.
+// A failed attachment is only logged, the incident being created nonetheless.
+func attachAlertGroup(ctx context.Context, tableName string, incident Incident, data template.Data) {
+	config := configFrom(ctx)
+	c := config.Attachment
+	sysID, _ := incident["sys_id"].(string)
+	if !c.enabled() || (len(sysID) == 0 && !isDryRun(ctx)) {
+		return
+	}
+
+	content, contentType, err := renderAttachment(ctx, c, data)
+	if err == nil {
+		err = serviceNowFrom(ctx).AttachFile(ctx, tableName, sysID, c.fileName(), contentType, content)
+	}
+	if err != nil {
+		attachmentErrors.Inc()
+		loggerFrom(ctx).Errorf("Error attaching the alert group to incident %v: %v", incident["number"], err)
+	}
+}
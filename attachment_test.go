@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestWebhook_Attachment(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Attachment = AttachmentConfig{Format: attachmentFormatJSON}
+	dedupStore = newMemoryDedupStore()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{"sys_id": "1", "number": "INC1"}, nil)
+	snClientMock.On("AttachFile", "incident", "1", "alertmanager_notification.json", "application/json", mock.MatchedBy(func(content []byte) bool {
+		var data template.Data
+		return json.Unmarshal(content, &data) == nil && len(data.Alerts) == 2 && len(data.GroupLabels) > 0
+	})).Return(nil)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(webhook).ServeHTTP(rr, httptest.NewRequest("POST", "/webhook", strings.NewReader(twoAlertsNotification)))
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Wrong status code: got %v, want %v", rr.Code, http.StatusOK)
+	}
+	snClientMock.AssertExpectations(t)
+}
+
+func TestAttachAlertGroup_Text(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Attachment = AttachmentConfig{Format: attachmentFormatText, FileName: "alerts.txt", Template: "{{ .AlertCount }} alert(s): {{ .CommonLabels.alertname }}"}
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("AttachFile", "incident", "1", "alerts.txt", "text/plain", []byte("1 alert(s): HighLatency")).Return(nil)
+
+	data := template.Data{CommonLabels: template.KV{"alertname": "HighLatency"}, Alerts: template.Alerts{{Status: "firing"}}}
+	attachAlertGroup(context.Background(), "incident", Incident{"sys_id": "1", "number": "INC1"}, data)
+	snClientMock.AssertExpectations(t)
+}
+
+func TestAttachAlertGroup_DefaultTemplate(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	data := template.Data{
+		Status:      "firing",
+		GroupLabels: template.KV{"alertname": "HighLatency"},
+		Alerts:      template.Alerts{{Status: "firing", Labels: template.KV{"instance": "web-1"}, Annotations: template.KV{"summary": "Latency is high"}}},
+	}
+	content, contentType, err := renderAttachment(context.Background(), AttachmentConfig{Format: attachmentFormatText}, data)
+	if err != nil || contentType != "text/plain" {
+		t.Fatalf("Unexpected rendering: %v, %v", contentType, err)
+	}
+	for _, want := range []string{`alertname="HighLatency"`, "instance: web-1", "summary: Latency is high"} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("Missing %q in the attachment: %s", want, content)
+		}
+	}
+}
+
+func TestAttachAlertGroup_Error(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Attachment = AttachmentConfig{Format: attachmentFormatJSON}
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("AttachFile", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(errors.New("Error"))
+
+	before := testutil.ToFloat64(attachmentErrors)
+	attachAlertGroup(context.Background(), "incident", Incident{"sys_id": "1", "number": "INC1"}, template.Data{})
+	attachAlertGroup(context.Background(), "incident", nil, template.Data{})
+	if got := testutil.ToFloat64(attachmentErrors) - before; got != 1 {
+		t.Errorf("The failed attachment should be counted once: got %v", got)
+	}
+}
+
+func TestAttachmentConfig_Validate(t *testing.T) {
+	var errs strings.Builder
+	AttachmentConfig{Format: "xml"}.validate(&errs)
+	AttachmentConfig{Format: attachmentFormatText, Template: "{{ .Status"}.validate(&errs)
+	AttachmentConfig{Format: attachmentFormatJSON, Template: "{{ .Status }}", FileName: "../alerts.json"}.validate(&errs)
+	for _, want := range []string{`attachment.format "xml"`, "attachment.template is invalid", "only applies to the text format", "attachment.file_name"} {
+		if !strings.Contains(errs.String(), want) {
+			t.Errorf("Missing validation error %q: %q", want, errs.String())
+		}
+	}
+}
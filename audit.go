@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	auditStdout          = "stdout"
+	auditDeleted         = "deleted"
+	auditAttached        = "attached"
+	auditBackupTimestamp = "20060102T150405.000000000"
+)
+
+var (
+	// auditLog records the ServiceNow mutations, nil when the audit log is disabled
+	auditLog *auditWriter
+
+	auditErrors = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "webhook_audit_errors_total",
+			Help: "Total number of ServiceNow mutations that could not be written to the audit log.",
+		},
+	)
+)
+
+// AuditConfig - Append-only JSON log of the records created, updated and deleted in ServiceNow
+type AuditConfig struct {
+	Output     string        `yaml:"output"`
+	MaxSizeMB  int           `yaml:"max_size_mb"`
+	MaxBackups int           `yaml:"max_backups"`
+	MaxAge     time.Duration `yaml:"max_age"`
+}
+
+func (c AuditConfig) validate(errs *strings.Builder) {
+	if c.MaxSizeMB < 0 {
+		errs.WriteString("audit.max_size_mb must not be negative\n")
+	}
+	if c.MaxBackups < 0 {
+		errs.WriteString("audit.max_backups must not be negative\n")
+	}
+	if c.MaxAge < 0 {
+		errs.WriteString("audit.max_age must not be negative\n")
+	}
+	if c.Output == auditStdout && (c.MaxSizeMB > 0 || c.MaxBackups > 0 || c.MaxAge > 0) {
+		errs.WriteString("audit.max_size_mb, audit.max_backups and audit.max_age only apply to an audit file\n")
+	}
+}
+
+// auditCaller identifies what triggered the ServiceNow mutations
+type auditCaller struct {
+	Source     string `json:"source"`
+	RequestID  string `json:"request_id,omitempty"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+	Receiver   string `json:"receiver,omitempty"`
+	GroupKey   string `json:"group_key,omitempty"`
+	// AlertmanagerGroupKey is the groupKey of the Alertmanager webhook message
+	AlertmanagerGroupKey string `json:"alertmanager_group_key,omitempty"`
+}
+
+// auditEntry is a line of the audit log
+type auditEntry struct {
+	Time      time.Time   `json:"time"`
+	Duration  float64     `json:"duration_seconds"`
+	Operation string      `json:"operation"`
+	Instance  string      `json:"instance,omitempty"`
+	Table     string      `json:"table"`
+	SysID     string      `json:"sys_id,omitempty"`
+	Caller    auditCaller `json:"caller"`
+	Payload   Incident    `json:"payload,omitempty"`
+	Response  Incident    `json:"response,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+type auditCallerContextKey struct{}
+
+type auditOperationContextKey struct{}
+
+// withAuditCaller returns a context recording the caller in the audit log entries of its mutations
+func withAuditCaller(ctx context.Context, caller auditCaller) context.Context {
+	return context.WithValue(ctx, auditCallerContextKey{}, caller)
+}
+
+func auditCallerFrom(ctx context.Context) auditCaller {
+	caller, _ := ctx.Value(auditCallerContextKey{}).(auditCaller)
+	return caller
+}
+
+// withAuditOperation returns a context recording its updates as the operation in the audit log, e.g. as resolutions
+func withAuditOperation(ctx context.Context, operation string) context.Context {
+	return context.WithValue(ctx, auditOperationContextKey{}, operation)
+}
+
+func auditOperationFrom(ctx context.Context, operation string) string {
+	if o, ok := ctx.Value(auditOperationContextKey{}).(string); ok {
+		return o
+	}
+	return operation
+}
+
+// auditClient writes an audit log entry for each record created, updated or deleted, whether it succeeded or not.
+// The reads are not audited.
+type auditClient struct {
+	ServiceNow
+	log *auditWriter
+}
+
+func (c auditClient) record(ctx context.Context, start time.Time, operation string, tableName string, sysID string, payload Incident, response Incident, err error) {
+	entry := auditEntry{
+		Time:      start.UTC(),
+		Duration:  time.Since(start).Seconds(),
+		Operation: operation,
+		Instance:  instanceFrom(ctx),
+		Table:     tableName,
+		SysID:     sysID,
+		Caller:    auditCallerFrom(ctx),
+		Payload:   payload,
+		Response:  response,
+	}
+	if len(entry.SysID) == 0 && response != nil {
+		entry.SysID = response.GetSysID()
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if err := c.log.write(entry); err != nil {
+		auditErrors.Inc()
+		loggerFrom(ctx).Errorf("Error writing the audit log: %v", err)
+	}
+}
+
+// CreateIncident creates the record and audits it
+func (c auditClient) CreateIncident(ctx context.Context, tableName string, incidentParam Incident) (Incident, error) {
+	start := time.Now()
+	incident, err := c.ServiceNow.CreateIncident(ctx, tableName, incidentParam)
+	c.record(ctx, start, incidentCreated, tableName, "", incidentParam, incident, err)
+	return incident, err
+}
+
+// UpdateIncident updates the record and audits it
+func (c auditClient) UpdateIncident(ctx context.Context, tableName string, incidentParam Incident, sysID string) (Incident, error) {
+	start := time.Now()
+	incident, err := c.ServiceNow.UpdateIncident(ctx, tableName, incidentParam, sysID)
+	c.record(ctx, start, auditOperationFrom(ctx, incidentUpdated), tableName, sysID, incidentParam, incident, err)
+	return incident, err
+}
+
+// PatchIncident patches the record and audits it
+func (c auditClient) PatchIncident(ctx context.Context, tableName string, incidentParam Incident, sysID string) (Incident, error) {
+	start := time.Now()
+	incident, err := c.ServiceNow.PatchIncident(ctx, tableName, incidentParam, sysID)
+	c.record(ctx, start, auditOperationFrom(ctx, incidentUpdated), tableName, sysID, incidentParam, incident, err)
+	return incident, err
+}
+
+// DeleteIncident deletes the record and audits it
+func (c auditClient) DeleteIncident(ctx context.Context, tableName string, sysID string) error {
+	start := time.Now()
+	err := c.ServiceNow.DeleteIncident(ctx, tableName, sysID)
+	c.record(ctx, start, auditDeleted, tableName, sysID, nil, nil, err)
+	return err
+}
+
+// AttachFile attaches the file to the record and audits it, without its content
+func (c auditClient) AttachFile(ctx context.Context, tableName string, sysID string, fileName string, contentType string, content []byte) error {
+	start := time.Now()
+	err := c.ServiceNow.AttachFile(ctx, tableName, sysID, fileName, contentType, content)
+	payload := Incident{"file_name": fileName, "content_type": contentType, "size_bytes": len(content)}
+	c.record(ctx, start, auditAttached, tableName, sysID, payload, nil, err)
+	return err
+}
+
+// auditWriter appends the audit log entries as JSON lines to stdout or to a file.
+// The file is rotated once larger than the maximum size, the rotated files being pruned by number and by age.
+type auditWriter struct {
+	mutex  sync.Mutex
+	config AuditConfig
+	out    io.Writer
+	file   *os.File
+	size   int64
+	now    func() time.Time
+}
+
+func newAuditWriter(c AuditConfig) (*auditWriter, error) {
+	w := &auditWriter{config: c, now: time.Now}
+	if c.Output == auditStdout {
+		w.out = os.Stdout
+		return w, nil
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *auditWriter) open() error {
+	file, err := os.OpenFile(w.config.Output, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("error opening the audit log: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error opening the audit log: %v", err)
+	}
+	w.file, w.out, w.size = file, file, info.Size()
+	return nil
+}
+
+func (w *auditWriter) write(entry auditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.file != nil && w.config.MaxSizeMB > 0 && w.size > 0 && w.size+int64(len(line)) > int64(w.config.MaxSizeMB)<<20 {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := w.out.Write(line)
+	w.size += int64(n)
+	return err
+}
+
+// rotate renames the audit file with its rotation time, opens a new one and prunes the rotated files.
+// When the file cannot be renamed, the entries are still appended to it.
+func (w *auditWriter) rotate() error {
+	w.file.Close()
+	backup := w.config.Output + "." + w.now().UTC().Format(auditBackupTimestamp)
+	renameErr := os.Rename(w.config.Output, backup)
+	if err := w.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		baseLogger.Warnf("Error rotating the audit log: %v", renameErr)
+		return nil
+	}
+	w.prune()
+	return nil
+}
+
+// prune removes the rotated files beyond the maximum number of backups, or older than the maximum age
+func (w *auditWriter) prune() {
+	backups, err := filepath.Glob(w.config.Output + ".*")
+	if err != nil {
+		return
+	}
+	// The rotation timestamps sort chronologically, newest first
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	i := 0
+	for _, backup := range backups {
+		rotated, err := time.Parse(auditBackupTimestamp, strings.TrimPrefix(backup, w.config.Output+"."))
+		if err != nil {
+			continue
+		}
+		i++
+		expired := w.config.MaxAge > 0 && w.now().Sub(rotated) > w.config.MaxAge
+		if expired || (w.config.MaxBackups > 0 && i > w.config.MaxBackups) {
+			if err := os.Remove(backup); err != nil {
+				baseLogger.Warnf("Error removing the rotated audit log %s: %v", backup, err)
+			}
+		}
+	}
+}
+
+func (w *auditWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	return w.file.Close()
+}
+
+// loadAuditLog opens the audit log, when configured
+func loadAuditLog(config Config) error {
+	auditLog = nil
+	if len(config.Audit.Output) == 0 {
+		return nil
+	}
+	w, err := newAuditWriter(config.Audit)
+	if err != nil {
+		return err
+	}
+	auditLog = w
+	baseLogger.Infof("Auditing the ServiceNow mutations to %s", config.Audit.Output)
+	return nil
+}
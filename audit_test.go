@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+// newTestAuditLog enables the audit log to a file of a temporary directory
+func newTestAuditLog(t *testing.T, c AuditConfig) (*auditWriter, func()) {
+	directory, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Output = filepath.Join(directory, "audit.log")
+	w, err := newAuditWriter(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditLog = w
+	return w, func() {
+		auditLog = nil
+		w.Close()
+		os.RemoveAll(directory)
+	}
+}
+
+func readAuditEntries(t *testing.T, file string) []auditEntry {
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []auditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Invalid audit log line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestWebhook_Audit(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	w, cleanup := newTestAuditLog(t, AuditConfig{})
+	defer cleanup()
+	dedupStore = newMemoryDedupStore()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{"sys_id": "1", "number": "INC1"}, nil)
+
+	req := httptest.NewRequest("POST", "/webhook", strings.NewReader(twoAlertsNotification))
+	req.Header.Set(requestIDHeader, "request-1")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(webhook).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: got %v, want %v", rr.Code, http.StatusOK)
+	}
+
+	entries := readAuditEntries(t, w.config.Output)
+	if len(entries) != 1 {
+		t.Fatalf("Only the creation should be audited: %+v", entries)
+	}
+	entry := entries[0]
+	if entry.Operation != incidentCreated || entry.Table != "incident" || entry.SysID != "1" || len(entry.Error) > 0 {
+		t.Errorf("Unexpected audit entry: %+v", entry)
+	}
+	if entry.Caller.Source != "webhook" || entry.Caller.RequestID != "request-1" || len(entry.Caller.GroupKey) == 0 {
+		t.Errorf("Unexpected audit caller: %+v", entry.Caller)
+	}
+	if entry.Payload["short_description"] == nil || entry.Response["number"] != "INC1" {
+		t.Errorf("The payload and the response should be audited: %+v", entry)
+	}
+	if entry.Time.IsZero() {
+		t.Errorf("The audit entry should be timestamped")
+	}
+}
+
+func TestAuditClient_Operations(t *testing.T) {
+	w, cleanup := newTestAuditLog(t, AuditConfig{})
+	defer cleanup()
+	snClientMock := new(MockedSnClient)
+	snClientMock.On("UpdateIncident", mock.Anything, mock.Anything, mock.Anything).Return(Incident{}, errors.New("Error"))
+	snClientMock.On("DeleteIncident", mock.Anything, mock.Anything).Return(nil)
+	client := auditClient{snClientMock, w}
+
+	ctx := withAuditCaller(context.Background(), auditCaller{Source: "test"})
+	client.UpdateIncident(withAuditOperation(ctx, incidentResolved), "incident", Incident{"state": "6"}, "1")
+	client.DeleteIncident(ctx, "incident", "2")
+
+	entries := readAuditEntries(t, w.config.Output)
+	if len(entries) != 2 {
+		t.Fatalf("Unexpected audit entries: %+v", entries)
+	}
+	if entries[0].Operation != incidentResolved || entries[0].SysID != "1" || entries[0].Error != "Error" || entries[0].Caller.Source != "test" {
+		t.Errorf("Unexpected resolution audit entry: %+v", entries[0])
+	}
+	if entries[1].Operation != auditDeleted || entries[1].SysID != "2" || len(entries[1].Error) > 0 {
+		t.Errorf("Unexpected deletion audit entry: %+v", entries[1])
+	}
+}
+
+func TestServiceNowFrom_Audit(t *testing.T) {
+	_, cleanup := newTestAuditLog(t, AuditConfig{})
+	defer cleanup()
+	if _, ok := serviceNowFrom(context.Background()).(auditClient); !ok {
+		t.Errorf("The ServiceNow client should be audited")
+	}
+	if _, ok := serviceNowFrom(withDryRun(context.Background())).(dryRunClient); !ok {
+		t.Errorf("The dry runs should not be audited")
+	}
+}
+
+func TestAuditWriter_Rotate(t *testing.T) {
+	w, cleanup := newTestAuditLog(t, AuditConfig{MaxSizeMB: 1, MaxBackups: 1, MaxAge: time.Hour})
+	defer cleanup()
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	w.now = func() time.Time { return now }
+
+	expired := w.config.Output + "." + now.Add(-2*time.Hour).Format(auditBackupTimestamp)
+	if err := ioutil.WriteFile(expired, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		w.size = 1 << 20
+		now = now.Add(time.Second)
+		if err := w.write(auditEntry{Operation: incidentCreated}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backups, _ := filepath.Glob(w.config.Output + ".*")
+	if len(backups) != 1 || !strings.HasSuffix(backups[0], now.Format(auditBackupTimestamp)) {
+		t.Errorf("Only the last rotated file should be kept: %v", backups)
+	}
+	if entries := readAuditEntries(t, w.config.Output); len(entries) != 1 {
+		t.Errorf("The entry should be written to the new file: %+v", entries)
+	}
+}
+
+func TestAuditConfig_Validate(t *testing.T) {
+	var errs strings.Builder
+	AuditConfig{Output: auditStdout, MaxSizeMB: 10}.validate(&errs)
+	AuditConfig{Output: "audit.log", MaxBackups: -1}.validate(&errs)
+	for _, want := range []string{"only apply to an audit file", "audit.max_backups"} {
+		if !strings.Contains(errs.String(), want) {
+			t.Errorf("Missing %s validation error: %q", want, errs.String())
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// BasicAuthConfig - Basic authentication credentials
+type BasicAuthConfig struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+func (c WebhookConfig) validateAuth(errs *strings.Builder) {
+	if len(c.BasicAuth.Username) > 0 && len(c.BasicAuth.Password) == 0 {
+		errs.WriteString("webhook.basic_auth.password is missing\n")
+	}
+}
+
+// authEnabled returns whether the requests on /webhook must be authenticated
+func (c WebhookConfig) authEnabled() bool {
+	return len(c.BasicAuth.Username) > 0 || len(c.BearerToken) > 0
+}
+
+// authenticate returns whether the request carries the configured basic auth credentials or bearer token
+func (c WebhookConfig) authenticate(r *http.Request) bool {
+	if !c.authEnabled() {
+		return true
+	}
+	if len(c.BasicAuth.Username) > 0 {
+		if username, password, ok := r.BasicAuth(); ok && secureCompare(username, c.BasicAuth.Username) && secureCompare(password, c.BasicAuth.Password) {
+			return true
+		}
+	}
+	if len(c.BearerToken) > 0 {
+		authorization := r.Header.Get("Authorization")
+		if strings.HasPrefix(authorization, "Bearer ") && secureCompare(strings.TrimPrefix(authorization, "Bearer "), c.BearerToken) {
+			return true
+		}
+	}
+	return false
+}
+
+func secureCompare(given string, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebhookConfig_Authenticate(t *testing.T) {
+	basicAuth := WebhookConfig{BasicAuth: BasicAuthConfig{Username: "alertmanager", Password: "secret"}}
+	bearer := WebhookConfig{BearerToken: "token"}
+	both := WebhookConfig{BasicAuth: basicAuth.BasicAuth, BearerToken: "token"}
+	tests := []struct {
+		name          string
+		config        WebhookConfig
+		authorization func(r *http.Request)
+		want          bool
+	}{
+		{name: "disabled", config: WebhookConfig{}, authorization: func(r *http.Request) {}, want: true},
+		{name: "basic_auth", config: basicAuth, authorization: func(r *http.Request) { r.SetBasicAuth("alertmanager", "secret") }, want: true},
+		{name: "basic_auth_wrong_password", config: basicAuth, authorization: func(r *http.Request) { r.SetBasicAuth("alertmanager", "wrong") }, want: false},
+		{name: "basic_auth_missing", config: basicAuth, authorization: func(r *http.Request) {}, want: false},
+		{name: "bearer", config: bearer, authorization: func(r *http.Request) { r.Header.Set("Authorization", "Bearer token") }, want: true},
+		{name: "bearer_wrong", config: bearer, authorization: func(r *http.Request) { r.Header.Set("Authorization", "Bearer other") }, want: false},
+		{name: "bearer_as_basic_auth", config: bearer, authorization: func(r *http.Request) { r.SetBasicAuth("token", "token") }, want: false},
+		{name: "both_basic_auth", config: both, authorization: func(r *http.Request) { r.SetBasicAuth("alertmanager", "secret") }, want: true},
+		{name: "both_bearer", config: both, authorization: func(r *http.Request) { r.Header.Set("Authorization", "Bearer token") }, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/webhook", nil)
+			tt.authorization(req)
+			if got := tt.config.authenticate(req); got != tt.want {
+				t.Errorf("Unexpected authentication: got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookHandler_Unauthorized(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Webhook.BearerToken = "token"
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+
+	req := httptest.NewRequest("POST", "/webhook", strings.NewReader("{}"))
+	req.Header.Set("Authorization", "Bearer other")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(webhook).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Wrong status code: got %v, want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if len(rr.Header().Get("WWW-Authenticate")) == 0 {
+		t.Errorf("WWW-Authenticate header should be set")
+	}
+	if len(snClientMock.Calls) > 0 {
+		t.Errorf("ServiceNow should not be called on unauthorized requests")
+	}
+}
+
+func TestWebhookConfig_ValidateAuth(t *testing.T) {
+	var errs strings.Builder
+	WebhookConfig{BasicAuth: BasicAuthConfig{Username: "alertmanager"}}.validate(&errs)
+	if !strings.Contains(errs.String(), "webhook.basic_auth.password") {
+		t.Errorf("Unexpected validation errors: %q", errs.String())
+	}
+}
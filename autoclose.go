@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	autoCloseInterval = 5 * time.Minute
+	// autoClosePageSize is the number of resolved incidents looked up per request
+	autoClosePageSize = 100
+)
+
+// AutoCloseConfig - Closure of the incidents resolved by the webhook once their alert group has not fired again for
+// a grace period, so that the service desk does not have to close them
+type AutoCloseConfig struct {
+	After time.Duration `yaml:"after"`
+	State string        `yaml:"state"`
+}
+
+func (c AutoCloseConfig) validate(resolveState string, errs *strings.Builder) {
+	if c.After != 0 && c.After < time.Minute {
+		errs.WriteString("resolve.auto_close.after must be at least 1m\n")
+	}
+	if c.After > 0 && len(c.State) == 0 {
+		errs.WriteString("resolve.auto_close.state is missing\n")
+	}
+	if c.After > 0 && len(resolveState) == 0 {
+		errs.WriteString("resolve.state is missing, it is required by resolve.auto_close\n")
+	}
+}
+
+// resolvedIncidentsQuery returns the encoded query of the incidents of the webhook (having an alert group key) in the
+// resolved state, last updated by the user of the ServiceNow instance, i.e. resolved by the webhook, and not updated
+// since the grace period
+func resolvedIncidentsQuery(ctx context.Context, tableName string, workflow WorkflowConfig, userName string) string {
+	query := fmt.Sprintf("state=%s^%sISNOTEMPTY^sys_updated_on<javascript:gs.minutesAgoStart(%d)",
+		workflow.Resolve.State, groupKeyField(ctx, tableName), int64(workflow.Resolve.AutoClose.After/time.Minute))
+	if len(userName) > 0 {
+		query += "^sys_updated_by=" + userName
+	}
+	return query
+}
+
+// closeResolvedIncidents closes the incidents of the tables with an auto_close grace period which are still resolved
+// once the grace period has elapsed, in the default ServiceNow instance and in the additional ones. An incident whose
+// alert group fired again has either been reopened or updated meanwhile, and is not closed.
+func closeResolvedIncidents(ctx context.Context) {
+	config := configFrom(ctx)
+	closeInstanceResolvedIncidents(ctx)
+	for _, name := range config.instanceNames() {
+		closeInstanceResolvedIncidents(withInstance(ctx, name))
+	}
+}
+
+func closeInstanceResolvedIncidents(ctx context.Context) {
+	config := configFrom(ctx)
+	for _, tableName := range config.instanceTableNames(instanceFrom(ctx)) {
+		workflow := config.tableWorkflow(tableName)
+		c := workflow.Resolve.AutoClose
+		if c.After <= 0 {
+			continue
+		}
+		incidents, err := lookupResolvedIncidents(ctx, tableName, resolvedIncidentsQuery(ctx, tableName, workflow, instanceConfigFrom(ctx).UserName))
+		if err != nil {
+			serviceNowError.Inc()
+			loggerFrom(ctx).Errorf("Error looking up the resolved incidents of table %s: %v", tableName, err)
+			continue
+		}
+		for _, incident := range incidents {
+			closeIncident(ctx, tableName, incident, c, time.Now())
+		}
+	}
+}
+
+// lookupResolvedIncidents looks up the incidents of the query page by page. All the pages are looked up before closing any
+// incident, which would otherwise shift the next pages.
+func lookupResolvedIncidents(ctx context.Context, tableName string, query string) ([]Incident, error) {
+	var incidents []Incident
+	for offset := 0; ; offset += autoClosePageSize {
+		page, err := serviceNowFrom(ctx).GetIncidents(ctx, tableName, map[string]string{
+			"sysparm_query":  query,
+			"sysparm_fields": "sys_id,number,state",
+			"sysparm_limit":  strconv.Itoa(autoClosePageSize),
+			"sysparm_offset": strconv.Itoa(offset),
+		})
+		if err != nil {
+			return nil, err
+		}
+		incidents = append(incidents, page...)
+		if len(page) < autoClosePageSize {
+			return incidents, nil
+		}
+	}
+}
+
+func closeIncident(ctx context.Context, tableName string, incident Incident, c AutoCloseConfig, now time.Time) {
+	update := Incident{
+		"state":        c.State,
+		workNotesField: fmt.Sprintf("%s - Closed as its alert group did not fire again within %v of its resolution.", now.UTC().Format(workNoteTimeLayout), c.After),
+	}
+	state, err := transitionIncident(ctx, tableName, incident, update)
+	if err == nil {
+		_, err = serviceNowFrom(ctx).UpdateIncident(ctx, tableName, update, incident.GetSysID())
+		countIncidentOperation(ctx, tableName, incidentUpdated, err)
+		err = intermediateStateError(err, incident, state)
+	}
+	if err != nil {
+		serviceNowError.Inc()
+		loggerFrom(ctx).Errorf("Error closing resolved incident %s: %v", incident.GetNumber(), err)
+		return
+	}
+	loggerFrom(ctx).Infof("Resolved incident %s closed, with state %s", incident.GetNumber(), c.State)
+}
+
+// startAutoClose starts the background task closing the resolved incidents, checking the configuration on each run
+// so that auto_close can be enabled by a reload
+func startAutoClose() {
+	backgroundTasks.Go("auto close", func(ctx context.Context) {
+		ctx = withAuditCaller(ctx, auditCaller{Source: "auto close"})
+		runEvery(ctx, autoCloseInterval, func() {
+			// The incidents are closed by the leader replica only
+			if !leader.isLeader() {
+				return
+			}
+			closeResolvedIncidents(withConfigSnapshot(ctx))
+		})
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCloseResolvedIncidents(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Workflow.Resolve = ResolveConfig{State: "6", AutoClose: AutoCloseConfig{After: 24 * time.Hour, State: "7"}}
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "incident", map[string]string{
+		"sysparm_query":  "state=6^CHANGE_MEISNOTEMPTY^sys_updated_on<javascript:gs.minutesAgoStart(1440)^sys_updated_by=CHANGE_ME",
+		"sysparm_fields": "sys_id,number,state",
+		"sysparm_limit":  "100",
+		"sysparm_offset": "0",
+	}).Return([]Incident{{"sys_id": "1", "number": "INC1", "state": "6"}}, nil)
+	snClientMock.On("UpdateIncident", "incident", mock.MatchedBy(func(incident Incident) bool {
+		notes, _ := incident[workNotesField].(string)
+		return incident["state"] == "7" && strings.Contains(notes, "did not fire again within 24h0m0s")
+	}), "1").Return(Incident{}, nil)
+
+	closeResolvedIncidents(context.Background())
+	snClientMock.AssertExpectations(t)
+}
+
+func TestCloseResolvedIncidents_PagesAndInstances(t *testing.T) {
+	defaultMock, retailMock := loadInstancesTestConfig()
+	defer useServiceNowInstances(nil)
+	currentConfig().Workflow.Resolve = ResolveConfig{State: "6", AutoClose: AutoCloseConfig{After: time.Hour, State: "7"}}
+	page := func(userName string, offset string) map[string]string {
+		return map[string]string{
+			"sysparm_query":  "state=6^CHANGE_MEISNOTEMPTY^sys_updated_on<javascript:gs.minutesAgoStart(60)^sys_updated_by=" + userName,
+			"sysparm_fields": "sys_id,number,state",
+			"sysparm_limit":  "100",
+			"sysparm_offset": offset,
+		}
+	}
+	fullPage := make([]Incident, autoClosePageSize)
+	for i := range fullPage {
+		fullPage[i] = Incident{"sys_id": fmt.Sprintf("p%d", i), "number": fmt.Sprintf("INC%d", i), "state": "6"}
+	}
+	defaultMock.ExpectedCalls = nil
+	defaultMock.On("GetIncidents", "incident", page("CHANGE_ME", "0")).Return(fullPage, nil)
+	defaultMock.On("GetIncidents", "incident", page("CHANGE_ME", "100")).Return([]Incident{{"sys_id": "last", "number": "INC100", "state": "6"}}, nil)
+	defaultMock.On("UpdateIncident", "incident", mock.Anything, mock.Anything).Return(Incident{}, nil)
+	retailMock.ExpectedCalls = nil
+	retailMock.On("GetIncidents", "incident", page("retail-user", "0")).Return([]Incident{{"sys_id": "r1", "number": "INC1", "state": "6"}}, nil)
+	retailMock.On("UpdateIncident", "incident", mock.Anything, "r1").Return(Incident{}, nil)
+
+	closeResolvedIncidents(context.Background())
+	defaultMock.AssertNumberOfCalls(t, "UpdateIncident", autoClosePageSize+1)
+	retailMock.AssertNumberOfCalls(t, "UpdateIncident", 1)
+}
+
+func TestCloseResolvedIncidents_Disabled(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+
+	closeResolvedIncidents(context.Background())
+	if len(snClientMock.Calls) != 0 {
+		t.Errorf("No incident should be looked up without auto_close: %v", snClientMock.Calls)
+	}
+}
+
+func TestAutoCloseConfig_Validate(t *testing.T) {
+	var errs strings.Builder
+	ResolveConfig{AutoClose: AutoCloseConfig{After: time.Second}}.validate(&errs)
+	for _, want := range []string{"resolve.auto_close.after must be at least 1m", "resolve.auto_close.state is missing", "resolve.state is missing, it is required by resolve.auto_close"} {
+		if !strings.Contains(errs.String(), want) {
+			t.Errorf("Missing validation error %q: %q", want, errs.String())
+		}
+	}
+}
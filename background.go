@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+const sweepInterval = time.Minute
+
+var backgroundTasks = newTaskGroup()
+
+// taskGroup manages the lifecycle of the background tasks, all stopped by cancelling their common context
+type taskGroup struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	mutex   sync.Mutex
+	running map[string]int
+}
+
+func newTaskGroup() *taskGroup {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &taskGroup{ctx: ctx, cancel: cancel, running: map[string]int{}}
+}
+
+// Go starts the named background task. The task must return once its context is done.
+func (g *taskGroup) Go(name string, task func(ctx context.Context)) {
+	g.mutex.Lock()
+	g.running[name]++
+	g.mutex.Unlock()
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		defer func() {
+			g.mutex.Lock()
+			g.running[name]--
+			if g.running[name] == 0 {
+				delete(g.running, name)
+			}
+			g.mutex.Unlock()
+		}()
+
+		baseLogger.Debugf("Background task %s started", name)
+		task(g.ctx)
+		baseLogger.Debugf("Background task %s stopped", name)
+	}()
+}
+
+// Stop cancels the background tasks and waits for them to return within the grace period.
+// The names of the tasks still running after the grace period are returned.
+func (g *taskGroup) Stop(gracePeriod time.Duration) []string {
+	g.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(gracePeriod):
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	var running []string
+	for name := range g.running {
+		running = append(running, name)
+	}
+	sort.Strings(running)
+	return running
+}
+
+// runEvery calls fn at every interval until the context is done
+func runEvery(ctx context.Context, interval time.Duration, fn func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fn()
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTaskGroup_Stop(t *testing.T) {
+	tasks := newTaskGroup()
+	var stopped int32
+	for _, name := range []string{"poller", "canary", "worker", "worker"} {
+		tasks.Go(name, func(ctx context.Context) {
+			<-ctx.Done()
+			atomic.AddInt32(&stopped, 1)
+		})
+	}
+
+	if running := tasks.Stop(time.Second); len(running) > 0 {
+		t.Errorf("Unexpected running tasks: got %v, want none", running)
+	}
+	if stopped != 4 {
+		t.Errorf("Unexpected stopped tasks count: got %v, want %v", stopped, 4)
+	}
+}
+
+func TestTaskGroup_Stop_Timeout(t *testing.T) {
+	tasks := newTaskGroup()
+	release := make(chan struct{})
+	defer close(release)
+	tasks.Go("stuck", func(ctx context.Context) {
+		<-release
+	})
+	tasks.Go("well-behaved", func(ctx context.Context) {
+		<-ctx.Done()
+	})
+
+	running := tasks.Stop(10 * time.Millisecond)
+	if !reflect.DeepEqual(running, []string{"stuck"}) {
+		t.Errorf("Unexpected running tasks: got %v, want %v", running, []string{"stuck"})
+	}
+}
+
+func TestRunEvery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls int32
+	done := make(chan struct{})
+	go func() {
+		runEvery(ctx, time.Millisecond, func() {
+			if atomic.AddInt32(&calls, 1) == 3 {
+				cancel()
+			}
+		})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("runEvery did not return after context cancellation")
+	}
+}
+
+func TestMemoryDedupStore_Sweep(t *testing.T) {
+	store := newMemoryDedupStore()
+	store.Set("expired", "42", time.Nanosecond)
+	store.Set("valid", "43", time.Minute)
+	time.Sleep(time.Millisecond)
+
+	store.sweep()
+	if len(store.entries) != 1 {
+		t.Errorf("Unexpected entries count after sweep: got %v, want %v", len(store.entries), 1)
+	}
+}
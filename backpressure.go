@@ -0,0 +1,63 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultRetryAfter    = 30 * time.Second
+	defaultMaxRetryAfter = 5 * time.Minute
+)
+
+// overloadError is returned when the webhook or ServiceNow is overloaded, so that Alertmanager is told when to retry
+type overloadError struct {
+	message string
+	// retryAfter is zero when the backpressure state does not tell when to retry
+	retryAfter time.Duration
+}
+
+func (e *overloadError) Error() string {
+	return e.message
+}
+
+// parseRetryAfter returns the delay before retrying a request rate limited by ServiceNow, from the Retry-After
+// header (delay in seconds or HTTP date) or the X-RateLimit-Reset header (Unix epoch time), or zero when unknown
+func parseRetryAfter(header http.Header, now time.Time) time.Duration {
+	if retryAfter := header.Get("Retry-After"); len(retryAfter) > 0 {
+		if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		if date, err := http.ParseTime(retryAfter); err == nil && date.After(now) {
+			return date.Sub(now)
+		}
+	}
+	if reset := header.Get("X-RateLimit-Reset"); len(reset) > 0 {
+		if epoch, err := strconv.ParseInt(reset, 10, 64); err == nil {
+			if date := time.Unix(epoch, 0); date.After(now) {
+				return date.Sub(now)
+			}
+		}
+	}
+	return 0
+}
+
+// retryAfterHeader returns the Retry-After header value, in seconds, of the delay bounded by the configuration
+func (c WebhookConfig) retryAfterHeader(retryAfter time.Duration) string {
+	if retryAfter <= 0 {
+		retryAfter = c.DefaultRetryAfter
+		if retryAfter <= 0 {
+			retryAfter = defaultRetryAfter
+		}
+	}
+	maxRetryAfter := c.MaxRetryAfter
+	if maxRetryAfter <= 0 {
+		maxRetryAfter = defaultMaxRetryAfter
+	}
+	if retryAfter > maxRetryAfter {
+		retryAfter = maxRetryAfter
+	}
+	return strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))
+}
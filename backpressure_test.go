@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{name: "seconds", header: http.Header{"Retry-After": []string{"120"}}, want: 2 * time.Minute},
+		{name: "http_date", header: http.Header{"Retry-After": []string{now.Add(90 * time.Second).Format(http.TimeFormat)}}, want: 90 * time.Second},
+		{name: "rate_limit_reset", header: http.Header{"X-Ratelimit-Reset": []string{strconv.FormatInt(now.Add(time.Minute).Unix(), 10)}}, want: time.Minute},
+		{name: "past_rate_limit_reset", header: http.Header{"X-Ratelimit-Reset": []string{strconv.FormatInt(now.Add(-time.Minute).Unix(), 10)}}, want: 0},
+		{name: "invalid", header: http.Header{"Retry-After": []string{"soon"}}, want: 0},
+		{name: "none", header: http.Header{}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.header, now); got != tt.want {
+				t.Errorf("Unexpected retry after: got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryAfterHeader(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     WebhookConfig
+		retryAfter time.Duration
+		want       string
+	}{
+		{name: "known", retryAfter: 1500 * time.Millisecond, want: "2"},
+		{name: "unknown", retryAfter: 0, want: "30"},
+		{name: "configured_default", config: WebhookConfig{DefaultRetryAfter: 10 * time.Second}, want: "10"},
+		{name: "capped", retryAfter: time.Hour, want: "300"},
+		{name: "configured_max", config: WebhookConfig{MaxRetryAfter: time.Minute}, retryAfter: time.Hour, want: "60"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.config.retryAfterHeader(tt.retryAfter); got != tt.want {
+				t.Errorf("Unexpected Retry-After header: got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookHandler_ServiceNowOverloaded(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		header     http.Header
+		want       string
+	}{
+		{name: "rate_limited", statusCode: http.StatusTooManyRequests, header: http.Header{"Retry-After": []string{"120"}}, want: "120"},
+		{name: "unavailable", statusCode: http.StatusServiceUnavailable, header: http.Header{}, want: "30"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for k, v := range tt.header {
+					w.Header()[k] = v
+				}
+				w.WriteHeader(tt.statusCode)
+			}))
+			defer ts.Close()
+
+			loadConfig("config/servicenow_example.yml")
+			snClient, err := NewServiceNowClient("instancename", "username", "password")
+			if err != nil {
+				t.Fatal(err)
+			}
+			snClient.baseURL = ts.URL
+			useServiceNow(snClient)
+
+			data, err := ioutil.ReadFile("test/alertmanager_firing.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(webhook).ServeHTTP(rr, httptest.NewRequest("POST", "/webhook", bytes.NewReader(data)))
+
+			if rr.Code != http.StatusServiceUnavailable {
+				t.Errorf("Wrong status code: got %v, want %v", rr.Code, http.StatusServiceUnavailable)
+			}
+			if got := rr.Header().Get("Retry-After"); got != tt.want {
+				t.Errorf("Unexpected Retry-After header: got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookHandler_NoRetryAfterOnError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer ts.Close()
+
+	loadConfig("config/servicenow_example.yml")
+	snClient, err := NewServiceNowClient("instancename", "username", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	snClient.baseURL = ts.URL
+	useServiceNow(snClient)
+
+	data, err := ioutil.ReadFile("test/alertmanager_firing.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(webhook).ServeHTTP(rr, httptest.NewRequest("POST", "/webhook", bytes.NewReader(data)))
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Wrong status code: got %v, want %v", rr.Code, http.StatusInternalServerError)
+	}
+	if got := rr.Header().Get("Retry-After"); len(got) > 0 {
+		t.Errorf("Unexpected Retry-After header: %v", got)
+	}
+}
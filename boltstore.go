@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/binary"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltDedupBucket = []byte("dedup")
+
+// boltDedupStore is a DedupStore persisted in an embedded BoltDB file, so that the incidents created for the alert
+// group keys survive restarts. It is only suitable when running a single replica.
+type boltDedupStore struct {
+	db *bolt.DB
+}
+
+func newBoltDedupStore(path string) (*boltDedupStore, error) {
+	// The file is locked by the process holding it open: fail rather than wait forever for another instance
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltDedupBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltDedupStore{db: db}, nil
+}
+
+// encodeBoltEntry encodes the entry as its expiration time, in Unix nanoseconds, followed by its value
+func encodeBoltEntry(value string, expiresAt time.Time) []byte {
+	entry := make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(entry, uint64(expiresAt.UnixNano()))
+	copy(entry[8:], value)
+	return entry
+}
+
+// decodeBoltEntry returns the entry value if it is valid and not expired
+func decodeBoltEntry(entry []byte, now time.Time) (string, bool) {
+	if len(entry) < 8 || now.UnixNano() > int64(binary.BigEndian.Uint64(entry)) {
+		return "", false
+	}
+	return string(entry[8:]), true
+}
+
+func (s *boltDedupStore) Get(key string) (string, error) {
+	var value string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value, _ = decodeBoltEntry(tx.Bucket(boltDedupBucket).Get([]byte(key)), time.Now())
+		return nil
+	})
+	return value, err
+}
+
+func (s *boltDedupStore) Set(key string, value string, ttl time.Duration) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltDedupBucket).Put([]byte(key), encodeBoltEntry(value, time.Now().Add(ttl)))
+	})
+}
+
+func (s *boltDedupStore) SetIfAbsent(key string, value string, ttl time.Duration) (bool, error) {
+	var set bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltDedupBucket)
+		now := time.Now()
+		if _, ok := decodeBoltEntry(bucket.Get([]byte(key)), now); ok {
+			return nil
+		}
+		set = true
+		return bucket.Put([]byte(key), encodeBoltEntry(value, now.Add(ttl)))
+	})
+	return set, err
+}
+
+func (s *boltDedupStore) Delete(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltDedupBucket).Delete([]byte(key))
+	})
+}
+
+// sweep removes the expired entries
+func (s *boltDedupStore) sweep() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltDedupBucket)
+		now := time.Now()
+		// Keys are deleted once iterated, as deleting through the cursor skips the following key
+		var expired [][]byte
+		bucket.ForEach(func(key []byte, entry []byte) error {
+			if _, ok := decodeBoltEntry(entry, now); !ok {
+				expired = append(expired, append([]byte{}, key...))
+			}
+			return nil
+		})
+		for _, key := range expired {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltDedupStore) Close() error {
+	return s.db.Close()
+}
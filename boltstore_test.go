@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func tempBoltPath(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "dedup.db"), func() { os.RemoveAll(dir) }
+}
+
+func TestBoltDedupStore(t *testing.T) {
+	path, cleanup := tempBoltPath(t)
+	defer cleanup()
+	store, err := newBoltDedupStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	testDedupStore(t, store)
+}
+
+func TestBoltDedupStore_Expiry(t *testing.T) {
+	path, cleanup := tempBoltPath(t)
+	defer cleanup()
+	store, err := newBoltDedupStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	store.Set("expired", "42", time.Nanosecond)
+	store.Set("kept", "43", time.Minute)
+	time.Sleep(time.Millisecond)
+
+	if value, _ := store.Get("expired"); value != "" {
+		t.Errorf("Expired entry should not be returned, got %v", value)
+	}
+	if claimed, _ := store.SetIfAbsent("expired", dedupPending, time.Minute); !claimed {
+		t.Errorf("SetIfAbsent on expired key should succeed")
+	}
+
+	store.Set("expired", "42", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if err := store.sweep(); err != nil {
+		t.Fatal(err)
+	}
+	entries := 0
+	store.db.View(func(tx *bolt.Tx) error {
+		entries = tx.Bucket(boltDedupBucket).Stats().KeyN
+		return nil
+	})
+	if entries != 1 {
+		t.Errorf("Expired entries should be swept, got %d entries", entries)
+	}
+}
+
+func TestBoltDedupStore_Persistence(t *testing.T) {
+	path, cleanup := tempBoltPath(t)
+	defer cleanup()
+	store, err := newBoltDedupStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Set("key", "42", time.Minute)
+	store.Close()
+
+	store, err = newBoltDedupStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if value, _ := store.Get("key"); value != "42" {
+		t.Errorf("Entry should survive a restart: got %v, want %v", value, "42")
+	}
+}
+
+func TestLoadDedupStore_Bolt(t *testing.T) {
+	path, cleanup := tempBoltPath(t)
+	defer cleanup()
+	loadConfig("config/servicenow_example.yml")
+	*dedupBoltPath = path
+	defer func() {
+		*dedupBoltPath = ""
+		dedupStore = newMemoryDedupStore()
+	}()
+
+	store, err := loadDedupStore(*currentConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.(*boltDedupStore); !ok {
+		t.Errorf("Unexpected store type: got %T, want *boltDedupStore", store)
+	}
+	store.(*boltDedupStore).Close()
+
+	currentConfig().Dedup.Redis.Addr = "localhost:6379"
+	if _, err := loadDedupStore(*currentConfig()); err == nil {
+		t.Errorf("Expected an error using both BoltDB and Redis stores, got none")
+	}
+}
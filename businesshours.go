@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+const holidayLayout = "2006-01-02"
+
+// BusinessHoursConfig - Calendar of the business hours, and the rules adjusting the incident impact and urgency during
+// and outside of them
+type BusinessHoursConfig struct {
+	Weekdays []string                  `yaml:"weekdays"`
+	Start    string                    `yaml:"start_time"`
+	End      string                    `yaml:"end_time"`
+	TimeZone string                    `yaml:"time_zone"`
+	Holidays []string                  `yaml:"holidays"`
+	Rules    []BusinessHoursRuleConfig `yaml:"rules"`
+}
+
+// BusinessHoursRuleConfig - Impact and urgency of the alert groups matching all the labels, during and outside of the
+// business hours
+type BusinessHoursRuleConfig struct {
+	Match         map[string]string `yaml:"match"`
+	BusinessHours SeverityLevel     `yaml:"business_hours"`
+	OffHours      SeverityLevel     `yaml:"off_hours"`
+}
+
+func (c BusinessHoursConfig) validate(errs *strings.Builder) {
+	for _, day := range c.Weekdays {
+		if _, ok := weekdays[strings.ToLower(day)]; !ok {
+			errs.WriteString(fmt.Sprintf("business_hours.weekdays %q is not a day of the week\n", day))
+		}
+	}
+	if (len(c.Start) == 0) != (len(c.End) == 0) {
+		errs.WriteString("business_hours needs both a start_time and an end_time\n")
+	}
+	for _, value := range []string{c.Start, c.End} {
+		if _, err := time.Parse(maintenanceTimeLayout, value); len(value) > 0 && err != nil {
+			errs.WriteString(fmt.Sprintf("business_hours time %q is not formatted as HH:MM\n", value))
+		}
+	}
+	if _, err := time.LoadLocation(c.TimeZone); err != nil {
+		errs.WriteString(fmt.Sprintf("business_hours.time_zone is invalid: %v\n", err))
+	}
+	for _, holiday := range c.Holidays {
+		if _, err := time.Parse(holidayLayout, holiday); err != nil {
+			errs.WriteString(fmt.Sprintf("business_hours.holidays %q is not formatted as YYYY-MM-DD\n", holiday))
+		}
+	}
+	for i, rule := range c.Rules {
+		rule.BusinessHours.validate(fmt.Sprintf("business_hours.rules[%d].business_hours", i), errs)
+		rule.OffHours.validate(fmt.Sprintf("business_hours.rules[%d].off_hours", i), errs)
+	}
+}
+
+func (c BusinessHoursConfig) enabled() bool {
+	return len(c.Rules) > 0
+}
+
+// calendar returns the business hours as a recurring window, on the working days by default
+func (c BusinessHoursConfig) calendar() MaintenanceWindowConfig {
+	window := MaintenanceWindowConfig{Weekdays: c.Weekdays, Start: c.Start, End: c.End, TimeZone: c.TimeZone}
+	if len(window.Weekdays) == 0 {
+		window.Weekdays = []string{"monday", "tuesday", "wednesday", "thursday", "friday"}
+	}
+	return window
+}
+
+// during returns whether the time is within the business hours, and not on a holiday
+func (c BusinessHoursConfig) during(now time.Time) bool {
+	location, err := time.LoadLocation(c.TimeZone)
+	if err != nil {
+		location = time.UTC
+	}
+	today := now.In(location).Format(holidayLayout)
+	for _, holiday := range c.Holidays {
+		if holiday == today {
+			return false
+		}
+	}
+	return c.calendar().active(now)
+}
+
+func (r BusinessHoursRuleConfig) matches(data template.Data) bool {
+	for name, value := range r.Match {
+		if (fieldMapping{source: fieldMappingLabel, name: name}).value(data) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// applyBusinessHours sets the incident impact, urgency and priority of the first rule matching the alert group,
+// depending on whether the time is within the business hours, overriding the ones of the severity mapping
+func applyBusinessHours(c BusinessHoursConfig, incident Incident, data template.Data, now time.Time) {
+	for _, rule := range c.Rules {
+		if !rule.matches(data) {
+			continue
+		}
+		level := rule.OffHours
+		if c.during(now) {
+			level = rule.BusinessHours
+		}
+		level.apply(incident)
+		return
+	}
+}
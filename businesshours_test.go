@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+func TestBusinessHoursConfig_During(t *testing.T) {
+	c := BusinessHoursConfig{Start: "08:00", End: "18:00", TimeZone: "Europe/Paris", Holidays: []string{"2024-12-25"}}
+	tests := []struct {
+		name string
+		time time.Time
+		want bool
+	}{
+		{name: "noon", time: time.Date(2024, 1, 2, 11, 0, 0, 0, time.UTC), want: true},
+		{name: "night", time: time.Date(2024, 1, 2, 2, 0, 0, 0, time.UTC), want: false},
+		{name: "time_zone", time: time.Date(2024, 1, 2, 17, 30, 0, 0, time.UTC), want: false},
+		{name: "weekend", time: time.Date(2024, 1, 6, 11, 0, 0, 0, time.UTC), want: false},
+		{name: "holiday", time: time.Date(2024, 12, 25, 11, 0, 0, 0, time.UTC), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.during(tt.time); got != tt.want {
+				t.Errorf("Unexpected business hours at %v: got %v, want %v", tt.time, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyBusinessHours(t *testing.T) {
+	c := BusinessHoursConfig{
+		Start: "08:00",
+		End:   "18:00",
+		Rules: []BusinessHoursRuleConfig{
+			{Match: map[string]string{"severity": "warning", "tier": "24x7"}, BusinessHours: SeverityLevel{Urgency: "3"}, OffHours: SeverityLevel{Impact: "2", Urgency: "1"}},
+			{Match: map[string]string{"severity": "warning"}, OffHours: SeverityLevel{Urgency: "4"}},
+		},
+	}
+	noon, night := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC), time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	tiered := template.Data{CommonLabels: template.KV{"severity": "warning", "tier": "24x7"}}
+	tests := []struct {
+		name        string
+		data        template.Data
+		time        time.Time
+		wantImpact  string
+		wantUrgency string
+	}{
+		{name: "night_24x7", data: tiered, time: night, wantImpact: "2", wantUrgency: "1"},
+		{name: "noon_24x7", data: tiered, time: noon, wantImpact: "3", wantUrgency: "3"},
+		{name: "night_other", data: template.Data{CommonLabels: template.KV{"severity": "warning"}}, time: night, wantImpact: "3", wantUrgency: "4"},
+		{name: "unmatched", data: template.Data{CommonLabels: template.KV{"severity": "critical"}}, time: night, wantImpact: "3", wantUrgency: "2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			incident := Incident{"impact": "3", "urgency": "2"}
+			applyBusinessHours(c, incident, tt.data, tt.time)
+			if incident["impact"] != tt.wantImpact || incident["urgency"] != tt.wantUrgency {
+				t.Errorf("Unexpected impact/urgency: got %v/%v, want %v/%v", incident["impact"], incident["urgency"], tt.wantImpact, tt.wantUrgency)
+			}
+		})
+	}
+}
+
+func TestBusinessHoursConfig_Validate(t *testing.T) {
+	var errs strings.Builder
+	BusinessHoursConfig{
+		Weekdays: []string{"funday"},
+		Start:    "8h",
+		TimeZone: "Mars/Olympus",
+		Holidays: []string{"25/12/2024"},
+		Rules:    []BusinessHoursRuleConfig{{OffHours: SeverityLevel{Urgency: "high"}}},
+	}.validate(&errs)
+	for _, want := range []string{`"funday"`, "both a start_time and an end_time", `"8h"`, "time_zone", `"25/12/2024"`, "business_hours.rules[0].off_hours.urgency"} {
+		if !strings.Contains(errs.String(), want) {
+			t.Errorf("Missing validation error %q: %q", want, errs.String())
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	callbackPath = "/servicenow/callback"
+	// maxCallbackBodySize is the maximum size of the callback request bodies, holding a single incident
+	maxCallbackBodySize = 64 * 1024
+
+	callbackClosed       = "closed"
+	callbackAcknowledged = "acknowledged"
+	callbackUpdated      = "updated"
+	callbackUntracked    = "untracked"
+)
+
+var serviceNowCallbacks = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "webhook_servicenow_callbacks_total",
+		Help: "Total number of incident state changes notified by ServiceNow on /servicenow/callback, by change (closed, acknowledged, updated or untracked).",
+	},
+	[]string{"change"},
+)
+
+// CallbackConfig - Endpoint receiving the incident state changes notified by ServiceNow, through a Business Rule or an
+// outbound REST message, closing the loop without waiting for the reconciliation
+type CallbackConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// BearerToken expected in the Authorization header of the callbacks, instead of the webhook authentication
+	BearerToken string `yaml:"bearer_token"`
+	// ExpireSilences expires the Alertmanager silence of the alert group once its incident is not acknowledged anymore
+	ExpireSilences bool `yaml:"expire_silences"`
+}
+
+// incidentCallback is the body of a callback, the incident whose state changed
+type incidentCallback struct {
+	SysID  string      `json:"sys_id"`
+	Number string      `json:"number"`
+	State  json.Number `json:"state"`
+}
+
+// callbackResponse is the response of a callback, the number of tracked incidents and alert mappings updated
+type callbackResponse struct {
+	Change   string `json:"change"`
+	Tracked  int    `json:"tracked"`
+	Mappings int    `json:"mappings"`
+}
+
+func (c CallbackConfig) validate(config Config, errs *strings.Builder) {
+	if !c.Enabled {
+		return
+	}
+	if !config.Workflow.Reconciliation.Enabled {
+		errs.WriteString("callback requires workflow.reconciliation to be enabled, tracking the incidents of the firing alert groups\n")
+	}
+	if c.ExpireSilences && !config.Alertmanager.Silences.Enabled {
+		errs.WriteString("callback.expire_silences requires alertmanager.silences to be enabled\n")
+	}
+}
+
+// authenticate returns whether the callback carries the bearer token, or else the webhook credentials
+func (c CallbackConfig) authenticate(r *http.Request) bool {
+	config := configFrom(r.Context())
+	if len(c.BearerToken) == 0 {
+		return config.Webhook.authenticate(r)
+	}
+	return secureCompare(r.Header.Get("Authorization"), "Bearer "+c.BearerToken)
+}
+
+// applyCallback applies the state change of the incident to its tracked incidents and alert mappings. The closed
+// incidents are released like by the reconciliation, the alert groups of the acknowledged ones are silenced, and their
+// silence is expired once the incident is reopened when expire_silences is enabled.
+func applyCallback(ctx context.Context, callback incidentCallback, now time.Time) callbackResponse {
+	config := configFrom(ctx)
+	response := callbackResponse{Change: callbackUntracked}
+	if alertMappings != nil {
+		response.Mappings = alertMappings.updateState(callback.SysID, callback.State.String())
+	}
+	for key, tracked := range trackedIncidents.find(callback.SysID) {
+		ctx := withInstance(ctx, tracked.group.instance)
+		response.Tracked++
+		switch {
+		case tableNoUpdateStates(ctx, tracked.group.tableName)[callback.State]:
+			response.Change = callbackClosed
+			if releaseIncident(ctx, config.Workflow.Reconciliation, key, tracked, callbackClosed) && config.Callback.ExpireSilences {
+				expireIncidentSilence(ctx, key, tracked)
+			}
+		case config.Alertmanager.Silences.Enabled && config.Alertmanager.Silences.states()[callback.State]:
+			response.Change = callbackAcknowledged
+			silenceAcknowledgedIncident(ctx, key, tracked, Incident{"state": callback.State.String()}, now)
+		default:
+			response.Change = callbackUpdated
+			if config.Callback.ExpireSilences {
+				expireIncidentSilence(ctx, key, tracked)
+			}
+		}
+	}
+	serviceNowCallbacks.WithLabelValues(response.Change).Inc()
+	return response
+}
+
+// callbackHandler is the handler of /servicenow/callback, applying on POST requests the state change of the incident
+// of the JSON body, e.g. {"sys_id": "...", "number": "INC0010001", "state": "2"}
+func callbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Only POST requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r = r.WithContext(withConfigSnapshot(r.Context()))
+	config := configFrom(r.Context())
+	if !config.Callback.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+	if !config.Callback.authenticate(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !leader.isLeader() {
+		// The standby replicas track no incident
+		w.Header().Set("Retry-After", config.Webhook.retryAfterHeader(0))
+		http.Error(w, "Standby replica, the incidents are managed by the leader", http.StatusServiceUnavailable)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxCallbackBodySize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading the request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	var callback incidentCallback
+	if err := json.Unmarshal(body, &callback); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid incident callback: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(callback.SysID) == 0 || len(callback.State) == 0 {
+		http.Error(w, "The incident callback needs a sys_id and a state", http.StatusBadRequest)
+		return
+	}
+
+	ctx := withAuditCaller(r.Context(), auditCaller{Source: "servicenow callback", RemoteAddr: r.RemoteAddr})
+	response := applyCallback(ctx, callback, time.Now())
+	loggerFrom(ctx).Infof("ServiceNow callback of incident %s in state %s: %s, %d tracked incidents", callback.Number, callback.State, response.Change, response.Tracked)
+	writeJSON(w, response)
+}
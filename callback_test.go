@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+func TestCallbackHandler(t *testing.T) {
+	var expired []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			expired = append(expired, strings.TrimPrefix(r.URL.Path, "/api/v2/silence/"))
+		}
+	}))
+	defer ts.Close()
+
+	loadConfig("config/servicenow_example.yml")
+	dedupStore = newMemoryDedupStore()
+	trackedIncidents = &incidentTracker{incidents: map[string]trackedIncident{}}
+	currentConfig().Workflow.Reconciliation = ReconciliationConfig{Enabled: true}
+	currentConfig().Alertmanager = AlertmanagerConfig{URL: ts.URL, Silences: SilencesConfig{Enabled: true}}
+	currentConfig().Callback = CallbackConfig{Enabled: true, BearerToken: "token", ExpireSilences: true}
+	data := template.Data{Status: "firing", GroupLabels: template.KV{"alertname": "DiskFull"}, Alerts: template.Alerts{{Status: "firing"}}}
+	trackIncident(context.Background(), "incident", "reopened", data, Incident{"sys_id": "1", "number": "INC1"})
+	trackIncident(context.Background(), "incident", "closed", data, Incident{"sys_id": "2", "number": "INC2"})
+	trackedIncidents.silenced("reopened", "1", "s1", time.Now().Add(time.Hour))
+	dedupStore.Set("closed", "2", time.Hour)
+
+	post := func(authorization string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", callbackPath, strings.NewReader(body))
+		req.Header.Set("Authorization", authorization)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(callbackHandler).ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := post("Bearer other", `{"sys_id": "1", "state": "1"}`); rr.Code != http.StatusUnauthorized {
+		t.Errorf("The callback without the bearer token should be rejected: got %v", rr.Code)
+	}
+	if rr := post("Bearer token", `{"number": "INC1"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("The callback without sys_id and state should be rejected: got %v", rr.Code)
+	}
+
+	tests := []struct {
+		body   string
+		change string
+	}{
+		{`{"sys_id": "1", "number": "INC1", "state": "1"}`, callbackUpdated},
+		{`{"sys_id": "2", "number": "INC2", "state": 7}`, callbackClosed},
+		{`{"sys_id": "3", "number": "INC3", "state": "2"}`, callbackUntracked},
+	}
+	for _, tt := range tests {
+		rr := post("Bearer token", tt.body)
+		var response callbackResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil || rr.Code != http.StatusOK || response.Change != tt.change {
+			t.Errorf("Unexpected response to %s: %v %s", tt.body, rr.Code, rr.Body.String())
+		}
+	}
+	if len(expired) != 1 || expired[0] != "s1" {
+		t.Errorf("The silence of the reopened incident should be expired: %v", expired)
+	}
+	if tracked := trackedIncidents.incidents["reopened"]; len(tracked.silenceID) > 0 {
+		t.Errorf("The expired silence should not be recorded anymore: %+v", tracked)
+	}
+	if _, ok := trackedIncidents.incidents["closed"]; ok {
+		t.Errorf("The closed incident should not be tracked anymore")
+	}
+	if sysID, _ := dedupStore.Get("closed"); len(sysID) > 0 {
+		t.Errorf("The closed incident should be released from the deduplication store")
+	}
+}
+
+func TestCallbackHandler_Disabled(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(callbackHandler).ServeHTTP(rr, httptest.NewRequest("POST", callbackPath, strings.NewReader(`{}`)))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("The disabled callback should answer with a 404: got %v", rr.Code)
+	}
+}
+
+func TestAlertMappingRegistry_UpdateState(t *testing.T) {
+	registry := newAlertMappingRegistry(10)
+	group := tableGroup{tableName: "incident", data: template.Data{Alerts: template.Alerts{{Labels: template.KV{"alertname": "A"}}, {Labels: template.KV{"alertname": "B"}}}}}
+	registry.record(group, &incidentRef{sysID: "1", number: "INC1", state: "1"}, nil, time.Now())
+
+	if updated := registry.updateState("1", "2"); updated != 2 {
+		t.Errorf("The mappings of the two alerts should be updated: got %d", updated)
+	}
+	for _, mapping := range registry.list() {
+		if mapping.State != "2" {
+			t.Errorf("Unexpected state of the mapping: %+v", mapping)
+		}
+	}
+}
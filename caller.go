@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+const callerIDField = "caller_id"
+
+// CallerConfig - Caller of the incidents, optionally resolved to the sys_id of a ServiceNow user
+type CallerConfig struct {
+	Value       string `yaml:"value"`
+	LookupField string `yaml:"lookup_field"`
+}
+
+func (c CallerConfig) validate(errs *strings.Builder) {
+	switch c.LookupField {
+	case "", "user_name", "email":
+	default:
+		errs.WriteString(fmt.Sprintf("caller.lookup_field %q must be \"user_name\" or \"email\"\n", c.LookupField))
+	}
+	if _, err := newTextTemplate(callerIDField).Parse(c.Value); err != nil {
+		errs.WriteString(fmt.Sprintf("caller.value is invalid: %v\n", err))
+	}
+}
+
+// callerID returns the caller of the incident of the alert group: the configured value, defaulting to the user of the
+// ServiceNow instance, or the sys_id of the user it identifies when looked up. An unresolved caller is kept as is.
+func callerID(ctx context.Context, data template.Data) string {
+	config := configFrom(ctx)
+	c := config.Caller
+	caller := instanceConfigFrom(ctx).UserName
+	if len(c.Value) > 0 {
+		fields := Incident{callerIDField: c.Value}
+		applyIncidentTemplate(ctx, fields, data)
+		caller, _ = fields[callerIDField].(string)
+	}
+	if len(c.LookupField) == 0 || len(caller) == 0 {
+		return caller
+	}
+
+	sysID, err := lookupSysID(ctx, userCache, userTable, c.LookupField, caller)
+	if err != nil {
+		serviceNowError.Inc()
+		loggerFrom(ctx).Errorf("Error resolving the ServiceNow caller %s: %v", caller, err)
+		return caller
+	}
+	if len(sysID) == 0 {
+		loggerFrom(ctx).Warnf("ServiceNow caller %s not found by %s", caller, c.LookupField)
+		return caller
+	}
+	return sysID
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+func TestCallerID(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	data := template.Data{CommonLabels: template.KV{"owner": "jdoe@example.com"}}
+	if caller := callerID(context.Background(), data); caller != currentConfig().ServiceNow.UserName {
+		t.Errorf("The caller should default to the user of the instance: got %v", caller)
+	}
+
+	currentConfig().Caller = CallerConfig{Value: "{{ .CommonLabels.owner }}"}
+	if caller := callerID(context.Background(), data); caller != "jdoe@example.com" {
+		t.Errorf("The caller should be rendered from its template: got %v", caller)
+	}
+}
+
+func TestCallerID_Lookup(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Caller = CallerConfig{Value: "{{ .CommonLabels.owner }}", LookupField: "email"}
+	userCache = newLookupCache("user", time.Minute)
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "sys_user", map[string]string{"email": "jdoe@example.com", "sysparm_fields": "sys_id", "sysparm_limit": "1"}).Return([]Incident{{"sys_id": "1"}}, nil)
+	snClientMock.On("GetIncidents", "sys_user", map[string]string{"email": "unknown@example.com", "sysparm_fields": "sys_id", "sysparm_limit": "1"}).Return([]Incident{}, nil)
+	snClientMock.On("GetIncidents", "sys_user", map[string]string{"email": "failing@example.com", "sysparm_fields": "sys_id", "sysparm_limit": "1"}).Return([]Incident{}, errors.New("Error"))
+
+	for owner, want := range map[string]string{"jdoe@example.com": "1", "unknown@example.com": "unknown@example.com", "failing@example.com": "failing@example.com"} {
+		data := template.Data{CommonLabels: template.KV{"owner": owner}}
+		if caller := callerID(context.Background(), data); caller != want {
+			t.Errorf("Unexpected caller of %s: got %v, want %v", owner, caller, want)
+		}
+	}
+	callerID(context.Background(), template.Data{CommonLabels: template.KV{"owner": "jdoe@example.com"}})
+	snClientMock.AssertNumberOfCalls(t, "GetIncidents", 3)
+}
+
+func TestCallerConfig_Validate(t *testing.T) {
+	var errs strings.Builder
+	CallerConfig{Value: "{{ .CommonLabels.owner", LookupField: "name"}.validate(&errs)
+	for _, want := range []string{`caller.lookup_field "name"`, "caller.value is invalid"} {
+		if !strings.Contains(errs.String(), want) {
+			t.Errorf("Missing validation error %q: %q", want, errs.String())
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// checkConfigFile validates the config file offline, without loading it: its YAML structure, unknown fields included,
+// the config validation rules and the syntax of its templates. It returns the errors found.
+func checkConfigFile(configFile string) []string {
+	configData, err := readConfigFile(configFile)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	expanded, err := expandEnvVars(configData)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	// The strict YAML errors hold the line of each unknown field or invalid value
+	var errs []string
+	strictErr := yaml.UnmarshalStrict(expanded, &Config{})
+	var c Config
+	if err := yaml.Unmarshal(expanded, &c); err != nil {
+		return errorLines(strictErr, "yaml: unmarshal errors:")
+	}
+	if strictErr != nil {
+		errs = append(errs, errorLines(strictErr, "yaml: unmarshal errors:")...)
+	}
+
+	if _, err := parseConfig(configData); err != nil {
+		errs = append(errs, errorLines(err, "Config file is invalid")...)
+	}
+	return append(errs, checkTemplates(c)...)
+}
+
+// errorLines splits the error message in lines, without its header
+func errorLines(err error, header string) []string {
+	var lines []string
+	for _, line := range strings.Split(err.Error(), "\n") {
+		if line = strings.TrimSpace(line); len(line) > 0 && line != header {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// checkTemplates returns the parsing errors of the templates of the config, prefixed with their location
+func checkTemplates(c Config) []string {
+	templates := map[string]map[string]string{
+		"default_incident":           c.DefaultIncident,
+		"event.fields":               c.Event.Fields,
+		"workflow.resolve":           c.Workflow.Resolve.templates(),
+		"workflow.repeat_work_notes": {"template": c.Workflow.RepeatWorkNotes.Template},
+		"required_fields":            c.requiredFieldsTemplates(),
+	}
+	for table, fields := range c.TableProfiles {
+		templates["table_profiles."+table] = fields
+	}
+	for table, workflow := range c.TableWorkflows {
+		if workflow.Resolve != nil {
+			templates["table_workflows."+table+".resolve"] = workflow.Resolve.templates()
+		}
+	}
+	for name, fields := range c.IncidentTemplates {
+		templates["incident_templates."+name] = fields
+	}
+	for name, receiver := range c.Receivers {
+		templates["receivers."+name+".default_incident"] = receiver.DefaultIncident
+	}
+
+	var errs []string
+	for location, fields := range templates {
+		for field, text := range fields {
+			if _, err := newTextTemplate(field).Parse(text); err != nil {
+				errs = append(errs, fmt.Sprintf("%s.%s: %v", location, field, err))
+			}
+		}
+	}
+	sort.Strings(errs)
+	return errs
+}
+
+// runCheckConfig prints the errors of the config file, returning the exit code of the check-config command
+func runCheckConfig(w io.Writer, configFile string) int {
+	errs := checkConfigFile(configFile)
+	if len(errs) > 0 {
+		fmt.Fprintf(w, "Config file %s is invalid:\n", configFile)
+		for _, err := range errs {
+			fmt.Fprintf(w, "  %s\n", err)
+		}
+		return 1
+	}
+	fmt.Fprintf(w, "Config file %s is valid\n", configFile)
+	return 0
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCheckConfigFile(t *testing.T) {
+	if errs := checkConfigFile("config/servicenow_example.yml"); len(errs) > 0 {
+		t.Errorf("The example config should be valid: %v", errs)
+	}
+
+	file, err := ioutil.TempFile("", "servicenow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(`
+service_now:
+ instance_name: "instance"
+ user_name: "SA"
+ password: "SA!"
+ unknown_field: 1
+workflow:
+ incident_group_key_field: "u_other_reference_1"
+default_incident:
+ short_description: "{{ .CommonLabels.alertname "
+alert_filter:
+ exclude: ['team']
+`)
+	file.Close()
+
+	errs := checkConfigFile(file.Name())
+	want := []string{
+		"line 6: field unknown_field not found",
+		"alert_filter.exclude matcher team is invalid",
+		"default_incident.short_description: template: short_description:1: unclosed action",
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("Unexpected errors: %q", errs)
+	}
+	for i := range want {
+		if !strings.HasPrefix(errs[i], want[i]) {
+			t.Errorf("Unexpected error %d: got %q, want %q", i, errs[i], want[i])
+		}
+	}
+
+	var out bytes.Buffer
+	if code := runCheckConfig(&out, file.Name()); code != 1 || !strings.Contains(out.String(), "is invalid") {
+		t.Errorf("Unexpected check-config result: %d %q", code, out.String())
+	}
+}
+
+func TestCheckConfigFile_InvalidYAML(t *testing.T) {
+	file, err := ioutil.TempFile("", "servicenow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("service_now:\n instance_name: [\n")
+	file.Close()
+
+	if errs := checkConfigFile(file.Name()); len(errs) != 1 || !strings.Contains(errs[0], "line") {
+		t.Errorf("Unexpected errors: %q", errs)
+	}
+}
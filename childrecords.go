@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	defaultChildTable       = "incident_task"
+	defaultChildParentField = "incident"
+	defaultChildKeyField    = "correlation_id"
+)
+
+var childRecordErrors = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Name: "webhook_child_record_errors_total",
+		Help: "Total number of child records of the alerts which could not be looked up, created or resolved.",
+	},
+)
+
+// ChildRecordsConfig - Child record (incident task or child incident) of each alert of the incident of an alert
+// group, so that triage sees one incident while each alert has its own trackable record
+type ChildRecordsConfig struct {
+	Enabled      bool              `yaml:"enabled"`
+	TableName    string            `yaml:"table_name"`
+	ParentField  string            `yaml:"parent_field"`
+	KeyField     string            `yaml:"key_field"`
+	Fields       map[string]string `yaml:"fields"`
+	ResolveState string            `yaml:"resolve_state"`
+}
+
+func (c ChildRecordsConfig) validate(incidentPerAlert bool, errs *strings.Builder) {
+	if c.Enabled && incidentPerAlert {
+		errs.WriteString("workflow.child_records cannot be used with workflow.incident_per_alert, each alert having its own incident\n")
+	}
+}
+
+func (c ChildRecordsConfig) tableName() string {
+	if len(c.TableName) > 0 {
+		return c.TableName
+	}
+	return defaultChildTable
+}
+
+func (c ChildRecordsConfig) parentField() string {
+	if len(c.ParentField) > 0 {
+		return c.ParentField
+	}
+	return defaultChildParentField
+}
+
+func (c ChildRecordsConfig) keyField() string {
+	if len(c.KeyField) > 0 {
+		return c.KeyField
+	}
+	return defaultChildKeyField
+}
+
+// syncChildRecords creates the child record of each firing alert of the group missing one, and resolves the child
+// record of each resolved alert, when configured. The child records reference the incident of the alert group, and
+// hold the fingerprint of their alert in their key field. A failed child record is only logged, the incident being
+// managed nonetheless.
+func syncChildRecords(ctx context.Context, parent Incident, data template.Data) {
+	config := configFrom(ctx)
+	c := config.Workflow.ChildRecords
+	parentSysID, _ := parent["sys_id"].(string)
+	if !c.Enabled || (len(parentSysID) == 0 && !isDryRun(ctx)) {
+		return
+	}
+
+	children, err := serviceNowFrom(ctx).GetIncidents(ctx, c.tableName(), map[string]string{c.parentField(): parentSysID})
+	if err != nil {
+		childRecordErrors.Inc()
+		loggerFrom(ctx).Errorf("Error looking up the child records of incident %v: %v", parent["number"], err)
+		return
+	}
+	byFingerprint := make(map[string]Incident, len(children))
+	for _, child := range children {
+		if fingerprint, ok := child[c.keyField()].(string); ok {
+			byFingerprint[fingerprint] = child
+		}
+	}
+
+	for _, alert := range data.Alerts {
+		fingerprint := alertFingerprint(alert)
+		child, exists := byFingerprint[fingerprint]
+		switch {
+		case alert.Status == "firing" && !exists:
+			fields := Incident{}
+			for field, value := range c.Fields {
+				fields[field] = value
+			}
+			applyIncidentTemplate(ctx, fields, singleAlertData(data, alert))
+			fields[c.parentField()] = parentSysID
+			fields[c.keyField()] = fingerprint
+			if _, err := serviceNowFrom(ctx).CreateIncident(ctx, c.tableName(), fields); err != nil {
+				childRecordErrors.Inc()
+				loggerFrom(ctx).Errorf("Error creating the child record of alert %s: %v", fingerprint, err)
+			}
+		case alert.Status == "resolved" && exists && len(c.ResolveState) > 0 && child["state"] != c.ResolveState:
+			if _, err := serviceNowFrom(ctx).UpdateIncident(ctx, c.tableName(), Incident{"state": c.ResolveState}, child.GetSysID()); err != nil {
+				childRecordErrors.Inc()
+				loggerFrom(ctx).Errorf("Error resolving the child record of alert %s: %v", fingerprint, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSyncChildRecords(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Workflow.ChildRecords = ChildRecordsConfig{
+		Enabled:      true,
+		Fields:       map[string]string{"short_description": "{{ .CommonLabels.instance }}"},
+		ResolveState: "3",
+	}
+	firing := template.Alert{Status: "firing", Labels: template.KV{"alertname": "DiskFull", "instance": "a"}}
+	resolved := template.Alert{Status: "resolved", Labels: template.KV{"alertname": "DiskFull", "instance": "b"}}
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "incident_task", map[string]string{"incident": "1"}).Return([]Incident{
+		{"sys_id": "11", "correlation_id": alertFingerprint(resolved), "state": "1"},
+	}, nil)
+	snClientMock.On("CreateIncident", "incident_task", Incident{
+		"short_description": "a",
+		"incident":          "1",
+		"correlation_id":    alertFingerprint(firing),
+	}).Return(Incident{"sys_id": "12"}, nil)
+	snClientMock.On("UpdateIncident", "incident_task", Incident{"state": "3"}, "11").Return(Incident{}, nil)
+
+	syncChildRecords(context.Background(), Incident{"sys_id": "1", "number": "INC1"}, template.Data{Alerts: template.Alerts{firing, resolved}})
+	snClientMock.AssertExpectations(t)
+}
+
+func TestSyncChildRecords_Error(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Workflow.ChildRecords = ChildRecordsConfig{Enabled: true}
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "incident_task", mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", "incident_task", mock.Anything).Return(Incident{}, errors.New("Error"))
+	before := testutil.ToFloat64(childRecordErrors)
+
+	syncChildRecords(context.Background(), Incident{"sys_id": "1"}, template.Data{Alerts: template.Alerts{{Status: "firing"}}})
+	if got := testutil.ToFloat64(childRecordErrors) - before; got != 1 {
+		t.Errorf("The failed child record should be counted: got %v", got)
+	}
+
+	currentConfig().Workflow.ChildRecords.Enabled = false
+	syncChildRecords(context.Background(), Incident{"sys_id": "1"}, template.Data{Alerts: template.Alerts{{Status: "firing"}}})
+	if len(snClientMock.Calls) != 2 {
+		t.Errorf("No child record should be managed when disabled: %v", snClientMock.Calls)
+	}
+}
+
+func TestChildRecordsConfig_Validate(t *testing.T) {
+	var errs strings.Builder
+	ChildRecordsConfig{Enabled: true}.validate(true, &errs)
+	if !strings.Contains(errs.String(), "workflow.child_records cannot be used with workflow.incident_per_alert") {
+		t.Errorf("Missing validation error: %q", errs.String())
+	}
+}
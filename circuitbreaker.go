@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	circuitClosed   = 0
+	circuitOpen     = 1
+	circuitHalfOpen = 2
+
+	defaultCircuitOpenDuration = 30 * time.Second
+)
+
+var (
+	// circuitBreakers are the circuit breakers of the ServiceNow instances, by instance label
+	circuitBreakers      = map[string]*circuitBreaker{}
+	circuitBreakersMutex sync.Mutex
+
+	serviceNowCircuitState = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "servicenow_circuit_breaker_state",
+			Help: "State of the circuit breaker of the ServiceNow instance: 0 closed, 1 open, 2 half-open.",
+		},
+		[]string{"instance"},
+	)
+)
+
+// CircuitBreakerConfig - Circuit breaker failing the ServiceNow requests fast while the instance is down
+type CircuitBreakerConfig struct {
+	FailureThreshold int           `yaml:"failure_threshold"`
+	OpenDuration     time.Duration `yaml:"open_duration"`
+}
+
+func (c CircuitBreakerConfig) validate(errs *strings.Builder) {
+	if c.FailureThreshold < 0 {
+		errs.WriteString("service_now.circuit_breaker.failure_threshold must not be negative\n")
+	}
+	if c.OpenDuration < 0 {
+		errs.WriteString("service_now.circuit_breaker.open_duration must not be negative\n")
+	}
+}
+
+func (c CircuitBreakerConfig) enabled() bool {
+	return c.FailureThreshold > 0
+}
+
+func (c CircuitBreakerConfig) openDuration() time.Duration {
+	if c.OpenDuration <= 0 {
+		return defaultCircuitOpenDuration
+	}
+	return c.OpenDuration
+}
+
+// circuitBreaker opens after the configured number of consecutive failed requests, failing the requests fast.
+// Once open for the open duration, it lets a single probe request through (half-open): the circuit is closed again
+// when the probe succeeds, and re-opened otherwise.
+type circuitBreaker struct {
+	mutex    sync.Mutex
+	config   CircuitBreakerConfig
+	instance string
+	state    int
+	failures int
+	openedAt time.Time
+	now      func() time.Time
+}
+
+// instanceCircuitBreaker returns the circuit breaker of the ServiceNow instance, updated with the config. It is kept
+// across the rebuilds of the client on config reloads and Vault credential refreshes, so that an open circuit stays
+// open.
+func instanceCircuitBreaker(instance string, c CircuitBreakerConfig) *circuitBreaker {
+	circuitBreakersMutex.Lock()
+	defer circuitBreakersMutex.Unlock()
+	if b, ok := circuitBreakers[instance]; ok {
+		b.mutex.Lock()
+		b.config = c
+		b.mutex.Unlock()
+		return b
+	}
+	b := newCircuitBreaker(instance, c)
+	circuitBreakers[instance] = b
+	return b
+}
+
+func newCircuitBreaker(instance string, c CircuitBreakerConfig) *circuitBreaker {
+	b := &circuitBreaker{config: c, instance: instance, now: time.Now}
+	serviceNowCircuitState.WithLabelValues(instance).Set(circuitClosed)
+	return b
+}
+
+func (b *circuitBreaker) setState(state int) {
+	b.state = state
+	serviceNowCircuitState.WithLabelValues(b.instance).Set(float64(state))
+}
+
+// allow returns an overload error when the request must fail fast, telling when the circuit will be probed again
+func (b *circuitBreaker) allow() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	switch b.state {
+	case circuitOpen:
+		remaining := b.config.openDuration() - b.now().Sub(b.openedAt)
+		if remaining > 0 {
+			return &overloadError{
+				message:    fmt.Sprintf("The circuit breaker of ServiceNow instance %s is open", b.instance),
+				retryAfter: remaining,
+			}
+		}
+		baseLogger.Infof("Probing ServiceNow instance %s, the circuit breaker is half-open", b.instance)
+		b.setState(circuitHalfOpen)
+		return nil
+	case circuitHalfOpen:
+		// The probe request is in flight
+		return &overloadError{
+			message:    fmt.Sprintf("The circuit breaker of ServiceNow instance %s is half-open", b.instance),
+			retryAfter: b.config.openDuration(),
+		}
+	default:
+		return nil
+	}
+}
+
+// record records the result of an allowed request. A request which did not complete, e.g. cancelled by the caller,
+// is neither a success nor a failure, but ends the probe.
+func (b *circuitBreaker) record(completed bool, success bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	switch {
+	case !completed:
+		if b.state == circuitHalfOpen {
+			b.setState(circuitOpen)
+		}
+	case success:
+		if b.state != circuitClosed {
+			baseLogger.Infof("ServiceNow instance %s is available again, the circuit breaker is closed", b.instance)
+		}
+		b.failures = 0
+		b.setState(circuitClosed)
+	default:
+		b.failures++
+		if b.state == circuitHalfOpen || b.failures >= b.config.FailureThreshold {
+			if b.state == circuitClosed {
+				baseLogger.Warnf("The circuit breaker of ServiceNow instance %s is open after %d consecutive failures", b.instance, b.failures)
+			}
+			b.openedAt = b.now()
+			b.setState(circuitOpen)
+		}
+	}
+}
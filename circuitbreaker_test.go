@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	b := newCircuitBreaker("breaker", CircuitBreakerConfig{FailureThreshold: 2, OpenDuration: time.Minute})
+	b.now = func() time.Time { return now }
+
+	b.record(true, false)
+	b.record(true, true)
+	b.record(true, false)
+	if err := b.allow(); err != nil {
+		t.Fatalf("Non consecutive failures should not open the circuit: %v", err)
+	}
+	b.record(true, false)
+	err := b.allow()
+	if overload, ok := err.(*overloadError); !ok || overload.retryAfter != time.Minute {
+		t.Fatalf("Circuit should be open: %v", err)
+	}
+	if state := testutil.ToFloat64(serviceNowCircuitState.WithLabelValues("breaker")); state != circuitOpen {
+		t.Errorf("Unexpected state metric: %v", state)
+	}
+
+	// Failed probe
+	now = now.Add(time.Minute)
+	if err := b.allow(); err != nil {
+		t.Fatalf("Circuit should be probed: %v", err)
+	}
+	if err := b.allow(); err == nil {
+		t.Fatalf("A single probe should be let through")
+	}
+	b.record(true, false)
+	if err := b.allow(); err == nil {
+		t.Fatalf("Circuit should be re-opened by the failed probe")
+	}
+
+	// Cancelled, then successful probe
+	now = now.Add(time.Minute)
+	b.allow()
+	b.record(false, false)
+	now = now.Add(time.Minute)
+	b.allow()
+	b.record(true, true)
+	if err := b.allow(); err != nil {
+		t.Errorf("Circuit should be closed by the successful probe: %v", err)
+	}
+	if state := testutil.ToFloat64(serviceNowCircuitState.WithLabelValues("breaker")); state != circuitClosed {
+		t.Errorf("Unexpected state metric: %v", state)
+	}
+}
+
+func TestDoRequest_CircuitBreaker(t *testing.T) {
+	var requests int32
+	status := int32(http.StatusInternalServerError)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer ts.Close()
+
+	snClient, _ := NewServiceNowClient("instancename", "username", "password")
+	snClient.baseURL = ts.URL
+	snClient.breaker = newCircuitBreaker("instancename", CircuitBreakerConfig{FailureThreshold: 2, OpenDuration: time.Minute})
+
+	for i := 0; i < 4; i++ {
+		snClient.GetIncidents(context.Background(), "incident", nil)
+	}
+	if requests != 2 {
+		t.Errorf("Requests should fail fast once the circuit is open: got %d requests, want 2", requests)
+	}
+
+	// Client errors do not open the circuit
+	atomic.StoreInt32(&status, http.StatusBadRequest)
+	snClient.breaker = newCircuitBreaker("instancename", CircuitBreakerConfig{FailureThreshold: 1})
+	snClient.GetIncidents(context.Background(), "incident", nil)
+	if _, err := snClient.GetIncidents(context.Background(), "incident", nil); err == nil || strings.Contains(err.Error(), "circuit breaker") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestWebhookHandler_CircuitOpen(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	q, cleanup := newTestDeadLetterQueue(t)
+	defer cleanup()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, &overloadError{message: "The circuit breaker is open", retryAfter: 10 * time.Second})
+
+	rr := postAlertGroup(t, "test/alertmanager_firing.json")
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") != "10" {
+		t.Errorf("Unexpected response: status %v, Retry-After %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	if entries, _ := q.list(); len(entries) != 0 {
+		t.Errorf("Alert group retried by Alertmanager should not be dead-lettered, got %d entries", len(entries))
+	}
+}
+
+func TestNewSnClient_KeepsCircuitBreaker(t *testing.T) {
+	c := ServiceNowConfig{InstanceName: "reloaded", UserName: "username", Password: "password", CircuitBreaker: CircuitBreakerConfig{FailureThreshold: 1}}
+	client, err := newSnClient(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.(*ServiceNowClient).breaker.record(true, false)
+
+	c.Password = "rotated"
+	client, err = newSnClient(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.(*ServiceNowClient).breaker.allow(); err == nil {
+		t.Errorf("The open circuit breaker should be kept by the rebuilt client")
+	}
+}
+
+func TestCircuitBreakerConfig_Validate(t *testing.T) {
+	var errs strings.Builder
+	CircuitBreakerConfig{FailureThreshold: -1, OpenDuration: -1}.validate(&errs)
+	if !strings.Contains(errs.String(), "failure_threshold") || !strings.Contains(errs.String(), "open_duration") {
+		t.Errorf("Unexpected validation errors: %q", errs.String())
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/alertmanager/pkg/labels"
+	"github.com/prometheus/alertmanager/template"
+)
+
+const (
+	categoryField    = "category"
+	subcategoryField = "subcategory"
+)
+
+// ClassificationConfig - Incident category and subcategory set by the first rule whose label matchers all match the
+// alert group, such as alertname=~"Disk.*", or else by the default classification
+type ClassificationConfig struct {
+	Rules   []ClassificationRuleConfig `yaml:"rules"`
+	Default IncidentClassification     `yaml:"default"`
+}
+
+// ClassificationRuleConfig - Classification of the alert groups matching all the label matchers
+type ClassificationRuleConfig struct {
+	Matchers               []string `yaml:"matchers"`
+	IncidentClassification `yaml:",inline"`
+}
+
+// IncidentClassification - Incident category and subcategory, an empty one keeping the templated field
+type IncidentClassification struct {
+	Category    string `yaml:"category"`
+	Subcategory string `yaml:"subcategory"`
+}
+
+// classificationRule is a rule of the classification, with its parsed matchers
+type classificationRule struct {
+	matchers       []*labels.Matcher
+	classification IncidentClassification
+}
+
+// classification holds the compiled classification rules of the incident mapping
+type classification struct {
+	rules          []classificationRule
+	defaultFields  IncidentClassification
+	defaultEnabled bool
+}
+
+func (c ClassificationConfig) validate(errs *strings.Builder) {
+	for i, rule := range c.Rules {
+		if len(rule.Matchers) == 0 {
+			errs.WriteString(fmt.Sprintf("classification.rules[%d].matchers is missing\n", i))
+		}
+		for _, matcher := range rule.Matchers {
+			if _, err := labels.ParseMatcher(matcher); err != nil {
+				errs.WriteString(fmt.Sprintf("classification.rules[%d] matcher %s is invalid: %v\n", i, matcher, err))
+			}
+		}
+		if rule.IncidentClassification == (IncidentClassification{}) {
+			errs.WriteString(fmt.Sprintf("classification.rules[%d] needs a category or a subcategory\n", i))
+		}
+	}
+}
+
+// newClassification compiles the rules validated with the config
+func newClassification(c ClassificationConfig) classification {
+	compiled := classification{defaultFields: c.Default, defaultEnabled: c.Default != (IncidentClassification{})}
+	for _, rule := range c.Rules {
+		compiled.rules = append(compiled.rules, classificationRule{matchers: parseMatchers(rule.Matchers), classification: rule.IncidentClassification})
+	}
+	return compiled
+}
+
+// matches tells whether the labels of the alert group, the common ones or else the ones of its first alert having
+// them, match all the matchers of the rule
+func (r classificationRule) matches(data template.Data) bool {
+	for _, m := range r.matchers {
+		if !m.Matches(fieldMapping{source: fieldMappingLabel, name: m.Name}.value(data)) {
+			return false
+		}
+	}
+	return true
+}
+
+// classify returns the classification of the first rule matching the alert group, or else the default one. It also
+// returns false when none applies.
+func (c classification) classify(data template.Data) (IncidentClassification, bool) {
+	for _, rule := range c.rules {
+		if rule.matches(data) {
+			return rule.classification, true
+		}
+	}
+	return c.defaultFields, c.defaultEnabled
+}
+
+// applyClassification sets the incident category and subcategory of the alert group, overriding the templated ones
+func applyClassification(ctx context.Context, c classification, incident Incident, data template.Data) {
+	result, ok := c.classify(data)
+	if !ok {
+		return
+	}
+	if len(result.Category) > 0 {
+		incident[categoryField] = result.Category
+	}
+	if len(result.Subcategory) > 0 {
+		incident[subcategoryField] = result.Subcategory
+	}
+	loggerFrom(ctx).Debugf("Incident classified with category %q and subcategory %q", result.Category, result.Subcategory)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+func TestApplyClassification(t *testing.T) {
+	c := newClassification(ClassificationConfig{
+		Rules: []ClassificationRuleConfig{
+			{Matchers: []string{`alertname=~"Disk.*"`}, IncidentClassification: IncidentClassification{Category: "Hardware", Subcategory: "Storage"}},
+			{Matchers: []string{`job="blackbox"`}, IncidentClassification: IncidentClassification{Category: "Network"}},
+		},
+		Default: IncidentClassification{Category: "Software"},
+	})
+	for _, test := range []struct {
+		data        template.Data
+		category    string
+		subcategory string
+	}{
+		{template.Data{CommonLabels: template.KV{"alertname": "DiskFull", "job": "blackbox"}}, "Hardware", "Storage"},
+		{template.Data{CommonLabels: template.KV{"alertname": "ProbeFailed"}, Alerts: template.Alerts{{Labels: template.KV{"job": "blackbox"}}}}, "Network", "Templated"},
+		{template.Data{CommonLabels: template.KV{"alertname": "HighLatency"}}, "Software", "Templated"},
+	} {
+		incident := Incident{categoryField: "Templated", subcategoryField: "Templated"}
+		applyClassification(context.Background(), c, incident, test.data)
+		if incident[categoryField] != test.category || incident[subcategoryField] != test.subcategory {
+			t.Errorf("Wrong classification of %v: %v", test.data.CommonLabels, incident)
+		}
+	}
+
+	incident := Incident{categoryField: "Templated"}
+	applyClassification(context.Background(), newClassification(ClassificationConfig{}), incident, template.Data{})
+	if incident[categoryField] != "Templated" {
+		t.Errorf("The templated category should be kept without classification: %v", incident)
+	}
+}
+
+func TestClassificationConfig_Validate(t *testing.T) {
+	var errs strings.Builder
+	ClassificationConfig{Rules: []ClassificationRuleConfig{{}, {Matchers: []string{"alertname=~("}, IncidentClassification: IncidentClassification{Category: "Hardware"}}}}.validate(&errs)
+	for _, want := range []string{"classification.rules[0].matchers is missing", "classification.rules[0] needs a category or a subcategory", "classification.rules[1] matcher alertname=~( is invalid"} {
+		if !strings.Contains(errs.String(), want) {
+			t.Errorf("Missing validation error %q: %q", want, errs.String())
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// clientCertificate presents the client certificate of the TLS configuration, reloaded from its files once they are
+// rotated on disk. The files are checked on each TLS handshake: the connections already established keep the
+// certificate they were opened with.
+type clientCertificate struct {
+	certFile string
+	keyFile  string
+
+	mutex   sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// newClientCertificate loads the client certificate and key files
+func newClientCertificate(certFile string, keyFile string) (*clientCertificate, error) {
+	c := &clientCertificate{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(c.lastModified()); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// lastModified returns the latest modification time of the certificate and key files, zero when unknown
+func (c *clientCertificate) lastModified() time.Time {
+	var modTime time.Time
+	for _, file := range []string{c.certFile, c.keyFile} {
+		if info, err := os.Stat(file); err == nil && info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	return modTime
+}
+
+func (c *clientCertificate) reload(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("error loading the client certificate: %v", err)
+	}
+	c.cert = &cert
+	c.modTime = modTime
+	return nil
+}
+
+// getClientCertificate returns the client certificate, reloading it if its files changed. The previous certificate is
+// kept when the new one cannot be loaded, e.g. while only one of the files was rotated yet.
+func (c *clientCertificate) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if modTime := c.lastModified(); !modTime.Equal(c.modTime) {
+		if err := c.reload(modTime); err != nil {
+			baseLogger.Warnf("Keeping the previous client certificate of %s: %v", c.certFile, err)
+		} else {
+			baseLogger.Infof("Reloaded the client certificate of %s", c.certFile)
+		}
+	}
+	return c.cert, nil
+}
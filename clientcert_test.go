@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestClientCertificate_Reload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "servicenow-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile, _ := writeSelfSignedCert(t, dir)
+	c, err := newClientCertificate(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := c.getClientCertificate(nil)
+
+	writeSelfSignedCert(t, dir)
+	rotated := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, rotated, rotated)
+	second, _ := c.getClientCertificate(nil)
+	if bytes.Equal(first.Certificate[0], second.Certificate[0]) {
+		t.Errorf("The rotated client certificate should be reloaded")
+	}
+
+	ioutil.WriteFile(keyFile, []byte("invalid"), 0600)
+	rotated = rotated.Add(time.Minute)
+	os.Chtimes(keyFile, rotated, rotated)
+	if third, err := c.getClientCertificate(nil); err != nil || third != second {
+		t.Errorf("The previous client certificate should be kept when the new one is invalid: %v", err)
+	}
+}
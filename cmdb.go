@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+const (
+	ciTable                     = "cmdb_ci"
+	ciRelationshipTable         = "cmdb_rel_ci"
+	defaultImpactCIField        = "cmdb_ci"
+	defaultImpactMaxDepth       = 3
+	defaultImpactServiceClasses = "cmdb_ci_service"
+	defaultCILookupField        = "name"
+)
+
+var (
+	sysIDRegexp     = regexp.MustCompile("^[0-9a-f]{32}$")
+	ciCache         = newLookupCache("ci", defaultLookupCacheTTL)
+	ciLookupCache   = newLookupCache("ci_lookup", defaultLookupCacheTTL)
+	ciParentsCache  = newLookupCache("ci_parents", defaultLookupCacheTTL)
+	ciParentsFields = strings.Join([]string{"parent.sys_id", "parent.name", "parent.sys_class_name"}, ",")
+)
+
+// ImpactAnalysisConfig - Configuration of the impacted business services lookup in the CMDB
+type ImpactAnalysisConfig struct {
+	Field          string   `yaml:"field"`
+	CIField        string   `yaml:"ci_field"`
+	MaxDepth       int      `yaml:"max_depth"`
+	ServiceClasses []string `yaml:"service_classes"`
+}
+
+// CILookupConfig - Configuration of the incident configuration item lookup in the CMDB by an alert label
+type CILookupConfig struct {
+	Label     string `yaml:"label"`
+	Field     string `yaml:"field"`
+	DefaultCI string `yaml:"default_ci"`
+}
+
+func (c CILookupConfig) field() string {
+	if len(c.Field) == 0 {
+		return defaultCILookupField
+	}
+	return c.Field
+}
+
+type ciParent struct {
+	sysID     string
+	name      string
+	className string
+}
+
+// resolveCI returns the sys_id of the CI, given either as a sys_id or as a name
+func resolveCI(ctx context.Context, ci string) (string, error) {
+	if sysIDRegexp.MatchString(ci) {
+		return ci, nil
+	}
+	return lookupSysID(ctx, ciCache, ciTable, "name", ci)
+}
+
+// getCIParents returns the CIs directly depending on the given CI in the CMDB relationships
+func getCIParents(ctx context.Context, sysID string) ([]ciParent, error) {
+	if parents, ok := ciParentsCache.get(instanceKey(ctx, sysID)); ok {
+		return parents.([]ciParent), nil
+	}
+
+	relationships, err := serviceNowFrom(ctx).GetIncidents(ctx, ciRelationshipTable, map[string]string{
+		"child":          sysID,
+		"sysparm_fields": ciParentsFields,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	parents := make([]ciParent, 0, len(relationships))
+	for _, relationship := range relationships {
+		parent := ciParent{}
+		parent.sysID, _ = relationship["parent.sys_id"].(string)
+		parent.name, _ = relationship["parent.name"].(string)
+		parent.className, _ = relationship["parent.sys_class_name"].(string)
+		if len(parent.sysID) > 0 {
+			parents = append(parents, parent)
+		}
+	}
+	ciParentsCache.set(instanceKey(ctx, sysID), parents)
+	return parents, nil
+}
+
+// impactedServices returns the names of the business services depending on the CI, up to the configured depth.
+// When a CMDB query fails, the services found so far are returned along with the error.
+func impactedServices(ctx context.Context, c ImpactAnalysisConfig, ci string) ([]string, error) {
+	sysID, err := resolveCI(ctx, ci)
+	if err != nil || len(sysID) == 0 {
+		return nil, err
+	}
+
+	maxDepth := c.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = defaultImpactMaxDepth
+	}
+	serviceClasses := map[string]bool{}
+	for _, class := range c.ServiceClasses {
+		serviceClasses[class] = true
+	}
+	if len(serviceClasses) == 0 {
+		serviceClasses[defaultImpactServiceClasses] = true
+	}
+
+	var services []string
+	visited := map[string]bool{sysID: true}
+	level := []string{sysID}
+	for depth := 0; depth < maxDepth && len(level) > 0; depth++ {
+		var next []string
+		for _, child := range level {
+			parents, err := getCIParents(ctx, child)
+			if err != nil {
+				return services, err
+			}
+			for _, parent := range parents {
+				if visited[parent.sysID] {
+					continue
+				}
+				visited[parent.sysID] = true
+				next = append(next, parent.sysID)
+				if serviceClasses[parent.className] {
+					services = append(services, parent.name)
+				}
+			}
+		}
+		level = next
+	}
+	return services, nil
+}
+
+// labelValue returns the value of the label, common to the alert group or else found in its first alert having it
+func labelValue(label string, data template.Data) string {
+	if value := strings.TrimSpace(data.CommonLabels[label]); len(value) > 0 {
+		return value
+	}
+	for _, alert := range data.Alerts {
+		if value := strings.TrimSpace(alert.Labels[label]); len(value) > 0 {
+			return value
+		}
+	}
+	return ""
+}
+
+// applyCILookup sets the incident CI with the sys_id of the CI whose configured field matches the configured label of
+// the alert group. When no CI is found, the default CI is used if any, otherwise the templated CI is kept.
+func applyCILookup(ctx context.Context, incident Incident, data template.Data) {
+	config := configFrom(ctx)
+	c := config.CILookup
+	if len(c.Label) == 0 {
+		return
+	}
+
+	value := labelValue(c.Label, data)
+	if len(value) == 0 {
+		setDefaultCI(c, incident)
+		return
+	}
+
+	sysID, err := lookupSysID(ctx, ciLookupCache, ciTable, c.field(), value)
+	if err != nil {
+		serviceNowError.Inc()
+		loggerFrom(ctx).Errorf("Error looking up the CI with %s %s: %v", c.field(), value, err)
+		setDefaultCI(c, incident)
+		return
+	}
+	if len(sysID) == 0 {
+		loggerFrom(ctx).Warnf("No CI found with %s %s", c.field(), value)
+		setDefaultCI(c, incident)
+		return
+	}
+	incident[defaultImpactCIField] = sysID
+}
+
+func setDefaultCI(c CILookupConfig, incident Incident) {
+	if len(c.DefaultCI) > 0 {
+		incident[defaultImpactCIField] = c.DefaultCI
+	}
+}
+
+// applyImpactAnalysis sets the configured incident field with the business services impacted by the incident CI
+func applyImpactAnalysis(ctx context.Context, incident Incident) {
+	config := configFrom(ctx)
+	c := config.ImpactAnalysis
+	if len(c.Field) == 0 {
+		return
+	}
+
+	ciField := c.CIField
+	if len(ciField) == 0 {
+		ciField = defaultImpactCIField
+	}
+	ci, _ := incident[ciField].(string)
+	if len(ci) == 0 {
+		return
+	}
+
+	services, err := impactedServices(ctx, c, ci)
+	if err != nil {
+		serviceNowError.Inc()
+		loggerFrom(ctx).Errorf("Error looking up the business services impacted by CI %s: %v", ci, err)
+	}
+	if len(services) > 0 {
+		incident[c.Field] = strings.Join(services, ", ")
+	}
+}
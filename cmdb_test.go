@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/mock"
+)
+
+var (
+	webCISysID = strings.Repeat("a", 32)
+	appCISysID = strings.Repeat("b", 32)
+)
+
+func mockCMDB(snClientMock *MockedSnClient) {
+	snClientMock.On("GetIncidents", "cmdb_ci", map[string]string{"name": "web01", "sysparm_fields": "sys_id", "sysparm_limit": "1"}).Return([]Incident{Incident{"sys_id": webCISysID}}, nil)
+	snClientMock.On("GetIncidents", "cmdb_rel_ci", map[string]string{"child": webCISysID, "sysparm_fields": ciParentsFields}).Return([]Incident{
+		Incident{"parent.sys_id": appCISysID, "parent.name": "payments-app", "parent.sys_class_name": "cmdb_ci_appl"},
+		Incident{"parent.sys_id": "s1", "parent.name": "Checkout", "parent.sys_class_name": "cmdb_ci_service"},
+	}, nil)
+	snClientMock.On("GetIncidents", "cmdb_rel_ci", map[string]string{"child": appCISysID, "sysparm_fields": ciParentsFields}).Return([]Incident{
+		Incident{"parent.sys_id": "s2", "parent.name": "Payments", "parent.sys_class_name": "cmdb_ci_service"},
+		// Cycle back to an already visited CI
+		Incident{"parent.sys_id": webCISysID, "parent.name": "web01", "parent.sys_class_name": "cmdb_ci_server"},
+	}, nil)
+	snClientMock.On("GetIncidents", "cmdb_rel_ci", mock.Anything).Return([]Incident{}, nil)
+}
+
+func resetCMDBCaches() {
+	ciCache = newLookupCache("ci", time.Minute)
+	ciParentsCache = newLookupCache("ci_parents", time.Minute)
+	ciLookupCache = newLookupCache("ci_lookup", time.Minute)
+}
+
+func TestImpactedServices_Depth(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxDepth int
+		want     []string
+	}{
+		{name: "depth_1", maxDepth: 1, want: []string{"Checkout"}},
+		{name: "default_depth", maxDepth: 0, want: []string{"Checkout", "Payments"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetCMDBCaches()
+			snClientMock := new(MockedSnClient)
+			useServiceNow(snClientMock)
+			mockCMDB(snClientMock)
+
+			got, err := impactedServices(context.Background(), ImpactAnalysisConfig{MaxDepth: tt.maxDepth}, "web01")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Unexpected impacted services: got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImpactedServices_Cached(t *testing.T) {
+	resetCMDBCaches()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	mockCMDB(snClientMock)
+
+	for i := 0; i < 2; i++ {
+		if _, err := impactedServices(context.Background(), ImpactAnalysisConfig{MaxDepth: 1}, webCISysID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snClientMock.AssertNumberOfCalls(t, "GetIncidents", 1)
+}
+
+func TestApplyImpactAnalysis(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().ImpactAnalysis = ImpactAnalysisConfig{Field: "u_impacted_services"}
+	resetCMDBCaches()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	mockCMDB(snClientMock)
+
+	incident := Incident{"cmdb_ci": "web01"}
+	applyImpactAnalysis(context.Background(), incident)
+	if incident["u_impacted_services"] != "Checkout, Payments" {
+		t.Errorf("Unexpected impacted services: got %v, want %v", incident["u_impacted_services"], "Checkout, Payments")
+	}
+}
+
+func TestApplyImpactAnalysis_QueryError(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().ImpactAnalysis = ImpactAnalysisConfig{Field: "u_impacted_services"}
+	resetCMDBCaches()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, errors.New("Error"))
+
+	incident := Incident{"cmdb_ci": webCISysID}
+	applyImpactAnalysis(context.Background(), incident)
+	if _, ok := incident["u_impacted_services"]; ok {
+		t.Errorf("Impacted services field should be omitted on CMDB error, got %v", incident["u_impacted_services"])
+	}
+}
+
+func TestApplyImpactAnalysis_Disabled(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+
+	applyImpactAnalysis(context.Background(), Incident{"cmdb_ci": "web01"})
+	snClientMock.AssertNotCalled(t, "GetIncidents", mock.Anything, mock.Anything)
+}
+
+func TestApplyCILookup(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().CILookup = CILookupConfig{Label: "instance", Field: "fqdn", DefaultCI: "default"}
+	resetCMDBCaches()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "cmdb_ci", map[string]string{"fqdn": "web01.example.com", "sysparm_fields": "sys_id", "sysparm_limit": "1"}).Return([]Incident{Incident{"sys_id": webCISysID}}, nil)
+	snClientMock.On("GetIncidents", "cmdb_ci", mock.Anything).Return([]Incident{}, nil)
+
+	data := template.Data{Alerts: template.Alerts{template.Alert{Labels: template.KV{"instance": "web01.example.com"}}}}
+	for i := 0; i < 2; i++ {
+		incident := Incident{"cmdb_ci": "templated"}
+		applyCILookup(context.Background(), incident, data)
+		if incident["cmdb_ci"] != webCISysID {
+			t.Errorf("Unexpected CI: %v", incident["cmdb_ci"])
+		}
+	}
+	snClientMock.AssertNumberOfCalls(t, "GetIncidents", 1)
+
+	incident := Incident{}
+	applyCILookup(context.Background(), incident, template.Data{CommonLabels: template.KV{"instance": "unknown"}})
+	if incident["cmdb_ci"] != "default" {
+		t.Errorf("Default CI should be used when no CI is found: %v", incident["cmdb_ci"])
+	}
+
+	currentConfig().CILookup.DefaultCI = ""
+	incident = Incident{"cmdb_ci": "templated"}
+	applyCILookup(context.Background(), incident, template.Data{CommonLabels: template.KV{"instance": "unknown"}})
+	if incident["cmdb_ci"] != "templated" {
+		t.Errorf("Templated CI should be kept when no CI is found: %v", incident["cmdb_ci"])
+	}
+}
+
+func TestApplyCILookup_QueryError(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().CILookup = CILookupConfig{Label: "instance", DefaultCI: "default"}
+	resetCMDBCaches()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, errors.New("Error"))
+
+	incident := Incident{}
+	applyCILookup(context.Background(), incident, template.Data{CommonLabels: template.KV{"instance": "web01"}})
+	if incident["cmdb_ci"] != "default" {
+		t.Errorf("Default CI should be used on CMDB error: %v", incident["cmdb_ci"])
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// unsupportedEncodingError is returned when a request body is compressed with an encoding other than gzip
+type unsupportedEncodingError struct {
+	encoding string
+}
+
+func (e *unsupportedEncodingError) Error() string {
+	return fmt.Sprintf("unsupported Content-Encoding %q, only gzip is supported", e.encoding)
+}
+
+// decompressRequestBody replaces the gzip compressed request body with its decompressed copy of at most maxSize bytes,
+// returning a bodyTooLargeError when it is larger. A maxSize of 0 disables the limit.
+func decompressRequestBody(r *http.Request, maxSize int64) error {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	switch encoding {
+	case "", "identity":
+		return nil
+	case "gzip", "x-gzip":
+	default:
+		return &unsupportedEncodingError{encoding: encoding}
+	}
+
+	reader, err := gzip.NewReader(r.Body)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	var source io.Reader = reader
+	if maxSize > 0 {
+		source = io.LimitReader(reader, maxSize+1)
+	}
+	body, err := ioutil.ReadAll(source)
+	if err != nil {
+		return err
+	}
+	if maxSize > 0 && int64(len(body)) > maxSize {
+		return &bodyTooLargeError{maxSize: maxSize}
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r.Header.Del("Content-Encoding")
+	r.ContentLength = int64(len(body))
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+)
+
+func gzipBody(t *testing.T, body string) []byte {
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	if _, err := w.Write([]byte(body)); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	return compressed.Bytes()
+}
+
+func TestDecompressRequestBody(t *testing.T) {
+	req := httptest.NewRequest("POST", "/webhook", bytes.NewReader(gzipBody(t, "0123456789")))
+	req.Header.Set("Content-Encoding", "gzip")
+	if err := decompressRequestBody(req, 10); err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := ioutil.ReadAll(req.Body); string(body) != "0123456789" || req.ContentLength != 10 {
+		t.Errorf("Unexpected body: %q", body)
+	}
+
+	req = httptest.NewRequest("POST", "/webhook", bytes.NewReader(gzipBody(t, "0123456789")))
+	req.Header.Set("Content-Encoding", "gzip")
+	if _, ok := decompressRequestBody(req, 9).(*bodyTooLargeError); !ok {
+		t.Errorf("The body larger than the limit once decompressed should be rejected")
+	}
+
+	req = httptest.NewRequest("POST", "/webhook", bytes.NewReader([]byte("0123456789")))
+	if err := decompressRequestBody(req, 1); err != nil {
+		t.Errorf("The uncompressed body should be left as is: %v", err)
+	}
+	req.Header.Set("Content-Encoding", "br")
+	if _, ok := decompressRequestBody(req, 0).(*unsupportedEncodingError); !ok {
+		t.Errorf("The unsupported encoding should be rejected")
+	}
+}
+
+func TestWebhookHandler_Gzip(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	dedupStore = newMemoryDedupStore()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{"sys_id": "1", "number": "INC1"}, nil)
+
+	req := httptest.NewRequest("POST", "/webhook", bytes.NewReader(gzipBody(t, twoAlertsNotification)))
+	req.Header.Set("Content-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(webhook).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Wrong status code: got %v, want %v", rr.Code, http.StatusOK)
+	}
+	snClientMock.AssertExpectations(t)
+
+	req = httptest.NewRequest("POST", "/webhook", bytes.NewReader([]byte(twoAlertsNotification)))
+	req.Header.Set("Content-Encoding", "gzip")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(webhook).ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("The invalid gzip body should be rejected: got %v", rr.Code)
+	}
+
+	size := *maxDecompressedSize
+	defer func() { *maxDecompressedSize = size }()
+	*maxDecompressedSize = 64
+	req = httptest.NewRequest("POST", "/webhook", bytes.NewReader(gzipBody(t, twoAlertsNotification)))
+	req.Header.Set("Content-Encoding", "gzip")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(webhook).ServeHTTP(rr, req)
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Wrong status code: got %v, want %v", rr.Code, http.StatusRequestEntityTooLarge)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var serviceNowConcurrencyLimitWait = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Name: "servicenow_concurrency_limit_wait_seconds_total",
+		Help: "Total time spent by the ServiceNow requests waiting for a slot of the outbound concurrency limit.",
+	},
+)
+
+func validateMaxConcurrentRequests(c ServiceNowConfig, errs *strings.Builder) {
+	if c.MaxConcurrentRequests < 0 {
+		errs.WriteString("service_now.max_concurrent_requests must not be negative\n")
+	}
+}
+
+// concurrencyLimitedTransport bounds the number of requests in flight, shared by all the handlers and workers using
+// the client. A slot is held until the response body is closed, since the connection is busy until then.
+type concurrencyLimitedTransport struct {
+	next  http.RoundTripper
+	slots chan struct{}
+}
+
+func newConcurrencyLimitedTransport(next http.RoundTripper, maxConcurrentRequests int) *concurrencyLimitedTransport {
+	return &concurrencyLimitedTransport{next: next, slots: make(chan struct{}, maxConcurrentRequests)}
+}
+
+func (t *concurrencyLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	select {
+	case t.slots <- struct{}{}:
+	default:
+		start := time.Now()
+		select {
+		case t.slots <- struct{}{}:
+			serviceNowConcurrencyLimitWait.Add(time.Since(start).Seconds())
+		case <-req.Context().Done():
+			serviceNowConcurrencyLimitWait.Add(time.Since(start).Seconds())
+			return nil, req.Context().Err()
+		}
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		<-t.slots
+		return nil, err
+	}
+	resp.Body = &slotReleasingBody{ReadCloser: resp.Body, release: func() { <-t.slots }}
+	return resp, nil
+}
+
+// slotReleasingBody releases the slot of its request once closed
+type slotReleasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *slotReleasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewHTTPClient_MaxConcurrentRequests(t *testing.T) {
+	var inFlight, maxInFlight int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	client, err := newHTTPClient(ServiceNowConfig{MaxConcurrentRequests: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(server.URL)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+	if maxInFlight != 2 {
+		t.Errorf("Unexpected maximum number of requests in flight: got %d, want 2", maxInFlight)
+	}
+}
+
+func TestConcurrencyLimitedTransport_WaitCancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	transport := newConcurrencyLimitedTransport(http.DefaultTransport, 1)
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	if _, err := client.Do(req.WithContext(ctx)); err == nil {
+		t.Errorf("The request should not wait for a slot once its context is done")
+	}
+
+	resp.Body.Close()
+	resp.Body.Close()
+	if len(transport.slots) != 0 {
+		t.Errorf("The slot should be released once, when the response body is closed: %d slots in use", len(transport.slots))
+	}
+	if resp, err := client.Get(server.URL); err != nil {
+		t.Errorf("The released slot should be reused: %v", err)
+	} else {
+		resp.Body.Close()
+	}
+}
+
+func TestValidateMaxConcurrentRequests(t *testing.T) {
+	var errs strings.Builder
+	validateMaxConcurrentRequests(ServiceNowConfig{MaxConcurrentRequests: -1}, &errs)
+	if !strings.Contains(errs.String(), "service_now.max_concurrent_requests") {
+		t.Errorf("Unexpected validation errors: %q", errs.String())
+	}
+}
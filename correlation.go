@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+// CorrelationKeyConfig - Labels participating in the alert group key, so that volatile labels can be ignored
+type CorrelationKeyConfig struct {
+	IncludeLabels []string `yaml:"include_labels"`
+	ExcludeLabels []string `yaml:"exclude_labels"`
+}
+
+func (c CorrelationKeyConfig) validate(errs *strings.Builder) {
+	if len(c.IncludeLabels) > 0 && len(c.ExcludeLabels) > 0 {
+		errs.WriteString("correlation_key.include_labels and correlation_key.exclude_labels are mutually exclusive\n")
+	}
+}
+
+func (c CorrelationKeyConfig) configured() bool {
+	return len(c.IncludeLabels) > 0 || len(c.ExcludeLabels) > 0
+}
+
+// filter returns the labels participating in the correlation key: the included ones if configured, otherwise all
+// the labels but the excluded ones
+func (c CorrelationKeyConfig) filter(labels template.KV) template.KV {
+	if !c.configured() {
+		return labels
+	}
+
+	filtered := template.KV{}
+	if len(c.IncludeLabels) > 0 {
+		for _, name := range c.IncludeLabels {
+			if value, ok := labels[name]; ok {
+				filtered[name] = value
+			}
+		}
+		return filtered
+	}
+
+	excluded := make(map[string]bool, len(c.ExcludeLabels))
+	for _, name := range c.ExcludeLabels {
+		excluded[name] = true
+	}
+	for name, value := range labels {
+		if !excluded[name] {
+			filtered[name] = value
+		}
+	}
+	return filtered
+}
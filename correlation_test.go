@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCorrelationKeyFilter(t *testing.T) {
+	labels := template.KV{"alertname": "a", "namespace": "ns", "pod": "web-5d8f9"}
+	tests := []struct {
+		name   string
+		config CorrelationKeyConfig
+		want   template.KV
+	}{
+		{name: "all", config: CorrelationKeyConfig{}, want: labels},
+		{name: "include", config: CorrelationKeyConfig{IncludeLabels: []string{"alertname", "namespace", "missing"}}, want: template.KV{"alertname": "a", "namespace": "ns"}},
+		{name: "exclude", config: CorrelationKeyConfig{ExcludeLabels: []string{"pod"}}, want: template.KV{"alertname": "a", "namespace": "ns"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.config.filter(labels); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Unexpected labels: got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCorrelationKeyConfig_Validate(t *testing.T) {
+	var errs strings.Builder
+	CorrelationKeyConfig{IncludeLabels: []string{"a"}, ExcludeLabels: []string{"b"}}.validate(&errs)
+	if errs.Len() == 0 {
+		t.Errorf("Include and exclude labels should be mutually exclusive")
+	}
+}
+
+func TestOnAlertGroup_ExcludedLabelsDedup(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Workflow.CorrelationKey = CorrelationKeyConfig{ExcludeLabels: []string{"pod"}}
+	dedupStore = newMemoryDedupStore()
+	snClient := new(statefulSnClient)
+	useServiceNow(snClient)
+	snClient.On("GetIncidents", "incident", mock.Anything).Return([]Incident{}, nil)
+	snClient.On("CreateIncident", "incident", mock.Anything).Return(Incident{}, nil)
+	snClient.On("UpdateIncident", "incident", mock.Anything, "1").Return(Incident{}, nil)
+
+	for _, pod := range []string{"web-5d8f9", "web-7c4b2"} {
+		data := template.Data{
+			Status:      "firing",
+			GroupLabels: template.KV{"alertname": "PodCrashLooping", "pod": pod},
+			Alerts:      template.Alerts{template.Alert{Status: "firing", Labels: template.KV{"alertname": "PodCrashLooping", "pod": pod}}},
+		}
+		if err := onAlertGroup(context.Background(), data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snClient.AssertNumberOfCalls(t, "CreateIncident", 1)
+	snClient.AssertNumberOfCalls(t, "UpdateIncident", 1)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// envVarReference matches the ${NAME} references to environment variables in the config
+var envVarReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnvVars replaces the ${NAME} references of the config with the value of the environment variables.
+// A reference to an undefined variable is an error, rather than silently expanded to an empty value.
+func expandEnvVars(configData []byte) ([]byte, error) {
+	var undefined []string
+	expanded := envVarReference.ReplaceAllFunc(configData, func(reference []byte) []byte {
+		name := string(envVarReference.FindSubmatch(reference)[1])
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			undefined = append(undefined, name)
+		}
+		return []byte(value)
+	})
+	if len(undefined) > 0 {
+		return nil, fmt.Errorf("undefined environment variable(s) referenced in the config: %s", strings.Join(undefined, ", "))
+	}
+	return expanded, nil
+}
+
+// loadCredentialFiles reads the credentials of the ServiceNow instances and of the archive set with a file
+func loadCredentialFiles(c *Config) error {
+	if err := c.ServiceNow.loadCredentialFiles("service_now"); err != nil {
+		return err
+	}
+	for name, instance := range c.Instances {
+		if err := instance.loadCredentialFiles(fmt.Sprintf("instances.%s", name)); err != nil {
+			return err
+		}
+		c.Instances[name] = instance
+	}
+	return readCredentialFile(&c.Archive.S3.SecretAccessKey, c.Archive.S3.SecretAccessKeyFile, "archive.s3.secret_access_key")
+}
+
+func (c *ServiceNowConfig) loadCredentialFiles(prefix string) error {
+	if err := readCredentialFile(&c.Password, c.PasswordFile, prefix+".password"); err != nil {
+		return err
+	}
+	if err := c.loadHeaderFiles(prefix); err != nil {
+		return err
+	}
+	return readCredentialFile(&c.OAuth2.ClientSecret, c.OAuth2.ClientSecretFile, prefix+".oauth2.client_secret")
+}
+
+// readCredentialFile sets the credential with the content of the file, without its trailing line break
+func readCredentialFile(credential *string, file string, name string) error {
+	if len(file) == 0 {
+		return nil
+	}
+	if len(*credential) > 0 {
+		return fmt.Errorf("%s and %s_file are mutually exclusive", name, name)
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("error reading %s_file: %v", name, err)
+	}
+	*credential = strings.TrimRight(string(content), "\r\n")
+	return nil
+}
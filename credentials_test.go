@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const credentialsConfig = `
+service_now:
+ instance_name: "${TEST_SERVICENOW_INSTANCE}"
+ user_name: "SA"
+ password_file: "%s"
+workflow:
+ incident_group_key_field: "u_other_reference_1"
+`
+
+func writeCredentialFile(t *testing.T, dir string, content string) string {
+	file := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestParseConfig_Credentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := writeCredentialFile(t, dir, "s3cr3t\n")
+	os.Setenv("TEST_SERVICENOW_INSTANCE", "instance")
+	defer os.Unsetenv("TEST_SERVICENOW_INSTANCE")
+
+	c, err := parseConfig([]byte(strings.Replace(credentialsConfig, "%s", file, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ServiceNow.InstanceName != "instance" || c.ServiceNow.Password != "s3cr3t" {
+		t.Errorf("Unexpected ServiceNow config: %+v", c.ServiceNow)
+	}
+
+	os.Setenv("SERVICENOW_PASSWORD", "from env")
+	defer os.Unsetenv("SERVICENOW_PASSWORD")
+	c, err = parseConfig([]byte(strings.Replace(credentialsConfig, "%s", file, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ServiceNow.Password != "from env" {
+		t.Errorf("The environment variable should override the password file, got %q", c.ServiceNow.Password)
+	}
+}
+
+func TestParseConfig_Credentials_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{"undefined variable", strings.Replace(credentialsConfig, "%s", "/dev/null", 1), "TEST_SERVICENOW_INSTANCE"},
+		{"missing file", strings.Replace(strings.Replace(credentialsConfig, "${TEST_SERVICENOW_INSTANCE}", "instance", 1), "%s", "/nonexistent", 1), "error reading service_now.password_file"},
+		{"password and file", strings.Replace(strings.Replace(credentialsConfig, "${TEST_SERVICENOW_INSTANCE}", "instance", 1), "%s\"", "/dev/null\"\n password: \"SA!\"", 1), "mutually exclusive"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseConfig([]byte(test.config))
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("Unexpected error: got %v, want %q", err, test.want)
+			}
+		})
+	}
+}
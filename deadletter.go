@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const deadLetterExtension = ".json"
+
+var (
+	// deadLetters is nil when the dead-letter queue is disabled
+	deadLetters *deadLetterQueue
+
+	deadLettersWritten = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "webhook_dead_letters_total",
+			Help: "Total number of alert groups whose processing failed, persisted to the dead-letter queue.",
+		},
+	)
+	deadLetterQueueSize = promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "webhook_dead_letter_queue_size",
+			Help: "Number of alert groups of the dead-letter queue, waiting to be replayed.",
+		},
+		func() float64 {
+			if deadLetters == nil {
+				return 0
+			}
+			return float64(deadLetters.size())
+		},
+	)
+	deadLettersReplayed = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_dead_letters_replayed_total",
+			Help: "Total number of dead-letter queue entries replayed, by result.",
+		},
+		[]string{"result"},
+	)
+)
+
+// DeadLetterConfig - Persistence of the alert groups whose processing failed, to replay them later
+type DeadLetterConfig struct {
+	Directory string `yaml:"directory"`
+	// AcceptWhenUnavailable answers with a 202 the alert groups failing while ServiceNow is unavailable, persisted to
+	// be replayed automatically instead of being retried by Alertmanager
+	AcceptWhenUnavailable bool          `yaml:"accept_when_unavailable"`
+	ReplayInterval        time.Duration `yaml:"replay_interval"`
+}
+
+func (c DeadLetterConfig) validate(errs *strings.Builder) {
+	if c.AcceptWhenUnavailable && len(c.Directory) == 0 {
+		errs.WriteString("dead_letter.accept_when_unavailable requires dead_letter.directory\n")
+	}
+	if c.ReplayInterval < 0 {
+		errs.WriteString("dead_letter.replay_interval must not be negative\n")
+	}
+}
+
+// deadLetter is an entry of the dead-letter queue
+type deadLetter struct {
+	ID       string        `json:"id"`
+	Time     time.Time     `json:"time"`
+	Error    string        `json:"error"`
+	Attempts int           `json:"attempts"`
+	Data     template.Data `json:"data"`
+	// WebhookReceiver is the webhook receiver of the alert group, empty for /webhook
+	WebhookReceiver string `json:"webhook_receiver,omitempty"`
+	// Accepted is set on the alert groups answered with a 202 while ServiceNow was unavailable, replayed automatically
+	Accepted bool `json:"accepted,omitempty"`
+}
+
+// deadLetterSummary describes an entry of the dead-letter queue, without its alert group
+type deadLetterSummary struct {
+	ID       string    `json:"id"`
+	Time     time.Time `json:"time"`
+	Error    string    `json:"error"`
+	Attempts int       `json:"attempts"`
+	Receiver string    `json:"receiver"`
+	GroupKey string    `json:"group_key"`
+	Status   string    `json:"status"`
+	Alerts   int       `json:"alerts"`
+
+	WebhookReceiver string `json:"webhook_receiver,omitempty"`
+	Accepted        bool   `json:"accepted,omitempty"`
+}
+
+// deadLetterQueue persists each entry as a JSON file of the directory, named after its ID
+type deadLetterQueue struct {
+	directory string
+	mutex     sync.Mutex
+}
+
+func newDeadLetterQueue(directory string) (*deadLetterQueue, error) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, fmt.Errorf("Error creating the dead-letter queue directory: %v", err)
+	}
+	return &deadLetterQueue{directory: directory}, nil
+}
+
+// loadDeadLetterQueue opens the dead-letter queue directory, when configured
+func loadDeadLetterQueue(config Config) (*deadLetterQueue, error) {
+	deadLetters = nil
+	if len(config.DeadLetter.Directory) == 0 {
+		return nil, nil
+	}
+	q, err := newDeadLetterQueue(config.DeadLetter.Directory)
+	if err != nil {
+		return nil, err
+	}
+	deadLetters = q
+	baseLogger.Infof("Dead-letter queue stored in %s", q.directory)
+	return q, nil
+}
+
+func newDeadLetterID(now time.Time) string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%d-%x", now.UnixNano(), suffix)
+}
+
+func (q *deadLetterQueue) path(id string) (string, error) {
+	if len(id) == 0 || strings.ContainsAny(id, `/\.`) {
+		return "", fmt.Errorf("Invalid dead-letter ID %q", id)
+	}
+	return filepath.Join(q.directory, id+deadLetterExtension), nil
+}
+
+// add persists the alert group of the webhook receiver with the error of its processing. When the same alert group is
+// already dead-lettered, e.g. delivered again by Alertmanager, the error and attempts of its entry are updated instead.
+func (q *deadLetterQueue) add(webhookReceiver string, data template.Data, processingErr error) (string, error) {
+	content, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	ids, err := q.list()
+	if err != nil {
+		return "", err
+	}
+	for _, id := range ids {
+		entry, err := q.read(id)
+		if err != nil || entry.Accepted || entry.WebhookReceiver != webhookReceiver {
+			continue
+		}
+		if existing, err := json.Marshal(entry.Data); err != nil || !bytes.Equal(existing, content) {
+			continue
+		}
+		entry.Error = processingErr.Error()
+		entry.Attempts++
+		return entry.ID, q.write(entry)
+	}
+
+	now := time.Now()
+	entry := deadLetter{ID: newDeadLetterID(now), Time: now, Error: processingErr.Error(), Attempts: 1, Data: data, WebhookReceiver: webhookReceiver}
+	if err := q.write(entry); err != nil {
+		return "", err
+	}
+	deadLettersWritten.Inc()
+	return entry.ID, nil
+}
+
+func (q *deadLetterQueue) addEntry(entry deadLetter) (string, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if err := q.write(entry); err != nil {
+		return "", err
+	}
+	deadLettersWritten.Inc()
+	return entry.ID, nil
+}
+
+// write replaces the file of the entry through a temporary file, so that an entry is never read partially written
+func (q *deadLetterQueue) write(entry deadLetter) error {
+	path, err := q.path(entry.ID)
+	if err != nil {
+		return err
+	}
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (q *deadLetterQueue) read(id string) (deadLetter, error) {
+	var entry deadLetter
+	path, err := q.path(id)
+	if err != nil {
+		return entry, err
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return entry, err
+	}
+	err = json.Unmarshal(content, &entry)
+	return entry, err
+}
+
+// list returns the IDs of the entries, oldest first
+func (q *deadLetterQueue) list() ([]string, error) {
+	files, err := ioutil.ReadDir(q.directory)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, file := range files {
+		if name := file.Name(); !file.IsDir() && strings.HasSuffix(name, deadLetterExtension) {
+			ids = append(ids, strings.TrimSuffix(name, deadLetterExtension))
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// size returns the number of entries, 0 when the directory cannot be read
+func (q *deadLetterQueue) size() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	ids, _ := q.list()
+	return len(ids)
+}
+
+func (q *deadLetterQueue) summaries(ctx context.Context) ([]deadLetterSummary, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	ids, err := q.list()
+	if err != nil {
+		return nil, err
+	}
+	summaries := []deadLetterSummary{}
+	for _, id := range ids {
+		entry, err := q.read(id)
+		if err != nil {
+			baseLogger.Errorf("Error reading dead-letter %s: %v", id, err)
+			continue
+		}
+		summaries = append(summaries, deadLetterSummary{
+			ID:       entry.ID,
+			Time:     entry.Time,
+			Error:    entry.Error,
+			Attempts: entry.Attempts,
+			Receiver: entry.Data.Receiver,
+			GroupKey: getGroupKey(ctx, entry.Data),
+			Status:   entry.Data.Status,
+			Alerts:   len(entry.Data.Alerts),
+
+			WebhookReceiver: entry.WebhookReceiver,
+			Accepted:        entry.Accepted,
+		})
+	}
+	return summaries, nil
+}
+
+// replay processes the alert group of the entry again. The entry is removed when it succeeds, and its error and
+// attempts are updated when it fails again.
+func (q *deadLetterQueue) replay(ctx context.Context, id string) error {
+	q.mutex.Lock()
+	entry, err := q.read(id)
+	q.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	ctx = withConfigSnapshot(ctx)
+	ctx = withLogger(ctx, baseLogger.With("dead_letter", id).With("group_key", getGroupKey(ctx, entry.Data)))
+	ctx = withReceiver(ctx, entry.WebhookReceiver)
+	err = onAlertGroup(ctx, entry.Data)
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if err != nil {
+		deadLettersReplayed.WithLabelValues("failure").Inc()
+		entry.Error = err.Error()
+		entry.Attempts++
+		if writeErr := q.write(entry); writeErr != nil {
+			loggerFrom(ctx).Errorf("Error updating dead-letter: %v", writeErr)
+		}
+		return err
+	}
+
+	deadLettersReplayed.WithLabelValues("success").Inc()
+	path, _ := q.path(id)
+	return os.Remove(path)
+}
+
+// deadLetterFailedDelivery persists the alert group whose delivery failed, unless it is answered with a status code that
+// Alertmanager retries (5xx): each retry would add an entry, and replaying it later could send an old firing alert
+// group again after it resolved
+func deadLetterFailedDelivery(ctx context.Context, status int, data template.Data, processingErr error) {
+	if status >= 500 {
+		return
+	}
+	deadLetterAlertGroup(ctx, data, processingErr)
+}
+
+// deadLetterAlertGroup persists the alert group whose processing failed, when the dead-letter queue is enabled
+func deadLetterAlertGroup(ctx context.Context, data template.Data, processingErr error) {
+	if deadLetters == nil || isDryRun(ctx) {
+		return
+	}
+	id, err := deadLetters.add(receiverFrom(ctx), data, processingErr)
+	if err != nil {
+		loggerFrom(ctx).Errorf("Error persisting the alert group to the dead-letter queue, it is lost: %v", err)
+		return
+	}
+	loggerFrom(ctx).Warnf("Alert group persisted to the dead-letter queue as %s", id)
+}
+
+type deadLetterReplayResult struct {
+	ID    string `json:"id"`
+	Error string `json:"error,omitempty"`
+}
+
+// deadLettersHandler is the handler of /-/dead-letters, listing the entries on GET requests
+func deadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdminRequest(w, r, http.MethodGet) {
+		return
+	}
+	summaries, err := deadLetters.summaries(withConfigSnapshot(r.Context()))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error listing the dead-letters: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, summaries)
+}
+
+// replayDeadLettersHandler is the handler of /-/dead-letters/replay, replaying on POST requests the entry of the id
+// parameter, or all the entries without it
+func replayDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdminRequest(w, r, http.MethodPost) {
+		return
+	}
+	if !leader.isLeader() {
+		http.Error(w, "Standby replica, the dead-letters are replayed by the leader", http.StatusServiceUnavailable)
+		return
+	}
+	ids := r.URL.Query()["id"]
+	if len(ids) == 0 {
+		deadLetters.mutex.Lock()
+		var err error
+		ids, err = deadLetters.list()
+		deadLetters.mutex.Unlock()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error listing the dead-letters: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	results := []deadLetterReplayResult{}
+	for _, id := range ids {
+		result := deadLetterReplayResult{ID: id}
+		ctx := withAuditCaller(context.Background(), auditCaller{Source: "dead-letter replay", RemoteAddr: r.RemoteAddr})
+		if err := deadLetters.replay(ctx, id); os.IsNotExist(err) {
+			result.Error = "not found"
+		} else if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	writeJSON(w, results)
+}
+
+// authorizeAdminRequest checks the method and the authentication of a request on an admin endpoint of the
+// dead-letter queue, and answers it when rejected
+func authorizeAdminRequest(w http.ResponseWriter, r *http.Request, method string) bool {
+	if !authorizeRequest(w, r, method) {
+		return false
+	}
+	if deadLetters == nil {
+		http.Error(w, "The dead-letter queue is disabled", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// authorizeRequest checks the method and the authentication of a request on an admin endpoint, and answers it when
+// rejected
+func authorizeRequest(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		http.Error(w, fmt.Sprintf("Only %s requests allowed", method), http.StatusMethodNotAllowed)
+		return false
+	}
+	if !configFrom(r.Context()).Webhook.authenticate(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		baseLogger.Errorf("Error writing JSON response: %s", err)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/mock"
+)
+
+func newTestDeadLetterQueue(t *testing.T) (*deadLetterQueue, func()) {
+	directory, err := ioutil.TempDir("", "deadletters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := newDeadLetterQueue(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadLetters = q
+	return q, func() {
+		deadLetters = nil
+		os.RemoveAll(directory)
+	}
+}
+
+var deadLetterData = template.Data{
+	Receiver:    "servicenow",
+	Status:      "firing",
+	GroupLabels: template.KV{"alertname": "DiskFull"},
+	Alerts:      template.Alerts{template.Alert{Status: "firing", Labels: template.KV{"alertname": "DiskFull"}}},
+}
+
+func TestDeadLetterQueue_Replay(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	q, cleanup := newTestDeadLetterQueue(t)
+	defer cleanup()
+
+	id, err := q.add("", deadLetterData, errors.New("ServiceNow returned the HTTP error code: 500"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failingMock := new(MockedSnClient)
+	useServiceNow(failingMock)
+	failingMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, errors.New("still failing"))
+	if err := q.replay(context.Background(), id); err == nil {
+		t.Fatal("Replay should fail")
+	}
+	summaries, err := q.summaries(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 1 || summaries[0].Attempts != 2 || summaries[0].Error != "still failing" || summaries[0].GroupKey != getGroupKey(context.Background(), deadLetterData) {
+		t.Errorf("Unexpected dead-letters: %+v", summaries)
+	}
+
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{"sys_id": "1", "number": "INC1"}, nil)
+	if err := q.replay(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+	snClientMock.AssertNumberOfCalls(t, "CreateIncident", 1)
+	if ids, _ := q.list(); len(ids) != 0 {
+		t.Errorf("Replayed dead-letter should be removed: %v", ids)
+	}
+}
+
+func TestDeadLetterQueue_InvalidID(t *testing.T) {
+	q, cleanup := newTestDeadLetterQueue(t)
+	defer cleanup()
+	for _, id := range []string{"", "../config", "a/b"} {
+		if _, err := q.read(id); err == nil || os.IsNotExist(err) {
+			t.Errorf("ID %q should be rejected: %v", id, err)
+		}
+	}
+}
+
+func TestWebhookHandler_DeadLetter(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Webhook.ErrorStatusCodes.Permanent = http.StatusBadRequest
+	q, cleanup := newTestDeadLetterQueue(t)
+	defer cleanup()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{}, &httpStatusError{statusCode: http.StatusBadRequest, message: "ServiceNow returned the HTTP error code: 400"})
+
+	if rr := postAlertGroup(t, "test/alertmanager_firing.json"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("Wrong status code: got %v, want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(deadLettersHandler).ServeHTTP(rr, httptest.NewRequest("GET", "/-/dead-letters", nil))
+	var summaries []deadLetterSummary
+	if err := json.Unmarshal(rr.Body.Bytes(), &summaries); err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 1 || summaries[0].Error != "ServiceNow returned the HTTP error code: 400" {
+		t.Fatalf("Unexpected dead-letters: %+v", summaries)
+	}
+
+	replayedMock := new(MockedSnClient)
+	useServiceNow(replayedMock)
+	replayedMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+	replayedMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{"sys_id": "1", "number": "INC1"}, nil)
+
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(replayDeadLettersHandler).ServeHTTP(rr, httptest.NewRequest("POST", "/-/dead-letters/replay", nil))
+	var results []deadLetterReplayResult
+	if err := json.Unmarshal(rr.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].ID != summaries[0].ID || len(results[0].Error) > 0 {
+		t.Errorf("Unexpected replay results: %+v", results)
+	}
+	if ids, _ := q.list(); len(ids) != 0 {
+		t.Errorf("Replayed dead-letter should be removed: %v", ids)
+	}
+}
+
+func TestWebhookHandler_DeadLetterOnce(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	q, cleanup := newTestDeadLetterQueue(t)
+	defer cleanup()
+	dedupStore = newMemoryDedupStore()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{}, &httpStatusError{statusCode: http.StatusInternalServerError, message: "ServiceNow returned the HTTP error code: 500"}).Twice()
+
+	for i := 0; i < 2; i++ {
+		if rr := postAlertGroup(t, "test/alertmanager_firing.json"); rr.Code != http.StatusInternalServerError {
+			t.Fatalf("Wrong status code: got %v, want %v", rr.Code, http.StatusInternalServerError)
+		}
+	}
+	if ids, _ := q.list(); len(ids) != 0 {
+		t.Fatalf("The alert group retried by Alertmanager should not be dead-lettered: %v", ids)
+	}
+
+	currentConfig().Webhook.ErrorStatusCodes.Permanent = http.StatusBadRequest
+	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{}, &httpStatusError{statusCode: http.StatusBadRequest, message: "ServiceNow returned the HTTP error code: 400"})
+	for i := 0; i < 2; i++ {
+		if rr := postAlertGroup(t, "test/alertmanager_firing.json"); rr.Code != http.StatusBadRequest {
+			t.Fatalf("Wrong status code: got %v, want %v", rr.Code, http.StatusBadRequest)
+		}
+	}
+	summaries, err := q.summaries(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 1 || summaries[0].Attempts != 2 {
+		t.Errorf("The same failing alert group should be dead-lettered once: %+v", summaries)
+	}
+}
+
+func TestDeadLettersHandler_Disabled(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	deadLetters = nil
+
+	tests := []struct {
+		name       string
+		method     string
+		wantStatus int
+	}{
+		{name: "disabled", method: "GET", wantStatus: http.StatusNotFound},
+		{name: "method", method: "DELETE", wantStatus: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(deadLettersHandler).ServeHTTP(rr, httptest.NewRequest(tt.method, "/-/dead-letters", nil))
+			if rr.Code != tt.wantStatus {
+				t.Errorf("deadLettersHandler() status = %v, want %v", rr.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestDeadLetterQueue_Size(t *testing.T) {
+	q, cleanup := newTestDeadLetterQueue(t)
+	defer cleanup()
+	q.add("", deadLetterData, errors.New("Error"))
+	q.add("", template.Data{Receiver: "servicenow", Status: "resolved"}, errors.New("Error"))
+	if size := testutil.ToFloat64(deadLetterQueueSize); size != 2 {
+		t.Errorf("webhook_dead_letter_queue_size = %v, want 2", size)
+	}
+}
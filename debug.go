@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+)
+
+const debugPathPrefix = "/debug/pprof/"
+
+// debugHandler serves the pprof profiling endpoints: the index of the profiles on /debug/pprof/, each profile (e.g.
+// /debug/pprof/heap or /debug/pprof/goroutine) and the CPU profile and execution trace
+func debugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(debugPathPrefix, pprof.Index)
+	mux.HandleFunc(debugPathPrefix+"cmdline", pprof.Cmdline)
+	mux.HandleFunc(debugPathPrefix+"profile", pprof.Profile)
+	mux.HandleFunc(debugPathPrefix+"symbol", pprof.Symbol)
+	mux.HandleFunc(debugPathPrefix+"trace", pprof.Trace)
+	return mux
+}
+
+// withoutDebugEndpoints answers the pprof endpoints with a 404, as importing net/http/pprof registers them on the
+// default mux served by the webhook listener, so that they are only served by the admin listener
+func withoutDebugEndpoints(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, debugPathPrefix) {
+			http.NotFound(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// startDebugServer serves the debug endpoints on the admin listener address. It returns nil when no address is set.
+// The server has no write timeout, the CPU profile and the execution trace lasting the requested duration.
+func startDebugServer(address string) (*http.Server, error) {
+	if len(address) == 0 {
+		return nil, nil
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	server := &http.Server{Handler: debugHandler(), ReadTimeout: *readTimeout}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			baseLogger.Errorf("Error serving the debug endpoints on %v: %v", address, err)
+		}
+	}()
+	baseLogger.Infof("Serving the debug endpoints on: %v", address)
+	return server, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDebugHandler(t *testing.T) {
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/goroutine?debug=1", "/debug/pprof/heap?debug=1", "/debug/pprof/cmdline"} {
+		rr := httptest.NewRecorder()
+		debugHandler().ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if rr.Code != http.StatusOK {
+			t.Errorf("Wrong status code for %s: got %v, want %v", path, rr.Code, http.StatusOK)
+		}
+	}
+}
+
+func TestWithoutDebugEndpoints(t *testing.T) {
+	handler := withoutDebugEndpoints(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/debug/pprof/heap", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("The debug endpoints should not be served: got %v, want %v", rr.Code, http.StatusNotFound)
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/-/healthy", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Wrong status code: got %v, want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestStartDebugServer(t *testing.T) {
+	if server, err := startDebugServer(""); server != nil || err != nil {
+		t.Errorf("No debug server should be started without address: %v, %v", server, err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	server, err := startDebugServer(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	resp, err := http.Get("http://" + address + "/debug/pprof/goroutine?debug=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "goroutine") {
+		t.Errorf("Unexpected goroutine profile: %v %s", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+const (
+	defaultDedupTTL       = time.Hour
+	defaultRedisKeyPrefix = "alertmanager_webhook_servicenow:"
+	// dedupPending is the value held by a group key while its incident is being created
+	dedupPending = "pending"
+)
+
+// DedupConfig - Incident deduplication store configuration
+type DedupConfig struct {
+	Enabled *bool         `yaml:"enabled"`
+	TTL     time.Duration `yaml:"ttl"`
+	Redis   RedisConfig   `yaml:"redis"`
+	// Stateless finds the incidents of the alert groups only by querying ServiceNow, without claiming their creation
+	// in the store, so that no store has to be shared or persisted
+	Stateless bool `yaml:"stateless"`
+}
+
+func (c DedupConfig) validate(errs *strings.Builder) {
+	c.Redis.validate("dedup.redis", errs)
+	if c.Stateless && c.Redis.enabled() {
+		errs.WriteString("dedup.stateless cannot be used with dedup.redis, whose incident creation claims it disables\n")
+	}
+}
+
+// RedisConfig - Redis server configuration
+type RedisConfig struct {
+	Addr      string `yaml:"addr"`
+	Password  string `yaml:"password"`
+	DB        int    `yaml:"db"`
+	KeyPrefix string `yaml:"key_prefix"`
+
+	SentinelMasterName string     `yaml:"sentinel_master_name"`
+	SentinelAddrs      []string   `yaml:"sentinel_addrs"`
+	SentinelPassword   string     `yaml:"sentinel_password"`
+	TLSConfig          *TLSConfig `yaml:"tls_config"`
+}
+
+// enabled returns whether firing alert groups update their existing incident rather than always creating a new one
+func (c DedupConfig) enabled() bool {
+	return c.Enabled == nil || *c.Enabled
+}
+
+func (c DedupConfig) ttl() time.Duration {
+	if c.TTL > 0 {
+		return c.TTL
+	}
+	return defaultDedupTTL
+}
+
+// DedupStore holds the mapping of alert group keys to the sys_id of the incident created for them
+type DedupStore interface {
+	Get(key string) (string, error)
+	Set(key string, value string, ttl time.Duration) error
+	SetIfAbsent(key string, value string, ttl time.Duration) (bool, error)
+	Delete(key string) error
+}
+
+type memoryDedupEntry struct {
+	value     string
+	expiresAt time.Time
+}
+
+// memoryDedupStore is an in-process DedupStore, only suitable when running a single replica
+type memoryDedupStore struct {
+	mutex   sync.Mutex
+	entries map[string]memoryDedupEntry
+}
+
+func newMemoryDedupStore() *memoryDedupStore {
+	return &memoryDedupStore{entries: map[string]memoryDedupEntry{}}
+}
+
+// get returns the entry value if it exists and is not expired. Mutex must be held.
+func (s *memoryDedupStore) get(key string) (string, bool) {
+	entry, ok := s.entries[key]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(s.entries, key)
+		return "", false
+	}
+	return entry.value, true
+}
+
+func (s *memoryDedupStore) Get(key string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	value, _ := s.get(key)
+	return value, nil
+}
+
+func (s *memoryDedupStore) Set(key string, value string, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.entries[key] = memoryDedupEntry{value: value, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *memoryDedupStore) SetIfAbsent(key string, value string, ttl time.Duration) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.get(key); ok {
+		return false, nil
+	}
+	s.entries[key] = memoryDedupEntry{value: value, expiresAt: time.Now().Add(ttl)}
+	return true, nil
+}
+
+func (s *memoryDedupStore) Delete(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+// sweep removes the expired entries
+func (s *memoryDedupStore) sweep() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	for key, entry := range s.entries {
+		if now.After(entry.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// redisDedupStore is a DedupStore shared by all replicas through a Redis server
+type redisDedupStore struct {
+	client    *redis.Client
+	keyPrefix string
+}
+
+func newRedisDedupStore(c RedisConfig) (*redisDedupStore, error) {
+	client, err := newRedisClient(c)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Ping().Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &redisDedupStore{client: client, keyPrefix: c.keyPrefix()}, nil
+}
+
+func (s *redisDedupStore) Get(key string) (string, error) {
+	value, err := s.client.Get(s.keyPrefix + key).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return value, err
+}
+
+func (s *redisDedupStore) Set(key string, value string, ttl time.Duration) error {
+	return s.client.Set(s.keyPrefix+key, value, ttl).Err()
+}
+
+func (s *redisDedupStore) SetIfAbsent(key string, value string, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(s.keyPrefix+key, value, ttl).Result()
+}
+
+func (s *redisDedupStore) Delete(key string) error {
+	return s.client.Del(s.keyPrefix + key).Err()
+}
+
+func loadDedupStore(config Config) (DedupStore, error) {
+	if len(*dedupBoltPath) > 0 {
+		if config.Dedup.Redis.enabled() {
+			return nil, errors.New("dedup.bolt-path cannot be used with the dedup.redis store")
+		}
+		store, err := newBoltDedupStore(*dedupBoltPath)
+		if err != nil {
+			return nil, err
+		}
+		backgroundTasks.Go("dedup store sweeper", func(ctx context.Context) {
+			runEvery(ctx, sweepInterval, func() {
+				if err := store.sweep(); err != nil {
+					baseLogger.Errorf("Error sweeping the BoltDB deduplication store: %v", err)
+				}
+			})
+		})
+		dedupStore = store
+		baseLogger.Infof("Using BoltDB deduplication store at %s", *dedupBoltPath)
+		return dedupStore, nil
+	}
+
+	if !config.Dedup.Redis.enabled() {
+		store := newMemoryDedupStore()
+		backgroundTasks.Go("dedup store sweeper", func(ctx context.Context) {
+			runEvery(ctx, sweepInterval, store.sweep)
+		})
+		dedupStore = store
+		baseLogger.Info("Using in-memory deduplication store")
+		return dedupStore, nil
+	}
+
+	store, err := newRedisDedupStore(config.Dedup.Redis)
+	if err != nil {
+		return nil, err
+	}
+	dedupStore = store
+	baseLogger.Infof("Using Redis deduplication store at %s", config.Dedup.Redis.address())
+	return dedupStore, nil
+}
+
+// claimIncidentCreation atomically claims the creation of the incident for the given group key.
+// When the creation was already claimed (e.g. by another replica), the sys_id of the incident created for
+// the group key is returned, or an empty string if its creation is still in progress.
+// A stored incident found in existingIncidents is known to be in a no-update state, it is then released and claimed again.
+func claimIncidentCreation(ctx context.Context, key string, existingIncidents []Incident) (bool, string, error) {
+	config := configFrom(ctx)
+	ttl := config.Dedup.ttl()
+	for attempt := 0; attempt < 2; attempt++ {
+		claimed, err := dedupStore.SetIfAbsent(key, dedupPending, ttl)
+		if err != nil || claimed {
+			return claimed, "", err
+		}
+
+		sysID, err := dedupStore.Get(key)
+		if err != nil {
+			return false, "", err
+		}
+		if len(sysID) == 0 {
+			// Expired in the meantime, claim it again
+			continue
+		}
+		if sysID == dedupPending {
+			return false, "", nil
+		}
+		if !containsIncident(existingIncidents, sysID) {
+			return false, sysID, nil
+		}
+
+		loggerFrom(ctx).Infof("Deduplicated incident %s for alert group key %s is not updatable anymore", sysID, key)
+		if err := dedupStore.Delete(key); err != nil {
+			return false, "", err
+		}
+	}
+	return false, "", nil
+}
+
+func containsIncident(incidents []Incident, sysID string) bool {
+	for _, incident := range incidents {
+		if id, ok := incident["sys_id"].(string); ok && id == sysID {
+			return true
+		}
+	}
+	return false
+}
+
+// createDedupIncident creates the incident for the group key once its creation is claimed in the deduplication store.
+// When the incident was already created for the group key (e.g. by another replica), it is updated instead.
+// The created incident is returned, or nil when none was created.
+func createDedupIncident(ctx context.Context, tableName string, key string, incidentCreateParam Incident, incidentUpdateParam Incident, existingIncidents []Incident) (Incident, error) {
+	config := configFrom(ctx)
+	if isDryRun(ctx) {
+		// The deduplication store is left untouched, as no incident is created
+		return createIncident(ctx, tableName, incidentCreateParam)
+	}
+	if config.Dedup.Stateless {
+		return upsertStatelessIncident(ctx, tableName, incidentCreateParam, incidentUpdateParam)
+	}
+	claimed, sysID, err := claimIncidentCreation(ctx, key, existingIncidents)
+	if err != nil {
+		return nil, err
+	}
+
+	if !claimed {
+		if len(sysID) == 0 {
+			// Retried by Alertmanager once the other request created the incident, updating it
+			return nil, &overloadError{message: fmt.Sprintf("The incident creation for alert group key %s is already in progress", key)}
+		}
+		loggerFrom(ctx).Infof("Found deduplicated incident with id %s for alert group key: %s", sysID, key)
+		_, err := serviceNowFrom(ctx).UpdateIncident(ctx, tableName, incidentUpdateParam, sysID)
+		countIncidentOperation(ctx, tableName, incidentUpdated, err)
+		if err == nil {
+			recordIncident(ctx, Incident{"sys_id": sysID})
+		}
+		return nil, err
+	}
+
+	incident, err := createIncident(ctx, tableName, incidentCreateParam)
+	countIncidentOperation(ctx, tableName, incidentCreated, err)
+	if err != nil {
+		if err := dedupStore.Delete(key); err != nil {
+			loggerFrom(ctx).Errorf("Error releasing the incident creation claim for alert group key %s: %v", key, err)
+		}
+		return nil, err
+	}
+
+	if sysID, ok := incident["sys_id"].(string); ok {
+		err = dedupStore.Set(key, sysID, config.Dedup.ttl())
+	} else {
+		err = dedupStore.Delete(key)
+	}
+	if err != nil {
+		loggerFrom(ctx).Errorf("Error storing the incident created for alert group key %s: %v", key, err)
+	}
+	return incident, nil
+}
+
+// upsertStatelessIncident queries the table for the open incident of the alert group by its correlation field right
+// before creating one, e.g. sysparm_query=correlation_id=<fingerprint> with workflow.incident_per_alert, so that the
+// incident created in the meantime by another replica is updated instead. The created incident is returned, or nil
+// when none was created.
+func upsertStatelessIncident(ctx context.Context, tableName string, incidentCreateParam Incident, incidentUpdateParam Incident) (Incident, error) {
+	field := groupKeyField(ctx, tableName)
+	value, _ := incidentCreateParam[field].(string)
+	incidents, err := serviceNowFrom(ctx).GetIncidents(ctx, tableName, map[string]string{
+		"sysparm_query": field + "=" + value,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if updatable := filterUpdatableIncidents(ctx, tableName, incidents); len(updatable) > 0 {
+		sysID := updatable[0].GetSysID()
+		loggerFrom(ctx).Infof("Found the open incident %s of %s %s, updating it instead of creating one", updatable[0].GetNumber(), field, value)
+		_, err := serviceNowFrom(ctx).UpdateIncident(ctx, tableName, incidentUpdateParam, sysID)
+		countIncidentOperation(ctx, tableName, incidentUpdated, err)
+		if err == nil {
+			recordIncident(ctx, Incident{"sys_id": sysID})
+		}
+		return nil, err
+	}
+
+	incident, err := createIncident(ctx, tableName, incidentCreateParam)
+	countIncidentOperation(ctx, tableName, incidentCreated, err)
+	if err != nil {
+		return nil, err
+	}
+	return incident, nil
+}
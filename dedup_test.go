@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/mock"
+)
+
+func testDedupStore(t *testing.T, store DedupStore) {
+	value, err := store.Get("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "" {
+		t.Errorf("Unexpected value for missing key: got %v, want empty", value)
+	}
+
+	claimed, err := store.SetIfAbsent("key", dedupPending, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !claimed {
+		t.Errorf("SetIfAbsent on missing key should succeed")
+	}
+
+	claimed, err = store.SetIfAbsent("key", "other", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimed {
+		t.Errorf("SetIfAbsent on existing key should fail")
+	}
+
+	if err := store.Set("key", "42", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	value, _ = store.Get("key")
+	if value != "42" {
+		t.Errorf("Unexpected value: got %v, want %v", value, "42")
+	}
+
+	if err := store.Delete("key"); err != nil {
+		t.Fatal(err)
+	}
+	value, _ = store.Get("key")
+	if value != "" {
+		t.Errorf("Unexpected value for deleted key: got %v, want empty", value)
+	}
+}
+
+func TestMemoryDedupStore(t *testing.T) {
+	testDedupStore(t, newMemoryDedupStore())
+}
+
+func TestMemoryDedupStore_Expiry(t *testing.T) {
+	store := newMemoryDedupStore()
+	store.Set("key", "42", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	claimed, _ := store.SetIfAbsent("key", dedupPending, time.Minute)
+	if !claimed {
+		t.Errorf("SetIfAbsent on expired key should succeed")
+	}
+}
+
+func TestRedisDedupStore(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	store, err := newRedisDedupStore(RedisConfig{Addr: s.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testDedupStore(t, store)
+}
+
+func TestRedisDedupStore_Expiry(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	store, err := newRedisDedupStore(RedisConfig{Addr: s.Addr(), KeyPrefix: "test:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Set("key", "42", time.Minute)
+	if !s.Exists("test:key") {
+		t.Errorf("Key should be stored with the configured prefix")
+	}
+
+	s.FastForward(2 * time.Minute)
+	claimed, _ := store.SetIfAbsent("key", dedupPending, time.Minute)
+	if !claimed {
+		t.Errorf("SetIfAbsent on expired key should succeed")
+	}
+}
+
+func TestNewRedisDedupStore_ConnectionError(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := s.Addr()
+	s.Close()
+
+	if _, err := newRedisDedupStore(RedisConfig{Addr: addr}); err == nil {
+		t.Errorf("Expected an error, got none")
+	}
+}
+
+func TestLoadDedupStore(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	loadConfig("config/servicenow_example.yml")
+	defer func() { dedupStore = newMemoryDedupStore() }()
+
+	store, err := loadDedupStore(*currentConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.(*memoryDedupStore); !ok {
+		t.Errorf("Unexpected store type: got %T, want *memoryDedupStore", store)
+	}
+
+	currentConfig().Dedup.Redis.Addr = s.Addr()
+	store, err = loadDedupStore(*currentConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.(*redisDedupStore); !ok {
+		t.Errorf("Unexpected store type: got %T, want *redisDedupStore", store)
+	}
+}
+
+func TestOnAlertGroup_Dedup_SharedByReplicas(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	defer func() { dedupStore = newMemoryDedupStore() }()
+
+	loadConfig("config/servicenow_example.yml")
+	body, err := ioutil.ReadFile("test/alertmanager_firing.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := template.Data{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		t.Fatal(err)
+	}
+
+	// First replica creates the incident
+	dedupStore, _ = newRedisDedupStore(RedisConfig{Addr: s.Addr()})
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{"state": "1", "number": "INC42", "sys_id": "42"}, nil)
+	if err := onAlertGroup(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+
+	// Second replica, not seeing the incident yet when querying ServiceNow, updates it instead of creating another one
+	dedupStore, _ = newRedisDedupStore(RedisConfig{Addr: s.Addr()})
+	snClientMock = new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{}, errors.New("Create should not be called"))
+	snClientMock.On("UpdateIncident", mock.Anything, mock.Anything, "42").Return(Incident{"state": "1", "number": "INC42", "sys_id": "42"}, nil)
+	if err := onAlertGroup(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+	snClientMock.AssertCalled(t, "UpdateIncident", mock.Anything, mock.Anything, "42")
+}
+
+func TestOnAlertGroup_Dedup_StaleIncident(t *testing.T) {
+	defer func() { dedupStore = newMemoryDedupStore() }()
+	loadConfig("config/servicenow_example.yml")
+	data := template.Data{Status: "firing", GroupLabels: template.KV{"alertname": "stale"}}
+	dedupStore = newMemoryDedupStore()
+	dedupStore.Set(getGroupKey(context.Background(), data), "42", time.Minute)
+
+	// Stored incident is resolved: a new incident must be created
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{Incident{"state": "6", "number": "INC42", "sys_id": "42"}}, nil)
+	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{"state": "1", "number": "INC43", "sys_id": "43"}, nil)
+	snClientMock.On("UpdateIncident", mock.Anything, mock.Anything, mock.Anything).Return(Incident{}, errors.New("Update should not be called"))
+	if err := onAlertGroup(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+
+	if value, _ := dedupStore.Get(getGroupKey(context.Background(), data)); value != "43" {
+		t.Errorf("Unexpected stored incident: got %v, want %v", value, "43")
+	}
+}
+
+func TestOnAlertGroup_Dedup_CreationInProgress(t *testing.T) {
+	defer func() { dedupStore = newMemoryDedupStore() }()
+	loadConfig("config/servicenow_example.yml")
+	data := template.Data{Status: "firing", GroupLabels: template.KV{"alertname": "in_progress"}}
+	dedupStore = newMemoryDedupStore()
+	dedupStore.Set(getGroupKey(context.Background(), data), dedupPending, time.Minute)
+
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+	if _, ok := onAlertGroup(context.Background(), data).(*overloadError); !ok {
+		t.Fatal("The alert group should be retried once the incident creation in progress completes")
+	}
+	snClientMock.AssertNotCalled(t, "CreateIncident", mock.Anything, mock.Anything)
+
+	body, err := ioutil.ReadFile("test/alertmanager_firing.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.Unmarshal(body, &data)
+	dedupStore.Set(getGroupKey(context.Background(), data), dedupPending, time.Minute)
+	if rr := postAlertGroup(t, "test/alertmanager_firing.json"); rr.Code != http.StatusServiceUnavailable || len(rr.Header().Get("Retry-After")) == 0 {
+		t.Errorf("The notification should be retried by Alertmanager: status %v, Retry-After %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+}
+
+func TestOnAlertGroup_Dedup_Stateless(t *testing.T) {
+	defer func() { dedupStore = newMemoryDedupStore() }()
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Dedup.Stateless = true
+	data := template.Data{Status: "firing", GroupLabels: template.KV{"alertname": "stateless"}}
+	dedupStore = newMemoryDedupStore()
+	dedupStore.Set(getGroupKey(context.Background(), data), dedupPending, time.Minute)
+
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", "incident", mock.Anything).Return(Incident{"sys_id": "1", "number": "INC1"}, nil)
+	if err := onAlertGroup(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+	snClientMock.AssertNumberOfCalls(t, "CreateIncident", 1)
+	if value, _ := dedupStore.Get(getGroupKey(context.Background(), data)); value != dedupPending {
+		t.Errorf("The creation should not be claimed in the store: %q", value)
+	}
+}
+
+func TestOnAlertGroup_Dedup_StatelessUpdate(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Dedup.Stateless = true
+	currentConfig().Workflow.IncidentPerAlert = true
+	data := template.Data{Status: "firing", Alerts: template.Alerts{{Status: "firing", Labels: template.KV{"alertname": "stateless"}, Fingerprint: "fp1"}}}
+
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	field := groupKeyField(context.Background(), "incident")
+	// The incident is created by another replica between the lookup of the alert group and its creation
+	snClientMock.On("GetIncidents", "incident", map[string]string{field: "fp1"}).Return([]Incident{}, nil)
+	snClientMock.On("GetIncidents", "incident", map[string]string{"sysparm_query": field + "=fp1"}).Return([]Incident{{"sys_id": "1", "number": "INC1", "state": "1"}}, nil)
+	snClientMock.On("UpdateIncident", "incident", mock.Anything, "1").Return(Incident{}, nil)
+	if err := onAlertGroup(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+	snClientMock.AssertNumberOfCalls(t, "UpdateIncident", 1)
+	snClientMock.AssertNotCalled(t, "CreateIncident", mock.Anything, mock.Anything)
+}
+
+func TestDedupConfig_Validate(t *testing.T) {
+	var errs strings.Builder
+	DedupConfig{Stateless: true, Redis: RedisConfig{Addr: "localhost:6379"}}.validate(&errs)
+	if !strings.Contains(errs.String(), "dedup.stateless cannot be used with dedup.redis") {
+		t.Errorf("Unexpected validation errors: %q", errs.String())
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+const dryRunNumber = "DRY-RUN"
+
+type dryRunContextKey struct{}
+
+// withDryRun returns a context whose incidents are only logged, without being written to ServiceNow
+func withDryRun(ctx context.Context) context.Context {
+	return withLogger(context.WithValue(ctx, dryRunContextKey{}, true), loggerFrom(ctx).With("dry_run", true))
+}
+
+func isDryRun(ctx context.Context) bool {
+	dryRun, _ := ctx.Value(dryRunContextKey{}).(bool)
+	return dryRun
+}
+
+// dryRunRequested returns whether the webhook request is processed in dry run, with the --dry-run flag or the
+// dry_run query parameter
+func dryRunRequested(r *http.Request) bool {
+	if *dryRun {
+		return true
+	}
+	requested, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	return requested
+}
+
+// dryRunClient logs the records that would be written to ServiceNow instead of writing them.
+// The reads are still sent to ServiceNow, so that existing incidents are updated rather than created, as they would be.
+type dryRunClient struct {
+	ServiceNow
+}
+
+func logDryRun(ctx context.Context, action string, tableName string, record Incident, sysID string) {
+	body, _ := json.Marshal(record)
+	if len(sysID) > 0 {
+		loggerFrom(ctx).Infof("Dry run, would %s record %s of table %s: %s", action, sysID, tableName, body)
+		return
+	}
+	loggerFrom(ctx).Infof("Dry run, would %s a record of table %s: %s", action, tableName, body)
+}
+
+// CreateIncident logs the record, and returns it without sys_id
+func (c dryRunClient) CreateIncident(ctx context.Context, tableName string, incidentParam Incident) (Incident, error) {
+	logDryRun(ctx, "create", tableName, incidentParam, "")
+	created := Incident{"sys_id": "", "number": dryRunNumber}
+	for field, value := range incidentParam {
+		created[field] = value
+	}
+	return created, nil
+}
+
+// UpdateIncident logs the fields of the record
+func (c dryRunClient) UpdateIncident(ctx context.Context, tableName string, incidentParam Incident, sysID string) (Incident, error) {
+	logDryRun(ctx, "update", tableName, incidentParam, sysID)
+	return Incident{"sys_id": sysID, "number": dryRunNumber}, nil
+}
+
+// PatchIncident logs the fields of the record
+func (c dryRunClient) PatchIncident(ctx context.Context, tableName string, incidentParam Incident, sysID string) (Incident, error) {
+	logDryRun(ctx, "patch", tableName, incidentParam, sysID)
+	return Incident{"sys_id": sysID, "number": dryRunNumber}, nil
+}
+
+// DeleteIncident logs the record
+func (c dryRunClient) DeleteIncident(ctx context.Context, tableName string, sysID string) error {
+	logDryRun(ctx, "delete", tableName, Incident{}, sysID)
+	return nil
+}
+
+// AttachFile logs the name and the size of the file
+func (c dryRunClient) AttachFile(ctx context.Context, tableName string, sysID string, fileName string, contentType string, content []byte) error {
+	loggerFrom(ctx).Infof("Dry run, would attach file %s (%s, %d bytes) to record %s of table %s", fileName, contentType, len(content), sysID, tableName)
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+func TestWebhookHandler_DryRun(t *testing.T) {
+	tests := []struct {
+		name      string
+		existing  []Incident
+		wantLog   string
+		wantWrite string
+	}{
+		{name: "create", existing: []Incident{}, wantLog: "Dry run, would create a record of table incident", wantWrite: "CreateIncident"},
+		{name: "update", existing: []Incident{Incident{"sys_id": "42", "number": "INC42", "state": "2"}}, wantLog: "Dry run, would update record 42 of table incident", wantWrite: "UpdateIncident"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loadConfig("config/servicenow_example.yml")
+			dedupStore = newMemoryDedupStore()
+			snClientMock := new(MockedSnClient)
+			useServiceNow(snClientMock)
+			snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return(tt.existing, nil)
+
+			var buf bytes.Buffer
+			defer func(logger Logger) { baseLogger = logger }(baseLogger)
+			baseLogger = newLogger(&buf, "logfmt", "info")
+
+			data, err := ioutil.ReadFile("test/alertmanager_firing.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(webhook).ServeHTTP(rr, httptest.NewRequest("POST", "/webhook?dry_run=true", bytes.NewReader(data)))
+
+			if rr.Code != http.StatusOK {
+				t.Errorf("Wrong status code: got %v, want %v", rr.Code, http.StatusOK)
+			}
+			snClientMock.AssertNotCalled(t, tt.wantWrite)
+			if !strings.Contains(buf.String(), tt.wantLog) || !strings.Contains(buf.String(), "dry_run=true") {
+				t.Errorf("Missing dry run log %q in %s", tt.wantLog, buf.String())
+			}
+			alertGroup, err := readRequestBody(httptest.NewRequest("POST", "/webhook", bytes.NewReader(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if absent, _ := dedupStore.SetIfAbsent(getGroupKey(context.Background(), alertGroup), "", time.Minute); !absent {
+				t.Errorf("Dry run should leave the deduplication store untouched")
+			}
+		})
+	}
+}
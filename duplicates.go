@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	multipleIncidentsNewest = "newest"
+	multipleIncidentsOldest = "oldest"
+	multipleIncidentsMerge  = "merge"
+)
+
+func validateMultipleIncidentsPolicy(c WorkflowConfig, errs *strings.Builder) {
+	switch c.MultipleIncidentsPolicy {
+	case "", multipleIncidentsNewest, multipleIncidentsOldest:
+	case multipleIncidentsMerge:
+		if len(c.DuplicateCancelState) == 0 {
+			errs.WriteString("duplicate_cancel_state is missing\n")
+		}
+	default:
+		errs.WriteString(fmt.Sprintf("multiple_incidents_policy must be one of %q, %q or %q\n", multipleIncidentsNewest, multipleIncidentsOldest, multipleIncidentsMerge))
+	}
+}
+
+// sortByCreation sorts the incidents from the oldest to the newest
+func sortByCreation(incidents []Incident) []Incident {
+	sorted := make([]Incident, len(incidents))
+	copy(sorted, incidents)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		created1, _ := sorted[i]["sys_created_on"].(string)
+		created2, _ := sorted[j]["sys_created_on"].(string)
+		return created1 < created2
+	})
+	return sorted
+}
+
+// selectUpdatableIncident returns the incident to use among the updatable incidents of the group key, according
+// to the configured policy: the newest (default), the oldest, or the oldest once the other ones are cancelled (merge).
+func selectUpdatableIncident(ctx context.Context, tableName string, updatableIncidents []Incident, groupKey string) Incident {
+	config := configFrom(ctx)
+	if len(updatableIncidents) == 0 {
+		return nil
+	}
+	if len(updatableIncidents) == 1 {
+		return updatableIncidents[0]
+	}
+
+	sorted := sortByCreation(updatableIncidents)
+	switch config.Workflow.MultipleIncidentsPolicy {
+	case multipleIncidentsOldest:
+		loggerFrom(ctx).Warnf("As multiple updatable incidents were found for alert group key: %s, the oldest one will be used: %s", groupKey, sorted[0].GetNumber())
+		return sorted[0]
+	case multipleIncidentsMerge:
+		kept := sorted[0]
+		loggerFrom(ctx).Warnf("As multiple updatable incidents were found for alert group key: %s, they will be merged into the oldest one: %s", groupKey, kept.GetNumber())
+		cancelDuplicateIncidents(ctx, tableName, kept, sorted[1:], groupKey)
+		return kept
+	default:
+		newest := sorted[len(sorted)-1]
+		loggerFrom(ctx).Warnf("As multiple updatable incidents were found for alert group key: %s, the newest one will be used: %s", groupKey, newest.GetNumber())
+		return newest
+	}
+}
+
+// cancelDuplicateIncidents cancels the duplicates of the kept incident, with a work note referencing it
+func cancelDuplicateIncidents(ctx context.Context, tableName string, kept Incident, duplicates []Incident, groupKey string) {
+	config := configFrom(ctx)
+	for _, duplicate := range duplicates {
+		cancelParam := Incident{
+			"state":      config.Workflow.DuplicateCancelState,
+			"work_notes": fmt.Sprintf("Cancelled as a duplicate of %s for alert group key %s.", kept.GetNumber(), groupKey),
+		}
+		if _, err := serviceNowFrom(ctx).UpdateIncident(ctx, tableName, cancelParam, duplicate.GetSysID()); err != nil {
+			serviceNowError.Inc()
+			loggerFrom(ctx).Errorf("Error cancelling duplicate incident %s: %v", duplicate.GetNumber(), err)
+			continue
+		}
+		loggerFrom(ctx).Infof("Duplicate incident %s cancelled", duplicate.GetNumber())
+	}
+}
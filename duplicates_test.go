@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+)
+
+func duplicateIncidents() []Incident {
+	return []Incident{
+		Incident{"sys_id": "2", "number": "INC2", "sys_created_on": "2020-01-02 10:00:00"},
+		Incident{"sys_id": "1", "number": "INC1", "sys_created_on": "2020-01-01 10:00:00"},
+		Incident{"sys_id": "3", "number": "INC3", "sys_created_on": "2020-01-03 10:00:00"},
+	}
+}
+
+func TestSelectUpdatableIncident_Policies(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		want   string
+	}{
+		{name: "default", policy: "", want: "3"},
+		{name: "newest", policy: multipleIncidentsNewest, want: "3"},
+		{name: "oldest", policy: multipleIncidentsOldest, want: "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loadConfig("config/servicenow_example.yml")
+			currentConfig().Workflow.MultipleIncidentsPolicy = tt.policy
+			snClientMock := new(MockedSnClient)
+			useServiceNow(snClientMock)
+
+			got := selectUpdatableIncident(context.Background(), currentConfig().ServiceNow.TableName, duplicateIncidents(), "key")
+			if got.GetSysID() != tt.want {
+				t.Errorf("Unexpected incident selected: got %v, want %v", got.GetSysID(), tt.want)
+			}
+			snClientMock.AssertNotCalled(t, "UpdateIncident", mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestSelectUpdatableIncident_Merge(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Workflow.MultipleIncidentsPolicy = multipleIncidentsMerge
+	currentConfig().Workflow.DuplicateCancelState = "8"
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	cancelParam := Incident{"state": "8", "work_notes": "Cancelled as a duplicate of INC1 for alert group key key."}
+	snClientMock.On("UpdateIncident", currentConfig().ServiceNow.TableName, cancelParam, "2").Return(Incident{}, nil)
+	snClientMock.On("UpdateIncident", currentConfig().ServiceNow.TableName, cancelParam, "3").Return(Incident{}, errors.New("Error"))
+
+	got := selectUpdatableIncident(context.Background(), currentConfig().ServiceNow.TableName, duplicateIncidents(), "key")
+	if got.GetSysID() != "1" {
+		t.Errorf("Unexpected incident kept: got %v, want %v", got.GetSysID(), "1")
+	}
+	snClientMock.AssertExpectations(t)
+	snClientMock.AssertNotCalled(t, "UpdateIncident", mock.Anything, mock.Anything, "1")
+}
+
+func TestSelectUpdatableIncident_Single(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Workflow.MultipleIncidentsPolicy = multipleIncidentsMerge
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+
+	got := selectUpdatableIncident(context.Background(), currentConfig().ServiceNow.TableName, []Incident{Incident{"sys_id": "1"}}, "key")
+	if got.GetSysID() != "1" {
+		t.Errorf("Unexpected incident selected: got %v, want %v", got.GetSysID(), "1")
+	}
+	if selectUpdatableIncident(context.Background(), currentConfig().ServiceNow.TableName, nil, "key") != nil {
+		t.Errorf("No incident should be selected without updatable incidents")
+	}
+	snClientMock.AssertNotCalled(t, "UpdateIncident", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestValidateMultipleIncidentsPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  WorkflowConfig
+		wantErr bool
+	}{
+		{name: "default", config: WorkflowConfig{}},
+		{name: "oldest", config: WorkflowConfig{MultipleIncidentsPolicy: multipleIncidentsOldest}},
+		{name: "merge", config: WorkflowConfig{MultipleIncidentsPolicy: multipleIncidentsMerge, DuplicateCancelState: "8"}},
+		{name: "merge_without_cancel_state", config: WorkflowConfig{MultipleIncidentsPolicy: multipleIncidentsMerge}, wantErr: true},
+		{name: "unknown", config: WorkflowConfig{MultipleIncidentsPolicy: "first"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var errs strings.Builder
+			validateMultipleIncidentsPolicy(tt.config, &errs)
+			if (errs.Len() > 0) != tt.wantErr {
+				t.Errorf("Unexpected validation result: %q, wantErr %v", errs.String(), tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	defaultEnrichmentTimeout  = 5 * time.Second
+	defaultEnrichmentCacheTTL = 5 * time.Minute
+	defaultEnrichmentPrefix   = "enrichment_"
+)
+
+var (
+	enrichmentErrors = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "webhook_enrichment_errors_total",
+			Help: "Total number of alert enrichment errors.",
+		},
+	)
+)
+
+// EnrichmentConfig - Configuration of the alerts enrichment through an external lookup service
+type EnrichmentConfig struct {
+	URL      string        `yaml:"url"`
+	Timeout  time.Duration `yaml:"timeout"`
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+	Prefix   string        `yaml:"prefix"`
+}
+
+// EnrichmentResponse is the response of the enrichment service for an alert
+type EnrichmentResponse struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// enricher merges the labels and annotations returned by the enrichment service into the alerts
+type enricher struct {
+	url    string
+	prefix string
+	client *http.Client
+	cache  *lookupCache
+}
+
+func newEnricher(c EnrichmentConfig) *enricher {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = defaultEnrichmentTimeout
+	}
+	cacheTTL := c.CacheTTL
+	if cacheTTL <= 0 {
+		cacheTTL = defaultEnrichmentCacheTTL
+	}
+	prefix := c.Prefix
+	if len(prefix) == 0 {
+		prefix = defaultEnrichmentPrefix
+	}
+
+	return &enricher{
+		url:    c.URL,
+		prefix: prefix,
+		client: &http.Client{Timeout: timeout},
+		cache:  newLookupCache("enrichment", cacheTTL),
+	}
+}
+
+// newConfigEnricher returns the enricher of the config, nil without enrichment service
+func newConfigEnricher(c EnrichmentConfig) *enricher {
+	if len(c.URL) == 0 {
+		return nil
+	}
+	baseLogger.Infof("Alerts will be enriched from %s", c.URL)
+	return newEnricher(c)
+}
+
+// lookup returns the enrichment of the alert, from the cache or from the enrichment service
+func (e *enricher) lookup(ctx context.Context, alert template.Alert) (EnrichmentResponse, error) {
+	key := alertFingerprint(alert)
+	if enrichment, ok := e.cache.get(key); ok {
+		return enrichment.(EnrichmentResponse), nil
+	}
+
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return EnrichmentResponse{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return EnrichmentResponse{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return EnrichmentResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return EnrichmentResponse{}, fmt.Errorf("Enrichment service returned the HTTP error code: %v", resp.StatusCode)
+	}
+
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return EnrichmentResponse{}, err
+	}
+
+	enrichment := EnrichmentResponse{}
+	if err := json.Unmarshal(responseBody, &enrichment); err != nil {
+		return EnrichmentResponse{}, err
+	}
+
+	e.cache.set(key, enrichment)
+	return enrichment, nil
+}
+
+// enrich merges the enrichment of each alert into its labels and annotations, with keys prefixed to avoid collisions.
+// Enriched keys having the same value for all the alerts are also added to the common labels and annotations.
+// Alerts which enrichment fails are left untouched. The alerts are copied, leaving the ones of the caller un-enriched.
+func (e *enricher) enrich(ctx context.Context, data *template.Data) {
+	data.Alerts = append(template.Alerts{}, data.Alerts...)
+	for i, alert := range data.Alerts {
+		enrichment, err := e.lookup(ctx, alert)
+		if err != nil {
+			enrichmentErrors.Inc()
+			loggerFrom(ctx).Errorf("Error enriching alert %v, it will be used un-enriched: %v", alert.Labels, err)
+			continue
+		}
+
+		labels := copyKV(alert.Labels)
+		for k, v := range enrichment.Labels {
+			labels[e.prefix+k] = v
+		}
+		annotations := copyKV(alert.Annotations)
+		for k, v := range enrichment.Annotations {
+			annotations[e.prefix+k] = v
+		}
+		data.Alerts[i].Labels = labels
+		data.Alerts[i].Annotations = annotations
+	}
+
+	data.CommonLabels = mergeCommonKV(data.CommonLabels, data.Alerts, e.prefix, func(a template.Alert) template.KV { return a.Labels })
+	data.CommonAnnotations = mergeCommonKV(data.CommonAnnotations, data.Alerts, e.prefix, func(a template.Alert) template.KV { return a.Annotations })
+}
+
+func copyKV(kv template.KV) template.KV {
+	c := make(template.KV, len(kv))
+	for k, v := range kv {
+		c[k] = v
+	}
+	return c
+}
+
+// mergeCommonKV adds to common the prefixed keys having the same value in all the alerts
+func mergeCommonKV(common template.KV, alerts template.Alerts, prefix string, kv func(template.Alert) template.KV) template.KV {
+	if len(alerts) == 0 {
+		return common
+	}
+
+	merged := copyKV(common)
+	for k, v := range kv(alerts[0]) {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		isCommon := true
+		for _, alert := range alerts[1:] {
+			if value, ok := kv(alert)[k]; !ok || value != v {
+				isCommon = false
+				break
+			}
+		}
+		if isCommon {
+			merged[k] = v
+		}
+	}
+	return merged
+}
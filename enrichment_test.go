@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/mock"
+)
+
+func newEnrichmentServer(t *testing.T, calls *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		alert := template.Alert{}
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Errorf("Unexpected enrichment request body: %v", err)
+		}
+		if alert.Labels["service"] == "unknown" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(EnrichmentResponse{
+			Labels:      map[string]string{"team": "payments-" + alert.Labels["service"]},
+			Annotations: map[string]string{"escalation_policy": "24x7"},
+		})
+	}))
+}
+
+func TestEnricher_Enrich(t *testing.T) {
+	var calls int32
+	ts := newEnrichmentServer(t, &calls)
+	defer ts.Close()
+
+	e := newEnricher(EnrichmentConfig{URL: ts.URL})
+	data := template.Data{
+		Alerts: template.Alerts{
+			template.Alert{Labels: template.KV{"service": "api", "team": "original"}},
+			template.Alert{Labels: template.KV{"service": "db"}},
+		},
+		CommonLabels: template.KV{},
+	}
+	e.enrich(context.Background(), &data)
+
+	if got := data.Alerts[0].Labels["enrichment_team"]; got != "payments-api" {
+		t.Errorf("Unexpected enriched label: got %v, want %v", got, "payments-api")
+	}
+	if got := data.Alerts[0].Labels["team"]; got != "original" {
+		t.Errorf("Original label should not be overwritten: got %v, want %v", got, "original")
+	}
+	if got := data.Alerts[1].Annotations["enrichment_escalation_policy"]; got != "24x7" {
+		t.Errorf("Unexpected enriched annotation: got %v, want %v", got, "24x7")
+	}
+	if got := data.CommonAnnotations["enrichment_escalation_policy"]; got != "24x7" {
+		t.Errorf("Enriched annotation common to all alerts should be in common annotations: got %v", got)
+	}
+	if _, ok := data.CommonLabels["enrichment_team"]; ok {
+		t.Errorf("Enriched label differing between alerts should not be in common labels")
+	}
+}
+
+func TestEnricher_Cached(t *testing.T) {
+	var calls int32
+	ts := newEnrichmentServer(t, &calls)
+	defer ts.Close()
+
+	e := newEnricher(EnrichmentConfig{URL: ts.URL})
+	for i := 0; i < 2; i++ {
+		data := template.Data{Alerts: template.Alerts{template.Alert{Labels: template.KV{"service": "api"}}}}
+		e.enrich(context.Background(), &data)
+	}
+	if calls != 1 {
+		t.Errorf("Unexpected enrichment service calls: got %v, want %v", calls, 1)
+	}
+}
+
+func TestEnricher_FailOpen(t *testing.T) {
+	var calls int32
+	ts := newEnrichmentServer(t, &calls)
+	defer ts.Close()
+
+	e := newEnricher(EnrichmentConfig{URL: ts.URL})
+	data := template.Data{Alerts: template.Alerts{template.Alert{Labels: template.KV{"service": "unknown"}}}}
+	e.enrich(context.Background(), &data)
+
+	if len(data.Alerts[0].Labels) != 1 {
+		t.Errorf("Alert should be left un-enriched on error, got labels %v", data.Alerts[0].Labels)
+	}
+}
+
+func TestEnricher_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	e := newEnricher(EnrichmentConfig{URL: ts.URL, Timeout: 10 * time.Millisecond})
+	data := template.Data{Alerts: template.Alerts{template.Alert{Labels: template.KV{"service": "api"}}}}
+	e.enrich(context.Background(), &data)
+
+	if _, ok := data.Alerts[0].Labels["enrichment_team"]; ok {
+		t.Errorf("Alert should be left un-enriched on timeout")
+	}
+}
+
+func TestEnricher_Cancelled(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	e := newEnricher(EnrichmentConfig{URL: ts.URL, Timeout: time.Minute})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	data := template.Data{Alerts: template.Alerts{template.Alert{Labels: template.KV{"service": "api"}}}}
+	e.enrich(ctx, &data)
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("The enrichment call should stop with the request context, took %v", elapsed)
+	}
+	if _, ok := data.Alerts[0].Labels["enrichment_team"]; ok {
+		t.Errorf("Alert should be left un-enriched when the request is cancelled")
+	}
+}
+
+func TestEnricher_CallerAlertsUntouched(t *testing.T) {
+	var calls int32
+	ts := newEnrichmentServer(t, &calls)
+	defer ts.Close()
+
+	e := newEnricher(EnrichmentConfig{URL: ts.URL})
+	alerts := template.Alerts{template.Alert{Labels: template.KV{"service": "api"}}}
+	data := template.Data{Alerts: alerts}
+	e.enrich(context.Background(), &data)
+
+	if _, ok := data.Alerts[0].Labels["enrichment_team"]; !ok {
+		t.Errorf("Alert should be enriched, got labels %v", data.Alerts[0].Labels)
+	}
+	if len(alerts[0].Labels) != 1 || len(alerts[0].Annotations) != 0 {
+		t.Errorf("The alerts of the caller should be left un-enriched, got labels %v and annotations %v", alerts[0].Labels, alerts[0].Annotations)
+	}
+}
+
+func TestOnAlertGroup_Enrichment(t *testing.T) {
+	var calls int32
+	ts := newEnrichmentServer(t, &calls)
+	defer ts.Close()
+
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Enrichment = EnrichmentConfig{URL: ts.URL}
+	currentConfig().DefaultIncident = map[string]string{"short_description": "Escalation: {{ .CommonAnnotations.enrichment_escalation_policy }}"}
+	applyConfig()
+	dedupStore = newMemoryDedupStore()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		incident := args.Get(1).(Incident)
+		if incident["short_description"] != "Escalation: 24x7" {
+			t.Errorf("Unexpected short_description: got %v, want %v", incident["short_description"], "Escalation: 24x7")
+		}
+	}).Return(Incident{}, nil)
+
+	data := template.Data{
+		Status:      "firing",
+		GroupLabels: template.KV{"alertname": "enrichment"},
+		Alerts:      template.Alerts{template.Alert{Labels: template.KV{"service": "api"}}},
+	}
+	if err := onAlertGroup(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+	snClientMock.AssertCalled(t, "CreateIncident", mock.Anything, mock.Anything)
+}
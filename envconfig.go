@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// defaultIncidentEnvPrefix prefixes the environment variables setting the default_incident fields, e.g.
+// SERVICENOW_DEFAULT_INCIDENT_ASSIGNMENT_GROUP for default_incident.assignment_group
+const defaultIncidentEnvPrefix = "SERVICENOW_DEFAULT_INCIDENT_"
+
+// envConfig is the config of the webhook without config file, completed by the environment variables
+var envConfig = []byte(`service_now:
+  table_name: "incident"
+
+workflow:
+  no_update_states: [6,7,8]
+  incident_update_fields: ["comments"]
+
+default_incident:
+  short_description: "Alerts from group: {{ range $key, $val := .GroupLabels}}{{ $key }}:{{ $val }} {{end}}"
+  description: "Received alerts from AlertManager at {{.ExternalURL}} (\"{{.Receiver}}\" receiver configuration) with common descriptions:\n\n{{.CommonAnnotations.description}}"
+  comments: "Alerts list:\n\n{{ range .Alerts }}[{{ .Status }}] {{.StartsAt}} {{.Labels.alertname}}\n{{.Annotations.description}}\n\n{{ end }}"
+`)
+
+// readConfigFile returns the content of the config file, or the config of the environment variables only when no
+// config file is set
+func readConfigFile(configFile string) ([]byte, error) {
+	if len(configFile) == 0 {
+		return envConfig, nil
+	}
+	return ioutil.ReadFile(configFile)
+}
+
+// envLookup looks up the environment variables overriding the config, collecting the errors reading their files
+type envLookup struct {
+	errs []string
+}
+
+// lookup returns the value of the environment variable, or else the content of the file of its _FILE variable,
+// without its trailing line break, e.g. SERVICENOW_PASSWORD_FILE for a container secret
+func (e *envLookup) lookup(name string) (string, bool) {
+	if value, ok := os.LookupEnv(name); ok {
+		return value, true
+	}
+	file, ok := os.LookupEnv(name + "_FILE")
+	if !ok {
+		return "", false
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Sprintf("error reading %s_FILE: %v", name, err))
+		return "", false
+	}
+	return strings.TrimRight(string(content), "\r\n"), true
+}
+
+// defaultIncidentFields returns the default_incident fields set by the SERVICENOW_DEFAULT_INCIDENT_<FIELD> environment
+// variables, the field being the lower case suffix of the variable
+func (e *envLookup) defaultIncidentFields() map[string]string {
+	fields := map[string]string{}
+	for _, variable := range os.Environ() {
+		name := strings.TrimSuffix(strings.SplitN(variable, "=", 2)[0], "_FILE")
+		if !strings.HasPrefix(name, defaultIncidentEnvPrefix) {
+			continue
+		}
+		if value, ok := e.lookup(name); ok {
+			fields[strings.ToLower(strings.TrimPrefix(name, defaultIncidentEnvPrefix))] = value
+		}
+	}
+	return fields
+}
+
+func (e *envLookup) err() error {
+	if len(e.errs) > 0 {
+		return errors.New(strings.Join(e.errs, "\n"))
+	}
+	return nil
+}
+
+// splitEnvList returns the items of the comma separated list of an environment variable
+func splitEnvList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig_EnvOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := writeCredentialFile(t, dir, "s3cr3t\n")
+	for name, value := range map[string]string{
+		"SERVICENOW_INSTANCE_NAME":                     "instance",
+		"SERVICENOW_USERNAME":                          "user",
+		"SERVICENOW_PASSWORD_FILE":                     file,
+		"SERVICENOW_INCIDENT_GROUP_KEY_FIELD":          "u_other_reference_1",
+		"SERVICENOW_INCIDENT_UPDATE_FIELDS":            "comments, work_notes",
+		"SERVICENOW_DEFAULT_INCIDENT_ASSIGNMENT_GROUP": "Ops",
+	} {
+		os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+
+	configData, err := readConfigFile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := parseConfig(configData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ServiceNow.InstanceName != "instance" || c.ServiceNow.UserName != "user" || c.ServiceNow.Password != "s3cr3t" || c.ServiceNow.TableName != "incident" {
+		t.Errorf("Unexpected ServiceNow config: %+v", c.ServiceNow)
+	}
+	if strings.Join(c.Workflow.IncidentUpdateFields, ",") != "comments,work_notes" {
+		t.Errorf("Unexpected update fields: %v", c.Workflow.IncidentUpdateFields)
+	}
+	if c.DefaultIncident["assignment_group"] != "Ops" || len(c.DefaultIncident["short_description"]) == 0 {
+		t.Errorf("Unexpected default incident: %v", c.DefaultIncident)
+	}
+}
+
+func TestParseConfig_EnvFileError(t *testing.T) {
+	os.Setenv("SERVICENOW_PASSWORD_FILE", "/nonexistent")
+	defer os.Unsetenv("SERVICENOW_PASSWORD_FILE")
+
+	if _, err := parseConfig(envConfig); err == nil || !strings.Contains(err.Error(), "error reading SERVICENOW_PASSWORD_FILE") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
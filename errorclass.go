@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	// errorClassValidation is the class of the errors of the incidents rejected before reaching ServiceNow
+	errorClassValidation = "validation"
+	// errorClassPermanent is the class of the ServiceNow errors a retry of the same request would hit again
+	errorClassPermanent = "permanent"
+	// errorClassTransient is the class of the other errors, such as ServiceNow being unavailable or unreachable
+	errorClassTransient = "transient"
+)
+
+// httpStatusError is returned when ServiceNow answers with an HTTP error code
+type httpStatusError struct {
+	statusCode int
+	message    string
+}
+
+func (e *httpStatusError) Error() string {
+	return e.message
+}
+
+// alertGroupError is returned when the incidents of several tables of the alert group failed, with the class of
+// their errors most likely to succeed on retry
+type alertGroupError struct {
+	message string
+	class   string
+}
+
+func (e *alertGroupError) Error() string {
+	return e.message
+}
+
+// ErrorStatusCodesConfig - Status codes of the webhook responses by error class, so that Alertmanager only retries the
+// notifications when it can help, as it retries on the 5xx responses but not on the 4xx ones
+type ErrorStatusCodesConfig struct {
+	Validation int `yaml:"validation"`
+	Permanent  int `yaml:"permanent"`
+	Transient  int `yaml:"transient"`
+}
+
+func (c ErrorStatusCodesConfig) validate(errs *strings.Builder) {
+	for class, status := range map[string]int{errorClassValidation: c.Validation, errorClassPermanent: c.Permanent, errorClassTransient: c.Transient} {
+		if status != 0 && (status < 400 || status > 599) {
+			errs.WriteString(fmt.Sprintf("webhook.error_status_codes.%s must be a 4xx or 5xx status code, got %d\n", class, status))
+		}
+	}
+}
+
+// status returns the status code of the responses to the errors of the class
+func (c ErrorStatusCodesConfig) status(class string) int {
+	switch class {
+	case errorClassValidation:
+		if c.Validation != 0 {
+			return c.Validation
+		}
+		return http.StatusUnprocessableEntity
+	case errorClassPermanent:
+		if c.Permanent != 0 {
+			return c.Permanent
+		}
+	default:
+		if c.Transient != 0 {
+			return c.Transient
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+// errorClass returns the class of the error of an alert group. The ServiceNow 4xx errors are permanent, except the
+// timeouts and rate limiting ones.
+func errorClass(err error) string {
+	switch e := err.(type) {
+	case *fieldLengthError, *requiredFieldError:
+		return errorClassValidation
+	case *alertGroupError:
+		return e.class
+	case *httpStatusError:
+		if e.statusCode >= 400 && e.statusCode < 500 && e.statusCode != http.StatusRequestTimeout && e.statusCode != http.StatusTooManyRequests {
+			return errorClassPermanent
+		}
+	}
+	return errorClassTransient
+}
+
+// retriableErrorClass returns the class of both errors most likely to succeed on retry
+func retriableErrorClass(class string, other string) string {
+	rank := map[string]int{errorClassValidation: 0, errorClassPermanent: 1, errorClassTransient: 2}
+	if rank[other] > rank[class] {
+		return other
+	}
+	return class
+}
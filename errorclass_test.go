@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+)
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{err: &fieldLengthError{field: "description"}, want: errorClassValidation},
+		{err: &httpStatusError{statusCode: http.StatusBadRequest}, want: errorClassPermanent},
+		{err: &httpStatusError{statusCode: http.StatusForbidden}, want: errorClassPermanent},
+		{err: &httpStatusError{statusCode: http.StatusRequestTimeout}, want: errorClassTransient},
+		{err: &httpStatusError{statusCode: http.StatusInternalServerError}, want: errorClassTransient},
+		{err: errors.New("connection refused"), want: errorClassTransient},
+		{err: &alertGroupError{class: errorClassPermanent}, want: errorClassPermanent},
+	}
+	for _, tt := range tests {
+		if got := errorClass(tt.err); got != tt.want {
+			t.Errorf("Unexpected class of %#v: got %s, want %s", tt.err, got, tt.want)
+		}
+	}
+	if got := retriableErrorClass(errorClassPermanent, errorClassTransient); got != errorClassTransient {
+		t.Errorf("The alert group should be retried when one of its errors is transient: %s", got)
+	}
+	if got := retriableErrorClass(errorClassPermanent, errorClassValidation); got != errorClassPermanent {
+		t.Errorf("Unexpected class of the permanent and validation errors: %s", got)
+	}
+}
+
+func TestHTTPStatusError_ServiceNow(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+	snClient, _ := NewServiceNowClient("instancename", "username", "password")
+	snClient.baseURL = ts.URL
+
+	_, err := snClient.CreateIncident(context.Background(), "incident", Incident{})
+	if e, ok := err.(*httpStatusError); !ok || e.statusCode != http.StatusBadRequest {
+		t.Errorf("The ServiceNow error should carry its status code: %#v", err)
+	}
+}
+
+func TestWebhookHandler_ErrorStatusCodes(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Webhook.ErrorStatusCodes = ErrorStatusCodesConfig{Permanent: http.StatusBadRequest}
+	dedupStore = newMemoryDedupStore()
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "permanent", err: &httpStatusError{statusCode: http.StatusForbidden, message: "Forbidden"}, want: http.StatusBadRequest},
+		{name: "transient", err: &httpStatusError{statusCode: http.StatusBadGateway, message: "Bad Gateway"}, want: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snClientMock := new(MockedSnClient)
+			useServiceNow(snClientMock)
+			snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+			snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{}, tt.err)
+
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(webhook).ServeHTTP(rr, httptest.NewRequest("POST", "/webhook", strings.NewReader(twoAlertsNotification)))
+			if rr.Code != tt.want {
+				t.Errorf("Wrong status code: got %v, want %v", rr.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorStatusCodesConfig_Validate(t *testing.T) {
+	var errs strings.Builder
+	ErrorStatusCodesConfig{Validation: 422, Permanent: 200}.validate(&errs)
+	if !strings.Contains(errs.String(), "webhook.error_status_codes.permanent must be a 4xx or 5xx") || strings.Contains(errs.String(), "validation") {
+		t.Errorf("Unexpected validation errors: %q", errs.String())
+	}
+	if status := (ErrorStatusCodesConfig{}).status(errorClassValidation); status != http.StatusUnprocessableEntity {
+		t.Errorf("Unexpected default status code of the validation errors: %v", status)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const defaultEscalationWorkNote = "{{ .AlertCount }} alert(s) firing: {{ .InstanceList }}"
+
+var incidentEscalations = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "webhook_incident_escalations_total",
+		Help: "Total number of incidents escalated as their alert group kept firing, by escalation threshold.",
+	},
+	[]string{"after"},
+)
+
+// EscalationConfig - Escalation of the existing incident when its alert group keeps firing past the thresholds
+type EscalationConfig struct {
+	Levels   []EscalationLevelConfig `yaml:"levels"`
+	WorkNote string                  `yaml:"work_note"`
+}
+
+// EscalationLevelConfig - Incident fields set once the alert group has been firing for the given duration
+type EscalationLevelConfig struct {
+	After  time.Duration     `yaml:"after"`
+	Fields map[string]string `yaml:"fields"`
+}
+
+func (c EscalationConfig) validate(errs *strings.Builder) {
+	for i, level := range c.Levels {
+		if level.After <= 0 {
+			errs.WriteString(fmt.Sprintf("escalation.levels[%d].after must be positive\n", i))
+		} else if i > 0 && level.After <= c.Levels[i-1].After {
+			errs.WriteString(fmt.Sprintf("escalation.levels[%d].after must be longer than the one of the previous level\n", i))
+		}
+		if len(level.Fields) == 0 {
+			errs.WriteString(fmt.Sprintf("escalation.levels[%d].fields is missing\n", i))
+		}
+		for field, text := range level.Fields {
+			if _, err := newTextTemplate(field).Parse(text); err != nil {
+				errs.WriteString(fmt.Sprintf("escalation.levels[%d].fields.%s is invalid: %v\n", i, field, err))
+			}
+		}
+	}
+	if _, err := newTextTemplate("work_note").Parse(c.workNote()); err != nil {
+		errs.WriteString(fmt.Sprintf("escalation.work_note is invalid: %v\n", err))
+	}
+}
+
+func (c EscalationConfig) workNote() string {
+	if len(c.WorkNote) == 0 {
+		return defaultEscalationWorkNote
+	}
+	return c.WorkNote
+}
+
+// escalationLevelKey is the deduplication store key of the escalation level reached by the incident of the alert
+// group key
+func escalationLevelKey(key string) string {
+	return "escalation:" + key
+}
+
+// firingSince returns the start of the earliest firing alert of the group, zero when unknown
+func firingSince(data template.Data) time.Time {
+	var since time.Time
+	for _, alert := range data.Alerts {
+		if alert.Status == "firing" && !alert.StartsAt.IsZero() && (since.IsZero() || alert.StartsAt.Before(since)) {
+			since = alert.StartsAt
+		}
+	}
+	return since
+}
+
+// escalationLevel returns the number of the escalation levels whose threshold the firing duration reached
+func escalationLevel(levels []EscalationLevelConfig, firing time.Duration) int {
+	return sort.Search(len(levels), func(i int) bool { return levels[i].After > firing })
+}
+
+// applyEscalation sets the fields of the escalation level reached by the firing alert group on the incident update,
+// along with a timestamped work note, once per level. The reached level is kept in the deduplication store until the
+// alert group is resolved. It must be called with the incident lock of the alert group held.
+func applyEscalation(ctx context.Context, incident Incident, key string, data template.Data, now time.Time) {
+	config := configFrom(ctx)
+	c := config.Workflow.Escalation
+	since := firingSince(data)
+	if len(c.Levels) == 0 || since.IsZero() {
+		return
+	}
+	firing := now.Sub(since)
+	level := escalationLevel(c.Levels, firing)
+	if level == 0 {
+		return
+	}
+
+	levelKey := escalationLevelKey(key)
+	escalated := 0
+	if value, err := dedupStore.Get(levelKey); err != nil {
+		loggerFrom(ctx).Errorf("Error reading the escalation level of alert group key %s: %v", key, err)
+	} else if n, err := strconv.Atoi(value); err == nil {
+		escalated = n
+	}
+	if !isDryRun(ctx) {
+		// Stored on each notification, so that the level outlives the store TTL while the alert group keeps firing
+		if err := dedupStore.Set(levelKey, strconv.Itoa(level), defaultNotificationCounterTTL); err != nil {
+			loggerFrom(ctx).Errorf("Error storing the escalation level of alert group key %s: %v", key, err)
+		}
+	}
+	if level <= escalated {
+		return
+	}
+
+	threshold := c.Levels[level-1]
+	fields := Incident{workNotesField: c.workNote()}
+	for field, text := range threshold.Fields {
+		fields[field] = text
+	}
+	applyIncidentTemplate(ctx, fields, data)
+
+	var changes []string
+	for field, value := range fields {
+		if field != workNotesField {
+			incident[field] = value
+			changes = append(changes, fmt.Sprintf("%s=%v", field, value))
+		}
+	}
+	sort.Strings(changes)
+	text := fmt.Sprintf("%s - alert firing for more than %v, escalated (%s): %s", now.UTC().Format(workNoteTimeLayout), threshold.After, strings.Join(changes, ", "), fields[workNotesField])
+	if existing, ok := incident[workNotesField].(string); ok && len(existing) > 0 {
+		text += "\n\n" + existing
+	}
+	incident[workNotesField] = text
+	incidentEscalations.WithLabelValues(threshold.After.String()).Inc()
+	loggerFrom(ctx).Infof("Escalating the incident of alert group key %s, firing for %v: %s", key, firing.Round(time.Second), strings.Join(changes, ", "))
+}
+
+// resetEscalation forgets the escalation level of the resolved alert group
+func resetEscalation(ctx context.Context, key string) {
+	config := configFrom(ctx)
+	if len(config.Workflow.Escalation.Levels) == 0 || isDryRun(ctx) {
+		return
+	}
+	if err := dedupStore.Delete(escalationLevelKey(key)); err != nil {
+		loggerFrom(ctx).Errorf("Error resetting the escalation level of alert group key %s: %v", key, err)
+	}
+}
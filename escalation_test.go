@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/mock"
+)
+
+func loadEscalationTestConfig() {
+	loadConfig("config/servicenow_example.yml")
+	dedupStore = newMemoryDedupStore()
+	currentConfig().Workflow.Escalation = EscalationConfig{
+		Levels: []EscalationLevelConfig{
+			{After: 30 * time.Minute, Fields: map[string]string{"urgency": "2"}},
+			{After: 2 * time.Hour, Fields: map[string]string{"urgency": "1", "impact": "1"}},
+		},
+		WorkNote: "{{ .CommonLabels.alertname }} is still firing",
+	}
+}
+
+func TestApplyEscalation(t *testing.T) {
+	loadEscalationTestConfig()
+	start := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	data := template.Data{
+		CommonLabels: template.KV{"alertname": "DiskFull"},
+		Alerts:       template.Alerts{{Status: "firing", StartsAt: start}, {Status: "firing", StartsAt: start.Add(time.Hour)}},
+	}
+
+	incident := Incident{}
+	applyEscalation(context.Background(), incident, "key", data, start.Add(10*time.Minute))
+	if len(incident) > 0 {
+		t.Errorf("The incident should not be escalated before the first threshold: %v", incident)
+	}
+
+	incident = Incident{workNotesField: "other note"}
+	applyEscalation(context.Background(), incident, "key", data, start.Add(45*time.Minute))
+	if incident["urgency"] != "2" || incident[workNotesField] != "2024-01-02 12:45:00 UTC - alert firing for more than 30m0s, escalated (urgency=2): DiskFull is still firing\n\nother note" {
+		t.Errorf("Unexpected first escalation: %v", incident)
+	}
+
+	incident = Incident{}
+	applyEscalation(context.Background(), incident, "key", data, start.Add(time.Hour))
+	if len(incident) > 0 {
+		t.Errorf("The incident should be escalated once per level: %v", incident)
+	}
+
+	incident = Incident{}
+	applyEscalation(context.Background(), incident, "key", data, start.Add(3*time.Hour))
+	if incident["urgency"] != "1" || incident["impact"] != "1" || !strings.Contains(incident[workNotesField].(string), "more than 2h0m0s, escalated (impact=1, urgency=1)") {
+		t.Errorf("Unexpected second escalation: %v", incident)
+	}
+
+	resetEscalation(context.Background(), "key")
+	incident = Incident{}
+	applyEscalation(withDryRun(context.Background()), incident, "key", data, start.Add(45*time.Minute))
+	applyEscalation(context.Background(), incident, "key", data, start.Add(45*time.Minute))
+	if incident["urgency"] != "2" {
+		t.Errorf("The escalation level should be reset, and not stored in dry run: %v", incident)
+	}
+}
+
+func TestOnAlertGroup_Escalation(t *testing.T) {
+	loadEscalationTestConfig()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "incident", mock.Anything).Return([]Incident{Incident{"sys_id": "1", "number": "INC1", "state": "2"}}, nil)
+	snClientMock.On("UpdateIncident", "incident", mock.Anything, "1").Return(Incident{}, nil)
+
+	data := template.Data{
+		Status:       "firing",
+		GroupLabels:  template.KV{"alertname": "DiskFull"},
+		CommonLabels: template.KV{"alertname": "DiskFull"},
+		Alerts:       template.Alerts{template.Alert{Status: "firing", StartsAt: time.Now().Add(-time.Hour)}},
+	}
+	if err := onAlertGroup(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+	incident := snClientMock.Calls[1].Arguments.Get(1).(Incident)
+	if incident["urgency"] != "2" || !strings.Contains(incident[workNotesField].(string), "DiskFull is still firing") {
+		t.Errorf("The updated incident should be escalated: %v", incident)
+	}
+
+	data.Status = "resolved"
+	data.Alerts[0].Status = "resolved"
+	if err := onAlertGroup(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := dedupStore.Get(escalationLevelKey(instanceKey(context.Background(), dedupKey(context.Background(), "incident", getGroupKey(context.Background(), data))))); len(value) > 0 {
+		t.Errorf("The escalation level should be reset once resolved: %v", value)
+	}
+}
+
+func TestEscalationConfig_Validate(t *testing.T) {
+	var errs strings.Builder
+	EscalationConfig{
+		Levels: []EscalationLevelConfig{
+			{After: time.Hour, Fields: map[string]string{"urgency": "{{ .Status"}},
+			{After: time.Minute},
+			{},
+		},
+		WorkNote: "{{ end }}",
+	}.validate(&errs)
+	for _, want := range []string{
+		"escalation.levels[0].fields.urgency is invalid",
+		"escalation.levels[1].after must be longer",
+		"escalation.levels[1].fields is missing",
+		"escalation.levels[2].after must be positive",
+		"escalation.work_note is invalid",
+	} {
+		if !strings.Contains(errs.String(), want) {
+			t.Errorf("Missing validation error %q: %q", want, errs.String())
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+const (
+	targetIncident = "incident"
+	targetEvent    = "event"
+
+	eventTable               = "em_event"
+	eventClearSeverity       = "0"
+	defaultEventSeverity     = "3"
+	eventTimeLayout          = "2006-01-02 15:04:05"
+	eventSeverityField       = "severity"
+	eventMessageKeyField     = "message_key"
+	eventAdditionalInfoField = "additional_info"
+	eventTimeField           = "time_of_event"
+)
+
+// defaultEventFields are the event fields templates, per alert, overridden by the configured ones
+var defaultEventFields = map[string]string{
+	"source":      "Alertmanager",
+	"node":        "{{ .Labels.instance }}",
+	"type":        "{{ .Labels.alertname }}",
+	"metric_name": "{{ .Labels.alertname }}",
+	"description": "{{ .Annotations.summary }}",
+}
+
+// defaultEventSeverities maps the usual alert severities to the ServiceNow event severities
+// (1 Critical, 2 Major, 3 Minor, 4 Warning, 5 Info)
+var defaultEventSeverities = map[string]string{
+	"critical": "1",
+	"major":    "2",
+	"error":    "2",
+	"minor":    "3",
+	"warning":  "4",
+	"info":     "5",
+}
+
+// EventConfig - Mapping of the alerts to ServiceNow Event Management events, used with the "event" target
+type EventConfig struct {
+	Fields          map[string]string `yaml:"fields"`
+	SeverityLabel   string            `yaml:"severity_label"`
+	Severities      map[string]string `yaml:"severities"`
+	DefaultSeverity string            `yaml:"default_severity"`
+}
+
+func validateTarget(c Config, errs *strings.Builder) {
+	switch c.Target {
+	case "", targetIncident, targetEvent:
+	default:
+		errs.WriteString(fmt.Sprintf("target must be one of %q or %q\n", targetIncident, targetEvent))
+	}
+	for severity, value := range c.Event.Severities {
+		validateEventSeverity(fmt.Sprintf("event.severities.%s", severity), value, errs)
+	}
+	if len(c.Event.DefaultSeverity) > 0 {
+		validateEventSeverity("event.default_severity", c.Event.DefaultSeverity, errs)
+	}
+}
+
+func validateEventSeverity(name string, value string, errs *strings.Builder) {
+	if severity, err := strconv.Atoi(value); err != nil || severity < 1 || severity > 5 {
+		errs.WriteString(fmt.Sprintf("%s must be an event severity from 1 to 5, got %q\n", name, value))
+	}
+}
+
+// eventTarget returns whether alerts are sent as events instead of managing incidents
+func (c Config) eventTarget() bool {
+	return c.Target == targetEvent
+}
+
+// fields returns the event fields templates, the configured ones overriding the default ones
+func (c EventConfig) fields() map[string]string {
+	fields := make(map[string]string, len(defaultEventFields)+len(c.Fields))
+	for field, text := range defaultEventFields {
+		fields[field] = text
+	}
+	for field, text := range c.Fields {
+		fields[field] = text
+	}
+	return fields
+}
+
+// severity returns the event severity of the alert: clear when resolved, or else mapped from its severity label
+func (c EventConfig) severity(alert template.Alert) string {
+	if alert.Status == "resolved" {
+		return eventClearSeverity
+	}
+	label := c.SeverityLabel
+	if len(label) == 0 {
+		label = defaultSeverityLabel
+	}
+	severities := c.Severities
+	if len(severities) == 0 {
+		severities = defaultEventSeverities
+	}
+	if severity, ok := severities[strings.ToLower(alert.Labels[label])]; ok {
+		return severity
+	}
+	if len(c.DefaultSeverity) > 0 {
+		return c.DefaultSeverity
+	}
+	return defaultEventSeverity
+}
+
+// alertToEvent builds the event of the alert of the group. The alert fingerprint is the message key, so that
+// ServiceNow correlates the events of an alert, and its labels and annotations are sent as additional information.
+func alertToEvent(ctx context.Context, data template.Data, alert template.Alert) Incident {
+	config := configFrom(ctx)
+	alertData := data
+	alertData.Status = alert.Status
+	alertData.Alerts = template.Alerts{alert}
+
+	event := Incident{}
+	executeFieldTemplates(ctx, configSnapshotFrom(ctx).mapping.eventFields, event, newTemplateContext(config.InstanceList, alertData))
+
+	info := make(map[string]string, len(alert.Labels)+len(alert.Annotations))
+	for name, value := range alert.Annotations {
+		info[name] = value
+	}
+	for name, value := range alert.Labels {
+		info[name] = value
+	}
+	additionalInfo, _ := json.Marshal(info)
+
+	event[eventSeverityField] = config.Event.severity(alert)
+	event[eventMessageKeyField] = alertFingerprint(alert)
+	event[eventAdditionalInfoField] = string(additionalInfo)
+	if !alert.StartsAt.IsZero() {
+		event[eventTimeField] = alert.StartsAt.UTC().Format(eventTimeLayout)
+	}
+	return event
+}
+
+// sendEvents posts an event per alert of the group to the em_event table, letting the ServiceNow alert rules decide
+// whether an incident is created. Every alert is sent even when one fails.
+func sendEvents(ctx context.Context, data template.Data) error {
+	var errs []string
+	var overload *overloadError
+	for _, alert := range data.Alerts {
+		_, err := serviceNowFrom(ctx).CreateIncident(ctx, eventTable, alertToEvent(ctx, data, alert))
+		countIncidentOperation(ctx, eventTable, incidentCreated, err)
+		if err != nil {
+			serviceNowError.Inc()
+			loggerFrom(ctx).Errorf("Error sending the event of alert %s: %v", alertFingerprint(alert), err)
+			errs = append(errs, err.Error())
+			if e, ok := err.(*overloadError); ok {
+				overload = e
+			}
+		}
+	}
+	if len(errs) == 0 {
+		loggerFrom(ctx).Infof("Sent %d event(s)", len(data.Alerts))
+		return nil
+	}
+	message := fmt.Sprintf("Error sending %d of %d event(s): %s", len(errs), len(data.Alerts), strings.Join(errs, "; "))
+	if overload != nil {
+		return &overloadError{message: message, retryAfter: overload.retryAfter}
+	}
+	return errors.New(message)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestOnAlertGroup_Events(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Target = targetEvent
+	currentConfig().Event = EventConfig{Fields: map[string]string{"resource": "{{ .Labels.device }}"}}
+	applyConfig()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("CreateIncident", eventTable, mock.Anything).Return(Incident{}, nil)
+
+	startsAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	data := template.Data{
+		Status:      "firing",
+		GroupLabels: template.KV{"alertname": "DiskFull"},
+		Alerts: template.Alerts{
+			template.Alert{
+				Status:      "firing",
+				Fingerprint: "f1",
+				StartsAt:    startsAt,
+				Labels:      template.KV{"alertname": "DiskFull", "instance": "web01", "device": "sda", "severity": "critical"},
+				Annotations: template.KV{"summary": "Disk full"},
+			},
+			template.Alert{Status: "resolved", Fingerprint: "f2", Labels: template.KV{"alertname": "DiskFull", "instance": "web02"}},
+		},
+	}
+	if err := onAlertGroup(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+
+	snClientMock.AssertNotCalled(t, "GetIncidents", mock.Anything, mock.Anything)
+	snClientMock.AssertNumberOfCalls(t, "CreateIncident", 2)
+	event := snClientMock.Calls[0].Arguments.Get(1).(Incident)
+	want := Incident{
+		"source":             "Alertmanager",
+		"node":               "web01",
+		"type":               "DiskFull",
+		"metric_name":        "DiskFull",
+		"description":        "Disk full",
+		"resource":           "sda",
+		eventSeverityField:   "1",
+		eventMessageKeyField: "f1",
+		eventTimeField:       "2020-01-02 03:04:05",
+	}
+	for field, value := range want {
+		if event[field] != value {
+			t.Errorf("Unexpected event %s: got %v, want %v", field, event[field], value)
+		}
+	}
+	var info map[string]string
+	if err := json.Unmarshal([]byte(event[eventAdditionalInfoField].(string)), &info); err != nil || info["device"] != "sda" || info["summary"] != "Disk full" {
+		t.Errorf("Unexpected additional info: %v (%v)", event[eventAdditionalInfoField], err)
+	}
+
+	resolved := snClientMock.Calls[1].Arguments.Get(1).(Incident)
+	if resolved[eventSeverityField] != eventClearSeverity || resolved["node"] != "web02" {
+		t.Errorf("Unexpected resolved event: %v", resolved)
+	}
+}
+
+func TestEventConfig_Severity(t *testing.T) {
+	c := EventConfig{Severities: map[string]string{"page": "1"}, DefaultSeverity: "5"}
+	tests := []struct {
+		name  string
+		alert template.Alert
+		want  string
+	}{
+		{name: "mapped", alert: template.Alert{Status: "firing", Labels: template.KV{"severity": "page"}}, want: "1"},
+		{name: "default", alert: template.Alert{Status: "firing", Labels: template.KV{"severity": "critical"}}, want: "5"},
+		{name: "resolved", alert: template.Alert{Status: "resolved", Labels: template.KV{"severity": "page"}}, want: eventClearSeverity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.severity(tt.alert); got != tt.want {
+				t.Errorf("severity() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTarget(t *testing.T) {
+	var errs strings.Builder
+	validateTarget(Config{Target: "problem", Event: EventConfig{Severities: map[string]string{"critical": "0"}}}, &errs)
+	if !strings.Contains(errs.String(), "target must be") || !strings.Contains(errs.String(), "event.severities.critical") {
+		t.Errorf("Unexpected validation errors: %q", errs.String())
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	exemplarTraceIDLabel   = "trace_id"
+	exemplarRequestIDLabel = "request_id"
+)
+
+var openMetricsMetricsHandler = promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+	promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+
+// observeWithExemplar observes the value, with the trace ID of the span of the context as exemplar when sampled, so
+// that the slow requests of the latency histograms link to their trace, or else with the request ID of the webhook
+// delivery of the context, when it fits the exemplar size limit
+func observeWithExemplar(ctx context.Context, observer prometheus.Observer, value float64) {
+	exemplarObserver, ok := observer.(prometheus.ExemplarObserver)
+	if !ok {
+		observer.Observe(value)
+		return
+	}
+	if s := spanFrom(ctx); s != nil {
+		exemplarObserver.ObserveWithExemplar(value, prometheus.Labels{exemplarTraceIDLabel: s.SpanContext().TraceID().String()})
+		return
+	}
+	id := requestIDFrom(ctx)
+	if len(id) == 0 || !utf8.ValidString(id) || utf8.RuneCountInString(exemplarRequestIDLabel+id) > prometheus.ExemplarMaxRunes {
+		observer.Observe(value)
+		return
+	}
+	exemplarObserver.ObserveWithExemplar(value, prometheus.Labels{exemplarRequestIDLabel: id})
+}
+
+// metricsHandler serves the metrics, in the OpenMetrics format, the only one exposing the exemplars, to the scrapers
+// accepting it
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	openMetricsMetricsHandler.ServeHTTP(w, r)
+}
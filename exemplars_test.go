@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func TestObserveWithExemplar(t *testing.T) {
+	_, cleanup := newTestTracer(t)
+	defer cleanup()
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_duration_seconds", Buckets: []float64{1}})
+	ctx, s := startSpan(withRemoteParent(context.Background(), testTraceParent), "ServiceNow POST", spanKindClient)
+	observeWithExemplar(ctx, histogram, 0.5)
+	observeWithExemplar(context.Background(), histogram, 2)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(histogram)
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=0.0.1")
+	rr := httptest.NewRecorder()
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{EnableOpenMetrics: true}).ServeHTTP(rr, req)
+	body := rr.Body.String()
+
+	if !strings.Contains(body, `test_duration_seconds_bucket{le="1.0"} 1 # {trace_id="4bf92f3577b34da6a3ce929d0e0e4736"} 0.5`) {
+		t.Errorf("The observation should have the trace ID of span %v as exemplar: %s", s, body)
+	}
+	if !strings.Contains(body, "test_duration_seconds_count 2") || strings.Count(body, "# {") != 1 {
+		t.Errorf("The observation without span should have no exemplar: %s", body)
+	}
+}
+
+func TestObserveWithExemplar_RequestID(t *testing.T) {
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_duration_seconds", Buckets: []float64{1}})
+	observeWithExemplar(withAuditCaller(context.Background(), auditCaller{RequestID: "2c5ea4c0f4a3b21e"}), histogram, 0.5)
+	observeWithExemplar(withAuditCaller(context.Background(), auditCaller{RequestID: strings.Repeat("a", 60)}), histogram, 2)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(histogram)
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=0.0.1")
+	rr := httptest.NewRecorder()
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{EnableOpenMetrics: true}).ServeHTTP(rr, req)
+	body := rr.Body.String()
+
+	if !strings.Contains(body, `test_duration_seconds_bucket{le="1.0"} 1 # {request_id="2c5ea4c0f4a3b21e"} 0.5`) {
+		t.Errorf("The observation without span should have the request ID as exemplar: %s", body)
+	}
+	if !strings.Contains(body, "test_duration_seconds_count 2") || strings.Count(body, "# {") != 1 {
+		t.Errorf("The request ID exceeding the exemplar size limit should be left out: %s", body)
+	}
+}
+
+func TestMetricsHandler_OpenMetrics(t *testing.T) {
+	for _, accept := range []string{"application/openmetrics-text; version=0.0.1", "text/plain"} {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		req.Header.Set("Accept", accept)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(metricsHandler).ServeHTTP(rr, req)
+		body, _ := ioutil.ReadAll(rr.Body)
+
+		openMetrics := strings.HasPrefix(accept, "application/openmetrics-text")
+		if strings.HasPrefix(rr.Header().Get("Content-Type"), "application/openmetrics-text") != openMetrics || strings.HasSuffix(string(body), "# EOF\n") != openMetrics {
+			t.Errorf("The OpenMetrics format should only be served to the scrapers accepting it (%s): %s", accept, rr.Header().Get("Content-Type"))
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	fieldLimitTruncate   = "truncate"
+	fieldLimitWorkNotes  = "work_notes"
+	fieldLimitAttachment = "attachment"
+	fieldLimitReject     = "reject"
+	ellipsis             = "..."
+)
+
+type fieldOverflowsContextKey struct{}
+
+// fieldOverflows are the full values of the incident fields truncated with the attachment policy, by field
+type fieldOverflows map[string]string
+
+// FieldLimitConfig - Maximum length of an incident field, and what to do with longer values
+type FieldLimitConfig struct {
+	MaxLength int    `yaml:"max_length"`
+	Policy    string `yaml:"policy"`
+}
+
+// fieldLengthError is returned when an incident field exceeds its maximum length with the reject policy
+type fieldLengthError struct {
+	field     string
+	length    int
+	maxLength int
+}
+
+func (e *fieldLengthError) Error() string {
+	return fmt.Sprintf("incident field %s is %d characters long, exceeding its maximum length of %d", e.field, e.length, e.maxLength)
+}
+
+func validateFieldLimits(c Config, errs *strings.Builder) {
+	for field, limit := range c.FieldLimits {
+		if limit.MaxLength <= len(ellipsis) {
+			errs.WriteString(fmt.Sprintf("field_limits.%s.max_length must be greater than %d\n", field, len(ellipsis)))
+		}
+		switch limit.Policy {
+		case "", fieldLimitTruncate, fieldLimitAttachment, fieldLimitReject:
+		case fieldLimitWorkNotes:
+			if field == workNotesField {
+				errs.WriteString(fmt.Sprintf("field_limits.%s.policy cannot be %q\n", field, fieldLimitWorkNotes))
+			}
+		default:
+			errs.WriteString(fmt.Sprintf("field_limits.%s.policy must be one of %q, %q, %q or %q\n", field, fieldLimitTruncate, fieldLimitWorkNotes, fieldLimitAttachment, fieldLimitReject))
+		}
+	}
+}
+
+// truncate returns the first characters of the value, ending with an ellipsis, so that it is maxLength characters long
+func truncate(value string, maxLength int) string {
+	runes := []rune(value)
+	return string(runes[:maxLength-len(ellipsis)]) + ellipsis
+}
+
+// applyFieldLimits enforces the maximum length of the incident fields: longer values are truncated with an ellipsis,
+// truncated with their full value moved to the work notes or returned to be attached, or rejected with a
+// fieldLengthError, according to the policy of the field.
+func applyFieldLimits(limits map[string]FieldLimitConfig, incident Incident) (fieldOverflows, error) {
+	overflows := fieldOverflows{}
+	fields := make([]string, 0, len(limits))
+	for field := range limits {
+		fields = append(fields, field)
+	}
+	// Work notes are limited last, once the overflow of the other fields is moved to them
+	sort.Slice(fields, func(i, j int) bool {
+		if fields[i] == workNotesField || fields[j] == workNotesField {
+			return fields[j] == workNotesField && fields[i] != workNotesField
+		}
+		return fields[i] < fields[j]
+	})
+
+	for _, field := range fields {
+		limit := limits[field]
+		value, ok := incident[field].(string)
+		if !ok || utf8.RuneCountInString(value) <= limit.MaxLength {
+			continue
+		}
+		switch limit.Policy {
+		case fieldLimitReject:
+			return nil, &fieldLengthError{field: field, length: utf8.RuneCountInString(value), maxLength: limit.MaxLength}
+		case fieldLimitWorkNotes:
+			note := fmt.Sprintf("Full %s:\n%s", field, value)
+			if existing, ok := incident[workNotesField].(string); ok && len(existing) > 0 {
+				note = existing + "\n\n" + note
+			}
+			incident[workNotesField] = note
+		case fieldLimitAttachment:
+			overflows[field] = value
+		}
+		incident[field] = truncate(value, limit.MaxLength)
+	}
+	return overflows, nil
+}
+
+// withFieldOverflows returns a context collecting the full values of the fields truncated with the attachment policy
+func withFieldOverflows(ctx context.Context) (context.Context, fieldOverflows) {
+	overflows := fieldOverflows{}
+	return context.WithValue(ctx, fieldOverflowsContextKey{}, overflows), overflows
+}
+
+// recordFieldOverflows records the full values of the truncated fields in the context, when collected
+func recordFieldOverflows(ctx context.Context, overflows fieldOverflows) {
+	collected, _ := ctx.Value(fieldOverflowsContextKey{}).(fieldOverflows)
+	if collected == nil {
+		return
+	}
+	for field, value := range overflows {
+		collected[field] = value
+	}
+}
+
+// attachFieldOverflows attaches the full value of each truncated field to the incident created, as <field>.txt.
+// A failed attachment is only logged, the incident being created nonetheless.
+func attachFieldOverflows(ctx context.Context, tableName string, incident Incident, overflows fieldOverflows) {
+	sysID, _ := incident["sys_id"].(string)
+	if len(overflows) == 0 || (len(sysID) == 0 && !isDryRun(ctx)) {
+		return
+	}
+	for field, value := range overflows {
+		if err := serviceNowFrom(ctx).AttachFile(ctx, tableName, sysID, field+".txt", "text/plain", []byte(value)); err != nil {
+			attachmentErrors.Inc()
+			loggerFrom(ctx).Errorf("Error attaching the full %s to incident %v: %v", field, incident["number"], err)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestApplyFieldLimits(t *testing.T) {
+	limits := map[string]FieldLimitConfig{
+		"short_description": FieldLimitConfig{MaxLength: 10, Policy: fieldLimitWorkNotes},
+		"description":       FieldLimitConfig{MaxLength: 8},
+		workNotesField:      FieldLimitConfig{MaxLength: 40},
+		"comments":          FieldLimitConfig{MaxLength: 20, Policy: fieldLimitTruncate},
+	}
+	incident := Incident{"short_description": "Disk full on web01", "description": "Disk full ééé", "comments": "short"}
+	if _, err := applyFieldLimits(limits, incident); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Incident{
+		"short_description": "Disk fu...",
+		"description":       "Disk ...",
+		workNotesField:      "Full short_description:\nDisk full on ...",
+		"comments":          "short",
+	}
+	for field, value := range expected {
+		if incident[field] != value {
+			t.Errorf("Unexpected %s: got %q, want %q", field, incident[field], value)
+		}
+	}
+}
+
+func TestApplyFieldLimits_Reject(t *testing.T) {
+	_, err := applyFieldLimits(map[string]FieldLimitConfig{"short_description": FieldLimitConfig{MaxLength: 5, Policy: fieldLimitReject}}, Incident{"short_description": "Disk full"})
+	if _, ok := err.(*fieldLengthError); !ok || !strings.Contains(err.Error(), "short_description is 9 characters long") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestWebhook_FieldLimitAttachment(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().FieldLimits = map[string]FieldLimitConfig{"short_description": FieldLimitConfig{MaxLength: 5, Policy: fieldLimitAttachment}}
+	dedupStore = newMemoryDedupStore()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", mock.Anything, mock.MatchedBy(func(incident Incident) bool {
+		return len([]rune(incident["short_description"].(string))) == 5
+	})).Return(Incident{"sys_id": "1", "number": "INC1"}, nil)
+	snClientMock.On("AttachFile", "incident", "1", "short_description.txt", "text/plain", mock.MatchedBy(func(content []byte) bool {
+		return len(content) > 5
+	})).Return(nil)
+
+	rr := postAlertGroup(t, "test/alertmanager_firing.json")
+	if rr.Code != http.StatusOK {
+		t.Errorf("Unexpected status: got %v, want %v", rr.Code, http.StatusOK)
+	}
+	snClientMock.AssertExpectations(t)
+}
+
+func TestWebhook_FieldLengthRejected(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().FieldLimits = map[string]FieldLimitConfig{"short_description": FieldLimitConfig{MaxLength: 5, Policy: fieldLimitReject}}
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+
+	rr := postAlertGroup(t, "test/alertmanager_firing.json")
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Unexpected status: got %v, want %v", rr.Code, http.StatusUnprocessableEntity)
+	}
+	snClientMock.AssertNotCalled(t, "CreateIncident", mock.Anything, mock.Anything)
+}
+
+func TestAlertGroupToIncident_FieldLimits(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().FieldLimits = map[string]FieldLimitConfig{"short_description": FieldLimitConfig{MaxLength: 5}}
+	incident, err := alertGroupToIncident(context.Background(), "incident", template.Data{CommonLabels: template.KV{"alertname": "DiskFull"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len([]rune(incident["short_description"].(string))) != 5 {
+		t.Errorf("Unexpected short_description: %q", incident["short_description"])
+	}
+}
+
+func TestValidateFieldLimits(t *testing.T) {
+	var errs strings.Builder
+	validateFieldLimits(Config{FieldLimits: map[string]FieldLimitConfig{
+		"short_description": FieldLimitConfig{MaxLength: 2},
+		workNotesField:      FieldLimitConfig{MaxLength: 100, Policy: fieldLimitWorkNotes},
+		"description":       FieldLimitConfig{MaxLength: 100, Policy: "other"},
+	}}, &errs)
+	for _, expected := range []string{"short_description.max_length", "work_notes.policy cannot", "description.policy must"} {
+		if !strings.Contains(errs.String(), expected) {
+			t.Errorf("Missing validation error %q: %q", expected, errs.String())
+		}
+	}
+}
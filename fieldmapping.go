@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+const (
+	fieldMappingLabel      = "label"
+	fieldMappingAnnotation = "annotation"
+	// fieldMappingGeneratorURL maps the URL of the graph of the alert expression, in Prometheus, having no name
+	fieldMappingGeneratorURL = "generator_url"
+)
+
+// fieldMapping sets an incident field with the value of an alert label or annotation, or with its generator URL
+type fieldMapping struct {
+	field  string
+	source string
+	name   string
+}
+
+func validateFieldMappings(c Config, errs *strings.Builder) {
+	if _, err := parseFieldMappings(c.FieldMappings); err != nil {
+		errs.WriteString(err.Error() + "\n")
+	}
+	for tableName, mappings := range c.TableFieldMappings {
+		if _, err := parseFieldMappings(mappings); err != nil {
+			errs.WriteString(fmt.Sprintf("table_field_mappings.%s: %v\n", tableName, err))
+		}
+	}
+}
+
+// parseFieldMappings parses the field mappings, of the form "label:<name>", "annotation:<name>" or "generator_url",
+// sorted by field
+func parseFieldMappings(mappings map[string]string) ([]fieldMapping, error) {
+	fields := make([]string, 0, len(mappings))
+	for field := range mappings {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	parsed := make([]fieldMapping, 0, len(mappings))
+	for _, field := range fields {
+		if mappings[field] == fieldMappingGeneratorURL {
+			parsed = append(parsed, fieldMapping{field: field, source: fieldMappingGeneratorURL})
+			continue
+		}
+		parts := strings.SplitN(mappings[field], ":", 2)
+		if len(parts) != 2 || len(parts[1]) == 0 || (parts[0] != fieldMappingLabel && parts[0] != fieldMappingAnnotation) {
+			return nil, fmt.Errorf("field_mappings.%s must be of the form %q, %q or %q, got %q", field, fieldMappingLabel+":<name>", fieldMappingAnnotation+":<name>", fieldMappingGeneratorURL, mappings[field])
+		}
+		parsed = append(parsed, fieldMapping{field: field, source: parts[0], name: parts[1]})
+	}
+	return parsed, nil
+}
+
+// value returns the value of the label or annotation common to the alert group, or else the one of its first alert having it
+func (m fieldMapping) value(data template.Data) string {
+	if m.source == fieldMappingGeneratorURL {
+		for _, alert := range data.Alerts {
+			if len(alert.GeneratorURL) > 0 {
+				return alert.GeneratorURL
+			}
+		}
+		return ""
+	}
+	common, alertValue := data.CommonLabels, func(alert template.Alert) string { return alert.Labels[m.name] }
+	if m.source == fieldMappingAnnotation {
+		common, alertValue = data.CommonAnnotations, func(alert template.Alert) string { return alert.Annotations[m.name] }
+	}
+	if value := common[m.name]; len(value) > 0 {
+		return value
+	}
+	for _, alert := range data.Alerts {
+		if value := alertValue(alert); len(value) > 0 {
+			return value
+		}
+	}
+	return ""
+}
+
+// prefixedLabelMappings returns the field mappings of the labels of the alert group having the prefix, sorted by field.
+// The field is the label name without the prefix, e.g. u_application for servicenow_u_application. The incident group
+// key field is never mapped, so that the incident is still found by its alert group.
+func prefixedLabelMappings(prefix string, keyField string, data template.Data) []fieldMapping {
+	names := map[string]bool{}
+	for name := range data.CommonLabels {
+		names[name] = true
+	}
+	for _, alert := range data.Alerts {
+		for name := range alert.Labels {
+			names[name] = true
+		}
+	}
+
+	var mappings []fieldMapping
+	for name := range names {
+		field := strings.TrimPrefix(name, prefix)
+		if !strings.HasPrefix(name, prefix) || len(field) == 0 || field == keyField {
+			continue
+		}
+		mappings = append(mappings, fieldMapping{field: field, source: fieldMappingLabel, name: name})
+	}
+	sort.Slice(mappings, func(i, j int) bool { return mappings[i].field < mappings[j].field })
+	return mappings
+}
+
+// applyFieldMappings sets the mapped incident fields, overriding the templated ones. Fields without value are left as is.
+func applyFieldMappings(mappings []fieldMapping, incident Incident, data template.Data) {
+	for _, m := range mappings {
+		if value := m.value(data); len(value) > 0 {
+			incident[m.field] = value
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+func TestParseFieldMappings(t *testing.T) {
+	got, err := parseFieldMappings(map[string]string{"u_env": "annotation:environment", "category": "label:service_category"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []fieldMapping{
+		{field: "category", source: fieldMappingLabel, name: "service_category"},
+		{field: "u_env", source: fieldMappingAnnotation, name: "environment"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected field mappings: got %v, want %v", got, want)
+	}
+
+	for _, invalid := range []string{"service_category", "label:", "annotations:environment"} {
+		if _, err := parseFieldMappings(map[string]string{"category": invalid}); err == nil {
+			t.Errorf("Expected an error parsing %q, got none", invalid)
+		}
+	}
+}
+
+func TestValidateFieldMappings(t *testing.T) {
+	var errs strings.Builder
+	validateFieldMappings(Config{FieldMappings: map[string]string{"category": "service_category"}}, &errs)
+	if !strings.Contains(errs.String(), "field_mappings.category") {
+		t.Errorf("Unexpected validation errors: %q", errs.String())
+	}
+}
+
+func TestAlertGroupToIncident_FieldMappings(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().FieldMappings = map[string]string{
+		"category":  "label:service_category",
+		"u_env":     "annotation:environment",
+		"u_team":    "label:team",
+		"u_missing": "label:missing",
+	}
+	applyConfig()
+
+	data := template.Data{
+		CommonLabels:      template.KV{"service_category": "Database"},
+		CommonAnnotations: template.KV{"environment": "production"},
+		Alerts: template.Alerts{
+			template.Alert{Labels: template.KV{"service_category": "Database"}},
+			template.Alert{Labels: template.KV{"service_category": "Database", "team": "dba"}},
+		},
+	}
+	incident, err := alertGroupToIncident(context.Background(), "incident", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if incident["category"] != "Database" || incident["u_env"] != "production" || incident["u_team"] != "dba" {
+		t.Errorf("Unexpected mapped fields: %v", incident)
+	}
+	if _, ok := incident["u_missing"]; ok {
+		t.Errorf("Field without value should not be mapped: %v", incident["u_missing"])
+	}
+}
+
+func TestAlertGroupToIncident_TableFieldMappings(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().FieldMappings = map[string]string{"category": "label:service_category"}
+	currentConfig().TableFieldMappings = map[string]map[string]string{
+		"sn_si_incident": {"category": "label:threat", "affected_user": "label:user"},
+	}
+	applyConfig()
+
+	data := template.Data{
+		CommonLabels: template.KV{"service_category": "Security", "threat": "Intrusion", "user": "jdoe"},
+		Alerts:       template.Alerts{template.Alert{Labels: template.KV{"service_category": "Security", "threat": "Intrusion", "user": "jdoe"}}},
+	}
+	incident, err := alertGroupToIncident(context.Background(), "sn_si_incident", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if incident["category"] != "Intrusion" || incident["affected_user"] != "jdoe" {
+		t.Errorf("The table field mappings should override the field mappings: %v", incident)
+	}
+	incident, err = alertGroupToIncident(context.Background(), "incident", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := incident["affected_user"]; ok || incident["category"] != "Security" {
+		t.Errorf("The table field mappings should only apply to their table: %v", incident)
+	}
+
+	var errs strings.Builder
+	validateFieldMappings(Config{TableFieldMappings: map[string]map[string]string{"sc_task": {"u_request": "request"}}}, &errs)
+	if !strings.Contains(errs.String(), "table_field_mappings.sc_task: field_mappings.u_request") {
+		t.Errorf("Unexpected validation errors: %q", errs.String())
+	}
+}
+
+func TestAlertGroupToIncident_FieldLabelPrefix(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().FieldLabelPrefix = "servicenow_"
+	currentConfig().FieldMappings = map[string]string{"u_team": "label:team"}
+	applyConfig()
+
+	data := template.Data{
+		CommonLabels: template.KV{"servicenow_u_application": "payments"},
+		Alerts: template.Alerts{
+			template.Alert{Labels: template.KV{
+				"servicenow_u_application": "payments",
+				"servicenow_u_region":      "eu",
+				"servicenow_u_team":        "overridden",
+				"servicenow_" + currentConfig().Workflow.IncidentGroupKeyField: "forged",
+				"team": "dba",
+			}},
+		},
+	}
+	incident, err := alertGroupToIncident(context.Background(), "incident", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if incident["u_application"] != "payments" || incident["u_region"] != "eu" || incident["u_team"] != "dba" {
+		t.Errorf("Unexpected prefixed label fields: %v", incident)
+	}
+	if incident[currentConfig().Workflow.IncidentGroupKeyField] != getGroupKey(context.Background(), data) {
+		t.Errorf("The incident group key field should not be overridden: %v", incident[currentConfig().Workflow.IncidentGroupKeyField])
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/alertmanager/pkg/labels"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var webhookFilteredAlerts = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Name: "webhook_filtered_alerts_total",
+		Help: "Total number of alerts dropped by the alert filter.",
+	},
+)
+
+// AlertFilterConfig - Label matchers selecting the alerts that create/update incidents, such as severity=~"critical|major"
+type AlertFilterConfig struct {
+	// Include holds the matchers an alert must all match
+	Include []string `yaml:"include"`
+	// Exclude holds the matchers of which an alert must match none
+	Exclude []string `yaml:"exclude"`
+}
+
+type alertFilter struct {
+	include []*labels.Matcher
+	exclude []*labels.Matcher
+}
+
+func (c AlertFilterConfig) validate(errs *strings.Builder) {
+	for _, matcher := range c.Include {
+		if _, err := labels.ParseMatcher(matcher); err != nil {
+			errs.WriteString(fmt.Sprintf("alert_filter.include matcher %s is invalid: %v\n", matcher, err))
+		}
+	}
+	for _, matcher := range c.Exclude {
+		if _, err := labels.ParseMatcher(matcher); err != nil {
+			errs.WriteString(fmt.Sprintf("alert_filter.exclude matcher %s is invalid: %v\n", matcher, err))
+		}
+	}
+}
+
+// newAlertFilter parses the matchers validated with the config, returning nil when there is none
+func newAlertFilter(c AlertFilterConfig) *alertFilter {
+	if len(c.Include) == 0 && len(c.Exclude) == 0 {
+		return nil
+	}
+	return &alertFilter{include: parseMatchers(c.Include), exclude: parseMatchers(c.Exclude)}
+}
+
+func parseMatchers(matchers []string) []*labels.Matcher {
+	parsed := make([]*labels.Matcher, 0, len(matchers))
+	for _, matcher := range matchers {
+		if m, err := labels.ParseMatcher(matcher); err == nil {
+			parsed = append(parsed, m)
+		}
+	}
+	return parsed
+}
+
+func newAlertFilters(c Config) (*alertFilter, map[string]*alertFilter) {
+	receiverFilters := make(map[string]*alertFilter, len(c.Receivers))
+	for name, receiver := range c.Receivers {
+		if f := newAlertFilter(receiver.AlertFilter); f != nil {
+			receiverFilters[name] = f
+		}
+	}
+	return newAlertFilter(c.AlertFilter), receiverFilters
+}
+
+// keeps returns whether the alert matches all the include matchers and none of the exclude ones
+func (f *alertFilter) keeps(alert template.Alert) bool {
+	for _, m := range f.include {
+		if !m.Matches(alert.Labels[m.Name]) {
+			return false
+		}
+	}
+	for _, m := range f.exclude {
+		if m.Matches(alert.Labels[m.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// filter removes from the alert group the alerts not kept by the filter, returning whether some were
+func (f *alertFilter) filter(ctx context.Context, data template.Data) (template.Data, bool) {
+	if f == nil {
+		return data, false
+	}
+	alerts := make(template.Alerts, 0, len(data.Alerts))
+	for _, alert := range data.Alerts {
+		if !f.keeps(alert) {
+			webhookFilteredAlerts.Inc()
+			loggerFrom(ctx).Debugf("Alert %s dropped by the alert filter: Labels=%v", alertFingerprint(alert), alert.Labels)
+			continue
+		}
+		alerts = append(alerts, alert)
+	}
+	if len(alerts) == len(data.Alerts) {
+		return data, false
+	}
+	data.Alerts = alerts
+	data.Status = "resolved"
+	if len(alerts.Firing()) > 0 {
+		data.Status = "firing"
+	}
+	return data, true
+}
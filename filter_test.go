@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAlertFilter_Filter(t *testing.T) {
+	f := newAlertFilter(AlertFilterConfig{Include: []string{`severity=~"critical|major"`}, Exclude: []string{`team="sandbox"`}})
+	data := template.Data{
+		Status: "firing",
+		Alerts: template.Alerts{
+			template.Alert{Status: "firing", Labels: template.KV{"severity": "warning", "team": "db"}},
+			template.Alert{Status: "firing", Labels: template.KV{"severity": "critical", "team": "sandbox"}},
+			template.Alert{Status: "resolved", Labels: template.KV{"severity": "major", "team": "db"}},
+		},
+	}
+	before := testutil.ToFloat64(webhookFilteredAlerts)
+
+	got, filtered := f.filter(context.Background(), data)
+	if !filtered || len(got.Alerts) != 1 || got.Alerts[0].Labels["severity"] != "major" {
+		t.Fatalf("Unexpected filtered alerts: %+v", got.Alerts)
+	}
+	if got.Status != "resolved" {
+		t.Errorf("Alert group without firing alert should be resolved: %s", got.Status)
+	}
+	if dropped := testutil.ToFloat64(webhookFilteredAlerts) - before; dropped != 2 {
+		t.Errorf("Unexpected dropped alerts: got %v, want 2", dropped)
+	}
+}
+
+func TestOnAlertGroup_AlertFilter(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().AlertFilter = AlertFilterConfig{Exclude: []string{`team!="ops"`}}
+	applyConfig()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+
+	data := template.Data{
+		Status:      "firing",
+		GroupLabels: template.KV{"alertname": "InstanceDown"},
+		Alerts:      template.Alerts{template.Alert{Status: "firing", Labels: template.KV{"alertname": "InstanceDown", "team": "sandbox"}}},
+	}
+	if err := onAlertGroup(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+	snClientMock.AssertNotCalled(t, "GetIncidents", mock.Anything, mock.Anything)
+}
+
+func TestAlertFilterConfig_Validate(t *testing.T) {
+	var errs strings.Builder
+	AlertFilterConfig{Include: []string{`severity=~"(critical"`}, Exclude: []string{`team`}}.validate(&errs)
+	for _, want := range []string{"alert_filter.include matcher", "alert_filter.exclude matcher"} {
+		if !strings.Contains(errs.String(), want) {
+			t.Errorf("Validation errors %q should contain %q", errs.String(), want)
+		}
+	}
+}
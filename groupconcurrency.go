@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"sync"
+)
+
+func validateGroupConcurrency(c WorkflowConfig, errs *strings.Builder) {
+	if c.GroupConcurrency < 0 {
+		errs.WriteString("workflow.group_concurrency must not be negative\n")
+	}
+}
+
+// groupConcurrency returns the number of alert groups of a notification managed concurrently, one at a time by default
+func (c WorkflowConfig) groupConcurrency() int {
+	if c.GroupConcurrency <= 0 {
+		return 1
+	}
+	return c.GroupConcurrency
+}
+
+// forEachConcurrently calls f with each index up to n, with at most concurrency calls at a time, and waits for them
+func forEachConcurrently(n int, concurrency int, f func(i int)) {
+	if concurrency <= 1 || n <= 1 {
+		for i := 0; i < n; i++ {
+			f(i)
+		}
+		return
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	if concurrency > n {
+		concurrency = n
+	}
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				f(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
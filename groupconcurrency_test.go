@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestForEachConcurrently(t *testing.T) {
+	var mutex sync.Mutex
+	running, maxRunning := 0, 0
+	calls := make([]bool, 10)
+	forEachConcurrently(len(calls), 3, func(i int) {
+		mutex.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		calls[i] = true
+		mutex.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+	})
+	for i, called := range calls {
+		if !called {
+			t.Errorf("The index %d should be processed", i)
+		}
+	}
+	if maxRunning != 3 {
+		t.Errorf("Unexpected number of concurrent calls: got %d, want 3", maxRunning)
+	}
+}
+
+func TestOnAlertGroup_GroupConcurrency(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Workflow.IncidentPerAlert = true
+	currentConfig().Workflow.GroupConcurrency = 4
+	dedupStore = newMemoryDedupStore()
+	data := template.Data{Status: "firing", GroupLabels: template.KV{"alertname": "DiskFull"}}
+	for i := 0; i < 8; i++ {
+		data.Alerts = append(data.Alerts, template.Alert{Status: "firing", Labels: template.KV{"alertname": "DiskFull", "instance": fmt.Sprint(i)}})
+	}
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{"sys_id": "1", "number": "INC1"}, nil).Run(func(mock.Arguments) {
+		time.Sleep(5 * time.Millisecond)
+	})
+
+	ctx, results := withAlertResults(context.Background())
+	if err := onAlertGroup(ctx, data); err != nil {
+		t.Fatal(err)
+	}
+	snClientMock.AssertNumberOfCalls(t, "CreateIncident", 8)
+	list := results.list()
+	if len(list) != 8 {
+		t.Fatalf("Unexpected results: %+v", list)
+	}
+	for i, result := range list {
+		if result.Fingerprint != alertFingerprint(data.Alerts[i]) || result.Status != alertResultSuccess {
+			t.Errorf("The results should follow the order of the alerts: %+v", list)
+		}
+	}
+}
+
+func TestValidateGroupConcurrency(t *testing.T) {
+	var errs strings.Builder
+	validateGroupConcurrency(WorkflowConfig{GroupConcurrency: -1}, &errs)
+	if !strings.Contains(errs.String(), "workflow.group_concurrency") {
+		t.Errorf("Missing validation error: %q", errs.String())
+	}
+}
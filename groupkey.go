@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// AlertmanagerGroupKeyConfig - Recording of the groupKey of the Alertmanager webhook message on the incidents created
+// and updated for the alert group, so that the ServiceNow reports and workflows can link an incident back to the exact
+// Alertmanager group
+type AlertmanagerGroupKeyConfig struct {
+	Field string `yaml:"field"`
+}
+
+func (c AlertmanagerGroupKeyConfig) validate(config Config, errs *strings.Builder) {
+	if len(c.Field) == 0 {
+		return
+	}
+	if c.Field == config.Workflow.IncidentGroupKeyField {
+		errs.WriteString(fmt.Sprintf("alertmanager_group_key.field must not be workflow.incident_group_key_field %q, holding the deduplication key\n", c.Field))
+	}
+	if c.Field == config.RequestID.Field {
+		errs.WriteString(fmt.Sprintf("alertmanager_group_key.field must not be request_id.field %q\n", c.Field))
+	}
+}
+
+// applyAlertmanagerGroupKey sets the Alertmanager group key of the alert group on the field of the incident, when
+// configured and provided by the webhook message
+func applyAlertmanagerGroupKey(ctx context.Context, incident Incident) {
+	config := configFrom(ctx)
+	field := config.AlertmanagerGroupKey.Field
+	groupKey := auditCallerFrom(ctx).AlertmanagerGroupKey
+	if len(field) > 0 && len(groupKey) > 0 {
+		incident[field] = groupKey
+	}
+}
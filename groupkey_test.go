@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+)
+
+const groupKeyNotification = `{
+  "groupKey": "{}:{alertname=\"InstanceDown\"}",
+  "status": "firing",
+  "groupLabels": {"alertname": "InstanceDown"},
+  "alerts": [{"status": "firing", "labels": {"alertname": "InstanceDown"}, "fingerprint": "a"}]
+}`
+
+func TestWebhook_AlertmanagerGroupKey(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().AlertmanagerGroupKey = AlertmanagerGroupKeyConfig{Field: "correlation_id"}
+	dedupStore = newMemoryDedupStore()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	hasGroupKey := mock.MatchedBy(func(incident Incident) bool {
+		return incident["correlation_id"] == `{}:{alertname="InstanceDown"}`
+	})
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil).Once()
+	snClientMock.On("CreateIncident", "incident", hasGroupKey).Return(Incident{"sys_id": "1", "number": "INC1"}, nil)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{{"sys_id": "1", "number": "INC1", "state": "1"}}, nil)
+	snClientMock.On("UpdateIncident", "incident", hasGroupKey, "1").Return(Incident{}, nil)
+
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(webhook).ServeHTTP(rr, httptest.NewRequest("POST", "/webhook", strings.NewReader(groupKeyNotification)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Unexpected status: %v, %s", rr.Code, rr.Body.String())
+		}
+	}
+	snClientMock.AssertExpectations(t)
+}
+
+func TestAlertmanagerGroupKeyConfig_Validate(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().RequestID.Field = "correlation_display"
+	var errs strings.Builder
+	AlertmanagerGroupKeyConfig{Field: currentConfig().Workflow.IncidentGroupKeyField}.validate(*currentConfig(), &errs)
+	AlertmanagerGroupKeyConfig{Field: "correlation_display"}.validate(*currentConfig(), &errs)
+	for _, want := range []string{"must not be workflow.incident_group_key_field", "must not be request_id.field"} {
+		if !strings.Contains(errs.String(), want) {
+			t.Errorf("Missing validation error %q: %q", want, errs.String())
+		}
+	}
+}
+
+func TestReadWebhookMessage_GroupKey(t *testing.T) {
+	for payload, want := range map[string]string{
+		groupKeyNotification: `{}:{alertname="InstanceDown"}`,
+		`{"groupKey": 42, "status": "firing", "alerts": [{"status": "firing", "labels": {"alertname": "A"}}]}`: "42",
+	} {
+		message, err := readWebhookMessage(httptest.NewRequest("POST", "/webhook", strings.NewReader(payload)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(message.GroupKey) != want {
+			t.Errorf("Unexpected group key: got %q, want %q", message.GroupKey, want)
+		}
+	}
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	haBackendRedis          = "redis"
+	haBackendKubernetes     = "kubernetes"
+	defaultHALeaseDuration  = 15 * time.Second
+	defaultHARetryPeriod    = 5 * time.Second
+	defaultHALeaseName      = "alertmanager-webhook-servicenow"
+	haRequestTimeout        = 5 * time.Second
+	kubernetesTokenFile     = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	kubernetesCAFile        = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	kubernetesNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+	// kubernetesMicroTime is the format of the times of the Kubernetes leases
+	kubernetesMicroTime = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+var (
+	// leader elects the replica writing to ServiceNow, nil when the high availability mode is disabled
+	leader *leaderElector
+
+	// redisAcquireLeader extends the lock when held by the identity, or acquires it when free.
+	// KEYS[1] is the lock, ARGV[1] the identity and ARGV[2] the lease duration in milliseconds.
+	redisAcquireLeader = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return 1
+end
+return 0`)
+	// redisReleaseLeader deletes the lock when held by the identity
+	redisReleaseLeader = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+	haLeader = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "webhook_ha_leader",
+			Help: "Whether the replica is the leader writing to ServiceNow, in the high availability mode.",
+		},
+	)
+	haElectionErrors = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "webhook_ha_election_errors_total",
+			Help: "Total number of errors acquiring or renewing the leader lease, in the high availability mode.",
+		},
+	)
+)
+
+// HAConfig - High availability of several replicas, only the elected leader managing the incidents
+type HAConfig struct {
+	Backend       string                `yaml:"backend"`
+	Identity      string                `yaml:"identity"`
+	LeaseDuration time.Duration         `yaml:"lease_duration"`
+	RetryPeriod   time.Duration         `yaml:"retry_period"`
+	Redis         RedisConfig           `yaml:"redis"`
+	Kubernetes    KubernetesLeaseConfig `yaml:"kubernetes"`
+}
+
+// KubernetesLeaseConfig - Kubernetes Lease of the leader election, managed with the service account of the pod
+type KubernetesLeaseConfig struct {
+	Namespace string `yaml:"namespace"`
+	LeaseName string `yaml:"lease_name"`
+}
+
+func (c HAConfig) enabled() bool {
+	return len(c.Backend) > 0
+}
+
+func validateHA(c Config, errs *strings.Builder) {
+	ha := c.HA
+	switch ha.Backend {
+	case "", haBackendKubernetes:
+	case haBackendRedis:
+		if !ha.redis(c).enabled() {
+			errs.WriteString("ha.redis.addr is missing, and dedup.redis is not configured\n")
+		}
+		ha.Redis.validate("ha.redis", errs)
+	default:
+		errs.WriteString(fmt.Sprintf("ha.backend %q must be %q or %q\n", ha.Backend, haBackendRedis, haBackendKubernetes))
+	}
+	if ha.LeaseDuration < 0 || ha.RetryPeriod < 0 {
+		errs.WriteString("ha.lease_duration and ha.retry_period must not be negative\n")
+	} else if ha.enabled() && ha.retryPeriod() >= ha.leaseDuration() {
+		errs.WriteString("ha.retry_period must be shorter than ha.lease_duration\n")
+	}
+}
+
+// redis returns the Redis server of the leader lock, defaulting to the one of the deduplication store
+func (c HAConfig) redis(config Config) RedisConfig {
+	if !c.Redis.enabled() {
+		return config.Dedup.Redis
+	}
+	return c.Redis
+}
+
+func (c HAConfig) leaseDuration() time.Duration {
+	if c.LeaseDuration == 0 {
+		return defaultHALeaseDuration
+	}
+	return c.LeaseDuration
+}
+
+func (c HAConfig) retryPeriod() time.Duration {
+	if c.RetryPeriod == 0 {
+		return defaultHARetryPeriod
+	}
+	return c.RetryPeriod
+}
+
+// identity returns the identity of the replica, defaulting to its hostname, which is the pod name on Kubernetes
+func (c HAConfig) identity() (string, error) {
+	if len(c.Identity) > 0 {
+		return c.Identity, nil
+	}
+	return os.Hostname()
+}
+
+// leaderLock is a lease held by a single replica at a time
+type leaderLock interface {
+	// acquire acquires the lease for a new lease duration, or extends it when already held, returning whether it is held
+	acquire(ctx context.Context) (bool, error)
+	// release frees the lease when held, so that another replica takes over without waiting for its expiration
+	release(ctx context.Context) error
+}
+
+// leaderElector campaigns for the leader lease on each retry period. The replica remains leader while the lease
+// cannot be renewed, until the lease could have expired.
+type leaderElector struct {
+	lock          leaderLock
+	leaseDuration time.Duration
+	retryPeriod   time.Duration
+
+	mutex     sync.Mutex
+	leading   bool
+	renewedAt time.Time
+}
+
+// isLeader returns whether the replica manages the incidents, always when the high availability mode is disabled
+func (e *leaderElector) isLeader() bool {
+	if e == nil {
+		return true
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.leading
+}
+
+func (e *leaderElector) campaign(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, haRequestTimeout)
+	defer cancel()
+	now := time.Now()
+	held, err := e.lock.acquire(ctx)
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if err != nil {
+		haElectionErrors.Inc()
+		baseLogger.Warnf("Error acquiring the leader lease: %v", err)
+		// The lease may be taken over once expired, the replica steps down a retry period before
+		held = e.leading && now.Sub(e.renewedAt) < e.leaseDuration-e.retryPeriod
+	} else if held {
+		e.renewedAt = now
+	}
+	if held != e.leading {
+		if held {
+			baseLogger.Info("Elected leader, managing the incidents")
+		} else {
+			baseLogger.Warn("Not the leader anymore, standing by")
+		}
+	}
+	e.leading = held
+	if held {
+		haLeader.Set(1)
+	} else {
+		haLeader.Set(0)
+	}
+}
+
+// run campaigns for the leader lease until the context is done, then releases it
+func (e *leaderElector) run(ctx context.Context) {
+	e.campaign(ctx)
+	runEvery(ctx, e.retryPeriod, func() { e.campaign(ctx) })
+
+	e.mutex.Lock()
+	leading := e.leading
+	e.leading = false
+	e.mutex.Unlock()
+	haLeader.Set(0)
+	if leading {
+		releaseCtx, cancel := context.WithTimeout(context.Background(), haRequestTimeout)
+		defer cancel()
+		if err := e.lock.release(releaseCtx); err != nil {
+			baseLogger.Warnf("Error releasing the leader lease: %v", err)
+		}
+	}
+}
+
+// redisLeaderLock is a lock of a Redis server shared by the replicas, expiring after the lease duration
+type redisLeaderLock struct {
+	client   *redis.Client
+	key      string
+	identity string
+	duration time.Duration
+}
+
+func newRedisLeaderLock(c RedisConfig, identity string, duration time.Duration) (*redisLeaderLock, error) {
+	client, err := newRedisClient(c)
+	if err != nil {
+		return nil, err
+	}
+	return &redisLeaderLock{client: client, key: c.keyPrefix() + "leader", identity: identity, duration: duration}, nil
+}
+
+func (l *redisLeaderLock) acquire(ctx context.Context) (bool, error) {
+	held, err := redisAcquireLeader.Run(l.client.WithContext(ctx), []string{l.key}, l.identity, int64(l.duration/time.Millisecond)).Int()
+	return held == 1, err
+}
+
+func (l *redisLeaderLock) release(ctx context.Context) error {
+	return redisReleaseLeader.Run(l.client.WithContext(ctx), []string{l.key}, l.identity).Err()
+}
+
+// kubernetesLease is a coordination.k8s.io/v1 Lease, updated with optimistic concurrency so that a single replica
+// acquires it
+type kubernetesLease struct {
+	baseURL   string
+	tokenFile string
+	namespace string
+	name      string
+	identity  string
+	duration  time.Duration
+	client    *http.Client
+	now       func() time.Time
+}
+
+type kubernetesLeaseObject struct {
+	APIVersion string                 `json:"apiVersion"`
+	Kind       string                 `json:"kind"`
+	Metadata   map[string]interface{} `json:"metadata"`
+	Spec       kubernetesLeaseSpec    `json:"spec"`
+}
+
+type kubernetesLeaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+	LeaseTransitions     int    `json:"leaseTransitions"`
+}
+
+// newKubernetesLease returns the lease managed with the in-cluster service account of the pod
+func newKubernetesLease(c KubernetesLeaseConfig, identity string, duration time.Duration) (*kubernetesLease, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if len(host) == 0 || len(port) == 0 {
+		return nil, fmt.Errorf("not running in a Kubernetes cluster, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+	namespace := c.Namespace
+	if len(namespace) == 0 {
+		content, err := ioutil.ReadFile(kubernetesNamespaceFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the namespace of the pod: %v", err)
+		}
+		namespace = strings.TrimSpace(string(content))
+	}
+	name := c.LeaseName
+	if len(name) == 0 {
+		name = defaultHALeaseName
+	}
+	tlsConfig, err := newTLSConfig(TLSConfig{CAFile: kubernetesCAFile})
+	if err != nil {
+		return nil, err
+	}
+	return &kubernetesLease{
+		baseURL:   "https://" + net.JoinHostPort(host, port),
+		tokenFile: kubernetesTokenFile,
+		namespace: namespace,
+		name:      name,
+		identity:  identity,
+		duration:  duration,
+		client:    &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+		now:       time.Now,
+	}, nil
+}
+
+func (l *kubernetesLease) path() string {
+	return fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases", l.namespace)
+}
+
+// request sends the request to the Kubernetes API, decoding the lease of the response. It returns the status code of
+// the response, the not found and conflict errors being left to the caller.
+func (l *kubernetesLease) request(ctx context.Context, method string, path string, lease *kubernetesLeaseObject) (int, error) {
+	var body []byte
+	if lease != nil {
+		var err error
+		if body, err = json.Marshal(lease); err != nil {
+			return 0, err
+		}
+	}
+	req, err := http.NewRequest(method, l.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	// The service account tokens are rotated, the token file is read on each request
+	if len(l.tokenFile) > 0 {
+		token, err := ioutil.ReadFile(l.tokenFile)
+		if err != nil {
+			return 0, fmt.Errorf("error reading the service account token: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := l.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusConflict {
+		return resp.StatusCode, nil
+	}
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("Kubernetes %s %s returned HTTP %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(content)))
+	}
+	if lease != nil {
+		*lease = kubernetesLeaseObject{}
+		if err := json.Unmarshal(content, lease); err != nil {
+			return resp.StatusCode, fmt.Errorf("error decoding the lease: %v", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// expired returns whether the lease is free, or was not renewed by its holder within its duration
+func (l *kubernetesLease) expired(spec kubernetesLeaseSpec) bool {
+	if len(spec.HolderIdentity) == 0 {
+		return true
+	}
+	renewed, err := time.Parse(kubernetesMicroTime, spec.RenewTime)
+	if err != nil {
+		return true
+	}
+	return l.now().After(renewed.Add(time.Duration(spec.LeaseDurationSeconds) * time.Second))
+}
+
+func (l *kubernetesLease) acquire(ctx context.Context) (bool, error) {
+	now := l.now().UTC().Format(kubernetesMicroTime)
+	lease := &kubernetesLeaseObject{}
+	status, err := l.request(ctx, http.MethodGet, l.path()+"/"+l.name, lease)
+	if err != nil {
+		return false, err
+	}
+
+	if status == http.StatusNotFound {
+		lease = &kubernetesLeaseObject{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata:   map[string]interface{}{"name": l.name, "namespace": l.namespace},
+			Spec:       kubernetesLeaseSpec{HolderIdentity: l.identity, AcquireTime: now},
+		}
+		lease.Spec.LeaseDurationSeconds = int(l.duration.Seconds())
+		lease.Spec.RenewTime = now
+		status, err = l.request(ctx, http.MethodPost, l.path(), lease)
+		return err == nil && status != http.StatusConflict, err
+	}
+
+	if lease.Spec.HolderIdentity != l.identity {
+		if !l.expired(lease.Spec) {
+			return false, nil
+		}
+		lease.Spec.HolderIdentity = l.identity
+		lease.Spec.AcquireTime = now
+		lease.Spec.LeaseTransitions++
+	}
+	lease.Spec.LeaseDurationSeconds = int(l.duration.Seconds())
+	lease.Spec.RenewTime = now
+	// The lease carries its resourceVersion, the update conflicts when another replica updated it meanwhile
+	status, err = l.request(ctx, http.MethodPut, l.path()+"/"+l.name, lease)
+	return err == nil && status != http.StatusConflict, err
+}
+
+func (l *kubernetesLease) release(ctx context.Context) error {
+	lease := &kubernetesLeaseObject{}
+	status, err := l.request(ctx, http.MethodGet, l.path()+"/"+l.name, lease)
+	if err != nil || status == http.StatusNotFound || lease.Spec.HolderIdentity != l.identity {
+		return err
+	}
+	lease.Spec.HolderIdentity = ""
+	lease.Spec.LeaseDurationSeconds = 1
+	_, err = l.request(ctx, http.MethodPut, l.path()+"/"+l.name, lease)
+	return err
+}
+
+// loadLeaderElector starts the leader election, when the high availability mode is enabled
+func loadLeaderElector(config Config) error {
+	leader = nil
+	c := config.HA
+	if !c.enabled() {
+		return nil
+	}
+	identity, err := c.identity()
+	if err != nil {
+		return fmt.Errorf("error resolving the identity of the replica: %v", err)
+	}
+
+	var lock leaderLock
+	if c.Backend == haBackendKubernetes {
+		if lock, err = newKubernetesLease(c.Kubernetes, identity, c.leaseDuration()); err != nil {
+			return err
+		}
+	} else if lock, err = newRedisLeaderLock(c.redis(config), identity, c.leaseDuration()); err != nil {
+		return err
+	}
+	leader = &leaderElector{lock: lock, leaseDuration: c.leaseDuration(), retryPeriod: c.retryPeriod()}
+	backgroundTasks.Go("leader election", leader.run)
+	baseLogger.Infof("High availability mode enabled, campaigning for the leader lease as %s", identity)
+	return nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestRedisLeaderLock(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	a, _ := newRedisLeaderLock(RedisConfig{Addr: s.Addr()}, "a", 10*time.Second)
+	b, _ := newRedisLeaderLock(RedisConfig{Addr: s.Addr()}, "b", 10*time.Second)
+
+	if held, err := a.acquire(ctx); !held || err != nil {
+		t.Fatalf("The free lock should be acquired: %v, %v", held, err)
+	}
+	if held, err := b.acquire(ctx); held || err != nil {
+		t.Errorf("The lock held by another replica should not be acquired: %v, %v", held, err)
+	}
+	s.FastForward(5 * time.Second)
+	if held, err := a.acquire(ctx); !held || err != nil {
+		t.Errorf("The lock should be renewed: %v, %v", held, err)
+	}
+	s.FastForward(6 * time.Second)
+	if held, _ := b.acquire(ctx); held {
+		t.Errorf("The renewed lock should not have expired")
+	}
+
+	if err := b.release(ctx); err != nil || !s.Exists(a.key) {
+		t.Errorf("The lock should not be released by another replica: %v", err)
+	}
+	if err := a.release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if held, err := b.acquire(ctx); !held || err != nil {
+		t.Errorf("The released lock should be acquired: %v, %v", held, err)
+	}
+}
+
+// fakeLeaseServer serves a single Lease of the Kubernetes API, with optimistic concurrency
+type fakeLeaseServer struct {
+	mutex   sync.Mutex
+	lease   *kubernetesLeaseObject
+	version int
+}
+
+func (s *fakeLeaseServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.Method == http.MethodGet {
+		if s.lease == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(s.lease)
+		return
+	}
+
+	var lease kubernetesLeaseObject
+	body, _ := ioutil.ReadAll(r.Body)
+	json.Unmarshal(body, &lease)
+	if (r.Method == http.MethodPost && s.lease != nil) || (r.Method == http.MethodPut && lease.Metadata["resourceVersion"] != strconv.Itoa(s.version)) {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	s.version++
+	lease.Metadata["resourceVersion"] = strconv.Itoa(s.version)
+	s.lease = &lease
+	json.NewEncoder(w).Encode(s.lease)
+}
+
+// newTestTokenFile writes the service account token of the fake Kubernetes API
+func newTestTokenFile(t *testing.T) (string, func()) {
+	tokenFile, err := ioutil.TempFile("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenFile.WriteString("token\n")
+	tokenFile.Close()
+	return tokenFile.Name(), func() { os.Remove(tokenFile.Name()) }
+}
+
+func newTestKubernetesLease(baseURL string, tokenFile string, identity string, now *time.Time) *kubernetesLease {
+	return &kubernetesLease{
+		baseURL:   baseURL,
+		tokenFile: tokenFile,
+		namespace: "monitoring",
+		name:      defaultHALeaseName,
+		identity:  identity,
+		duration:  10 * time.Second,
+		client:    http.DefaultClient,
+		now:       func() time.Time { return *now },
+	}
+}
+
+func TestKubernetesLease(t *testing.T) {
+	server := &fakeLeaseServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	tokenFile, cleanup := newTestTokenFile(t)
+	defer cleanup()
+	ctx := context.Background()
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	a := newTestKubernetesLease(ts.URL, tokenFile, "a", &now)
+	b := newTestKubernetesLease(ts.URL, tokenFile, "b", &now)
+
+	if held, err := a.acquire(ctx); !held || err != nil {
+		t.Fatalf("The lease should be created: %v, %v", held, err)
+	}
+	if held, err := b.acquire(ctx); held || err != nil {
+		t.Errorf("The lease held by another replica should not be acquired: %v, %v", held, err)
+	}
+	now = now.Add(5 * time.Second)
+	if held, err := a.acquire(ctx); !held || err != nil {
+		t.Errorf("The lease should be renewed: %v, %v", held, err)
+	}
+
+	now = now.Add(11 * time.Second)
+	if held, err := b.acquire(ctx); !held || err != nil {
+		t.Fatalf("The expired lease should be acquired: %v, %v", held, err)
+	}
+	if server.lease.Spec.HolderIdentity != "b" || server.lease.Spec.LeaseTransitions != 1 {
+		t.Errorf("Unexpected lease: %+v", server.lease.Spec)
+	}
+	if held, _ := a.acquire(ctx); held {
+		t.Errorf("The lease taken over should not be acquired back")
+	}
+
+	if err := b.release(ctx); err != nil || len(server.lease.Spec.HolderIdentity) > 0 {
+		t.Errorf("The lease should be released: %v, %+v", err, server.lease.Spec)
+	}
+	if held, err := a.acquire(ctx); !held || err != nil {
+		t.Errorf("The released lease should be acquired: %v, %v", held, err)
+	}
+}
+
+func TestKubernetesLease_Conflict(t *testing.T) {
+	server := &fakeLeaseServer{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			// Another replica updated the lease meanwhile
+			server.version++
+		}
+		server.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	tokenFile, cleanup := newTestTokenFile(t)
+	defer cleanup()
+	now := time.Now()
+	a := newTestKubernetesLease(ts.URL, tokenFile, "a", &now)
+	a.acquire(context.Background())
+
+	if held, err := a.acquire(context.Background()); held || err != nil {
+		t.Errorf("The conflicting update should not acquire the lease: %v, %v", held, err)
+	}
+}
+
+// fakeLeaderLock returns its result on each acquisition
+type fakeLeaderLock struct {
+	held     bool
+	err      error
+	released bool
+}
+
+func (l *fakeLeaderLock) acquire(ctx context.Context) (bool, error) {
+	return l.held, l.err
+}
+
+func (l *fakeLeaderLock) release(ctx context.Context) error {
+	l.released = true
+	return nil
+}
+
+func TestLeaderElector(t *testing.T) {
+	var e *leaderElector
+	if !e.isLeader() {
+		t.Errorf("The replica should be the leader when the high availability mode is disabled")
+	}
+
+	lock := &fakeLeaderLock{held: true}
+	e = &leaderElector{lock: lock, leaseDuration: time.Hour, retryPeriod: time.Minute}
+	e.campaign(context.Background())
+	if !e.isLeader() {
+		t.Fatalf("The replica holding the lease should be the leader")
+	}
+	lock.err = errors.New("Error")
+	e.campaign(context.Background())
+	if !e.isLeader() {
+		t.Errorf("The leader should remain leader while its lease has not expired")
+	}
+	e.renewedAt = time.Now().Add(-time.Hour)
+	e.campaign(context.Background())
+	if e.isLeader() {
+		t.Errorf("The leader should step down before its lease expires")
+	}
+
+	lock.held, lock.err = true, nil
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	e.run(ctx)
+	if e.isLeader() || !lock.released {
+		t.Errorf("The lease should be released once the election stopped")
+	}
+}
+
+func TestWebhook_Standby(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	defer func(e *leaderElector) { leader = e }(leader)
+	leader = &leaderElector{}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(webhook).ServeHTTP(rr, httptest.NewRequest("POST", "/webhook", strings.NewReader(twoAlertsNotification)))
+	if rr.Code != http.StatusServiceUnavailable || len(rr.Header().Get("Retry-After")) == 0 {
+		t.Errorf("The standby replica should answer with a 503 and a Retry-After header: got %v", rr.Code)
+	}
+}
+
+func TestValidateHA(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().HA = HAConfig{Backend: haBackendRedis, LeaseDuration: time.Second, RetryPeriod: 2 * time.Second}
+	var errs strings.Builder
+	validateHA(*currentConfig(), &errs)
+	currentConfig().HA = HAConfig{Backend: "etcd"}
+	validateHA(*currentConfig(), &errs)
+	for _, want := range []string{"ha.redis.addr is missing", "ha.retry_period must be shorter", `ha.backend "etcd"`} {
+		if !strings.Contains(errs.String(), want) {
+			t.Errorf("Missing validation error %q: %q", want, errs.String())
+		}
+	}
+}
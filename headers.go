@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// headerName matches the valid HTTP header names (RFC 7230 tokens)
+var headerName = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+func validateHeaders(c ServiceNowConfig, errs *strings.Builder) {
+	var names []string
+	for name := range c.Headers {
+		names = append(names, name)
+	}
+	for name := range c.HeaderFiles {
+		if _, ok := c.Headers[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !headerName.MatchString(name) {
+			errs.WriteString(fmt.Sprintf("service_now.headers name %q is not a valid HTTP header name\n", name))
+		}
+	}
+}
+
+// loadHeaderFiles sets the headers of the header_files with the content of their file
+func (c *ServiceNowConfig) loadHeaderFiles(prefix string) error {
+	names := make([]string, 0, len(c.HeaderFiles))
+	for name := range c.HeaderFiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := c.Headers[name]; ok {
+			return fmt.Errorf("%s.headers and %s.header_files both set the %s header", prefix, prefix, name)
+		}
+		content, err := ioutil.ReadFile(c.HeaderFiles[name])
+		if err != nil {
+			return fmt.Errorf("error reading %s.header_files.%s: %v", prefix, name, err)
+		}
+		if c.Headers == nil {
+			c.Headers = map[string]string{}
+		}
+		c.Headers[name] = strings.TrimRight(string(content), "\r\n")
+	}
+	return nil
+}
+
+// headerTransport adds the configured static headers to each request, including the retries and the OAuth2 token
+// requests, without overriding the headers set by the client (e.g. Authorization or Content-Type)
+type headerTransport struct {
+	next    http.RoundTripper
+	headers http.Header
+}
+
+func newHeaderTransport(next http.RoundTripper, headers map[string]string) *headerTransport {
+	t := &headerTransport{next: next, headers: http.Header{}}
+	for name, value := range headers {
+		t.headers.Set(name, value)
+	}
+	return t
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the request
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header)+len(t.headers))
+	for name, values := range req.Header {
+		r.Header[name] = values
+	}
+	for name, values := range t.headers {
+		if _, ok := r.Header[name]; !ok {
+			r.Header[name] = values
+		}
+	}
+	return t.next.RoundTrip(r)
+}
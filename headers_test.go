@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewHTTPClient_Headers(t *testing.T) {
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+	}))
+	defer server.Close()
+
+	client, err := newHTTPClient(ServiceNowConfig{Headers: map[string]string{"X-Subscription-Key": "key", "Accept": "text/plain"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if header.Get("X-Subscription-Key") != "key" {
+		t.Errorf("The static header should be sent: %v", header)
+	}
+	if header.Get("Accept") != "application/json" {
+		t.Errorf("The static headers should not override the headers of the request: %v", header)
+	}
+	if len(req.Header) != 1 {
+		t.Errorf("The request should not be modified: %v", req.Header)
+	}
+}
+
+func TestLoadHeaderFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "headers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "token")
+	ioutil.WriteFile(file, []byte("secret\n"), 0600)
+
+	c := ServiceNowConfig{HeaderFiles: map[string]string{"X-UserToken": file}}
+	if err := c.loadHeaderFiles("service_now"); err != nil || c.Headers["X-UserToken"] != "secret" {
+		t.Errorf("The header should be read from its file: %v, %v", c.Headers, err)
+	}
+	c = ServiceNowConfig{Headers: map[string]string{"X-UserToken": "token"}, HeaderFiles: map[string]string{"X-UserToken": file}}
+	if err := c.loadHeaderFiles("service_now"); err == nil || !strings.Contains(err.Error(), "both set the X-UserToken header") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	c = ServiceNowConfig{HeaderFiles: map[string]string{"X-UserToken": filepath.Join(dir, "missing")}}
+	if err := c.loadHeaderFiles("service_now"); err == nil {
+		t.Errorf("Expected an error with a missing header file")
+	}
+}
+
+func TestValidateHeaders(t *testing.T) {
+	var errs strings.Builder
+	validateHeaders(ServiceNowConfig{Headers: map[string]string{"X Key": "key"}, HeaderFiles: map[string]string{"X:Token": "file"}}, &errs)
+	for _, want := range []string{`"X Key"`, `"X:Token"`} {
+		if !strings.Contains(errs.String(), want) {
+			t.Errorf("Missing validation error %s: %q", want, errs.String())
+		}
+	}
+}
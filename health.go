@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var readiness = &readinessCheck{}
+
+// readinessCheck caches the result of the check that the ServiceNow client can authenticate, for the TTL
+type readinessCheck struct {
+	mutex     sync.Mutex
+	checkedAt time.Time
+	err       error
+}
+
+// check returns the cached readiness, checking it again with a single table query once the TTL is elapsed
+func (c *readinessCheck) check(ctx context.Context, ttl time.Duration) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.checkedAt.IsZero() && time.Since(c.checkedAt) < ttl {
+		return c.err
+	}
+
+	ctx = withConfigSnapshot(ctx)
+	err := checkInstance(ctx)
+	for _, name := range configFrom(ctx).instanceNames() {
+		if err != nil {
+			break
+		}
+		if err = checkInstance(withInstance(ctx, name)); err != nil {
+			err = fmt.Errorf("instance %s: %v", name, err)
+		}
+	}
+	c.checkedAt = time.Now()
+	c.err = err
+	return err
+}
+
+// checkInstance queries a single record of the table of the ServiceNow instance of the context
+func checkInstance(ctx context.Context) error {
+	config := configFrom(ctx)
+	tableName := instanceConfigFrom(ctx).TableName
+	if len(tableName) == 0 {
+		tableName = config.ServiceNow.TableName
+	}
+	_, err := serviceNowFrom(ctx).GetIncidents(ctx, tableName, map[string]string{"sysparm_limit": "1", "sysparm_fields": "sys_id"})
+	if err != nil {
+		return fmt.Errorf("ServiceNow is not reachable: %v", err)
+	}
+	return nil
+}
+
+// last returns the time and the result of the last check, zero when not checked yet
+func (c *readinessCheck) last() (time.Time, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.checkedAt, c.err
+}
+
+// invalidate forces the next check, after the ServiceNow client is rebuilt
+func (c *readinessCheck) invalidate() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.checkedAt = time.Time{}
+}
+
+// healthy is the handler of /-/healthy, answering as long as the process serves requests
+func healthy(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("OK"))
+}
+
+// ready is the handler of /-/ready, answering once the ServiceNow clients can authenticate to their instance
+func ready(w http.ResponseWriter, r *http.Request) {
+	if err := readiness.check(r.Context(), *readinessCheckTTL); err != nil {
+		loggerFrom(r.Context()).Warnf("Not ready: %v", err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("OK"))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+func TestReady(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+	}{
+		{name: "authenticated", wantStatus: http.StatusOK},
+		{name: "unauthorized", err: errors.New("ServiceNow returned the HTTP error code: 401"), wantStatus: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loadConfig("config/servicenow_example.yml")
+			readiness.invalidate()
+			snClientMock := new(MockedSnClient)
+			useServiceNow(snClientMock)
+			snClientMock.On("GetIncidents", "incident", mock.Anything).Return([]Incident{}, tt.err)
+
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(ready).ServeHTTP(rr, httptest.NewRequest("GET", "/-/ready", nil))
+			if rr.Code != tt.wantStatus {
+				t.Errorf("ready() status = %v, want %v", rr.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestReadinessCheck_Cached(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "incident", mock.Anything).Return([]Incident{}, nil)
+
+	c := &readinessCheck{}
+	for i := 0; i < 3; i++ {
+		if err := c.check(context.Background(), time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snClientMock.AssertNumberOfCalls(t, "GetIncidents", 1)
+
+	c.check(context.Background(), 0)
+	snClientMock.AssertNumberOfCalls(t, "GetIncidents", 2)
+}
+
+func TestHealthy(t *testing.T) {
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(healthy).ServeHTTP(rr, httptest.NewRequest("GET", "/-/healthy", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("healthy() status = %v, want %v", rr.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	defaultIdempotencyWindow = 5 * time.Minute
+	// idempotencyKeyAlerts identifies a delivery by its receivers, group labels and alerts, idempotencyKeyPayload by
+	// its receiver and the hash of its full payload
+	idempotencyKeyAlerts  = "alerts"
+	idempotencyKeyPayload = "payload"
+	// deliveryProcessed is the value held by the key of a delivery once processed, dedupPending while in progress
+	deliveryProcessed = "processed"
+)
+
+var webhookDuplicateDeliveries = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "webhook_duplicate_deliveries_total",
+		Help: "Total number of webhook deliveries skipped as duplicates of a delivery already processed or in progress, by state.",
+	},
+	[]string{"state"},
+)
+
+// IdempotencyConfig - Skipping of the exact duplicates of the webhook deliveries processed within the window
+type IdempotencyConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Window  time.Duration `yaml:"window"`
+	Key     string        `yaml:"key"`
+}
+
+func (c IdempotencyConfig) validate(errs *strings.Builder) {
+	if c.Window < 0 {
+		errs.WriteString("webhook.idempotency.window must not be negative\n")
+	}
+	if len(c.Key) > 0 && c.Key != idempotencyKeyAlerts && c.Key != idempotencyKeyPayload {
+		errs.WriteString(fmt.Sprintf("webhook.idempotency.key must be %q or %q\n", idempotencyKeyAlerts, idempotencyKeyPayload))
+	}
+}
+
+func (c IdempotencyConfig) window() time.Duration {
+	if c.Window == 0 {
+		return defaultIdempotencyWindow
+	}
+	return c.Window
+}
+
+// deliveryKey is the deduplication store key identifying the delivery of the notification to the webhook receiver,
+// from its Alertmanager receiver and group labels, which make its group key, and the status and timestamps of its alerts
+func deliveryKey(receiver string, data template.Data) string {
+	alerts := make([]string, 0, len(data.Alerts))
+	for _, alert := range data.Alerts {
+		alerts = append(alerts, fmt.Sprintf("%s %s %s %s", alertFingerprint(alert), alert.Status, alert.StartsAt.UTC().Format(time.RFC3339Nano), alert.EndsAt.UTC().Format(time.RFC3339Nano)))
+	}
+	sort.Strings(alerts)
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s\n%s\n%v\n%s\n%s", receiver, data.Receiver, data.GroupLabels.SortedPairs(), data.Status, strings.Join(alerts, "\n"))))
+	return fmt.Sprintf("delivery:%x", hash)
+}
+
+// payloadDeliveryKey is the deduplication store key identifying the delivery of the payload to the webhook receiver,
+// from the hash of the full payload, so that only the byte for byte duplicates are skipped
+func payloadDeliveryKey(receiver string, payloadHash [sha256.Size]byte) string {
+	return fmt.Sprintf("delivery:payload:%x", sha256.Sum256([]byte(fmt.Sprintf("%s\n%x", receiver, payloadHash))))
+}
+
+// messageDeliveryKey is the deduplication store key of the delivery of the message, according to the key of the config
+func (c IdempotencyConfig) messageDeliveryKey(receiver string, message webhookMessage) string {
+	if c.Key == idempotencyKeyPayload {
+		return payloadDeliveryKey(receiver, message.payloadHash)
+	}
+	return deliveryKey(receiver, message.Data)
+}
+
+// claimDelivery claims the processing of the delivery, returning its key, or the state of the delivery already
+// claimed within the window, either processed or dedupPending. The key is empty when the delivery is not tracked,
+// with a store error failing open.
+func claimDelivery(ctx context.Context, receiver string, message webhookMessage) (string, string) {
+	config := configFrom(ctx)
+	c := config.Webhook.Idempotency
+	if !c.Enabled || isDryRun(ctx) {
+		return "", ""
+	}
+
+	key := c.messageDeliveryKey(receiver, message)
+	claimed, err := dedupStore.SetIfAbsent(key, dedupPending, c.window())
+	if err != nil {
+		loggerFrom(ctx).Errorf("Error claiming the delivery %s, processing it anyway: %v", key, err)
+		return "", ""
+	}
+	if claimed {
+		return key, ""
+	}
+	state, err := dedupStore.Get(key)
+	if err != nil {
+		loggerFrom(ctx).Errorf("Error reading the delivery %s, processing it anyway: %v", key, err)
+		return "", ""
+	}
+	if len(state) == 0 {
+		// Expired in the meantime
+		return claimDelivery(ctx, receiver, message)
+	}
+	return "", state
+}
+
+// finishDelivery marks the claimed delivery as processed for the rest of the window, or releases it when it failed so
+// that its retry is processed
+func finishDelivery(ctx context.Context, key string, processed bool) {
+	config := configFrom(ctx)
+	if len(key) == 0 {
+		return
+	}
+	var err error
+	if processed {
+		err = dedupStore.Set(key, deliveryProcessed, config.Webhook.Idempotency.window())
+	} else {
+		err = dedupStore.Delete(key)
+	}
+	if err != nil {
+		loggerFrom(ctx).Errorf("Error storing the state of the delivery %s: %v", key, err)
+	}
+}
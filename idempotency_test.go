@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/mock"
+)
+
+func postNotification(body string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(webhook).ServeHTTP(rr, httptest.NewRequest("POST", "/webhook", strings.NewReader(body)))
+	return rr
+}
+
+func TestWebhook_DuplicateDelivery(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Webhook.Idempotency = IdempotencyConfig{Enabled: true}
+	dedupStore = newMemoryDedupStore()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{"sys_id": "1", "number": "INC1"}, nil)
+	snClientMock.On("UpdateIncident", mock.Anything, mock.Anything, "1").Return(Incident{}, nil)
+
+	for i := 0; i < 2; i++ {
+		if rr := postNotification(twoAlertsNotification); rr.Code != http.StatusOK {
+			t.Errorf("Wrong status code of delivery %d: got %v, want %v", i, rr.Code, http.StatusOK)
+		}
+	}
+	snClientMock.AssertNumberOfCalls(t, "GetIncidents", 1)
+	snClientMock.AssertNumberOfCalls(t, "CreateIncident", 1)
+
+	// A notification of the same group with another status is a new delivery
+	postNotification(strings.Replace(twoAlertsNotification, `"status": "firing", "labels": {"alertname": "InstanceDown", "instance": "web02"}`, `"status": "resolved", "labels": {"alertname": "InstanceDown", "instance": "web02"}`, 1))
+	snClientMock.AssertNumberOfCalls(t, "GetIncidents", 2)
+}
+
+func TestWebhook_DuplicateDelivery_Failed(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Webhook.Idempotency = IdempotencyConfig{Enabled: true}
+	dedupStore = newMemoryDedupStore()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{}, errors.New("Error")).Once()
+	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{"sys_id": "1", "number": "INC1"}, nil)
+
+	if rr := postNotification(twoAlertsNotification); rr.Code != http.StatusInternalServerError {
+		t.Fatalf("Wrong status code: got %v, want %v", rr.Code, http.StatusInternalServerError)
+	}
+	if rr := postNotification(twoAlertsNotification); rr.Code != http.StatusOK {
+		t.Errorf("The retry of the failed delivery should be processed: got %v", rr.Code)
+	}
+	snClientMock.AssertNumberOfCalls(t, "CreateIncident", 2)
+}
+
+func TestWebhook_DuplicateDelivery_InProgress(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Webhook.Idempotency = IdempotencyConfig{Enabled: true}
+	dedupStore = newMemoryDedupStore()
+	useServiceNow(new(MockedSnClient))
+	data, _ := readRequestBody(httptest.NewRequest("POST", "/webhook", strings.NewReader(twoAlertsNotification)))
+	dedupStore.Set(deliveryKey("", data), dedupPending, time.Minute)
+
+	rr := postNotification(twoAlertsNotification)
+	if rr.Code != http.StatusServiceUnavailable || len(rr.Header().Get("Retry-After")) == 0 {
+		t.Errorf("The delivery in progress should be retried later: got %v", rr.Code)
+	}
+}
+
+func TestDeliveryKey(t *testing.T) {
+	start := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	a := template.Alert{Status: "firing", Fingerprint: "a", StartsAt: start}
+	b := template.Alert{Status: "firing", Fingerprint: "b", StartsAt: start}
+	data := template.Data{Receiver: "servicenow", Status: "firing", GroupLabels: template.KV{"alertname": "DiskFull"}, Alerts: template.Alerts{a, b}}
+	key := deliveryKey("", data)
+
+	if deliveryKey("", template.Data{Receiver: "servicenow", Status: "firing", GroupLabels: template.KV{"alertname": "DiskFull"}, Alerts: template.Alerts{b, a}}) != key {
+		t.Errorf("The key should not depend on the order of the alerts")
+	}
+	if deliveryKey("payments", data) == key {
+		t.Errorf("The key should depend on the webhook receiver")
+	}
+	resolved := data
+	resolved.Alerts = template.Alerts{a, {Status: "resolved", Fingerprint: "b", StartsAt: start, EndsAt: start.Add(time.Hour)}}
+	if deliveryKey("", resolved) == key {
+		t.Errorf("The key should depend on the status of the alerts")
+	}
+}
+
+func TestWebhook_DuplicateDelivery_PayloadKey(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Webhook.Idempotency = IdempotencyConfig{Enabled: true, Key: idempotencyKeyPayload}
+	dedupStore = newMemoryDedupStore()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{"sys_id": "1", "number": "INC1"}, nil)
+	snClientMock.On("UpdateIncident", mock.Anything, mock.Anything, "1").Return(Incident{}, nil)
+
+	for i := 0; i < 2; i++ {
+		if rr := postNotification(twoAlertsNotification); rr.Code != http.StatusOK {
+			t.Errorf("Wrong status code of delivery %d: got %v, want %v", i, rr.Code, http.StatusOK)
+		}
+	}
+	snClientMock.AssertNumberOfCalls(t, "GetIncidents", 1)
+
+	// The same alerts in another payload, e.g. reformatted, are a new delivery
+	postNotification(strings.Replace(twoAlertsNotification, `"status": "firing"`, `"status":"firing"`, 1))
+	snClientMock.AssertNumberOfCalls(t, "GetIncidents", 2)
+}
+
+func TestPayloadDeliveryKey(t *testing.T) {
+	hash := sha256.Sum256([]byte(twoAlertsNotification))
+	if payloadDeliveryKey("", hash) == payloadDeliveryKey("payments", hash) {
+		t.Errorf("The key should depend on the webhook receiver")
+	}
+	if payloadDeliveryKey("", hash) == payloadDeliveryKey("", sha256.Sum256([]byte(twoAlertsNotification+"\n"))) {
+		t.Errorf("The key should depend on the payload")
+	}
+}
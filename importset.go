@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	importSetAPI          = "%s/import/%s"
+	importStatusInserted  = "inserted"
+	importStatusUpdated   = "updated"
+	importStatusIgnored   = "ignored"
+	importStatusError     = "error"
+	importDisplayedNumber = "number"
+)
+
+// ImportSetConfig - Writes of the incidents through a staging table of the Import Set API, transformed into the
+// target table by its transform maps, instead of the Table API
+type ImportSetConfig struct {
+	StagingTable string            `yaml:"staging_table"`
+	FieldNames   map[string]string `yaml:"field_names"`
+	SysIDField   string            `yaml:"sys_id_field"`
+}
+
+func (c ImportSetConfig) enabled() bool {
+	return len(c.StagingTable) > 0
+}
+
+func (c ImportSetConfig) validate(errs *strings.Builder) {
+	if !c.enabled() {
+		if len(c.FieldNames) > 0 || len(c.SysIDField) > 0 {
+			errs.WriteString("import_set.staging_table is missing\n")
+		}
+		return
+	}
+	if len(c.SysIDField) == 0 {
+		errs.WriteString("import_set.sys_id_field is missing, it is required to update the incidents\n")
+	}
+}
+
+// stagingRow maps the incident fields to the fields of the staging table, the unmapped ones being kept as is
+func (c ImportSetConfig) stagingRow(incident Incident, sysID string) Incident {
+	row := make(Incident, len(incident)+1)
+	for field, value := range incident {
+		if name, ok := c.FieldNames[field]; ok {
+			field = name
+		}
+		row[field] = value
+	}
+	if len(sysID) > 0 {
+		row[c.SysIDField] = sysID
+	}
+	return row
+}
+
+// importSetResponse is the response of the Import Set API, with the result of each transform map
+type importSetResponse struct {
+	ImportSet    string            `json:"import_set"`
+	StagingTable string            `json:"staging_table"`
+	Result       []importSetResult `json:"result"`
+}
+
+type importSetResult struct {
+	TransformMap  string `json:"transform_map"`
+	Table         string `json:"table"`
+	DisplayName   string `json:"display_name"`
+	DisplayValue  string `json:"display_value"`
+	Status        string `json:"status"`
+	SysID         string `json:"sys_id"`
+	StatusMessage string `json:"status_message"`
+	ErrorMessage  string `json:"error_message"`
+}
+
+// targetResult returns the result of the transform into the table, or the first one
+func (r importSetResponse) targetResult(tableName string) (importSetResult, bool) {
+	for _, result := range r.Result {
+		if result.Table == tableName {
+			return result, true
+		}
+	}
+	if len(r.Result) > 0 {
+		return r.Result[0], true
+	}
+	return importSetResult{}, false
+}
+
+// importIncident inserts the incident into the staging table, updating the incident of the sys_id when set, and
+// returns the incident the transform inserted or updated
+func (snClient *ServiceNowClient) importIncident(ctx context.Context, tableName string, incidentParam Incident, sysID string) (Incident, error) {
+	c := snClient.importSet
+	postBody, err := json.Marshal(c.stagingRow(incidentParam, sysID))
+	if err != nil {
+		loggerFrom(ctx).Errorf("Error while marshalling the import set row. %s", err)
+		return nil, err
+	}
+
+	url := fmt.Sprintf(importSetAPI, snClient.api(), c.StagingTable)
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(postBody))
+	if err != nil {
+		loggerFrom(ctx).Errorf("Error creating the request. %s", err)
+		return nil, err
+	}
+	response, err := snClient.doRequest(ctx, req, apiOperation{table: tableName, name: apiImport})
+	if err != nil {
+		loggerFrom(ctx).Errorf("Error while importing the incident. %s", err)
+		return nil, err
+	}
+
+	importResponse := importSetResponse{}
+	if err := json.Unmarshal(response, &importResponse); err != nil {
+		loggerFrom(ctx).Errorf("Error while unmarshalling the import set result. %s", err)
+		return nil, err
+	}
+	result, ok := importResponse.targetResult(tableName)
+	if !ok {
+		return nil, fmt.Errorf("import set %s returned no transform result", importResponse.ImportSet)
+	}
+
+	switch {
+	case result.Status == importStatusInserted || result.Status == importStatusUpdated:
+	case result.Status == importStatusIgnored && len(sysID) > 0:
+		// Nothing changed on the updated incident
+	case result.Status == importStatusError:
+		return nil, fmt.Errorf("import set %s transform failed: %s", importResponse.ImportSet, result.ErrorMessage)
+	default:
+		return nil, fmt.Errorf("import set %s row was %s by the transform: %s", importResponse.ImportSet, result.Status, result.StatusMessage)
+	}
+
+	incident := Incident{"sys_id": result.SysID, "number": ""}
+	if result.DisplayName == importDisplayedNumber {
+		incident["number"] = result.DisplayValue
+	}
+	if len(incident.GetSysID()) == 0 {
+		incident["sys_id"] = sysID
+	}
+	loggerFrom(ctx).Infof("Import set %s row %s by the transform map %s into %s", importResponse.ImportSet, result.Status, result.TransformMap, result.Table)
+	return incident, nil
+}
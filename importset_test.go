@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestImportSetClient returns a client of a fake Import Set API answering with the transform result
+func newTestImportSetClient(t *testing.T, status string, rows *[]Incident) (*ServiceNowClient, func()) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/api/now/import/u_alertmanager_import" {
+			t.Errorf("Unexpected request; got: %v %v", r.Method, r.URL.Path)
+		}
+		var row Incident
+		json.NewDecoder(r.Body).Decode(&row)
+		*rows = append(*rows, row)
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"import_set":"ISET0010001","staging_table":"u_alertmanager_import","result":[
+			{"transform_map":"Alertmanager CIs","table":"cmdb_ci","status":"ignored"},
+			{"transform_map":"Alertmanager","table":"incident","display_name":"number","display_value":"INC0010001","status":%q,"sys_id":"1","error_message":"Invalid state"}
+		]}`, status)
+	}))
+	snClient, err := NewServiceNowClient("instancename", "username", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	snClient.baseURL = ts.URL
+	snClient.importSet = ImportSetConfig{
+		StagingTable: "u_alertmanager_import",
+		FieldNames:   map[string]string{"short_description": "u_short_description"},
+		SysIDField:   "u_incident_sys_id",
+	}
+	return snClient, ts.Close
+}
+
+func TestImportSet_CreateIncident(t *testing.T) {
+	var rows []Incident
+	snClient, cleanup := newTestImportSetClient(t, importStatusInserted, &rows)
+	defer cleanup()
+
+	incident, err := snClient.CreateIncident(context.Background(), "incident", Incident{"short_description": "Disk full", "impact": "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if incident.GetSysID() != "1" || incident.GetNumber() != "INC0010001" {
+		t.Errorf("Unexpected imported incident: %v", incident)
+	}
+	if len(rows) != 1 || rows[0]["u_short_description"] != "Disk full" || rows[0]["impact"] != "2" || rows[0]["u_incident_sys_id"] != nil {
+		t.Errorf("Unexpected staging row: %v", rows)
+	}
+}
+
+func TestImportSet_UpdateIncident(t *testing.T) {
+	var rows []Incident
+	snClient, cleanup := newTestImportSetClient(t, importStatusIgnored, &rows)
+	defer cleanup()
+
+	incident, err := snClient.UpdateIncident(context.Background(), "incident", Incident{"comments": "Still firing"}, "1")
+	if err != nil {
+		t.Fatalf("An update without any change should succeed: %v", err)
+	}
+	if incident.GetSysID() != "1" || len(rows) != 1 || rows[0]["u_incident_sys_id"] != "1" || rows[0]["comments"] != "Still firing" {
+		t.Errorf("Unexpected update: %v, %v", incident, rows)
+	}
+}
+
+func TestImportSet_TransformErrors(t *testing.T) {
+	var rows []Incident
+	for status, want := range map[string]string{importStatusError: "transform failed: Invalid state", importStatusIgnored: "row was ignored", "skipped": "row was skipped"} {
+		snClient, cleanup := newTestImportSetClient(t, status, &rows)
+		_, err := snClient.CreateIncident(context.Background(), "incident", Incident{"short_description": "Disk full"})
+		cleanup()
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Unexpected error for the %s status: %v", status, err)
+		}
+	}
+}
+
+func TestImportSetConfig_Validate(t *testing.T) {
+	var errs strings.Builder
+	ImportSetConfig{SysIDField: "u_sys_id"}.validate(&errs)
+	ImportSetConfig{StagingTable: "u_alertmanager_import"}.validate(&errs)
+	for _, want := range []string{"import_set.staging_table is missing", "import_set.sys_id_field is missing"} {
+		if !strings.Contains(errs.String(), want) {
+			t.Errorf("Missing validation error %q: %q", want, errs.String())
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	inboundLimitGlobal = "global"
+	inboundLimitSource = "source"
+)
+
+var (
+	webhookRateLimitedRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_rate_limited_requests_total",
+			Help: "Total number of requests on /webhook rejected with a 429 by the inbound rate limit, by limit (global or source).",
+		},
+		[]string{"limit"},
+	)
+)
+
+// InboundRateLimitConfig - Rate limit of the requests on /webhook, overall and by source IP, protecting the webhook
+// and ServiceNow during alert storms
+type InboundRateLimitConfig struct {
+	RequestsPerSecond float64         `yaml:"requests_per_second"`
+	Burst             int             `yaml:"burst"`
+	PerSource         RateLimitConfig `yaml:"per_source"`
+}
+
+func (c InboundRateLimitConfig) validate(errs *strings.Builder) {
+	if c.RequestsPerSecond < 0 {
+		errs.WriteString("webhook.rate_limit.requests_per_second must not be negative\n")
+	}
+	if c.Burst < 0 {
+		errs.WriteString("webhook.rate_limit.burst must not be negative\n")
+	}
+	if c.PerSource.RequestsPerSecond < 0 {
+		errs.WriteString("webhook.rate_limit.per_source.requests_per_second must not be negative\n")
+	}
+	if c.PerSource.Burst < 0 {
+		errs.WriteString("webhook.rate_limit.per_source.burst must not be negative\n")
+	}
+}
+
+func (c InboundRateLimitConfig) global() RateLimitConfig {
+	return RateLimitConfig{RequestsPerSecond: c.RequestsPerSecond, Burst: c.Burst}
+}
+
+// take takes a token if one is available, or else returns how long to wait for the next one
+func (b *tokenBucket) take() (bool, time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := b.now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// full returns whether the bucket was refilled, i.e. its source has been idle long enough to forget it
+func (b *tokenBucket) full() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.tokens+b.now().Sub(b.last).Seconds()*b.rate >= b.burst
+}
+
+// inboundLimiter holds the token buckets of the inbound rate limit, the global one and the ones of the sources
+type inboundLimiter struct {
+	config  InboundRateLimitConfig
+	global  *tokenBucket
+	mutex   sync.Mutex
+	sources map[string]*tokenBucket
+}
+
+// newInboundRateLimiter builds the inbound rate limiter, the previous one being kept as is when its configuration did
+// not change on reload
+func newInboundRateLimiter(c InboundRateLimitConfig, previous *inboundLimiter) *inboundLimiter {
+	if !c.global().enabled() && !c.PerSource.enabled() {
+		return nil
+	}
+	if previous != nil && previous.config == c {
+		return previous
+	}
+	limiter := &inboundLimiter{config: c, sources: map[string]*tokenBucket{}}
+	if c.global().enabled() {
+		limiter.global = newTokenBucket(c.global())
+	}
+	return limiter
+}
+
+// allow returns whether the request is within the rate limits, the source one being checked first so that a noisy
+// source does not consume the tokens of the others, or else the limit exceeded and how long to wait for a token
+func (l *inboundLimiter) allow(source string) (bool, string, time.Duration) {
+	if l.config.PerSource.enabled() {
+		l.mutex.Lock()
+		bucket, ok := l.sources[source]
+		if !ok {
+			bucket = newTokenBucket(l.config.PerSource)
+			l.sources[source] = bucket
+		}
+		l.mutex.Unlock()
+		if ok, wait := bucket.take(); !ok {
+			return false, inboundLimitSource, wait
+		}
+	}
+	if l.global != nil {
+		if ok, wait := l.global.take(); !ok {
+			return false, inboundLimitGlobal, wait
+		}
+	}
+	return true, "", 0
+}
+
+// sweep forgets the idle sources
+func (l *inboundLimiter) sweep() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for source, bucket := range l.sources {
+		if bucket.full() {
+			delete(l.sources, source)
+		}
+	}
+}
+
+// rateLimitRequest answers the request with a 429 and a Retry-After header when it exceeds the inbound rate limit
+func rateLimitRequest(w http.ResponseWriter, r *http.Request) bool {
+	config := configFrom(r.Context())
+	limiter := configSnapshotFrom(r.Context()).inboundRateLimiter
+	if limiter == nil {
+		return false
+	}
+	source := ""
+	if ip := config.Webhook.SourceIPs.sourceIP(r); ip != nil {
+		source = ip.String()
+	}
+	ok, limit, wait := limiter.allow(source)
+	if ok {
+		return false
+	}
+	webhookRateLimitedRequests.WithLabelValues(limit).Inc()
+	baseLogger.Warnf("Rate limited request on /webhook from %s, exceeding the %s rate limit", r.RemoteAddr, limit)
+	w.Header().Set("Retry-After", config.Webhook.retryAfterHeader(wait))
+	sendResponse(w, r, http.StatusTooManyRequests, "Too many requests")
+	return true
+}
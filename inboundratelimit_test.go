@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTokenBucket_Take(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := newTokenBucket(RateLimitConfig{RequestsPerSecond: 2, Burst: 1})
+	b.now = func() time.Time { return now }
+	b.last = now
+
+	if ok, _ := b.take(); !ok {
+		t.Fatalf("The burst should be allowed")
+	}
+	if ok, wait := b.take(); ok || wait != 500*time.Millisecond {
+		t.Errorf("The empty bucket should tell when its next token is available: %v, %v", ok, wait)
+	}
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := b.take(); !ok {
+		t.Errorf("The refilled bucket should allow the request")
+	}
+}
+
+func TestWebhook_RateLimit(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Webhook.RateLimit = InboundRateLimitConfig{PerSource: RateLimitConfig{RequestsPerSecond: 0.001, Burst: 1}}
+	applyConfig()
+	before := testutil.ToFloat64(webhookRateLimitedRequests.WithLabelValues(inboundLimitSource))
+
+	post := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/webhook", strings.NewReader("{}"))
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(webhook).ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := post("10.0.0.1:1234"); rr.Code == http.StatusTooManyRequests {
+		t.Fatalf("The first request should not be rate limited")
+	}
+	rr := post("10.0.0.1:1235")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "300" {
+		t.Errorf("The second request of the source should be rate limited, with the bounded Retry-After: %v %v", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	if rr := post("10.0.0.2:1234"); rr.Code == http.StatusTooManyRequests {
+		t.Errorf("The requests of another source should not be rate limited")
+	}
+	if got := testutil.ToFloat64(webhookRateLimitedRequests.WithLabelValues(inboundLimitSource)) - before; got != 1 {
+		t.Errorf("The rate limited request should be counted: got %v", got)
+	}
+}
+
+func TestLoadInboundRateLimit(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	if applyConfig(); currentConfigSnapshot().inboundRateLimiter != nil {
+		t.Fatalf("No rate limiter should be built without rate limit")
+	}
+	currentConfig().Webhook.RateLimit = InboundRateLimitConfig{RequestsPerSecond: 10}
+	applyConfig()
+	limiter := currentConfigSnapshot().inboundRateLimiter
+	if applyConfig(); currentConfigSnapshot().inboundRateLimiter != limiter || limiter.global == nil {
+		t.Errorf("The rate limiter should be kept when its configuration did not change")
+	}
+}
+
+func TestInboundRateLimitConfig_Validate(t *testing.T) {
+	var errs strings.Builder
+	InboundRateLimitConfig{RequestsPerSecond: -1, PerSource: RateLimitConfig{Burst: -1}}.validate(&errs)
+	for _, want := range []string{"webhook.rate_limit.requests_per_second must not be negative", "webhook.rate_limit.per_source.burst must not be negative"} {
+		if !strings.Contains(errs.String(), want) {
+			t.Errorf("Missing validation error %q: %q", want, errs.String())
+		}
+	}
+}
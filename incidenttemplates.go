@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+// IncidentTemplateRuleConfig - Selection of a named incident template for the alert groups matching all the conditions
+type IncidentTemplateRuleConfig struct {
+	// Match holds the labels common to the alert group, such as alertname or severity
+	Match    map[string]string `yaml:"match"`
+	Receiver string            `yaml:"receiver"`
+	Template string            `yaml:"template"`
+}
+
+func validateIncidentTemplates(c Config, errs *strings.Builder) {
+	for i, rule := range c.IncidentTemplateRules {
+		if len(rule.Match) == 0 && len(rule.Receiver) == 0 {
+			errs.WriteString(fmt.Sprintf("incident_template_rules[%d] needs a match or a receiver\n", i))
+		}
+		if len(rule.Template) == 0 {
+			errs.WriteString(fmt.Sprintf("incident_template_rules[%d].template is missing\n", i))
+		} else if _, ok := c.IncidentTemplates[rule.Template]; !ok {
+			errs.WriteString(fmt.Sprintf("incident_template_rules[%d].template %q is not defined in incident_templates\n", i, rule.Template))
+		}
+	}
+}
+
+func (r IncidentTemplateRuleConfig) matches(data template.Data) bool {
+	if len(r.Receiver) > 0 && r.Receiver != data.Receiver {
+		return false
+	}
+	for name, value := range r.Match {
+		if data.CommonLabels[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// selectIncidentTemplate returns the template of the first rule matching the alert group, or an empty name when none matches
+func selectIncidentTemplate(rules []IncidentTemplateRuleConfig, data template.Data) string {
+	for _, rule := range rules {
+		if rule.matches(data) {
+			return rule.Template
+		}
+	}
+	return ""
+}
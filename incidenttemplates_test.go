@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+func TestIncidentMapping_IncidentTemplates(t *testing.T) {
+	c := Config{
+		DefaultIncident: map[string]string{"short_description": "default"},
+		TableProfiles:   map[string]map[string]string{"change_request": {"short_description": "change"}},
+		IncidentTemplates: map[string]map[string]string{
+			"database": {"short_description": "DB {{ .CommonLabels.alertname }}", "category": "database"},
+			"network":  {"short_description": "Network", "category": "network"},
+		},
+		IncidentTemplateRules: []IncidentTemplateRuleConfig{
+			{Match: map[string]string{"alertname": "MySQLDown", "severity": "critical"}, Template: "database"},
+			{Receiver: "network-team", Template: "network"},
+		},
+	}
+	m := newIncidentMapping(c)
+
+	tests := []struct {
+		name     string
+		table    string
+		data     template.Data
+		want     string
+		category string
+	}{
+		{"labels", "incident", template.Data{CommonLabels: template.KV{"alertname": "MySQLDown", "severity": "critical"}}, "DB MySQLDown", "database"},
+		{"partial labels", "incident", template.Data{CommonLabels: template.KV{"alertname": "MySQLDown", "severity": "warning"}}, "default", ""},
+		{"receiver", "change_request", template.Data{Receiver: "network-team"}, "Network", "network"},
+		{"table profile", "change_request", template.Data{Receiver: "other"}, "change", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			incident := Incident{}
+			m.apply(context.Background(), test.table, incident, test.data)
+			category, _ := incident["category"].(string)
+			if incident["short_description"] != test.want || category != test.category {
+				t.Errorf("Unexpected incident: %v", incident)
+			}
+		})
+	}
+}
+
+func TestValidateIncidentTemplates(t *testing.T) {
+	var errs strings.Builder
+	validateIncidentTemplates(Config{
+		IncidentTemplates:     map[string]map[string]string{"database": {"short_description": "DB"}},
+		IncidentTemplateRules: []IncidentTemplateRuleConfig{{Template: "database"}, {Receiver: "network-team", Template: "network"}},
+	}, &errs)
+	for _, want := range []string{"incident_template_rules[0] needs a match", `incident_template_rules[1].template "network" is not defined`} {
+		if !strings.Contains(errs.String(), want) {
+			t.Errorf("Validation errors %q should contain %q", errs.String(), want)
+		}
+	}
+}
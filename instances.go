@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type instanceContextKey struct{}
+
+func validateInstances(c Config, errs *strings.Builder) {
+	for name, instance := range c.Instances {
+		if len(instance.InstanceName) == 0 && len(instance.APIURL) == 0 {
+			errs.WriteString(fmt.Sprintf("instances.%s.instance_name is missing\n", name))
+		}
+		if len(instance.UserName) == 0 && !instance.Vault.enabled() {
+			errs.WriteString(fmt.Sprintf("instances.%s.user_name is missing\n", name))
+		}
+		if len(instance.Password) == 0 && !instance.OAuth2.enabled() && !instance.Vault.enabled() {
+			errs.WriteString(fmt.Sprintf("instances.%s.password is missing\n", name))
+		}
+		var instanceErrs strings.Builder
+		instance.OAuth2.validate(&instanceErrs)
+		instance.Retry.validate(&instanceErrs)
+		validateProxyURL(instance.ProxyURL, &instanceErrs)
+		instance.TLSConfig.validate(&instanceErrs)
+		instance.Transport.validate(&instanceErrs)
+		instance.RateLimit.validate(&instanceErrs)
+		validateMaxConcurrentRequests(instance, &instanceErrs)
+		validateHeaders(instance, &instanceErrs)
+		validateAPIURL(instance, &instanceErrs)
+		instance.CircuitBreaker.validate(&instanceErrs)
+		instance.Vault.validate(&instanceErrs)
+		instance.ImportSet.validate(&instanceErrs)
+		for _, err := range strings.SplitAfter(instanceErrs.String(), "\n") {
+			if len(err) > 0 {
+				errs.WriteString(fmt.Sprintf("instances.%s: %s", name, err))
+			}
+		}
+	}
+}
+
+// instanceNames returns the names of the additional ServiceNow instances, sorted
+func (c Config) instanceNames() []string {
+	names := make([]string, 0, len(c.Instances))
+	for name := range c.Instances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newSnClients builds the clients of the additional ServiceNow instances
+func newSnClients(c Config) (map[string]ServiceNow, error) {
+	clients := make(map[string]ServiceNow, len(c.Instances))
+	for name, instance := range c.Instances {
+		client, err := newSnClient(instance)
+		if err != nil {
+			return nil, fmt.Errorf("instance %s: %v", name, err)
+		}
+		clients[name] = client
+	}
+	return clients, nil
+}
+
+// withInstance returns a context managing the incidents in the named ServiceNow instance, the default one when empty
+func withInstance(ctx context.Context, name string) context.Context {
+	if len(name) == 0 {
+		return ctx
+	}
+	return withLogger(context.WithValue(ctx, instanceContextKey{}, name), loggerFrom(ctx).With("instance", name))
+}
+
+// instanceFrom returns the name of the ServiceNow instance of the context, empty for the default one
+func instanceFrom(ctx context.Context) string {
+	name, _ := ctx.Value(instanceContextKey{}).(string)
+	return name
+}
+
+// serviceNowFrom returns the client of the ServiceNow instance of the context
+func serviceNowFrom(ctx context.Context) ServiceNow {
+	s := configSnapshotFrom(ctx)
+	client, ok := s.serviceNowInstances[instanceFrom(ctx)]
+	if !ok {
+		client = s.serviceNow
+	}
+	if isDryRun(ctx) {
+		client = dryRunClient{client}
+	} else if auditLog != nil {
+		client = auditClient{client, auditLog}
+	}
+	if recorder := archiveRecorderFrom(ctx); recorder != nil {
+		return archiveClient{client, recorder}
+	}
+	return client
+}
+
+// instanceConfigFrom returns the configuration of the ServiceNow instance of the context
+func instanceConfigFrom(ctx context.Context) ServiceNowConfig {
+	config := configFrom(ctx)
+	if c, ok := config.Instances[instanceFrom(ctx)]; ok {
+		return c
+	}
+	return config.ServiceNow
+}
+
+// instanceKey prefixes the cache or deduplication key with the ServiceNow instance of the context, as the same
+// key refers to different records in different instances
+func instanceKey(ctx context.Context, key string) string {
+	if name := instanceFrom(ctx); len(name) > 0 {
+		return name + "/" + key
+	}
+	return key
+}
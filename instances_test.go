@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/mock"
+)
+
+func loadInstancesTestConfig() (*MockedSnClient, *MockedSnClient) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Instances = map[string]ServiceNowConfig{
+		"retail": {InstanceName: "retail", UserName: "retail-user", Password: "retail-password", TableName: "incident"},
+	}
+	currentConfig().Routes = []RouteConfig{
+		{Match: map[string]string{"business_unit": "retail"}, Instance: "retail"},
+	}
+	dedupStore = newMemoryDedupStore()
+
+	defaultMock := new(MockedSnClient)
+	retailMock := new(MockedSnClient)
+	for _, m := range []*MockedSnClient{defaultMock, retailMock} {
+		m.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+		m.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{"sys_id": "1", "number": "INC1"}, nil)
+	}
+	useServiceNow(defaultMock)
+	useServiceNowInstances(map[string]ServiceNow{"retail": retailMock})
+	return defaultMock, retailMock
+}
+
+func TestOnAlertGroup_Instances(t *testing.T) {
+	defaultMock, retailMock := loadInstancesTestConfig()
+	defer useServiceNowInstances(nil)
+
+	data := template.Data{
+		Status:      "firing",
+		GroupLabels: template.KV{"alertname": "DiskFull"},
+		Alerts: template.Alerts{
+			template.Alert{Status: "firing", Labels: template.KV{"alertname": "DiskFull", "business_unit": "retail"}},
+			template.Alert{Status: "firing", Labels: template.KV{"alertname": "DiskFull", "business_unit": "bank"}},
+		},
+	}
+	if err := onAlertGroup(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+
+	defaultMock.AssertNumberOfCalls(t, "CreateIncident", 1)
+	retailMock.AssertNumberOfCalls(t, "CreateIncident", 1)
+	if callerID := retailMock.Calls[1].Arguments.Get(1).(Incident)["caller_id"]; callerID != "retail-user" {
+		t.Errorf("Incident should be created by the user of its instance, got %v", callerID)
+	}
+	if sysID, _ := dedupStore.Get("retail/" + getGroupKey(context.Background(), data)); sysID != "1" {
+		t.Errorf("Deduplication key should be scoped to the instance")
+	}
+}
+
+func TestValidateInstances(t *testing.T) {
+	c := Config{
+		Instances: map[string]ServiceNowConfig{"retail": {InstanceName: "retail"}},
+		Routes:    []RouteConfig{{Match: map[string]string{"a": "b"}, Instance: "bank"}},
+	}
+	var errs strings.Builder
+	validateInstances(c, &errs)
+	validateRoutes(c, &errs)
+	for _, want := range []string{"instances.retail.user_name", "instances.retail.password", `routes[0].instance "bank"`, "routes[0].table_name"} {
+		if !strings.Contains(errs.String(), want) {
+			t.Errorf("Missing validation error %q in %q", want, errs.String())
+		}
+	}
+}
+
+func TestInstanceKey(t *testing.T) {
+	ctx := context.Background()
+	if key := instanceKey(ctx, "key"); key != "key" {
+		t.Errorf("Unexpected key for the default instance: %v", key)
+	}
+	if key := instanceKey(withInstance(ctx, "retail"), "key"); key != "retail/key" {
+		t.Errorf("Unexpected key for another instance: %v", key)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import "sync"
+
+// incidentLocks serializes the management of the incident of a same alert group key, so that concurrent
+// webhook requests query ServiceNow one after the other and only the first one creates the incident
+var incidentLocks = newKeyLocker()
+
+// keyLocker provides a mutex per key, only kept while it is held or waited for
+type keyLocker struct {
+	mutex sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+func newKeyLocker() *keyLocker {
+	return &keyLocker{locks: map[string]*keyLock{}}
+}
+
+// lock acquires the lock of the key, and returns the function releasing it
+func (l *keyLocker) lock(key string) func() {
+	l.mutex.Lock()
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &keyLock{}
+		l.locks[key] = lock
+	}
+	lock.refs++
+	l.mutex.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+
+		l.mutex.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(l.locks, key)
+		}
+		l.mutex.Unlock()
+	}
+}
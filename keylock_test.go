@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/mock"
+)
+
+// statefulSnClient returns the incidents created so far on GetIncidents
+type statefulSnClient struct {
+	MockedSnClient
+	mutex     sync.Mutex
+	incidents []Incident
+}
+
+func (c *statefulSnClient) GetIncidents(ctx context.Context, tableName string, params map[string]string) ([]Incident, error) {
+	c.MockedSnClient.GetIncidents(context.Background(), tableName, params)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]Incident{}, c.incidents...), nil
+}
+
+func (c *statefulSnClient) CreateIncident(ctx context.Context, tableName string, incidentParam Incident) (Incident, error) {
+	c.MockedSnClient.CreateIncident(context.Background(), tableName, incidentParam)
+	// Leave time to concurrent requests to query ServiceNow
+	time.Sleep(10 * time.Millisecond)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	incident := Incident{"sys_id": "1", "number": "INC1", "state": "1"}
+	c.incidents = append(c.incidents, incident)
+	return incident, nil
+}
+
+func TestOnAlertGroup_ConcurrentCreate(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	dedupStore = newMemoryDedupStore()
+	snClient := new(statefulSnClient)
+	useServiceNow(snClient)
+	snClient.On("GetIncidents", "incident", mock.Anything).Return([]Incident{}, nil)
+	snClient.On("CreateIncident", "incident", mock.Anything).Return(Incident{}, nil)
+	snClient.On("UpdateIncident", "incident", mock.Anything, "1").Return(Incident{}, nil)
+
+	data := template.Data{
+		Status:      "firing",
+		GroupLabels: template.KV{"alertname": "concurrent"},
+		Alerts:      template.Alerts{template.Alert{Status: "firing", Labels: template.KV{"alertname": "concurrent"}}},
+	}
+
+	const requests = 20
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := onAlertGroup(context.Background(), data); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	snClient.AssertNumberOfCalls(t, "CreateIncident", 1)
+	snClient.AssertNumberOfCalls(t, "UpdateIncident", requests-1)
+	if len(incidentLocks.locks) != 0 {
+		t.Errorf("Locks should be released, got %d", len(incidentLocks.locks))
+	}
+}
+
+func TestOnAlertGroup_LockReleasedOnError(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, errors.New("Error"))
+
+	data := template.Data{Status: "firing", GroupLabels: template.KV{"alertname": "error"}}
+	for i := 0; i < 2; i++ {
+		if err := onAlertGroup(context.Background(), data); err == nil {
+			t.Fatal("Expected an error, got none")
+		}
+	}
+	if len(incidentLocks.locks) != 0 {
+		t.Errorf("Locks should be released on error, got %d", len(incidentLocks.locks))
+	}
+}
+
+func TestKeyLocker_UnrelatedKeys(t *testing.T) {
+	locker := newKeyLocker()
+	unlock := locker.lock("a")
+	defer unlock()
+
+	acquired := make(chan struct{})
+	go func() {
+		locker.lock("b")()
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("Lock of an unrelated key should not wait")
+	}
+}
+
+func TestKeyLocker_SameKey(t *testing.T) {
+	locker := newKeyLocker()
+	unlock := locker.lock("a")
+
+	acquired := make(chan struct{})
+	go func() {
+		locker.lock("a")()
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("Lock of the same key should wait for its release")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("Lock should be acquired once released")
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+const (
+	knowledgeTable = "kb_knowledge"
+	// knowledgeArticleURL is the URL of a knowledge base article, relative to the ServiceNow instance
+	knowledgeArticleURL = "%s/kb_view.do?sysparm_article=%s"
+)
+
+var (
+	defaultKnowledgeAnnotations = []string{"kb_article", "runbook_kb"}
+	knowledgeCache              = newLookupCache("knowledge", defaultLookupCacheTTL)
+	knowledgeNumberRegexp       = regexp.MustCompile(`^KB[0-9]+$`)
+)
+
+// KnowledgeConfig - Knowledge base articles referenced by the annotations of the alerts, such as kb_article, recorded
+// on the incidents created for them, so that the resolvers get the remediation steps right away
+type KnowledgeConfig struct {
+	// Annotations holding the article numbers, e.g. KB0012345, several ones being separated by commas or spaces
+	Annotations []string `yaml:"annotations"`
+	// Field receiving the sys_id of the first article found in the knowledge base, e.g. a reference to kb_knowledge
+	Field string `yaml:"field"`
+	// WorkNote adds the numbers and URLs of the articles as a work note
+	WorkNote bool `yaml:"work_note"`
+	// Validate looks the articles up in the knowledge base, skipping the unknown ones
+	Validate bool `yaml:"validate"`
+}
+
+func (c KnowledgeConfig) validate(config Config, errs *strings.Builder) {
+	for i, annotation := range c.Annotations {
+		if len(annotation) == 0 {
+			errs.WriteString(fmt.Sprintf("knowledge.annotations[%d] is empty\n", i))
+		}
+	}
+	if len(c.Field) > 0 && c.Field == config.Workflow.IncidentGroupKeyField {
+		errs.WriteString(fmt.Sprintf("knowledge.field must not be workflow.incident_group_key_field %q, holding the deduplication key\n", c.Field))
+	}
+	if c.Field == workNotesField {
+		errs.WriteString("knowledge.field must not be work_notes, set knowledge.work_note instead\n")
+	}
+}
+
+func (c KnowledgeConfig) enabled() bool {
+	return len(c.Field) > 0 || c.WorkNote
+}
+
+func (c KnowledgeConfig) annotations() []string {
+	if len(c.Annotations) == 0 {
+		return defaultKnowledgeAnnotations
+	}
+	return c.Annotations
+}
+
+// knowledgeArticle is a knowledge base article referenced by the alert group, with its sys_id when looked up
+type knowledgeArticle struct {
+	number string
+	sysID  string
+}
+
+// knowledgeNumbers returns the distinct article numbers of the annotations of the alert group, the common ones first,
+// in upper case. The values not looking like an article number are logged and skipped.
+func knowledgeNumbers(ctx context.Context, c KnowledgeConfig, data template.Data) []string {
+	var numbers []string
+	seen := map[string]bool{}
+	collect := func(annotations template.KV) {
+		for _, name := range c.annotations() {
+			for _, number := range strings.FieldsFunc(annotations[name], func(r rune) bool { return r == ',' || r == ' ' }) {
+				number = strings.ToUpper(strings.TrimSpace(number))
+				if seen[number] {
+					continue
+				}
+				seen[number] = true
+				if !knowledgeNumberRegexp.MatchString(number) {
+					loggerFrom(ctx).Warnf("Annotation %s value %q is not a knowledge base article number", name, number)
+					continue
+				}
+				numbers = append(numbers, number)
+			}
+		}
+	}
+	collect(data.CommonAnnotations)
+	for _, alert := range data.Alerts {
+		collect(alert.Annotations)
+	}
+	return numbers
+}
+
+// knowledgeArticles returns the articles referenced by the alert group. They are looked up by number in the knowledge
+// base when their sys_id is needed or when they are validated, the unknown ones being skipped when validated.
+func knowledgeArticles(ctx context.Context, c KnowledgeConfig, data template.Data) []knowledgeArticle {
+	var articles []knowledgeArticle
+	for _, number := range knowledgeNumbers(ctx, c, data) {
+		article := knowledgeArticle{number: number}
+		if len(c.Field) > 0 || c.Validate {
+			sysID, err := lookupSysID(ctx, knowledgeCache, knowledgeTable, "number", number)
+			if err != nil {
+				serviceNowError.Inc()
+				loggerFrom(ctx).Errorf("Error looking up the knowledge base article %s: %v", number, err)
+			} else if len(sysID) == 0 {
+				loggerFrom(ctx).Warnf("Knowledge base article %s not found", number)
+			}
+			if len(sysID) == 0 && c.Validate {
+				continue
+			}
+			article.sysID = sysID
+		}
+		articles = append(articles, article)
+	}
+	return articles
+}
+
+// applyKnowledge records the knowledge base articles referenced by the alert group on the incident to create: the
+// sys_id of the first one found on the knowledge field, and their references appended to the work notes
+func applyKnowledge(ctx context.Context, incident Incident, data template.Data, now time.Time) {
+	config := configFrom(ctx)
+	c := config.Knowledge
+	if !c.enabled() {
+		return
+	}
+	articles := knowledgeArticles(ctx, c, data)
+	if len(articles) == 0 {
+		return
+	}
+	if len(c.Field) > 0 {
+		for _, article := range articles {
+			if len(article.sysID) > 0 {
+				incident[c.Field] = article.sysID
+				break
+			}
+		}
+	}
+	if c.WorkNote {
+		baseURL := instanceConfigFrom(ctx).baseURL()
+		lines := []string{fmt.Sprintf("%s - Knowledge base articles:", now.UTC().Format(workNoteTimeLayout))}
+		for _, article := range articles {
+			lines = append(lines, fmt.Sprintf("%s: %s", article.number, fmt.Sprintf(knowledgeArticleURL, baseURL, article.number)))
+		}
+		text := strings.Join(lines, "\n")
+		if existing, ok := incident[workNotesField].(string); ok && len(existing) > 0 {
+			text = existing + "\n\n" + text
+		}
+		incident[workNotesField] = text
+	}
+}
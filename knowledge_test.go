@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+func knowledgeAlertGroup() template.Data {
+	return template.Data{
+		CommonAnnotations: template.KV{"kb_article": "kb0001"},
+		Alerts: template.Alerts{
+			{Annotations: template.KV{"kb_article": "KB0001", "runbook_kb": "KB0002, KB0003"}},
+			{Annotations: template.KV{"runbook_kb": "see the wiki"}},
+		},
+	}
+}
+
+func TestKnowledgeNumbers(t *testing.T) {
+	numbers := knowledgeNumbers(context.Background(), KnowledgeConfig{}, knowledgeAlertGroup())
+	if strings.Join(numbers, ",") != "KB0001,KB0002,KB0003" {
+		t.Errorf("Unexpected knowledge base article numbers: %v", numbers)
+	}
+}
+
+func TestApplyKnowledge(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Knowledge = KnowledgeConfig{Field: "u_knowledge_article", WorkNote: true, Validate: true}
+	knowledgeCache.flush()
+	defer knowledgeCache.flush()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	lookup := func(number string) map[string]string {
+		return map[string]string{"number": number, "sysparm_fields": "sys_id", "sysparm_limit": "1"}
+	}
+	snClientMock.On("GetIncidents", knowledgeTable, lookup("KB0001")).Return([]Incident{}, nil)
+	snClientMock.On("GetIncidents", knowledgeTable, lookup("KB0002")).Return([]Incident{{"sys_id": "kb2"}}, nil)
+	snClientMock.On("GetIncidents", knowledgeTable, lookup("KB0003")).Return([]Incident(nil), errors.New("timeout"))
+
+	incident := Incident{workNotesField: "Existing note"}
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	applyKnowledge(context.Background(), incident, knowledgeAlertGroup(), now)
+
+	if incident["u_knowledge_article"] != "kb2" {
+		t.Errorf("The field should reference the first article found: %v", incident)
+	}
+	expected := "Existing note\n\n2024-01-02 15:04:05 UTC - Knowledge base articles:\nKB0002: " + currentConfig().ServiceNow.baseURL() + "/kb_view.do?sysparm_article=KB0002"
+	if incident[workNotesField] != expected {
+		t.Errorf("Unexpected work notes: %q", incident[workNotesField])
+	}
+}
+
+func TestApplyKnowledge_WorkNoteWithoutLookup(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Knowledge = KnowledgeConfig{Annotations: []string{"runbook_kb"}, WorkNote: true}
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+
+	incident := Incident{}
+	applyKnowledge(context.Background(), incident, knowledgeAlertGroup(), time.Now())
+
+	notes, _ := incident[workNotesField].(string)
+	if !strings.Contains(notes, "KB0002: ") || !strings.Contains(notes, "KB0003: ") || strings.Contains(notes, "KB0001") {
+		t.Errorf("Unexpected work notes: %q", notes)
+	}
+	snClientMock.AssertNumberOfCalls(t, "GetIncidents", 0)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+const (
+	defaultLinksField       = "description"
+	defaultGeneratorURLName = "Source"
+)
+
+// LinksConfig - Links rendered into an incident field, so that the responders can pivot from ServiceNow to the graph
+// of the alert, its dashboard or its runbook
+type LinksConfig struct {
+	// Field receiving the links. The links are appended to the value of the description, and replace the one of a
+	// dedicated field.
+	Field        string       `yaml:"field"`
+	GeneratorURL bool         `yaml:"generator_url"`
+	Templates    []LinkConfig `yaml:"templates"`
+}
+
+// LinkConfig - Link with a templated URL
+type LinkConfig struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+}
+
+func (c LinksConfig) validate(errs *strings.Builder) {
+	for i, link := range c.Templates {
+		if len(link.Name) == 0 {
+			errs.WriteString(fmt.Sprintf("links.templates[%d].name is missing\n", i))
+		}
+		if len(link.URL) == 0 {
+			errs.WriteString(fmt.Sprintf("links.templates[%d].url is missing\n", i))
+		} else if _, err := newTextTemplate(link.Name).Parse(link.URL); err != nil {
+			errs.WriteString(fmt.Sprintf("links.templates[%d].url is invalid: %v\n", i, err))
+		}
+	}
+}
+
+func (c LinksConfig) enabled() bool {
+	return c.GeneratorURL || len(c.Templates) > 0
+}
+
+func (c LinksConfig) field() string {
+	if len(c.Field) == 0 {
+		return defaultLinksField
+	}
+	return c.Field
+}
+
+// renderLinks returns the links of the alert group, one "<name>: <url>" per line. The links rendered empty, such as
+// the runbook of an alert without runbook_url annotation, are skipped.
+func renderLinks(ctx context.Context, c LinksConfig, data template.Data) string {
+	config := configFrom(ctx)
+	var lines []string
+	if c.GeneratorURL {
+		if url := (fieldMapping{source: fieldMappingGeneratorURL}).value(data); len(url) > 0 {
+			lines = append(lines, fmt.Sprintf("%s: %s", defaultGeneratorURLName, url))
+		}
+	}
+	templateData := newTemplateContext(config.InstanceList, data)
+	for _, link := range c.Templates {
+		url, err := applyTemplate(link.Name, link.URL, templateData)
+		if err != nil {
+			webhookIncidentTemplateError.Inc()
+			loggerFrom(ctx).Errorf("Error rendering the %s link: %v", link.Name, err)
+			continue
+		}
+		// A missing annotation is rendered as <no value>
+		if url = strings.TrimSpace(url); len(url) > 0 && url != "<no value>" {
+			lines = append(lines, fmt.Sprintf("%s: %s", link.Name, url))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// applyLinks renders the links of the alert group into the links field of the incident
+func applyLinks(ctx context.Context, c LinksConfig, incident Incident, data template.Data) {
+	if !c.enabled() {
+		return
+	}
+	links := renderLinks(ctx, c, data)
+	if len(links) == 0 {
+		return
+	}
+	field := c.field()
+	if field != defaultLinksField {
+		incident[field] = links
+		return
+	}
+	if description, _ := incident[field].(string); len(description) > 0 {
+		links = description + "\n\n" + links
+	}
+	incident[field] = links
+}
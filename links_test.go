@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+var linksData = template.Data{
+	CommonAnnotations: template.KV{"runbook_url": "https://runbooks.example.com/disk-full"},
+	Alerts: template.Alerts{
+		template.Alert{Labels: template.KV{"instance": "db-1:9100"}},
+		template.Alert{Labels: template.KV{"instance": "db-2:9100"}, GeneratorURL: "http://prometheus:9090/graph?g0.expr=up"},
+	},
+}
+
+func TestApplyLinks(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	c := LinksConfig{
+		GeneratorURL: true,
+		Templates: []LinkConfig{
+			{Name: "Dashboard", URL: "https://grafana/d/node?var-instance={{ .Labels.instance | urlquery }}"},
+			{Name: "Runbook", URL: "{{ .CommonAnnotations.runbook_url }}"},
+			{Name: "Logs", URL: "{{ .CommonAnnotations.logs_url }}"},
+		},
+	}
+	incident := Incident{"description": "Disk full"}
+	applyLinks(context.Background(), c, incident, linksData)
+	want := "Disk full\n\nSource: http://prometheus:9090/graph?g0.expr=up\nDashboard: https://grafana/d/node?var-instance=db-1%3A9100\nRunbook: https://runbooks.example.com/disk-full"
+	if incident["description"] != want {
+		t.Errorf("Unexpected description: got %q, want %q", incident["description"], want)
+	}
+
+	c.Field = "u_links"
+	incident = Incident{"description": "Disk full", "u_links": "previous"}
+	applyLinks(context.Background(), c, incident, linksData)
+	if incident["description"] != "Disk full" || !strings.HasPrefix(incident["u_links"].(string), "Source: ") {
+		t.Errorf("The links should replace the value of the dedicated field: %+v", incident)
+	}
+
+	incident = Incident{"description": "Disk full"}
+	applyLinks(context.Background(), LinksConfig{}, incident, linksData)
+	if incident["description"] != "Disk full" {
+		t.Errorf("The disabled links should not be rendered: %+v", incident)
+	}
+}
+
+func TestFieldMapping_GeneratorURL(t *testing.T) {
+	mappings, err := parseFieldMappings(map[string]string{"u_graph_url": "generator_url"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	incident := Incident{}
+	applyFieldMappings(mappings, incident, linksData)
+	if incident["u_graph_url"] != "http://prometheus:9090/graph?g0.expr=up" {
+		t.Errorf("The generator URL should be mapped: %+v", incident)
+	}
+	if _, err := parseFieldMappings(map[string]string{"u_graph_url": "generator_url:name"}); err == nil {
+		t.Errorf("The generator URL should not take a name")
+	}
+}
+
+func TestLinksConfig_Validate(t *testing.T) {
+	var errs strings.Builder
+	LinksConfig{Templates: []LinkConfig{{URL: "{{ .Labels"}, {Name: "Runbook"}}}.validate(&errs)
+	for _, want := range []string{"links.templates[0].name is missing", "links.templates[0].url is invalid", "links.templates[1].url is missing"} {
+		if !strings.Contains(errs.String(), want) {
+			t.Errorf("Missing validation error %q: %q", want, errs.String())
+		}
+	}
+}
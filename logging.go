@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/template"
+)
+
+const (
+	requestIDHeader = "X-Request-Id"
+	// callerDepth is the depth of the caller of the Logger methods, from the go-kit Log call
+	callerDepth = 5
+)
+
+var (
+	// baseLogger is the logger the per-request loggers are derived from
+	baseLogger = newLogger(os.Stderr, "logfmt", "info")
+
+	logTimestamp = kitlog.TimestampFormat(func() time.Time { return time.Now().UTC() }, "2006-01-02T15:04:05.000Z07:00")
+
+	// logLevels are the levels of the loggers, from the most verbose one
+	logLevels = []string{"debug", "info", "warn", "error"}
+)
+
+// Logger is a leveled logger adding its structured fields to each line, whose messages are formatted like fmt.
+// The loggers derived from a logger share its level, which can be changed at runtime.
+type Logger struct {
+	logger kitlog.Logger
+	level  *int32
+}
+
+// newLogger returns a logger writing to w in the format ("logfmt" or "json"), filtering the lines below the level
+// ("debug", "info", "warn" or "error", defaulting to "info")
+func newLogger(w io.Writer, format string, lvl string) Logger {
+	var logger kitlog.Logger
+	if format == "json" {
+		logger = kitlog.NewJSONLogger(kitlog.NewSyncWriter(w))
+	} else {
+		logger = kitlog.NewLogfmtLogger(kitlog.NewSyncWriter(w))
+	}
+
+	rank := logLevelRank(lvl)
+	if rank < 0 {
+		rank = logLevelRank("info")
+	}
+	return Logger{logger: kitlog.With(logger, "ts", logTimestamp, "caller", kitlog.Caller(callerDepth)), level: &rank}
+}
+
+// logLevelRank returns the rank of the level in logLevels, or -1 for an unknown level
+func logLevelRank(lvl string) int32 {
+	for rank, l := range logLevels {
+		if l == lvl {
+			return int32(rank)
+		}
+	}
+	return -1
+}
+
+// With returns a copy of the logger adding the field to each line
+func (l Logger) With(key string, value interface{}) Logger {
+	return Logger{logger: kitlog.With(l.logger, key, value), level: l.level}
+}
+
+// Level returns the level of the logger
+func (l Logger) Level() string {
+	return logLevels[atomic.LoadInt32(l.level)]
+}
+
+// SetLevel changes the level of the logger, and of the loggers derived from it
+func (l Logger) SetLevel(lvl string) error {
+	rank := logLevelRank(lvl)
+	if rank < 0 {
+		return fmt.Errorf("unknown log level %q, must be one of %s", lvl, strings.Join(logLevels, ", "))
+	}
+	atomic.StoreInt32(l.level, rank)
+	return nil
+}
+
+// DebugEnabled returns whether the debug lines are logged, so that their costly fields are only built when needed
+func (l Logger) DebugEnabled() bool {
+	return atomic.LoadInt32(l.level) == 0
+}
+
+func (l Logger) log(lvl level.Value, msg string) {
+	if logLevelRank(lvl.String()) < atomic.LoadInt32(l.level) {
+		return
+	}
+	l.logger.Log(level.Key(), lvl, "msg", msg)
+}
+
+// Debug logs the message at the debug level
+func (l Logger) Debug(args ...interface{}) { l.log(level.DebugValue(), fmt.Sprint(args...)) }
+
+// Debugf logs the formatted message at the debug level
+func (l Logger) Debugf(format string, args ...interface{}) {
+	l.log(level.DebugValue(), fmt.Sprintf(format, args...))
+}
+
+// Info logs the message at the info level
+func (l Logger) Info(args ...interface{}) { l.log(level.InfoValue(), fmt.Sprint(args...)) }
+
+// Infof logs the formatted message at the info level
+func (l Logger) Infof(format string, args ...interface{}) {
+	l.log(level.InfoValue(), fmt.Sprintf(format, args...))
+}
+
+// Warn logs the message at the warn level
+func (l Logger) Warn(args ...interface{}) { l.log(level.WarnValue(), fmt.Sprint(args...)) }
+
+// Warnf logs the formatted message at the warn level
+func (l Logger) Warnf(format string, args ...interface{}) {
+	l.log(level.WarnValue(), fmt.Sprintf(format, args...))
+}
+
+// Error logs the message at the error level
+func (l Logger) Error(args ...interface{}) { l.log(level.ErrorValue(), fmt.Sprint(args...)) }
+
+// Errorf logs the formatted message at the error level
+func (l Logger) Errorf(format string, args ...interface{}) {
+	l.log(level.ErrorValue(), fmt.Sprintf(format, args...))
+}
+
+// Fatal logs the message at the error level, then exits
+func (l Logger) Fatal(args ...interface{}) {
+	l.log(level.ErrorValue(), fmt.Sprint(args...))
+	os.Exit(1)
+}
+
+// Fatalf logs the formatted message at the error level, then exits
+func (l Logger) Fatalf(format string, args ...interface{}) {
+	l.log(level.ErrorValue(), fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+type loggerKey struct{}
+
+// withLogger returns a copy of the context carrying the logger
+func withLogger(ctx context.Context, logger Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// loggerFrom returns the logger carried by the context, or the base logger when there is none
+func loggerFrom(ctx context.Context) Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(Logger); ok {
+		return logger
+	}
+	return baseLogger
+}
+
+// newRequestLogger returns the logger of a webhook request, with the fields identifying the request and its alert group
+func newRequestLogger(ctx context.Context, id string, data template.Data) Logger {
+	return baseLogger.
+		With("request_id", id).
+		With("receiver", data.Receiver).
+		With("group_key", getGroupKey(ctx, data)).
+		With("alerts", len(data.Alerts))
+}
+
+// requestID returns the ID of the request sent by a proxy, or a generated one
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); len(id) > 0 {
+		return id
+	}
+	return newRequestID()
+}
+
+// newRequestID returns a random request ID
+func newRequestID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "unknown"
+	}
+	return fmt.Sprintf("%x", id)
+}
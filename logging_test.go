@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestWebhookHandler_RequestLogger(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	dedupStore = newMemoryDedupStore()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{}, nil)
+
+	var buf bytes.Buffer
+	defer func(logger Logger) { baseLogger = logger }(baseLogger)
+	baseLogger = newLogger(&buf, "logfmt", "info")
+
+	data, err := ioutil.ReadFile("test/alertmanager_firing.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/webhook", bytes.NewReader(data))
+	req.Header.Set(requestIDHeader, "req-42")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(webhook).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("Wrong status code: got %v, want %v", status, http.StatusOK)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) < 2 {
+		t.Fatalf("Expected the incident management to be logged, got %q", buf.String())
+	}
+	for _, line := range lines {
+		for _, field := range []string{"request_id=req-42", "receiver=", "group_key=", "alerts=", "level=", "caller="} {
+			if !strings.Contains(line, field) {
+				t.Errorf("Log line is missing %s: %q", field, line)
+			}
+		}
+	}
+}
+
+func TestOnAlertGroup_IncidentLogger(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "incident", mock.Anything).Return([]Incident{Incident{"sys_id": "1", "number": "INC1", "state": "2"}}, nil)
+	snClientMock.On("UpdateIncident", "incident", mock.Anything, "1").Return(Incident{}, nil)
+
+	var buf bytes.Buffer
+	defer func(logger Logger) { baseLogger = logger }(baseLogger)
+	baseLogger = newLogger(&buf, "json", "debug")
+
+	data := template.Data{Status: "firing", GroupLabels: template.KV{"alertname": "DiskFull"}, Alerts: template.Alerts{template.Alert{Fingerprint: "f1"}}}
+	if err := onAlertGroup(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"fingerprint":"f1"`) || !strings.Contains(buf.String(), `"incident":"INC1"`) || !strings.Contains(buf.String(), `"table":"incident"`) {
+		t.Errorf("Expected the incident management to be logged with the alert and incident fields, got %s", buf.String())
+	}
+}
+
+func TestNewLogger_Level(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newLogger(&buf, "logfmt", "warn")
+	logger.Infof("hidden %d", 1)
+	logger.Warnf("shown %d", 2)
+	if strings.Contains(buf.String(), "hidden") || !strings.Contains(buf.String(), `level=warn msg="shown 2"`) || !strings.Contains(buf.String(), "caller=logging_test.go:") {
+		t.Errorf("Unexpected log output: %s", buf.String())
+	}
+}
+
+func TestLoggerFrom_Default(t *testing.T) {
+	if loggerFrom(context.Background()) != baseLogger {
+		t.Errorf("A context without logger should use the base logger")
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	req := httptest.NewRequest("POST", "/webhook", nil)
+	first, second := requestID(req), requestID(req)
+	if len(first) != 16 || first == second {
+		t.Errorf("Unexpected generated request IDs: %q, %q", first, second)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+const maxLoggedBodySize = 4096
+
+// logLevelHandler is the handler of /-/loglevel, returning the log level on GET requests and changing it on PUT
+// requests to the level of the body or of the level parameter, until the next change or restart
+func logLevelHandler(w http.ResponseWriter, r *http.Request) {
+	method := http.MethodPut
+	if r.Method == http.MethodGet {
+		method = http.MethodGet
+	}
+	if !authorizeRequest(w, r, method) {
+		return
+	}
+	if method == http.MethodPut {
+		lvl := r.URL.Query().Get("level")
+		if len(lvl) == 0 {
+			body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 64))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			lvl = strings.TrimSpace(string(body))
+		}
+		previous := baseLogger.Level()
+		if err := baseLogger.SetLevel(lvl); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		baseLogger.Warnf("Log level changed from %s to %s", previous, lvl)
+	}
+	writeJSON(w, map[string]string{"level": baseLogger.Level()})
+}
+
+// startLogLevelToggle starts the background task switching the log level between debug and the level of the
+// command line on SIGUSR1
+func startLogLevelToggle() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	initial := baseLogger.Level()
+	backgroundTasks.Go("log level toggle", func(ctx context.Context) {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-signals:
+				lvl := "debug"
+				if baseLogger.DebugEnabled() {
+					lvl = initial
+					if initial == "debug" {
+						lvl = "info"
+					}
+				}
+				baseLogger.SetLevel(lvl)
+				baseLogger.Warnf("Log level switched to %s", lvl)
+			}
+		}
+	})
+}
+
+// loggedBody returns the body for a debug line, truncated to maxLoggedBodySize
+func loggedBody(body []byte) string {
+	if len(body) > maxLoggedBodySize {
+		return string(body[:maxLoggedBodySize]) + "...(truncated)"
+	}
+	return string(body)
+}
+
+// logServiceNowRequest logs the ServiceNow request at the debug level, with its JSON body but without its
+// authentication, the other bodies (e.g. attachments) being only described
+func logServiceNowRequest(ctx context.Context, req *http.Request) {
+	logger := loggerFrom(ctx)
+	if !logger.DebugEnabled() {
+		return
+	}
+	body := ""
+	if req.GetBody != nil && req.ContentLength > 0 {
+		if contentType := req.Header.Get("Content-Type"); !strings.Contains(contentType, "json") {
+			body = fmt.Sprintf("<%d bytes of %s>", req.ContentLength, contentType)
+		} else if reader, err := req.GetBody(); err == nil {
+			content, _ := ioutil.ReadAll(reader)
+			body = loggedBody(content)
+		}
+	}
+	logger.Debugf("ServiceNow request: %s %s %s", req.Method, req.URL.RequestURI(), body)
+}
+
+// logServiceNowResponse logs the ServiceNow response at the debug level
+func logServiceNowResponse(ctx context.Context, statusCode int, body []byte) {
+	if logger := loggerFrom(ctx); logger.DebugEnabled() {
+		logger.Debugf("ServiceNow response: %d %s", statusCode, loggedBody(body))
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLogLevelHandler(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	var buf bytes.Buffer
+	defer func(logger Logger) { baseLogger = logger }(baseLogger)
+	baseLogger = newLogger(&buf, "logfmt", "info")
+	requestLogger := baseLogger.With("request_id", "1")
+
+	rr := httptest.NewRecorder()
+	logLevelHandler(rr, httptest.NewRequest("GET", "/-/loglevel", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"level":"info"`) {
+		t.Errorf("Unexpected log level: %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	logLevelHandler(rr, httptest.NewRequest("PUT", "/-/loglevel", strings.NewReader("debug\n")))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"level":"debug"`) {
+		t.Errorf("The log level should be changed: %d %s", rr.Code, rr.Body.String())
+	}
+	requestLogger.Debugf("shown")
+	if !strings.Contains(buf.String(), `msg=shown`) || !strings.Contains(buf.String(), "Log level changed from info to debug") {
+		t.Errorf("The derived loggers should follow the log level: %s", buf.String())
+	}
+
+	rr = httptest.NewRecorder()
+	logLevelHandler(rr, httptest.NewRequest("PUT", "/-/loglevel?level=verbose", nil))
+	if rr.Code != http.StatusBadRequest || baseLogger.Level() != "debug" {
+		t.Errorf("The unknown level should be rejected: %d, %s", rr.Code, baseLogger.Level())
+	}
+
+	rr = httptest.NewRecorder()
+	logLevelHandler(rr, httptest.NewRequest("POST", "/-/loglevel?level=error", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("The POST requests should not be allowed: %d", rr.Code)
+	}
+}
+
+func TestLogServiceNowRequest(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newLogger(&buf, "logfmt", "info")
+	ctx := withLogger(context.Background(), logger)
+	req, _ := http.NewRequest("POST", "https://instance.service-now.com/api/now/table/incident", strings.NewReader(`{"short_description":"d"}`))
+	req.Header.Set("Content-Type", "application/json")
+
+	logServiceNowRequest(ctx, req)
+	if buf.Len() > 0 {
+		t.Errorf("The requests should only be logged at the debug level: %s", buf.String())
+	}
+	logger.SetLevel("debug")
+	logServiceNowRequest(ctx, req)
+	logServiceNowResponse(ctx, 201, bytes.Repeat([]byte("a"), maxLoggedBodySize+1))
+	for _, want := range []string{`POST /api/now/table/incident {\"short_description\":\"d\"}`, "ServiceNow response: 201", "...(truncated)"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Missing %q in the log output: %s", want, buf.String())
+		}
+	}
+}
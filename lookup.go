@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	defaultLookupCacheTTL = 10 * time.Minute
+	userTable             = "sys_user"
+)
+
+var (
+	userCache = newLookupCache("user", defaultLookupCacheTTL)
+
+	lookupCacheRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_lookup_cache_requests_total",
+			Help: "Total number of ServiceNow lookups served by the lookup caches, by cache and result (hit or miss).",
+		},
+		[]string{"cache", "result"},
+	)
+)
+
+// LookupCacheConfig - Caching of the ServiceNow lookups of the users, groups and configuration items
+type LookupCacheConfig struct {
+	TTL     time.Duration `yaml:"ttl"`
+	MaxSize int           `yaml:"max_size"`
+}
+
+func (c LookupCacheConfig) validate(errs *strings.Builder) {
+	if c.TTL < 0 {
+		errs.WriteString("lookup_cache.ttl must not be negative\n")
+	}
+	if c.MaxSize < 0 {
+		errs.WriteString("lookup_cache.max_size must not be negative\n")
+	}
+}
+
+func (c LookupCacheConfig) ttl() time.Duration {
+	if c.TTL == 0 {
+		return defaultLookupCacheTTL
+	}
+	return c.TTL
+}
+
+type lookupCacheEntry struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
+// lookupCache is a TTL cache of ServiceNow lookup results. An empty value caches a lookup without result.
+type lookupCache struct {
+	mutex   sync.Mutex
+	name    string
+	ttl     time.Duration
+	maxSize int
+	entries map[string]lookupCacheEntry
+}
+
+func newLookupCache(name string, ttl time.Duration) *lookupCache {
+	return &lookupCache{name: name, ttl: ttl, entries: map[string]lookupCacheEntry{}}
+}
+
+func (c *lookupCache) get(key string) (interface{}, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, ok := c.entries[key]
+	if ok && time.Now().After(entry.expiresAt) {
+		delete(c.entries, key)
+		ok = false
+	}
+	if !ok {
+		lookupCacheRequests.WithLabelValues(c.name, "miss").Inc()
+		return nil, false
+	}
+	lookupCacheRequests.WithLabelValues(c.name, "hit").Inc()
+	return entry.value, true
+}
+
+// set caches the value. When the cache is full, the expired entries are removed, or else the entry expiring first.
+func (c *lookupCache) set(key string, value interface{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.entries[key]; !ok && c.maxSize > 0 && len(c.entries) >= c.maxSize {
+		c.sweepLocked()
+		if len(c.entries) >= c.maxSize {
+			c.evictLocked()
+		}
+	}
+	c.entries[key] = lookupCacheEntry{value: value, expiresAt: time.Now().Add(c.ttl)}
+}
+
+func (c *lookupCache) evictLocked() {
+	var oldest string
+	var oldestExpiresAt time.Time
+	for key, entry := range c.entries {
+		if len(oldest) == 0 || entry.expiresAt.Before(oldestExpiresAt) {
+			oldest, oldestExpiresAt = key, entry.expiresAt
+		}
+	}
+	delete(c.entries, oldest)
+}
+
+// configure changes the TTL of the entries set from now on, and the maximum number of entries (0 for no limit)
+func (c *lookupCache) configure(ttl time.Duration, maxSize int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.ttl = ttl
+	c.maxSize = maxSize
+}
+
+// sweep removes the expired entries
+func (c *lookupCache) sweep() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.sweepLocked()
+}
+
+func (c *lookupCache) sweepLocked() {
+	now := time.Now()
+	for key, entry := range c.entries {
+		if now.After(entry.expiresAt) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// flush removes all the entries
+func (c *lookupCache) flush() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	flushed := len(c.entries)
+	c.entries = map[string]lookupCacheEntry{}
+	return flushed
+}
+
+// lookupCaches returns the caches of the ServiceNow lookups, configured by lookup_cache
+func lookupCaches() []*lookupCache {
+	return []*lookupCache{userCache, groupCache, ciCache, ciLookupCache, ciParentsCache, onCallCache, knowledgeCache}
+}
+
+// loadLookupCaches applies lookup_cache to the lookup caches, assignment_group.cache_ttl taking precedence for the
+// group lookups, and assigned_to.on_call_cache_ttl for the on-call ones
+func loadLookupCaches(config Config) {
+	for _, cache := range lookupCaches() {
+		ttl := config.LookupCache.ttl()
+		if cache == groupCache && config.AssignmentGroup.CacheTTL > 0 {
+			ttl = config.AssignmentGroup.CacheTTL
+		}
+		if cache == onCallCache {
+			ttl = config.AssignedTo.onCallCacheTTL()
+		}
+		cache.configure(ttl, config.LookupCache.MaxSize)
+	}
+}
+
+// flushCacheHandler is the handler of /-/cache/flush, removing on POST requests the entries of the lookup caches of
+// the cache parameters (e.g. user, group or ci), or of all the lookup caches without it, so that the changes of the
+// ServiceNow data are taken into account right away
+func flushCacheHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorizeRequest(w, r, http.MethodPost) {
+		return
+	}
+	caches := map[string]*lookupCache{}
+	for _, cache := range lookupCaches() {
+		caches[cache.name] = cache
+	}
+	selected := map[string]bool{}
+	for _, name := range r.URL.Query()["cache"] {
+		if _, ok := caches[name]; !ok {
+			http.Error(w, fmt.Sprintf("Unknown lookup cache %q", name), http.StatusBadRequest)
+			return
+		}
+		selected[name] = true
+	}
+	flushed := map[string]int{}
+	for name, cache := range caches {
+		if len(selected) == 0 || selected[name] {
+			flushed[name] = cache.flush()
+		}
+	}
+	baseLogger.Infof("Lookup caches flushed: %v", flushed)
+	writeJSON(w, flushed)
+}
+
+// lookupSysID returns the sys_id of the first record of the table having the field value, or an empty string if none.
+// The cache key holds the table and the field, as a cache such as the users one is looked up by different fields.
+func lookupSysID(ctx context.Context, cache *lookupCache, table string, field string, value string) (string, error) {
+	key := instanceKey(ctx, table+":"+field+":"+value)
+	if sysID, ok := cache.get(key); ok {
+		return sysID.(string), nil
+	}
+
+	records, err := serviceNowFrom(ctx).GetIncidents(ctx, table, map[string]string{
+		field:            value,
+		"sysparm_fields": "sys_id",
+		"sysparm_limit":  "1",
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var sysID string
+	if len(records) > 0 {
+		sysID, _ = records[0]["sys_id"].(string)
+	}
+	cache.set(key, sysID)
+	return sysID, nil
+}
+
+// resolveUsers returns the sys_ids of the given ServiceNow user names. Unresolved users are logged and skipped.
+func resolveUsers(ctx context.Context, userNames []string) []string {
+	var sysIDs []string
+	for _, userName := range userNames {
+		sysID, err := lookupSysID(ctx, userCache, userTable, "user_name", userName)
+		if err != nil {
+			serviceNowError.Inc()
+			loggerFrom(ctx).Errorf("Error resolving ServiceNow user %s: %v", userName, err)
+			continue
+		}
+		if len(sysID) == 0 {
+			loggerFrom(ctx).Warnf("ServiceNow user %s not found", userName)
+			continue
+		}
+		sysIDs = append(sysIDs, sysID)
+	}
+	return sysIDs
+}
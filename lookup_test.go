@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestLookupCache_Expiry(t *testing.T) {
+	cache := newLookupCache("test", time.Nanosecond)
+	cache.set("key", "42")
+	time.Sleep(time.Millisecond)
+
+	if _, ok := cache.get("key"); ok {
+		t.Errorf("Expired entry should not be returned")
+	}
+}
+
+func TestLookupCache_MaxSize(t *testing.T) {
+	cache := newLookupCache("test", time.Minute)
+	cache.configure(time.Minute, 2)
+	cache.set("b", "2")
+	cache.entries["a"] = lookupCacheEntry{value: "1", expiresAt: time.Now().Add(time.Second)}
+	cache.set("c", "3")
+
+	if _, ok := cache.get("a"); ok || len(cache.entries) != 2 {
+		t.Errorf("The entry expiring first should be evicted from the full cache: %v", cache.entries)
+	}
+	if _, ok := cache.get("c"); !ok {
+		t.Errorf("The new entry should be cached")
+	}
+}
+
+func TestLookupCache_Metrics(t *testing.T) {
+	cache := newLookupCache("metrics_test", time.Minute)
+	cache.get("key")
+	cache.set("key", "42")
+	cache.get("key")
+
+	if hits, misses := testutil.ToFloat64(lookupCacheRequests.WithLabelValues("metrics_test", "hit")), testutil.ToFloat64(lookupCacheRequests.WithLabelValues("metrics_test", "miss")); hits != 1 || misses != 1 {
+		t.Errorf("Unexpected cache requests: %v hit(s), %v miss(es)", hits, misses)
+	}
+}
+
+func TestFlushCacheHandler(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	userCache = newLookupCache("user", time.Minute)
+	groupCache = newLookupCache("group", time.Minute)
+	userCache.set("jdoe", "42")
+	groupCache.set("Databases", "43")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(flushCacheHandler).ServeHTTP(rr, httptest.NewRequest("POST", "/-/cache/flush?cache=user", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"user":1`) {
+		t.Errorf("Unexpected response: %v %s", rr.Code, rr.Body.String())
+	}
+	if _, ok := userCache.get("jdoe"); ok {
+		t.Errorf("The user cache should be flushed")
+	}
+	if _, ok := groupCache.get("Databases"); !ok {
+		t.Errorf("The group cache should not be flushed")
+	}
+
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(flushCacheHandler).ServeHTTP(rr, httptest.NewRequest("POST", "/-/cache/flush", nil))
+	if _, ok := groupCache.get("Databases"); ok || rr.Code != http.StatusOK {
+		t.Errorf("Every cache should be flushed: %v", rr.Code)
+	}
+
+	for _, test := range []struct {
+		method string
+		target string
+		want   int
+	}{
+		{"GET", "/-/cache/flush", http.StatusMethodNotAllowed},
+		{"POST", "/-/cache/flush?cache=unknown", http.StatusBadRequest},
+	} {
+		rr = httptest.NewRecorder()
+		http.HandlerFunc(flushCacheHandler).ServeHTTP(rr, httptest.NewRequest(test.method, test.target, nil))
+		if rr.Code != test.want {
+			t.Errorf("Unexpected status of %s %s: got %v, want %v", test.method, test.target, rr.Code, test.want)
+		}
+	}
+}
+
+func TestLookupSysID_Cached(t *testing.T) {
+	cache := newLookupCache("test", time.Minute)
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "sys_user", map[string]string{"user_name": "jdoe", "sysparm_fields": "sys_id", "sysparm_limit": "1"}).Return([]Incident{Incident{"sys_id": "42"}}, nil).Once()
+	snClientMock.On("GetIncidents", "sys_user", map[string]string{"user_name": "nobody", "sysparm_fields": "sys_id", "sysparm_limit": "1"}).Return([]Incident{}, nil).Once()
+
+	for i := 0; i < 2; i++ {
+		sysID, err := lookupSysID(context.Background(), cache, "sys_user", "user_name", "jdoe")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sysID != "42" {
+			t.Errorf("Unexpected sys_id: got %v, want %v", sysID, "42")
+		}
+
+		sysID, err = lookupSysID(context.Background(), cache, "sys_user", "user_name", "nobody")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sysID != "" {
+			t.Errorf("Unexpected sys_id: got %v, want empty", sysID)
+		}
+	}
+	snClientMock.AssertNumberOfCalls(t, "GetIncidents", 2)
+}
+
+func TestLookupSysID_CachedByField(t *testing.T) {
+	cache := newLookupCache("test", time.Minute)
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "sys_user", map[string]string{"user_name": "jdoe", "sysparm_fields": "sys_id", "sysparm_limit": "1"}).Return([]Incident{{"sys_id": "42"}}, nil).Once()
+	snClientMock.On("GetIncidents", "sys_user", map[string]string{"email": "jdoe", "sysparm_fields": "sys_id", "sysparm_limit": "1"}).Return([]Incident{}, nil).Once()
+
+	if sysID, err := lookupSysID(context.Background(), cache, "sys_user", "user_name", "jdoe"); err != nil || sysID != "42" {
+		t.Fatalf("Unexpected sys_id by user_name: %v, %v", sysID, err)
+	}
+	if sysID, err := lookupSysID(context.Background(), cache, "sys_user", "email", "jdoe"); err != nil || sysID != "" {
+		t.Errorf("The lookup by email should not use the entry of the lookup by user_name: %v, %v", sysID, err)
+	}
+	snClientMock.AssertNumberOfCalls(t, "GetIncidents", 2)
+}
+
+func TestLookupSysID_Error(t *testing.T) {
+	cache := newLookupCache("test", time.Minute)
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, errors.New("Error"))
+
+	if _, err := lookupSysID(context.Background(), cache, "sys_user", "user_name", "jdoe"); err == nil {
+		t.Errorf("Expected an error, got none")
+	}
+	if len(cache.entries) > 0 {
+		t.Errorf("Failed lookup should not be cached")
+	}
+}
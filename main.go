@@ -2,37 +2,68 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/prometheus/alertmanager/template"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/promlog"
+	promlogflag "github.com/prometheus/common/promlog/flag"
 	"github.com/prometheus/common/version"
 
 	"gopkg.in/alecthomas/kingpin.v2"
 	"gopkg.in/yaml.v2"
 
-	"github.com/prometheus/common/log"
-
 	"crypto/md5"
-	tmpltext "text/template"
 )
 
 var (
-	configFile           = kingpin.Flag("config.file", "ServiceNow configuration file.").Default("config/servicenow.yml").String()
+	_                    = kingpin.Command("serve", "Start the webhook (default).").Default()
+	checkConfigCommand   = kingpin.Command("check-config", "Validate the config file offline, exiting with a non-zero status when it is invalid.")
+	replayCommand        = kingpin.Command("replay", "Process archived notifications again through the incident pipeline, exiting with a non-zero status when any failed.")
+	replayFiles          = replayCommand.Arg("file", "Archived notification files.").Required().ExistingFiles()
+	sendTestAlertCommand = kingpin.Command("send-test-alert", "Send a synthetic alert to a running webhook, or through the incident pipeline in-process, exiting with a non-zero status when it failed.")
+	testAlertLabels      = sendTestAlertCommand.Flag("label", "Label of the alert, as name=value. Repeatable. The alertname defaults to "+testAlertName+".").StringMap()
+	testAlertAnnotations = sendTestAlertCommand.Flag("annotation", "Annotation of the alert, as name=value. Repeatable.").StringMap()
+	testAlertStatus      = sendTestAlertCommand.Flag("status", "Status of the alert, firing or resolved.").Default("firing").Enum("firing", "resolved")
+	testAlertReceiver    = sendTestAlertCommand.Flag("receiver", "Alertmanager receiver of the notification.").Default("send-test-alert").String()
+	testAlertURL         = sendTestAlertCommand.Flag("url", "URL of the running webhook to send the alert to, e.g. http://127.0.0.1:9877/webhook, with the basic auth credentials if any. The alert is processed in-process when empty.").String()
+	testAlertToken       = sendTestAlertCommand.Flag("bearer-token", "Bearer token sent to the webhook of --url.").String()
+	testAlertWebhook     = sendTestAlertCommand.Flag("webhook-receiver", "Webhook receiver whose config processes the alert in-process, the default webhook when empty.").String()
+	configFile           = kingpin.Flag("config.file", "ServiceNow configuration file, none to configure the webhook with the environment variables only.").Default("config/servicenow.yml").String()
 	listenAddress        = kingpin.Flag("web.listen-address", "The address to listen on for HTTP requests.").Default(":9877").String()
-	config               Config
-	serviceNow           ServiceNow
-	noUpdateStates       map[json.Number]bool
-	incidentUpdateFields map[string]bool
+	tlsCertFile          = kingpin.Flag("web.tls-cert-file", "Path of the TLS certificate file, to serve HTTPS. Requires --web.tls-key-file.").String()
+	tlsKeyFile           = kingpin.Flag("web.tls-key-file", "Path of the TLS private key file, to serve HTTPS. Requires --web.tls-cert-file.").String()
+	shutdownGracePeriod  = kingpin.Flag("shutdown.grace-period", "Maximum time to wait for the requests in flight, then for the background tasks, to finish on shutdown.").Default("30s").Duration()
+	dryRun               = kingpin.Flag("dry-run", "Only log the incidents that would be created or updated, without writing them to ServiceNow.").Bool()
+	readinessCheckTTL    = kingpin.Flag("web.readiness-check-ttl", "How long the result of the ServiceNow check of /-/ready is cached.").Default("30s").Duration()
+	readTimeout          = kingpin.Flag("web.read-timeout", "Maximum duration for reading an entire request, including the body. 0 disables it.").Default("30s").Duration()
+	writeTimeout         = kingpin.Flag("web.write-timeout", "Maximum duration before timing out the writes of a response, from the end of the request headers. 0 disables it.").Default("90s").Duration()
+	idleTimeout          = kingpin.Flag("web.idle-timeout", "Maximum time to wait for the next request on a keep-alive connection. 0 disables it.").Default("120s").Duration()
+	maxRequestBodySize   = kingpin.Flag("web.max-request-body-size", "Maximum size of the /webhook request bodies, larger ones are answered with a 413. 0 disables it.").Default("10MB").Bytes()
+	maxDecompressedSize  = kingpin.Flag("web.max-decompressed-body-size", "Maximum size of the gzip compressed /webhook request bodies once decompressed, larger ones are answered with a 413. 0 disables it.").Default("50MB").Bytes()
+	telemetryAddress     = kingpin.Flag("web.telemetry-address", "The address of the telemetry listener serving the status page, /metrics, the health endpoints, the admin APIs and /debug/pprof, which are then no longer served on --web.listen-address. Disabled by default.").String()
+	debugListenAddress   = kingpin.Flag("debug.listen-address", "The address of the admin listener serving the /debug/pprof profiling endpoints. Disabled by default.").String()
+	mappingsMaxEntries   = kingpin.Flag("mappings.max-entries", "Maximum number of alerts whose incident is served on /api/v1/mappings, the least recently updated ones being forgotten. 0 disables it.").Default("10000").Int()
+	dedupBoltPath        = kingpin.Flag("dedup.bolt-path", "Path of the BoltDB file persisting the deduplication store across restarts. Defaults to the store of the config file.").String()
+
+	dedupStore    DedupStore = newMemoryDedupStore()
+	alertMappings *alertMappingRegistry
 
 	webhookRequests = promauto.NewCounterVec(
 		prometheus.CounterOpts{
@@ -88,30 +119,128 @@ var (
 
 // Config - ServiceNow webhook configuration
 type Config struct {
-	ServiceNow      ServiceNowConfig  `yaml:"service_now"`
-	Workflow        WorkflowConfig    `yaml:"workflow"`
-	DefaultIncident map[string]string `yaml:"default_incident"`
+	ServiceNow       ServiceNowConfig             `yaml:"service_now"`
+	Workflow         WorkflowConfig               `yaml:"workflow"`
+	DefaultIncident  map[string]string            `yaml:"default_incident"`
+	TestNotification TestNotificationConfig       `yaml:"test_notification"`
+	Dedup            DedupConfig                  `yaml:"dedup"`
+	WatchList        WatchListConfig              `yaml:"watch_list"`
+	AssignedTo       AssignedToConfig             `yaml:"assigned_to"`
+	AssignmentGroup  AssignmentGroupConfig        `yaml:"assignment_group"`
+	ImpactAnalysis   ImpactAnalysisConfig         `yaml:"impact_analysis"`
+	CILookup         CILookupConfig               `yaml:"ci_lookup"`
+	Webhook          WebhookConfig                `yaml:"webhook"`
+	SchemaValidation SchemaValidationConfig       `yaml:"schema_validation"`
+	Enrichment       EnrichmentConfig             `yaml:"enrichment"`
+	Routes           []RouteConfig                `yaml:"routes"`
+	TableProfiles    map[string]map[string]string `yaml:"table_profiles"`
+	FieldMappings    map[string]string            `yaml:"field_mappings"`
+	FieldLabelPrefix string                       `yaml:"field_label_prefix"`
+	FieldLimits      map[string]FieldLimitConfig  `yaml:"field_limits"`
+	SeverityMapping  SeverityMappingConfig        `yaml:"severity_mapping"`
+	Classification   ClassificationConfig         `yaml:"classification"`
+	BusinessHours    BusinessHoursConfig          `yaml:"business_hours"`
+	InstanceList     InstanceListConfig           `yaml:"instance_list"`
+	RelatedAlerts    RelatedAlertsConfig          `yaml:"related_alerts"`
+	Async            AsyncConfig                  `yaml:"async"`
+	DeadLetter       DeadLetterConfig             `yaml:"dead_letter"`
+	Instances        map[string]ServiceNowConfig  `yaml:"instances"`
+	Target           string                       `yaml:"target"`
+	Event            EventConfig                  `yaml:"event"`
+
+	// IncidentTemplates holds named incident field sets, selected by the IncidentTemplateRules
+	IncidentTemplates     map[string]map[string]string `yaml:"incident_templates"`
+	IncidentTemplateRules []IncidentTemplateRuleConfig `yaml:"incident_template_rules"`
+	MaintenanceWindows    []MaintenanceWindowConfig    `yaml:"maintenance_windows"`
+	AlertFilter           AlertFilterConfig            `yaml:"alert_filter"`
+	Tracing               TracingConfig                `yaml:"tracing"`
+	Audit                 AuditConfig                  `yaml:"audit"`
+	Receivers             map[string]ReceiverConfig    `yaml:"receivers"`
+	HA                    HAConfig                     `yaml:"ha"`
+	Attachment            AttachmentConfig             `yaml:"attachment"`
+	Caller                CallerConfig                 `yaml:"caller"`
+
+	// TableWorkflows overrides the workflow of the records of the named tables
+	TableWorkflows map[string]TableWorkflowConfig `yaml:"table_workflows"`
+	Links          LinksConfig                    `yaml:"links"`
+	Timestamps     TimestampsConfig               `yaml:"timestamps"`
+
+	// FieldSanitization cleans up the values of the named incident fields, before their field_limits are enforced
+	FieldSanitization map[string]FieldSanitizationConfig `yaml:"field_sanitization"`
+	// TableFieldMappings holds the field mappings of the records of a table, applied after the field_mappings
+	TableFieldMappings map[string]map[string]string `yaml:"table_field_mappings"`
+	// RequiredFields are the incident fields required on creation, by name
+	RequiredFields map[string]RequiredFieldConfig `yaml:"required_fields"`
+	// RequestID records the request ID of the webhook deliveries on their incidents
+	RequestID RequestIDConfig `yaml:"request_id"`
+	// AlertmanagerGroupKey records the Alertmanager group key of the alert groups on their incidents
+	AlertmanagerGroupKey AlertmanagerGroupKeyConfig `yaml:"alertmanager_group_key"`
+	// Knowledge records the knowledge base articles referenced by the alerts on their incidents
+	Knowledge KnowledgeConfig `yaml:"knowledge"`
+	// Alertmanager is called back to silence the alert groups of the acknowledged incidents
+	Alertmanager AlertmanagerConfig `yaml:"alertmanager"`
+	// Callback receives the incident state changes notified by ServiceNow
+	Callback CallbackConfig `yaml:"callback"`
+	// LookupCache configures the caches of the ServiceNow lookups of the users, groups and configuration items
+	LookupCache LookupCacheConfig `yaml:"lookup_cache"`
+	// Archive stores the received notifications, with their incident payloads, for their replay
+	Archive ArchiveConfig `yaml:"archive"`
 }
 
 // ServiceNowConfig - ServiceNow instance configuration
 type ServiceNowConfig struct {
-	InstanceName string `yaml:"instance_name"`
-	UserName     string `yaml:"user_name"`
-	Password     string `yaml:"password"`
-	TableName	 string `yaml:"table_name"`
+	InstanceName   string               `yaml:"instance_name"`
+	APIURL         string               `yaml:"api_url"`
+	UserName       string               `yaml:"user_name"`
+	Password       string               `yaml:"password"`
+	PasswordFile   string               `yaml:"password_file"`
+	TableName      string               `yaml:"table_name"`
+	OAuth2         OAuth2Config         `yaml:"oauth2"`
+	Retry          RetryConfig          `yaml:"retry"`
+	ProxyURL       string               `yaml:"proxy_url"`
+	TLSConfig      TLSConfig            `yaml:"tls_config"`
+	RateLimit      RateLimitConfig      `yaml:"rate_limit"`
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+	Vault          VaultConfig          `yaml:"vault"`
+	ImportSet      ImportSetConfig      `yaml:"import_set"`
+	Transport      TransportConfig      `yaml:"transport"`
+
+	// Maximum number of requests in flight to the instance, 0 for no limit
+	MaxConcurrentRequests int `yaml:"max_concurrent_requests"`
+	// Static headers added to each request, and the files holding the values of the secret ones
+	Headers     map[string]string `yaml:"headers"`
+	HeaderFiles map[string]string `yaml:"header_files"`
 }
 
 // WorkflowConfig - Incident workflow configuration
 type WorkflowConfig struct {
-	IncidentGroupKeyField string        `yaml:"incident_group_key_field"`
-	NoUpdateStates        []json.Number `yaml:"no_update_states"`
-	IncidentUpdateFields  []string      `yaml:"incident_update_fields"`
+	IncidentGroupKeyField   string                `yaml:"incident_group_key_field"`
+	NoUpdateStates          []json.Number         `yaml:"no_update_states"`
+	IncidentUpdateFields    []string              `yaml:"incident_update_fields"`
+	TwoPhaseCreate          TwoPhaseCreateConfig  `yaml:"two_phase_create"`
+	MultipleIncidentsPolicy string                `yaml:"multiple_incidents_policy"`
+	DuplicateCancelState    string                `yaml:"duplicate_cancel_state"`
+	CorrelationKey          CorrelationKeyConfig  `yaml:"correlation_key"`
+	Mode                    string                `yaml:"mode"`
+	Resolve                 ResolveConfig         `yaml:"resolve"`
+	IncidentPerAlert        bool                  `yaml:"incident_per_alert"`
+	RepeatWorkNotes         RepeatWorkNotesConfig `yaml:"repeat_work_notes"`
+	Refire                  RefireConfig          `yaml:"refire"`
+	Escalation              EscalationConfig      `yaml:"escalation"`
+	UpdateThrottle          UpdateThrottleConfig  `yaml:"update_throttle"`
+	States                  IncidentStatesConfig  `yaml:"states"`
+	ChildRecords            ChildRecordsConfig    `yaml:"child_records"`
+	Reconciliation          ReconciliationConfig  `yaml:"reconciliation"`
+	// GroupConcurrency is the number of alert groups of a notification, split by table or by alert, managed concurrently
+	GroupConcurrency int `yaml:"group_concurrency"`
 }
 
 // JSONResponse is the Webhook http response
 type JSONResponse struct {
+	XMLName xml.Name `json:"-" xml:"response"`
 	Status  int
 	Message string
+	Results []alertResult `json:",omitempty" xml:"Result,omitempty"`
 }
 
 func init() {
@@ -121,18 +250,76 @@ func init() {
 func (c Config) validate() error {
 	var errs strings.Builder
 
-	if len(c.ServiceNow.InstanceName) == 0 {
+	if len(c.ServiceNow.InstanceName) == 0 && len(c.ServiceNow.APIURL) == 0 {
 		errs.WriteString("instance_name is missing\n")
 	}
-	if len(c.ServiceNow.UserName) == 0 {
+	validateAPIURL(c.ServiceNow, &errs)
+	if len(c.ServiceNow.UserName) == 0 && !c.ServiceNow.Vault.enabled() {
 		errs.WriteString("user_name is missing\n")
 	}
-	if len(c.ServiceNow.Password) == 0 {
+	if len(c.ServiceNow.Password) == 0 && !c.ServiceNow.OAuth2.enabled() && !c.ServiceNow.Vault.enabled() {
 		errs.WriteString("password is missing\n")
 	}
-	if len(c.Workflow.IncidentGroupKeyField) == 0 {
+	if len(c.Workflow.IncidentGroupKeyField) == 0 && !c.eventTarget() {
 		errs.WriteString("incident_group_key_field is missing\n")
 	}
+	c.ServiceNow.OAuth2.validate(&errs)
+	c.ServiceNow.Retry.validate(&errs)
+	validateProxyURL(c.ServiceNow.ProxyURL, &errs)
+	c.ServiceNow.TLSConfig.validate(&errs)
+	c.ServiceNow.Transport.validate(&errs)
+	c.ServiceNow.RateLimit.validate(&errs)
+	validateMaxConcurrentRequests(c.ServiceNow, &errs)
+	validateHeaders(c.ServiceNow, &errs)
+	c.ServiceNow.CircuitBreaker.validate(&errs)
+	c.ServiceNow.Vault.validate(&errs)
+	c.ServiceNow.ImportSet.validate(&errs)
+	c.Workflow.TwoPhaseCreate.validate(&errs)
+	validateMultipleIncidentsPolicy(c.Workflow, &errs)
+	c.Workflow.CorrelationKey.validate(&errs)
+	validateWorkflowMode(c.Workflow, &errs)
+	c.Workflow.Resolve.validate(&errs)
+	validateGroupConcurrency(c.Workflow, &errs)
+	c.Workflow.UpdateThrottle.validate(&errs)
+	c.Workflow.Refire.validate(c.Workflow.NoUpdateStates, &errs)
+	c.Workflow.Escalation.validate(&errs)
+	c.Workflow.States.validate(c.Workflow.TwoPhaseCreate, &errs)
+	c.Workflow.ChildRecords.validate(c.Workflow.IncidentPerAlert, &errs)
+	c.AssignmentGroup.validate(&errs)
+	c.AssignedTo.validate(&errs)
+	c.AlertmanagerGroupKey.validate(c, &errs)
+	c.Knowledge.validate(c, &errs)
+	c.Alertmanager.validate(c, &errs)
+	c.Callback.validate(c, &errs)
+	c.LookupCache.validate(&errs)
+	c.Archive.validate(&errs)
+	c.Webhook.validate(&errs)
+	c.SchemaValidation.validate(&errs)
+	validateTarget(c, &errs)
+	validateInstances(c, &errs)
+	validateRoutes(c, &errs)
+	validateReceivers(c, &errs)
+	validateIncidentTemplates(c, &errs)
+	validateMaintenanceWindows(c, &errs)
+	c.AlertFilter.validate(&errs)
+	c.Tracing.validate(&errs)
+	c.Audit.validate(&errs)
+	validateHA(c, &errs)
+	c.Attachment.validate(&errs)
+	c.Caller.validate(&errs)
+	validateTableWorkflows(c, &errs)
+	c.Links.validate(&errs)
+	c.Timestamps.validate(&errs)
+	validateFieldMappings(c, &errs)
+	validateFieldLimits(c, &errs)
+	validateFieldSanitization(c, &errs)
+	validateRequiredFields(c, &errs)
+	c.SeverityMapping.validate(&errs)
+	c.Classification.validate(&errs)
+	c.BusinessHours.validate(&errs)
+	c.Async.validate(&errs)
+	c.DeadLetter.validate(&errs)
+	c.Dedup.validate(&errs)
 
 	if errs.Len() > 0 {
 		return errors.New("Config file is invalid\n" + errs.String())
@@ -141,130 +328,512 @@ func (c Config) validate() error {
 }
 
 func webhook(w http.ResponseWriter, r *http.Request) {
+	// A reload does not change the config of the request in flight
+	r = r.WithContext(withConfigSnapshot(r.Context()))
+	config := configFrom(r.Context())
+
+	if !config.Webhook.SourceIPs.allowed(r) {
+		webhookForbiddenRequests.Inc()
+		baseLogger.Warnf("Forbidden request on /webhook from %s", r.RemoteAddr)
+		sendResponse(w, r, http.StatusForbidden, "Forbidden")
+		return
+	}
+
+	if !config.Webhook.authenticate(r) {
+		baseLogger.Warnf("Unauthorized request on /webhook from %s", r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", `Basic realm="alertmanager-webhook-servicenow"`)
+		sendResponse(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	if rateLimitRequest(w, r) {
+		return
+	}
 
-	data, err := readRequestBody(r)
+	receiver := receiverFrom(r.Context())
+	if _, ok := config.Receivers[receiver]; len(receiver) > 0 && !ok {
+		sendResponse(w, r, http.StatusNotFound, fmt.Sprintf("Receiver %s not found", receiver))
+		return
+	}
+
+	if !leader.isLeader() {
+		// Alertmanager retries the notification, reaching the leader through the load balancer
+		w.Header().Set("Retry-After", config.Webhook.retryAfterHeader(0))
+		sendResponse(w, r, http.StatusServiceUnavailable, "Standby replica, the incidents are managed by the leader")
+		return
+	}
+
+	if err := limitRequestBody(r, int64(*maxRequestBodySize)); err != nil {
+		baseLogger.Errorf("Error reading request body : %v", err)
+		if _, ok := err.(*bodyTooLargeError); ok {
+			sendResponse(w, r, http.StatusRequestEntityTooLarge, err.Error())
+		} else {
+			sendResponse(w, r, http.StatusBadRequest, err.Error())
+		}
+		return
+	}
+	if err := config.Webhook.Signature.verifySignature(r); err != nil {
+		baseLogger.Warnf("Rejected request on /webhook from %s : %v", r.RemoteAddr, err)
+		sendResponse(w, r, http.StatusUnauthorized, "Invalid signature")
+		return
+	}
+	if err := decompressRequestBody(r, int64(*maxDecompressedSize)); err != nil {
+		baseLogger.Errorf("Error decompressing request body : %v", err)
+		switch err.(type) {
+		case *bodyTooLargeError:
+			sendResponse(w, r, http.StatusRequestEntityTooLarge, err.Error())
+		case *unsupportedEncodingError:
+			sendResponse(w, r, http.StatusUnsupportedMediaType, err.Error())
+		default:
+			sendResponse(w, r, http.StatusBadRequest, err.Error())
+		}
+		return
+	}
+	message, err := readWebhookMessage(r)
+	data := message.Data
 	if err != nil {
-		log.Errorf("Error reading request body : %v", err)
-		sendJSONResponse(w, http.StatusBadRequest, err.Error())
+		baseLogger.Errorf("Error reading request body : %v", err)
+		sendResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	webhookNotificationAlerts.Observe(float64(len(data.Alerts)))
+	spanFrom(r.Context()).setAttribute("alerts", len(data.Alerts))
+	spanFrom(r.Context()).setAttribute("receiver", data.Receiver)
+	id := requestID(r)
+	w.Header().Set(requestIDHeader, id)
+	spanFrom(r.Context()).setAttribute("request_id", id)
+	logger := newRequestLogger(r.Context(), id, data)
+	if len(receiver) > 0 {
+		logger = logger.With("webhook_receiver", receiver)
+	}
+	caller := auditCaller{Source: "webhook", RequestID: id, RemoteAddr: r.RemoteAddr, Receiver: data.Receiver, GroupKey: getGroupKey(r.Context(), data), AlertmanagerGroupKey: string(message.GroupKey)}
+	ctx := withAuditCaller(withLogger(r.Context(), logger), caller)
+	if dryRunRequested(r) {
+		ctx = withDryRun(ctx)
+	}
+	ctx = withArchive(ctx)
+	key, state := claimDelivery(ctx, receiver, message)
+	if state == deliveryProcessed {
+		webhookDuplicateDeliveries.WithLabelValues(state).Inc()
+		logger.Info("Duplicate delivery already processed, skipped")
+		sendResponse(w, r, http.StatusOK, "Duplicate delivery already processed")
+		return
+	} else if len(state) > 0 {
+		webhookDuplicateDeliveries.WithLabelValues("in_progress").Inc()
+		logger.Info("Duplicate delivery still being processed, skipped")
+		w.Header().Set("Retry-After", config.Webhook.retryAfterHeader(0))
+		sendResponse(w, r, http.StatusServiceUnavailable, "Duplicate delivery still being processed")
 		return
 	}
+	processed := false
+	defer func() { finishDelivery(ctx, key, processed) }()
+
+	ctx, results := withAlertResults(ctx)
+	if alertGroupQueue != nil {
+		// The alert group outlives the request, it is processed with a context of its own
+		jobCtx := continueTrace(withReceiver(withAuditCaller(withLogger(context.Background(), logger), caller), receiver), ctx)
+		if isDryRun(ctx) {
+			jobCtx = withDryRun(jobCtx)
+		}
+		err = alertGroupQueue.enqueue(withArchive(jobCtx), data)
+		if err == nil {
+			processed = true
+			logger.Info("Alert group queued")
+			sendResponse(w, r, http.StatusAccepted, "Accepted")
+			return
+		}
+	} else {
+		err = onAlertGroup(ctx, data)
+		archiveNotification(ctx, data, err)
+		if !results.partial() && acceptAlertGroup(ctx, data, err) {
+			processed = true
+			sendResultsResponse(w, r, http.StatusAccepted, "Accepted, the alert group is delivered once ServiceNow is available", results.list())
+			return
+		}
+	}
 
-	err = onAlertGroup(data)
+	if status := config.Webhook.PartialFailureStatus; status != 0 && results.partial() {
+		logger.Errorf("Error managing incidents of some alerts : %v", err)
+		deadLetterFailedDelivery(ctx, status, results.failedAlerts(data), err)
+		processed = status < 300
+		sendResultsResponse(w, r, status, err.Error(), results.list())
+		return
+	}
 
+	switch err.(type) {
+	case *fieldLengthError, *requiredFieldError:
+		logger.Errorf("Rejected incident from alert : %v", err)
+		status := config.Webhook.ErrorStatusCodes.status(errorClassValidation)
+		deadLetterFailedDelivery(ctx, status, data, err)
+		sendResponse(w, r, status, err.Error())
+		return
+	}
+	if overload, ok := err.(*overloadError); ok {
+		// Not dead-lettered, as Alertmanager retries the alert group after the Retry-After
+		logger.Errorf("Overloaded while managing incident from alert : %v", err)
+		w.Header().Set("Retry-After", config.Webhook.retryAfterHeader(overload.retryAfter))
+		sendResultsResponse(w, r, http.StatusServiceUnavailable, err.Error(), results.list())
+		return
+	}
 	if err != nil {
-		log.Errorf("Error managing incident from alert : %v", err)
-		sendJSONResponse(w, http.StatusInternalServerError, err.Error())
+		class := errorClass(err)
+		logger.Errorf("Error managing incident from alert (%s error) : %v", class, err)
+		status := config.Webhook.ErrorStatusCodes.status(class)
+		deadLetterFailedDelivery(ctx, status, data, err)
+		sendResultsResponse(w, r, status, err.Error(), results.list())
 		return
 	}
 
 	// Returns a 200 if everything went smoothly
-	sendJSONResponse(w, http.StatusOK, "Success")
-}
-
-func homepage(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte(`<html>
-	<head><title>alertmanager-webhook-servicenow</title></head>
-	<body>
-	<h1>alertmanager-webhook-servicenow</h1>
-	<p><a href="/metrics">Metrics</a></p>
-	</body>
-	</html>`))
+	processed = true
+	sendResultsResponse(w, r, http.StatusOK, "Success", results.list())
 }
 
 // Starts the following http handler:
-// - basic home page on /
+// - status page on /
 // - Alertmanager webhook entry point on /webhook
 // - health metrics on /metrics
 func main() {
+	logConfig := promlog.Config{}
+	promlogflag.AddFlags(kingpin.CommandLine, &logConfig)
 	kingpin.Version(version.Print("alertmanager-webhook-servicenow"))
 	kingpin.HelpFlag.Short('h')
-	kingpin.Parse()
+	command := kingpin.Parse()
+	baseLogger = newLogger(os.Stderr, logConfig.Format.String(), logConfig.Level.String())
+
+	if command == checkConfigCommand.FullCommand() {
+		os.Exit(runCheckConfig(os.Stdout, *configFile))
+	}
+
+	if command == sendTestAlertCommand.FullCommand() && len(*testAlertURL) > 0 {
+		os.Exit(runSendTestAlert(os.Stdout, *testAlertURL, *testAlertToken, "", newTestNotification(*testAlertReceiver, *testAlertStatus, *testAlertLabels, *testAlertAnnotations, time.Now())))
+	}
+
+	if err := validateTLSFlags(*tlsCertFile, *tlsKeyFile); err != nil {
+		baseLogger.Fatal(err)
+	}
 
 	_, err := loadConfig(*configFile)
 	if err != nil {
-		log.Fatalf("Error loading config file: %v", err)
+		baseLogger.Fatalf("Error loading config file: %v", err)
+	}
+
+	if err := loadVaultCredentials(context.Background()); err != nil {
+		baseLogger.Fatal(err)
 	}
 
 	_, err = loadSnClient()
 	if err != nil {
-		log.Fatalf("Error loading ServiceNow client: %v", err)
+		baseLogger.Fatalf("Error loading ServiceNow client: %v", err)
 	}
 
-	log.Info("Starting webhook", version.Info())
-	log.Info("Build context", version.BuildContext())
+	// The startup reads a single version of the config
+	startup := withConfigSnapshot(context.Background())
+	config := *configFrom(startup)
 
-	http.HandleFunc("/", homepage)
-	http.HandleFunc("/webhook", webhook)
-	http.Handle("/metrics", promhttp.Handler())
+	err = validateSchema(startup)
+	if err != nil {
+		baseLogger.Fatalf("Error validating the configuration against ServiceNow schema: %v", err)
+	}
 
-	log.Infof("listening on: %v", *listenAddress)
-	log.Fatal(http.ListenAndServe(*listenAddress, nil))
+	_, err = loadDedupStore(config)
+	if err != nil {
+		baseLogger.Fatalf("Error loading deduplication store: %v", err)
+	}
+	if command == replayCommand.FullCommand() {
+		if err := loadAuditLog(config); err != nil {
+			baseLogger.Fatalf("Error loading the audit log: %v", err)
+		}
+		os.Exit(runReplay(os.Stdout, *replayFiles))
+	}
+	if command == sendTestAlertCommand.FullCommand() {
+		if err := loadAuditLog(config); err != nil {
+			baseLogger.Fatalf("Error loading the audit log: %v", err)
+		}
+		os.Exit(runSendTestAlert(os.Stdout, "", "", *testAlertWebhook, newTestNotification(*testAlertReceiver, *testAlertStatus, *testAlertLabels, *testAlertAnnotations, time.Now())))
+	}
+	if err := loadLeaderElector(config); err != nil {
+		baseLogger.Fatalf("Error loading the leader election: %v", err)
+	}
+
+	loadRecentAlerts(config)
+	loadAlertMappings()
+	_, err = loadDeadLetterQueue(config)
+	if err != nil {
+		baseLogger.Fatalf("Error loading dead-letter queue: %v", err)
+	}
+	if _, err := loadAlertGroupQueue(config); err != nil {
+		baseLogger.Fatalf("Error loading the asynchronous processing: %v", err)
+	}
+	if err := loadAuditLog(config); err != nil {
+		baseLogger.Fatalf("Error loading the audit log: %v", err)
+	}
+	if err := loadArchive(config); err != nil {
+		baseLogger.Fatalf("Error loading the archive: %v", err)
+	}
+	if err := loadTracer(config); err != nil {
+		baseLogger.Fatalf("Error loading the tracing: %v", err)
+	}
+
+	baseLogger.Info("Starting webhook", version.Info())
+	baseLogger.Info("Build context", version.BuildContext())
+
+	backgroundTasks.Go("lookup cache sweeper", func(ctx context.Context) {
+		runEvery(ctx, sweepInterval, func() {
+			userCache.sweep()
+			groupCache.sweep()
+			ciCache.sweep()
+			ciLookupCache.sweep()
+			ciParentsCache.sweep()
+			onCallCache.sweep()
+			knowledgeCache.sweep()
+			s := currentConfigSnapshot()
+			if s.enricher != nil {
+				s.enricher.cache.sweep()
+			}
+			if recentAlerts != nil {
+				recentAlerts.sweep()
+			}
+			if s.inboundRateLimiter != nil {
+				s.inboundRateLimiter.sweep()
+			}
+		})
+	})
+	startAssignmentRetry(config)
+	startAutoClose()
+	startReconciliation(config)
+	startArchivePruning(config)
+	startDeadLetterReplay(config)
+	startConfigReload(*configFile)
+	startLogLevelToggle()
+	startVaultRefresh(config)
+
+	server := &http.Server{
+		Handler:      webhookHandler(*telemetryAddress),
+		ReadTimeout:  *readTimeout,
+		WriteTimeout: *writeTimeout,
+		IdleTimeout:  *idleTimeout,
+	}
+	debugServer, err := startDebugServer(*debugListenAddress)
+	if err != nil {
+		baseLogger.Fatalf("Error listening on %v: %v", *debugListenAddress, err)
+	}
+	telemetryServer, err := startTelemetryServer(*telemetryAddress)
+	if err != nil {
+		baseLogger.Fatalf("Error listening on %v: %v", *telemetryAddress, err)
+	}
+	// The listening socket is inherited from systemd when socket-activated, ignoring the listen address
+	activatedListener, err := systemdListener()
+	if err != nil {
+		baseLogger.Fatal(err)
+	}
+	serverErr := make(chan error, 1)
+	go func() {
+		listener, address := activatedListener, "systemd socket"
+		if listener == nil {
+			tcpListener, err := net.Listen("tcp", *listenAddress)
+			if err != nil {
+				serverErr <- err
+				return
+			}
+			listener, address = tcpListener, *listenAddress
+		}
+		if len(*tlsCertFile) > 0 {
+			baseLogger.Infof("listening on: %v (TLS)", address)
+		} else {
+			baseLogger.Infof("listening on: %v", address)
+		}
+		startSystemdNotify()
+		serverErr <- serve(server, listener, *tlsCertFile, *tlsKeyFile)
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	select {
+	case err = <-serverErr:
+		baseLogger.Errorf("Error listening on %v: %v", *listenAddress, err)
+	case sig := <-signals:
+		baseLogger.Infof("Received %v, shutting down", sig)
+	}
+	sdNotify(systemdStopping)
+
+	if debugServer != nil {
+		debugServer.Close()
+	}
+	shutdown(server, *shutdownGracePeriod)
+	if telemetryServer != nil {
+		// Closed last, so that the health endpoints and the metrics remain available while shutting down
+		telemetryServer.Close()
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+func sendResponse(w http.ResponseWriter, r *http.Request, status int, message string) {
+	sendResultsResponse(w, r, status, message, nil)
 }
 
-func sendJSONResponse(w http.ResponseWriter, status int, message string) {
+// sendResultsResponse sends the response along with the outcome of each alert
+func sendResultsResponse(w http.ResponseWriter, r *http.Request, status int, message string, results []alertResult) {
+	config := configFrom(r.Context())
 	webhookRequests.WithLabelValues(strconv.Itoa(status)).Inc()
 	webhookLastRequest.SetToCurrentTime()
+	recordDelivery(r, status, message, results)
 
 	data := JSONResponse{
 		Status:  status,
 		Message: message,
+		Results: results,
+	}
+
+	var bytes []byte
+	format, mediaType := negotiateResponseFormat(r.Header.Get("Accept"), config.Webhook.ResponseFormat)
+	w.Header().Set("Content-Type", mediaType+"; charset=utf-8")
+	if format == responseFormatXML {
+		bytes, _ = xml.Marshal(data)
+	} else {
+		bytes, _ = json.Marshal(data)
 	}
-	bytes, _ := json.Marshal(data)
 
 	w.WriteHeader(status)
 	_, err := w.Write(bytes)
 
 	if err != nil {
-		log.Errorf("Error writing JSON response: %s", err)
+		baseLogger.Errorf("Error writing %s response: %s", format, err)
+	}
+}
+
+// bodyTooLargeError is returned when a request body exceeds the maximum size
+type bodyTooLargeError struct {
+	maxSize int64
+}
+
+func (e *bodyTooLargeError) Error() string {
+	return fmt.Sprintf("request body exceeds the maximum size of %d bytes", e.maxSize)
+}
+
+// limitRequestBody replaces the request body with a buffered copy of at most maxSize bytes, returning a
+// bodyTooLargeError for larger bodies, without reading more than maxSize bytes of them
+func limitRequestBody(r *http.Request, maxSize int64) error {
+	if maxSize <= 0 {
+		return nil
+	}
+	if r.ContentLength > maxSize {
+		return &bodyTooLargeError{maxSize: maxSize}
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxSize+1))
+	r.Body.Close()
+	if err != nil {
+		return err
+	}
+	if int64(len(body)) > maxSize {
+		return &bodyTooLargeError{maxSize: maxSize}
 	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return nil
 }
 
 func readRequestBody(r *http.Request) (template.Data, error) {
+	message, err := readWebhookMessage(r)
+	return message.Data, err
+}
 
-	// Do not forget to close the body at the end
-	defer r.Body.Close()
+// webhookMessage is the Alertmanager webhook message, the Data template with the Alertmanager group key
+type webhookMessage struct {
+	GroupKey alertmanagerGroupKey `json:"groupKey"`
+	template.Data
 
-	// Extract data from the body in the Data template provided by AlertManager
-	data := template.Data{}
-	err := json.NewDecoder(r.Body).Decode(&data)
+	// payloadHash is the SHA-256 of the request body of the message
+	payloadHash [sha256.Size]byte
+}
+
+// alertmanagerGroupKey is the group key of the webhook message, a string, or a number for the older Alertmanager
+// versions
+type alertmanagerGroupKey string
 
-	return data, err
+func (k *alertmanagerGroupKey) UnmarshalJSON(data []byte) error {
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err == nil {
+		*k = alertmanagerGroupKey(number)
+		return nil
+	}
+	var key string
+	if err := json.Unmarshal(data, &key); err != nil {
+		return err
+	}
+	*k = alertmanagerGroupKey(key)
+	return nil
 }
 
-func loadConfigContent(configData []byte) (Config, error) {
-	config = Config{}
-	var err error
+func readWebhookMessage(r *http.Request) (webhookMessage, error) {
+
+	// Do not forget to close the body at the end
+	defer r.Body.Close()
 
-	err = yaml.Unmarshal([]byte(configData), &config)
+	// Extract data from the body in the Data template provided by AlertManager
+	message := webhookMessage{}
+	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		return config, err
+		return message, err
+	}
+	if err := json.NewDecoder(bytes.NewReader(body)).Decode(&message); err != nil {
+		return message, describeDecodeError(err)
+	}
+	message.payloadHash = sha256.Sum256(body)
+	if err := validateNotification(message.Data); err != nil {
+		return message, err
 	}
 
-	loadEnvVars(&config)
+	// Older Alertmanager payloads do not include the alert fingerprint
+	for i, alert := range message.Alerts {
+		message.Alerts[i].Fingerprint = alertFingerprint(alert)
+	}
+	return message, nil
+}
 
-	err = config.validate()
+// parseConfig parses and validates the config, without loading it
+func parseConfig(configData []byte) (Config, error) {
+	c := Config{}
+	configData, err := expandEnvVars(configData)
 	if err != nil {
-		return config, err
+		return c, err
+	}
+	err = yaml.Unmarshal([]byte(configData), &c)
+	if err != nil {
+		return c, err
 	}
 
-	// Load internal state from config
-	noUpdateStates = make(map[json.Number]bool, len(config.Workflow.NoUpdateStates))
-	for _, s := range config.Workflow.NoUpdateStates {
-		noUpdateStates[s] = true
+	// The environment variables override the credential files
+	if err := loadCredentialFiles(&c); err != nil {
+		return c, err
+	}
+	if err := loadEnvVars(&c); err != nil {
+		return c, err
 	}
 
-	// Load internal incidents update fields from config
-	incidentUpdateFields = make(map[string]bool, len(config.Workflow.IncidentUpdateFields))
-	for _, f := range config.Workflow.IncidentUpdateFields {
-		incidentUpdateFields[f] = true
+	return c, c.validate()
+}
+
+// loadConfigContent parses the config and publishes its snapshot, keeping the current ServiceNow clients
+func loadConfigContent(configData []byte) (Config, error) {
+	c, err := parseConfig(configData)
+	if err != nil {
+		return c, err
 	}
-	log.Info("ServiceNow config loaded")
-	return config, nil
+	snapshotMutex.Lock()
+	defer snapshotMutex.Unlock()
+	currentSnapshot.Store(newConfigSnapshot(c, newConfigStatus(configData, time.Now()), currentConfigSnapshot()))
+	baseLogger.Info("ServiceNow config loaded")
+	return c, nil
 }
 
 func loadConfig(configFile string) (Config, error) {
 	// Load the config from the file
-	configData, err := ioutil.ReadFile(configFile)
+	configData, err := readConfigFile(configFile)
 	if err != nil {
 		return Config{}, err
 	}
@@ -272,171 +841,474 @@ func loadConfig(configFile string) (Config, error) {
 	return loadConfigContent(configData)
 }
 
-func loadEnvVars(c *Config) {
-	if instanceName, ok := os.LookupEnv("SERVICENOW_INSTANCE_NAME"); ok {
+// loadEnvVars overrides the config with the environment variables, each one also read from the file of its _FILE
+// variable
+func loadEnvVars(c *Config) error {
+	env := &envLookup{}
+	if instanceName, ok := env.lookup("SERVICENOW_INSTANCE_NAME"); ok {
 		(*c).ServiceNow.InstanceName = instanceName
 	}
-	if userName, ok := os.LookupEnv("SERVICENOW_USERNAME"); ok {
+	if apiURL, ok := env.lookup("SERVICENOW_API_URL"); ok {
+		(*c).ServiceNow.APIURL = apiURL
+	}
+	if userName, ok := env.lookup("SERVICENOW_USERNAME"); ok {
 		(*c).ServiceNow.UserName = userName
 	}
-	if password, ok := os.LookupEnv("SERVICENOW_PASSWORD"); ok {
+	if password, ok := env.lookup("SERVICENOW_PASSWORD"); ok {
 		(*c).ServiceNow.Password = password
 	}
-	if incidentField, ok := os.LookupEnv("SERVICENOW_INCIDENT_GROUP_KEY_FIELD"); ok {
+	if clientSecret, ok := env.lookup("SERVICENOW_OAUTH2_CLIENT_SECRET"); ok {
+		(*c).ServiceNow.OAuth2.ClientSecret = clientSecret
+	}
+	if refreshToken, ok := env.lookup("SERVICENOW_OAUTH2_REFRESH_TOKEN"); ok {
+		(*c).ServiceNow.OAuth2.RefreshToken = refreshToken
+	}
+	if proxyURL, ok := env.lookup("SERVICENOW_PROXY_URL"); ok {
+		(*c).ServiceNow.ProxyURL = proxyURL
+	}
+	if bearerToken, ok := env.lookup("WEBHOOK_BEARER_TOKEN"); ok {
+		(*c).Webhook.BearerToken = bearerToken
+	}
+	if signatureSecret, ok := env.lookup("WEBHOOK_SIGNATURE_SECRET"); ok {
+		(*c).Webhook.Signature.Secret = signatureSecret
+	}
+	if incidentField, ok := env.lookup("SERVICENOW_INCIDENT_GROUP_KEY_FIELD"); ok {
 		(*c).Workflow.IncidentGroupKeyField = incidentField
 	}
+	if tableName, ok := env.lookup("SERVICENOW_TABLE_NAME"); ok {
+		(*c).ServiceNow.TableName = tableName
+	}
+	if updateFields, ok := env.lookup("SERVICENOW_INCIDENT_UPDATE_FIELDS"); ok {
+		(*c).Workflow.IncidentUpdateFields = splitEnvList(updateFields)
+	}
+	for field, value := range env.defaultIncidentFields() {
+		if (*c).DefaultIncident == nil {
+			(*c).DefaultIncident = map[string]string{}
+		}
+		(*c).DefaultIncident[field] = value
+	}
+	return env.err()
 }
 
+// loadSnClient builds the ServiceNow clients of the loaded config and publishes them
 func loadSnClient() (ServiceNow, error) {
+	snapshotMutex.Lock()
+	defer snapshotMutex.Unlock()
+	s := currentConfigSnapshot()
+	client, err := newSnClient(s.config.ServiceNow)
+	if err != nil {
+		return client, err
+	}
+	instances, err := newSnClients(s.config)
+	if err != nil {
+		return client, err
+	}
+	currentSnapshot.Store(s.withServiceNow(s.config, client, instances))
+	return client, nil
+}
+
+func newSnClient(c ServiceNowConfig) (ServiceNow, error) {
+	var client *ServiceNowClient
 	var err error
-	serviceNow, err = NewServiceNowClient(config.ServiceNow.InstanceName, config.ServiceNow.UserName, config.ServiceNow.Password)
+	if len(c.APIURL) == 0 && len(c.InstanceName) == 0 {
+		return nil, errors.New("Missing instanceName")
+	}
+	if c.OAuth2.enabled() {
+		client, err = newServiceNowOAuth2Client(c.baseURL(), c.OAuth2)
+	} else {
+		client, err = newServiceNowClient(c.baseURL(), c.UserName, c.Password)
+	}
 	if err != nil {
-		return serviceNow, err
+		return nil, err
 	}
-	return serviceNow, nil
+	client.apiURL = strings.TrimRight(c.APIURL, "/")
+	httpClient, err := newHTTPClient(c)
+	if err != nil {
+		return nil, err
+	}
+	client.client = httpClient
+	if client.oauth2 != nil {
+		client.oauth2.client = httpClient
+	}
+	client.retry = c.Retry
+	if c.CircuitBreaker.enabled() {
+		client.breaker = instanceCircuitBreaker(c.instanceLabel(), c.CircuitBreaker)
+	}
+	client.importSet = c.ImportSet
+	return client, nil
 }
 
-func onAlertGroup(data template.Data) error {
+func onAlertGroup(ctx context.Context, data template.Data) error {
+	config := configFrom(ctx)
+	ctx = withRetryBudget(selectReceiver(ctx, data))
 
-	log.Infof("Received alert group: Status=%s, GroupLabels=%v, CommonLabels=%v, CommonAnnotations=%v",
+	loggerFrom(ctx).Infof("Received alert group: Status=%s, GroupLabels=%v, CommonLabels=%v, CommonAnnotations=%v",
 		data.Status, data.GroupLabels, data.CommonLabels, data.CommonAnnotations)
 
+	if isTestNotification(config.TestNotification, data) {
+		webhookTestNotifications.Inc()
+		loggerFrom(ctx).Infof("Alert group is a test notification, no incident will be created/updated: GroupLabels=%v", data.GroupLabels)
+		return nil
+	}
+
+	if isIgnoredFiringGroup(config.Workflow, data.Status) {
+		webhookIgnoredFiringGroups.Inc()
+		loggerFrom(ctx).Infof("Alert group is firing in resolve_only mode, no incident will be created/updated: GroupLabels=%v", data.GroupLabels)
+		return nil
+	}
+
+	snapshot := configSnapshotFrom(ctx)
+	data, filtered := snapshot.alertFilter.filter(ctx, data)
+	data, receiverFiltered := snapshot.receiverAlertFilters[receiverFrom(ctx)].filter(ctx, data)
+	if (filtered || receiverFiltered) && len(data.Alerts) == 0 {
+		loggerFrom(ctx).Infof("Alert group is dropped by the alert filter, no incident will be created/updated: GroupLabels=%v", data.GroupLabels)
+		return nil
+	}
+
+	data, suppressed := suppressMaintenanceAlerts(ctx, config.MaintenanceWindows, data, time.Now())
+	if suppressed && len(data.Alerts) == 0 {
+		loggerFrom(ctx).Infof("Alert group is suppressed by a maintenance window, no incident will be created/updated: GroupLabels=%v", data.GroupLabels)
+		return nil
+	}
+
+	if snapshot.enricher != nil {
+		snapshot.enricher.enrich(ctx, &data)
+	}
+	if recentAlerts != nil && !isDryRun(ctx) {
+		recentAlerts.record(data, time.Now())
+	}
+
+	groups := routeAlertGroup(ctx, data)
+	if config.Workflow.IncidentPerAlert {
+		groups = splitByAlert(groups)
+	}
+	refs := make([]*incidentRef, len(groups))
+	groupErrs := make([]error, len(groups))
+	forEachConcurrently(len(groups), config.Workflow.groupConcurrency(), func(i int) {
+		groupCtx, ref := withIncidentRef(ctx)
+		groupCtx, s := startSpan(groupCtx, "manage incident", spanKindInternal)
+		s.setAttribute("table", groups[i].tableName)
+		s.setAttribute("alerts", len(groups[i].data.Alerts))
+		groupErrs[i] = onTableAlertGroup(groupCtx, groups[i])
+		s.setAttribute("incident", ref.number)
+		s.finish(groupErrs[i])
+		refs[i] = ref
+	})
+
+	var errs []string
+	var overload *overloadError
+	class := errorClassValidation
+	for i, group := range groups {
+		ref, err := refs[i], groupErrs[i]
+		alertResultsFrom(ctx).add(group, ref, err)
+		recordAlertMappings(ctx, group, ref, err)
+		if err != nil {
+			if len(groups) == 1 {
+				return err
+			}
+			loggerFrom(ctx).Errorf("Error managing incident in table %s for alert group key %s: %v", group.tableName, getGroupKey(ctx, group.data), err)
+			errs = append(errs, fmt.Sprintf("%s: %v", group.tableName, err))
+			class = retriableErrorClass(class, errorClass(err))
+			if e, ok := err.(*overloadError); ok {
+				if overload == nil {
+					overload = &overloadError{}
+				}
+				if e.retryAfter > overload.retryAfter {
+					overload.retryAfter = e.retryAfter
+				}
+			}
+		}
+	}
+	if len(errs) > 0 {
+		message := "Error managing incidents in table(s): " + strings.Join(errs, "; ")
+		if overload != nil {
+			return &overloadError{message: message, retryAfter: overload.retryAfter}
+		}
+		return &alertGroupError{message: message, class: class}
+	}
+	return nil
+}
+
+// onTableAlertGroup manages the incident of the alert group in the table
+func onTableAlertGroup(ctx context.Context, group tableGroup) error {
+	config := configFrom(ctx)
+	ctx = withRouteFields(withInstance(ctx, group.instance), group.fields)
+	tableName, data := group.tableName, group.data
+	logger := loggerFrom(ctx).With("table", tableName)
+	if len(data.Alerts) == 1 {
+		logger = logger.With("fingerprint", alertFingerprint(data.Alerts[0]))
+	}
+	ctx = withLogger(ctx, logger)
+	if config.eventTarget() {
+		return sendEvents(ctx, data)
+	}
+	if !group.dedup && data.Status == "firing" {
+		loggerFrom(ctx).Infof("Deduplication is disabled for firing alert group key: %s, a new incident will be created", getGroupKey(ctx, data))
+		return onUndedupedFiringGroup(ctx, tableName, data)
+	}
+
+	unlock := incidentLocks.lock(instanceKey(ctx, dedupKey(ctx, tableName, getGroupKey(ctx, data))))
+	defer unlock()
+
 	getParams := map[string]string{
-		config.Workflow.IncidentGroupKeyField: getGroupKey(data),
+		groupKeyField(ctx, tableName): getGroupKey(ctx, data),
 	}
 
-	existingIncidents, err := serviceNow.GetIncidents(config.ServiceNow.TableName,getParams)
+	existingIncidents, err := serviceNowFrom(ctx).GetIncidents(ctx, tableName, getParams)
 	if err != nil {
 		serviceNowError.Inc()
 		return err
 	}
-	log.Infof("Found %v existing incident(s) for alert group key: %s.", len(existingIncidents), getGroupKey(data))
-
-	updatableIncidents := filterUpdatableIncidents(existingIncidents)
-	log.Infof("Found %v updatable incident(s) for alert group key: %s.", len(updatableIncidents), getGroupKey(data))
+	loggerFrom(ctx).Infof("Found %v existing incident(s) for alert group key: %s.", len(existingIncidents), getGroupKey(ctx, data))
 
-	var updatableIncident Incident
-	if len(updatableIncidents) > 0 {
-		updatableIncident = updatableIncidents[0]
+	updatableIncidents := filterUpdatableIncidents(ctx, tableName, existingIncidents)
+	loggerFrom(ctx).Infof("Found %v updatable incident(s) for alert group key: %s.", len(updatableIncidents), getGroupKey(ctx, data))
 
-		if len(updatableIncidents) > 1 {
-			log.Warnf("As multiple updable incidents were found for alert group key: %s, first one will be used: %s", getGroupKey(data), updatableIncident.GetNumber())
-		}
+	updatableIncident := selectUpdatableIncident(ctx, tableName, updatableIncidents, getGroupKey(ctx, data))
+	if updatableIncident != nil {
+		ctx = withLogger(ctx, loggerFrom(ctx).With("incident", updatableIncident.GetNumber()))
 	}
 
 	if data.Status == "firing" {
-		return onFiringGroup(data, updatableIncident)
+		return onFiringGroup(ctx, tableName, data, updatableIncident, existingIncidents)
 	} else if data.Status == "resolved" {
-		return onResolvedGroup(data, updatableIncident)
+		return onResolvedGroup(ctx, tableName, data, updatableIncident)
 	} else {
-		log.Errorf("Unknown alert group status: %s", data.Status)
+		loggerFrom(ctx).Errorf("Unknown alert group status: %s", data.Status)
 	}
 
 	return nil
 }
 
-func onFiringGroup(data template.Data, updatableIncident Incident) error {
-	incidentCreateParam, err := alertGroupToIncident(data)
+func onFiringGroup(ctx context.Context, tableName string, data template.Data, updatableIncident Incident, existingIncidents []Incident) error {
+	ctx, overflows := withFieldOverflows(ctx)
+	incidentCreateParam, err := alertGroupToIncident(ctx, tableName, data)
 	if err != nil {
 		return err
 	}
+	applyCILookup(ctx, incidentCreateParam, data)
+	applyImpactAnalysis(ctx, incidentCreateParam)
 
-	incidentUpdateParam := filterForUpdate(incidentCreateParam)
+	incidentUpdateParam := filterForUpdate(ctx, tableName, incidentCreateParam)
+	key := instanceKey(ctx, dedupKey(ctx, tableName, getGroupKey(ctx, data)))
+	applyRequestID(ctx, incidentCreateParam, time.Now())
+	applyRequestID(ctx, incidentUpdateParam, time.Now())
+	applyAlertmanagerGroupKey(ctx, incidentCreateParam)
+	applyAlertmanagerGroupKey(ctx, incidentUpdateParam)
 
 	if updatableIncident == nil {
-		log.Infof("Found no updatable incident for firing alert group key: %s", getGroupKey(data))
-		if _, err := serviceNow.CreateIncident(config.ServiceNow.TableName, incidentCreateParam); err != nil {
+		loggerFrom(ctx).Infof("Found no updatable incident for firing alert group key: %s", getGroupKey(ctx, data))
+		if previous := previousIncident(existingIncidents); onRefiringGroup(ctx, tableName, previous, incidentCreateParam, incidentUpdateParam) {
+			trackIncident(ctx, tableName, key, data, previous)
+			return nil
+		}
+		applyWatchList(ctx, incidentCreateParam, data)
+		applyAssignedTo(ctx, incidentCreateParam, data)
+		applyRelatedAlerts(ctx, incidentCreateParam, data)
+		deferredGroup := applyAssignmentGroup(ctx, incidentCreateParam, data)
+		applyOnCallAssignee(ctx, incidentCreateParam)
+		applyKnowledge(ctx, incidentCreateParam, data, time.Now())
+		incident, err := createDedupIncident(ctx, tableName, key, incidentCreateParam, incidentUpdateParam, existingIncidents)
+		if err != nil {
 			serviceNowError.Inc()
 			return err
 		}
+		recordUpdate(ctx, key, time.Now())
+		recordIncident(ctx, incident)
+		deferAssignmentGroup(ctx, tableName, incident, deferredGroup)
+		attachAlertGroup(ctx, tableName, incident, data)
+		attachFieldOverflows(ctx, tableName, incident, overflows)
+		syncChildRecords(ctx, incident, data)
+		trackIncident(ctx, tableName, key, data, incident)
 	} else {
-		log.Infof("Found updatable incident (%s), with state %s, for firing alert group key: %s", updatableIncident.GetNumber(), updatableIncident.GetState(), getGroupKey(data))
-		if _, err := serviceNow.UpdateIncident(config.ServiceNow.TableName, incidentUpdateParam, updatableIncident.GetSysID()); err != nil {
+		loggerFrom(ctx).Infof("Found updatable incident (%s), with state %s, for firing alert group key: %s", updatableIncident.GetNumber(), updatableIncident.GetState(), getGroupKey(ctx, data))
+		now := time.Now()
+		if throttleUpdate(ctx, key, now) {
+			recordIncident(ctx, updatableIncident)
+			return nil
+		}
+		applyRepeatWorkNote(ctx, incidentUpdateParam, key, data, now)
+		applyEscalation(ctx, incidentUpdateParam, key, data, now)
+		applyCoalescedNotifications(ctx, incidentUpdateParam, key, now)
+		applyStillFiringState(ctx, tableName, incidentUpdateParam)
+		state, err := transitionIncident(ctx, tableName, updatableIncident, incidentUpdateParam)
+		if err != nil {
 			serviceNowError.Inc()
 			return err
 		}
+		_, err = serviceNowFrom(ctx).UpdateIncident(ctx, tableName, incidentUpdateParam, updatableIncident.GetSysID())
+		countIncidentOperation(ctx, tableName, incidentUpdated, err)
+		if err != nil {
+			serviceNowError.Inc()
+			return intermediateStateError(err, updatableIncident, state)
+		}
+		recordUpdate(ctx, key, now)
+		recordIncident(ctx, updatableIncident)
+		syncChildRecords(ctx, updatableIncident, data)
+		trackIncident(ctx, tableName, key, data, updatableIncident)
 	}
 	return nil
 }
 
-func onResolvedGroup(data template.Data, updatableIncident Incident) error {
-	incidentCreateParam, err := alertGroupToIncident(data)
+// onUndedupedFiringGroup always creates a new incident for the firing alert group, without looking for an existing one
+func onUndedupedFiringGroup(ctx context.Context, tableName string, data template.Data) error {
+	ctx, overflows := withFieldOverflows(ctx)
+	incidentCreateParam, err := alertGroupToIncident(ctx, tableName, data)
+	if err != nil {
+		return err
+	}
+	applyCILookup(ctx, incidentCreateParam, data)
+	applyImpactAnalysis(ctx, incidentCreateParam)
+	applyWatchList(ctx, incidentCreateParam, data)
+	applyAssignedTo(ctx, incidentCreateParam, data)
+	applyRelatedAlerts(ctx, incidentCreateParam, data)
+	applyRequestID(ctx, incidentCreateParam, time.Now())
+	applyAlertmanagerGroupKey(ctx, incidentCreateParam)
+	deferredGroup := applyAssignmentGroup(ctx, incidentCreateParam, data)
+	applyOnCallAssignee(ctx, incidentCreateParam)
+	applyKnowledge(ctx, incidentCreateParam, data, time.Now())
+
+	incident, err := createIncident(ctx, tableName, incidentCreateParam)
+	countIncidentOperation(ctx, tableName, incidentCreated, err)
+	if err != nil {
+		serviceNowError.Inc()
+		return err
+	}
+	recordIncident(ctx, incident)
+	deferAssignmentGroup(ctx, tableName, incident, deferredGroup)
+	attachAlertGroup(ctx, tableName, incident, data)
+	attachFieldOverflows(ctx, tableName, incident, overflows)
+	return nil
+}
+
+func onResolvedGroup(ctx context.Context, tableName string, data template.Data, updatableIncident Incident) error {
+	incidentCreateParam, err := alertGroupToIncident(ctx, tableName, data)
 	if err != nil {
 		return err
 	}
 
-	incidentUpdateParam := filterForUpdate(incidentCreateParam)
+	incidentUpdateParam := filterForUpdate(ctx, tableName, incidentCreateParam)
+	applyResolution(ctx, tableName, incidentUpdateParam, data)
+	applyRequestID(ctx, incidentUpdateParam, time.Now())
+	applyAlertmanagerGroupKey(ctx, incidentUpdateParam)
+	resetNotifications(ctx, instanceKey(ctx, dedupKey(ctx, tableName, getGroupKey(ctx, data))))
+	resetEscalation(ctx, instanceKey(ctx, dedupKey(ctx, tableName, getGroupKey(ctx, data))))
+	resetUpdateThrottle(ctx, instanceKey(ctx, dedupKey(ctx, tableName, getGroupKey(ctx, data))))
+	untrackIncident(ctx, instanceKey(ctx, dedupKey(ctx, tableName, getGroupKey(ctx, data))))
 
 	if updatableIncident == nil {
-		log.Infof("Found no updatable incident for resolved alert group key: %s. No incident will be created/updated.", getGroupKey(data))
+		loggerFrom(ctx).Infof("Found no updatable incident for resolved alert group key: %s. No incident will be created/updated.", getGroupKey(ctx, data))
 	} else {
-		log.Infof("Found updatable incident (%s), with state %s, for resolved alert group key: %s", updatableIncident.GetNumber(), updatableIncident.GetState(), getGroupKey(data))
-		if _, err := serviceNow.UpdateIncident(config.ServiceNow.TableName, incidentUpdateParam, updatableIncident.GetSysID()); err != nil {
+		loggerFrom(ctx).Infof("Found updatable incident (%s), with state %s, for resolved alert group key: %s", updatableIncident.GetNumber(), updatableIncident.GetState(), getGroupKey(ctx, data))
+		state, err := transitionIncident(ctx, tableName, updatableIncident, incidentUpdateParam)
+		if err != nil {
 			serviceNowError.Inc()
 			return err
 		}
+		resolveCtx := withAuditOperation(ctx, incidentResolved)
+		_, err = serviceNowFrom(ctx).UpdateIncident(resolveCtx, tableName, incidentUpdateParam, updatableIncident.GetSysID())
+		countIncidentOperation(ctx, tableName, incidentResolved, err)
+		if err != nil {
+			serviceNowError.Inc()
+			return intermediateStateError(err, updatableIncident, state)
+		}
+		recordIncident(ctx, updatableIncident)
+		syncChildRecords(ctx, updatableIncident, data)
 	}
 	return nil
 }
 
-func alertGroupToIncident(data template.Data) (Incident, error) {
+func alertGroupToIncident(ctx context.Context, tableName string, data template.Data) (Incident, error) {
+	config := configFrom(ctx)
 
 	incident := Incident{
-		"caller_id":                           config.ServiceNow.UserName,
-		config.Workflow.IncidentGroupKeyField: getGroupKey(data),
-	}
-
-	for k, v := range config.DefaultIncident {
-		incident[k] = v
+		callerIDField:                 callerID(ctx, data),
+		groupKeyField(ctx, tableName): getGroupKey(ctx, data),
 	}
 
-	applyIncidentTemplate(incident, data)
+	_, s := startSpan(ctx, "render incident", spanKindInternal)
+	configSnapshotFrom(ctx).mapping.apply(ctx, tableName, incident, data)
+	applyLinks(ctx, config.Links, incident, data)
+	applyOpenedAt(config.Timestamps, incident, data)
+	s.finish(nil)
 	err := validateIncident(incident)
 	if err != nil {
 		webhookIncidentValidationError.Inc()
-		log.Error(err)
+		loggerFrom(ctx).Error(err)
 	}
+	if err := applyRequiredFields(ctx, config.RequiredFields, incident, data); err != nil {
+		webhookIncidentValidationError.Inc()
+		return nil, err
+	}
+	applyFieldSanitization(config.FieldSanitization, incident)
+	overflows, err := applyFieldLimits(config.FieldLimits, incident)
+	if err != nil {
+		webhookIncidentValidationError.Inc()
+		return nil, err
+	}
+	recordFieldOverflows(ctx, overflows)
 	return incident, nil
 }
 
-func filterForUpdate(incident Incident) Incident {
+func filterForUpdate(ctx context.Context, tableName string, incident Incident) Incident {
+	updateFields := tableUpdateFields(ctx, tableName)
 	incidentUpdate := Incident{}
 	for field, value := range incident {
-		if incidentUpdateFields[field] {
+		if updateFields[field] {
 			incidentUpdate[field] = value
 		}
 	}
 	return incidentUpdate
 }
 
-func filterUpdatableIncidents(incidents []Incident) []Incident {
+func filterUpdatableIncidents(ctx context.Context, tableName string, incidents []Incident) []Incident {
+	states := tableNoUpdateStates(ctx, tableName)
 	var updatableIncidents []Incident
 	for _, incident := range incidents {
-		if !noUpdateStates[incident.GetState()] {
+		if !states[incident.GetState()] {
 			updatableIncidents = append(updatableIncidents, incident)
 		}
 	}
 	return updatableIncidents
 }
 
-func getGroupKey(data template.Data) string {
-	hash := md5.Sum([]byte(fmt.Sprintf("%v", data.GroupLabels.SortedPairs())))
+// getGroupKey returns the correlation key of the alert group, a hash of its group labels. With
+// workflow.incident_per_alert, the alert group of a single alert is correlated by the fingerprint of the alert instead,
+// unless the labels of the correlation key are configured.
+func getGroupKey(ctx context.Context, data template.Data) string {
+	config := configFrom(ctx)
+	c := config.Workflow
+	if c.IncidentPerAlert && len(data.Alerts) == 1 && !c.CorrelationKey.configured() {
+		return alertFingerprint(data.Alerts[0])
+	}
+	return hashLabels(c.CorrelationKey.filter(data.GroupLabels))
+}
+
+// alertFingerprint returns the fingerprint of the alert provided by Alertmanager, or a hash of its labels when missing
+func alertFingerprint(alert template.Alert) string {
+	if len(alert.Fingerprint) > 0 {
+		return alert.Fingerprint
+	}
+	return hashLabels(alert.Labels)
+}
+
+func hashLabels(labels template.KV) string {
+	hash := md5.Sum([]byte(fmt.Sprintf("%v", labels.SortedPairs())))
 	return fmt.Sprintf("%x", hash)
 }
 
-func applyIncidentTemplate(incident Incident, data template.Data) {
+// applyIncidentTemplate executes the templates of all the incident fields, compiling them on the fly
+func applyIncidentTemplate(ctx context.Context, incident Incident, data template.Data) {
+	config := configFrom(ctx)
+	fields := make(map[string]string, len(incident))
 	for key, val := range incident {
-		var err error
-		incident[key], err = applyTemplate(key, val.(string), data)
-		if err != nil {
-			webhookIncidentTemplateError.Inc()
-			log.Errorf("Error parsing default incident template for key:%s value:%s, error:%v", key, val.(string), err)
-		}
+		fields[key] = val.(string)
 	}
+	executeFieldTemplates(ctx, compileFieldTemplates(fields), incident, newTemplateContext(config.InstanceList, data))
 }
 
-func applyTemplate(name string, text string, data template.Data) (string, error) {
-	tmpl, err := tmpltext.New(name).Parse(text)
+func applyTemplate(name string, text string, data interface{}) (string, error) {
+	tmpl, err := newTextTemplate(name).Parse(text)
 	if err != nil {
 		return "", err
 	}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
@@ -18,21 +19,68 @@ type MockedSnClient struct {
 	mock.Mock
 }
 
-func (mock *MockedSnClient) CreateIncident(tableName string, incidentParam Incident) (Incident, error) {
+func (mock *MockedSnClient) CreateIncident(ctx context.Context, tableName string, incidentParam Incident) (Incident, error) {
 	args := mock.Called(tableName, incidentParam)
 	return args.Get(0).(Incident), args.Error(1)
 }
 
-func (mock *MockedSnClient) GetIncidents(tableName string, params map[string]string) ([]Incident, error) {
+func (mock *MockedSnClient) GetIncidents(ctx context.Context, tableName string, params map[string]string) ([]Incident, error) {
 	args := mock.Called(tableName, params)
 	return args.Get(0).([]Incident), args.Error(1)
 }
 
-func (mock *MockedSnClient) UpdateIncident(tableName string, incidentParam Incident, sysID string) (Incident, error) {
+func (mock *MockedSnClient) UpdateIncident(ctx context.Context, tableName string, incidentParam Incident, sysID string) (Incident, error) {
 	args := mock.Called(tableName, incidentParam, sysID)
 	return args.Get(0).(Incident), args.Error(1)
 }
 
+func (mock *MockedSnClient) PatchIncident(ctx context.Context, tableName string, incidentParam Incident, sysID string) (Incident, error) {
+	args := mock.Called(tableName, incidentParam, sysID)
+	return args.Get(0).(Incident), args.Error(1)
+}
+
+func (mock *MockedSnClient) DeleteIncident(ctx context.Context, tableName string, sysID string) error {
+	args := mock.Called(tableName, sysID)
+	return args.Error(0)
+}
+
+func (mock *MockedSnClient) AttachFile(ctx context.Context, tableName string, sysID string, fileName string, contentType string, content []byte) error {
+	args := mock.Called(tableName, sysID, fileName, contentType, content)
+	return args.Error(0)
+}
+
+func (mock *MockedSnClient) OnCallUsers(ctx context.Context, groupSysID string) ([]string, error) {
+	args := mock.Called(groupSysID)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+// useServiceNow publishes a copy of the current config snapshot whose default ServiceNow instance uses the client
+func useServiceNow(client ServiceNow) {
+	s := currentConfigSnapshot()
+	currentSnapshot.Store(s.withServiceNow(s.config, client, s.serviceNowInstances))
+}
+
+// useServiceNowInstances publishes a copy of the current config snapshot whose named ServiceNow instances use the
+// clients
+func useServiceNowInstances(instances map[string]ServiceNow) {
+	s := currentConfigSnapshot()
+	currentSnapshot.Store(s.withServiceNow(s.config, s.serviceNow, instances))
+}
+
+// useIncidentUpdateFields publishes a snapshot of the current config updating the incidents with the fields
+func useIncidentUpdateFields(fields ...string) {
+	s := currentConfigSnapshot()
+	c := s.config
+	c.Workflow.IncidentUpdateFields = fields
+	currentSnapshot.Store(newConfigSnapshot(c, s.status, s))
+}
+
+// applyConfig publishes a new snapshot of the current config once a test modified it, deriving its state again
+func applyConfig() {
+	s := currentConfigSnapshot()
+	currentSnapshot.Store(newConfigSnapshot(s.config, s.status, s))
+}
+
 func TestLoadSnClient_OK(t *testing.T) {
 	loadConfig("config/servicenow_example.yml")
 	_, err := loadSnClient()
@@ -43,9 +91,9 @@ func TestLoadSnClient_OK(t *testing.T) {
 
 func TestWebhookHandler_Firing_DoNotExists_OK(t *testing.T) {
 	loadConfig("config/servicenow_example.yml")
-	incidentUpdateFields = map[string]bool{}
+	useIncidentUpdateFields()
 	snClientMock := new(MockedSnClient)
-	serviceNow = snClientMock
+	useServiceNow(snClientMock)
 	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
 	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
 		incident := args.Get(1).(Incident)
@@ -75,7 +123,7 @@ func TestWebhookHandler_Firing_DoNotExists_OK(t *testing.T) {
 		t.Errorf("Wrong status code: got %v, want %v", status, http.StatusOK)
 	}
 
-	want := `{"Status":200,"Message":"Success"}`
+	want := `{"Status":200,"Message":"Success","Results":[{"Fingerprint":"c2e24d8bdb72ec6886fb79c75f1f3cfe","Table":"incident","Status":"success"}]}`
 	if rr.Body.String() != want {
 		t.Errorf("Unexpected body: got %v, want %v", rr.Body.String(), want)
 	}
@@ -84,7 +132,7 @@ func TestWebhookHandler_Firing_DoNotExists_OK(t *testing.T) {
 func TestWebhookHandler_Firing_Exists_Create_OK(t *testing.T) {
 	loadConfig("config/servicenow_example.yml")
 	snClientMock := new(MockedSnClient)
-	serviceNow = snClientMock
+	useServiceNow(snClientMock)
 	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{Incident{"state": "6", "number": "INC42", "sys_id": "42"}}, nil)
 	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{}, nil)
 	snClientMock.On("UpdateIncident", mock.Anything, mock.Anything, mock.Anything).Return(Incident{}, errors.New("Update should not be called"))
@@ -109,7 +157,7 @@ func TestWebhookHandler_Firing_Exists_Create_OK(t *testing.T) {
 		t.Errorf("Wrong status code: got %v, want %v", status, http.StatusOK)
 	}
 
-	want := `{"Status":200,"Message":"Success"}`
+	want := `{"Status":200,"Message":"Success","Results":[{"Fingerprint":"c2e24d8bdb72ec6886fb79c75f1f3cfe","Table":"incident","Status":"success"}]}`
 	if rr.Body.String() != want {
 		t.Errorf("Unexpected body: got %v, want %v", rr.Body.String(), want)
 	}
@@ -117,11 +165,9 @@ func TestWebhookHandler_Firing_Exists_Create_OK(t *testing.T) {
 
 func TestWebhookHandler_Firing_Exists_Update_OK(t *testing.T) {
 	loadConfig("config/servicenow_example.yml")
-	incidentUpdateFields = map[string]bool{
-		"comments": true,
-	}
+	useIncidentUpdateFields("comments")
 	snClientMock := new(MockedSnClient)
-	serviceNow = snClientMock
+	useServiceNow(snClientMock)
 	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{Incident{"state": "1", "number": "INC42", "sys_id": "42"}}, nil)
 	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{}, errors.New("Create should not be called"))
 	snClientMock.On("UpdateIncident", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
@@ -151,7 +197,7 @@ func TestWebhookHandler_Firing_Exists_Update_OK(t *testing.T) {
 		t.Errorf("Wrong status code: got %v, want %v", status, http.StatusOK)
 	}
 
-	want := `{"Status":200,"Message":"Success"}`
+	want := `{"Status":200,"Message":"Success","Results":[{"Fingerprint":"c2e24d8bdb72ec6886fb79c75f1f3cfe","Table":"incident","Status":"success","Incident":"INC42","SysID":"42"}]}`
 	if rr.Body.String() != want {
 		t.Errorf("Unexpected body: got %v, want %v", rr.Body.String(), want)
 	}
@@ -160,7 +206,7 @@ func TestWebhookHandler_Firing_Exists_Update_OK(t *testing.T) {
 func TestWebhookHandler_Resolved_DoNotExists_OK(t *testing.T) {
 	loadConfig("config/servicenow_example.yml")
 	snClientMock := new(MockedSnClient)
-	serviceNow = snClientMock
+	useServiceNow(snClientMock)
 	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
 	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{}, errors.New("Create should not be called"))
 	snClientMock.On("UpdateIncident", mock.Anything, mock.Anything, mock.Anything).Return(Incident{}, errors.New("Update should not be called"))
@@ -185,7 +231,7 @@ func TestWebhookHandler_Resolved_DoNotExists_OK(t *testing.T) {
 		t.Errorf("Wrong status code: got %v, want %v", status, http.StatusOK)
 	}
 
-	want := `{"Status":200,"Message":"Success"}`
+	want := `{"Status":200,"Message":"Success","Results":[{"Fingerprint":"c2e24d8bdb72ec6886fb79c75f1f3cfe","Table":"incident","Status":"success"}]}`
 	if rr.Body.String() != want {
 		t.Errorf("Unexpected body: got %v, want %v", rr.Body.String(), want)
 	}
@@ -194,7 +240,7 @@ func TestWebhookHandler_Resolved_DoNotExists_OK(t *testing.T) {
 func TestWebhookHandler_Resolved_Exists_OK(t *testing.T) {
 	loadConfig("config/servicenow_example.yml")
 	snClientMock := new(MockedSnClient)
-	serviceNow = snClientMock
+	useServiceNow(snClientMock)
 	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{Incident{"state": "7", "number": "INC42", "sys_id": "42"}}, nil)
 	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{}, errors.New("Create should not be called"))
 	snClientMock.On("UpdateIncident", mock.Anything, mock.Anything, mock.Anything).Return(Incident{}, nil)
@@ -219,7 +265,7 @@ func TestWebhookHandler_Resolved_Exists_OK(t *testing.T) {
 		t.Errorf("Wrong status code: got %v, want %v", status, http.StatusOK)
 	}
 
-	want := `{"Status":200,"Message":"Success"}`
+	want := `{"Status":200,"Message":"Success","Results":[{"Fingerprint":"c2e24d8bdb72ec6886fb79c75f1f3cfe","Table":"incident","Status":"success"}]}`
 	if rr.Body.String() != want {
 		t.Errorf("Unexpected body: got %v, want %v", rr.Body.String(), want)
 	}
@@ -242,16 +288,51 @@ func TestWebhookHandler_BadRequest(t *testing.T) {
 		t.Errorf("Wrong status code: got %v, want %v", status, http.StatusBadRequest)
 	}
 
-	want := `{"Status":400,"Message":"EOF"}`
+	want := `{"Status":400,"Message":"invalid Alertmanager notification: the request body is empty"}`
 	if rr.Body.String() != want {
 		t.Errorf("Unexpected body: got %v, want %v", rr.Body.String(), want)
 	}
 }
 
+func TestWebhookHandler_RequestEntityTooLarge(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	useServiceNow(new(MockedSnClient))
+	size := *maxRequestBodySize
+	defer func() { *maxRequestBodySize = size }()
+	*maxRequestBodySize = 64
+
+	data, err := ioutil.ReadFile("test/alertmanager_firing.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, req := range []*http.Request{
+		httptest.NewRequest("POST", "/webhook", bytes.NewReader(data)),
+		// Without Content-Length
+		httptest.NewRequest("POST", "/webhook", ioutil.NopCloser(bytes.NewReader(data))),
+	} {
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(webhook).ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusRequestEntityTooLarge {
+			t.Errorf("Wrong status code: got %v, want %v", status, http.StatusRequestEntityTooLarge)
+		}
+	}
+}
+
+func TestLimitRequestBody(t *testing.T) {
+	req := httptest.NewRequest("POST", "/webhook", ioutil.NopCloser(bytes.NewReader([]byte("0123456789"))))
+	if err := limitRequestBody(req, 10); err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	if string(body) != "0123456789" {
+		t.Errorf("Unexpected body: %q", body)
+	}
+}
+
 func TestWebhookHandler_InternalServerError(t *testing.T) {
 	loadConfig("config/servicenow_example.yml")
 	snClientMock := new(MockedSnClient)
-	serviceNow = snClientMock
+	useServiceNow(snClientMock)
 	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
 	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{}, errors.New("Error"))
 
@@ -276,12 +357,47 @@ func TestWebhookHandler_InternalServerError(t *testing.T) {
 	}
 
 	// Check the response body
-	want := `{"Status":500,"Message":"Error"}`
+	want := `{"Status":500,"Message":"Error","Results":[{"Fingerprint":"c2e24d8bdb72ec6886fb79c75f1f3cfe","Table":"incident","Status":"failed","Error":"Error"}]}`
 	if rr.Body.String() != want {
 		t.Errorf("Unexpected body: got %v, want %v", rr.Body.String(), want)
 	}
 }
 
+func TestReadRequestBody_Fingerprint(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    string
+	}{
+		{name: "provided", payload: "test/alertmanager_firing_fingerprint.json", want: "6c6f5e1a2b3d4e5f"},
+		{name: "computed", payload: "test/alertmanager_firing.json", want: hashLabels(template.KV{
+			"alertname":  "something_happened",
+			"env":        "prod",
+			"instance":   "server01.int:9100",
+			"job":        "node",
+			"service":    "prometheus_bot",
+			"severity":   "warning",
+			"supervisor": "runit",
+		})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := ioutil.ReadFile(tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := readRequestBody(httptest.NewRequest("POST", "/webhook", bytes.NewReader(payload)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data.Alerts[0].Fingerprint != tt.want {
+				t.Errorf("Unexpected fingerprint: got %v, want %v", data.Alerts[0].Fingerprint, tt.want)
+			}
+		})
+	}
+}
+
 func TestApplyTemplate_emptyText(t *testing.T) {
 	data := template.Data{}
 	text := ""
@@ -323,7 +439,7 @@ func TestApplyIncidentTemplate_Range(t *testing.T) {
 	incident := Incident{
 		"description": "{{ range $key, $val := .CommonAnnotations}}{{ $key }}:{{ $val }} {{end}}",
 	}
-	applyIncidentTemplate(incident, data)
+	applyIncidentTemplate(context.Background(), incident, data)
 
 	got := incident["description"]
 	want := "error:a warning:b "
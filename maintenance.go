@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const maintenanceTimeLayout = "15:04"
+
+var webhookMaintenanceSuppressedAlerts = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "webhook_maintenance_suppressed_alerts_total",
+		Help: "Total number of firing alerts suppressed by a maintenance window.",
+	},
+	[]string{"window"},
+)
+
+// MaintenanceWindowConfig - Recurring window during which the firing alerts matching all the labels create no incident
+type MaintenanceWindowConfig struct {
+	Name     string            `yaml:"name"`
+	Weekdays []string          `yaml:"weekdays"`
+	Start    string            `yaml:"start_time"`
+	End      string            `yaml:"end_time"`
+	TimeZone string            `yaml:"time_zone"`
+	Match    map[string]string `yaml:"match"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+func validateMaintenanceWindows(c Config, errs *strings.Builder) {
+	for i, window := range c.MaintenanceWindows {
+		if len(window.Name) == 0 {
+			errs.WriteString(fmt.Sprintf("maintenance_windows[%d].name is missing\n", i))
+		}
+		for _, day := range window.Weekdays {
+			if _, ok := weekdays[strings.ToLower(day)]; !ok {
+				errs.WriteString(fmt.Sprintf("maintenance_windows[%d].weekdays %q is not a day of the week\n", i, day))
+			}
+		}
+		if (len(window.Start) == 0) != (len(window.End) == 0) {
+			errs.WriteString(fmt.Sprintf("maintenance_windows[%d] needs both a start_time and an end_time\n", i))
+		}
+		for _, value := range []string{window.Start, window.End} {
+			if _, err := time.Parse(maintenanceTimeLayout, value); len(value) > 0 && err != nil {
+				errs.WriteString(fmt.Sprintf("maintenance_windows[%d] time %q is not formatted as HH:MM\n", i, value))
+			}
+		}
+		if _, err := time.LoadLocation(window.TimeZone); err != nil {
+			errs.WriteString(fmt.Sprintf("maintenance_windows[%d].time_zone is invalid: %v\n", i, err))
+		}
+	}
+}
+
+// minutes returns the minutes since midnight of the HH:MM time, validated with the config
+func minutes(value string) int {
+	t, _ := time.Parse(maintenanceTimeLayout, value)
+	return t.Hour()*60 + t.Minute()
+}
+
+func (c MaintenanceWindowConfig) onWeekday(day time.Weekday) bool {
+	if len(c.Weekdays) == 0 {
+		return true
+	}
+	for _, name := range c.Weekdays {
+		if weekdays[strings.ToLower(name)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// active returns whether the window is in progress at the time. A window whose end time is before its start time ends
+// on the next day, and a window without times lasts the whole day.
+func (c MaintenanceWindowConfig) active(now time.Time) bool {
+	location, err := time.LoadLocation(c.TimeZone)
+	if err != nil {
+		location = time.UTC
+	}
+	now = now.In(location)
+	if len(c.Start) == 0 {
+		return c.onWeekday(now.Weekday())
+	}
+
+	minute, start, end := now.Hour()*60+now.Minute(), minutes(c.Start), minutes(c.End)
+	if start < end {
+		return c.onWeekday(now.Weekday()) && minute >= start && minute < end
+	}
+	return (c.onWeekday(now.Weekday()) && minute >= start) || (c.onWeekday(now.AddDate(0, 0, -1).Weekday()) && minute < end)
+}
+
+func (c MaintenanceWindowConfig) matches(labels template.KV) bool {
+	for name, value := range c.Match {
+		if labels[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// suppressMaintenanceAlerts removes from the alert group the firing alerts of the active maintenance windows, returning
+// whether some were. The resolved alerts are kept, so that the incidents created before a window are still resolved.
+func suppressMaintenanceAlerts(ctx context.Context, windows []MaintenanceWindowConfig, data template.Data, now time.Time) (template.Data, bool) {
+	var active []MaintenanceWindowConfig
+	for _, window := range windows {
+		if window.active(now) {
+			active = append(active, window)
+		}
+	}
+	if len(active) == 0 {
+		return data, false
+	}
+
+	alerts := make(template.Alerts, 0, len(data.Alerts))
+	for _, alert := range data.Alerts {
+		if window, ok := maintenanceWindow(active, alert); ok {
+			webhookMaintenanceSuppressedAlerts.WithLabelValues(window.Name).Inc()
+			loggerFrom(ctx).Infof("Alert %s suppressed by maintenance window %s: Labels=%v", alertFingerprint(alert), window.Name, alert.Labels)
+			continue
+		}
+		alerts = append(alerts, alert)
+	}
+	if len(alerts) == len(data.Alerts) {
+		return data, false
+	}
+	data.Alerts = alerts
+	if len(alerts.Firing()) == 0 {
+		data.Status = "resolved"
+	}
+	return data, true
+}
+
+func maintenanceWindow(windows []MaintenanceWindowConfig, alert template.Alert) (MaintenanceWindowConfig, bool) {
+	if alert.Status != "firing" {
+		return MaintenanceWindowConfig{}, false
+	}
+	for _, window := range windows {
+		if window.matches(alert.Labels) {
+			return window, true
+		}
+	}
+	return MaintenanceWindowConfig{}, false
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMaintenanceWindow_Active(t *testing.T) {
+	// 2026-10-17 is a Saturday
+	saturday := func(hour, minute int) time.Time { return time.Date(2026, 10, 17, hour, minute, 0, 0, time.UTC) }
+	tests := []struct {
+		name   string
+		window MaintenanceWindowConfig
+		now    time.Time
+		want   bool
+	}{
+		{"in range", MaintenanceWindowConfig{Weekdays: []string{"Saturday"}, Start: "02:00", End: "04:00"}, saturday(3, 0), true},
+		{"end excluded", MaintenanceWindowConfig{Weekdays: []string{"saturday"}, Start: "02:00", End: "04:00"}, saturday(4, 0), false},
+		{"other day", MaintenanceWindowConfig{Weekdays: []string{"sunday"}, Start: "02:00", End: "04:00"}, saturday(3, 0), false},
+		{"every day", MaintenanceWindowConfig{Start: "02:00", End: "04:00"}, saturday(2, 0), true},
+		{"whole day", MaintenanceWindowConfig{Weekdays: []string{"saturday"}}, saturday(23, 59), true},
+		{"overnight start", MaintenanceWindowConfig{Weekdays: []string{"saturday"}, Start: "22:00", End: "06:00"}, saturday(23, 0), true},
+		{"overnight next day", MaintenanceWindowConfig{Weekdays: []string{"friday"}, Start: "22:00", End: "06:00"}, saturday(5, 59), true},
+		{"overnight outside", MaintenanceWindowConfig{Weekdays: []string{"saturday"}, Start: "22:00", End: "06:00"}, saturday(5, 0), false},
+		{"time zone", MaintenanceWindowConfig{Start: "02:00", End: "04:00", TimeZone: "Europe/Paris"}, saturday(1, 30), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.window.active(test.now); got != test.want {
+				t.Errorf("Unexpected active window: got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestOnAlertGroup_MaintenanceWindow(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().MaintenanceWindows = []MaintenanceWindowConfig{{Name: "staging", Match: map[string]string{"env": "staging"}}}
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	before := testutil.ToFloat64(webhookMaintenanceSuppressedAlerts.WithLabelValues("staging"))
+
+	data := template.Data{
+		Status:      "firing",
+		GroupLabels: template.KV{"alertname": "InstanceDown"},
+		Alerts: template.Alerts{
+			template.Alert{Status: "firing", Labels: template.KV{"alertname": "InstanceDown", "env": "staging"}},
+			template.Alert{Status: "firing", Labels: template.KV{"alertname": "InstanceDown", "env": "staging", "instance": "web02"}},
+		},
+	}
+	if err := onAlertGroup(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+
+	snClientMock.AssertNotCalled(t, "GetIncidents")
+	if got := testutil.ToFloat64(webhookMaintenanceSuppressedAlerts.WithLabelValues("staging")) - before; got != 2 {
+		t.Errorf("Unexpected suppressed alerts: got %v, want 2", got)
+	}
+}
+
+func TestSuppressMaintenanceAlerts_KeepsOtherAlerts(t *testing.T) {
+	windows := []MaintenanceWindowConfig{{Name: "staging", Match: map[string]string{"env": "staging"}}}
+	data := template.Data{
+		Status: "firing",
+		Alerts: template.Alerts{
+			template.Alert{Status: "firing", Labels: template.KV{"env": "staging"}},
+			template.Alert{Status: "resolved", Labels: template.KV{"env": "staging"}},
+			template.Alert{Status: "firing", Labels: template.KV{"env": "production"}},
+		},
+	}
+	got, suppressed := suppressMaintenanceAlerts(context.Background(), windows, data, time.Now())
+	if !suppressed || len(got.Alerts) != 2 || got.Alerts[0].Status != "resolved" || got.Status != "firing" {
+		t.Errorf("Unexpected alert group: %+v", got)
+	}
+
+	got, _ = suppressMaintenanceAlerts(context.Background(), windows, template.Data{Status: "firing", Alerts: data.Alerts[:2]}, time.Now())
+	if got.Status != "resolved" {
+		t.Errorf("Alert group without firing alert should be resolved: %+v", got)
+	}
+}
+
+func TestValidateMaintenanceWindows(t *testing.T) {
+	var errs strings.Builder
+	validateMaintenanceWindows(Config{MaintenanceWindows: []MaintenanceWindowConfig{
+		{Weekdays: []string{"someday"}, Start: "25:00", TimeZone: "Nowhere/City"},
+	}}, &errs)
+	for _, want := range []string{".name is missing", `"someday" is not a day`, "both a start_time and an end_time", `"25:00" is not formatted`, ".time_zone is invalid"} {
+		if !strings.Contains(errs.String(), want) {
+			t.Errorf("Validation errors %q should contain %q", errs.String(), want)
+		}
+	}
+}
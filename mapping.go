@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	tmpltext "text/template"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+// fieldTemplate is the compiled template of an incident field. err holds the template parsing error, if any.
+type fieldTemplate struct {
+	field string
+	text  string
+	tmpl  *tmpltext.Template
+	err   error
+}
+
+// incidentMapping is an immutable snapshot of the alert to incident mapping: the compiled incident field templates of each table,
+// of each webhook receiver and of each named incident template with their selection rules, the field mappings, the prefix of the labels mapped to fields, the severity mapping and the compiled event field templates.
+// It is swapped as a whole on reload with the config snapshot, so that an alert group is always mapped with a consistent set of templates.
+type incidentMapping struct {
+	defaultFields  []fieldTemplate
+	tableFields    map[string][]fieldTemplate
+	namedFields    map[string][]fieldTemplate
+	templateRules  []IncidentTemplateRuleConfig
+	fieldMappings  []fieldMapping
+	tableMappings  map[string][]fieldMapping
+	labelPrefix    string
+	severity       SeverityMappingConfig
+	classification classification
+	businessHours  BusinessHoursConfig
+	eventFields    []fieldTemplate
+
+	// receiverFields and receiverMappings hold the incident fields and field mappings of the webhook receivers, by name
+	receiverFields   map[string][]fieldTemplate
+	receiverMappings map[string][]fieldMapping
+}
+
+func newIncidentMapping(c Config) *incidentMapping {
+	m := &incidentMapping{
+		defaultFields:  compileFieldTemplates(c.DefaultIncident),
+		tableFields:    make(map[string][]fieldTemplate, len(c.TableProfiles)),
+		tableMappings:  make(map[string][]fieldMapping, len(c.TableFieldMappings)),
+		namedFields:    make(map[string][]fieldTemplate, len(c.IncidentTemplates)),
+		templateRules:  c.IncidentTemplateRules,
+		labelPrefix:    c.FieldLabelPrefix,
+		severity:       c.SeverityMapping,
+		classification: newClassification(c.Classification),
+		businessHours:  c.BusinessHours,
+		eventFields:    compileFieldTemplates(c.Event.fields()),
+
+		receiverFields:   make(map[string][]fieldTemplate, len(c.Receivers)),
+		receiverMappings: make(map[string][]fieldMapping, len(c.Receivers)),
+	}
+	// The field mappings are validated with the config
+	m.fieldMappings, _ = parseFieldMappings(c.FieldMappings)
+	for tableName, mappings := range c.TableFieldMappings {
+		m.tableMappings[tableName], _ = parseFieldMappings(mappings)
+	}
+	for tableName, fields := range c.TableProfiles {
+		m.tableFields[tableName] = compileFieldTemplates(fields)
+	}
+	for name, fields := range c.IncidentTemplates {
+		m.namedFields[name] = compileFieldTemplates(fields)
+	}
+	for name, receiver := range c.Receivers {
+		if receiver.DefaultIncident != nil {
+			m.receiverFields[name] = compileFieldTemplates(receiver.DefaultIncident)
+		}
+		m.receiverMappings[name], _ = parseFieldMappings(receiver.FieldMappings)
+	}
+	return m
+}
+
+func compileFieldTemplates(fields map[string]string) []fieldTemplate {
+	templates := make([]fieldTemplate, 0, len(fields))
+	for field, text := range fields {
+		tmpl, err := newTextTemplate(field).Parse(text)
+		templates = append(templates, fieldTemplate{field: field, text: text, tmpl: tmpl, err: err})
+	}
+	return templates
+}
+
+// fields returns the compiled incident fields of the table, defaulting to the default incident
+func (m *incidentMapping) fields(tableName string) []fieldTemplate {
+	if fields, ok := m.tableFields[tableName]; ok {
+		return fields
+	}
+	return m.defaultFields
+}
+
+// selectFields returns the compiled fields of the incident template selected for the alert group by the first matching
+// rule, defaulting to the fields of the webhook receiver of the context, then to the fields of the table
+func (m *incidentMapping) selectFields(ctx context.Context, tableName string, data template.Data) []fieldTemplate {
+	if name := selectIncidentTemplate(m.templateRules, data); len(name) > 0 {
+		if fields, ok := m.namedFields[name]; ok {
+			loggerFrom(ctx).Debugf("Incident template %s selected", name)
+			return fields
+		}
+	}
+	if fields, ok := m.receiverFields[receiverFrom(ctx)]; ok {
+		return fields
+	}
+	return m.fields(tableName)
+}
+
+// apply sets the incident fields of the selected incident template, of the webhook receiver or of the table, executing their templates on the alert
+// group, overridden by the fields of the route, then the fields of the prefixed labels, the mapped fields, those of the table, those of the receiver last, the impact
+// and urgency of the alert group severity, adjusted to the business hours, and the category and subcategory of the classification
+func (m *incidentMapping) apply(ctx context.Context, tableName string, incident Incident, data template.Data) {
+	config := configFrom(ctx)
+	templateContext := newTemplateContext(config.InstanceList, data)
+	executeFieldTemplates(ctx, m.selectFields(ctx, tableName, data), incident, templateContext)
+	// The routes are not part of the snapshot, their fields being compiled with the config under the config lock
+	executeFieldTemplates(ctx, compileFieldTemplates(routeFieldsFrom(ctx)), incident, templateContext)
+	if len(m.labelPrefix) > 0 {
+		applyFieldMappings(prefixedLabelMappings(m.labelPrefix, groupKeyField(ctx, tableName), data), incident, data)
+	}
+	applyFieldMappings(m.fieldMappings, incident, data)
+	applyFieldMappings(m.tableMappings[tableName], incident, data)
+	applyFieldMappings(m.receiverMappings[receiverFrom(ctx)], incident, data)
+	applySeverityMapping(m.severity, incident, data)
+	applyBusinessHours(m.businessHours, incident, data, time.Now())
+	applyClassification(ctx, m.classification, incident, data)
+}
+
+func executeFieldTemplates(ctx context.Context, templates []fieldTemplate, incident Incident, data interface{}) {
+	for _, t := range templates {
+		err := t.err
+		var result bytes.Buffer
+		if err == nil {
+			err = t.tmpl.Execute(&result, data)
+		}
+		if err != nil {
+			incident[t.field] = ""
+			webhookIncidentTemplateError.Inc()
+			loggerFrom(ctx).Errorf("Error parsing default incident template for key:%s value:%s, error:%v", t.field, t.text, err)
+			continue
+		}
+		incident[t.field] = result.String()
+	}
+}
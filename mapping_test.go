@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+func TestIncidentMapping_TemplateError(t *testing.T) {
+	m := newIncidentMapping(Config{DefaultIncident: map[string]string{
+		"short_description": "{{ .CommonLabels.alertname }}",
+		"description":       "{{ .Unclosed",
+	}})
+
+	incident := Incident{}
+	m.apply(context.Background(), "incident", incident, template.Data{CommonLabels: template.KV{"alertname": "a"}})
+	if incident["short_description"] != "a" || incident["description"] != "" {
+		t.Errorf("Unexpected incident: %v", incident)
+	}
+}
+
+func TestReloadIncidentMapping_Invalid(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	before := currentConfigSnapshot().mapping
+
+	file, err := ioutil.TempFile("", "servicenow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("service_now:\n instance_name: \"instance\"\n")
+	file.Close()
+
+	if err := reloadConfig(file.Name()); err == nil {
+		t.Errorf("Expected an error reloading an invalid config, got none")
+	}
+	if currentConfigSnapshot().mapping != before {
+		t.Errorf("Invalid config should keep the current mapping")
+	}
+}
+
+func writeMappingConfig(t *testing.T, version int) string {
+	file, err := ioutil.TempFile("", "servicenow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(file, `
+service_now:
+ instance_name: "instance"
+ user_name: "SA"
+ password: "SA!"
+workflow:
+ incident_group_key_field: "u_group_key_v%[1]d"
+ no_update_states: [%[2]d]
+ incident_update_fields: ["u_notes_v%[1]d"]
+default_incident:
+ short_description: "v%[1]d {{ .CommonLabels.alertname }}"
+ description: "v%[1]d {{ .AlertCount }}"
+ comments: "v%[1]d"
+`, version, 6+version)
+	file.Close()
+	return file.Name()
+}
+
+// Run with -race: alert groups are mapped while the config is reloaded, each incident must be mapped with a single version.
+func TestIncidentMapping_ConcurrentReload(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	configFiles := []string{writeMappingConfig(t, 0), writeMappingConfig(t, 1)}
+	for _, configFile := range configFiles {
+		defer os.Remove(configFile)
+	}
+	if err := reloadConfig(configFiles[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	data := template.Data{CommonLabels: template.KV{"alertname": "a"}, Alerts: template.Alerts{template.Alert{}}}
+	done := make(chan struct{})
+	var reloads sync.WaitGroup
+	reloads.Add(1)
+	go func() {
+		defer reloads.Done()
+		for i := 1; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+				if err := reloadConfig(configFiles[i%2]); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}
+	}()
+
+	var requests sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		requests.Add(1)
+		go func() {
+			defer requests.Done()
+			for i := 0; i < 200; i++ {
+				ctx := withConfigSnapshot(context.Background())
+				incident, _ := alertGroupToIncident(ctx, "incident", data)
+				version := incident["comments"].(string)
+				if incident["short_description"] != version+" a" || incident["description"] != version+" 1" || incident["u_group_key_"+version] == nil {
+					t.Errorf("Incident mapped with mixed versions: %v", incident)
+					return
+				}
+				if field := groupKeyField(ctx, "incident"); field != "u_group_key_"+version {
+					t.Errorf("Incident of the %s config looked up by the group key field %s", version, field)
+					return
+				}
+				if update := filterForUpdate(ctx, "incident", Incident{"u_notes_v0": "n", "u_notes_v1": "n"}); len(update) != 1 || update["u_notes_"+version] == nil {
+					t.Errorf("Incident of the %s config updated with the fields %v", version, update)
+					return
+				}
+				closed := map[string]string{"v0": "6", "v1": "7"}[version]
+				if updatable := filterUpdatableIncidents(ctx, "incident", []Incident{{"state": "6"}, {"state": "7"}}); len(updatable) != 1 || updatable[0]["state"] == closed {
+					t.Errorf("Incidents of the %s config filtered with the no update states of another version: %v", version, updatable)
+					return
+				}
+			}
+		}()
+	}
+	requests.Wait()
+	close(done)
+	reloads.Wait()
+}
+
+func TestParseConfig_DoesNotLoad(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	configData, err := ioutil.ReadFile("config/servicenow_example.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := parseConfig([]byte(strings.Replace(string(configData), "CHANGE_ME", "other", -1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if currentConfig().ServiceNow.InstanceName == c.ServiceNow.InstanceName {
+		t.Errorf("Parsing a config should not load it")
+	}
+}
+
+func TestIncidentMapping_PinnedAcrossReload(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	configFiles := []string{writeMappingConfig(t, 0), writeMappingConfig(t, 1)}
+	for _, configFile := range configFiles {
+		defer os.Remove(configFile)
+	}
+	if err := reloadConfig(configFiles[0]); err != nil {
+		t.Fatal(err)
+	}
+	ctx := withConfigSnapshot(context.Background())
+	if err := reloadConfig(configFiles[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	if field := groupKeyField(ctx, "incident"); field != "u_group_key_v0" {
+		t.Errorf("The request should look up its incidents by the group key field of its config: got %s", field)
+	}
+	if update := filterForUpdate(ctx, "incident", Incident{"u_notes_v0": "n", "u_notes_v1": "n"}); len(update) != 1 || update["u_notes_v0"] == nil {
+		t.Errorf("The request should update its incidents with the fields of its config: got %v", update)
+	}
+	if updatable := filterUpdatableIncidents(ctx, "incident", []Incident{{"state": "6"}, {"state": "7"}}); len(updatable) != 1 || updatable[0]["state"] != "7" {
+		t.Errorf("The request should filter its incidents by the no update states of its config: got %v", updatable)
+	}
+}
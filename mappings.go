@@ -0,0 +1,160 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const mappingsPathPrefix = "/api/v1/mappings"
+
+// alertMapping is the incident an alert was last managed in, in a table
+type alertMapping struct {
+	Fingerprint string    `json:"fingerprint"`
+	Alertname   string    `json:"alertname,omitempty"`
+	AlertStatus string    `json:"alert_status"`
+	Table       string    `json:"table"`
+	Incident    string    `json:"incident,omitempty"`
+	SysID       string    `json:"sys_id,omitempty"`
+	State       string    `json:"state,omitempty"`
+	Result      string    `json:"result"`
+	Error       string    `json:"error,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// alertMappingRegistry holds the mappings of the alerts managed by the replica, evicting the least recently updated
+// ones beyond its capacity
+type alertMappingRegistry struct {
+	mutex      sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List
+}
+
+func newAlertMappingRegistry(maxEntries int) *alertMappingRegistry {
+	return &alertMappingRegistry{maxEntries: maxEntries, entries: map[string]*list.Element{}, order: list.New()}
+}
+
+// record records the outcome of the alerts of the group in its table. A failed alert keeps the incident it was mapped
+// to, when ServiceNow did not tell which one failed.
+func (r *alertMappingRegistry) record(group tableGroup, ref *incidentRef, err error, now time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, alert := range group.data.Alerts {
+		mapping := alertMapping{
+			Fingerprint: alertFingerprint(alert),
+			Alertname:   alert.Labels["alertname"],
+			AlertStatus: alert.Status,
+			Table:       group.tableName,
+			Incident:    ref.number,
+			SysID:       ref.sysID,
+			State:       ref.state,
+			Result:      alertResultSuccess,
+			UpdatedAt:   now,
+		}
+		key := mapping.Fingerprint + "/" + mapping.Table
+		element, known := r.entries[key]
+		if err != nil {
+			mapping.Result, mapping.Error = alertResultFailed, err.Error()
+			if known && len(mapping.SysID) == 0 {
+				last := element.Value.(alertMapping)
+				mapping.Incident, mapping.SysID, mapping.State = last.Incident, last.SysID, last.State
+			}
+		}
+		if known {
+			element.Value = mapping
+			r.order.MoveToFront(element)
+			continue
+		}
+		r.entries[key] = r.order.PushFront(mapping)
+		if r.order.Len() > r.maxEntries {
+			oldest := r.order.Back()
+			r.order.Remove(oldest)
+			last := oldest.Value.(alertMapping)
+			delete(r.entries, last.Fingerprint+"/"+last.Table)
+		}
+	}
+}
+
+// updateState sets the state of the incident of the sys_id on the mappings of its alerts, returning their number. The
+// mappings keep their order, of the last outcome of their alerts.
+func (r *alertMappingRegistry) updateState(sysID string, state string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	updated := 0
+	for element := r.order.Front(); element != nil; element = element.Next() {
+		if mapping := element.Value.(alertMapping); mapping.SysID == sysID {
+			mapping.State = state
+			element.Value = mapping
+			updated++
+		}
+	}
+	return updated
+}
+
+// list returns the mappings, the most recently updated first
+func (r *alertMappingRegistry) list() []alertMapping {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	mappings := make([]alertMapping, 0, r.order.Len())
+	for element := r.order.Front(); element != nil; element = element.Next() {
+		mappings = append(mappings, element.Value.(alertMapping))
+	}
+	return mappings
+}
+
+// get returns the mappings of the alert in each of its tables, sorted by table
+func (r *alertMappingRegistry) get(fingerprint string) []alertMapping {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var mappings []alertMapping
+	for element := r.order.Front(); element != nil; element = element.Next() {
+		if mapping := element.Value.(alertMapping); mapping.Fingerprint == fingerprint {
+			mappings = append(mappings, mapping)
+		}
+	}
+	sort.Slice(mappings, func(i, j int) bool { return mappings[i].Table < mappings[j].Table })
+	return mappings
+}
+
+// loadAlertMappings creates the registry of the alert mappings, unless disabled
+func loadAlertMappings() {
+	if *mappingsMaxEntries > 0 {
+		alertMappings = newAlertMappingRegistry(*mappingsMaxEntries)
+	}
+}
+
+// recordAlertMappings records the outcome of the alert group, unless dry run
+func recordAlertMappings(ctx context.Context, group tableGroup, ref *incidentRef, err error) {
+	if alertMappings == nil || isDryRun(ctx) {
+		return
+	}
+	alertMappings.record(group, ref, err, time.Now())
+}
+
+// mappingsHandler is the handler of /api/v1/mappings, listing on GET requests the incidents the alerts were managed
+// in, and of /api/v1/mappings/<fingerprint>, returning the ones of the alert
+func mappingsHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorizeRequest(w, r, http.MethodGet) {
+		return
+	}
+	if alertMappings == nil {
+		http.Error(w, "The alert mappings are disabled", http.StatusNotFound)
+		return
+	}
+	fingerprint := strings.Trim(strings.TrimPrefix(r.URL.Path, mappingsPathPrefix), "/")
+	if len(fingerprint) == 0 {
+		writeJSON(w, alertMappings.list())
+		return
+	}
+	mappings := alertMappings.get(fingerprint)
+	if len(mappings) == 0 {
+		http.Error(w, "Unknown alert "+fingerprint, http.StatusNotFound)
+		return
+	}
+	writeJSON(w, mappings)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAlertMappingRegistry(t *testing.T) {
+	r := newAlertMappingRegistry(2)
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	alert := func(name string) template.Alert {
+		return template.Alert{Status: "firing", Labels: template.KV{"alertname": name}, Fingerprint: name}
+	}
+	r.record(tableGroup{tableName: "incident", data: template.Data{Alerts: template.Alerts{alert("a")}}}, &incidentRef{number: "INC1", sysID: "1", state: "1"}, nil, now)
+	r.record(tableGroup{tableName: "problem", data: template.Data{Alerts: template.Alerts{alert("a")}}}, &incidentRef{number: "PRB1", sysID: "2"}, nil, now)
+	r.record(tableGroup{tableName: "incident", data: template.Data{Alerts: template.Alerts{alert("a")}}}, &incidentRef{}, errors.New("Error"), now.Add(time.Minute))
+
+	mappings := r.get("a")
+	if len(mappings) != 2 || mappings[0].Table != "incident" || mappings[1].Incident != "PRB1" {
+		t.Fatalf("The mappings of the alert should be returned by table: %+v", mappings)
+	}
+	if m := mappings[0]; m.Result != alertResultFailed || m.Error != "Error" || m.Incident != "INC1" || m.State != "1" || !m.UpdatedAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("The failed alert should keep its incident: %+v", m)
+	}
+
+	r.record(tableGroup{tableName: "incident", data: template.Data{Alerts: template.Alerts{alert("b")}}}, &incidentRef{number: "INC2"}, nil, now)
+	if list := r.list(); len(list) != 2 || list[0].Fingerprint != "b" || list[1].Table != "incident" {
+		t.Errorf("The least recently updated mapping should be evicted: %+v", list)
+	}
+}
+
+func TestMappingsHandler(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	dedupStore = newMemoryDedupStore()
+	defer func(r *alertMappingRegistry) { alertMappings = r }(alertMappings)
+	alertMappings = newAlertMappingRegistry(10)
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{"sys_id": "1", "number": "INC1", "state": "1"}, nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(webhook).ServeHTTP(rr, httptest.NewRequest("POST", "/webhook", strings.NewReader(twoAlertsNotification)))
+
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(mappingsHandler).ServeHTTP(rr, httptest.NewRequest("GET", mappingsPathPrefix, nil))
+	var mappings []alertMapping
+	if err := json.Unmarshal(rr.Body.Bytes(), &mappings); err != nil || len(mappings) != 2 {
+		t.Fatalf("The mappings of both alerts should be listed: %s, %v", rr.Body.String(), err)
+	}
+	if m := mappings[0]; m.Incident != "INC1" || m.SysID != "1" || m.State != "1" || m.Result != alertResultSuccess || m.Table != "incident" {
+		t.Errorf("Unexpected mapping: %+v", m)
+	}
+
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(mappingsHandler).ServeHTTP(rr, httptest.NewRequest("GET", mappingsPathPrefix+"/"+mappings[1].Fingerprint, nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), mappings[1].Fingerprint) {
+		t.Errorf("The mapping of the alert should be returned: %v %s", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(mappingsHandler).ServeHTTP(rr, httptest.NewRequest("GET", mappingsPathPrefix+"/unknown", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Wrong status code for an unknown alert: got %v, want %v", rr.Code, http.StatusNotFound)
+	}
+
+	currentConfig().Webhook.BearerToken = "token"
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(mappingsHandler).ServeHTTP(rr, httptest.NewRequest("GET", mappingsPathPrefix, nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Wrong status code without authentication: got %v, want %v", rr.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	incidentCreated  = "created"
+	incidentUpdated  = "updated"
+	incidentResolved = "resolved"
+)
+
+// Operations of the ServiceNow API requests
+const (
+	apiCreate = "create"
+	apiGet    = "get"
+	apiUpdate = "update"
+	apiDelete = "delete"
+	apiAttach = "attach"
+	apiImport = "import"
+)
+
+var (
+	incidentOperations = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_incident_operations_total",
+			Help: "Total number of incidents created, updated and resolved in ServiceNow, by table, operation and result.",
+		},
+		[]string{"table", "operation", "result"},
+	)
+
+	serviceNowRequestDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "servicenow_request_duration_seconds",
+			Help:    "Duration of the HTTP requests to ServiceNow instance.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"method", "code"},
+	)
+
+	serviceNowAPIRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "servicenow_api_requests_total",
+			Help: "Total number of ServiceNow API requests, including the retries, by table, operation, HTTP status class and code.",
+		},
+		[]string{"table", "operation", "status_class", "code"},
+	)
+
+	serviceNowAPIRequestDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "servicenow_api_request_duration_seconds",
+			Help:    "Duration of the ServiceNow API requests, including the retries, by table, operation and HTTP status class.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"table", "operation", "status_class"},
+	)
+
+	webhookNotificationAlerts = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "webhook_notification_alerts",
+			Help:    "Number of alerts per notification received on /webhook.",
+			Buckets: []float64{1, 2, 5, 10, 20, 50, 100, 200, 500},
+		},
+	)
+)
+
+// countIncidentOperation counts the incident operation, as failed when it returned an error.
+// The operations of a dry run are not counted.
+func countIncidentOperation(ctx context.Context, tableName string, operation string, err error) {
+	if isDryRun(ctx) {
+		return
+	}
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	incidentOperations.WithLabelValues(tableName, operation, result).Inc()
+}
+
+// apiOperation is the operation of a ServiceNow API request on a table, labelling its metrics
+type apiOperation struct {
+	table string
+	name  string
+}
+
+// statusClass returns the class of the HTTP status code, e.g. 4xx, or "error" when no response was received
+func statusClass(resp *http.Response) string {
+	if resp == nil {
+		return "error"
+	}
+	return strconv.Itoa(resp.StatusCode/100) + "xx"
+}
+
+// observe counts the request of the operation, with the code of its response, and its duration
+func (o apiOperation) observe(ctx context.Context, resp *http.Response, duration time.Duration) {
+	class, code := statusClass(resp), ""
+	if resp != nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	serviceNowAPIRequests.WithLabelValues(o.table, o.name, class, code).Inc()
+	observeWithExemplar(ctx, serviceNowAPIRequestDuration.WithLabelValues(o.table, o.name, class), duration.Seconds())
+}
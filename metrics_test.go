@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestIncidentOperations(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    string
+		existing  []Incident
+		err       error
+		operation string
+		result    string
+	}{
+		{name: "created", status: "firing", existing: []Incident{}, operation: incidentCreated, result: "success"},
+		{name: "create_failed", status: "firing", existing: []Incident{}, err: errors.New("error"), operation: incidentCreated, result: "failure"},
+		{name: "updated", status: "firing", existing: []Incident{Incident{"sys_id": "1", "number": "INC1", "state": "2"}}, operation: incidentUpdated, result: "success"},
+		{name: "resolved", status: "resolved", existing: []Incident{Incident{"sys_id": "1", "number": "INC1", "state": "2"}}, operation: incidentResolved, result: "success"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loadConfig("config/servicenow_example.yml")
+			snClientMock := new(MockedSnClient)
+			useServiceNow(snClientMock)
+			snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return(tt.existing, nil)
+			snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{"sys_id": "1", "number": "INC1"}, tt.err)
+			snClientMock.On("UpdateIncident", mock.Anything, mock.Anything, mock.Anything).Return(Incident{}, tt.err)
+
+			counter := incidentOperations.WithLabelValues("incident", tt.operation, tt.result)
+			before := testutil.ToFloat64(counter)
+			data := template.Data{
+				Status:      tt.status,
+				GroupLabels: template.KV{"alertname": "metrics_" + tt.name},
+				Alerts:      template.Alerts{template.Alert{Status: tt.status}},
+			}
+			onAlertGroup(context.Background(), data)
+			if got := testutil.ToFloat64(counter) - before; got != 1 {
+				t.Errorf("webhook_incident_operations_total increased by %v, want 1", got)
+			}
+		})
+	}
+}
+
+func TestServiceNowAPIRequests(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"result": []}`))
+	}))
+	defer ts.Close()
+	snClient, _ := NewServiceNowClient("instancename", "username", "password")
+	snClient.baseURL = ts.URL
+
+	counters := map[string]float64{}
+	labels := map[string][]string{
+		"get":    {"u_metrics_incident", apiGet, "2xx", "200"},
+		"update": {"u_metrics_incident", apiUpdate, "4xx", "429"},
+	}
+	for name, l := range labels {
+		counters[name] = testutil.ToFloat64(serviceNowAPIRequests.WithLabelValues(l...))
+	}
+	snClient.GetIncidents(context.Background(), "u_metrics_incident", nil)
+	snClient.UpdateIncident(context.Background(), "u_metrics_incident", Incident{}, "1")
+	for name, l := range labels {
+		if got := testutil.ToFloat64(serviceNowAPIRequests.WithLabelValues(l...)) - counters[name]; got != 1 {
+			t.Errorf("servicenow_api_requests_total%v increased by %v, want 1", l, got)
+		}
+	}
+
+	snClient.baseURL = "http://127.0.0.1:0"
+	counter := serviceNowAPIRequests.WithLabelValues("u_metrics_incident", apiCreate, "error", "")
+	before := testutil.ToFloat64(counter)
+	snClient.CreateIncident(context.Background(), "u_metrics_incident", Incident{})
+	if got := testutil.ToFloat64(counter) - before; got != 1 {
+		t.Errorf("The requests without response should be counted as errors, increased by %v", got)
+	}
+}
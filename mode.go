@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// workflowModeResolveOnly only manages resolved alert groups, to leave incidents creation to another deployment
+	workflowModeResolveOnly = "resolve_only"
+)
+
+var webhookIgnoredFiringGroups = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Name: "webhook_ignored_firing_groups_total",
+		Help: "Total number of firing alert groups ignored in resolve_only mode.",
+	},
+)
+
+func validateWorkflowMode(c WorkflowConfig, errs *strings.Builder) {
+	switch c.Mode {
+	case "":
+	case workflowModeResolveOnly:
+		// Resolved alert groups only update the existing incidents with the update fields and the resolution
+		if len(c.IncidentUpdateFields) == 0 && !c.Resolve.enabled() {
+			errs.WriteString("incident_update_fields is missing, it is required to resolve incidents in resolve_only mode unless resolve.state is set\n")
+		}
+		if c.TwoPhaseCreate.Enabled {
+			errs.WriteString("two_phase_create cannot be enabled in resolve_only mode\n")
+		}
+	default:
+		errs.WriteString(fmt.Sprintf("workflow.mode must be empty or %q\n", workflowModeResolveOnly))
+	}
+}
+
+// isIgnoredFiringGroup returns whether the alert group is firing while only resolved groups are managed
+func isIgnoredFiringGroup(c WorkflowConfig, status string) bool {
+	return c.Mode == workflowModeResolveOnly && status == "firing"
+}
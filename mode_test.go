@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestOnAlertGroup_ResolveOnly_Firing(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Workflow.Mode = workflowModeResolveOnly
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+
+	data := template.Data{
+		Status:      "firing",
+		GroupLabels: template.KV{"alertname": "resolve_only"},
+		Alerts:      template.Alerts{template.Alert{Status: "firing"}},
+	}
+	if err := onAlertGroup(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+	snClientMock.AssertNotCalled(t, "GetIncidents", mock.Anything, mock.Anything)
+	snClientMock.AssertNotCalled(t, "CreateIncident", mock.Anything, mock.Anything)
+	snClientMock.AssertNotCalled(t, "UpdateIncident", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestOnAlertGroup_ResolveOnly_Resolved(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Workflow.Mode = workflowModeResolveOnly
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "incident", mock.Anything).Return([]Incident{Incident{"sys_id": "1", "number": "INC1", "state": "1"}}, nil)
+	snClientMock.On("UpdateIncident", "incident", mock.Anything, "1").Return(Incident{}, nil)
+
+	data := template.Data{
+		Status:      "resolved",
+		GroupLabels: template.KV{"alertname": "resolve_only"},
+		Alerts:      template.Alerts{template.Alert{Status: "resolved"}},
+	}
+	if err := onAlertGroup(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+	snClientMock.AssertCalled(t, "UpdateIncident", "incident", mock.Anything, "1")
+}
+
+func TestValidateWorkflowMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  WorkflowConfig
+		wantErr string
+	}{
+		{name: "default", config: WorkflowConfig{}},
+		{name: "resolve_only", config: WorkflowConfig{Mode: workflowModeResolveOnly, IncidentUpdateFields: []string{"state"}}},
+		{name: "resolve_only_with_resolve_state", config: WorkflowConfig{Mode: workflowModeResolveOnly, Resolve: ResolveConfig{State: "6"}}},
+		{name: "resolve_only_without_update_fields", config: WorkflowConfig{Mode: workflowModeResolveOnly}, wantErr: "incident_update_fields is missing"},
+		{name: "resolve_only_two_phase_create", config: WorkflowConfig{Mode: workflowModeResolveOnly, IncidentUpdateFields: []string{"state"}, TwoPhaseCreate: TwoPhaseCreateConfig{Enabled: true}}, wantErr: "two_phase_create"},
+		{name: "unknown", config: WorkflowConfig{Mode: "create_only"}, wantErr: "workflow.mode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var errs strings.Builder
+			validateWorkflowMode(tt.config, &errs)
+			if len(tt.wantErr) == 0 && errs.Len() > 0 {
+				t.Errorf("Unexpected validation errors: %q", errs.String())
+			}
+			if !strings.Contains(errs.String(), tt.wantErr) {
+				t.Errorf("Expected validation error %q, got %q", tt.wantErr, errs.String())
+			}
+		})
+	}
+}
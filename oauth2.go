@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	oauth2TokenPath = "/oauth_token.do"
+	// oauth2ExpiryMargin renews the access token before it expires, so that it does not expire in flight
+	oauth2ExpiryMargin = 30 * time.Second
+)
+
+// OAuth2Config - ServiceNow OAuth2 authentication configuration
+type OAuth2Config struct {
+	ClientID         string `yaml:"client_id"`
+	ClientSecret     string `yaml:"client_secret"`
+	ClientSecretFile string `yaml:"client_secret_file"`
+	TokenURL         string `yaml:"token_url"`
+	RefreshToken     string `yaml:"refresh_token"`
+}
+
+func (c OAuth2Config) enabled() bool {
+	return len(c.ClientID) > 0
+}
+
+func (c OAuth2Config) validate(errs *strings.Builder) {
+	if c.enabled() && len(c.ClientSecret) == 0 {
+		errs.WriteString("oauth2.client_secret is missing\n")
+	}
+}
+
+// oauth2TokenSource fetches and caches the OAuth2 access token, with the client credentials grant, or the refresh token
+// grant when a refresh token is configured
+type oauth2TokenSource struct {
+	mutex        sync.Mutex
+	config       OAuth2Config
+	client       *http.Client
+	refreshToken string
+	accessToken  string
+	expiresAt    time.Time
+}
+
+func newOAuth2TokenSource(c OAuth2Config, client *http.Client) *oauth2TokenSource {
+	return &oauth2TokenSource{config: c, client: client, refreshToken: c.RefreshToken}
+}
+
+type oauth2TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// authHeader returns the Authorization header with a valid access token, fetching a new one when needed
+func (s *oauth2TokenSource) authHeader(ctx context.Context, baseURL string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.accessToken) > 0 && time.Now().Before(s.expiresAt) {
+		return "Bearer " + s.accessToken, nil
+	}
+
+	tokenURL := s.config.TokenURL
+	if len(tokenURL) == 0 {
+		tokenURL = baseURL + oauth2TokenPath
+	}
+	form := url.Values{"client_id": {s.config.ClientID}, "client_secret": {s.config.ClientSecret}}
+	if len(s.refreshToken) > 0 {
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", s.refreshToken)
+	} else {
+		form.Set("grant_type", "client_credentials")
+	}
+
+	req, err := http.NewRequest("POST", tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("ServiceNow returned the HTTP error code %v on the OAuth2 token request", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	var token oauth2TokenResponse
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", fmt.Errorf("Invalid OAuth2 token response: %v", err)
+	}
+	if len(token.AccessToken) == 0 {
+		return "", errors.New("Invalid OAuth2 token response: access_token is missing")
+	}
+
+	s.accessToken = token.AccessToken
+	s.expiresAt = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - oauth2ExpiryMargin)
+	if len(token.RefreshToken) > 0 {
+		s.refreshToken = token.RefreshToken
+	}
+	return "Bearer " + s.accessToken, nil
+}
+
+// invalidate forgets the access token, so that a new one is fetched on the next request
+func (s *oauth2TokenSource) invalidate() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.accessToken = ""
+}
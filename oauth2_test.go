@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// newOAuth2Server returns a ServiceNow server issuing numbered access tokens, and only accepting the latest one
+func newOAuth2Server(t *testing.T, grants *[]string) *httptest.Server {
+	incidentTest, err := ioutil.ReadFile("test/incident_response.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tokens int32
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == oauth2TokenPath {
+			r.ParseForm()
+			if r.Form.Get("client_id") != "id" || r.Form.Get("client_secret") != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			*grants = append(*grants, r.Form.Get("grant_type")+":"+r.Form.Get("refresh_token"))
+			n := atomic.AddInt32(&tokens, 1)
+			fmt.Fprintf(w, `{"access_token": "token%d", "refresh_token": "refresh%d", "expires_in": 1800}`, n, n)
+			return
+		}
+		if r.Header.Get("Authorization") != fmt.Sprintf("Bearer token%d", atomic.LoadInt32(&tokens)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, string(incidentTest))
+	}))
+}
+
+func TestOAuth2Client_ClientCredentials(t *testing.T) {
+	var grants []string
+	ts := newOAuth2Server(t, &grants)
+	defer ts.Close()
+
+	snClient, err := NewServiceNowOAuth2Client("instancename", OAuth2Config{ClientID: "id", ClientSecret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	snClient.baseURL = ts.URL
+
+	for i := 0; i < 2; i++ {
+		if _, err := snClient.CreateIncident(context.Background(), "incident", basicIncidentParam); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(grants) != 1 || grants[0] != "client_credentials:" {
+		t.Errorf("Access token should be fetched once with the client credentials grant, got %v", grants)
+	}
+}
+
+func TestOAuth2Client_RetryOnUnauthorized(t *testing.T) {
+	var grants []string
+	ts := newOAuth2Server(t, &grants)
+	defer ts.Close()
+
+	snClient, err := NewServiceNowOAuth2Client("instancename", OAuth2Config{ClientID: "id", ClientSecret: "secret", RefreshToken: "refresh0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	snClient.baseURL = ts.URL
+
+	if _, err := snClient.CreateIncident(context.Background(), "incident", basicIncidentParam); err != nil {
+		t.Fatal(err)
+	}
+	// The access token is revoked by ServiceNow
+	snClient.oauth2.accessToken = "revoked"
+	if _, err := snClient.UpdateIncident(context.Background(), "incident", basicIncidentParam, "42"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"refresh_token:refresh0", "refresh_token:refresh1"}
+	if strings.Join(grants, ",") != strings.Join(want, ",") {
+		t.Errorf("Unexpected token grants: got %v, want %v", grants, want)
+	}
+}
+
+func TestOAuth2Client_TokenError(t *testing.T) {
+	var grants []string
+	ts := newOAuth2Server(t, &grants)
+	defer ts.Close()
+
+	snClient, err := NewServiceNowOAuth2Client("instancename", OAuth2Config{ClientID: "id", ClientSecret: "wrong"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	snClient.baseURL = ts.URL
+
+	if _, err := snClient.CreateIncident(context.Background(), "incident", basicIncidentParam); err == nil || !strings.Contains(err.Error(), "OAuth2") {
+		t.Errorf("Expected an OAuth2 error, got %v", err)
+	}
+}
+
+func TestNewServiceNowOAuth2Client_MissingCredentials(t *testing.T) {
+	if _, err := NewServiceNowOAuth2Client("instancename", OAuth2Config{ClientID: "id"}); err == nil {
+		t.Errorf("Expected an error, got none")
+	}
+}
+
+func TestLoadConfigContent_OAuth2(t *testing.T) {
+	configFile := `
+service_now:
+ instance_name: "instance"
+ user_name: "SA"
+ oauth2:
+  client_id: "id"
+  client_secret: "secret"
+workflow:
+ incident_group_key_field: "u_other_reference_1"
+`
+	if _, err := loadConfigContent([]byte(configFile)); err != nil {
+		t.Errorf("Password should not be required with OAuth2: %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	onCallAPI = "%s/on_call_rota/whoisoncall"
+	// onCallRotaTable labels the requests of the On-Call Scheduling API in the metrics
+	onCallRotaTable       = "cmn_rota"
+	defaultOnCallCacheTTL = time.Minute
+)
+
+var onCallCache = newLookupCache("on_call", defaultOnCallCacheTTL)
+
+// onCallMember is an entry of the response of the On-Call Scheduling API, one per user on call for the group
+type onCallMember struct {
+	UserID string      `json:"userId"`
+	Order  json.Number `json:"order"`
+}
+
+// whoIsOnCall requests the users currently on call for the group to the On-Call Scheduling API
+func (snClient *ServiceNowClient) whoIsOnCall(ctx context.Context, groupSysID string) ([]byte, error) {
+	url := fmt.Sprintf(onCallAPI, snClient.api())
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		loggerFrom(ctx).Errorf("Error creating the request. %s", err)
+		return nil, err
+	}
+
+	q := req.URL.Query()
+	q.Add("group_ids", groupSysID)
+	req.URL.RawQuery = q.Encode()
+	req.Header.Set("Accept", "application/json")
+
+	return snClient.doRequest(ctx, req, apiOperation{table: onCallRotaTable, name: apiGet})
+}
+
+// OnCallUsers returns the sys_ids of the users currently on call for the group, from the first escalation level
+func (snClient *ServiceNowClient) OnCallUsers(ctx context.Context, groupSysID string) ([]string, error) {
+	responseBody, err := snClient.whoIsOnCall(ctx, groupSysID)
+	if err != nil {
+		loggerFrom(ctx).Errorf("Error while getting the users on call. %s", err)
+		return nil, err
+	}
+
+	var response struct {
+		Result []onCallMember `json:"result"`
+	}
+	if err := json.Unmarshal(responseBody, &response); err != nil {
+		return nil, fmt.Errorf("invalid On-Call Scheduling API response: %v", err)
+	}
+	members := response.Result
+	sort.SliceStable(members, func(i, j int) bool {
+		a, _ := members[i].Order.Float64()
+		b, _ := members[j].Order.Float64()
+		return a < b
+	})
+	var userIDs []string
+	for _, member := range members {
+		if len(member.UserID) > 0 {
+			userIDs = append(userIDs, member.UserID)
+		}
+	}
+	return userIDs, nil
+}
+
+// onCallUser returns the sys_id of the user currently on call for the group, empty when nobody is. The lookups are
+// cached for assigned_to.on_call_cache_ttl, so that a rotation is followed shortly.
+func onCallUser(ctx context.Context, groupSysID string) (string, error) {
+	key := instanceKey(ctx, groupSysID)
+	if userID, ok := onCallCache.get(key); ok {
+		return userID.(string), nil
+	}
+	userIDs, err := serviceNowFrom(ctx).OnCallUsers(ctx, groupSysID)
+	if err != nil {
+		return "", err
+	}
+	var userID string
+	if len(userIDs) > 0 {
+		userID = userIDs[0]
+	}
+	onCallCache.set(key, userID)
+	return userID, nil
+}
+
+// applyOnCallAssignee sets the incident assignee with the user currently on call for its assignment group, resolved
+// to a sys_id when it is a name, unless the assignee is already set. The field is omitted when nobody is on call.
+func applyOnCallAssignee(ctx context.Context, incident Incident) {
+	config := configFrom(ctx)
+	if !config.AssignedTo.OnCall {
+		return
+	}
+	if _, ok := incident[assignedToField]; ok {
+		return
+	}
+	group, _ := incident[assignmentGroupField].(string)
+	if len(group) == 0 {
+		return
+	}
+	if !sysIDRegexp.MatchString(group) {
+		sysID, err := lookupSysID(ctx, groupCache, groupTable, "name", group)
+		if err != nil || len(sysID) == 0 {
+			loggerFrom(ctx).Warnf("ServiceNow group %s not resolved, the on-call assignee is not looked up: %v", group, err)
+			return
+		}
+		group = sysID
+	}
+	userID, err := onCallUser(ctx, group)
+	if err != nil {
+		serviceNowError.Inc()
+		loggerFrom(ctx).Errorf("Error looking up the user on call for the group %s: %v", group, err)
+		return
+	}
+	if len(userID) == 0 {
+		loggerFrom(ctx).Infof("Nobody is on call for the group %s", group)
+		return
+	}
+	incident[assignedToField] = userID
+}
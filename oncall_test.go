@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestServiceNowClient_OnCallUsers(t *testing.T) {
+	var path, groups string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, groups = r.URL.Path, r.URL.Query().Get("group_ids")
+		w.Write([]byte(`{"result": [{"userId": "backup", "order": 2}, {"userId": "primary", "order": 1}]}`))
+	}))
+	defer ts.Close()
+	client, err := newSnClient(ServiceNowConfig{APIURL: ts.URL + "/api/now", UserName: "user", Password: "password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userIDs, err := client.OnCallUsers(context.Background(), "g1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/api/now/on_call_rota/whoisoncall" || groups != "g1" {
+		t.Errorf("Unexpected request: %s?group_ids=%s", path, groups)
+	}
+	if len(userIDs) != 2 || userIDs[0] != "primary" || userIDs[1] != "backup" {
+		t.Errorf("The users on call should be sorted by escalation order: %v", userIDs)
+	}
+}
+
+func TestApplyOnCallAssignee(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().AssignedTo = AssignedToConfig{OnCall: true}
+	onCallCache.flush()
+	groupCache.flush()
+	defer onCallCache.flush()
+	defer groupCache.flush()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", groupTable, map[string]string{"name": "Ops", "sysparm_fields": "sys_id", "sysparm_limit": "1"}).Return([]Incident{{"sys_id": "g1"}}, nil)
+	snClientMock.On("OnCallUsers", "g1").Return([]string{"u1", "u2"}, nil).Once()
+
+	for i := 0; i < 2; i++ {
+		incident := Incident{assignmentGroupField: "Ops"}
+		applyOnCallAssignee(context.Background(), incident)
+		if incident[assignedToField] != "u1" {
+			t.Errorf("The incident should be assigned to the first user on call: %v", incident)
+		}
+	}
+	snClientMock.AssertNumberOfCalls(t, "OnCallUsers", 1)
+
+	incident := Incident{assignmentGroupField: "g1", assignedToField: "owner"}
+	applyOnCallAssignee(context.Background(), incident)
+	if incident[assignedToField] != "owner" {
+		t.Errorf("The assignee already set should be kept: %v", incident)
+	}
+}
+
+func TestApplyOnCallAssignee_NobodyOnCall(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().AssignedTo = AssignedToConfig{OnCall: true}
+	onCallCache.flush()
+	defer onCallCache.flush()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("OnCallUsers", "0123456789abcdef0123456789abcdef").Return([]string(nil), nil)
+
+	incident := Incident{assignmentGroupField: "0123456789abcdef0123456789abcdef"}
+	applyOnCallAssignee(context.Background(), incident)
+	if _, ok := incident[assignedToField]; ok {
+		t.Errorf("The assignee should be omitted when nobody is on call: %v", incident)
+	}
+}
+
+func TestOnAlertGroup_UndedupedOnCallAssignee(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	disabled := false
+	currentConfig().Dedup.Enabled = &disabled
+	currentConfig().AssignedTo = AssignedToConfig{OnCall: true}
+	onCallCache.flush()
+	defer onCallCache.flush()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("OnCallUsers", "0123456789abcdef0123456789abcdef").Return([]string{"u1"}, nil)
+	snClientMock.On("CreateIncident", "incident", mock.Anything).Return(Incident{"sys_id": "1", "number": "INC1"}, nil)
+	data := template.Data{
+		Status:            "firing",
+		GroupLabels:       template.KV{"alertname": "DiskFull"},
+		CommonAnnotations: template.KV{"assignment_group": "0123456789abcdef0123456789abcdef"},
+		Alerts:            template.Alerts{{Status: "firing"}},
+	}
+
+	if err := onAlertGroup(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+	snClientMock.AssertNumberOfCalls(t, "CreateIncident", 1)
+	if incident := snClientMock.Calls[len(snClientMock.Calls)-1].Arguments.Get(1).(Incident); incident[assignedToField] != "u1" {
+		t.Errorf("The incident created without deduplication should be assigned to the user on call: %v", incident)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+// payloadError is returned when the body of a webhook request is not a valid Alertmanager notification
+type payloadError struct {
+	problems []string
+}
+
+func (e *payloadError) Error() string {
+	return "invalid Alertmanager notification: " + strings.Join(e.problems, "; ")
+}
+
+// describeDecodeError returns a payloadError locating the JSON decoding error of the notification
+func describeDecodeError(err error) error {
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		return &payloadError{problems: []string{fmt.Sprintf("malformed JSON at offset %d: %v", e.Offset, e)}}
+	case *json.UnmarshalTypeError:
+		return &payloadError{problems: []string{fmt.Sprintf("%s must be a %v, got a JSON %s", e.Field, e.Type, e.Value)}}
+	case *time.ParseError:
+		return &payloadError{problems: []string{fmt.Sprintf("timestamp %q must be RFC 3339, e.g. %q", e.Value, time.RFC3339)}}
+	}
+	if err == io.EOF {
+		return &payloadError{problems: []string{"the request body is empty"}}
+	}
+	return &payloadError{problems: []string{err.Error()}}
+}
+
+// validateNotification checks the fields of the notification the incidents are managed from, so that a malformed
+// one, e.g. a hand-written test payload, is rejected up front rather than failing in ServiceNow
+func validateNotification(data template.Data) error {
+	var problems []string
+	if data.Status != "firing" && data.Status != "resolved" {
+		problems = append(problems, fmt.Sprintf(`status must be "firing" or "resolved", got %q`, data.Status))
+	}
+	if len(data.Alerts) == 0 {
+		problems = append(problems, "alerts must hold at least one alert")
+	}
+	for i, alert := range data.Alerts {
+		if alert.Status != "firing" && alert.Status != "resolved" {
+			problems = append(problems, fmt.Sprintf(`alerts[%d].status must be "firing" or "resolved", got %q`, i, alert.Status))
+		}
+		if len(alert.Labels) == 0 {
+			problems = append(problems, fmt.Sprintf("alerts[%d].labels must hold at least one label", i))
+		}
+		if alert.Status == "resolved" && !alert.StartsAt.IsZero() && !alert.EndsAt.IsZero() && alert.EndsAt.Before(alert.StartsAt) {
+			problems = append(problems, fmt.Sprintf("alerts[%d].endsAt %s is before its startsAt %s", i, alert.EndsAt.Format(time.RFC3339), alert.StartsAt.Format(time.RFC3339)))
+		}
+	}
+	if len(problems) > 0 {
+		return &payloadError{problems: problems}
+	}
+	return nil
+}
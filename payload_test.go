@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadRequestBody_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    []string
+	}{
+		{name: "syntax", payload: `{"status": "firing",}`, want: []string{"malformed JSON at offset 21"}},
+		{name: "type", payload: `{"status": "firing", "alerts": {}}`, want: []string{"alerts must be a template.Alerts, got a JSON object"}},
+		{name: "timestamp", payload: `{"status": "firing", "alerts": [{"startsAt": "yesterday"}]}`, want: []string{`timestamp "yesterday" must be RFC 3339`}},
+		{name: "no_alert", payload: `{"status": "firing", "alerts": []}`, want: []string{"alerts must hold at least one alert"}},
+		{name: "fields", payload: `{"alerts": [{"status": "pending"}]}`, want: []string{
+			`status must be "firing" or "resolved", got ""`,
+			`alerts[0].status must be "firing" or "resolved", got "pending"`,
+			"alerts[0].labels must hold at least one label",
+		}},
+		{name: "ends_before_start", payload: `{"status": "resolved", "alerts": [{"status": "resolved", "labels": {"alertname": "A"}, "startsAt": "2020-01-02T00:00:00Z", "endsAt": "2020-01-01T00:00:00Z"}]}`, want: []string{
+			"alerts[0].endsAt 2020-01-01T00:00:00Z is before its startsAt 2020-01-02T00:00:00Z",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readRequestBody(httptest.NewRequest("POST", "/webhook", strings.NewReader(tt.payload)))
+			if _, ok := err.(*payloadError); !ok {
+				t.Fatalf("Expected a payload error, got %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Missing %q in %q", want, err.Error())
+				}
+			}
+		})
+	}
+}
+
+func TestWebhook_InvalidPayload(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(webhook).ServeHTTP(rr, httptest.NewRequest("POST", "/webhook", strings.NewReader(`{"status": "firing", "alerts": []}`)))
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "alerts must hold at least one alert") {
+		t.Errorf("Unexpected response: got %v %q", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import "github.com/prometheus/alertmanager/template"
+
+// splitByAlert splits the table alert groups into one group per alert, so that each alert is managed in its own incident.
+// The labels of the alert are used as group labels, and the incident is deduplicated by the alert fingerprint.
+func splitByAlert(groups []tableGroup) []tableGroup {
+	var split []tableGroup
+	for _, group := range groups {
+		for _, alert := range group.data.Alerts {
+			split = append(split, tableGroup{instance: group.instance, tableName: group.tableName, dedup: group.dedup, data: singleAlertData(group.data, alert), fields: group.fields})
+		}
+	}
+	return split
+}
+
+// singleAlertData returns the alert group of the single alert, whose labels and annotations are the common ones
+func singleAlertData(data template.Data, alert template.Alert) template.Data {
+	data.Status = alert.Status
+	data.Alerts = template.Alerts{alert}
+	data.GroupLabels = alert.Labels
+	data.CommonLabels = alert.Labels
+	data.CommonAnnotations = alert.Annotations
+	return data
+}
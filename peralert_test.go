@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSplitByAlert(t *testing.T) {
+	data := template.Data{
+		Status:      "firing",
+		GroupLabels: template.KV{"alertname": "InstanceDown"},
+		Alerts: template.Alerts{
+			template.Alert{Status: "firing", Labels: template.KV{"alertname": "InstanceDown", "instance": "web01"}},
+			template.Alert{Status: "resolved", Labels: template.KV{"alertname": "InstanceDown", "instance": "web02"}, Annotations: template.KV{"summary": "web02 down"}},
+		},
+	}
+	groups := splitByAlert([]tableGroup{{tableName: "incident", dedup: true, data: data}})
+	if len(groups) != 2 {
+		t.Fatalf("Unexpected groups: got %d, want 2", len(groups))
+	}
+	if groups[1].data.Status != "resolved" || groups[1].data.CommonAnnotations["summary"] != "web02 down" || !groups[1].dedup {
+		t.Errorf("Unexpected alert group: %+v", groups[1])
+	}
+	if getGroupKey(context.Background(), groups[0].data) == getGroupKey(context.Background(), groups[1].data) {
+		t.Errorf("Alerts should have distinct group keys")
+	}
+}
+
+func TestGetGroupKey_IncidentPerAlert(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Workflow.IncidentPerAlert = true
+	alert := template.Alert{Status: "firing", Labels: template.KV{"alertname": "InstanceDown", "instance": "web01"}, Fingerprint: "c4d5e6f7a8b9c0d1"}
+	groups := splitByAlert([]tableGroup{{tableName: "incident", data: template.Data{Alerts: template.Alerts{alert}}}})
+	if key := getGroupKey(context.Background(), groups[0].data); key != alert.Fingerprint {
+		t.Errorf("The alert should be correlated by its fingerprint: got %v", key)
+	}
+
+	currentConfig().Workflow.CorrelationKey = CorrelationKeyConfig{IncludeLabels: []string{"instance"}}
+	if key := getGroupKey(context.Background(), groups[0].data); key != hashLabels(template.KV{"instance": "web01"}) {
+		t.Errorf("The alert should be correlated by the labels of the correlation key: got %v", key)
+	}
+}
+
+func TestOnAlertGroup_IncidentPerAlert(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Workflow.IncidentPerAlert = true
+	dedupStore = newMemoryDedupStore()
+	web01 := template.Alert{Status: "firing", Labels: template.KV{"alertname": "InstanceDown", "instance": "web01"}}
+	web02 := template.Alert{Status: "firing", Labels: template.KV{"alertname": "InstanceDown", "instance": "web02"}}
+	keyField := currentConfig().Workflow.IncidentGroupKeyField
+
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "incident", map[string]string{keyField: hashLabels(web01.Labels)}).Return([]Incident{Incident{"sys_id": "1", "number": "INC1", "state": "1"}}, nil)
+	snClientMock.On("GetIncidents", "incident", map[string]string{keyField: hashLabels(web02.Labels)}).Return([]Incident{}, nil)
+	snClientMock.On("UpdateIncident", "incident", mock.Anything, "1").Return(Incident{}, nil)
+	snClientMock.On("CreateIncident", "incident", mock.Anything).Return(Incident{"sys_id": "2", "number": "INC2"}, nil)
+
+	data := template.Data{
+		Status:      "firing",
+		GroupLabels: template.KV{"alertname": "InstanceDown"},
+		Alerts:      template.Alerts{web01, web02},
+	}
+	if err := onAlertGroup(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+
+	snClientMock.AssertNumberOfCalls(t, "UpdateIncident", 1)
+	snClientMock.AssertNumberOfCalls(t, "CreateIncident", 1)
+	for _, call := range snClientMock.Calls {
+		if call.Method == "CreateIncident" && call.Arguments.Get(1).(Incident)[keyField] != hashLabels(web02.Labels) {
+			t.Errorf("Incident should be created for the web02 alert, got %v", call.Arguments.Get(1))
+		}
+	}
+}
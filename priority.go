@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const priorityField = "priority"
+
+var ignoredPriorities = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Name: "webhook_incident_priority_ignored_total",
+		Help: "Total number of incidents created with a priority other than the mapped one, the instance computing it from the impact and the urgency.",
+	},
+)
+
+// mapsPriority returns whether the severity mapping or the business hours rules set the priority
+func (c Config) mapsPriority() bool {
+	var levels []SeverityLevel
+	if c.SeverityMapping.enabled() {
+		levels = append(levels, c.SeverityMapping.Default)
+		for _, level := range c.SeverityMapping.Levels {
+			levels = append(levels, level)
+		}
+	}
+	for _, rule := range c.BusinessHours.Rules {
+		levels = append(levels, rule.BusinessHours, rule.OffHours)
+	}
+	for _, level := range levels {
+		if len(level.Priority) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPriority warns when the instance ignored the priority sent on the creation of the incident, as the instances
+// computing the priority from the impact and the urgency do not allow to set it directly
+func checkPriority(ctx context.Context, sent Incident, created Incident) {
+	priority, ok := sent[priorityField]
+	if !ok || created == nil {
+		return
+	}
+	got, ok := created[priorityField]
+	if !ok || fmt.Sprint(got) == fmt.Sprint(priority) {
+		return
+	}
+	ignoredPriorities.Inc()
+	loggerFrom(ctx).Warnf("Priority %v of incident %s was ignored by the instance, which set it to %v: map the impact and the urgency instead", priority, created.GetNumber(), got)
+}
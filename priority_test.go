@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestValidateSchema_ReadOnlyPriority(t *testing.T) {
+	ts := newDictionaryServer(t)
+	defer ts.Close()
+	loadSchemaTestConfig(t, ts)
+	currentConfig().SeverityMapping = SeverityMappingConfig{Levels: map[string]SeverityLevel{"critical": {Impact: "1", Urgency: "1", Priority: "1"}}}
+
+	err := validateSchema(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "field priority is read-only") {
+		t.Errorf("Expected a read-only priority error, got %v", err)
+	}
+}
+
+func TestCreateIncident_IgnoredPriority(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("CreateIncident", "incident", mock.Anything).Return(Incident{"sys_id": "1", "number": "INC1", priorityField: "4"}, nil).Once()
+	snClientMock.On("CreateIncident", "incident", mock.Anything).Return(Incident{"sys_id": "2", "number": "INC2", priorityField: "1"}, nil).Once()
+
+	before := testutil.ToFloat64(ignoredPriorities)
+	createIncident(context.Background(), "incident", Incident{priorityField: "1"})
+	if got := testutil.ToFloat64(ignoredPriorities) - before; got != 1 {
+		t.Errorf("The ignored priority should be counted: got %v", got)
+	}
+	createIncident(context.Background(), "incident", Incident{priorityField: "1"})
+	if got := testutil.ToFloat64(ignoredPriorities) - before; got != 1 {
+		t.Errorf("The kept priority should not be counted: got %v", got)
+	}
+}
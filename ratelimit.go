@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var serviceNowRateLimitWait = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Name: "servicenow_rate_limit_wait_seconds_total",
+		Help: "Total time spent by the ServiceNow requests waiting for the outbound rate limiter.",
+	},
+)
+
+// RateLimitConfig - Outbound rate limiting of the ServiceNow requests, with a token bucket
+type RateLimitConfig struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst"`
+}
+
+func (c RateLimitConfig) validate(errs *strings.Builder) {
+	if c.RequestsPerSecond < 0 {
+		errs.WriteString("service_now.rate_limit.requests_per_second must not be negative\n")
+	}
+	if c.Burst < 0 {
+		errs.WriteString("service_now.rate_limit.burst must not be negative\n")
+	}
+}
+
+func (c RateLimitConfig) enabled() bool {
+	return c.RequestsPerSecond > 0
+}
+
+// burst defaults to one second of requests, and at least one request
+func (c RateLimitConfig) burst() int {
+	if c.Burst > 0 {
+		return c.Burst
+	}
+	return int(math.Max(1, math.Ceil(c.RequestsPerSecond)))
+}
+
+// tokenBucket allows rate requests per second on average, and bursts of up to burst requests
+type tokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+func newTokenBucket(c RateLimitConfig) *tokenBucket {
+	return &tokenBucket{rate: c.RequestsPerSecond, burst: float64(c.burst()), tokens: float64(c.burst()), last: time.Now(), now: time.Now}
+}
+
+// reserve takes a token, and returns how long to wait before using it. Tokens are borrowed in advance when the bucket
+// is empty, so that the waiting requests are served in order.
+func (b *tokenBucket) reserve() time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := b.now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel gives back a reserved token which was not used
+func (b *tokenBucket) cancel() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.tokens = math.Min(b.burst, b.tokens+1)
+}
+
+// wait waits for a token, or until the context is done
+func (b *tokenBucket) wait(ctx context.Context) error {
+	delay := b.reserve()
+	if delay <= 0 {
+		return nil
+	}
+	start := time.Now()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	defer func() { serviceNowRateLimitWait.Add(time.Since(start).Seconds()) }()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		b.cancel()
+		return ctx.Err()
+	}
+}
+
+// rateLimitedTransport waits for the token bucket before each request, including the retries and the OAuth2 token
+// requests
+type rateLimitedTransport struct {
+	next   http.RoundTripper
+	bucket *tokenBucket
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.bucket.wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return t.next.RoundTrip(req)
+}
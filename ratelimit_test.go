@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket_Reserve(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(RateLimitConfig{RequestsPerSecond: 2, Burst: 2})
+	b.now = func() time.Time { return now }
+	b.last = now
+
+	expected := []time.Duration{0, 0, 500 * time.Millisecond, time.Second}
+	for i, want := range expected {
+		if delay := b.reserve(); delay != want {
+			t.Errorf("Unexpected delay of request %d: got %v, want %v", i, delay, want)
+		}
+	}
+
+	// The borrowed tokens are paid back before new ones are available
+	now = now.Add(time.Second)
+	if delay := b.reserve(); delay != 500*time.Millisecond {
+		t.Errorf("Unexpected delay after refill: got %v, want %v", delay, 500*time.Millisecond)
+	}
+	now = now.Add(10 * time.Second)
+	if delay := b.reserve(); delay != 0 {
+		t.Errorf("Unexpected delay once refilled: got %v", delay)
+	}
+	if b.tokens != 1 {
+		t.Errorf("Tokens should not exceed the burst: got %v tokens left", b.tokens)
+	}
+}
+
+func TestTokenBucket_WaitCancelled(t *testing.T) {
+	b := newTokenBucket(RateLimitConfig{RequestsPerSecond: 0.001})
+	if err := b.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b.wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if b.tokens < -0.01 {
+		t.Errorf("The token of the cancelled request should be given back, %v tokens left", b.tokens)
+	}
+}
+
+func TestNewHTTPClient_RateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	client, err := newHTTPClient(ServiceNowConfig{RateLimit: RateLimitConfig{RequestsPerSecond: 20, Burst: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("Requests should be rate limited, 3 requests took %v", elapsed)
+	}
+}
+
+func TestRateLimitConfig(t *testing.T) {
+	var errs strings.Builder
+	RateLimitConfig{RequestsPerSecond: -1, Burst: -1}.validate(&errs)
+	if !strings.Contains(errs.String(), "requests_per_second") || !strings.Contains(errs.String(), "burst") {
+		t.Errorf("Unexpected validation errors: %q", errs.String())
+	}
+	if burst := (RateLimitConfig{RequestsPerSecond: 2.5}).burst(); burst != 3 {
+		t.Errorf("Unexpected default burst: %d", burst)
+	}
+	if burst := (RateLimitConfig{RequestsPerSecond: 0.1}).burst(); burst != 1 {
+		t.Errorf("Unexpected default burst: %d", burst)
+	}
+}
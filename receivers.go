@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+const receiverPathPrefix = "/webhook/"
+
+type receiverContextKey struct{}
+
+// ReceiverConfig - Webhook receiver served on /webhook/<name>, and selected on /webhook by the Alertmanager receiver of
+// the notification, with its own target table, incident fields, field mappings, routes and alert filter
+type ReceiverConfig struct {
+	Instance        string            `yaml:"instance"`
+	TableName       string            `yaml:"table_name"`
+	DefaultIncident map[string]string `yaml:"default_incident"`
+	FieldMappings   map[string]string `yaml:"field_mappings"`
+	Routes          []RouteConfig     `yaml:"routes"`
+	// AlertmanagerReceivers holds the Alertmanager receivers whose notifications on /webhook are managed by the receiver
+	AlertmanagerReceivers []string `yaml:"alertmanager_receivers"`
+	// AlertFilter selects the alerts of the receiver, after the global alert_filter
+	AlertFilter AlertFilterConfig `yaml:"alert_filter"`
+}
+
+func validateReceivers(c Config, errs *strings.Builder) {
+	selected := map[string]string{}
+	for _, name := range c.receiverNames() {
+		for _, alertmanagerReceiver := range c.Receivers[name].AlertmanagerReceivers {
+			if other, ok := selected[alertmanagerReceiver]; ok {
+				errs.WriteString(fmt.Sprintf("receivers.%s: alertmanager_receivers %q is already selecting receivers.%s\n", name, alertmanagerReceiver, other))
+				continue
+			}
+			selected[alertmanagerReceiver] = name
+		}
+	}
+	for name, receiver := range c.Receivers {
+		if len(name) == 0 || strings.ContainsAny(name, "/?#") {
+			errs.WriteString(fmt.Sprintf("receivers name %q must be a non-empty URL path segment\n", name))
+		}
+		var receiverErrs strings.Builder
+		if _, ok := c.Instances[receiver.Instance]; len(receiver.Instance) > 0 && !ok {
+			receiverErrs.WriteString(fmt.Sprintf("instance %q is not defined in instances\n", receiver.Instance))
+		}
+		if _, err := parseFieldMappings(receiver.FieldMappings); err != nil {
+			receiverErrs.WriteString(err.Error() + "\n")
+		}
+		validateRouteList(c, receiver.Routes, &receiverErrs)
+		receiver.AlertFilter.validate(&receiverErrs)
+		for _, err := range strings.SplitAfter(receiverErrs.String(), "\n") {
+			if len(err) > 0 {
+				errs.WriteString(fmt.Sprintf("receivers.%s: %s", name, err))
+			}
+		}
+	}
+}
+
+// receiverNames returns the names of the webhook receivers, sorted
+func (c Config) receiverNames() []string {
+	names := make([]string, 0, len(c.Receivers))
+	for name := range c.Receivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// withReceiver returns a context managing the incidents with the config of the named webhook receiver
+func withReceiver(ctx context.Context, name string) context.Context {
+	if len(name) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, receiverContextKey{}, name)
+}
+
+// selectReceiver returns a context managing the alert group of a /webhook notification with the webhook receiver whose
+// alertmanager_receivers holds the Alertmanager receiver of the notification, if any
+func selectReceiver(ctx context.Context, data template.Data) context.Context {
+	config := configFrom(ctx)
+	if len(receiverFrom(ctx)) > 0 {
+		return ctx
+	}
+	for _, name := range config.receiverNames() {
+		for _, alertmanagerReceiver := range config.Receivers[name].AlertmanagerReceivers {
+			if alertmanagerReceiver == data.Receiver {
+				loggerFrom(ctx).Debugf("Alert group of the %s Alertmanager receiver managed by the %s webhook receiver", data.Receiver, name)
+				return withReceiver(ctx, name)
+			}
+		}
+	}
+	return ctx
+}
+
+// receiverFrom returns the name of the webhook receiver of the context, empty for /webhook
+func receiverFrom(ctx context.Context) string {
+	name, _ := ctx.Value(receiverContextKey{}).(string)
+	return name
+}
+
+// routingFrom returns the routing of the alert groups of the webhook receiver of the context. A receiver without
+// routes uses the global ones, and its table defaults to the table of its ServiceNow instance.
+func routingFrom(ctx context.Context) routing {
+	snapshot := configSnapshotFrom(ctx)
+	config := &snapshot.config
+	defaults := routing{routes: config.Routes, matchers: snapshot.routeMatchers, tableName: config.ServiceNow.TableName}
+	receiver, ok := config.Receivers[receiverFrom(ctx)]
+	if !ok {
+		return defaults
+	}
+	r := routing{routes: receiver.Routes, matchers: snapshot.routeMatchers, instance: receiver.Instance, tableName: receiver.TableName}
+	if r.routes == nil {
+		r.routes = defaults.routes
+	}
+	if len(r.tableName) == 0 {
+		r.tableName = instanceTableName(*config, receiver.Instance)
+	}
+	return r
+}
+
+// instanceTableName returns the table of the named ServiceNow instance, the default one when empty
+func instanceTableName(c Config, instance string) string {
+	if len(instance) > 0 {
+		return c.Instances[instance].TableName
+	}
+	return c.ServiceNow.TableName
+}
+
+// receiverWebhook serves the webhook of the receiver named by the last segment of the path
+func receiverWebhook(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, receiverPathPrefix)
+	if len(name) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	webhook(w, r.WithContext(withReceiver(r.Context(), name)))
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/mock"
+)
+
+func loadReceiversTestConfig() {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Workflow.IncidentPerAlert = false
+	currentConfig().Receivers = map[string]ReceiverConfig{
+		"payments": {
+			TableName:       "u_payments_incident",
+			DefaultIncident: map[string]string{"short_description": "Payments: {{ .CommonLabels.alertname }}"},
+			FieldMappings:   map[string]string{"u_service": "label:service"},
+		},
+		"databases": {
+			Routes: []RouteConfig{{Match: map[string]string{"itsm_process": "change"}, TableName: "change_request"}},
+		},
+	}
+	applyConfig()
+	dedupStore = newMemoryDedupStore()
+}
+
+const receiverNotification = `{
+  "status": "firing",
+  "groupLabels": {"alertname": "CheckoutErrors"},
+  "commonLabels": {"alertname": "CheckoutErrors", "service": "checkout"},
+  "alerts": [{"status": "firing", "labels": {"alertname": "CheckoutErrors", "service": "checkout"}}]
+}`
+
+func TestReceiverWebhook(t *testing.T) {
+	loadReceiversTestConfig()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "u_payments_incident", mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", "u_payments_incident", mock.MatchedBy(func(incident Incident) bool {
+		return incident["short_description"] == "Payments: CheckoutErrors" && incident["u_service"] == "checkout"
+	})).Return(Incident{"sys_id": "1", "number": "INC1"}, nil)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(receiverWebhook).ServeHTTP(rr, httptest.NewRequest("POST", "/webhook/payments", strings.NewReader(receiverNotification)))
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Wrong status code: got %v, want %v", rr.Code, http.StatusOK)
+	}
+	snClientMock.AssertExpectations(t)
+}
+
+func TestReceiverWebhook_DefaultConfig(t *testing.T) {
+	loadReceiversTestConfig()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "incident", mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", "incident", mock.MatchedBy(func(incident Incident) bool {
+		return incident["short_description"] != "Payments: CheckoutErrors" && incident["u_service"] == nil
+	})).Return(Incident{"sys_id": "1", "number": "INC1"}, nil)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(webhook).ServeHTTP(rr, httptest.NewRequest("POST", "/webhook", strings.NewReader(receiverNotification)))
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Wrong status code: got %v, want %v", rr.Code, http.StatusOK)
+	}
+	snClientMock.AssertExpectations(t)
+}
+
+func TestReceiverWebhook_NotFound(t *testing.T) {
+	loadReceiversTestConfig()
+	for _, path := range []string{"/webhook/unknown", "/webhook/"} {
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(receiverWebhook).ServeHTTP(rr, httptest.NewRequest("POST", path, strings.NewReader(receiverNotification)))
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Wrong status code for %s: got %v, want %v", path, rr.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestRouteAlertGroup_Receiver(t *testing.T) {
+	loadReceiversTestConfig()
+	currentConfig().Routes = []RouteConfig{{Match: map[string]string{"itsm_process": "change"}, TableName: "problem"}}
+	data := template.Data{Alerts: template.Alerts{template.Alert{Labels: template.KV{"itsm_process": "change"}}}}
+
+	groups := routeAlertGroup(withReceiver(context.Background(), "databases"), data)
+	if len(groups) != 1 || groups[0].tableName != "change_request" {
+		t.Errorf("The alerts should be routed with the routes of the receiver: %+v", groups)
+	}
+	groups = routeAlertGroup(withReceiver(context.Background(), "payments"), data)
+	if len(groups) != 1 || groups[0].tableName != "problem" {
+		t.Errorf("A receiver without routes should use the global ones: %+v", groups)
+	}
+	data.Alerts[0].Labels = template.KV{}
+	groups = routeAlertGroup(withReceiver(context.Background(), "payments"), data)
+	if len(groups) != 1 || groups[0].tableName != "u_payments_incident" {
+		t.Errorf("The alerts matching no route should use the table of the receiver: %+v", groups)
+	}
+}
+
+func TestDeadLetterQueue_ReplayReceiver(t *testing.T) {
+	loadReceiversTestConfig()
+	currentConfig().Webhook.ErrorStatusCodes.Permanent = http.StatusBadRequest
+	q, cleanup := newTestDeadLetterQueue(t)
+	defer cleanup()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "u_payments_incident", mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", "u_payments_incident", mock.Anything).Return(Incident{}, &httpStatusError{statusCode: http.StatusBadRequest, message: "Error"}).Once()
+	snClientMock.On("CreateIncident", "u_payments_incident", mock.Anything).Return(Incident{"sys_id": "1", "number": "INC1"}, nil)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(receiverWebhook).ServeHTTP(rr, httptest.NewRequest("POST", "/webhook/payments", strings.NewReader(receiverNotification)))
+	ids, err := q.list()
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("The failed alert group should be dead-lettered: %v, %v", ids, err)
+	}
+	if summaries, _ := q.summaries(context.Background()); len(summaries) != 1 || summaries[0].WebhookReceiver != "payments" {
+		t.Errorf("The dead-letter should record its webhook receiver: %+v", summaries)
+	}
+	if err := q.replay(context.Background(), ids[0]); err != nil {
+		t.Errorf("The dead-letter should be replayed with the config of its webhook receiver: %v", err)
+	}
+	snClientMock.AssertExpectations(t)
+}
+
+func TestValidateReceivers(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Receivers = map[string]ReceiverConfig{
+		"team": {
+			Instance:      "unknown",
+			FieldMappings: map[string]string{"u_service": "service"},
+			Routes:        []RouteConfig{{TableName: "problem"}},
+		},
+		"a/b": {},
+	}
+	var errs strings.Builder
+	validateReceivers(*currentConfig(), &errs)
+	for _, want := range []string{
+		`receivers.team: instance "unknown" is not defined in instances`,
+		"receivers.team: field_mappings",
+		"receivers.team: routes[0] needs match labels or matchers",
+		`receivers name "a/b"`,
+	} {
+		if !strings.Contains(errs.String(), want) {
+			t.Errorf("Missing validation error %q: %q", want, errs.String())
+		}
+	}
+}
+
+func TestOnAlertGroup_AlertmanagerReceiver(t *testing.T) {
+	loadReceiversTestConfig()
+	payments := currentConfig().Receivers["payments"]
+	payments.AlertmanagerReceivers = []string{"payments-team"}
+	payments.AlertFilter = AlertFilterConfig{Exclude: []string{`severity="info"`}}
+	currentConfig().Receivers["payments"] = payments
+	applyConfig()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "u_payments_incident", mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", "u_payments_incident", mock.Anything).Return(Incident{"sys_id": "1", "number": "INC1"}, nil)
+	data := template.Data{
+		Receiver:     "payments-team",
+		Status:       "firing",
+		GroupLabels:  template.KV{"alertname": "CheckoutErrors"},
+		CommonLabels: template.KV{"alertname": "CheckoutErrors"},
+		Alerts: template.Alerts{
+			template.Alert{Status: "firing", Labels: template.KV{"alertname": "CheckoutErrors", "severity": "critical"}},
+			template.Alert{Status: "firing", Labels: template.KV{"alertname": "CheckoutErrors", "severity": "info"}},
+		},
+	}
+
+	if err := onAlertGroup(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+	if incident := snClientMock.Calls[1].Arguments.Get(1).(Incident); incident["short_description"] != "Payments: CheckoutErrors" {
+		t.Errorf("The alert group should be managed by the selected receiver: %v", incident)
+	}
+
+	data.Alerts = data.Alerts[1:]
+	snClientMock.Calls = nil
+	if err := onAlertGroup(context.Background(), data); err != nil || len(snClientMock.Calls) > 0 {
+		t.Errorf("The alerts should be dropped by the alert filter of the receiver: %v, %v", err, snClientMock.Calls)
+	}
+
+	snClientMock.On("GetIncidents", "incident", mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", "incident", mock.Anything).Return(Incident{"sys_id": "2", "number": "INC2"}, nil)
+	if err := onAlertGroup(withReceiver(context.Background(), "databases"), data); err != nil {
+		t.Fatal(err)
+	}
+	snClientMock.AssertCalled(t, "CreateIncident", "incident", mock.Anything)
+}
+
+func TestValidateReceivers_AlertmanagerReceivers(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Receivers = map[string]ReceiverConfig{
+		"a": {AlertmanagerReceivers: []string{"team"}},
+		"b": {AlertmanagerReceivers: []string{"team"}, AlertFilter: AlertFilterConfig{Include: []string{"severity=~("}}},
+	}
+	var errs strings.Builder
+	validateReceivers(*currentConfig(), &errs)
+	for _, want := range []string{`receivers.b: alertmanager_receivers "team" is already selecting receivers.a`, "receivers.b: alert_filter.include matcher"} {
+		if !strings.Contains(errs.String(), want) {
+			t.Errorf("Missing validation error %q: %q", want, errs.String())
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	defaultReconciliationInterval = 5 * time.Minute
+	defaultReconciliationMaxAge   = 24 * time.Hour
+)
+
+var reconciledIncidents = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "webhook_reconciled_incidents_total",
+		Help: "Total number of incidents of firing alert groups found deleted or closed out of band in ServiceNow, by change.",
+	},
+	[]string{"change"},
+)
+
+// ReconciliationConfig - Periodic check of the incidents of the firing alert groups, detecting the incidents closed,
+// merged or deleted out of band in ServiceNow
+type ReconciliationConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"`
+	MaxAge   time.Duration `yaml:"max_age"`
+	Recreate bool          `yaml:"recreate"`
+}
+
+func (c ReconciliationConfig) interval() time.Duration {
+	if c.Interval <= 0 {
+		return defaultReconciliationInterval
+	}
+	return c.Interval
+}
+
+func (c ReconciliationConfig) maxAge() time.Duration {
+	if c.MaxAge <= 0 {
+		return defaultReconciliationMaxAge
+	}
+	return c.MaxAge
+}
+
+// trackedIncident is the incident of a firing alert group, with the last notification of the alert group and the
+// Alertmanager silence of the alert group once the incident is acknowledged
+type trackedIncident struct {
+	group         tableGroup
+	sysID         string
+	number        string
+	notifiedAt    time.Time
+	silenceID     string
+	silencedUntil time.Time
+}
+
+// incidentTracker holds the incidents of the firing alert groups, by deduplication key
+type incidentTracker struct {
+	mutex     sync.Mutex
+	incidents map[string]trackedIncident
+}
+
+var trackedIncidents = &incidentTracker{incidents: map[string]trackedIncident{}}
+
+// trackIncident tracks the incident of the firing alert group, when the reconciliation is enabled
+func trackIncident(ctx context.Context, tableName string, key string, data template.Data, incident Incident) {
+	config := configFrom(ctx)
+	if !config.Workflow.Reconciliation.Enabled || isDryRun(ctx) || incident == nil || len(incident.GetSysID()) == 0 {
+		return
+	}
+	trackedIncidents.mutex.Lock()
+	defer trackedIncidents.mutex.Unlock()
+	tracked := trackedIncident{
+		group:      tableGroup{instance: instanceFrom(ctx), tableName: tableName, dedup: true, data: data},
+		sysID:      incident.GetSysID(),
+		number:     incident.GetNumber(),
+		notifiedAt: time.Now(),
+	}
+	if previous, ok := trackedIncidents.incidents[key]; ok && previous.sysID == tracked.sysID {
+		tracked.silenceID, tracked.silencedUntil = previous.silenceID, previous.silencedUntil
+	}
+	trackedIncidents.incidents[key] = tracked
+}
+
+// untrackIncident stops tracking the incident of the resolved alert group
+func untrackIncident(ctx context.Context, key string) {
+	trackedIncidents.mutex.Lock()
+	defer trackedIncidents.mutex.Unlock()
+	delete(trackedIncidents.incidents, key)
+}
+
+// forget stops tracking the incident of the deduplication key, unless the key tracks another incident meanwhile
+func (t *incidentTracker) forget(key string, sysID string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if tracked, ok := t.incidents[key]; !ok || tracked.sysID != sysID {
+		return false
+	}
+	delete(t.incidents, key)
+	return true
+}
+
+// silenced records the Alertmanager silence of the alert group of the tracked incident, unless the key tracks another
+// incident meanwhile
+func (t *incidentTracker) silenced(key string, sysID string, silenceID string, until time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if tracked, ok := t.incidents[key]; ok && tracked.sysID == sysID {
+		tracked.silenceID, tracked.silencedUntil = silenceID, until
+		t.incidents[key] = tracked
+	}
+}
+
+// find returns the tracked incidents of the sys_id, by deduplication key
+func (t *incidentTracker) find(sysID string) map[string]trackedIncident {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	incidents := map[string]trackedIncident{}
+	for key, tracked := range t.incidents {
+		if tracked.sysID == sysID {
+			incidents[key] = tracked
+		}
+	}
+	return incidents
+}
+
+func (t *incidentTracker) snapshot() map[string]trackedIncident {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	incidents := make(map[string]trackedIncident, len(t.incidents))
+	for key, tracked := range t.incidents {
+		incidents[key] = tracked
+	}
+	return incidents
+}
+
+// reconcileIncidents looks up the tracked incidents in ServiceNow. The incidents deleted, or in a no update state,
+// are not tracked anymore and released from the deduplication store, so that the next notification of their alert
+// group does not update them. They are replaced right away when recreate is enabled. The alert groups of the
+// acknowledged incidents are silenced in Alertmanager when alertmanager.silences is enabled. The incidents of the
+// alert groups not notified for max_age are considered resolved, and not tracked anymore.
+func reconcileIncidents(ctx context.Context, c ReconciliationConfig) {
+	for key, tracked := range trackedIncidents.snapshot() {
+		if time.Since(tracked.notifiedAt) > c.maxAge() {
+			trackedIncidents.forget(key, tracked.sysID)
+			continue
+		}
+		ctx := withInstance(ctx, tracked.group.instance)
+		incidents, err := serviceNowFrom(ctx).GetIncidents(ctx, tracked.group.tableName, map[string]string{
+			"sys_id":         tracked.sysID,
+			"sysparm_fields": "sys_id,number,state",
+		})
+		if err != nil {
+			serviceNowError.Inc()
+			loggerFrom(ctx).Errorf("Error reconciling incident %s: %v", tracked.number, err)
+			continue
+		}
+
+		change := "closed"
+		if len(incidents) == 0 {
+			change = "deleted"
+		} else if !tableNoUpdateStates(ctx, tracked.group.tableName)[incidents[0].GetState()] {
+			silenceAcknowledgedIncident(ctx, key, tracked, incidents[0], time.Now())
+			continue
+		}
+		releaseIncident(ctx, c, key, tracked, change)
+	}
+}
+
+// releaseIncident stops tracking the incident changed out of band, and releases it from the deduplication store. The
+// incident of the still firing alert group is replaced right away when recreate is enabled. It returns false when the
+// incident is not tracked anymore.
+func releaseIncident(ctx context.Context, c ReconciliationConfig, key string, tracked trackedIncident, change string) bool {
+	if !trackedIncidents.forget(key, tracked.sysID) {
+		return false
+	}
+	reconciledIncidents.WithLabelValues(change).Inc()
+	loggerFrom(ctx).Warnf("Incident %s of firing alert group key %s was %s out of band", tracked.number, getGroupKey(ctx, tracked.group.data), change)
+	if sysID, err := dedupStore.Get(key); err != nil {
+		loggerFrom(ctx).Errorf("Error looking up the deduplicated incident of alert group key %s: %v", key, err)
+	} else if sysID == tracked.sysID {
+		if err := dedupStore.Delete(key); err != nil {
+			loggerFrom(ctx).Errorf("Error releasing the deduplicated incident of alert group key %s: %v", key, err)
+		}
+	}
+
+	if c.Recreate {
+		loggerFrom(ctx).Infof("Managing the incident of the still firing alert group key %s again", getGroupKey(ctx, tracked.group.data))
+		if err := onTableAlertGroup(ctx, tracked.group); err != nil {
+			loggerFrom(ctx).Errorf("Error recreating the incident of alert group key %s: %v", getGroupKey(ctx, tracked.group.data), err)
+		}
+	}
+	return true
+}
+
+// startReconciliation starts the background task reconciling the tracked incidents
+func startReconciliation(config Config) {
+	c := config.Workflow.Reconciliation
+	if !c.Enabled {
+		return
+	}
+	backgroundTasks.Go("incident reconciliation", func(ctx context.Context) {
+		ctx = withAuditCaller(ctx, auditCaller{Source: "incident reconciliation"})
+		runEvery(ctx, c.interval(), func() {
+			// The standby replicas track no incident, as they receive no notification
+			if !leader.isLeader() {
+				return
+			}
+			ctx := withConfigSnapshot(ctx)
+			reconcileIncidents(ctx, configFrom(ctx).Workflow.Reconciliation)
+		})
+	})
+}
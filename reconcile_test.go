@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestReconcileIncidents(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	dedupStore = newMemoryDedupStore()
+	trackedIncidents = &incidentTracker{incidents: map[string]trackedIncident{}}
+	currentConfig().Workflow.Reconciliation = ReconciliationConfig{Enabled: true}
+	data := template.Data{Status: "firing", GroupLabels: template.KV{"alertname": "DiskFull"}, Alerts: template.Alerts{{Status: "firing"}}}
+	trackIncident(context.Background(), "incident", "open", data, Incident{"sys_id": "1", "number": "INC1"})
+	trackIncident(context.Background(), "incident", "closed", data, Incident{"sys_id": "2", "number": "INC2"})
+	trackIncident(context.Background(), "incident", "deleted", data, Incident{"sys_id": "3", "number": "INC3"})
+	dedupStore.Set("closed", "2", time.Hour)
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "incident", map[string]string{"sys_id": "1", "sysparm_fields": "sys_id,number,state"}).Return([]Incident{{"sys_id": "1", "state": "2"}}, nil)
+	snClientMock.On("GetIncidents", "incident", map[string]string{"sys_id": "2", "sysparm_fields": "sys_id,number,state"}).Return([]Incident{{"sys_id": "2", "state": "7"}}, nil)
+	snClientMock.On("GetIncidents", "incident", map[string]string{"sys_id": "3", "sysparm_fields": "sys_id,number,state"}).Return([]Incident{}, nil)
+	before := testutil.ToFloat64(reconciledIncidents.WithLabelValues("closed"))
+
+	reconcileIncidents(context.Background(), currentConfig().Workflow.Reconciliation)
+	if _, ok := trackedIncidents.incidents["open"]; !ok || len(trackedIncidents.incidents) != 1 {
+		t.Errorf("Only the open incident should still be tracked: %v", trackedIncidents.incidents)
+	}
+	if sysID, _ := dedupStore.Get("closed"); len(sysID) > 0 {
+		t.Errorf("The closed incident should be released from the deduplication store")
+	}
+	if got := testutil.ToFloat64(reconciledIncidents.WithLabelValues("closed")) - before; got != 1 {
+		t.Errorf("The closed incident should be counted: got %v", got)
+	}
+
+	trackedIncidents.incidents["open"] = trackedIncident{sysID: "1", notifiedAt: time.Now().Add(-48 * time.Hour)}
+	reconcileIncidents(context.Background(), currentConfig().Workflow.Reconciliation)
+	if len(trackedIncidents.incidents) != 0 {
+		t.Errorf("The incident of the alert group not notified for max_age should not be tracked anymore")
+	}
+}
+
+func TestReconcileIncidents_Recreate(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	dedupStore = newMemoryDedupStore()
+	trackedIncidents = &incidentTracker{incidents: map[string]trackedIncident{}}
+	currentConfig().Workflow.IncidentPerAlert = false
+	currentConfig().Workflow.Reconciliation = ReconciliationConfig{Enabled: true, Recreate: true}
+	data := template.Data{Status: "firing", GroupLabels: template.KV{"alertname": "DiskFull"}, Alerts: template.Alerts{{Status: "firing"}}}
+	key := dedupKey(context.Background(), "incident", getGroupKey(context.Background(), data))
+	trackIncident(context.Background(), "incident", key, data, Incident{"sys_id": "1", "number": "INC1"})
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "incident", map[string]string{"sys_id": "1", "sysparm_fields": "sys_id,number,state"}).Return([]Incident{{"sys_id": "1", "state": "7"}}, nil)
+	snClientMock.On("GetIncidents", "incident", mock.Anything).Return([]Incident{{"sys_id": "1", "number": "INC1", "state": "7"}}, nil)
+	snClientMock.On("CreateIncident", "incident", mock.Anything).Return(Incident{"sys_id": "2", "number": "INC2"}, nil)
+
+	reconcileIncidents(context.Background(), currentConfig().Workflow.Reconciliation)
+	snClientMock.AssertCalled(t, "CreateIncident", "incident", mock.Anything)
+	if tracked := trackedIncidents.incidents[key]; tracked.sysID != "2" {
+		t.Errorf("The recreated incident should be tracked: %v", tracked)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// enabled returns whether a Redis server, or Redis Sentinel monitored servers, are configured
+func (c RedisConfig) enabled() bool {
+	return len(c.Addr) > 0 || len(c.SentinelAddrs) > 0
+}
+
+func (c RedisConfig) validate(prefix string, errs *strings.Builder) {
+	if len(c.Addr) > 0 && len(c.SentinelAddrs) > 0 {
+		errs.WriteString(fmt.Sprintf("%s.addr and %s.sentinel_addrs cannot be set together\n", prefix, prefix))
+	}
+	if (len(c.SentinelAddrs) == 0) != (len(c.SentinelMasterName) == 0) {
+		errs.WriteString(fmt.Sprintf("%s.sentinel_addrs and %s.sentinel_master_name must be set together\n", prefix, prefix))
+	}
+	if c.TLSConfig == nil {
+		return
+	}
+	var tlsErrs strings.Builder
+	c.TLSConfig.validate(&tlsErrs)
+	for _, err := range strings.SplitAfter(tlsErrs.String(), "\n") {
+		if len(err) > 0 {
+			errs.WriteString(prefix + "." + err)
+		}
+	}
+}
+
+func (c RedisConfig) keyPrefix() string {
+	if len(c.KeyPrefix) == 0 {
+		return defaultRedisKeyPrefix
+	}
+	return c.KeyPrefix
+}
+
+// address describes the Redis server for the logs, without its credentials
+func (c RedisConfig) address() string {
+	if len(c.SentinelAddrs) > 0 {
+		return fmt.Sprintf("master %s of the Redis Sentinels %s", c.SentinelMasterName, strings.Join(c.SentinelAddrs, ","))
+	}
+	return c.Addr
+}
+
+// newRedisClient returns the client of the Redis server, or of the master elected by the Redis Sentinels, so that
+// the state shared by the replicas survives the failover of the Redis server
+func newRedisClient(c RedisConfig) (*redis.Client, error) {
+	var tlsConfig *tls.Config
+	if c.TLSConfig != nil {
+		var err error
+		if tlsConfig, err = newTLSConfig(*c.TLSConfig); err != nil {
+			return nil, fmt.Errorf("error loading the Redis TLS config: %v", err)
+		}
+	}
+
+	if len(c.SentinelAddrs) > 0 {
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       c.SentinelMasterName,
+			SentinelAddrs:    c.SentinelAddrs,
+			SentinelPassword: c.SentinelPassword,
+			Password:         c.Password,
+			DB:               c.DB,
+			TLSConfig:        tlsConfig,
+		}), nil
+	}
+	return redis.NewClient(&redis.Options{
+		Addr:      c.Addr,
+		Password:  c.Password,
+		DB:        c.DB,
+		TLSConfig: tlsConfig,
+	}), nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// serveTLSProxy serves the Redis server over TLS, with a self-signed certificate written to the directory
+func serveTLSProxy(t *testing.T, dir string, addr string) (net.Listener, string) {
+	certFile, keyFile, _ := writeSelfSignedCert(t, dir)
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				backend, err := net.Dial("tcp", addr)
+				if err != nil {
+					return
+				}
+				defer backend.Close()
+				go io.Copy(backend, conn)
+				io.Copy(conn, backend)
+			}()
+		}
+	}()
+	return l, certFile
+}
+
+func TestNewRedisClient_TLS(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	dir, err := ioutil.TempDir("", "redis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	l, certFile := serveTLSProxy(t, dir, s.Addr())
+	defer l.Close()
+
+	store, err := newRedisDedupStore(RedisConfig{Addr: l.Addr().String(), TLSConfig: &TLSConfig{CAFile: certFile}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("key", "1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := s.Get(defaultRedisKeyPrefix + "key"); value != "1" {
+		t.Errorf("The key should be stored over TLS: got %q", value)
+	}
+
+	if _, err := newRedisDedupStore(RedisConfig{Addr: l.Addr().String(), TLSConfig: &TLSConfig{}}); err == nil {
+		t.Errorf("The untrusted certificate of the Redis server should be rejected")
+	}
+	if _, err := newRedisClient(RedisConfig{Addr: l.Addr().String(), TLSConfig: &TLSConfig{CAFile: dir}}); err == nil {
+		t.Errorf("An invalid CA file should be reported")
+	}
+}
+
+// serveFakeSentinel answers the Redis Sentinel requests with the address of the master
+func serveFakeSentinel(t *testing.T, masterName string, masterAddr string) net.Listener {
+	host, port, _ := net.SplitHostPort(masterAddr)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveFakeSentinelConn(conn, masterName, host, port)
+		}
+	}()
+	return l
+}
+
+func serveFakeSentinelConn(conn net.Conn, masterName string, host string, port string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readRESPArray(r)
+		if err != nil {
+			return
+		}
+		command := strings.ToLower(strings.Join(args, " "))
+		switch {
+		case command == "sentinel get-master-addr-by-name "+strings.ToLower(masterName):
+			fmt.Fprintf(conn, "*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(host), host, len(port), port)
+		case strings.HasPrefix(command, "sentinel sentinels"):
+			fmt.Fprint(conn, "*0\r\n")
+		case strings.HasPrefix(command, "psubscribe"):
+			fmt.Fprintf(conn, "*3\r\n$10\r\npsubscribe\r\n$%d\r\n%s\r\n:1\r\n", len(args[1]), args[1])
+		default:
+			fmt.Fprint(conn, "-ERR unknown command\r\n")
+		}
+	}
+}
+
+func readRESPArray(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	args := make([]string, n)
+	for i := range args {
+		if _, err := r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(arg, "\r\n")
+	}
+	return args, nil
+}
+
+func TestNewRedisClient_Sentinel(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	l := serveFakeSentinel(t, "mymaster", s.Addr())
+	defer l.Close()
+
+	store, err := newRedisDedupStore(RedisConfig{SentinelMasterName: "mymaster", SentinelAddrs: []string{l.Addr().String()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("key", "1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := s.Get(defaultRedisKeyPrefix + "key"); value != "1" {
+		t.Errorf("The key should be stored on the master elected by the sentinels: got %q", value)
+	}
+}
+
+func TestLoadDedupStore_Sentinel(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	l := serveFakeSentinel(t, "mymaster", s.Addr())
+	defer l.Close()
+
+	loadConfig("config/servicenow_example.yml")
+	defer func() { dedupStore = newMemoryDedupStore() }()
+	currentConfig().Dedup.Redis = RedisConfig{SentinelMasterName: "mymaster", SentinelAddrs: []string{l.Addr().String()}}
+	store, err := loadDedupStore(*currentConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.(*redisDedupStore); !ok {
+		t.Errorf("Unexpected store type: got %T, want *redisDedupStore", store)
+	}
+}
+
+func TestRedisConfig_Validate(t *testing.T) {
+	var errs strings.Builder
+	RedisConfig{Addr: "redis:6379", SentinelAddrs: []string{"sentinel:26379"}}.validate("dedup.redis", &errs)
+	RedisConfig{TLSConfig: &TLSConfig{CertFile: "cert.pem"}}.validate("ha.redis", &errs)
+	for _, want := range []string{
+		"dedup.redis.addr and dedup.redis.sentinel_addrs cannot be set together",
+		"dedup.redis.sentinel_addrs and dedup.redis.sentinel_master_name must be set together",
+		"ha.redis.tls_config.cert_file",
+	} {
+		if !strings.Contains(errs.String(), want) {
+			t.Errorf("Missing validation error %q: %q", want, errs.String())
+		}
+	}
+
+	errs.Reset()
+	RedisConfig{SentinelMasterName: "mymaster", SentinelAddrs: []string{"sentinel:26379"}, TLSConfig: &TLSConfig{}}.validate("dedup.redis", &errs)
+	if errs.Len() > 0 {
+		t.Errorf("Unexpected validation errors: %q", errs.String())
+	}
+}
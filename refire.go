@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	refirePolicyCreate = "create"
+	refirePolicyReopen = "reopen"
+	descriptionField   = "description"
+)
+
+// RefireConfig - Incident management when an alert group fires again after its incident was resolved or closed
+type RefireConfig struct {
+	Policy           string        `yaml:"policy"`
+	ReopenState      string        `yaml:"reopen_state"`
+	ReopenFromStates []json.Number `yaml:"reopen_from_states"`
+}
+
+func (c RefireConfig) validate(noUpdateStates []json.Number, errs *strings.Builder) {
+	switch c.Policy {
+	case "", refirePolicyCreate:
+	case refirePolicyReopen:
+		if len(c.ReopenState) == 0 {
+			errs.WriteString("refire.reopen_state is missing, it is required by the reopen policy\n")
+		}
+		for _, s := range noUpdateStates {
+			if s.String() == c.ReopenState {
+				errs.WriteString("refire.reopen_state must not be one of the no_update_states\n")
+			}
+		}
+	default:
+		errs.WriteString(fmt.Sprintf("refire.policy must be one of %q or %q\n", refirePolicyCreate, refirePolicyReopen))
+	}
+}
+
+// canReopen returns whether the incident can be reopened from its state
+func (c RefireConfig) canReopen(incident Incident) bool {
+	if c.Policy != refirePolicyReopen {
+		return false
+	}
+	if len(c.ReopenFromStates) == 0 {
+		return true
+	}
+	for _, s := range c.ReopenFromStates {
+		if s == incident.GetState() {
+			return true
+		}
+	}
+	return false
+}
+
+// previousIncident returns the newest of the existing incidents of the alert group, none of them being updatable
+func previousIncident(existingIncidents []Incident) Incident {
+	if len(existingIncidents) == 0 {
+		return nil
+	}
+	sorted := sortByCreation(existingIncidents)
+	return sorted[len(sorted)-1]
+}
+
+// onRefiringGroup handles the firing alert group whose previous incident was resolved or closed, according to the
+// refire policy: the previous incident is reopened when allowed, otherwise the incident about to be created references
+// it in its description. It returns whether the previous incident was reopened.
+func onRefiringGroup(ctx context.Context, tableName string, previous Incident, incidentCreateParam Incident, incidentUpdateParam Incident) bool {
+	config := configFrom(ctx)
+	c := config.Workflow.Refire
+	if len(c.Policy) == 0 || previous == nil {
+		return false
+	}
+
+	if c.canReopen(previous) {
+		reopenParam := Incident{}
+		for field, value := range incidentUpdateParam {
+			reopenParam[field] = value
+		}
+		reopenParam["state"] = c.ReopenState
+		reopenParam[workNotesField] = "Reopened as its alert group fired again."
+		state, err := transitionIncident(ctx, tableName, previous, reopenParam)
+		if err == nil {
+			_, err = serviceNowFrom(ctx).UpdateIncident(ctx, tableName, reopenParam, previous.GetSysID())
+			countIncidentOperation(ctx, tableName, incidentUpdated, err)
+			err = intermediateStateError(err, previous, state)
+		}
+		if err == nil {
+			recordIncident(ctx, previous)
+			loggerFrom(ctx).Infof("Incident %s reopened, with state %s", previous.GetNumber(), c.ReopenState)
+			return true
+		}
+		serviceNowError.Inc()
+		loggerFrom(ctx).Warnf("Error reopening incident %s, a new incident will be created: %v", previous.GetNumber(), err)
+	}
+
+	reference := fmt.Sprintf("Alert group fired again after the resolution of incident %s.", previous.GetNumber())
+	if description, ok := incidentCreateParam[descriptionField].(string); ok && len(description) > 0 {
+		reference = description + "\n\n" + reference
+	}
+	incidentCreateParam[descriptionField] = reference
+	return false
+}
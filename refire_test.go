@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/mock"
+)
+
+var refiringData = template.Data{
+	Status:      "firing",
+	GroupLabels: template.KV{"alertname": "DiskFull"},
+	Alerts:      template.Alerts{template.Alert{Status: "firing"}},
+}
+
+func resolvedIncidents() []Incident {
+	return []Incident{
+		Incident{"sys_id": "1", "number": "INC1", "state": "7", "sys_created_on": "2019-01-01 00:00:00"},
+		Incident{"sys_id": "2", "number": "INC2", "state": "6", "sys_created_on": "2019-01-02 00:00:00"},
+	}
+}
+
+func TestOnAlertGroup_RefireReopen(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	dedupStore = newMemoryDedupStore()
+	currentConfig().Workflow.Refire = RefireConfig{Policy: refirePolicyReopen, ReopenState: "2"}
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "incident", mock.Anything).Return(resolvedIncidents(), nil)
+	snClientMock.On("UpdateIncident", "incident", mock.Anything, "2").Return(Incident{}, nil)
+
+	if err := onAlertGroup(context.Background(), refiringData); err != nil {
+		t.Fatal(err)
+	}
+
+	snClientMock.AssertNotCalled(t, "CreateIncident", mock.Anything, mock.Anything)
+	incident := snClientMock.Calls[1].Arguments.Get(1).(Incident)
+	if incident["state"] != "2" || incident[workNotesField] == nil {
+		t.Errorf("Unexpected reopening: %v", incident)
+	}
+}
+
+func TestOnAlertGroup_RefireReopenFailure(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	dedupStore = newMemoryDedupStore()
+	currentConfig().Workflow.Refire = RefireConfig{Policy: refirePolicyReopen, ReopenState: "2"}
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "incident", mock.Anything).Return(resolvedIncidents(), nil)
+	snClientMock.On("UpdateIncident", "incident", mock.Anything, "2").Return(Incident{}, errors.New("closed incidents cannot be reopened"))
+	snClientMock.On("CreateIncident", "incident", mock.Anything).Return(Incident{"sys_id": "3", "number": "INC3"}, nil)
+
+	if err := onAlertGroup(context.Background(), refiringData); err != nil {
+		t.Fatal(err)
+	}
+
+	incident := snClientMock.Calls[2].Arguments.Get(1).(Incident)
+	if description, _ := incident[descriptionField].(string); !strings.HasSuffix(description, "after the resolution of incident INC2.") {
+		t.Errorf("Created incident should reference the previous one: %v", incident)
+	}
+}
+
+func TestOnAlertGroup_RefireCreate(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	dedupStore = newMemoryDedupStore()
+	currentConfig().Workflow.Refire = RefireConfig{Policy: refirePolicyReopen, ReopenState: "2", ReopenFromStates: []json.Number{"7"}}
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "incident", mock.Anything).Return(resolvedIncidents(), nil)
+	snClientMock.On("CreateIncident", "incident", mock.Anything).Return(Incident{"sys_id": "3", "number": "INC3"}, nil)
+
+	if err := onAlertGroup(context.Background(), refiringData); err != nil {
+		t.Fatal(err)
+	}
+
+	snClientMock.AssertNotCalled(t, "UpdateIncident", mock.Anything, mock.Anything, mock.Anything)
+	incident := snClientMock.Calls[1].Arguments.Get(1).(Incident)
+	if description, _ := incident[descriptionField].(string); !strings.Contains(description, "INC2") {
+		t.Errorf("Created incident should reference the previous one: %v", incident)
+	}
+}
+
+func TestRefireConfig_Validate(t *testing.T) {
+	var errs strings.Builder
+	RefireConfig{Policy: refirePolicyReopen, ReopenState: "6"}.validate([]json.Number{"6", "7"}, &errs)
+	RefireConfig{Policy: "other"}.validate(nil, &errs)
+	if !strings.Contains(errs.String(), "refire.reopen_state must not") || !strings.Contains(errs.String(), "refire.policy") {
+		t.Errorf("Unexpected validation errors: %q", errs.String())
+	}
+}
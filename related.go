@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+const (
+	defaultRelatedAlertsLookback  = time.Hour
+	defaultRelatedAlertsCacheSize = 1000
+	maxListedRelatedAlerts        = 20
+	relatedAlertsField            = "work_notes"
+)
+
+// recentAlerts caches the recently received firing alerts, nil when the related alerts are disabled
+var recentAlerts *recentAlertCache
+
+// RelatedAlertsConfig - Configuration of the summary of the recent related alerts added to the created incidents
+type RelatedAlertsConfig struct {
+	Label     string        `yaml:"label"`
+	Lookback  time.Duration `yaml:"lookback"`
+	CacheSize int           `yaml:"cache_size"`
+}
+
+type recentAlert struct {
+	alert template.Alert
+	seen  time.Time
+}
+
+// recentAlertCache is a bounded cache of the firing alerts received within the lookback, by fingerprint
+type recentAlertCache struct {
+	mutex    sync.Mutex
+	lookback time.Duration
+	size     int
+	alerts   map[string]recentAlert
+}
+
+func newRecentAlertCache(c RelatedAlertsConfig) *recentAlertCache {
+	lookback := c.Lookback
+	if lookback <= 0 {
+		lookback = defaultRelatedAlertsLookback
+	}
+	size := c.CacheSize
+	if size <= 0 {
+		size = defaultRelatedAlertsCacheSize
+	}
+	return &recentAlertCache{lookback: lookback, size: size, alerts: map[string]recentAlert{}}
+}
+
+func loadRecentAlerts(config Config) *recentAlertCache {
+	recentAlerts = nil
+	if len(config.RelatedAlerts.Label) > 0 {
+		recentAlerts = newRecentAlertCache(config.RelatedAlerts)
+	}
+	return recentAlerts
+}
+
+// record caches the firing alerts of the group, and forgets its resolved ones
+func (c *recentAlertCache) record(data template.Data, now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, alert := range data.Alerts {
+		fingerprint := alertFingerprint(alert)
+		if alert.Status == "resolved" {
+			delete(c.alerts, fingerprint)
+			continue
+		}
+		c.alerts[fingerprint] = recentAlert{alert: alert, seen: now}
+	}
+
+	// Evict the least recently seen alerts beyond the cache size
+	for len(c.alerts) > c.size {
+		var oldest string
+		for fingerprint, recent := range c.alerts {
+			if len(oldest) == 0 || recent.seen.Before(c.alerts[oldest].seen) {
+				oldest = fingerprint
+			}
+		}
+		delete(c.alerts, oldest)
+	}
+}
+
+// related returns the alerts seen within the lookback having one of the label values, but the excluded ones
+func (c *recentAlertCache) related(label string, values map[string]bool, excluded map[string]bool, now time.Time) []template.Alert {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var related []template.Alert
+	for fingerprint, recent := range c.alerts {
+		if excluded[fingerprint] || now.Sub(recent.seen) > c.lookback || !values[recent.alert.Labels[label]] {
+			continue
+		}
+		related = append(related, recent.alert)
+	}
+	sort.Slice(related, func(i, j int) bool {
+		return fmt.Sprintf("%v", related[i].Labels.SortedPairs()) < fmt.Sprintf("%v", related[j].Labels.SortedPairs())
+	})
+	return related
+}
+
+func (c *recentAlertCache) sweep() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	for fingerprint, recent := range c.alerts {
+		if now.Sub(recent.seen) > c.lookback {
+			delete(c.alerts, fingerprint)
+		}
+	}
+}
+
+// relatedAlertsSummary returns the summary of the recent alerts sharing the correlation label with the group,
+// or an empty string when there are none
+func relatedAlertsSummary(c *recentAlertCache, label string, data template.Data, now time.Time) string {
+	values := map[string]bool{}
+	excluded := map[string]bool{}
+	for _, alert := range data.Alerts {
+		if value := alert.Labels[label]; len(value) > 0 {
+			values[value] = true
+		}
+		excluded[alertFingerprint(alert)] = true
+	}
+	if len(values) == 0 {
+		return ""
+	}
+
+	related := c.related(label, values, excluded, now)
+	if len(related) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(values))
+	for value := range values {
+		names = append(names, value)
+	}
+	sort.Strings(names)
+
+	var summary strings.Builder
+	summary.WriteString(fmt.Sprintf("%d related firing alert(s) with %s %s:", len(related), label, strings.Join(names, ", ")))
+	for i, alert := range related {
+		if i == maxListedRelatedAlerts {
+			summary.WriteString(fmt.Sprintf("\n...and %d more", len(related)-maxListedRelatedAlerts))
+			break
+		}
+		summary.WriteString("\n- " + formatAlert(alert))
+	}
+	return summary.String()
+}
+
+// formatAlert formats the alert labels as a Prometheus series, e.g. Name{label="value"}
+func formatAlert(alert template.Alert) string {
+	var labels []string
+	for _, pair := range alert.Labels.SortedPairs() {
+		if pair.Name != "alertname" {
+			labels = append(labels, fmt.Sprintf("%s=%q", pair.Name, pair.Value))
+		}
+	}
+	return alert.Labels["alertname"] + "{" + strings.Join(labels, ", ") + "}"
+}
+
+// applyRelatedAlerts adds the summary of the recent related alerts to the work notes of the incident
+func applyRelatedAlerts(ctx context.Context, incident Incident, data template.Data) {
+	config := configFrom(ctx)
+	if recentAlerts == nil {
+		return
+	}
+
+	summary := relatedAlertsSummary(recentAlerts, config.RelatedAlerts.Label, data, time.Now())
+	if len(summary) == 0 {
+		return
+	}
+	loggerFrom(ctx).Infof("Adding the related alerts summary to the incident for alert group key: %s", getGroupKey(ctx, data))
+	if notes, ok := incident[relatedAlertsField].(string); ok && len(notes) > 0 {
+		summary = notes + "\n\n" + summary
+	}
+	incident[relatedAlertsField] = summary
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/mock"
+)
+
+func clusterAlert(status string, alertname string, cluster string) template.Alert {
+	return template.Alert{Status: status, Labels: template.KV{"alertname": alertname, "cluster": cluster}}
+}
+
+func seedRecentAlerts(now time.Time) *recentAlertCache {
+	cache := newRecentAlertCache(RelatedAlertsConfig{Label: "cluster", Lookback: time.Hour})
+	cache.record(template.Data{Alerts: template.Alerts{
+		clusterAlert("firing", "NodeDown", "prod-1"),
+		clusterAlert("firing", "DiskFull", "prod-1"),
+		clusterAlert("firing", "NodeDown", "prod-2"),
+	}}, now.Add(-10*time.Minute))
+	cache.record(template.Data{Alerts: template.Alerts{
+		clusterAlert("firing", "APIDown", "prod-1"),
+	}}, now.Add(-2*time.Hour))
+	return cache
+}
+
+func TestRelatedAlertsSummary(t *testing.T) {
+	now := time.Now()
+	cache := seedRecentAlerts(now)
+
+	data := template.Data{Alerts: template.Alerts{clusterAlert("firing", "HighLatency", "prod-1")}}
+	cache.record(data, now)
+
+	got := relatedAlertsSummary(cache, "cluster", data, now)
+	want := "2 related firing alert(s) with cluster prod-1:\n" +
+		"- DiskFull{cluster=\"prod-1\"}\n" +
+		"- NodeDown{cluster=\"prod-1\"}"
+	if got != want {
+		t.Errorf("Unexpected summary:\ngot  %q\nwant %q", got, want)
+	}
+}
+
+func TestRelatedAlertsSummary_None(t *testing.T) {
+	now := time.Now()
+	cache := seedRecentAlerts(now)
+
+	tests := []struct {
+		name string
+		data template.Data
+	}{
+		{name: "no_related_alert", data: template.Data{Alerts: template.Alerts{clusterAlert("firing", "HighLatency", "dev")}}},
+		{name: "no_label", data: template.Data{Alerts: template.Alerts{template.Alert{Labels: template.KV{"alertname": "HighLatency"}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := relatedAlertsSummary(cache, "cluster", tt.data, now); got != "" {
+				t.Errorf("Unexpected summary: %q", got)
+			}
+		})
+	}
+}
+
+func TestRecentAlertCache_Resolved(t *testing.T) {
+	now := time.Now()
+	cache := seedRecentAlerts(now)
+	cache.record(template.Data{Alerts: template.Alerts{clusterAlert("resolved", "DiskFull", "prod-1")}}, now)
+
+	data := template.Data{Alerts: template.Alerts{clusterAlert("firing", "HighLatency", "prod-1")}}
+	related := cache.related("cluster", map[string]bool{"prod-1": true}, map[string]bool{}, now)
+	if len(related) != 1 || related[0].Labels["alertname"] != "NodeDown" {
+		t.Errorf("Resolved alerts should be forgotten, got %v", related)
+	}
+	if got := relatedAlertsSummary(cache, "cluster", data, now); got != "1 related firing alert(s) with cluster prod-1:\n- NodeDown{cluster=\"prod-1\"}" {
+		t.Errorf("Unexpected summary: %q", got)
+	}
+}
+
+func TestRecentAlertCache_Bounded(t *testing.T) {
+	now := time.Now()
+	cache := newRecentAlertCache(RelatedAlertsConfig{CacheSize: 2})
+	cache.record(template.Data{Alerts: template.Alerts{clusterAlert("firing", "A", "prod-1")}}, now.Add(-3*time.Minute))
+	cache.record(template.Data{Alerts: template.Alerts{clusterAlert("firing", "B", "prod-1")}}, now.Add(-2*time.Minute))
+	cache.record(template.Data{Alerts: template.Alerts{clusterAlert("firing", "C", "prod-1")}}, now.Add(-time.Minute))
+
+	related := cache.related("cluster", map[string]bool{"prod-1": true}, map[string]bool{}, now)
+	if len(related) != 2 || related[0].Labels["alertname"] != "B" || related[1].Labels["alertname"] != "C" {
+		t.Errorf("Least recently seen alert should be evicted, got %v", related)
+	}
+}
+
+func TestRecentAlertCache_Sweep(t *testing.T) {
+	cache := seedRecentAlerts(time.Now())
+	cache.sweep()
+	if len(cache.alerts) != 3 {
+		t.Errorf("Alerts older than the lookback should be swept, got %d alerts", len(cache.alerts))
+	}
+}
+
+func TestOnAlertGroup_RelatedAlerts(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().RelatedAlerts = RelatedAlertsConfig{Label: "cluster"}
+	loadRecentAlerts(*currentConfig())
+	defer func() { recentAlerts = nil }()
+	dedupStore = newMemoryDedupStore()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "incident", mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", "incident", mock.Anything).Return(Incident{}, nil)
+
+	recentAlerts.record(template.Data{Alerts: template.Alerts{clusterAlert("firing", "NodeDown", "prod-1")}}, time.Now())
+	data := template.Data{
+		Status:      "firing",
+		GroupLabels: template.KV{"alertname": "HighLatency"},
+		Alerts:      template.Alerts{clusterAlert("firing", "HighLatency", "prod-1")},
+	}
+	if err := onAlertGroup(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+
+	incident := snClientMock.Calls[1].Arguments.Get(1).(Incident)
+	want := "1 related firing alert(s) with cluster prod-1:\n- NodeDown{cluster=\"prod-1\"}"
+	if incident[relatedAlertsField] != want {
+		t.Errorf("Unexpected work notes: got %q, want %q", incident[relatedAlertsField], want)
+	}
+}
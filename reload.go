@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/alertmanager/pkg/labels"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// currentSnapshot holds the *configSnapshot in use, swapped as a whole on reload
+	currentSnapshot atomic.Value
+	// snapshotMutex serializes the reloads and the Vault refreshes, each one building the next snapshot from the
+	// current one. The requests never take it.
+	snapshotMutex sync.Mutex
+	// emptySnapshot is the snapshot in use until a config is loaded
+	emptySnapshot = &configSnapshot{mapping: &incidentMapping{}}
+
+	configLastReloadSuccessful = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "webhook_config_last_reload_successful",
+			Help: "Whether the last configuration reload attempt was successful.",
+		},
+	)
+	configLastReloadSuccess = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "webhook_config_last_reload_success_timestamp_seconds",
+			Help: "Unix/epoch time of the last successful configuration reload.",
+		},
+	)
+)
+
+type configSnapshotContextKey struct{}
+
+// configSnapshot is an immutable snapshot of the loaded config, with the state derived from it and the ServiceNow
+// clients of its instances. It is never modified once published: a reload or a Vault refresh publishes a new one,
+// without waiting for the requests in flight, which keep the snapshot pinned in their context.
+type configSnapshot struct {
+	config               Config
+	status               configStatus
+	serviceNow           ServiceNow
+	serviceNowInstances  map[string]ServiceNow
+	noUpdateStates       map[json.Number]bool
+	incidentUpdateFields map[string]bool
+	mapping              *incidentMapping
+	alertFilter          *alertFilter
+	receiverAlertFilters map[string]*alertFilter
+	routeMatchers        map[string]*labels.Matcher
+	enricher             *enricher
+	inboundRateLimiter   *inboundLimiter
+}
+
+// newConfigSnapshot derives the internal state of the config. The ServiceNow clients and the inbound rate limiter of
+// the previous snapshot are kept.
+func newConfigSnapshot(c Config, status configStatus, previous *configSnapshot) *configSnapshot {
+	s := &configSnapshot{
+		config:              c,
+		status:              status,
+		serviceNow:          previous.serviceNow,
+		serviceNowInstances: previous.serviceNowInstances,
+	}
+	s.noUpdateStates = make(map[json.Number]bool, len(c.Workflow.NoUpdateStates))
+	for _, state := range c.Workflow.NoUpdateStates {
+		s.noUpdateStates[state] = true
+	}
+	s.incidentUpdateFields = make(map[string]bool, len(c.Workflow.IncidentUpdateFields))
+	for _, f := range c.Workflow.IncidentUpdateFields {
+		s.incidentUpdateFields[f] = true
+	}
+	s.mapping = newIncidentMapping(c)
+	s.alertFilter, s.receiverAlertFilters = newAlertFilters(c)
+	s.routeMatchers = newRouteMatchers(c)
+	s.enricher = newConfigEnricher(c.Enrichment)
+	s.inboundRateLimiter = newInboundRateLimiter(c.Webhook.RateLimit, previous.inboundRateLimiter)
+	loadLookupCaches(c)
+	return s
+}
+
+// withServiceNow returns a copy of the snapshot with the ServiceNow credentials of the config and their clients
+func (s *configSnapshot) withServiceNow(c Config, client ServiceNow, instances map[string]ServiceNow) *configSnapshot {
+	next := *s
+	next.config = c
+	next.serviceNow = client
+	next.serviceNowInstances = instances
+	return &next
+}
+
+// currentConfigSnapshot returns the snapshot in use, which must not be modified
+func currentConfigSnapshot() *configSnapshot {
+	s, _ := currentSnapshot.Load().(*configSnapshot)
+	if s == nil {
+		return emptySnapshot
+	}
+	return s
+}
+
+// currentConfig returns the config in use, which must not be modified
+func currentConfig() *Config {
+	return &currentConfigSnapshot().config
+}
+
+// withConfigSnapshot returns a context pinning the snapshot in use, so that a reload does not change the config in the
+// middle of a request or of a background run
+func withConfigSnapshot(ctx context.Context) context.Context {
+	return context.WithValue(ctx, configSnapshotContextKey{}, currentConfigSnapshot())
+}
+
+// configSnapshotFrom returns the snapshot pinned in the context, or else the one in use
+func configSnapshotFrom(ctx context.Context) *configSnapshot {
+	if s, ok := ctx.Value(configSnapshotContextKey{}).(*configSnapshot); ok {
+		return s
+	}
+	return currentConfigSnapshot()
+}
+
+// configFrom returns the config of the snapshot pinned in the context, which must not be modified
+func configFrom(ctx context.Context) *Config {
+	return &configSnapshotFrom(ctx).config
+}
+
+// reloadConfig re-reads and validates the config file, and builds its ServiceNow clients. The config, the ServiceNow
+// clients, the incident mapping and the enricher are swapped together, without waiting for the requests in flight.
+// An invalid config is rejected and the current one is kept. The other settings are only loaded at startup.
+func reloadConfig(configFile string) error {
+	err := loadReloadedConfig(configFile)
+	if err != nil {
+		configLastReloadSuccessful.Set(0)
+		return err
+	}
+	readiness.invalidate()
+	configLastReloadSuccessful.Set(1)
+	configLastReloadSuccess.SetToCurrentTime()
+	return nil
+}
+
+func loadReloadedConfig(configFile string) error {
+	configData, err := readConfigFile(configFile)
+	if err != nil {
+		return err
+	}
+	c, err := parseConfig(configData)
+	if err != nil {
+		return err
+	}
+	if err := resolveVaultCredentials(context.Background(), &c); err != nil {
+		return err
+	}
+	client, err := newSnClient(c.ServiceNow)
+	if err != nil {
+		return fmt.Errorf("Error loading ServiceNow client: %v", err)
+	}
+	instances, err := newSnClients(c)
+	if err != nil {
+		return fmt.Errorf("Error loading ServiceNow client: %v", err)
+	}
+
+	snapshotMutex.Lock()
+	defer snapshotMutex.Unlock()
+	s := newConfigSnapshot(c, newConfigStatus(configData, time.Now()), currentConfigSnapshot())
+	s.serviceNow = client
+	s.serviceNowInstances = instances
+	currentSnapshot.Store(s)
+	baseLogger.Info("ServiceNow config loaded")
+	return nil
+}
+
+// reload is the handler of /-/reload, reloading the config on POST requests
+func reload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		http.Error(w, "Only POST or PUT requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !currentConfig().Webhook.authenticate(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := reloadConfig(*configFile); err != nil {
+		baseLogger.Errorf("Error reloading the config, the current one is kept: %v", err)
+		http.Error(w, fmt.Sprintf("Error reloading the config: %v", err), http.StatusInternalServerError)
+		return
+	}
+	baseLogger.Info("Config reloaded")
+}
+
+// startConfigReload starts the background task reloading the config file on SIGHUP
+func startConfigReload(configFile string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	backgroundTasks.Go("config reload", func(ctx context.Context) {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-signals:
+				if err := reloadConfig(configFile); err != nil {
+					baseLogger.Errorf("Error reloading the config, the current one is kept: %v", err)
+					continue
+				}
+				baseLogger.Info("Config reloaded")
+			}
+		}
+	})
+}
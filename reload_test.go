@@ -0,0 +1,156 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/mock"
+)
+
+func writeReloadConfig(t *testing.T, content string) string {
+	file, err := ioutil.TempFile("", "servicenow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(content)
+	file.Close()
+	return file.Name()
+}
+
+const reloadedConfig = `
+service_now:
+ instance_name: "reloaded"
+ user_name: "SA"
+ password: "SA!"
+workflow:
+ incident_group_key_field: "u_other_reference_1"
+default_incident:
+ short_description: "reloaded {{ .CommonLabels.alertname }}"
+`
+
+func TestReloadConfig(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	useServiceNow(new(MockedSnClient))
+	configFile := writeReloadConfig(t, reloadedConfig)
+	defer os.Remove(configFile)
+
+	if err := reloadConfig(configFile); err != nil {
+		t.Fatal(err)
+	}
+	if currentConfig().ServiceNow.InstanceName != "reloaded" {
+		t.Errorf("Config not reloaded: %v", currentConfig().ServiceNow)
+	}
+	client, ok := currentConfigSnapshot().serviceNow.(*ServiceNowClient)
+	if !ok || client.baseURL != "https://reloaded.service-now.com" {
+		t.Errorf("ServiceNow client not rebuilt: %v", currentConfigSnapshot().serviceNow)
+	}
+	if fields := currentConfigSnapshot().mapping.fields("incident"); len(fields) != 1 || fields[0].field != "short_description" {
+		t.Errorf("Incident mapping not reloaded: %v", fields)
+	}
+	if testutil.ToFloat64(configLastReloadSuccessful) != 1 {
+		t.Errorf("Reload should be reported successful")
+	}
+}
+
+func TestReloadConfig_Invalid(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	mapping := currentConfigSnapshot().mapping
+	configFile := writeReloadConfig(t, `
+service_now:
+ instance_name: "reloaded"
+workflow:
+ incident_group_key_field: "u_other_reference_1"
+default_incident:
+ short_description: "{{ .Unclosed"
+`)
+	defer os.Remove(configFile)
+
+	if err := reloadConfig(configFile); err == nil {
+		t.Fatal("Invalid config should be rejected")
+	}
+	if currentConfig().ServiceNow.InstanceName == "reloaded" || currentConfigSnapshot().serviceNow != snClientMock || currentConfigSnapshot().mapping != mapping {
+		t.Errorf("Invalid config should keep the current one")
+	}
+	if testutil.ToFloat64(configLastReloadSuccessful) != 0 {
+		t.Errorf("Reload should be reported failed")
+	}
+}
+
+func TestReloadConfig_RequestInFlight(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	dedupStore = newMemoryDedupStore()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	started, release := make(chan struct{}), make(chan struct{})
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		close(started)
+		<-release
+	}).Return([]Incident{}, nil).Once()
+	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{"sys_id": "1", "number": "INC1"}, nil)
+	configFile := writeReloadConfig(t, reloadedConfig)
+	defer os.Remove(configFile)
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- postAlertGroup(t, "test/alertmanager_firing.json") }()
+	<-started
+	reloaded := make(chan error)
+	go func() { reloaded <- reloadConfig(configFile) }()
+	select {
+	case err := <-reloaded:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The reload should not wait for the request in flight")
+	}
+	close(release)
+
+	// The request in flight keeps the config and the ServiceNow client it started with
+	if rr := <-done; rr.Code != http.StatusOK {
+		t.Errorf("Wrong status code: got %v, want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	snClientMock.AssertNumberOfCalls(t, "CreateIncident", 1)
+	if currentConfig().ServiceNow.InstanceName != "reloaded" {
+		t.Errorf("Config not reloaded: %v", currentConfig().ServiceNow)
+	}
+}
+
+func TestReloadHandler(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	useServiceNow(new(MockedSnClient))
+	validFile := writeReloadConfig(t, reloadedConfig)
+	defer os.Remove(validFile)
+	invalidFile := writeReloadConfig(t, "service_now: [")
+	defer os.Remove(invalidFile)
+	defaultConfigFile := *configFile
+	defer func() { *configFile = defaultConfigFile }()
+
+	tests := []struct {
+		name       string
+		method     string
+		configFile string
+		wantStatus int
+	}{
+		{name: "get", method: "GET", configFile: validFile, wantStatus: http.StatusMethodNotAllowed},
+		{name: "post", method: "POST", configFile: validFile, wantStatus: http.StatusOK},
+		{name: "put", method: "PUT", configFile: validFile, wantStatus: http.StatusOK},
+		{name: "invalid", method: "POST", configFile: invalidFile, wantStatus: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*configFile = tt.configFile
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(reload).ServeHTTP(rr, httptest.NewRequest(tt.method, "/-/reload", nil))
+			if rr.Code != tt.wantStatus {
+				t.Errorf("reload() status = %v, want %v: %s", rr.Code, tt.wantStatus, rr.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// readArchivedNotification reads an entry of the archive
+func readArchivedNotification(path string) (archivedNotification, error) {
+	var entry archivedNotification
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return entry, err
+	}
+	if err := json.Unmarshal(content, &entry); err != nil {
+		return entry, fmt.Errorf("invalid archived notification: %v", err)
+	}
+	if err := validateNotification(entry.Notification); err != nil {
+		return entry, err
+	}
+	return entry, nil
+}
+
+// replayArchivedNotification processes the notification of the archive entry again, as its webhook receiver
+func replayArchivedNotification(ctx context.Context, path string) error {
+	entry, err := readArchivedNotification(path)
+	if err != nil {
+		return err
+	}
+	ctx = withConfigSnapshot(ctx)
+	caller := auditCaller{Source: "replay", RequestID: entry.RequestID, Receiver: entry.Notification.Receiver, GroupKey: getGroupKey(ctx, entry.Notification)}
+	ctx = withReceiver(withAuditCaller(withLogger(ctx, newRequestLogger(ctx, entry.RequestID, entry.Notification)), caller), entry.WebhookReceiver)
+	if *dryRun {
+		ctx = withDryRun(ctx)
+	}
+	return onAlertGroup(ctx, entry.Notification)
+}
+
+// runReplay processes the archived notifications of the files again, through the pipeline of the webhook configured
+// by the config file, and returns the exit status of the replay command: non-zero when any replay failed
+func runReplay(w io.Writer, paths []string) int {
+	status := 0
+	for _, path := range paths {
+		if err := replayArchivedNotification(context.Background(), path); err != nil {
+			fmt.Fprintf(w, "%s: %v\n", path, err)
+			status = 1
+			continue
+		}
+		fmt.Fprintf(w, "%s: replayed\n", path)
+	}
+	return status
+}
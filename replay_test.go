@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRunReplay(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	dedupStore = newMemoryDedupStore()
+	directory, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	entry := archivedNotification{ReceivedAt: time.Now(), RequestID: "id", Notification: template.Data{
+		Status:      "firing",
+		GroupLabels: template.KV{"alertname": "DiskFull"},
+		Alerts:      template.Alerts{{Status: "firing", Labels: template.KV{"alertname": "DiskFull"}}},
+	}}
+	archive := &directoryArchive{directory: directory}
+	content, _ := json.Marshal(entry)
+	archive.put(context.Background(), "entry.json", content)
+	ioutil.WriteFile(filepath.Join(directory, "invalid.json"), []byte(`{"notification": {}}`), 0600)
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "incident", mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", "incident", mock.Anything).Return(Incident{"sys_id": "1", "number": "INC1"}, nil)
+
+	var out bytes.Buffer
+	status := runReplay(&out, []string{filepath.Join(directory, "entry.json"), filepath.Join(directory, "invalid.json")})
+	if status != 1 {
+		t.Errorf("The replay should fail as an entry is invalid: %v", status)
+	}
+	if !strings.Contains(out.String(), "entry.json: replayed") || !strings.Contains(out.String(), "invalid.json: invalid Alertmanager notification") {
+		t.Errorf("Unexpected output: %s", out.String())
+	}
+	snClientMock.AssertCalled(t, "CreateIncident", "incident", mock.Anything)
+}
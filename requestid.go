@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// RequestIDConfig - Recording of the request ID of the webhook delivery creating or updating an incident, so that the
+// incident can be traced back to the delivery, its log lines and its audit log entries
+type RequestIDConfig struct {
+	Field    string `yaml:"field"`
+	WorkNote bool   `yaml:"work_note"`
+}
+
+// requestIDFrom returns the request ID of the webhook delivery of the context, empty outside of a delivery
+func requestIDFrom(ctx context.Context) string {
+	return auditCallerFrom(ctx).RequestID
+}
+
+// applyRequestID sets the request ID of the webhook delivery on the field of the incident, and adds it as a timestamped
+// work note, before the other work notes, when configured
+func applyRequestID(ctx context.Context, incident Incident, now time.Time) {
+	config := configFrom(ctx)
+	c := config.RequestID
+	id := requestIDFrom(ctx)
+	if len(id) == 0 {
+		return
+	}
+	if len(c.Field) > 0 {
+		incident[c.Field] = id
+	}
+	if c.WorkNote {
+		text := fmt.Sprintf("%s - Alertmanager notification request ID: %s", now.UTC().Format(workNoteTimeLayout), id)
+		if existing, ok := incident[workNotesField].(string); ok && len(existing) > 0 {
+			text += "\n\n" + existing
+		}
+		incident[workNotesField] = text
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+func TestApplyRequestID(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().RequestID = RequestIDConfig{Field: "correlation_display", WorkNote: true}
+	ctx := withAuditCaller(context.Background(), auditCaller{RequestID: "request-1"})
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	incident := Incident{workNotesField: "Existing"}
+	applyRequestID(ctx, incident, now)
+	if incident["correlation_display"] != "request-1" || incident[workNotesField] != "2024-01-02 15:04:05 UTC - Alertmanager notification request ID: request-1\n\nExisting" {
+		t.Errorf("Unexpected request ID fields: %v", incident)
+	}
+	incident = Incident{}
+	applyRequestID(context.Background(), incident, now)
+	if len(incident) > 0 {
+		t.Errorf("The incident should be left untouched outside of a delivery: %v", incident)
+	}
+}
+
+func TestWebhook_RequestID(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().RequestID = RequestIDConfig{Field: "correlation_display"}
+	dedupStore = newMemoryDedupStore()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", mock.Anything, mock.MatchedBy(func(incident Incident) bool {
+		return incident["correlation_display"] == "request-1"
+	})).Return(Incident{"sys_id": "1", "number": "INC1"}, nil)
+
+	req := httptest.NewRequest("POST", "/webhook", strings.NewReader(twoAlertsNotification))
+	req.Header.Set(requestIDHeader, "request-1")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(webhook).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || rr.Header().Get(requestIDHeader) != "request-1" {
+		t.Errorf("The response should carry the request ID: got %v, %q", rr.Code, rr.Header().Get(requestIDHeader))
+	}
+	snClientMock.AssertExpectations(t)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+const (
+	requiredFieldDefault = "default"
+	requiredFieldReject  = "reject"
+)
+
+// RequiredFieldConfig - Incident field required on creation, and what to do when it is missing or empty
+type RequiredFieldConfig struct {
+	Policy  string `yaml:"policy"`
+	Default string `yaml:"default"`
+}
+
+// requiredFieldError is returned when required incident fields are missing or empty with the reject policy
+type requiredFieldError struct {
+	fields []string
+}
+
+func (e *requiredFieldError) Error() string {
+	return fmt.Sprintf("required incident field(s) %s missing or empty", strings.Join(e.fields, ", "))
+}
+
+func validateRequiredFields(c Config, errs *strings.Builder) {
+	for field, required := range c.RequiredFields {
+		switch required.Policy {
+		case "", requiredFieldDefault:
+			if len(required.Default) == 0 {
+				errs.WriteString(fmt.Sprintf("required_fields.%s.default is missing\n", field))
+			}
+		case requiredFieldReject:
+			if len(required.Default) > 0 {
+				errs.WriteString(fmt.Sprintf("required_fields.%s.default only applies to the %q policy\n", field, requiredFieldDefault))
+			}
+		default:
+			errs.WriteString(fmt.Sprintf("required_fields.%s.policy must be %q or %q\n", field, requiredFieldDefault, requiredFieldReject))
+		}
+	}
+}
+
+// requiredFieldsTemplates returns the templated default values of the required fields, by field
+func (c Config) requiredFieldsTemplates() map[string]string {
+	templates := make(map[string]string, len(c.RequiredFields))
+	for field, required := range c.RequiredFields {
+		templates[field] = required.Default
+	}
+	return templates
+}
+
+// applyRequiredFields sets the default value of the required fields missing or empty on the incident of a firing
+// alert group, or returns a requiredFieldError for the ones with the reject policy. The default values support Go
+// templating.
+func applyRequiredFields(ctx context.Context, required map[string]RequiredFieldConfig, incident Incident, data template.Data) error {
+	if data.Status != "firing" {
+		return nil
+	}
+	defaults := Incident{}
+	var rejected []string
+	for field, c := range required {
+		// A missing label or annotation is rendered as <no value>
+		if value, _ := incident[field].(string); len(strings.TrimSpace(value)) > 0 && value != "<no value>" {
+			continue
+		}
+		if c.Policy == requiredFieldReject {
+			rejected = append(rejected, field)
+		} else {
+			defaults[field] = c.Default
+		}
+	}
+	if len(rejected) > 0 {
+		sort.Strings(rejected)
+		return &requiredFieldError{fields: rejected}
+	}
+	applyIncidentTemplate(ctx, defaults, data)
+	for field, value := range defaults {
+		incident[field] = value
+	}
+	return nil
+}
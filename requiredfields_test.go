@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestApplyRequiredFields(t *testing.T) {
+	required := map[string]RequiredFieldConfig{
+		"assignment_group": {Default: "{{ .CommonLabels.team }}-support"},
+		"category":         {Default: "software"},
+	}
+	data := template.Data{Status: "firing", CommonLabels: template.KV{"team": "storage"}}
+	incident := Incident{"assignment_group": "<no value>", "category": "hardware"}
+	if err := applyRequiredFields(context.Background(), required, incident, data); err != nil {
+		t.Fatal(err)
+	}
+	if incident["assignment_group"] != "storage-support" || incident["category"] != "hardware" {
+		t.Errorf("Only the missing or empty fields should be defaulted: %v", incident)
+	}
+}
+
+func TestApplyRequiredFields_Reject(t *testing.T) {
+	required := map[string]RequiredFieldConfig{
+		"cmdb_ci":          {Policy: requiredFieldReject},
+		"assignment_group": {Policy: requiredFieldReject},
+	}
+	err := applyRequiredFields(context.Background(), required, Incident{}, template.Data{Status: "firing"})
+	if _, ok := err.(*requiredFieldError); !ok || err.Error() != "required incident field(s) assignment_group, cmdb_ci missing or empty" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := applyRequiredFields(context.Background(), required, Incident{}, template.Data{Status: "resolved"}); err != nil {
+		t.Errorf("The resolved alert groups should not be rejected: %v", err)
+	}
+}
+
+func TestWebhook_RequiredFieldRejected(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().RequiredFields = map[string]RequiredFieldConfig{"cmdb_ci": {Policy: requiredFieldReject}}
+	currentConfig().Webhook.ErrorStatusCodes.Validation = http.StatusBadRequest
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+
+	rr := postAlertGroup(t, "test/alertmanager_firing.json")
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "cmdb_ci missing or empty") {
+		t.Errorf("Unexpected response: got %v %q", rr.Code, rr.Body.String())
+	}
+	snClientMock.AssertNotCalled(t, "CreateIncident", mock.Anything, mock.Anything)
+}
+
+func TestValidateRequiredFields(t *testing.T) {
+	var errs strings.Builder
+	validateRequiredFields(Config{RequiredFields: map[string]RequiredFieldConfig{
+		"assignment_group": {},
+		"cmdb_ci":          {Policy: requiredFieldReject, Default: "server"},
+		"category":         {Policy: "ignore"},
+	}}, &errs)
+	for _, want := range []string{"required_fields.assignment_group.default is missing", "required_fields.cmdb_ci.default only applies", "required_fields.category.policy"} {
+		if !strings.Contains(errs.String(), want) {
+			t.Errorf("Missing validation error %q: %q", want, errs.String())
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+const (
+	closeCodeField  = "close_code"
+	closeNotesField = "close_notes"
+)
+
+// ResolveConfig - Incident resolution when its alert group is resolved
+type ResolveConfig struct {
+	State      string            `yaml:"state"`
+	CloseCode  string            `yaml:"close_code"`
+	CloseCodes []CloseCodeConfig `yaml:"close_codes"`
+	CloseNotes string            `yaml:"close_notes"`
+	AutoClose  AutoCloseConfig   `yaml:"auto_close"`
+}
+
+// CloseCodeConfig - Close code of the resolved alert groups matching all the labels
+type CloseCodeConfig struct {
+	Match     map[string]string `yaml:"match"`
+	CloseCode string            `yaml:"close_code"`
+}
+
+func (c ResolveConfig) validate(errs *strings.Builder) {
+	if len(c.State) == 0 && (len(c.CloseCode) > 0 || len(c.CloseCodes) > 0 || len(c.CloseNotes) > 0) {
+		errs.WriteString("resolve.state is missing, it is required by resolve.close_code, resolve.close_codes and resolve.close_notes\n")
+	}
+	c.AutoClose.validate(c.State, errs)
+	for i, closeCode := range c.CloseCodes {
+		if len(closeCode.Match) == 0 {
+			errs.WriteString(fmt.Sprintf("resolve.close_codes[%d].match is missing\n", i))
+		}
+		if len(closeCode.CloseCode) == 0 {
+			errs.WriteString(fmt.Sprintf("resolve.close_codes[%d].close_code is missing\n", i))
+		}
+	}
+}
+
+// templates returns the templated fields of the resolution, by name
+func (c ResolveConfig) templates() map[string]string {
+	templates := map[string]string{"close_code": c.CloseCode, "close_notes": c.CloseNotes}
+	for i, closeCode := range c.CloseCodes {
+		templates[fmt.Sprintf("close_codes[%d].close_code", i)] = closeCode.CloseCode
+	}
+	return templates
+}
+
+// closeCode returns the close code of the first close_codes entry matching the labels of the alert group (the common
+// ones, or else the ones of its first alert having them), or else the default close_code
+func (c ResolveConfig) closeCode(data template.Data) string {
+	for _, closeCode := range c.CloseCodes {
+		if closeCode.matches(data) {
+			return closeCode.CloseCode
+		}
+	}
+	return c.CloseCode
+}
+
+func (c CloseCodeConfig) matches(data template.Data) bool {
+	for name, value := range c.Match {
+		if (fieldMapping{source: fieldMappingLabel, name: name}).value(data) != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (c ResolveConfig) enabled() bool {
+	return len(c.State) > 0
+}
+
+// applyResolution sets the resolved state, the close code and the close notes on the update of the incident of a
+// resolved alert group. The close code and the close notes support Go templating.
+func applyResolution(ctx context.Context, tableName string, incident Incident, data template.Data) {
+	config := configFrom(ctx)
+	c := config.tableWorkflow(tableName).Resolve
+	if !c.enabled() {
+		return
+	}
+
+	resolution := Incident{"state": c.State}
+	if closeCode := c.closeCode(data); len(closeCode) > 0 {
+		resolution[closeCodeField] = closeCode
+	}
+	if len(c.CloseNotes) > 0 {
+		resolution[closeNotesField] = c.CloseNotes
+	}
+	applyIncidentTemplate(ctx, resolution, data)
+	applyResolvedAt(config.Timestamps, resolution, data)
+
+	for field, value := range resolution {
+		incident[field] = value
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestOnAlertGroup_Resolve(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Workflow.Resolve = ResolveConfig{State: "6", CloseCode: "Solved (Permanently)", CloseNotes: "Alert {{ .CommonLabels.alertname }} resolved"}
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "incident", mock.Anything).Return([]Incident{Incident{"sys_id": "1", "number": "INC1", "state": "2"}}, nil)
+	snClientMock.On("UpdateIncident", "incident", mock.Anything, "1").Return(Incident{}, nil)
+
+	data := template.Data{
+		Status:       "resolved",
+		GroupLabels:  template.KV{"alertname": "DiskFull"},
+		CommonLabels: template.KV{"alertname": "DiskFull"},
+		Alerts:       template.Alerts{template.Alert{Status: "resolved"}},
+	}
+	if err := onAlertGroup(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+
+	snClientMock.AssertNotCalled(t, "CreateIncident", mock.Anything, mock.Anything)
+	incident := snClientMock.Calls[1].Arguments.Get(1).(Incident)
+	if incident["state"] != "6" || incident[closeCodeField] != "Solved (Permanently)" || incident[closeNotesField] != "Alert DiskFull resolved" {
+		t.Errorf("Unexpected resolution: %v", incident)
+	}
+	if _, ok := incident["comments"]; !ok {
+		t.Errorf("Update fields should still be sent with the resolution: %v", incident)
+	}
+}
+
+func TestOnAlertGroup_Resolve_Firing(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Workflow.Resolve = ResolveConfig{State: "6", CloseCode: "Solved (Permanently)"}
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "incident", mock.Anything).Return([]Incident{Incident{"sys_id": "1", "number": "INC1", "state": "2"}}, nil)
+	snClientMock.On("UpdateIncident", "incident", mock.Anything, "1").Return(Incident{}, nil)
+
+	data := template.Data{
+		Status:      "firing",
+		GroupLabels: template.KV{"alertname": "DiskFull"},
+		Alerts:      template.Alerts{template.Alert{Status: "firing"}},
+	}
+	if err := onAlertGroup(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+
+	incident := snClientMock.Calls[1].Arguments.Get(1).(Incident)
+	if _, ok := incident[closeCodeField]; ok {
+		t.Errorf("Firing alert group should not resolve the incident: %v", incident)
+	}
+}
+
+func TestResolveConfig_Validate(t *testing.T) {
+	var errs strings.Builder
+	ResolveConfig{CloseCode: "Solved"}.validate(&errs)
+	if !strings.Contains(errs.String(), "resolve.state") {
+		t.Errorf("Unexpected validation errors: %q", errs.String())
+	}
+	errs.Reset()
+	ResolveConfig{State: "6", CloseCodes: []CloseCodeConfig{{}}}.validate(&errs)
+	for _, want := range []string{"resolve.close_codes[0].match", "resolve.close_codes[0].close_code"} {
+		if !strings.Contains(errs.String(), want) {
+			t.Errorf("Missing validation error %q: %q", want, errs.String())
+		}
+	}
+}
+
+func TestApplyResolution_CloseCodes(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Workflow.Resolve = ResolveConfig{
+		State:      "6",
+		CloseCode:  "Solved (Permanently)",
+		CloseCodes: []CloseCodeConfig{{Match: map[string]string{"remediation": "automatic"}, CloseCode: "Solved ({{ .Labels.remediation }})"}},
+		CloseNotes: "{{ .Labels.alertname }} resolved after {{ .Duration | humanizeDuration }}: {{ .Annotations.summary }}",
+	}
+	start := time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)
+	data := template.Data{
+		Status: "resolved",
+		Alerts: template.Alerts{template.Alert{
+			Status:      "resolved",
+			Labels:      template.KV{"alertname": "DiskFull", "remediation": "automatic"},
+			Annotations: template.KV{"summary": "Disk cleaned up"},
+			StartsAt:    start,
+			EndsAt:      start.Add(90 * time.Minute),
+		}},
+	}
+
+	incident := Incident{}
+	applyResolution(context.Background(), "incident", incident, data)
+	if incident[closeCodeField] != "Solved (automatic)" || incident[closeNotesField] != "DiskFull resolved after 1h 30m 0s: Disk cleaned up" {
+		t.Errorf("Unexpected resolution: %v", incident)
+	}
+	data.Alerts[0].Labels = template.KV{"alertname": "DiskFull"}
+	incident = Incident{}
+	applyResolution(context.Background(), "incident", incident, data)
+	if incident[closeCodeField] != "Solved (Permanently)" {
+		t.Errorf("The close_code should be the default one: %v", incident)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	responseFormatJSON = "json"
+	responseFormatXML  = "xml"
+)
+
+var (
+	responseFormatMediaTypes = map[string]string{
+		"application/json": responseFormatJSON,
+		"application/xml":  responseFormatXML,
+		"text/xml":         responseFormatXML,
+	}
+	// defaultResponseMediaTypes are the media types of the formats, when the Accept header names none
+	defaultResponseMediaTypes = map[string]string{
+		responseFormatJSON: "application/json",
+		responseFormatXML:  "application/xml",
+	}
+)
+
+// WebhookConfig - Webhook endpoint configuration
+type WebhookConfig struct {
+	ResponseFormat    string          `yaml:"response_format"`
+	DefaultRetryAfter time.Duration   `yaml:"default_retry_after"`
+	MaxRetryAfter     time.Duration   `yaml:"max_retry_after"`
+	BasicAuth         BasicAuthConfig `yaml:"basic_auth"`
+	BearerToken       string          `yaml:"bearer_token"`
+	// PartialFailureStatus is the status code of the responses to the notifications whose alerts partially failed
+	PartialFailureStatus int `yaml:"partial_failure_status"`
+	// Idempotency skips the deliveries retried by Alertmanager although they were processed, e.g. after a timeout
+	Idempotency IdempotencyConfig `yaml:"idempotency"`
+	// Signature rejects the requests whose body is not signed with the shared secret
+	Signature SignatureConfig `yaml:"signature"`
+	// SourceIPs rejects the requests coming from outside the allowed CIDR blocks
+	SourceIPs SourceIPConfig `yaml:"source_ips"`
+	// ErrorStatusCodes are the status codes of the responses to the failed notifications, by error class
+	ErrorStatusCodes ErrorStatusCodesConfig `yaml:"error_status_codes"`
+	// RateLimit rejects the requests exceeding the inbound rate limit with a 429
+	RateLimit InboundRateLimitConfig `yaml:"rate_limit"`
+}
+
+func (c WebhookConfig) validate(errs *strings.Builder) {
+	switch c.ResponseFormat {
+	case "", responseFormatJSON, responseFormatXML:
+	default:
+		errs.WriteString(fmt.Sprintf("webhook.response_format must be one of %q or %q\n", responseFormatJSON, responseFormatXML))
+	}
+	c.validateAuth(errs)
+	c.RateLimit.validate(errs)
+	validatePartialFailureStatus(c.PartialFailureStatus, errs)
+	c.Idempotency.validate(errs)
+	c.Signature.validate(errs)
+	c.SourceIPs.validate(errs)
+	c.ErrorStatusCodes.validate(errs)
+}
+
+// negotiateResponseFormat returns the response format preferred by the Accept header with its media type, as declared
+// by the header, or the default format (JSON if not configured) when the header does not express a preference.
+func negotiateResponseFormat(accept string, defaultFormat string) (string, string) {
+	if len(defaultFormat) == 0 {
+		defaultFormat = responseFormatJSON
+	}
+
+	format, formatMediaType := defaultFormat, defaultResponseMediaTypes[defaultFormat]
+	bestQuality := -1.0
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+
+		candidate, ok := responseFormatMediaTypes[mediaType]
+		if !ok {
+			switch mediaType {
+			case "*/*", "application/*":
+				candidate, mediaType = defaultFormat, defaultResponseMediaTypes[defaultFormat]
+			case "text/*":
+				// XML is the only format with a text media type
+				candidate, mediaType = responseFormatXML, "text/xml"
+			default:
+				continue
+			}
+		}
+
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil || quality <= 0 {
+				continue
+			}
+		}
+		if quality > bestQuality {
+			format, formatMediaType = candidate, mediaType
+			bestQuality = quality
+		}
+	}
+	return format, formatMediaType
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiateResponseFormat(t *testing.T) {
+	tests := []struct {
+		name          string
+		accept        string
+		defaultFormat string
+		want          string
+		wantMediaType string
+	}{
+		{name: "no_accept", accept: "", defaultFormat: "", want: responseFormatJSON, wantMediaType: "application/json"},
+		{name: "no_accept_xml_default", accept: "", defaultFormat: responseFormatXML, want: responseFormatXML, wantMediaType: "application/xml"},
+		{name: "json", accept: "application/json", defaultFormat: responseFormatXML, want: responseFormatJSON, wantMediaType: "application/json"},
+		{name: "application_xml", accept: "application/xml", defaultFormat: "", want: responseFormatXML, wantMediaType: "application/xml"},
+		{name: "text_xml", accept: "text/xml; charset=utf-8", defaultFormat: "", want: responseFormatXML, wantMediaType: "text/xml"},
+		{name: "wildcard", accept: "*/*", defaultFormat: responseFormatXML, want: responseFormatXML, wantMediaType: "application/xml"},
+		{name: "application_wildcard", accept: "application/*", defaultFormat: "", want: responseFormatJSON, wantMediaType: "application/json"},
+		{name: "text_wildcard", accept: "text/*", defaultFormat: "", want: responseFormatXML, wantMediaType: "text/xml"},
+		{name: "quality", accept: "application/xml;q=0.5, application/json", defaultFormat: "", want: responseFormatJSON, wantMediaType: "application/json"},
+		{name: "not_acceptable", accept: "application/json;q=0, text/xml", defaultFormat: "", want: responseFormatXML, wantMediaType: "text/xml"},
+		{name: "unsupported", accept: "text/html", defaultFormat: "", want: responseFormatJSON, wantMediaType: "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, mediaType := negotiateResponseFormat(tt.accept, tt.defaultFormat); got != tt.want || mediaType != tt.wantMediaType {
+				t.Errorf("negotiateResponseFormat() = %v, %v, want %v, %v", got, mediaType, tt.want, tt.wantMediaType)
+			}
+		})
+	}
+}
+
+func TestSendResponse(t *testing.T) {
+	tests := []struct {
+		name            string
+		accept          string
+		wantContentType string
+		wantBody        string
+	}{
+		{
+			name:            "json",
+			accept:          "application/json",
+			wantContentType: "application/json; charset=utf-8",
+			wantBody:        `{"Status":200,"Message":"Success"}`,
+		},
+		{
+			name:            "xml",
+			accept:          "application/xml",
+			wantContentType: "application/xml; charset=utf-8",
+			wantBody:        `<response><Status>200</Status><Message>Success</Message></response>`,
+		},
+		{
+			name:            "text_xml",
+			accept:          "text/xml",
+			wantContentType: "text/xml; charset=utf-8",
+			wantBody:        `<response><Status>200</Status><Message>Success</Message></response>`,
+		},
+	}
+	loadConfig("config/servicenow_example.yml")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/webhook", nil)
+			req.Header.Set("Accept", tt.accept)
+			rr := httptest.NewRecorder()
+
+			sendResponse(rr, req, http.StatusOK, "Success")
+
+			if contentType := rr.Header().Get("Content-Type"); contentType != tt.wantContentType {
+				t.Errorf("Unexpected content type: got %v, want %v", contentType, tt.wantContentType)
+			}
+			if rr.Body.String() != tt.wantBody {
+				t.Errorf("Unexpected body: got %v, want %v", rr.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestWebhookHandler_BadRequest_XML(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Webhook.ResponseFormat = responseFormatXML
+
+	req := httptest.NewRequest("GET", "/webhook", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(webhook).ServeHTTP(rr, req)
+
+	want := `<response><Status>400</Status><Message>invalid Alertmanager notification: the request body is empty</Message></response>`
+	if rr.Body.String() != want {
+		t.Errorf("Unexpected body: got %v, want %v", rr.Body.String(), want)
+	}
+	if contentType := rr.Header().Get("Content-Type"); contentType != "application/xml; charset=utf-8" {
+		t.Errorf("Unexpected content type: got %v", contentType)
+	}
+}
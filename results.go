@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+const (
+	alertResultSuccess = "success"
+	alertResultFailed  = "failed"
+)
+
+// alertResult is the outcome of an alert in a table, reported in the webhook response along with its incident, when known
+type alertResult struct {
+	Fingerprint string
+	Table       string
+	Status      string
+	Incident    string `json:",omitempty" xml:",omitempty"`
+	SysID       string `json:",omitempty" xml:",omitempty"`
+	Error       string `json:",omitempty" xml:",omitempty"`
+}
+
+type alertResultsContextKey struct{}
+
+type incidentRefContextKey struct{}
+
+// incidentRef holds the number and sys_id of the incident created or updated for an alert group, and its state when known
+type incidentRef struct {
+	number string
+	sysID  string
+	state  string
+}
+
+// withIncidentRef returns a context recording the incident created or updated with it
+func withIncidentRef(ctx context.Context) (context.Context, *incidentRef) {
+	ref := &incidentRef{}
+	return context.WithValue(ctx, incidentRefContextKey{}, ref), ref
+}
+
+// recordIncident records the incident created or updated for the alert group of the context
+func recordIncident(ctx context.Context, incident Incident) {
+	ref, _ := ctx.Value(incidentRefContextKey{}).(*incidentRef)
+	if ref == nil || incident == nil {
+		return
+	}
+	// The incidents returned by ServiceNow do not always hold both fields
+	ref.number, _ = incident["number"].(string)
+	ref.sysID, _ = incident["sys_id"].(string)
+	ref.state, _ = incident["state"].(string)
+}
+
+// alertResults collects the outcome of each alert of a notification
+type alertResults struct {
+	mutex   sync.Mutex
+	results []alertResult
+}
+
+// withAlertResults returns a context collecting the outcome of the alerts processed with it
+func withAlertResults(ctx context.Context) (context.Context, *alertResults) {
+	results := &alertResults{}
+	return context.WithValue(ctx, alertResultsContextKey{}, results), results
+}
+
+func alertResultsFrom(ctx context.Context) *alertResults {
+	results, _ := ctx.Value(alertResultsContextKey{}).(*alertResults)
+	return results
+}
+
+// add records the outcome of each alert of the group, with the incident of the group
+func (r *alertResults) add(group tableGroup, ref *incidentRef, err error) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, alert := range group.data.Alerts {
+		result := alertResult{Fingerprint: alertFingerprint(alert), Table: group.tableName, Status: alertResultSuccess, Incident: ref.number, SysID: ref.sysID}
+		if err != nil {
+			result.Status = alertResultFailed
+			result.Error = err.Error()
+		}
+		r.results = append(r.results, result)
+	}
+}
+
+func (r *alertResults) list() []alertResult {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]alertResult(nil), r.results...)
+}
+
+// partial returns whether some alerts succeeded and others failed
+func (r *alertResults) partial() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var succeeded, failed bool
+	for _, result := range r.results {
+		if result.Status == alertResultFailed {
+			failed = true
+		} else {
+			succeeded = true
+		}
+	}
+	return succeeded && failed
+}
+
+// failedAlerts returns the alert group restricted to the alerts that failed in at least one table
+func (r *alertResults) failedAlerts(data template.Data) template.Data {
+	r.mutex.Lock()
+	failed := map[string]bool{}
+	for _, result := range r.results {
+		if result.Status == alertResultFailed {
+			failed[result.Fingerprint] = true
+		}
+	}
+	r.mutex.Unlock()
+
+	alerts := make(template.Alerts, 0, len(failed))
+	for _, alert := range data.Alerts {
+		if failed[alertFingerprint(alert)] {
+			alerts = append(alerts, alert)
+		}
+	}
+	data.Alerts = alerts
+	return data
+}
+
+func validatePartialFailureStatus(status int, errs *strings.Builder) {
+	if status != 0 && (status < 200 || status > 599) {
+		errs.WriteString(fmt.Sprintf("webhook.partial_failure_status %d is not a valid HTTP status code\n", status))
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+)
+
+const twoAlertsNotification = `{
+  "status": "firing",
+  "groupLabels": {"alertname": "InstanceDown"},
+  "commonLabels": {"alertname": "InstanceDown"},
+  "alerts": [
+    {"status": "firing", "labels": {"alertname": "InstanceDown", "instance": "web01"}, "fingerprint": "a"},
+    {"status": "firing", "labels": {"alertname": "InstanceDown", "instance": "web02"}, "fingerprint": "b"}
+  ]
+}`
+
+// postPartialFailure posts two alerts, the incident of the first one failing to be created
+func postPartialFailure(t *testing.T) (*httptest.ResponseRecorder, JSONResponse) {
+	currentConfig().Workflow.IncidentPerAlert = true
+	dedupStore = newMemoryDedupStore()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{}, errors.New("Error")).Once()
+	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{"sys_id": "2", "number": "INC2"}, nil)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(webhook).ServeHTTP(rr, httptest.NewRequest("POST", "/webhook", strings.NewReader(twoAlertsNotification)))
+
+	var response JSONResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	return rr, response
+}
+
+func TestWebhook_PartialFailureResults(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	rr, response := postPartialFailure(t)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Wrong status code: got %v, want %v", rr.Code, http.StatusInternalServerError)
+	}
+	want := []alertResult{
+		{Fingerprint: "a", Table: "incident", Status: alertResultFailed, Error: "Error"},
+		{Fingerprint: "b", Table: "incident", Status: alertResultSuccess, Incident: "INC2", SysID: "2"},
+	}
+	if len(response.Results) != len(want) {
+		t.Fatalf("Unexpected results: %+v", response.Results)
+	}
+	for i := range want {
+		if response.Results[i] != want[i] {
+			t.Errorf("Unexpected result %d: got %+v, want %+v", i, response.Results[i], want[i])
+		}
+	}
+}
+
+func TestWebhook_PartialFailureStatus(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Webhook.PartialFailureStatus = http.StatusMultiStatus
+	q, cleanup := newTestDeadLetterQueue(t)
+	defer cleanup()
+
+	rr, response := postPartialFailure(t)
+
+	if rr.Code != http.StatusMultiStatus || response.Status != http.StatusMultiStatus {
+		t.Errorf("Wrong status code: got %v, want %v", rr.Code, http.StatusMultiStatus)
+	}
+	ids, err := q.list()
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("The failed alerts should be dead-lettered: %v, %v", ids, err)
+	}
+	entry, err := q.read(ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entry.Data.Alerts) != 1 || entry.Data.Alerts[0].Fingerprint != "a" {
+		t.Errorf("Only the failed alert should be dead-lettered: %+v", entry.Data.Alerts)
+	}
+}
+
+func TestWebhook_PartialFailureStatus_AllFailed(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Webhook.PartialFailureStatus = http.StatusMultiStatus
+	currentConfig().Workflow.IncidentPerAlert = true
+	dedupStore = newMemoryDedupStore()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{}, errors.New("Error"))
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(webhook).ServeHTTP(rr, httptest.NewRequest("POST", "/webhook", strings.NewReader(twoAlertsNotification)))
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Wrong status code: got %v, want %v", rr.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestWebhookConfig_ValidatePartialFailureStatus(t *testing.T) {
+	var errs strings.Builder
+	WebhookConfig{PartialFailureStatus: 42}.validate(&errs)
+	if !strings.Contains(errs.String(), "webhook.partial_failure_status") {
+		t.Errorf("Unexpected validation errors: %q", errs.String())
+	}
+}
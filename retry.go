@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	defaultInitialBackoff = time.Second
+	defaultMaxBackoff     = 30 * time.Second
+)
+
+var (
+	serviceNowRetries = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "servicenow_request_retries_total",
+			Help: "Total number of ServiceNow requests retried after a transient error, by HTTP status code.",
+		},
+		[]string{"code"},
+	)
+	serviceNowRetryBudgetExhausted = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "servicenow_retry_budget_exhausted_total",
+			Help: "Total number of ServiceNow requests not retried as the retry budget of their notification was exhausted.",
+		},
+	)
+)
+
+// RetryConfig - Retry of the ServiceNow requests failing with a transient error
+type RetryConfig struct {
+	MaxRetries     int           `yaml:"max_retries"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	// Budget bounds the retries of all the requests of a notification
+	Budget RetryBudgetConfig `yaml:"budget"`
+}
+
+// RetryBudgetConfig - Retries of all the ServiceNow requests made while processing a notification, so that an
+// overloaded instance does not hold a worker for each request of the notification in turn. Unbounded when zero.
+type RetryBudgetConfig struct {
+	MaxRetries     int           `yaml:"max_retries"`
+	MaxElapsedTime time.Duration `yaml:"max_elapsed_time"`
+}
+
+func (c RetryConfig) validate(errs *strings.Builder) {
+	if c.MaxRetries < 0 {
+		errs.WriteString("service_now.retry.max_retries must not be negative\n")
+	}
+	if c.InitialBackoff < 0 || c.MaxBackoff < 0 {
+		errs.WriteString("service_now.retry backoffs must not be negative\n")
+	}
+	if c.InitialBackoff > 0 && c.MaxBackoff > 0 && c.InitialBackoff > c.MaxBackoff {
+		errs.WriteString("service_now.retry.initial_backoff must not be greater than service_now.retry.max_backoff\n")
+	}
+	if c.Budget.MaxRetries < 0 || c.Budget.MaxElapsedTime < 0 {
+		errs.WriteString("service_now.retry.budget values must not be negative\n")
+	}
+}
+
+func (c RetryConfig) initialBackoff() time.Duration {
+	if c.InitialBackoff <= 0 {
+		return defaultInitialBackoff
+	}
+	return c.InitialBackoff
+}
+
+func (c RetryConfig) maxBackoff() time.Duration {
+	if c.MaxBackoff <= 0 {
+		return defaultMaxBackoff
+	}
+	return c.MaxBackoff
+}
+
+// retryableStatus returns whether a response with the status code is likely to succeed when retried
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff returns the delay before the retry following the attempt (starting at 0), doubling from the initial backoff
+// up to the maximum backoff, with a random jitter of up to half the delay.
+// The Retry-After delay of the response is honored when it is longer. It returns false when the request should not
+// be retried, after the maximum number of retries or when the Retry-After delay exceeds the maximum backoff.
+func (c RetryConfig) backoff(attempt int, retryAfter time.Duration) (time.Duration, bool) {
+	if attempt >= c.MaxRetries || retryAfter > c.maxBackoff() {
+		return 0, false
+	}
+	delay := c.initialBackoff()
+	for i := 0; i < attempt && delay < c.maxBackoff(); i++ {
+		delay *= 2
+	}
+	if delay > c.maxBackoff() {
+		delay = c.maxBackoff()
+	}
+	delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	if delay < retryAfter {
+		delay = retryAfter
+	}
+	return delay, true
+}
+
+// retryBudget counts the retries of the requests of a notification, shared by its alert groups managed concurrently
+type retryBudget struct {
+	mutex   sync.Mutex
+	started time.Time
+	retries int
+}
+
+type retryBudgetContextKey struct{}
+
+// withRetryBudget returns a context counting the retries of the notification from now, unless already counted
+func withRetryBudget(ctx context.Context) context.Context {
+	if retryBudgetFrom(ctx) != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, retryBudgetContextKey{}, &retryBudget{started: time.Now()})
+}
+
+func retryBudgetFrom(ctx context.Context) *retryBudget {
+	budget, _ := ctx.Value(retryBudgetContextKey{}).(*retryBudget)
+	return budget
+}
+
+// spend counts a retry after the delay, returning false when it would exceed the maximum retries or elapsed time of
+// the budget. A request outside of a notification is not bounded.
+func (b *retryBudget) spend(c RetryBudgetConfig, delay time.Duration, now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if c.MaxRetries > 0 && b.retries >= c.MaxRetries {
+		return false
+	}
+	if c.MaxElapsedTime > 0 && now.Add(delay).Sub(b.started) > c.MaxElapsedTime {
+		return false
+	}
+	b.retries++
+	return true
+}
+
+// waitRetry waits for the delay before retrying the request, or until the context is done
+func waitRetry(ctx context.Context, req *http.Request, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return fmt.Errorf("Error rewinding the request body: %v", err)
+		}
+		req.Body = body
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryConfig_Backoff(t *testing.T) {
+	c := RetryConfig{MaxRetries: 5, InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	tests := []struct {
+		name       string
+		attempt    int
+		retryAfter time.Duration
+		wantMin    time.Duration
+		wantMax    time.Duration
+		wantRetry  bool
+	}{
+		{name: "first", attempt: 0, wantMin: 50 * time.Millisecond, wantMax: 100 * time.Millisecond, wantRetry: true},
+		{name: "exponential", attempt: 2, wantMin: 200 * time.Millisecond, wantMax: 400 * time.Millisecond, wantRetry: true},
+		{name: "capped", attempt: 4, wantMin: 500 * time.Millisecond, wantMax: time.Second, wantRetry: true},
+		{name: "retry_after", attempt: 0, retryAfter: 800 * time.Millisecond, wantMin: 800 * time.Millisecond, wantMax: 800 * time.Millisecond, wantRetry: true},
+		{name: "retry_after_too_long", attempt: 0, retryAfter: time.Minute},
+		{name: "exhausted", attempt: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, retry := c.backoff(tt.attempt, tt.retryAfter)
+			if retry != tt.wantRetry {
+				t.Fatalf("backoff() retry = %v, want %v", retry, tt.wantRetry)
+			}
+			if retry && (delay < tt.wantMin || delay > tt.wantMax) {
+				t.Errorf("backoff() delay = %v, want between %v and %v", delay, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
+
+func TestRetryConfig_Disabled(t *testing.T) {
+	if _, retry := (RetryConfig{}).backoff(0, 0); retry {
+		t.Errorf("Requests should not be retried by default")
+	}
+}
+
+func TestDoRequest_Retry(t *testing.T) {
+	incidentTest, err := ioutil.ReadFile("test/incident_response.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		status       int
+		failures     int32
+		wantRequests int32
+		wantErr      bool
+	}{
+		{name: "service_unavailable", status: http.StatusServiceUnavailable, failures: 2, wantRequests: 3},
+		{name: "too_many_requests", status: http.StatusTooManyRequests, failures: 1, wantRequests: 2},
+		{name: "bad_gateway", status: http.StatusBadGateway, failures: 1, wantRequests: 2},
+		{name: "exhausted", status: http.StatusServiceUnavailable, failures: 10, wantRequests: 4, wantErr: true},
+		{name: "not_retryable", status: http.StatusBadRequest, failures: 1, wantRequests: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				if string(body) != `{"short_description":"test"}` {
+					t.Errorf("Unexpected body on retry: %s", body)
+				}
+				if atomic.AddInt32(&requests, 1) <= tt.failures {
+					w.Header().Set("Retry-After", "0")
+					w.WriteHeader(tt.status)
+					return
+				}
+				fmt.Fprint(w, string(incidentTest))
+			}))
+			defer ts.Close()
+
+			snClient, _ := NewServiceNowClient("instancename", "username", "password")
+			snClient.baseURL = ts.URL
+			snClient.retry = RetryConfig{MaxRetries: 3, InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+
+			_, err := snClient.CreateIncident(context.Background(), "incident", Incident{"short_description": "test"})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CreateIncident() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if requests != tt.wantRequests {
+				t.Errorf("ServiceNow requests = %v, want %v", requests, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestDoRequest_RetryCanceled(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	snClient, _ := NewServiceNowClient("instancename", "username", "password")
+	snClient.baseURL = ts.URL
+	snClient.retry = RetryConfig{MaxRetries: 3, InitialBackoff: time.Minute, MaxBackoff: time.Minute}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := snClient.GetIncidents(ctx, "incident", nil); err != context.DeadlineExceeded {
+		t.Errorf("GetIncidents() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestDoRequest_RetryBudget(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+
+	snClient, _ := NewServiceNowClient("instancename", "username", "password")
+	snClient.baseURL = ts.URL
+	snClient.retry = RetryConfig{MaxRetries: 3, InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond, Budget: RetryBudgetConfig{MaxRetries: 4}}
+	ctx := withRetryBudget(context.Background())
+
+	if _, err := snClient.GetIncidents(ctx, "incident", nil); err == nil {
+		t.Fatal("The rate limited request should fail")
+	}
+	_, err := snClient.GetIncidents(ctx, "incident", nil)
+	if _, ok := err.(*overloadError); !ok {
+		t.Errorf("The request exhausting the budget should fail fast with an overload error: %v", err)
+	}
+	// 4 requests for the first one, then 2 once the single retry left is spent
+	if requests != 6 {
+		t.Errorf("ServiceNow requests = %v, want 6", requests)
+	}
+}
+
+func TestRetryBudget_Spend(t *testing.T) {
+	now := time.Now()
+	b := &retryBudget{started: now}
+	c := RetryBudgetConfig{MaxElapsedTime: time.Minute}
+	if !b.spend(c, 30*time.Second, now.Add(20*time.Second)) {
+		t.Errorf("The retry within the budget should be allowed")
+	}
+	if b.spend(c, 30*time.Second, now.Add(40*time.Second)) {
+		t.Errorf("The retry ending after the maximum elapsed time should not be allowed")
+	}
+	var unbounded *retryBudget
+	if !unbounded.spend(RetryBudgetConfig{MaxRetries: 1}, time.Hour, now) {
+		t.Errorf("The requests outside of a notification should not be bounded")
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/alertmanager/pkg/labels"
+	"github.com/prometheus/alertmanager/template"
+)
+
+// RouteConfig - Routing of the alerts matching all the labels and all the label matchers, such as type=~"sec.*", to a
+// ServiceNow table
+type RouteConfig struct {
+	Match     map[string]string `yaml:"match"`
+	Matchers  []string          `yaml:"matchers"`
+	TableName string            `yaml:"table_name"`
+	Dedup     *bool             `yaml:"dedup"`
+	Instance  string            `yaml:"instance"`
+	// DefaultIncident holds the incident fields of the route, overriding the selected ones field by field
+	DefaultIncident map[string]string `yaml:"default_incident"`
+}
+
+// tableGroup is the part of an alert group routed to a ServiceNow table by a same route
+type tableGroup struct {
+	// instance is the name of the ServiceNow instance of the table, empty for the default one
+	instance  string
+	tableName string
+	dedup     bool
+	data      template.Data
+	// fields are the incident fields of the route, overriding the selected ones
+	fields map[string]string
+}
+
+// routing is the routes of the alert groups, and the ServiceNow instance and table of the alerts matching none
+type routing struct {
+	routes []RouteConfig
+	// matchers are the parsed label matchers of the routes, by matcher
+	matchers  map[string]*labels.Matcher
+	instance  string
+	tableName string
+}
+
+func validateRoutes(c Config, errs *strings.Builder) {
+	validateRouteList(c, c.Routes, errs)
+}
+
+func validateRouteList(c Config, routes []RouteConfig, errs *strings.Builder) {
+	for i, route := range routes {
+		if len(route.Match) == 0 && len(route.Matchers) == 0 {
+			errs.WriteString(fmt.Sprintf("routes[%d] needs match labels or matchers\n", i))
+		}
+		for _, matcher := range route.Matchers {
+			if _, err := labels.ParseMatcher(matcher); err != nil {
+				errs.WriteString(fmt.Sprintf("routes[%d] matcher %s is invalid: %v\n", i, matcher, err))
+			}
+		}
+		if len(c.routeTableName(route)) == 0 {
+			errs.WriteString(fmt.Sprintf("routes[%d].table_name is missing\n", i))
+		}
+		if _, ok := c.Instances[route.Instance]; len(route.Instance) > 0 && !ok {
+			errs.WriteString(fmt.Sprintf("routes[%d].instance %q is not defined in instances\n", i, route.Instance))
+		}
+		for field, text := range route.DefaultIncident {
+			if _, err := newTextTemplate(field).Parse(text); err != nil {
+				errs.WriteString(fmt.Sprintf("routes[%d].default_incident.%s is invalid: %v\n", i, field, err))
+			}
+		}
+	}
+}
+
+// matches returns whether the labels match all the labels and all the parsed label matchers of the route
+func (r RouteConfig) matches(alertLabels template.KV, matchers map[string]*labels.Matcher) bool {
+	for name, value := range r.Match {
+		if alertLabels[name] != value {
+			return false
+		}
+	}
+	for _, matcher := range r.Matchers {
+		// The matchers are validated with the config
+		m, ok := matchers[matcher]
+		if !ok || !m.Matches(alertLabels[m.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// newRouteMatchers parses the label matchers of the global routes and of the routes of the webhook receivers, validated
+// with the config
+func newRouteMatchers(c Config) map[string]*labels.Matcher {
+	matchers := map[string]*labels.Matcher{}
+	add := func(routes []RouteConfig) {
+		for _, route := range routes {
+			for _, matcher := range route.Matchers {
+				if m, err := labels.ParseMatcher(matcher); err == nil {
+					matchers[matcher] = m
+				}
+			}
+		}
+	}
+	add(c.Routes)
+	for _, receiver := range c.Receivers {
+		add(receiver.Routes)
+	}
+	return matchers
+}
+
+// dedupEnabled returns whether the route deduplicates incidents, defaulting to the global setting
+func (r RouteConfig) dedupEnabled(ctx context.Context) bool {
+	config := configFrom(ctx)
+	if r.Dedup != nil {
+		return *r.Dedup
+	}
+	return config.Dedup.enabled()
+}
+
+// route returns the index of the first route matching the labels, or -1 when none matches
+func (r routing) route(labels template.KV) int {
+	for i, route := range r.routes {
+		if route.matches(labels, r.matchers) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r routing) newTableGroup(ctx context.Context, routeIndex int, data template.Data) tableGroup {
+	config := configFrom(ctx)
+	if routeIndex < 0 {
+		return tableGroup{instance: r.instance, tableName: r.tableName, dedup: config.Dedup.enabled(), data: data}
+	}
+	route := r.routes[routeIndex]
+	return tableGroup{instance: route.Instance, tableName: config.routeTableName(route), dedup: route.dedupEnabled(ctx), data: data, fields: route.DefaultIncident}
+}
+
+type routeFieldsContextKey struct{}
+
+// withRouteFields returns a context setting the incident fields of the route of the alert group
+func withRouteFields(ctx context.Context, fields map[string]string) context.Context {
+	if len(fields) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, routeFieldsContextKey{}, fields)
+}
+
+// routeFieldsFrom returns the incident fields of the route of the context, if any
+func routeFieldsFrom(ctx context.Context) map[string]string {
+	fields, _ := ctx.Value(routeFieldsContextKey{}).(map[string]string)
+	return fields
+}
+
+// routeTableName returns the table of the route, defaulting to the table of its ServiceNow instance
+func (c Config) routeTableName(route RouteConfig) string {
+	if len(route.TableName) == 0 && len(route.Instance) > 0 {
+		return c.Instances[route.Instance].TableName
+	}
+	return route.TableName
+}
+
+// incidentFields returns the incident fields profile of the table, defaulting to the default incident
+func (c Config) incidentFields(tableName string) map[string]string {
+	if fields, ok := c.TableProfiles[tableName]; ok {
+		return fields
+	}
+	return c.DefaultIncident
+}
+
+// tableNames returns the distinct tables incidents can be managed in on the default instance, starting with the default
+// table, then the tables of the routes and of the webhook receivers
+func (c Config) tableNames() []string {
+	return c.instanceTableNames("")
+}
+
+// instanceTableNames returns the distinct tables incidents can be managed in on the named ServiceNow instance, the
+// default one when empty, starting with the table of the instance
+func (c Config) instanceTableNames(instance string) []string {
+	var tableNames []string
+	seen := map[string]bool{}
+	add := func(routeInstance string, tableName string) {
+		if routeInstance == instance && len(tableName) > 0 && !seen[tableName] {
+			seen[tableName] = true
+			tableNames = append(tableNames, tableName)
+		}
+	}
+	add(instance, instanceTableName(c, instance))
+	for _, route := range c.Routes {
+		add(route.Instance, route.TableName)
+	}
+	for _, name := range c.receiverNames() {
+		receiver := c.Receivers[name]
+		add(receiver.Instance, receiver.TableName)
+		for _, route := range receiver.Routes {
+			add(route.Instance, route.TableName)
+		}
+	}
+	return tableNames
+}
+
+// routeAlertGroup splits the alert group by the route of the webhook receiver of the context each alert matches, in
+// order of first appearance. The alert group is kept as is when all its alerts match the same route.
+func routeAlertGroup(ctx context.Context, data template.Data) []tableGroup {
+	r := routingFrom(ctx)
+	var groups []tableGroup
+	index := map[int]int{}
+	routeIndex := -1
+	for _, alert := range data.Alerts {
+		routeIndex = r.route(alert.Labels)
+		i, ok := index[routeIndex]
+		if !ok {
+			i = len(groups)
+			index[routeIndex] = i
+			groupData := data
+			groupData.Alerts = nil
+			groups = append(groups, r.newTableGroup(ctx, routeIndex, groupData))
+		}
+		groups[i].data.Alerts = append(groups[i].data.Alerts, alert)
+	}
+
+	if len(groups) <= 1 {
+		return []tableGroup{r.newTableGroup(ctx, routeIndex, data)}
+	}
+
+	for i := range groups {
+		groups[i].data.Status = "resolved"
+		if len(groups[i].data.Alerts.Firing()) > 0 {
+			groups[i].data.Status = "firing"
+		}
+	}
+	return groups
+}
+
+// dedupKey returns the deduplication store key of the group key in the table
+func dedupKey(ctx context.Context, tableName string, groupKey string) string {
+	config := configFrom(ctx)
+	if tableName == config.ServiceNow.TableName {
+		return groupKey
+	}
+	return tableName + ":" + groupKey
+}
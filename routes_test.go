@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/mock"
+)
+
+func loadRoutesTestConfig() {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Routes = []RouteConfig{
+		{Match: map[string]string{"itsm_process": "change"}, TableName: "change_request"},
+		{Match: map[string]string{"itsm_process": "problem"}, TableName: "problem"},
+	}
+	currentConfig().TableProfiles = map[string]map[string]string{
+		"change_request": {"short_description": "Change for {{ .CommonLabels.alertname }}", "type": "standard"},
+	}
+	applyConfig()
+	dedupStore = newMemoryDedupStore()
+}
+
+func routedAlertGroup() template.Data {
+	return template.Data{
+		Status:       "firing",
+		GroupLabels:  template.KV{"alertname": "routed"},
+		CommonLabels: template.KV{"alertname": "routed"},
+		Alerts: template.Alerts{
+			template.Alert{Status: "firing", Labels: template.KV{"alertname": "routed", "itsm_process": "change"}},
+			template.Alert{Status: "resolved", Labels: template.KV{"alertname": "routed", "itsm_process": "problem"}},
+			template.Alert{Status: "firing", Labels: template.KV{"alertname": "routed", "instance": "a"}},
+			template.Alert{Status: "firing", Labels: template.KV{"alertname": "routed", "itsm_process": "change", "instance": "b"}},
+		},
+	}
+}
+
+func TestRouteAlertGroup(t *testing.T) {
+	loadRoutesTestConfig()
+
+	groups := routeAlertGroup(context.Background(), routedAlertGroup())
+	want := []struct {
+		tableName string
+		status    string
+		alerts    int
+	}{
+		{tableName: "change_request", status: "firing", alerts: 2},
+		{tableName: "problem", status: "resolved", alerts: 1},
+		{tableName: "incident", status: "firing", alerts: 1},
+	}
+	if len(groups) != len(want) {
+		t.Fatalf("Unexpected number of table groups: got %v, want %v", len(groups), len(want))
+	}
+	for i, w := range want {
+		if groups[i].tableName != w.tableName || groups[i].data.Status != w.status || len(groups[i].data.Alerts) != w.alerts {
+			t.Errorf("Unexpected table group %d: got %s/%s/%d alert(s), want %s/%s/%d alert(s)", i,
+				groups[i].tableName, groups[i].data.Status, len(groups[i].data.Alerts), w.tableName, w.status, w.alerts)
+		}
+	}
+}
+
+func TestRouteAlertGroup_NoRoute(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	data := routedAlertGroup()
+
+	groups := routeAlertGroup(context.Background(), data)
+	if len(groups) != 1 || groups[0].tableName != "incident" || len(groups[0].data.Alerts) != len(data.Alerts) {
+		t.Errorf("Alert group should be kept as is in the default table, got %v", groups)
+	}
+}
+
+func TestOnAlertGroup_Routes(t *testing.T) {
+	loadRoutesTestConfig()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", "change_request", mock.Anything).Run(func(args mock.Arguments) {
+		incident := args.Get(1).(Incident)
+		if incident["type"] != "standard" || incident["short_description"] != "Change for routed" {
+			t.Errorf("Change request should use its table profile, got %v", incident)
+		}
+	}).Return(Incident{}, nil)
+	snClientMock.On("CreateIncident", "incident", mock.Anything).Run(func(args mock.Arguments) {
+		incident := args.Get(1).(Incident)
+		if _, ok := incident["type"]; ok {
+			t.Errorf("Incident should use the default incident, got %v", incident)
+		}
+	}).Return(Incident{}, nil)
+
+	if err := onAlertGroup(context.Background(), routedAlertGroup()); err != nil {
+		t.Fatal(err)
+	}
+	snClientMock.AssertCalled(t, "GetIncidents", "change_request", mock.Anything)
+	snClientMock.AssertCalled(t, "GetIncidents", "problem", mock.Anything)
+	snClientMock.AssertCalled(t, "GetIncidents", "incident", mock.Anything)
+	snClientMock.AssertNumberOfCalls(t, "CreateIncident", 2)
+	// Resolved alerts do not create incidents
+	snClientMock.AssertNotCalled(t, "CreateIncident", "problem", mock.Anything)
+}
+
+func TestOnAlertGroup_Routes_TableError(t *testing.T) {
+	loadRoutesTestConfig()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "change_request", mock.Anything).Return([]Incident{}, errors.New("Error"))
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{}, nil)
+
+	err := onAlertGroup(context.Background(), routedAlertGroup())
+	if err == nil || !strings.Contains(err.Error(), "change_request") {
+		t.Errorf("Expected an error for the change_request table, got %v", err)
+	}
+	// Other tables are still managed
+	snClientMock.AssertCalled(t, "CreateIncident", "incident", mock.Anything)
+}
+
+func TestValidateRoutes(t *testing.T) {
+	var errs strings.Builder
+	validateRoutes(Config{Routes: []RouteConfig{{TableName: "problem"}, {Match: map[string]string{"a": "b"}}}}, &errs)
+	if !strings.Contains(errs.String(), "routes[0] needs match labels or matchers") || !strings.Contains(errs.String(), "routes[1].table_name is missing") {
+		t.Errorf("Unexpected validation errors: %q", errs.String())
+	}
+}
+
+func TestDedupKey(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	if key := dedupKey(context.Background(), "incident", "key"); key != "key" {
+		t.Errorf("Unexpected dedup key for the default table: %v", key)
+	}
+	if key := dedupKey(context.Background(), "problem", "key"); key != "problem:key" {
+		t.Errorf("Unexpected dedup key for a routed table: %v", key)
+	}
+}
+
+func TestDedupKey_PinnedSnapshot(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	ctx := withConfigSnapshot(context.Background())
+	c := *currentConfig()
+	c.ServiceNow.TableName = "problem"
+	disabled := false
+	c.Dedup.Enabled = &disabled
+	currentSnapshot.Store(newConfigSnapshot(c, currentConfigSnapshot().status, currentConfigSnapshot()))
+
+	if key := dedupKey(ctx, "incident", "key"); key != "key" {
+		t.Errorf("The dedup key should use the default table of the request's config: %v", key)
+	}
+	if !(RouteConfig{}).dedupEnabled(ctx) {
+		t.Errorf("The route should default to the dedup setting of the request's config")
+	}
+}
+
+func TestOnAlertGroup_RouteDedupDisabled(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	dedup := false
+	currentConfig().Routes = []RouteConfig{{Match: map[string]string{"type": "security"}, TableName: "incident", Dedup: &dedup}}
+	dedupStore = newMemoryDedupStore()
+	snClient := new(statefulSnClient)
+	useServiceNow(snClient)
+	snClient.On("CreateIncident", "incident", mock.Anything).Return(Incident{}, nil)
+
+	data := template.Data{
+		Status:      "firing",
+		GroupLabels: template.KV{"alertname": "IntrusionDetected"},
+		Alerts:      template.Alerts{template.Alert{Status: "firing", Labels: template.KV{"alertname": "IntrusionDetected", "type": "security"}}},
+	}
+	for i := 0; i < 3; i++ {
+		if err := onAlertGroup(context.Background(), data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snClient.AssertNumberOfCalls(t, "CreateIncident", 3)
+	snClient.AssertNotCalled(t, "GetIncidents", mock.Anything, mock.Anything)
+}
+
+func TestRouteDedupEnabled(t *testing.T) {
+	enabled, disabled := true, false
+	tests := []struct {
+		name   string
+		global *bool
+		route  *bool
+		want   bool
+	}{
+		{name: "default", want: true},
+		{name: "global_disabled", global: &disabled, want: false},
+		{name: "route_disabled", route: &disabled, want: false},
+		{name: "route_enabled", global: &disabled, route: &enabled, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loadConfig("config/servicenow_example.yml")
+			currentConfig().Dedup.Enabled = tt.global
+			if got := (RouteConfig{Dedup: tt.route}).dedupEnabled(context.Background()); got != tt.want {
+				t.Errorf("Unexpected dedup: got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteAlertGroup_SameTableRoutes(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	dedup := false
+	currentConfig().Routes = []RouteConfig{{Match: map[string]string{"type": "security"}, TableName: "incident", Dedup: &dedup}}
+
+	groups := routeAlertGroup(context.Background(), template.Data{Alerts: template.Alerts{
+		template.Alert{Status: "firing", Labels: template.KV{"type": "security"}},
+		template.Alert{Status: "firing", Labels: template.KV{"type": "other"}},
+	}})
+	if len(groups) != 2 || groups[0].dedup || !groups[1].dedup {
+		t.Errorf("Alerts should be split by route, got %v", groups)
+	}
+}
+
+func TestOnAlertGroup_RouteDefaultIncident(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Routes = []RouteConfig{{
+		Match:           map[string]string{"team": "storage"},
+		TableName:       "incident",
+		DefaultIncident: map[string]string{"contact_type": "Event Management", "impact": "{{ .CommonLabels.impact }}"},
+	}}
+	applyConfig()
+	dedupStore = newMemoryDedupStore()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "incident", mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", "incident", mock.Anything).Return(Incident{"sys_id": "1", "number": "INC1"}, nil)
+	data := template.Data{
+		Status:       "firing",
+		GroupLabels:  template.KV{"alertname": "DiskFull"},
+		CommonLabels: template.KV{"alertname": "DiskFull", "team": "storage", "impact": "1"},
+		Alerts:       template.Alerts{template.Alert{Status: "firing", Labels: template.KV{"alertname": "DiskFull", "team": "storage", "impact": "1"}}},
+	}
+
+	if err := onAlertGroup(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+	incident := snClientMock.Calls[1].Arguments.Get(1).(Incident)
+	if incident["contact_type"] != "Event Management" || incident["impact"] != "1" || incident["category"] != "Failure" {
+		t.Errorf("The route fields should override the default incident field by field: %v", incident)
+	}
+}
+
+func TestValidateRoutes_DefaultIncident(t *testing.T) {
+	var errs strings.Builder
+	validateRoutes(Config{Routes: []RouteConfig{{Match: map[string]string{"a": "b"}, TableName: "incident", DefaultIncident: map[string]string{"impact": "{{ .Invalid"}}}}, &errs)
+	if !strings.Contains(errs.String(), "routes[0].default_incident.impact is invalid") {
+		t.Errorf("Unexpected validation errors: %q", errs.String())
+	}
+}
+
+func TestRouteAlertGroup_Matchers(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Routes = []RouteConfig{
+		{Matchers: []string{`type=~"security|intrusion"`}, TableName: "sn_si_incident"},
+		{Match: map[string]string{"type": "request"}, Matchers: []string{`severity!="critical"`}, TableName: "sc_task"},
+	}
+	applyConfig()
+	data := template.Data{
+		Status:      "firing",
+		GroupLabels: template.KV{"alertname": "routed"},
+		Alerts: template.Alerts{
+			template.Alert{Status: "firing", Labels: template.KV{"alertname": "routed", "type": "intrusion"}},
+			template.Alert{Status: "firing", Labels: template.KV{"alertname": "routed", "type": "request", "severity": "warning"}},
+			template.Alert{Status: "firing", Labels: template.KV{"alertname": "routed", "type": "request", "severity": "critical"}},
+		},
+	}
+
+	groups := routeAlertGroup(context.Background(), data)
+	tables := make([]string, 0, len(groups))
+	for _, group := range groups {
+		tables = append(tables, group.tableName)
+	}
+	if strings.Join(tables, ",") != "sn_si_incident,sc_task,incident" {
+		t.Errorf("Unexpected tables of the alert group: %v", tables)
+	}
+}
+
+func TestValidateRoutes_Matchers(t *testing.T) {
+	var errs strings.Builder
+	validateRoutes(Config{Routes: []RouteConfig{{Matchers: []string{`type=~"security"`}, TableName: "sn_si_incident"}, {Matchers: []string{"type"}, TableName: "sc_task"}}}, &errs)
+	if strings.Contains(errs.String(), "routes[0]") || !strings.Contains(errs.String(), "routes[1] matcher type is invalid") {
+		t.Errorf("Unexpected validation errors: %q", errs.String())
+	}
+}
+
+func TestNewRouteMatchers(t *testing.T) {
+	matchers := newRouteMatchers(Config{
+		Routes:    []RouteConfig{{Matchers: []string{`type=~"security"`, "type"}}},
+		Receivers: map[string]ReceiverConfig{"team": {Routes: []RouteConfig{{Matchers: []string{`severity!=critical`}}}}},
+	})
+	if len(matchers) != 2 || matchers[`type=~"security"`] == nil || matchers[`severity!=critical`] == nil {
+		t.Errorf("The valid matchers of the global and receiver routes should be parsed: %v", matchers)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	minio "github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const s3Timeout = 30 * time.Second
+
+// S3Config - S3 (or S3 compatible) bucket, the credentials defaulting to the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY
+// and AWS_SESSION_TOKEN environment variables
+type S3Config struct {
+	Bucket              string `yaml:"bucket"`
+	Region              string `yaml:"region"`
+	Endpoint            string `yaml:"endpoint"`
+	Prefix              string `yaml:"prefix"`
+	AccessKeyID         string `yaml:"access_key_id"`
+	SecretAccessKey     string `yaml:"secret_access_key"`
+	SecretAccessKeyFile string `yaml:"secret_access_key_file"`
+}
+
+func (c S3Config) validate(prefix string, errs *strings.Builder) {
+	if len(c.Region) == 0 {
+		errs.WriteString(prefix + ".region is missing\n")
+	}
+	if len(c.Endpoint) > 0 {
+		if u, err := url.Parse(c.Endpoint); err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+			errs.WriteString(fmt.Sprintf("%s.endpoint %q is not a valid URL\n", prefix, c.Endpoint))
+		}
+	}
+}
+
+// s3Client stores the objects of the bucket with the MinIO client of the S3 API
+type s3Client struct {
+	config S3Config
+	client *minio.Client
+}
+
+// newS3Client addresses the bucket as a virtual host of the AWS endpoint of its region, or as the first path
+// segment of the custom endpoint, as the S3 compatible stores (e.g. MinIO) expect
+func newS3Client(c S3Config) (*s3Client, error) {
+	endpoint, secure, lookup := fmt.Sprintf("s3.%s.amazonaws.com", c.Region), true, minio.BucketLookupDNS
+	if len(c.Endpoint) > 0 {
+		u, err := url.Parse(c.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		endpoint, secure, lookup = u.Host, u.Scheme == "https", minio.BucketLookupPath
+	}
+	creds := credentials.NewEnvAWS()
+	if len(c.AccessKeyID) > 0 || len(c.SecretAccessKey) > 0 {
+		creds = credentials.NewStaticV4(c.AccessKeyID, c.SecretAccessKey, "")
+	}
+	client, err := minio.New(endpoint, &minio.Options{Creds: creds, Secure: secure, Region: c.Region, BucketLookup: lookup})
+	if err != nil {
+		return nil, err
+	}
+	return &s3Client{config: c, client: client}, nil
+}
+
+// putObject stores the object under the configured prefix
+func (c *s3Client) putObject(ctx context.Context, name string, contentType string, content []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, s3Timeout)
+	defer cancel()
+	_, err := c.client.PutObject(ctx, c.config.Bucket, c.config.Prefix+name, bytes.NewReader(content), int64(len(content)), minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+func (c *s3Client) deleteObject(ctx context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, s3Timeout)
+	defer cancel()
+	return c.client.RemoveObject(ctx, c.config.Bucket, key, minio.RemoveObjectOptions{})
+}
+
+type s3Object struct {
+	Key          string
+	LastModified time.Time
+}
+
+// listObjects returns the objects under the configured prefix, following the pagination
+func (c *s3Client) listObjects(ctx context.Context) ([]s3Object, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var objects []s3Object
+	for object := range c.client.ListObjects(ctx, c.config.Bucket, minio.ListObjectsOptions{Prefix: c.config.Prefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		objects = append(objects, s3Object{Key: object.Key, LastModified: object.LastModified})
+	}
+	return objects, nil
+}
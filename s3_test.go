@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+// decodeAWSChunks returns the payload of the body signed chunk by chunk, as sent over plain HTTP
+func decodeAWSChunks(contentSHA256 string, body string) string {
+	if !strings.HasPrefix(contentSHA256, "STREAMING-") {
+		return body
+	}
+	var payload strings.Builder
+	for len(body) > 0 {
+		header := strings.SplitN(body, "\r\n", 2)
+		var size int
+		fmt.Sscanf(header[0], "%x;", &size)
+		if size == 0 || len(header) < 2 {
+			break
+		}
+		payload.WriteString(header[1][:size])
+		body = strings.TrimPrefix(header[1][size:], "\r\n")
+	}
+	return payload.String()
+}
+
+func TestS3Archive(t *testing.T) {
+	objects := map[string]string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+		switch r.Method {
+		case http.MethodPut:
+			body, _ := ioutil.ReadAll(r.Body)
+			objects[key] = decodeAWSChunks(r.Header.Get("X-Amz-Content-Sha256"), string(body))
+		case http.MethodDelete:
+			delete(objects, key)
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodGet:
+			if token := r.URL.Query().Get("continuation-token"); len(token) == 0 {
+				fmt.Fprint(w, `<ListBucketResult><Contents><Key>archive/old.json</Key><LastModified>2020-01-01T00:00:00.000Z</LastModified></Contents><IsTruncated>true</IsTruncated><NextContinuationToken>next</NextContinuationToken></ListBucketResult>`)
+			} else {
+				fmt.Fprintf(w, `<ListBucketResult><Contents><Key>archive/new.json</Key><LastModified>%s</LastModified></Contents><IsTruncated>false</IsTruncated></ListBucketResult>`, time.Now().UTC().Format(time.RFC3339))
+			}
+		}
+	}))
+	defer ts.Close()
+	c := S3Config{Bucket: "bucket", Region: "us-east-1", Endpoint: ts.URL, Prefix: "archive/", AccessKeyID: "key", SecretAccessKey: "secret"}
+	client, err := newS3Client(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := &s3Archive{client: client}
+	ctx := context.Background()
+
+	if err := archive.put(ctx, "old.json", []byte("{}")); err != nil || objects["archive/old.json"] != "{}" {
+		t.Fatalf("The entry should be stored under the prefix: %v, %v", err, objects)
+	}
+	if pruned, err := archive.prune(ctx, time.Now().Add(-time.Hour)); err != nil || pruned != 1 {
+		t.Errorf("The old entry of the paginated objects should be pruned: %v, %v", pruned, err)
+	}
+	if _, ok := objects["archive/old.json"]; ok {
+		t.Errorf("The old entry should be deleted")
+	}
+
+	c.AccessKeyID = "unknown"
+	if archive.client, err = newS3Client(c); err != nil {
+		t.Fatal(err)
+	}
+	if err := archive.put(ctx, "entry.json", []byte("{}")); minio.ToErrorResponse(err).StatusCode != http.StatusForbidden {
+		t.Errorf("The rejected request should fail with its status: %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const (
+	nonASCIIKeep          = "keep"
+	nonASCIIRemove        = "remove"
+	nonASCIITransliterate = "transliterate"
+)
+
+// FieldSanitizationConfig - Cleanup of the value of an incident field, e.g. an alert annotation holding control
+// characters or emoji the instance rejects
+type FieldSanitizationConfig struct {
+	StripControlChars  bool   `yaml:"strip_control_chars"`
+	CollapseWhitespace bool   `yaml:"collapse_whitespace"`
+	NonASCII           string `yaml:"non_ascii"`
+}
+
+// transliterations are the ASCII replacements of the common Latin letters and typographic characters, the other
+// non-ASCII characters being removed
+var transliterations = map[rune]string{
+	'À': "A", 'Á': "A", 'Â': "A", 'Ã': "A", 'Ä': "A", 'Å': "A", 'Æ': "AE", 'Ç': "C",
+	'È': "E", 'É': "E", 'Ê': "E", 'Ë': "E", 'Ì': "I", 'Í': "I", 'Î': "I", 'Ï': "I",
+	'Ð': "D", 'Ñ': "N", 'Ò': "O", 'Ó': "O", 'Ô': "O", 'Õ': "O", 'Ö': "O", 'Ø': "O",
+	'Ù': "U", 'Ú': "U", 'Û': "U", 'Ü': "U", 'Ý': "Y", 'Þ': "TH", 'ß': "ss",
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'æ': "ae", 'ç': "c",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ì': "i", 'í': "i", 'î': "i", 'ï': "i",
+	'ð': "d", 'ñ': "n", 'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ý': "y", 'þ': "th", 'ÿ': "y",
+	'Œ': "OE", 'œ': "oe", 'Š': "S", 'š': "s", 'Ž': "Z", 'ž': "z", 'Ł': "L", 'ł': "l",
+	'‘': "'", '’': "'", '‚': "'", '“': `"`, '”': `"`, '„': `"`, '«': `"`, '»': `"`,
+	'–': "-", '—': "-", '…': "...", '•': "*", '€': "EUR", '£': "GBP", '©': "(c)", '®': "(R)",
+	'\u00a0': " ",
+}
+
+func validateFieldSanitization(c Config, errs *strings.Builder) {
+	for field, sanitization := range c.FieldSanitization {
+		switch sanitization.NonASCII {
+		case "", nonASCIIKeep, nonASCIIRemove, nonASCIITransliterate:
+		default:
+			errs.WriteString(fmt.Sprintf("field_sanitization.%s.non_ascii must be one of %q, %q or %q\n", field, nonASCIIKeep, nonASCIIRemove, nonASCIITransliterate))
+		}
+	}
+}
+
+// sanitize returns the value without its control characters (but the newlines and tabs), with its whitespace runs
+// collapsed into single spaces, and with its non-ASCII characters removed or transliterated, as configured
+func (c FieldSanitizationConfig) sanitize(value string) string {
+	var b strings.Builder
+	for _, r := range value {
+		switch {
+		case c.StripControlChars && unicode.IsControl(r) && r != '\n' && r != '\t':
+		case r > unicode.MaxASCII && c.NonASCII == nonASCIIRemove:
+		case r > unicode.MaxASCII && c.NonASCII == nonASCIITransliterate:
+			b.WriteString(transliterations[r])
+		default:
+			b.WriteRune(r)
+		}
+	}
+	value = b.String()
+	if c.CollapseWhitespace {
+		value = strings.Join(strings.Fields(value), " ")
+	}
+	return value
+}
+
+// applyFieldSanitization sanitizes the incident fields according to their field_sanitization entry
+func applyFieldSanitization(sanitizations map[string]FieldSanitizationConfig, incident Incident) {
+	for field, sanitization := range sanitizations {
+		if value, ok := incident[field].(string); ok {
+			incident[field] = sanitization.sanitize(value)
+		}
+	}
+}
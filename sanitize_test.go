@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFieldSanitizationConfig_Sanitize(t *testing.T) {
+	tests := []struct {
+		name   string
+		config FieldSanitizationConfig
+		value  string
+		want   string
+	}{
+		{name: "none", value: "Disk\x00 full ⚠", want: "Disk\x00 full ⚠"},
+		{name: "control_chars", config: FieldSanitizationConfig{StripControlChars: true}, value: "Disk\x00\x1b full\n\tnow", want: "Disk full\n\tnow"},
+		{name: "whitespace", config: FieldSanitizationConfig{CollapseWhitespace: true}, value: "  Disk \n\n full  ", want: "Disk full"},
+		{name: "remove", config: FieldSanitizationConfig{NonASCII: nonASCIIRemove}, value: "Disk full ⚠ café", want: "Disk full  caf"},
+		{name: "transliterate", config: FieldSanitizationConfig{NonASCII: nonASCIITransliterate}, value: "Disk “full” ⚠ café", want: `Disk "full"  cafe`},
+		{name: "all", config: FieldSanitizationConfig{StripControlChars: true, CollapseWhitespace: true, NonASCII: nonASCIIRemove}, value: "Disk\x07 full ⚠ now", want: "Disk full now"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.config.sanitize(tt.value); got != tt.want {
+				t.Errorf("Unexpected sanitized value: got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyFieldSanitization(t *testing.T) {
+	incident := Incident{"short_description": "Disk\x00 full", "description": "Disk\x00 full", "impact": 1}
+	applyFieldSanitization(map[string]FieldSanitizationConfig{"short_description": {StripControlChars: true}, "impact": {StripControlChars: true}}, incident)
+	if incident["short_description"] != "Disk full" || incident["description"] != "Disk\x00 full" || incident["impact"] != 1 {
+		t.Errorf("Only the string values of the configured fields should be sanitized: %v", incident)
+	}
+}
+
+func TestValidateFieldSanitization(t *testing.T) {
+	var errs strings.Builder
+	validateFieldSanitization(Config{FieldSanitization: map[string]FieldSanitizationConfig{"description": {NonASCII: "ascii"}}}, &errs)
+	if !strings.Contains(errs.String(), "field_sanitization.description.non_ascii") {
+		t.Errorf("Unexpected validation errors: %q", errs.String())
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	dictionaryTable    = "sys_dictionary"
+	schemaModeFail     = "fail"
+	schemaModeWarn     = "warn"
+	defaultSchemaTable = "task"
+)
+
+// SchemaValidationConfig - Configuration of the validation of the configured fields against the ServiceNow table schema
+type SchemaValidationConfig struct {
+	Enabled         bool     `yaml:"enabled"`
+	Mode            string   `yaml:"mode"`
+	InheritedTables []string `yaml:"inherited_tables"`
+}
+
+func (c SchemaValidationConfig) validate(errs *strings.Builder) {
+	switch c.Mode {
+	case "", schemaModeFail, schemaModeWarn:
+	default:
+		errs.WriteString(fmt.Sprintf("schema_validation.mode must be one of %q or %q\n", schemaModeFail, schemaModeWarn))
+	}
+}
+
+// configuredFields returns the distinct incident field names referenced by the configuration for the table
+func configuredFields(c Config, tableName string) []string {
+	workflow := c.tableWorkflow(tableName)
+	fields := map[string]bool{workflow.IncidentGroupKeyField: true}
+	for field := range c.incidentFields(tableName) {
+		fields[field] = true
+	}
+	// The incident templates can be selected for the alerts of any table
+	for _, templateFields := range c.IncidentTemplates {
+		for field := range templateFields {
+			fields[field] = true
+		}
+	}
+	for _, field := range workflow.IncidentUpdateFields {
+		fields[field] = true
+	}
+	if c.Workflow.TwoPhaseCreate.Enabled {
+		fields["state"] = true
+		for _, field := range c.Workflow.TwoPhaseCreate.SubmitFields {
+			fields[field] = true
+		}
+	}
+	if c.WatchList.enabled() {
+		fields[watchListField] = true
+	}
+	if c.AssignedTo.enabled() {
+		fields[assignedToField] = true
+	}
+	if len(c.ImpactAnalysis.Field) > 0 {
+		fields[c.ImpactAnalysis.Field] = true
+	}
+	if len(c.CILookup.Label) > 0 {
+		fields[defaultImpactCIField] = true
+	}
+	for _, limit := range c.FieldLimits {
+		if limit.Policy == fieldLimitWorkNotes {
+			fields[workNotesField] = true
+		}
+	}
+	if len(c.RelatedAlerts.Label) > 0 {
+		fields[relatedAlertsField] = true
+	}
+	if c.Links.enabled() {
+		fields[c.Links.field()] = true
+	}
+	if c.Timestamps.Enabled {
+		fields[c.Timestamps.openedAtField()] = true
+		if workflow.Resolve.enabled() {
+			fields[c.Timestamps.resolvedAtField()] = true
+		}
+	}
+	if workflow.Resolve.enabled() {
+		fields["state"] = true
+		if len(workflow.Resolve.CloseCode) > 0 {
+			fields[closeCodeField] = true
+		}
+		if len(workflow.Resolve.CloseNotes) > 0 {
+			fields[closeNotesField] = true
+		}
+	}
+	for field := range c.FieldMappings {
+		fields[field] = true
+	}
+	for field := range c.TableFieldMappings[tableName] {
+		fields[field] = true
+	}
+	// The fields of the webhook receivers and of the routes are checked against every table, as their routes may use any
+	routeFields := func(routes []RouteConfig) {
+		for _, route := range routes {
+			for field := range route.DefaultIncident {
+				fields[field] = true
+			}
+		}
+	}
+	routeFields(c.Routes)
+	for _, receiver := range c.Receivers {
+		for field := range receiver.DefaultIncident {
+			fields[field] = true
+		}
+		for field := range receiver.FieldMappings {
+			fields[field] = true
+		}
+		routeFields(receiver.Routes)
+	}
+	if c.SeverityMapping.enabled() || c.BusinessHours.enabled() {
+		fields["impact"] = true
+		fields["urgency"] = true
+	}
+	if c.mapsPriority() {
+		fields[priorityField] = true
+	}
+	for _, rule := range append(c.Classification.Rules, ClassificationRuleConfig{IncidentClassification: c.Classification.Default}) {
+		if len(rule.Category) > 0 {
+			fields[categoryField] = true
+		}
+		if len(rule.Subcategory) > 0 {
+			fields[subcategoryField] = true
+		}
+	}
+	if len(c.AssignmentGroup.Label) > 0 {
+		fields[assignmentGroupField] = true
+	}
+
+	names := make([]string, 0, len(fields))
+	for field := range fields {
+		if len(field) > 0 {
+			names = append(names, field)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// loadTableSchema fetches the fields, among the checked ones, of the table and of the tables it inherits from, from
+// the dictionary of the ServiceNow instance of the context
+func loadTableSchema(ctx context.Context, tableName string, inheritedTables []string, fields []string) (map[string]bool, error) {
+	if len(fields) == 0 {
+		return map[string]bool{}, nil
+	}
+	if len(inheritedTables) == 0 {
+		inheritedTables = []string{defaultSchemaTable}
+	}
+	tables := append([]string{tableName}, inheritedTables...)
+
+	// A field is defined at most once per table
+	entries, err := serviceNowFrom(ctx).GetIncidents(ctx, dictionaryTable, map[string]string{
+		"sysparm_query":  "nameIN" + strings.Join(tables, ",") + "^elementIN" + strings.Join(fields, ","),
+		"sysparm_fields": "element,read_only",
+		"sysparm_limit":  strconv.Itoa(len(tables) * len(fields)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	schema := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		element, _ := entry["element"].(string)
+		if len(element) == 0 {
+			// Entry of the table itself
+			continue
+		}
+		readOnly, _ := entry["read_only"].(string)
+		schema[element] = schema[element] || readOnly != "true"
+	}
+	return schema, nil
+}
+
+// validateSchema checks that every configured field exists and is writable in the target tables, against the
+// dictionary of the ServiceNow instance of each table.
+// Depending on the configured mode, invalid fields are either returned as an error or only logged.
+func validateSchema(ctx context.Context) error {
+	config := configFrom(ctx)
+	c := config.SchemaValidation
+	if !c.Enabled {
+		return nil
+	}
+
+	var errs strings.Builder
+	var validated []string
+	for _, instance := range append([]string{""}, config.instanceNames()...) {
+		ctx := withInstance(ctx, instance)
+		for _, tableName := range config.instanceTableNames(instance) {
+			table := tableName
+			if len(instance) > 0 {
+				table = fmt.Sprintf("%s of instance %s", tableName, instance)
+			}
+			validated = append(validated, table)
+			fields := configuredFields(*config, tableName)
+			schema, err := loadTableSchema(ctx, tableName, c.InheritedTables, fields)
+			if err != nil {
+				return fmt.Errorf("Error loading the schema of table %s: %v", table, err)
+			}
+
+			for _, field := range fields {
+				writable, ok := schema[field]
+				if !ok {
+					errs.WriteString(fmt.Sprintf("field %s does not exist in table %s\n", field, table))
+				} else if !writable {
+					errs.WriteString(fmt.Sprintf("field %s is read-only in table %s\n", field, table))
+				}
+			}
+		}
+	}
+
+	if errs.Len() == 0 {
+		baseLogger.Infof("Configured fields validated against the schema of table(s) %s", strings.Join(validated, ", "))
+		return nil
+	}
+	if c.Mode == schemaModeWarn {
+		baseLogger.Warnf("Configured fields are invalid:\n%s", errs.String())
+		return nil
+	}
+	return errors.New("Configured fields are invalid\n" + errs.String())
+}
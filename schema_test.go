@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+)
+
+func newDictionaryServer(t *testing.T) *httptest.Server {
+	// Load a simple example of a dictionary response coming from ServiceNow
+	dictionary, err := ioutil.ReadFile("test/sys_dictionary_response.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/sys_dictionary") {
+			t.Errorf("Unexpected table queried: %v", r.URL.Path)
+		}
+		query := r.URL.Query().Get("sysparm_query")
+		fields := strings.TrimPrefix(query, "nameINincident,task^elementIN")
+		if fields == query || !strings.Contains(","+fields+",", ",short_description,") {
+			t.Errorf("Unexpected query: got %v, want the configured fields of nameINincident,task", query)
+		}
+		if limit := r.URL.Query().Get("sysparm_limit"); limit != strconv.Itoa(2*len(strings.Split(fields, ","))) {
+			t.Errorf("Unexpected limit: got %v for fields %v", limit, fields)
+		}
+		fmt.Fprint(w, string(dictionary))
+	}))
+}
+
+func loadSchemaTestConfig(t *testing.T, ts *httptest.Server) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Workflow.IncidentGroupKeyField = "u_prometheus_alertgroup_id"
+	currentConfig().SchemaValidation = SchemaValidationConfig{Enabled: true}
+	snClient, err := NewServiceNowClient("instancename", "username", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	snClient.baseURL = ts.URL
+	useServiceNow(snClient)
+}
+
+func TestValidateSchema_OK(t *testing.T) {
+	ts := newDictionaryServer(t)
+	defer ts.Close()
+	loadSchemaTestConfig(t, ts)
+
+	if err := validateSchema(context.Background()); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestValidateSchema_Instances(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().SchemaValidation = SchemaValidationConfig{Enabled: true}
+	currentConfig().Instances = map[string]ServiceNowConfig{
+		"retail": {InstanceName: "retail", UserName: "retail-user", Password: "retail-password", TableName: "u_retail_incident"},
+	}
+	defaultMock, retailMock := new(MockedSnClient), new(MockedSnClient)
+	defaultMock.On("GetIncidents", dictionaryTable, mock.Anything).Return([]Incident{}, nil)
+	retailMock.On("GetIncidents", dictionaryTable, mock.Anything).Return([]Incident{{"element": "short_description", "read_only": "false"}}, nil)
+	useServiceNow(defaultMock)
+	useServiceNowInstances(map[string]ServiceNow{"retail": retailMock})
+	defer useServiceNowInstances(nil)
+
+	err := validateSchema(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "field short_description does not exist in table incident\n") || strings.Contains(err.Error(), "field short_description does not exist in table u_retail_incident") {
+		t.Errorf("Each table should be checked against the dictionary of its instance: %v", err)
+	}
+	for _, m := range []*MockedSnClient{defaultMock, retailMock} {
+		if len(m.Calls) != 1 {
+			t.Fatalf("Each instance should be queried once: %v", m.Calls)
+		}
+	}
+	if query := defaultMock.Calls[0].Arguments.Get(1).(map[string]string)["sysparm_query"]; !strings.HasPrefix(query, "nameINincident,") {
+		t.Errorf("Unexpected query of the default instance: %v", query)
+	}
+	if query := retailMock.Calls[0].Arguments.Get(1).(map[string]string)["sysparm_query"]; !strings.HasPrefix(query, "nameINu_retail_incident,") {
+		t.Errorf("Unexpected query of the retail instance: %v", query)
+	}
+}
+
+func TestValidateSchema_UnknownField(t *testing.T) {
+	ts := newDictionaryServer(t)
+	defer ts.Close()
+	loadSchemaTestConfig(t, ts)
+	currentConfig().DefaultIncident["short_descripton"] = "typo"
+
+	err := validateSchema(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "field short_descripton does not exist") {
+		t.Errorf("Expected an unknown field error, got %v", err)
+	}
+}
+
+func TestValidateSchema_ReadOnlyField(t *testing.T) {
+	ts := newDictionaryServer(t)
+	defer ts.Close()
+	loadSchemaTestConfig(t, ts)
+	currentConfig().Workflow.IncidentUpdateFields = []string{"sys_created_on"}
+
+	err := validateSchema(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "field sys_created_on is read-only") {
+		t.Errorf("Expected a read-only field error, got %v", err)
+	}
+}
+
+func TestValidateSchema_WarnMode(t *testing.T) {
+	ts := newDictionaryServer(t)
+	defer ts.Close()
+	loadSchemaTestConfig(t, ts)
+	currentConfig().SchemaValidation.Mode = schemaModeWarn
+	currentConfig().DefaultIncident["short_descripton"] = "typo"
+
+	if err := validateSchema(context.Background()); err != nil {
+		t.Errorf("Unexpected error in warn mode: %v", err)
+	}
+}
+
+func TestValidateSchema_Disabled(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	useServiceNow(new(MockedSnClient))
+
+	if err := validateSchema(context.Background()); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestValidateSchema_QueryError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer ts.Close()
+	loadSchemaTestConfig(t, ts)
+
+	if err := validateSchema(context.Background()); err == nil {
+		t.Errorf("Expected an error, got none")
+	}
+}
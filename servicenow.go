@@ -2,21 +2,24 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strconv"
 	"strings"
-
-	"github.com/prometheus/common/log"
+	"time"
 )
 
 const (
 	serviceNowBaseURL   = "https://%s.service-now.com"
-	tableAPI            = "%s/api/now/v2/table/%s"
+	serviceNowAPIPath   = "/api/now"
+	tableAPI            = "%s/v2/table/%s"
+	attachmentAPI       = "%s/attachment/file"
 	hibernatingInstance = "Hibernating Instance"
 )
 
@@ -62,16 +65,27 @@ func (ir IncidentsResponse) GetResults() []Incident {
 
 // ServiceNow interface
 type ServiceNow interface {
-	CreateIncident(tableName string, incidentParam Incident) (Incident, error)
-	GetIncidents(tableName string, params map[string]string) ([]Incident, error)
-	UpdateIncident(tableName string, incidentParam Incident, sysID string) (Incident, error)
+	CreateIncident(ctx context.Context, tableName string, incidentParam Incident) (Incident, error)
+	GetIncidents(ctx context.Context, tableName string, params map[string]string) ([]Incident, error)
+	UpdateIncident(ctx context.Context, tableName string, incidentParam Incident, sysID string) (Incident, error)
+	PatchIncident(ctx context.Context, tableName string, incidentParam Incident, sysID string) (Incident, error)
+	DeleteIncident(ctx context.Context, tableName string, sysID string) error
+	AttachFile(ctx context.Context, tableName string, sysID string, fileName string, contentType string, content []byte) error
+	OnCallUsers(ctx context.Context, groupSysID string) ([]string, error)
 }
 
 // ServiceNowClient is the interface to a ServiceNow instance
 type ServiceNowClient struct {
 	baseURL    string
+	apiURL     string
 	authHeader string
+	oauth2     *oauth2TokenSource
+	retry      RetryConfig
+	breaker    *circuitBreaker
 	client     *http.Client
+
+	// importSet writes the incidents through a staging table when enabled
+	importSet ImportSetConfig
 }
 
 // NewServiceNowClient will create a new ServiceNow client
@@ -79,7 +93,10 @@ func NewServiceNowClient(instanceName string, userName string, password string)
 	if instanceName == "" {
 		return nil, errors.New("Missing instanceName")
 	}
+	return newServiceNowClient(fmt.Sprintf(serviceNowBaseURL, instanceName), userName, password)
+}
 
+func newServiceNowClient(baseURL string, userName string, password string) (*ServiceNowClient, error) {
 	if userName == "" {
 		return nil, errors.New("Missing userName")
 	}
@@ -89,30 +106,58 @@ func NewServiceNowClient(instanceName string, userName string, password string)
 	}
 
 	return &ServiceNowClient{
-		baseURL:    fmt.Sprintf(serviceNowBaseURL, instanceName),
+		baseURL:    baseURL,
 		authHeader: fmt.Sprintf("Basic %s", base64.URLEncoding.EncodeToString([]byte(userName+":"+password))),
 		client:     http.DefaultClient,
 	}, nil
 }
 
+// NewServiceNowOAuth2Client will create a new ServiceNow client authenticated with OAuth2
+func NewServiceNowOAuth2Client(instanceName string, c OAuth2Config) (*ServiceNowClient, error) {
+	if instanceName == "" {
+		return nil, errors.New("Missing instanceName")
+	}
+	return newServiceNowOAuth2Client(fmt.Sprintf(serviceNowBaseURL, instanceName), c)
+}
+
+func newServiceNowOAuth2Client(baseURL string, c OAuth2Config) (*ServiceNowClient, error) {
+	if c.ClientID == "" || c.ClientSecret == "" {
+		return nil, errors.New("Missing OAuth2 client credentials")
+	}
+
+	return &ServiceNowClient{
+		baseURL: baseURL,
+		oauth2:  newOAuth2TokenSource(c, http.DefaultClient),
+		client:  http.DefaultClient,
+	}, nil
+}
+
+// api returns the URL of the REST API of the instance, its api_url when set
+func (snClient *ServiceNowClient) api() string {
+	if len(snClient.apiURL) > 0 {
+		return snClient.apiURL
+	}
+	return snClient.baseURL + serviceNowAPIPath
+}
+
 // Create a table item in ServiceNow from a post body
-func (snClient *ServiceNowClient) create(table string, body []byte) ([]byte, error) {
-	url := fmt.Sprintf(tableAPI, snClient.baseURL, table)
+func (snClient *ServiceNowClient) create(ctx context.Context, table string, body []byte) ([]byte, error) {
+	url := fmt.Sprintf(tableAPI, snClient.api(), table)
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
 	if err != nil {
-		log.Errorf("Error creating the request. %s", err)
+		loggerFrom(ctx).Errorf("Error creating the request. %s", err)
 		return nil, err
 	}
 
-	return snClient.doRequest(req)
+	return snClient.doRequest(ctx, req, apiOperation{table: table, name: apiCreate})
 }
 
 // get a table item from ServiceNow using a map of arguments
-func (snClient *ServiceNowClient) get(table string, params map[string]string) ([]byte, error) {
-	url := fmt.Sprintf(tableAPI, snClient.baseURL, table)
+func (snClient *ServiceNowClient) get(ctx context.Context, table string, params map[string]string) ([]byte, error) {
+	url := fmt.Sprintf(tableAPI, snClient.api(), table)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		log.Errorf("Error creating the request. %s", err)
+		loggerFrom(ctx).Errorf("Error creating the request. %s", err)
 		return nil, err
 	}
 
@@ -122,102 +167,243 @@ func (snClient *ServiceNowClient) get(table string, params map[string]string) ([
 	}
 	req.URL.RawQuery = q.Encode()
 
-	return snClient.doRequest(req)
+	return snClient.doRequest(ctx, req, apiOperation{table: table, name: apiGet})
 }
 
-// update a table item in ServiceNow from a post body and a sys_id
-func (snClient *ServiceNowClient) update(table string, body []byte, sysID string) ([]byte, error) {
-	url := fmt.Sprintf(tableAPI+"/%s", snClient.baseURL, table, sysID)
-	req, err := http.NewRequest("PUT", url, bytes.NewBuffer(body))
+// update a table item in ServiceNow from a post body and a sys_id, with a PUT or a PATCH of the given fields
+func (snClient *ServiceNowClient) update(ctx context.Context, method string, table string, body []byte, sysID string) ([]byte, error) {
+	url := fmt.Sprintf(tableAPI+"/%s", snClient.api(), table, sysID)
+	req, err := http.NewRequest(method, url, bytes.NewBuffer(body))
 	if err != nil {
-		log.Errorf("Error creating the request. %s", err)
+		loggerFrom(ctx).Errorf("Error creating the request. %s", err)
 		return nil, err
 	}
 
-	return snClient.doRequest(req)
+	return snClient.doRequest(ctx, req, apiOperation{table: table, name: apiUpdate})
 }
 
-// doRequest will do the given ServiceNow request and return response as byte array
-func (snClient *ServiceNowClient) doRequest(req *http.Request) ([]byte, error) {
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", snClient.authHeader)
-	resp, err := snClient.client.Do(req)
+// delete a table item in ServiceNow from a sys_id
+func (snClient *ServiceNowClient) delete(ctx context.Context, table string, sysID string) ([]byte, error) {
+	url := fmt.Sprintf(tableAPI+"/%s", snClient.api(), table, sysID)
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		loggerFrom(ctx).Errorf("Error creating the request. %s", err)
+		return nil, err
+	}
+
+	return snClient.doRequest(ctx, req, apiOperation{table: table, name: apiDelete})
+}
 
+// attach uploads a file attached to a table item in ServiceNow from a sys_id
+func (snClient *ServiceNowClient) attach(ctx context.Context, table string, sysID string, fileName string, contentType string, content []byte) ([]byte, error) {
+	url := fmt.Sprintf(attachmentAPI, snClient.api())
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(content))
 	if err != nil {
-		log.Errorf("Error sending the request. %s", err)
+		loggerFrom(ctx).Errorf("Error creating the request. %s", err)
 		return nil, err
 	}
 
-	serviceNowRequests.WithLabelValues(req.URL.Host, req.Method, strconv.Itoa(resp.StatusCode)).Inc()
-	serviceNowLastRequest.SetToCurrentTime()
+	q := req.URL.Query()
+	q.Add("table_name", table)
+	q.Add("table_sys_id", sysID)
+	q.Add("file_name", fileName)
+	req.URL.RawQuery = q.Encode()
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+
+	return snClient.doRequest(ctx, req, apiOperation{table: table, name: apiAttach})
+}
+
+// send sends the request with the authentication header.
+// With OAuth2, a request rejected as unauthorized is retried once with a new access token.
+func (snClient *ServiceNowClient) send(ctx context.Context, req *http.Request) (*http.Response, error) {
+	if snClient.oauth2 == nil {
+		req.Header.Set("Authorization", snClient.authHeader)
+		return snClient.client.Do(req)
+	}
+
+	for attempt := 0; ; attempt++ {
+		authHeader, err := snClient.oauth2.authHeader(ctx, snClient.baseURL)
+		if err != nil {
+			return nil, fmt.Errorf("Error getting the OAuth2 access token: %v", err)
+		}
+		req.Header.Set("Authorization", authHeader)
+		resp, err := snClient.client.Do(req)
+		if err != nil || resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return resp, err
+		}
+
+		resp.Body.Close()
+		loggerFrom(ctx).Warn("ServiceNow rejected the OAuth2 access token, retrying with a new one")
+		snClient.oauth2.invalidate()
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// doRequest will do the given ServiceNow request and return response as byte array.
+// Requests failing with a transient error are retried with an exponential backoff, when configured.
+// When the circuit breaker is enabled, requests fail fast while it is open.
+func (snClient *ServiceNowClient) doRequest(ctx context.Context, req *http.Request, operation apiOperation) (body []byte, err error) {
+	ctx, s := startSpan(ctx, "ServiceNow "+req.Method, spanKindClient)
+	s.setAttribute("http.method", req.Method)
+	s.setAttribute("http.url", req.URL.Scheme+"://"+req.URL.Host+req.URL.Path)
+	defer func() { s.finish(err) }()
+	injectTraceParent(ctx, req)
+	defer func() { serviceNowConnectivity.record(req.URL.Host, err, time.Now()) }()
+
+	if snClient.breaker == nil {
+		body, _, err = snClient.doAllowedRequest(ctx, req, operation)
+		return body, err
+	}
+	if err = snClient.breaker.allow(); err != nil {
+		loggerFrom(ctx).Error(err)
+		return nil, err
+	}
+	body, available, err := snClient.doAllowedRequest(ctx, req, operation)
+	snClient.breaker.record(ctx.Err() == nil, available)
+	return body, err
+}
+
+// doAllowedRequest sends the request, retrying it on transient errors. It also returns whether ServiceNow was available,
+// i.e. answered with a valid response or a client error.
+func (snClient *ServiceNowClient) doAllowedRequest(ctx context.Context, req *http.Request, operation apiOperation) ([]byte, bool, error) {
+	req = req.WithContext(ctx)
+	if len(req.Header.Get("Content-Type")) == 0 {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	logServiceNowRequest(ctx, req)
+	var resp *http.Response
+	budgetExhausted := false
+	for attempt := 0; ; attempt++ {
+		var err error
+		start := time.Now()
+		resp, err = snClient.send(ctx, req)
+		operation.observe(ctx, resp, time.Since(start))
+		if err != nil {
+			loggerFrom(ctx).Errorf("Error sending the request. %s", err)
+			return nil, false, err
+		}
+
+		observeWithExemplar(ctx, serviceNowRequestDuration.WithLabelValues(req.Method, strconv.Itoa(resp.StatusCode)), time.Since(start).Seconds())
+		serviceNowRequests.WithLabelValues(req.URL.Host, req.Method, strconv.Itoa(resp.StatusCode)).Inc()
+		serviceNowLastRequest.SetToCurrentTime()
+		spanFrom(ctx).setAttribute("http.status_code", resp.StatusCode)
+		spanFrom(ctx).setAttribute("retries", attempt)
+
+		if !retryableStatus(resp.StatusCode) {
+			break
+		}
+		delay, ok := snClient.retry.backoff(attempt, parseRetryAfter(resp.Header, time.Now()))
+		if !ok {
+			break
+		}
+		if !retryBudgetFrom(ctx).spend(snClient.retry.Budget, delay, time.Now()) {
+			budgetExhausted = true
+			serviceNowRetryBudgetExhausted.Inc()
+			loggerFrom(ctx).Warnf("ServiceNow returned the HTTP error code: %v, not retried as the retry budget of the notification is exhausted", resp.StatusCode)
+			break
+		}
+		resp.Body.Close()
+		serviceNowRetries.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
+		loggerFrom(ctx).Warnf("ServiceNow returned the HTTP error code: %v, retrying in %v (retry %d/%d)", resp.StatusCode, delay, attempt+1, snClient.retry.MaxRetries)
+		if err := waitRetry(ctx, req, delay); err != nil {
+			return nil, false, err
+		}
+	}
 
 	if resp.StatusCode >= 400 {
+		if loggerFrom(ctx).DebugEnabled() {
+			body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxLoggedBodySize))
+			logServiceNowResponse(ctx, resp.StatusCode, body)
+		}
+		resp.Body.Close()
 		errorMsg := fmt.Sprintf("ServiceNow returned the HTTP error code: %v", resp.StatusCode)
-		log.Error(errorMsg)
-		return nil, errors.New(errorMsg)
+		loggerFrom(ctx).Error(errorMsg)
+		available := resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests
+		if budgetExhausted {
+			// The notification fails fast, to be retried by Alertmanager after the Retry-After rather than holding a worker
+			return nil, available, &overloadError{message: errorMsg + ", the retry budget of the notification is exhausted", retryAfter: parseRetryAfter(resp.Header, time.Now())}
+		}
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+			return nil, available, &overloadError{message: errorMsg, retryAfter: parseRetryAfter(resp.Header, time.Now())}
+		}
+		return nil, available, &httpStatusError{statusCode: resp.StatusCode, message: errorMsg}
 	}
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, true, nil
+	}
+
 	responseBody, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Errorf("Error reading the body. %s", err)
-		return nil, err
+		loggerFrom(ctx).Errorf("Error reading the body. %s", err)
+		return nil, false, err
 	}
+	logServiceNowResponse(ctx, resp.StatusCode, responseBody)
 
 	if !json.Valid(responseBody) {
 		if strings.Contains(string(responseBody), hibernatingInstance) {
-			return nil, errors.New("ServiceNow is in sleeping mode and is unavailable (Hibernating Instance)")
+			return nil, false, errors.New("ServiceNow is in sleeping mode and is unavailable (Hibernating Instance)")
 		}
-		return nil, errors.New("ServiceNow is unavailable (API return format is not valid JSON)")
+		return nil, false, errors.New("ServiceNow is unavailable (API return format is not valid JSON)")
 	}
 
-	return responseBody, nil
+	return responseBody, true, nil
 }
 
 // CreateIncident will create an incident in ServiceNow from a given Incident, and return the created incident
-func (snClient *ServiceNowClient) CreateIncident(tableName string, incidentParam Incident) (Incident, error) {
-	log.Info("Create a ServiceNow incident")
+func (snClient *ServiceNowClient) CreateIncident(ctx context.Context, tableName string, incidentParam Incident) (Incident, error) {
+	loggerFrom(ctx).Info("Create a ServiceNow incident")
+	if snClient.importSet.enabled() {
+		return snClient.importIncident(ctx, tableName, incidentParam, "")
+	}
 
 	postBody, err := json.Marshal(incidentParam)
 	if err != nil {
-		log.Errorf("Error while marshalling the incident. %s", err)
+		loggerFrom(ctx).Errorf("Error while marshalling the incident. %s", err)
 		return nil, err
 	}
 
-	response, err := snClient.create(tableName, postBody)
+	response, err := snClient.create(ctx, tableName, postBody)
 	if err != nil {
-		log.Errorf("Error while creating the incident. %s", err)
+		loggerFrom(ctx).Errorf("Error while creating the incident. %s", err)
 		return nil, err
 	}
 
 	incidentResponse := IncidentResponse{}
 	err = json.Unmarshal(response, &incidentResponse)
 	if err != nil {
-		log.Errorf("Error while unmarshalling the incident. %s", err)
+		loggerFrom(ctx).Errorf("Error while unmarshalling the incident. %s", err)
 		return nil, err
 	}
 
 	createdIncident := incidentResponse.GetResult()
-	log.Infof("Incident %s created", createdIncident.GetNumber())
+	loggerFrom(ctx).Infof("Incident %s created", createdIncident.GetNumber())
 
 	return createdIncident, nil
 }
 
 // GetIncidents will retrieve an incident from ServiceNow
-func (snClient *ServiceNowClient) GetIncidents(tableName string, params map[string]string) ([]Incident, error) {
-	log.Infof("Get ServiceNow incidents with params: %v", params)
-	response, err := snClient.get(tableName, params)
+func (snClient *ServiceNowClient) GetIncidents(ctx context.Context, tableName string, params map[string]string) ([]Incident, error) {
+	loggerFrom(ctx).Infof("Get ServiceNow incidents with params: %v", params)
+	response, err := snClient.get(ctx, tableName, params)
 
 	if err != nil {
-		log.Errorf("Error while getting the incident. %s", err)
+		loggerFrom(ctx).Errorf("Error while getting the incident. %s", err)
 		return nil, err
 	}
 
 	incidentsResponse := IncidentsResponse{}
 	err = json.Unmarshal(response, &incidentsResponse)
 	if err != nil {
-		log.Errorf("Error while unmarshalling the incident. %s", err)
+		loggerFrom(ctx).Errorf("Error while unmarshalling the incident. %s", err)
 		return nil, err
 	}
 
@@ -225,30 +411,72 @@ func (snClient *ServiceNowClient) GetIncidents(tableName string, params map[stri
 }
 
 // UpdateIncident will update an incident in ServiceNow from a given Incident, and return the updated incident
-func (snClient *ServiceNowClient) UpdateIncident(tableName string, incidentParam Incident, sysID string) (Incident, error) {
-	log.Infof("Update %v field(s) of ServiceNow incident with id : %s", len(incidentParam), sysID)
+func (snClient *ServiceNowClient) UpdateIncident(ctx context.Context, tableName string, incidentParam Incident, sysID string) (Incident, error) {
+	loggerFrom(ctx).Infof("Update %v field(s) of ServiceNow incident with id : %s", len(incidentParam), sysID)
+	return snClient.updateIncident(ctx, http.MethodPut, tableName, incidentParam, sysID)
+}
+
+// PatchIncident will patch the given fields of an incident in ServiceNow, leaving the other fields untouched,
+// and return the patched incident
+func (snClient *ServiceNowClient) PatchIncident(ctx context.Context, tableName string, incidentParam Incident, sysID string) (Incident, error) {
+	loggerFrom(ctx).Infof("Patch %v field(s) of ServiceNow incident with id : %s", len(incidentParam), sysID)
+	return snClient.updateIncident(ctx, http.MethodPatch, tableName, incidentParam, sysID)
+}
+
+func (snClient *ServiceNowClient) updateIncident(ctx context.Context, method string, tableName string, incidentParam Incident, sysID string) (Incident, error) {
+	if snClient.importSet.enabled() {
+		return snClient.importIncident(ctx, tableName, incidentParam, sysID)
+	}
 
 	postBody, err := json.Marshal(incidentParam)
 	if err != nil {
-		log.Errorf("Error while marshalling the incident. %s", err)
+		loggerFrom(ctx).Errorf("Error while marshalling the incident. %s", err)
 		return nil, err
 	}
 
-	response, err := snClient.update(tableName, postBody, sysID)
+	response, err := snClient.update(ctx, method, tableName, postBody, sysID)
 	if err != nil {
-		log.Errorf("Error while updating the incident. %s", err)
+		loggerFrom(ctx).Errorf("Error while updating the incident. %s", err)
 		return nil, err
 	}
 
 	incidentResponse := IncidentResponse{}
 	err = json.Unmarshal(response, &incidentResponse)
 	if err != nil {
-		log.Errorf("Error while unmarshalling the incident. %s", err)
+		loggerFrom(ctx).Errorf("Error while unmarshalling the incident. %s", err)
 		return nil, err
 	}
 
 	updatedIncident := incidentResponse.GetResult()
-	log.Infof("Incident %s updated", updatedIncident.GetNumber())
+	loggerFrom(ctx).Infof("Incident %s updated", updatedIncident.GetNumber())
 
 	return updatedIncident, nil
 }
+
+// DeleteIncident will delete an incident in ServiceNow from a given sys_id
+func (snClient *ServiceNowClient) DeleteIncident(ctx context.Context, tableName string, sysID string) error {
+	loggerFrom(ctx).Infof("Delete ServiceNow incident with id : %s", sysID)
+
+	_, err := snClient.delete(ctx, tableName, sysID)
+	if err != nil {
+		loggerFrom(ctx).Errorf("Error while deleting the incident. %s", err)
+		return err
+	}
+
+	loggerFrom(ctx).Infof("Incident with id %s deleted", sysID)
+	return nil
+}
+
+// AttachFile will attach a file to an incident in ServiceNow from a given sys_id
+func (snClient *ServiceNowClient) AttachFile(ctx context.Context, tableName string, sysID string, fileName string, contentType string, content []byte) error {
+	loggerFrom(ctx).Infof("Attach file %s to ServiceNow incident with id : %s", fileName, sysID)
+
+	_, err := snClient.attach(ctx, tableName, sysID, fileName, contentType, content)
+	if err != nil {
+		loggerFrom(ctx).Errorf("Error while attaching the file. %s", err)
+		return err
+	}
+
+	loggerFrom(ctx).Infof("File %s attached to incident with id %s", fileName, sysID)
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -90,7 +91,7 @@ func TestCreateIncident_OK(t *testing.T) {
 		t.Errorf("Error occured on NewServiceNowClient: %s", err)
 	}
 
-	incident, err := snClient.CreateIncident("incident", basicIncidentParam)
+	incident, err := snClient.CreateIncident(context.Background(), "incident", basicIncidentParam)
 
 	if err != nil {
 		t.Errorf("Error occured on CreateIncident: %s", err)
@@ -124,7 +125,7 @@ func TestCreateIncident_OK_No_AG(t *testing.T) {
 		t.Errorf("Error occured on NewServiceNowClient: %s", err)
 	}
 
-	incident, err := snClient.CreateIncident("incident", basicIncidentParam)
+	incident, err := snClient.CreateIncident(context.Background(), "incident", basicIncidentParam)
 
 	if err != nil {
 		t.Errorf("Error occured on CreateIncident: %s", err)
@@ -151,7 +152,7 @@ func TestCreateIncident_IncidentMarshallError(t *testing.T) {
 	}
 
 	// Cause an error by using invalid incident
-	_, err = snClient.CreateIncident("incident", wrongIncidentParam)
+	_, err = snClient.CreateIncident(context.Background(), "incident", wrongIncidentParam)
 
 	if err == nil {
 		t.Errorf("Expected an error, got none")
@@ -167,7 +168,7 @@ func TestCreateIncident_CreateRequestError(t *testing.T) {
 		t.Errorf("Error occured on NewServiceNowClient: %s", err)
 	}
 
-	_, err = snClient.CreateIncident("incident", basicIncidentParam)
+	_, err = snClient.CreateIncident(context.Background(), "incident", basicIncidentParam)
 
 	if err == nil {
 		t.Errorf("Expected an error, got none")
@@ -187,7 +188,7 @@ func TestCreateIncident_DoRequestError(t *testing.T) {
 
 	// Cause an error by closing the server
 	ts.Close()
-	_, err = snClient.CreateIncident("incident", basicIncidentParam)
+	_, err = snClient.CreateIncident(context.Background(), "incident", basicIncidentParam)
 
 	if err == nil {
 		t.Errorf("Expected an error, got none")
@@ -209,7 +210,7 @@ func TestCreateIncident_InternalServerError(t *testing.T) {
 		t.Errorf("Error occured on NewServiceNowClient: %s", err)
 	}
 
-	_, err = snClient.CreateIncident("incident", basicIncidentParam)
+	_, err = snClient.CreateIncident(context.Background(), "incident", basicIncidentParam)
 
 	if err == nil {
 		t.Errorf("Expected an error, got none")
@@ -234,7 +235,7 @@ func TestGetIncidents_OK(t *testing.T) {
 		t.Errorf("Error occured on NewServiceNowClient: %s", err)
 	}
 
-	incidents, err := snClient.GetIncidents("incident", nil)
+	incidents, err := snClient.GetIncidents(context.Background(), "incident", nil)
 	if err != nil {
 		t.Errorf("Error occured on CreateIncident: %s", err)
 	}
@@ -256,7 +257,7 @@ func TestGetIncidents_CreateRequestError(t *testing.T) {
 		t.Errorf("Error occured on NewServiceNowClient: %s", err)
 	}
 
-	_, err = snClient.GetIncidents("incident", nil)
+	_, err = snClient.GetIncidents(context.Background(), "incident", nil)
 
 	if err == nil {
 		t.Errorf("Expected an error, got none")
@@ -283,7 +284,7 @@ func TestUpdateIncident_OK(t *testing.T) {
 		t.Errorf("Error occured on NewServiceNowClient: %s", err)
 	}
 
-	incident, err := snClient.UpdateIncident("incident", basicIncidentParam, "my_sys_id")
+	incident, err := snClient.UpdateIncident(context.Background(), "incident", basicIncidentParam, "my_sys_id")
 
 	if err != nil {
 		t.Errorf("Error occured on UpdateIncident: %s", err)
@@ -306,7 +307,105 @@ func TestUpdateIncident_CreateRequestError(t *testing.T) {
 		t.Errorf("Error occured on NewServiceNowClient: %s", err)
 	}
 
-	_, err = snClient.UpdateIncident("incident", basicIncidentParam, "my_sys_id")
+	_, err = snClient.UpdateIncident(context.Background(), "incident", basicIncidentParam, "my_sys_id")
+
+	if err == nil {
+		t.Errorf("Expected an error, got none")
+	}
+}
+
+func TestDeleteIncident_OK(t *testing.T) {
+	testHandler := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "DELETE" {
+			t.Errorf("Unexpected method; got: %v, want: %v", r.Method, "DELETE")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(testHandler))
+	defer ts.Close()
+
+	snClient, err := NewServiceNowClient("instancename", "username", "password")
+	snClient.baseURL = ts.URL
+
+	if err != nil {
+		t.Errorf("Error occured on NewServiceNowClient: %s", err)
+	}
+
+	err = snClient.DeleteIncident(context.Background(), "incident", "my_sys_id")
+
+	if err != nil {
+		t.Errorf("Error occured on DeleteIncident: %s", err)
+	}
+}
+
+func TestDeleteIncident_CreateRequestError(t *testing.T) {
+	snClient, err := NewServiceNowClient("instancename", "username", "password")
+	// Cause an error by using an invalid URL
+	snClient.baseURL = "very bad url"
+
+	if err != nil {
+		t.Errorf("Error occured on NewServiceNowClient: %s", err)
+	}
+
+	err = snClient.DeleteIncident(context.Background(), "incident", "my_sys_id")
+
+	if err == nil {
+		t.Errorf("Expected an error, got none")
+	}
+}
+
+func TestAttachFile_OK(t *testing.T) {
+	testHandler := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/api/now/attachment/file" {
+			t.Errorf("Unexpected request; got: %v %v", r.Method, r.URL.Path)
+		}
+		q := r.URL.Query()
+		if q.Get("table_name") != "incident" || q.Get("table_sys_id") != "my_sys_id" || q.Get("file_name") != "alerts.json" {
+			t.Errorf("Unexpected query; got: %v", q)
+		}
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Unexpected content type; got: %v", r.Header.Get("Content-Type"))
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) != `{"status":"firing"}` {
+			t.Errorf("Unexpected body; got: %s", body)
+		}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"result":{"sys_id":"attachment_sys_id"}}`)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(testHandler))
+	defer ts.Close()
+
+	snClient, err := NewServiceNowClient("instancename", "username", "password")
+	snClient.baseURL = ts.URL
+
+	if err != nil {
+		t.Errorf("Error occured on NewServiceNowClient: %s", err)
+	}
+
+	err = snClient.AttachFile(context.Background(), "incident", "my_sys_id", "alerts.json", "application/json", []byte(`{"status":"firing"}`))
+
+	if err != nil {
+		t.Errorf("Error occured on AttachFile: %s", err)
+	}
+}
+
+func TestAttachFile_Error(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer ts.Close()
+
+	snClient, err := NewServiceNowClient("instancename", "username", "password")
+	snClient.baseURL = ts.URL
+
+	if err != nil {
+		t.Errorf("Error occured on NewServiceNowClient: %s", err)
+	}
+
+	err = snClient.AttachFile(context.Background(), "incident", "my_sys_id", "alerts.txt", "text/plain", []byte("firing"))
 
 	if err == nil {
 		t.Errorf("Expected an error, got none")
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+const defaultSeverityLabel = "severity"
+
+// SeverityMappingConfig - Mapping of the alert severity to the incident impact, urgency and priority
+type SeverityMappingConfig struct {
+	Label   string                   `yaml:"label"`
+	Levels  map[string]SeverityLevel `yaml:"levels"`
+	Default SeverityLevel            `yaml:"default"`
+}
+
+// SeverityLevel - Incident impact, urgency and priority of an alert severity. The priority is only kept by the
+// instances allowing to set it directly, the others computing it from the impact and the urgency.
+type SeverityLevel struct {
+	Impact   string `yaml:"impact"`
+	Urgency  string `yaml:"urgency"`
+	Priority string `yaml:"priority"`
+}
+
+func (c SeverityMappingConfig) validate(errs *strings.Builder) {
+	for severity, level := range c.Levels {
+		level.validate(fmt.Sprintf("severity_mapping.levels.%s", severity), errs)
+	}
+	c.Default.validate("severity_mapping.default", errs)
+}
+
+func (l SeverityLevel) validate(name string, errs *strings.Builder) {
+	for field, value := range map[string]string{"impact": l.Impact, "urgency": l.Urgency, priorityField: l.Priority} {
+		if _, err := strconv.Atoi(value); len(value) > 0 && err != nil {
+			errs.WriteString(fmt.Sprintf("%s.%s must be an integer, got %q\n", name, field, value))
+		}
+	}
+}
+
+func (c SeverityMappingConfig) enabled() bool {
+	return len(c.Levels) > 0
+}
+
+// level returns the impact, urgency and priority of the severity of the alert group, or the default ones when it is missing or unknown.
+// The severity common to the alert group is used, or else the one of its first alert having it.
+func (c SeverityMappingConfig) level(data template.Data) SeverityLevel {
+	label := c.Label
+	if len(label) == 0 {
+		label = defaultSeverityLabel
+	}
+	severity := fieldMapping{source: fieldMappingLabel, name: label}.value(data)
+	if level, ok := c.Levels[severity]; ok {
+		return level
+	}
+	return c.Default
+}
+
+// applySeverityMapping sets the incident impact, urgency and priority from the alert group severity, overriding the
+// templated ones
+func applySeverityMapping(c SeverityMappingConfig, incident Incident, data template.Data) {
+	if !c.enabled() {
+		return
+	}
+	c.level(data).apply(incident)
+}
+
+// apply sets the impact, urgency and priority of the level on the incident
+func (l SeverityLevel) apply(incident Incident) {
+	if len(l.Impact) > 0 {
+		incident["impact"] = l.Impact
+	}
+	if len(l.Urgency) > 0 {
+		incident["urgency"] = l.Urgency
+	}
+	if len(l.Priority) > 0 {
+		incident[priorityField] = l.Priority
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+func TestApplySeverityMapping(t *testing.T) {
+	c := SeverityMappingConfig{
+		Levels: map[string]SeverityLevel{
+			"critical": {Impact: "1", Urgency: "1"},
+			"warning":  {Impact: "3", Urgency: "3"},
+		},
+		Default: SeverityLevel{Impact: "3", Urgency: "4"},
+	}
+	tests := []struct {
+		name        string
+		config      SeverityMappingConfig
+		data        template.Data
+		wantImpact  string
+		wantUrgency string
+	}{
+		{name: "critical", config: c, data: template.Data{CommonLabels: template.KV{"severity": "critical"}}, wantImpact: "1", wantUrgency: "1"},
+		{name: "alert_label", config: c, data: template.Data{Alerts: template.Alerts{template.Alert{Labels: template.KV{"severity": "warning"}}}}, wantImpact: "3", wantUrgency: "3"},
+		{name: "unknown", config: c, data: template.Data{CommonLabels: template.KV{"severity": "info"}}, wantImpact: "3", wantUrgency: "4"},
+		{name: "missing", config: c, data: template.Data{}, wantImpact: "3", wantUrgency: "4"},
+		{name: "other_label", config: SeverityMappingConfig{Label: "priority", Levels: c.Levels}, data: template.Data{CommonLabels: template.KV{"priority": "critical"}}, wantImpact: "1", wantUrgency: "1"},
+		{name: "no_default", config: SeverityMappingConfig{Levels: c.Levels}, data: template.Data{}, wantImpact: "2", wantUrgency: "2"},
+		{name: "disabled", data: template.Data{CommonLabels: template.KV{"severity": "critical"}}, wantImpact: "2", wantUrgency: "2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			incident := Incident{"impact": "2", "urgency": "2"}
+			applySeverityMapping(tt.config, incident, tt.data)
+			if incident["impact"] != tt.wantImpact || incident["urgency"] != tt.wantUrgency {
+				t.Errorf("Unexpected impact/urgency: got %v/%v, want %v/%v", incident["impact"], incident["urgency"], tt.wantImpact, tt.wantUrgency)
+			}
+		})
+	}
+}
+
+func TestApplySeverityMapping_Priority(t *testing.T) {
+	c := SeverityMappingConfig{Levels: map[string]SeverityLevel{"critical": {Impact: "1", Urgency: "1", Priority: "1"}}}
+	incident := Incident{"impact": "2", "urgency": "2"}
+	applySeverityMapping(c, incident, template.Data{CommonLabels: template.KV{"severity": "critical"}})
+	if incident[priorityField] != "1" {
+		t.Errorf("The priority should be set: %v", incident)
+	}
+	incident = Incident{"impact": "2", "urgency": "2"}
+	applySeverityMapping(c, incident, template.Data{})
+	if _, ok := incident[priorityField]; ok {
+		t.Errorf("The priority should not be set without mapping: %v", incident)
+	}
+}
+
+func TestSeverityMappingConfig_Validate(t *testing.T) {
+	var errs strings.Builder
+	SeverityMappingConfig{Levels: map[string]SeverityLevel{"critical": {Impact: "high", Priority: "P1"}}, Default: SeverityLevel{Urgency: "low"}}.validate(&errs)
+	if !strings.Contains(errs.String(), "severity_mapping.levels.critical.impact") || !strings.Contains(errs.String(), "severity_mapping.default.urgency") || !strings.Contains(errs.String(), "severity_mapping.levels.critical.priority") {
+		t.Errorf("Unexpected validation errors: %q", errs.String())
+	}
+}
+
+func TestAlertGroupToIncident_SeverityMapping(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().SeverityMapping = SeverityMappingConfig{Levels: map[string]SeverityLevel{"critical": {Impact: "1", Urgency: "1"}}}
+	applyConfig()
+
+	incident, err := alertGroupToIncident(context.Background(), "incident", template.Data{CommonLabels: template.KV{"severity": "critical"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if incident["impact"] != "1" || incident["urgency"] != "1" {
+		t.Errorf("Unexpected impact/urgency: got %v/%v, want 1/1", incident["impact"], incident["urgency"])
+	}
+}
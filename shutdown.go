@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// shutdown stops the webhook: the server stops accepting requests and waits for the requests in flight, then the
+// background tasks are stopped, the asynchronous workers processing the alert groups still queued. Each step waits
+// for the grace period at most, and the alert groups still queued afterwards are persisted to the dead-letter queue.
+func shutdown(server *http.Server, gracePeriod time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		baseLogger.Warnf("Requests still in flight after %v: %v", gracePeriod, err)
+	}
+
+	if running := backgroundTasks.Stop(gracePeriod); len(running) > 0 {
+		baseLogger.Warnf("Background tasks still running after %v: %v", gracePeriod, running)
+	}
+	if alertGroupQueue != nil {
+		if abandoned := alertGroupQueue.abandon(); abandoned > 0 {
+			baseLogger.Warnf("%d queued alert group(s) not processed before shutdown", abandoned)
+		}
+	}
+
+	if closer, ok := dedupStore.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			baseLogger.Errorf("Error closing the deduplication store: %v", err)
+		}
+	}
+	if auditLog != nil {
+		if err := auditLog.Close(); err != nil {
+			baseLogger.Errorf("Error closing the audit log: %v", err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+func TestShutdown_DrainsRequestsInFlight(t *testing.T) {
+	defer func(tasks *taskGroup) { backgroundTasks = tasks }(backgroundTasks)
+	backgroundTasks = newTaskGroup()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})}
+	go serve(server, listener, "", "")
+
+	result := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String() + "/")
+		if err == nil {
+			resp.Body.Close()
+		}
+		result <- err
+	}()
+	<-started
+
+	shutdown(server, time.Second)
+	if err := <-result; err != nil {
+		t.Errorf("Request in flight should complete before shutdown: %v", err)
+	}
+	if _, err := http.Get("http://" + listener.Addr().String() + "/"); err == nil {
+		t.Errorf("Server should not accept requests after shutdown")
+	}
+}
+
+func TestShutdown_DeadLettersQueuedAlertGroups(t *testing.T) {
+	defer func(tasks *taskGroup) { backgroundTasks = tasks }(backgroundTasks)
+	backgroundTasks = newTaskGroup()
+	q, cleanup := newTestDeadLetterQueue(t)
+	defer cleanup()
+
+	// No worker consumes the queue
+	alertGroupQueue = newAsyncQueue(10)
+	defer func() { alertGroupQueue = nil }()
+	for i := 0; i < 2; i++ {
+		if err := alertGroupQueue.enqueue(context.Background(), template.Data{Status: []string{"firing", "resolved"}[i]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	shutdown(&http.Server{}, time.Second)
+	entries, err := q.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("Unexpected dead letters: got %d, want 2", len(entries))
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSignatureConfig_VerifySignature(t *testing.T) {
+	c := SignatureConfig{Secret: "secret"}
+	body := `{"status":"firing"}`
+	tests := []struct {
+		name      string
+		config    SignatureConfig
+		signature string
+		body      string
+		wantErr   bool
+	}{
+		{name: "disabled", config: SignatureConfig{}, body: body},
+		{name: "signed", config: c, signature: c.sign([]byte(body)), body: body},
+		{name: "prefixed", config: c, signature: "sha256=" + strings.ToUpper(c.sign([]byte(body))), body: body},
+		{name: "sha512", config: SignatureConfig{Secret: "secret", Algorithm: signatureSHA512}, signature: SignatureConfig{Secret: "secret", Algorithm: signatureSHA512}.sign([]byte(body)), body: body},
+		{name: "missing", config: c, body: body, wantErr: true},
+		{name: "tampered", config: c, signature: c.sign([]byte(body)), body: `{"status":"resolved"}`, wantErr: true},
+		{name: "wrong_secret", config: c, signature: SignatureConfig{Secret: "other"}.sign([]byte(body)), body: body, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/webhook", strings.NewReader(tt.body))
+			if len(tt.signature) > 0 {
+				req.Header.Set(defaultSignatureHeader, tt.signature)
+			}
+			if err := tt.config.verifySignature(req); (err != nil) != tt.wantErr {
+				t.Fatalf("Unexpected verification error: %v", err)
+			}
+			if read, _ := ioutil.ReadAll(req.Body); string(read) != tt.body {
+				t.Errorf("The body should be left readable: %q", read)
+			}
+		})
+	}
+}
+
+func TestWebhook_InvalidSignature(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Webhook.Signature = SignatureConfig{Secret: "secret", Header: "X-Alertmanager-Signature"}
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	before := testutil.ToFloat64(webhookSignatureFailures.WithLabelValues("invalid"))
+
+	req := httptest.NewRequest("POST", "/webhook", strings.NewReader(twoAlertsNotification))
+	req.Header.Set("X-Alertmanager-Signature", currentConfig().Webhook.Signature.sign([]byte("{}")))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(webhook).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Wrong status code: got %v, want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if len(snClientMock.Calls) > 0 {
+		t.Errorf("ServiceNow should not be called on tampered requests")
+	}
+	if got := testutil.ToFloat64(webhookSignatureFailures.WithLabelValues("invalid")) - before; got != 1 {
+		t.Errorf("The invalid signature should be counted: got %v", got)
+	}
+}
+
+func TestSignatureConfig_Validate(t *testing.T) {
+	var errs strings.Builder
+	SignatureConfig{Algorithm: "md5"}.validate(&errs)
+	for _, want := range []string{"webhook.signature.algorithm", "webhook.signature.secret is missing"} {
+		if !strings.Contains(errs.String(), want) {
+			t.Errorf("Missing validation error %q: %q", want, errs.String())
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	alertmanagerSilencesAPI       = "%s/api/v2/silences"
+	alertmanagerSilenceAPI        = "%s/api/v2/silence/%s"
+	defaultAlertmanagerTimeout    = 10 * time.Second
+	defaultSilenceDuration        = 4 * time.Hour
+	defaultSilenceCreatedBy       = "alertmanager-webhook-servicenow"
+	silenceCreated                = "created"
+	silenceExpired                = "expired"
+	silenceError                  = "error"
+	silenceRenewalMarginIntervals = 2
+)
+
+var (
+	// defaultSilenceStates are the In Progress and On Hold states of the incidents
+	defaultSilenceStates = []json.Number{"2", "3"}
+
+	alertmanagerSilences = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_alertmanager_silences_total",
+			Help: "Total number of Alertmanager silences requested for the alert groups of acknowledged incidents, by result (created, expired or error).",
+		},
+		[]string{"result"},
+	)
+)
+
+// AlertmanagerConfig - Alertmanager API, called back to silence the alert groups whose incident is being worked on
+type AlertmanagerConfig struct {
+	// URL of the Alertmanager, defaulting to the external URL of the notifications of the alert group
+	URL       string          `yaml:"url"`
+	BasicAuth BasicAuthConfig `yaml:"basic_auth"`
+	Timeout   time.Duration   `yaml:"timeout"`
+	Silences  SilencesConfig  `yaml:"silences"`
+}
+
+// SilencesConfig - Silences created in Alertmanager for the alert groups whose incident moved to an acknowledged state,
+// such as In Progress or On Hold, stopping the repeated notifications while someone is working the ticket. They are
+// detected by the incident reconciliation.
+type SilencesConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// States of the incidents acknowledged
+	States []json.Number `yaml:"states"`
+	// Duration of the silences, renewed while the incident stays acknowledged
+	Duration  time.Duration `yaml:"duration"`
+	CreatedBy string        `yaml:"created_by"`
+}
+
+func (c AlertmanagerConfig) validate(config Config, errs *strings.Builder) {
+	if len(c.URL) > 0 {
+		if u, err := url.Parse(c.URL); err != nil || len(u.Host) == 0 || (u.Scheme != "http" && u.Scheme != "https") {
+			errs.WriteString("alertmanager.url must be an absolute http or https URL\n")
+		}
+	}
+	if len(c.BasicAuth.Username) > 0 && len(c.BasicAuth.Password) == 0 {
+		errs.WriteString("alertmanager.basic_auth.password is missing\n")
+	}
+	if c.Silences.Enabled && !config.Workflow.Reconciliation.Enabled {
+		errs.WriteString("alertmanager.silences requires workflow.reconciliation to be enabled, detecting the acknowledged incidents\n")
+	}
+	for _, state := range c.Silences.States {
+		for _, noUpdateState := range config.Workflow.NoUpdateStates {
+			if state == noUpdateState {
+				errs.WriteString(fmt.Sprintf("alertmanager.silences.states must not hold the no update state %s\n", state))
+			}
+		}
+	}
+}
+
+func (c AlertmanagerConfig) timeout() time.Duration {
+	if c.Timeout <= 0 {
+		return defaultAlertmanagerTimeout
+	}
+	return c.Timeout
+}
+
+func (c SilencesConfig) states() map[json.Number]bool {
+	states := c.States
+	if len(states) == 0 {
+		states = defaultSilenceStates
+	}
+	set := make(map[json.Number]bool, len(states))
+	for _, state := range states {
+		set[state] = true
+	}
+	return set
+}
+
+func (c SilencesConfig) duration() time.Duration {
+	if c.Duration <= 0 {
+		return defaultSilenceDuration
+	}
+	return c.Duration
+}
+
+func (c SilencesConfig) createdBy() string {
+	if len(c.CreatedBy) == 0 {
+		return defaultSilenceCreatedBy
+	}
+	return c.CreatedBy
+}
+
+// silenceMatcher is a label matcher of an Alertmanager silence
+type silenceMatcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex"`
+}
+
+// silence is an Alertmanager silence, as posted to the API v2
+type silence struct {
+	Matchers  []silenceMatcher `json:"matchers"`
+	StartsAt  time.Time        `json:"startsAt"`
+	EndsAt    time.Time        `json:"endsAt"`
+	CreatedBy string           `json:"createdBy"`
+	Comment   string           `json:"comment"`
+}
+
+// alertmanagerClient calls the Alertmanager API
+type alertmanagerClient struct {
+	config AlertmanagerConfig
+	client *http.Client
+}
+
+func newAlertmanagerClient(c AlertmanagerConfig) *alertmanagerClient {
+	return &alertmanagerClient{config: c, client: &http.Client{Timeout: c.timeout()}}
+}
+
+// CreateSilence posts the silence to the Alertmanager at the URL, returning its ID
+func (a *alertmanagerClient) CreateSilence(ctx context.Context, alertmanagerURL string, s silence) (string, error) {
+	body, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf(alertmanagerSilencesAPI, strings.TrimRight(alertmanagerURL, "/")), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if len(a.config.BasicAuth.Username) > 0 {
+		req.SetBasicAuth(a.config.BasicAuth.Username, a.config.BasicAuth.Password)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf("Alertmanager returned the HTTP error code %v: %s", resp.StatusCode, strings.TrimSpace(string(responseBody)))
+	}
+
+	var response struct {
+		SilenceID string `json:"silenceID"`
+	}
+	if err := json.Unmarshal(responseBody, &response); err != nil {
+		return "", fmt.Errorf("invalid Alertmanager silence response: %v", err)
+	}
+	return response.SilenceID, nil
+}
+
+// ExpireSilence expires the silence of the ID in the Alertmanager at the URL
+func (a *alertmanagerClient) ExpireSilence(ctx context.Context, alertmanagerURL string, id string) error {
+	req, err := http.NewRequest("DELETE", fmt.Sprintf(alertmanagerSilenceAPI, strings.TrimRight(alertmanagerURL, "/"), url.PathEscape(id)), nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if len(a.config.BasicAuth.Username) > 0 {
+		req.SetBasicAuth(a.config.BasicAuth.Username, a.config.BasicAuth.Password)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("Alertmanager returned the HTTP error code %v: %s", resp.StatusCode, strings.TrimSpace(string(responseBody)))
+	}
+	return nil
+}
+
+// alertmanagerURL returns the URL of the Alertmanager of the alert group: alertmanager.url, or else the external URL
+// of its notifications
+func (c AlertmanagerConfig) alertmanagerURL(data template.Data) string {
+	if len(c.URL) > 0 {
+		return c.URL
+	}
+	return data.ExternalURL
+}
+
+// silenceMatchers returns the matchers of the silence of the alert group: its group labels, or else its common labels
+// when the alerts are not grouped by label
+func silenceMatchers(data template.Data) []silenceMatcher {
+	labels := data.GroupLabels
+	if len(labels) == 0 {
+		labels = data.CommonLabels
+	}
+	var matchers []silenceMatcher
+	for name, value := range labels {
+		matchers = append(matchers, silenceMatcher{Name: name, Value: value})
+	}
+	sort.Slice(matchers, func(i, j int) bool { return matchers[i].Name < matchers[j].Name })
+	return matchers
+}
+
+// silenceAcknowledgedIncident silences in Alertmanager the alert group of the tracked incident acknowledged in
+// ServiceNow, unless it is already silenced for more than the next reconciliations
+func silenceAcknowledgedIncident(ctx context.Context, key string, tracked trackedIncident, incident Incident, now time.Time) {
+	config := configFrom(ctx)
+	c := config.Alertmanager
+	if !c.Silences.Enabled || !c.Silences.states()[incident.GetState()] {
+		return
+	}
+	if tracked.silencedUntil.After(now.Add(silenceRenewalMarginIntervals * config.Workflow.Reconciliation.interval())) {
+		return
+	}
+	alertmanagerURL := c.alertmanagerURL(tracked.group.data)
+	matchers := silenceMatchers(tracked.group.data)
+	if len(alertmanagerURL) == 0 || len(matchers) == 0 {
+		loggerFrom(ctx).Warnf("Alert group key %s of the acknowledged incident %s cannot be silenced, without Alertmanager URL or labels", getGroupKey(ctx, tracked.group.data), tracked.number)
+		return
+	}
+
+	endsAt := now.Add(c.Silences.duration())
+	id, err := newAlertmanagerClient(c).CreateSilence(ctx, alertmanagerURL, silence{
+		Matchers:  matchers,
+		StartsAt:  now,
+		EndsAt:    endsAt,
+		CreatedBy: c.Silences.createdBy(),
+		Comment:   fmt.Sprintf("Incident %s acknowledged in ServiceNow (state %s)", tracked.number, incident.GetState()),
+	})
+	if err != nil {
+		alertmanagerSilences.WithLabelValues(silenceError).Inc()
+		loggerFrom(ctx).Errorf("Error silencing the alert group key %s of the acknowledged incident %s: %v", getGroupKey(ctx, tracked.group.data), tracked.number, err)
+		return
+	}
+	alertmanagerSilences.WithLabelValues(silenceCreated).Inc()
+	trackedIncidents.silenced(key, tracked.sysID, id, endsAt)
+	loggerFrom(ctx).Infof("Alert group key %s of the acknowledged incident %s silenced until %s by silence %s", getGroupKey(ctx, tracked.group.data), tracked.number, endsAt.UTC().Format(time.RFC3339), id)
+}
+
+// expireIncidentSilence expires in Alertmanager the silence of the alert group of the tracked incident, once the
+// incident is not acknowledged anymore
+func expireIncidentSilence(ctx context.Context, key string, tracked trackedIncident) {
+	config := configFrom(ctx)
+	if len(tracked.silenceID) == 0 {
+		return
+	}
+	c := config.Alertmanager
+	if err := newAlertmanagerClient(c).ExpireSilence(ctx, c.alertmanagerURL(tracked.group.data), tracked.silenceID); err != nil {
+		alertmanagerSilences.WithLabelValues(silenceError).Inc()
+		loggerFrom(ctx).Errorf("Error expiring the silence %s of the incident %s: %v", tracked.silenceID, tracked.number, err)
+		return
+	}
+	alertmanagerSilences.WithLabelValues(silenceExpired).Inc()
+	trackedIncidents.silenced(key, tracked.sysID, "", time.Time{})
+	loggerFrom(ctx).Infof("Silence %s of the alert group key %s of the incident %s expired", tracked.silenceID, getGroupKey(ctx, tracked.group.data), tracked.number)
+}
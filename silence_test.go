@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+func TestReconcileIncidents_Silence(t *testing.T) {
+	var silences []silence
+	var username string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/silences" || r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		username, _, _ = r.BasicAuth()
+		var s silence
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			t.Error(err)
+		}
+		silences = append(silences, s)
+		w.Write([]byte(`{"silenceID": "s1"}`))
+	}))
+	defer ts.Close()
+
+	loadConfig("config/servicenow_example.yml")
+	dedupStore = newMemoryDedupStore()
+	trackedIncidents = &incidentTracker{incidents: map[string]trackedIncident{}}
+	currentConfig().Workflow.Reconciliation = ReconciliationConfig{Enabled: true}
+	currentConfig().Alertmanager = AlertmanagerConfig{BasicAuth: BasicAuthConfig{Username: "webhook", Password: "secret"}, Silences: SilencesConfig{Enabled: true}}
+	data := template.Data{Status: "firing", ExternalURL: ts.URL, GroupLabels: template.KV{"alertname": "DiskFull", "instance": "db1"}, Alerts: template.Alerts{{Status: "firing"}}}
+	trackIncident(context.Background(), "incident", "acknowledged", data, Incident{"sys_id": "1", "number": "INC1"})
+	trackIncident(context.Background(), "incident", "new", data, Incident{"sys_id": "2", "number": "INC2"})
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "incident", map[string]string{"sys_id": "1", "sysparm_fields": "sys_id,number,state"}).Return([]Incident{{"sys_id": "1", "state": "2"}}, nil)
+	snClientMock.On("GetIncidents", "incident", map[string]string{"sys_id": "2", "sysparm_fields": "sys_id,number,state"}).Return([]Incident{{"sys_id": "2", "state": "1"}}, nil)
+
+	for i := 0; i < 2; i++ {
+		reconcileIncidents(context.Background(), currentConfig().Workflow.Reconciliation)
+	}
+	if len(silences) != 1 {
+		t.Fatalf("Only the alert group of the acknowledged incident should be silenced, once: %v", silences)
+	}
+	s := silences[0]
+	if len(s.Matchers) != 2 || s.Matchers[0] != (silenceMatcher{Name: "alertname", Value: "DiskFull"}) || s.Matchers[1] != (silenceMatcher{Name: "instance", Value: "db1"}) {
+		t.Errorf("The silence should match the group labels: %v", s.Matchers)
+	}
+	if d := s.EndsAt.Sub(s.StartsAt); d != defaultSilenceDuration || s.CreatedBy != defaultSilenceCreatedBy || username != "webhook" {
+		t.Errorf("Unexpected silence: %+v, basic auth %s", s, username)
+	}
+	if tracked := trackedIncidents.incidents["acknowledged"]; tracked.silenceID != "s1" || tracked.silencedUntil.IsZero() {
+		t.Errorf("The silence should be recorded on the tracked incident: %+v", tracked)
+	}
+
+	trackIncident(context.Background(), "incident", "acknowledged", data, Incident{"sys_id": "1", "number": "INC1"})
+	if tracked := trackedIncidents.incidents["acknowledged"]; tracked.silenceID != "s1" {
+		t.Errorf("The silence should be kept on a new notification: %+v", tracked)
+	}
+}
+
+func TestSilenceAcknowledgedIncident_Renewal(t *testing.T) {
+	created := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		created++
+		w.Write([]byte(`{"silenceID": "s2"}`))
+	}))
+	defer ts.Close()
+
+	loadConfig("config/servicenow_example.yml")
+	trackedIncidents = &incidentTracker{incidents: map[string]trackedIncident{}}
+	currentConfig().Workflow.Reconciliation = ReconciliationConfig{Enabled: true}
+	currentConfig().Alertmanager = AlertmanagerConfig{URL: ts.URL, Silences: SilencesConfig{Enabled: true, States: []json.Number{"3"}, Duration: time.Hour}}
+	now := time.Now()
+	tracked := trackedIncident{sysID: "1", number: "INC1", silenceID: "s1", silencedUntil: now.Add(2 * time.Minute), group: tableGroup{data: template.Data{CommonLabels: template.KV{"alertname": "DiskFull"}}}}
+	trackedIncidents.incidents["key"] = tracked
+
+	silenceAcknowledgedIncident(context.Background(), "key", tracked, Incident{"state": "2"}, now)
+	if created != 0 {
+		t.Errorf("The incident not in an acknowledged state should not be silenced")
+	}
+	silenceAcknowledgedIncident(context.Background(), "key", tracked, Incident{"state": "3"}, now)
+	if tracked := trackedIncidents.incidents["key"]; created != 1 || tracked.silenceID != "s2" || !tracked.silencedUntil.Equal(now.Add(time.Hour)) {
+		t.Errorf("The silence expiring before the next reconciliations should be renewed: %+v", tracked)
+	}
+}
+
+func TestAlertmanagerConfig_Validate(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	c := *currentConfig()
+	c.Alertmanager = AlertmanagerConfig{URL: "alertmanager:9093", Silences: SilencesConfig{Enabled: true, States: []json.Number{"7"}}}
+	c.Workflow.NoUpdateStates = []json.Number{"7"}
+	c.Workflow.Reconciliation.Enabled = false
+	err := c.validate()
+	for _, expected := range []string{"alertmanager.url must be", "alertmanager.silences requires workflow.reconciliation", "no update state 7"} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("The config should be invalid with %q: %v", expected, err)
+		}
+	}
+}
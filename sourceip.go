@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var webhookForbiddenRequests = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Name: "webhook_forbidden_requests_total",
+		Help: "Total number of requests on /webhook rejected as their source IP is not allowed.",
+	},
+)
+
+// SourceIPConfig - Allowlist of the source IPs of the requests on /webhook, e.g. the Alertmanager nodes or the ingress
+type SourceIPConfig struct {
+	AllowedCIDRs      []string `yaml:"allowed_cidrs"`
+	TrustForwardedFor bool     `yaml:"trust_forwarded_for"`
+}
+
+func (c SourceIPConfig) validate(errs *strings.Builder) {
+	if _, err := parseCIDRs(c.AllowedCIDRs); err != nil {
+		errs.WriteString(fmt.Sprintf("webhook.source_ips.allowed_cidrs %v\n", err))
+	}
+}
+
+// parseCIDRs parses the CIDR blocks, a single IP address standing for itself
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if ip := net.ParseIP(cidr); ip != nil {
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 8 * net.IPv6len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("%q is neither a CIDR block nor an IP address", cidr)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// sourceIP returns the IP the request comes from. Behind a proxy, it is the last address of the X-Forwarded-For header,
+// appended by the proxy, as the previous ones are given by the client and can be forged.
+func (c SourceIPConfig) sourceIP(r *http.Request) net.IP {
+	if forwardedFor := r.Header.Get("X-Forwarded-For"); c.TrustForwardedFor && len(forwardedFor) > 0 {
+		addresses := strings.Split(forwardedFor, ",")
+		return net.ParseIP(strings.TrimSpace(addresses[len(addresses)-1]))
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// allowed returns whether the source IP of the request is in the allowlist, any source being allowed without one
+func (c SourceIPConfig) allowed(r *http.Request) bool {
+	if len(c.AllowedCIDRs) == 0 {
+		return true
+	}
+	ip := c.sourceIP(r)
+	if ip == nil {
+		return false
+	}
+	// The CIDRs were validated with the config
+	nets, _ := parseCIDRs(c.AllowedCIDRs)
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSourceIPConfig_Allowed(t *testing.T) {
+	c := SourceIPConfig{AllowedCIDRs: []string{"10.0.0.0/8", "192.168.1.10", "2001:db8::/32"}}
+	proxied := SourceIPConfig{AllowedCIDRs: c.AllowedCIDRs, TrustForwardedFor: true}
+	tests := []struct {
+		name        string
+		config      SourceIPConfig
+		remoteAddr  string
+		forwardedIP string
+		want        bool
+	}{
+		{name: "disabled", config: SourceIPConfig{}, remoteAddr: "203.0.113.1:1234", want: true},
+		{name: "cidr", config: c, remoteAddr: "10.1.2.3:1234", want: true},
+		{name: "ip", config: c, remoteAddr: "192.168.1.10:1234", want: true},
+		{name: "ipv6", config: c, remoteAddr: "[2001:db8::1]:1234", want: true},
+		{name: "denied", config: c, remoteAddr: "192.168.1.11:1234", want: false},
+		{name: "forwarded_for_ignored", config: c, remoteAddr: "203.0.113.1:1234", forwardedIP: "10.1.2.3", want: false},
+		{name: "forwarded_for", config: proxied, remoteAddr: "172.16.0.1:1234", forwardedIP: "203.0.113.1, 10.1.2.3", want: true},
+		{name: "forwarded_for_forged", config: proxied, remoteAddr: "172.16.0.1:1234", forwardedIP: "10.1.2.3, 203.0.113.1", want: false},
+		{name: "forwarded_for_missing", config: proxied, remoteAddr: "10.1.2.3:1234", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/webhook", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if len(tt.forwardedIP) > 0 {
+				req.Header.Set("X-Forwarded-For", tt.forwardedIP)
+			}
+			if got := tt.config.allowed(req); got != tt.want {
+				t.Errorf("Unexpected allowlist result: got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhook_ForbiddenSourceIP(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Webhook.SourceIPs = SourceIPConfig{AllowedCIDRs: []string{"10.0.0.0/8"}}
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	before := testutil.ToFloat64(webhookForbiddenRequests)
+
+	req := httptest.NewRequest("POST", "/webhook", strings.NewReader(twoAlertsNotification))
+	req.RemoteAddr = "203.0.113.1:1234"
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(webhook).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Wrong status code: got %v, want %v", rr.Code, http.StatusForbidden)
+	}
+	if len(snClientMock.Calls) > 0 {
+		t.Errorf("ServiceNow should not be called on forbidden requests")
+	}
+	if got := testutil.ToFloat64(webhookForbiddenRequests) - before; got != 1 {
+		t.Errorf("The forbidden request should be counted: got %v", got)
+	}
+}
+
+func TestSourceIPConfig_Validate(t *testing.T) {
+	var errs strings.Builder
+	SourceIPConfig{AllowedCIDRs: []string{"10.0.0.0/8", "10.0.0.0/33"}}.validate(&errs)
+	if !strings.Contains(errs.String(), `webhook.source_ips.allowed_cidrs "10.0.0.0/33"`) {
+		t.Errorf("Missing validation error: %q", errs.String())
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// IncidentStatesConfig - Incident states set on the alert lifecycle events, and intermediate states of the
+// transitions the instance does not allow directly. The state of the resolved alert groups is resolve.state.
+type IncidentStatesConfig struct {
+	Firing      string                  `yaml:"firing"`
+	StillFiring string                  `yaml:"still_firing"`
+	Transitions []StateTransitionConfig `yaml:"transitions"`
+}
+
+// StateTransitionConfig - Intermediate states an incident goes through, in order, before being set to the state to,
+// from any of the states from (any state when empty)
+type StateTransitionConfig struct {
+	From    []json.Number `yaml:"from"`
+	To      string        `yaml:"to"`
+	Through []string      `yaml:"through"`
+}
+
+func (c IncidentStatesConfig) validate(twoPhaseCreate TwoPhaseCreateConfig, errs *strings.Builder) {
+	if len(c.Firing) > 0 && twoPhaseCreate.Enabled {
+		errs.WriteString("states.firing cannot be used with two_phase_create, whose submit_state is the state of the created incidents\n")
+	}
+	for i, transition := range c.Transitions {
+		if len(transition.To) == 0 {
+			errs.WriteString(fmt.Sprintf("states.transitions[%d].to is missing\n", i))
+		}
+		if len(transition.Through) == 0 {
+			errs.WriteString(fmt.Sprintf("states.transitions[%d].through is missing\n", i))
+		}
+	}
+}
+
+// intermediateStates returns the states the incident goes through, in order, to be set from the state from to the
+// state to, following the first matching transition
+func (c IncidentStatesConfig) intermediateStates(from json.Number, to string) []string {
+	if string(from) == to {
+		return nil
+	}
+	for _, transition := range c.Transitions {
+		if transition.To == to && transition.matches(from) {
+			return transition.Through
+		}
+	}
+	return nil
+}
+
+func (c StateTransitionConfig) matches(state json.Number) bool {
+	if len(c.From) == 0 {
+		return true
+	}
+	for _, s := range c.From {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// applyFiringState sets the state of the new incidents of the firing alert groups
+func applyFiringState(ctx context.Context, tableName string, incident Incident) {
+	config := configFrom(ctx)
+	if c := config.tableWorkflow(tableName).States; len(c.Firing) > 0 {
+		incident["state"] = c.Firing
+	}
+}
+
+// applyStillFiringState sets the state on the update of the incident of a firing alert group
+func applyStillFiringState(ctx context.Context, tableName string, incident Incident) {
+	config := configFrom(ctx)
+	if c := config.tableWorkflow(tableName).States; len(c.StillFiring) > 0 {
+		incident["state"] = c.StillFiring
+	}
+}
+
+// transitionIncident updates the incident through the intermediate states of the transition from its current state
+// to the state of the update, if any, so that the update is allowed by the instance. It returns the intermediate state
+// the incident was last moved to, empty when none.
+func transitionIncident(ctx context.Context, tableName string, incident Incident, update Incident) (string, error) {
+	config := configFrom(ctx)
+	to, ok := update["state"].(string)
+	if !ok {
+		return "", nil
+	}
+	reached := ""
+	for _, state := range config.tableWorkflow(tableName).States.intermediateStates(incident.GetState(), to) {
+		loggerFrom(ctx).Infof("Moving incident %s to the intermediate state %s, before the state %s", incident.GetNumber(), state, to)
+		if _, err := serviceNowFrom(ctx).UpdateIncident(ctx, tableName, Incident{"state": state}, incident.GetSysID()); err != nil {
+			return reached, intermediateStateError(fmt.Errorf("error moving incident %s to the intermediate state %s: %v", incident.GetNumber(), state, err), incident, reached)
+		}
+		reached = state
+	}
+	return reached, nil
+}
+
+// intermediateStateError names the intermediate state the incident is left in when the rest of its transition failed,
+// keeping the class of the error
+func intermediateStateError(err error, incident Incident, state string) error {
+	if err == nil || len(state) == 0 {
+		return err
+	}
+	return &alertGroupError{message: fmt.Sprintf("incident %s left in the intermediate state %s: %v", incident.GetNumber(), state, err), class: errorClass(err)}
+}
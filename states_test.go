@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestIncidentStatesConfig_IntermediateStates(t *testing.T) {
+	c := IncidentStatesConfig{Transitions: []StateTransitionConfig{
+		{From: []json.Number{"1"}, To: "6", Through: []string{"2"}},
+		{To: "6", Through: []string{"2", "3"}},
+	}}
+	for _, test := range []struct {
+		from json.Number
+		to   string
+		want string
+	}{
+		{"1", "6", "2"},
+		{"3", "6", "2,3"},
+		{"6", "6", ""},
+		{"1", "2", ""},
+	} {
+		if got := strings.Join(c.intermediateStates(test.from, test.to), ","); got != test.want {
+			t.Errorf("Wrong intermediate states from %s to %s: got %q, want %q", test.from, test.to, got, test.want)
+		}
+	}
+}
+
+func TestOnAlertGroup_StateTransitions(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	dedupStore = newMemoryDedupStore()
+	currentConfig().Workflow.IncidentPerAlert = false
+	currentConfig().Workflow.Resolve = ResolveConfig{State: "6"}
+	currentConfig().Workflow.States = IncidentStatesConfig{
+		StillFiring: "2",
+		Transitions: []StateTransitionConfig{{From: []json.Number{"1"}, To: "6", Through: []string{"2"}}},
+	}
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "incident", mock.Anything).Return([]Incident{{"sys_id": "1", "number": "INC1", "state": "1"}}, nil)
+	snClientMock.On("UpdateIncident", "incident", mock.Anything, "1").Return(Incident{}, nil)
+	data := template.Data{
+		Status:      "resolved",
+		GroupLabels: template.KV{"alertname": "DiskFull"},
+		Alerts:      template.Alerts{template.Alert{Status: "resolved", Labels: template.KV{"alertname": "DiskFull"}}},
+	}
+
+	if err := onAlertGroup(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+	if len(snClientMock.Calls) != 3 {
+		t.Fatalf("The incident should go through the intermediate state: %v", snClientMock.Calls)
+	}
+	if incident := snClientMock.Calls[1].Arguments.Get(1).(Incident); len(incident) != 1 || incident["state"] != "2" {
+		t.Errorf("Unexpected intermediate update: %v", incident)
+	}
+	if incident := snClientMock.Calls[2].Arguments.Get(1).(Incident); incident["state"] != "6" {
+		t.Errorf("Unexpected resolution: %v", incident)
+	}
+
+	data.Status, data.Alerts[0].Status = "firing", "firing"
+	if err := onAlertGroup(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+	if incident := snClientMock.Calls[4].Arguments.Get(1).(Incident); incident["state"] != "2" {
+		t.Errorf("The still firing state should be set on the update: %v", incident)
+	}
+}
+
+func TestTransitionIncident_FinalUpdateFailed(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	dedupStore = newMemoryDedupStore()
+	currentConfig().Workflow.IncidentPerAlert = false
+	currentConfig().Workflow.Resolve = ResolveConfig{State: "6"}
+	currentConfig().Workflow.States = IncidentStatesConfig{Transitions: []StateTransitionConfig{{To: "6", Through: []string{"2"}}}}
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "incident", mock.Anything).Return([]Incident{{"sys_id": "1", "number": "INC1", "state": "1"}}, nil)
+	snClientMock.On("UpdateIncident", "incident", Incident{"state": "2"}, "1").Return(Incident{}, nil)
+	snClientMock.On("UpdateIncident", "incident", mock.Anything, "1").Return(Incident{}, &httpStatusError{statusCode: http.StatusForbidden})
+	data := template.Data{
+		Status:      "resolved",
+		GroupLabels: template.KV{"alertname": "DiskFull"},
+		Alerts:      template.Alerts{template.Alert{Status: "resolved", Labels: template.KV{"alertname": "DiskFull"}}},
+	}
+
+	err := onAlertGroup(context.Background(), data)
+	if err == nil || !strings.Contains(err.Error(), "incident INC1 left in the intermediate state 2") {
+		t.Fatalf("The error should name the intermediate state the incident is left in: %v", err)
+	}
+	if class := errorClass(err); class != errorClassPermanent {
+		t.Errorf("The class of the final update error should be kept: got %s", class)
+	}
+}
+
+func TestCreateIncident_FiringState(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Workflow.States = IncidentStatesConfig{Firing: "2"}
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("CreateIncident", "incident", mock.MatchedBy(func(incident Incident) bool {
+		return incident["state"] == "2"
+	})).Return(Incident{"sys_id": "1", "number": "INC1"}, nil)
+
+	if _, err := createIncident(context.Background(), "incident", Incident{"short_description": "d"}); err != nil {
+		t.Fatal(err)
+	}
+	snClientMock.AssertExpectations(t)
+}
+
+func TestIncidentStatesConfig_Validate(t *testing.T) {
+	var errs strings.Builder
+	IncidentStatesConfig{Firing: "2", Transitions: []StateTransitionConfig{{}}}.validate(TwoPhaseCreateConfig{Enabled: true}, &errs)
+	for _, want := range []string{"states.firing cannot be used with two_phase_create", "states.transitions[0].to", "states.transitions[0].through"} {
+		if !strings.Contains(errs.String(), want) {
+			t.Errorf("Missing validation error %q: %q", want, errs.String())
+		}
+	}
+}
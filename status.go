@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/common/version"
+)
+
+const statusPath = "/api/v1/status"
+
+var serviceNowConnectivity = &connectivityLog{hosts: map[string]*hostConnectivity{}}
+
+type buildStatus struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision"`
+	Branch    string `json:"branch"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+type configStatus struct {
+	Hash     string    `json:"hash"`
+	LoadedAt time.Time `json:"loaded_at"`
+}
+
+// hostConnectivity is the outcome of the calls to a ServiceNow host
+type hostConnectivity struct {
+	Host          string     `json:"host"`
+	LastSuccess   *time.Time `json:"last_success,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+}
+
+type serviceNowStatusReport struct {
+	Ready     bool               `json:"ready"`
+	CheckedAt *time.Time         `json:"checked_at,omitempty"`
+	Error     string             `json:"error,omitempty"`
+	Hosts     []hostConnectivity `json:"hosts"`
+}
+
+type queueStatus struct {
+	Enabled          bool    `json:"enabled"`
+	Length           int     `json:"length"`
+	Capacity         int     `json:"capacity"`
+	Workers          int     `json:"workers"`
+	BusyWorkers      int     `json:"busy_workers"`
+	OldestAgeSeconds float64 `json:"oldest_age_seconds"`
+	DeadLetters      *int    `json:"dead_letters,omitempty"`
+}
+
+// status is the response of /api/v1/status
+type status struct {
+	Build      buildStatus            `json:"build"`
+	Config     configStatus           `json:"config"`
+	Leader     bool                   `json:"leader"`
+	ServiceNow serviceNowStatusReport `json:"service_now"`
+	Queue      queueStatus            `json:"queue"`
+	Features   map[string]bool        `json:"features"`
+}
+
+// connectivityLog holds the outcome of the last calls to each ServiceNow host
+type connectivityLog struct {
+	mutex sync.Mutex
+	hosts map[string]*hostConnectivity
+}
+
+func (l *connectivityLog) record(host string, err error, now time.Time) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	c, ok := l.hosts[host]
+	if !ok {
+		c = &hostConnectivity{Host: host}
+		l.hosts[host] = c
+	}
+	if err == nil {
+		c.LastSuccess = &now
+		return
+	}
+	c.LastError = err.Error()
+	c.LastErrorTime = &now
+}
+
+// list returns the connectivity of the ServiceNow hosts, sorted by host
+func (l *connectivityLog) list() []hostConnectivity {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	hosts := make([]hostConnectivity, 0, len(l.hosts))
+	for _, c := range l.hosts {
+		hosts = append(hosts, *c)
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Host < hosts[j].Host })
+	return hosts
+}
+
+// newConfigStatus describes the config being loaded: the hash of its content, and its load time
+func newConfigStatus(configData []byte, now time.Time) configStatus {
+	return configStatus{Hash: fmt.Sprintf("%x", sha256.Sum256(configData)), LoadedAt: now}
+}
+
+// features returns whether the main optional features are enabled in the config
+func (c Config) features() map[string]bool {
+	return map[string]bool{
+		"async":                   c.Async.Enabled,
+		"async_wal":               len(c.Async.WALDirectory) > 0,
+		"dead_letter":             len(c.DeadLetter.Directory) > 0,
+		"accept_when_unavailable": c.DeadLetter.AcceptWhenUnavailable,
+		"dedup":                   c.Dedup.enabled(),
+		"dedup_stateless":         c.Dedup.Stateless,
+		"ha":                      c.HA.enabled(),
+		"tracing":                 len(c.Tracing.Endpoint) > 0,
+		"audit":                   len(c.Audit.Output) > 0,
+		"archive":                 len(c.Archive.Directory) > 0 || len(c.Archive.S3.Bucket) > 0,
+		"circuit_breaker":         c.ServiceNow.CircuitBreaker.enabled(),
+		"import_set":              c.ServiceNow.ImportSet.enabled(),
+		"incident_per_alert":      c.Workflow.IncidentPerAlert,
+		"multiple_instances":      len(c.Instances) > 0,
+		"knowledge":               c.Knowledge.enabled(),
+		"alertmanager_silences":   c.Alertmanager.Silences.Enabled,
+		"callback":                c.Callback.Enabled,
+	}
+}
+
+// currentQueueStatus returns the state of the asynchronous queue and the size of the dead-letter queue
+func currentQueueStatus(now time.Time) queueStatus {
+	var s queueStatus
+	if q := alertGroupQueue; q != nil {
+		s.Enabled = true
+		s.Length = len(q.jobs)
+		s.Capacity = cap(q.jobs)
+		s.Workers = q.workers
+		s.BusyWorkers = int(atomic.LoadInt32(&q.busy))
+		s.OldestAgeSeconds = q.oldestAge(now).Seconds()
+	}
+	if deadLetters != nil {
+		size := deadLetters.size()
+		s.DeadLetters = &size
+	}
+	return s
+}
+
+// statusHandler is the handler of /api/v1/status, returning on GET requests the build, the loaded config, the
+// ServiceNow connectivity, the queues and the enabled features, for the fleet health dashboards
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorizeRequest(w, r, http.MethodGet) {
+		return
+	}
+	now := time.Now()
+	snapshot := currentConfigSnapshot()
+	s := status{
+		Build: buildStatus{
+			Version:   version.Version,
+			Revision:  version.Revision,
+			Branch:    version.Branch,
+			BuildDate: version.BuildDate,
+			GoVersion: version.GoVersion,
+		},
+		Config:   snapshot.status,
+		Features: snapshot.config.features(),
+	}
+	s.Leader = leader.isLeader()
+	s.Queue = currentQueueStatus(now)
+
+	s.ServiceNow.Hosts = serviceNowConnectivity.list()
+	checkedAt, err := readiness.last()
+	if !checkedAt.IsZero() {
+		s.ServiceNow.CheckedAt = &checkedAt
+		s.ServiceNow.Ready = err == nil
+		if err != nil {
+			s.ServiceNow.Error = err.Error()
+		}
+	}
+	writeJSON(w, s)
+}
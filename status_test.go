@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStatusHandler(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Async.Enabled = true
+	defer readiness.invalidate()
+	serviceNowConnectivity = &connectivityLog{hosts: map[string]*hostConnectivity{}}
+	serviceNowConnectivity.record("instance.service-now.com", nil, time.Now())
+	serviceNowConnectivity.record("instance.service-now.com", errors.New("ServiceNow returned the HTTP error code: 503"), time.Now())
+	readiness.mutex.Lock()
+	readiness.checkedAt, readiness.err = time.Now(), nil
+	readiness.mutex.Unlock()
+	configData, _ := ioutil.ReadFile("config/servicenow_example.yml")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(statusHandler).ServeHTTP(rr, httptest.NewRequest("GET", statusPath, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: got %v, want %v", rr.Code, http.StatusOK)
+	}
+	var s status
+	if err := json.Unmarshal(rr.Body.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Config.Hash != fmt.Sprintf("%x", sha256.Sum256(configData)) || s.Config.LoadedAt.IsZero() {
+		t.Errorf("Unexpected config status: %+v", s.Config)
+	}
+	if !s.ServiceNow.Ready || len(s.ServiceNow.Hosts) != 1 || s.ServiceNow.Hosts[0].LastSuccess == nil || s.ServiceNow.Hosts[0].LastError != "ServiceNow returned the HTTP error code: 503" {
+		t.Errorf("Unexpected ServiceNow status: %+v", s.ServiceNow)
+	}
+	if !s.Features["async"] || s.Features["dead_letter"] {
+		t.Errorf("Unexpected features: %v", s.Features)
+	}
+	if !s.Leader || s.Queue.Enabled {
+		t.Errorf("Unexpected status: %+v", s)
+	}
+}
+
+func TestStatusHandler_Unauthorized(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Webhook.BearerToken = "token"
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(statusHandler).ServeHTTP(rr, httptest.NewRequest("GET", statusPath, nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Wrong status code: got %v, want %v", rr.Code, http.StatusUnauthorized)
+	}
+}
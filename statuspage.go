@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/version"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	recentDeliveriesSize = 20
+	redactedValue        = "<secret>"
+)
+
+var (
+	recentDeliveries = &deliveryLog{}
+
+	statusPageTemplate = template.Must(template.New("status").Parse(`<html>
+	<head><title>alertmanager-webhook-servicenow</title></head>
+	<body>
+	<h1>alertmanager-webhook-servicenow</h1>
+	<p><a href="/metrics">Metrics</a> - <a href="/-/ready">Readiness</a></p>
+	<h2>Build</h2>
+	<table>
+	<tr><th align="left">Version</th><td>{{ .Version }}</td></tr>
+	<tr><th align="left">Revision</th><td>{{ .Revision }}</td></tr>
+	<tr><th align="left">Branch</th><td>{{ .Branch }}</td></tr>
+	<tr><th align="left">Build date</th><td>{{ .BuildDate }}</td></tr>
+	<tr><th align="left">Go version</th><td>{{ .GoVersion }}</td></tr>
+	</table>
+	<h2>Status</h2>
+	<table>
+	<tr><th align="left">ServiceNow</th><td>{{ .ServiceNow }}</td></tr>
+	<tr><th align="left">Leader</th><td>{{ .Leader }}</td></tr>
+	<tr><th align="left">Queued alert groups</th><td>{{ .QueueLength }}</td></tr>
+	<tr><th align="left">Dead-letters</th><td>{{ .DeadLetters }}</td></tr>
+	</table>
+	<h2>Recent deliveries</h2>
+	<table>
+	<tr><th align="left">Time</th><th align="left">Path</th><th align="left">Source</th><th align="left">Status</th><th align="left">Alerts</th><th align="left">Failed</th><th align="left">Message</th></tr>
+	{{- range .Deliveries }}
+	<tr><td>{{ .Time.Format "2006-01-02 15:04:05Z07:00" }}</td><td>{{ .Path }}</td><td>{{ .RemoteAddr }}</td><td>{{ .Status }}</td><td>{{ .Alerts }}</td><td>{{ .FailedAlerts }}</td><td>{{ .Message }}</td></tr>
+	{{- end }}
+	</table>
+	<h2>Configuration</h2>
+	<pre>{{ .Config }}</pre>
+	</body>
+	</html>`))
+)
+
+// delivery is the outcome of a request on /webhook, shown on the status page
+type delivery struct {
+	Time         time.Time
+	Path         string
+	RemoteAddr   string
+	Status       int
+	Message      string
+	Alerts       int
+	FailedAlerts int
+}
+
+// deliveryLog holds the last deliveries, most recent first
+type deliveryLog struct {
+	mutex      sync.Mutex
+	deliveries []delivery
+}
+
+func (l *deliveryLog) record(d delivery) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.deliveries = append([]delivery{d}, l.deliveries...)
+	if len(l.deliveries) > recentDeliveriesSize {
+		l.deliveries = l.deliveries[:recentDeliveriesSize]
+	}
+}
+
+func (l *deliveryLog) list() []delivery {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]delivery(nil), l.deliveries...)
+}
+
+// recordDelivery records the response to the webhook request along with the outcome of its alerts
+func recordDelivery(r *http.Request, status int, message string, results []alertResult) {
+	d := delivery{Time: time.Now(), Path: r.URL.Path, RemoteAddr: r.RemoteAddr, Status: status, Message: message, Alerts: len(results)}
+	for _, result := range results {
+		if result.Status == alertResultFailed {
+			d.FailedAlerts++
+		}
+	}
+	recentDeliveries.record(d)
+}
+
+// redactedConfig returns the YAML of the config with its passwords, secrets, tokens and headers redacted
+func redactedConfig(c Config) (string, error) {
+	content, err := yaml.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	var tree interface{}
+	if err := yaml.Unmarshal(content, &tree); err != nil {
+		return "", err
+	}
+	content, err = yaml.Marshal(redact(tree, false))
+	return string(content), err
+}
+
+// redact replaces the non-empty values of the secret keys of the YAML tree, every value when secret
+func redact(node interface{}, secret bool) interface{} {
+	switch node := node.(type) {
+	case map[interface{}]interface{}:
+		for key, value := range node {
+			name := strings.ToLower(fmt.Sprint(key))
+			isSecret := secret || name == "headers" || (!strings.HasSuffix(name, "_file") &&
+				(strings.Contains(name, "password") || strings.Contains(name, "secret") || strings.Contains(name, "token")))
+			node[key] = redact(value, isSecret)
+		}
+		return node
+	case []interface{}:
+		for i, value := range node {
+			node[i] = redact(value, secret)
+		}
+		return node
+	case nil:
+		return nil
+	default:
+		if secret && len(fmt.Sprint(node)) > 0 {
+			return redactedValue
+		}
+		return node
+	}
+}
+
+// serviceNowStatus returns the result of the last readiness check of the ServiceNow instances
+func serviceNowStatus() string {
+	checkedAt, err := readiness.last()
+	if checkedAt.IsZero() {
+		return "Not checked yet"
+	}
+	if err != nil {
+		return fmt.Sprintf("%v (checked at %s)", err, checkedAt.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("Reachable (checked at %s)", checkedAt.Format(time.RFC3339))
+}
+
+// homepage is the handler of /, serving the status page: the build, the state of the webhook, its recent deliveries
+// and its redacted config
+func homepage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	config := currentConfig()
+	authenticated := config.Webhook.authenticate(r)
+	summary, err := redactedConfig(*config)
+	if !authenticated {
+		w.Header().Set("WWW-Authenticate", `Basic realm="alertmanager-webhook-servicenow"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		summary = fmt.Sprintf("Error rendering the config: %v", err)
+	}
+
+	page := struct {
+		Version, Revision, Branch, BuildDate, GoVersion string
+		ServiceNow                                      string
+		Leader                                          bool
+		QueueLength                                     int
+		DeadLetters                                     string
+		Deliveries                                      []delivery
+		Config                                          string
+	}{
+		Version:     version.Version,
+		Revision:    version.Revision,
+		Branch:      version.Branch,
+		BuildDate:   version.BuildDate,
+		GoVersion:   version.GoVersion,
+		ServiceNow:  serviceNowStatus(),
+		Leader:      leader.isLeader(),
+		DeadLetters: "Disabled",
+		Deliveries:  recentDeliveries.list(),
+		Config:      summary,
+	}
+	if alertGroupQueue != nil {
+		page.QueueLength = len(alertGroupQueue.jobs)
+	}
+	if deadLetters != nil {
+		deadLetters.mutex.Lock()
+		ids, err := deadLetters.list()
+		deadLetters.mutex.Unlock()
+		page.DeadLetters = fmt.Sprint(len(ids))
+		if err != nil {
+			page.DeadLetters = fmt.Sprintf("Error listing the dead-letters: %v", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := statusPageTemplate.Execute(w, page); err != nil {
+		baseLogger.Errorf("Error writing the status page: %s", err)
+	}
+}
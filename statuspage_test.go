@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRedactedConfig(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().ServiceNow.Password = "snpassword"
+	currentConfig().ServiceNow.PasswordFile = "/etc/servicenow/password"
+	currentConfig().Webhook.BearerToken = "webhooktoken"
+	currentConfig().Tracing.Headers = map[string]string{"Authorization": "Bearer tracingtoken"}
+
+	summary, err := redactedConfig(*currentConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"snpassword", "webhooktoken", "tracingtoken"} {
+		if strings.Contains(summary, secret) {
+			t.Errorf("The secret %s should be redacted: %s", secret, summary)
+		}
+	}
+	if !strings.Contains(summary, "password_file: /etc/servicenow/password") || !strings.Contains(summary, "instance_name:") {
+		t.Errorf("The other settings should be kept: %s", summary)
+	}
+}
+
+func TestHomepage(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().ServiceNow.Password = "snpassword"
+	recentDeliveries = &deliveryLog{}
+	for i := 0; i < recentDeliveriesSize+1; i++ {
+		recordDelivery(httptest.NewRequest("POST", "/webhook/payments", nil), http.StatusInternalServerError, "<Error>", []alertResult{{Status: alertResultFailed}, {Status: alertResultSuccess}})
+	}
+	if deliveries := recentDeliveries.list(); len(deliveries) != recentDeliveriesSize || deliveries[0].FailedAlerts != 1 || deliveries[0].Alerts != 2 {
+		t.Errorf("Unexpected recent deliveries: %+v", deliveries)
+	}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(homepage).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	body := rr.Body.String()
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: got %v, want %v", rr.Code, http.StatusOK)
+	}
+	for _, want := range []string{"Go version", "/webhook/payments", "&lt;Error&gt;", "Dead-letters", "instance_name"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("The status page should contain %q: %s", want, body)
+		}
+	}
+	if strings.Contains(body, "snpassword") {
+		t.Errorf("The status page should not contain secrets")
+	}
+
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(homepage).ServeHTTP(rr, httptest.NewRequest("GET", "/unknown", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Wrong status code of an unknown page: got %v, want %v", rr.Code, http.StatusNotFound)
+	}
+	currentConfig().Webhook.BearerToken = "token"
+	defer func() { currentConfig().Webhook.BearerToken = "" }()
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(homepage).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Wrong status code without authentication: got %v, want %v", rr.Code, http.StatusUnauthorized)
+	}
+}
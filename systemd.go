@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	// systemdReady is sent once the config is loaded and ServiceNow is reachable
+	systemdReady = "READY=1"
+	// systemdStopping is sent once the shutdown started
+	systemdStopping = "STOPPING=1"
+	// systemdWatchdog is sent every half of the watchdog timeout
+	systemdWatchdog = "WATCHDOG=1"
+)
+
+var (
+	// listenFDsStart is the first file descriptor passed by systemd socket activation
+	listenFDsStart = 3
+	// systemdReadyRetryInterval is how often the ServiceNow check is retried until the readiness is notified
+	systemdReadyRetryInterval = 5 * time.Second
+)
+
+// systemdListener returns the listening socket inherited from systemd socket activation, nil when the process is not
+// socket-activated. The LISTEN_* variables are unset, so that they are not inherited by child processes.
+func systemdListener() (net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, nil
+	}
+	if fds > 1 {
+		baseLogger.Warnf("systemd passed %d sockets, only the first one is used", fds)
+	}
+	syscall.CloseOnExec(listenFDsStart)
+	file := os.NewFile(uintptr(listenFDsStart), "LISTEN_FD_"+strconv.Itoa(listenFDsStart))
+	defer file.Close()
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("error using the socket passed by systemd: %v", err)
+	}
+	return listener, nil
+}
+
+// sdNotify sends the state to the systemd notification socket, returning false without error when the process is not
+// supervised by systemd with notifications (NOTIFY_SOCKET unset)
+func sdNotify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if len(socket) == 0 {
+		return false, nil
+	}
+	if socket[0] == '@' {
+		// Abstract namespace socket
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// systemdWatchdogInterval returns the interval of the watchdog notifications, half of the timeout of WATCHDOG_USEC,
+// zero when the watchdog is not enabled for the process
+func systemdWatchdogInterval() time.Duration {
+	if pid, err := strconv.Atoi(os.Getenv("WATCHDOG_PID")); err == nil && pid != os.Getpid() {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// notifySystemdReady notifies systemd of the readiness once the ServiceNow check passes, retrying it meanwhile, then
+// keeps the watchdog notified when enabled
+func notifySystemdReady(ctx context.Context) {
+	if len(os.Getenv("NOTIFY_SOCKET")) == 0 {
+		return
+	}
+	for {
+		err := readiness.check(ctx, 0)
+		if err == nil {
+			break
+		}
+		baseLogger.Warnf("Not notifying systemd of the readiness yet: %v", err)
+		sdNotify(fmt.Sprintf("STATUS=Waiting for ServiceNow: %v", err))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(systemdReadyRetryInterval):
+		}
+	}
+	if _, err := sdNotify(systemdReady + "\nSTATUS=Ready"); err != nil {
+		baseLogger.Errorf("Error notifying systemd of the readiness: %v", err)
+		return
+	}
+	baseLogger.Info("Notified systemd of the readiness")
+
+	if interval := systemdWatchdogInterval(); interval > 0 {
+		runEvery(ctx, interval, func() {
+			if _, err := sdNotify(systemdWatchdog); err != nil {
+				baseLogger.Errorf("Error notifying the systemd watchdog: %v", err)
+			}
+		})
+	}
+}
+
+// startSystemdNotify starts the background task notifying systemd of the readiness and of the watchdog
+func startSystemdNotify() {
+	if len(os.Getenv("NOTIFY_SOCKET")) == 0 {
+		return
+	}
+	backgroundTasks.Go("systemd notify", notifySystemdReady)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+// listenNotifySocket listens on a systemd notification socket of a temporary directory, set as NOTIFY_SOCKET
+func listenNotifySocket(t *testing.T) (*net.UnixConn, func()) {
+	dir, err := ioutil.TempDir("", "systemd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	os.Setenv("NOTIFY_SOCKET", socket)
+	return conn, func() {
+		os.Unsetenv("NOTIFY_SOCKET")
+		conn.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func readNotification(t *testing.T, conn *net.UnixConn) string {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buffer := make([]byte, 1024)
+	n, err := conn.Read(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buffer[:n])
+}
+
+func TestSdNotify(t *testing.T) {
+	if sent, err := sdNotify(systemdReady); sent || err != nil {
+		t.Errorf("Nothing should be sent without NOTIFY_SOCKET: %v, %v", sent, err)
+	}
+	conn, cleanup := listenNotifySocket(t)
+	defer cleanup()
+
+	if sent, err := sdNotify(systemdReady); !sent || err != nil {
+		t.Fatalf("The state should be sent: %v, %v", sent, err)
+	}
+	if state := readNotification(t, conn); state != systemdReady {
+		t.Errorf("Unexpected state: %q", state)
+	}
+}
+
+func TestSystemdListener(t *testing.T) {
+	if listener, err := systemdListener(); listener != nil || err != nil {
+		t.Errorf("The process should not be socket-activated: %v, %v", listener, err)
+	}
+
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpListener.Close()
+	file, err := tcpListener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	// The descriptor passed by systemd is owned, and closed, by systemdListener
+	fd, err := syscall.Dup(int(file.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(start int) { listenFDsStart = start }(listenFDsStart)
+	listenFDsStart = fd
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	os.Setenv("LISTEN_FDS", "1")
+
+	listener, err := systemdListener()
+	if err != nil || listener == nil {
+		t.Fatalf("The socket passed by systemd should be used: %v, %v", listener, err)
+	}
+	defer listener.Close()
+	if listener.Addr().String() != tcpListener.Addr().String() {
+		t.Errorf("Unexpected address: got %v, want %v", listener.Addr(), tcpListener.Addr())
+	}
+	if _, ok := os.LookupEnv("LISTEN_FDS"); ok {
+		t.Errorf("The LISTEN_* variables should be unset")
+	}
+}
+
+func TestNotifySystemdReady(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	defer readiness.invalidate()
+	defer func(interval time.Duration) { systemdReadyRetryInterval = interval }(systemdReadyRetryInterval)
+	systemdReadyRetryInterval = time.Millisecond
+	conn, cleanup := listenNotifySocket(t)
+	defer cleanup()
+	os.Setenv("WATCHDOG_USEC", "20000")
+	defer os.Unsetenv("WATCHDOG_USEC")
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident(nil), errors.New("unreachable")).Once()
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		notifySystemdReady(ctx)
+		close(done)
+	}()
+	if state := readNotification(t, conn); state != "STATUS=Waiting for ServiceNow: ServiceNow is not reachable: unreachable" {
+		t.Errorf("The failed check should be reported as the status: %q", state)
+	}
+	if state := readNotification(t, conn); state != systemdReady+"\nSTATUS=Ready" {
+		t.Errorf("The readiness should be notified once ServiceNow is reachable: %q", state)
+	}
+	if state := readNotification(t, conn); state != systemdWatchdog {
+		t.Errorf("The watchdog should be notified: %q", state)
+	}
+	cancel()
+	<-done
+}
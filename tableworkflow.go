@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// TableWorkflowConfig - Workflow settings of a table overriding the ones of workflow, as the record states and fields
+// differ between the incident, problem, change_request or sc_task tables
+type TableWorkflowConfig struct {
+	IncidentGroupKeyField string                `yaml:"incident_group_key_field"`
+	NoUpdateStates        []json.Number         `yaml:"no_update_states"`
+	IncidentUpdateFields  []string              `yaml:"incident_update_fields"`
+	Resolve               *ResolveConfig        `yaml:"resolve"`
+	States                *IncidentStatesConfig `yaml:"states"`
+}
+
+func validateTableWorkflows(c Config, errs *strings.Builder) {
+	for tableName, workflow := range c.TableWorkflows {
+		var workflowErrs strings.Builder
+		if workflow.Resolve != nil {
+			workflow.Resolve.validate(&workflowErrs)
+		}
+		if workflow.States != nil {
+			workflow.States.validate(c.Workflow.TwoPhaseCreate, &workflowErrs)
+		}
+		for _, err := range strings.SplitAfter(workflowErrs.String(), "\n") {
+			if len(err) > 0 {
+				errs.WriteString(fmt.Sprintf("table_workflows.%s: %s", tableName, err))
+			}
+		}
+	}
+}
+
+// tableWorkflow returns the workflow of the records of the table, with the overrides of its table_workflows entry
+func (c Config) tableWorkflow(tableName string) WorkflowConfig {
+	workflow := c.Workflow
+	override, ok := c.TableWorkflows[tableName]
+	if !ok {
+		return workflow
+	}
+	if len(override.IncidentGroupKeyField) > 0 {
+		workflow.IncidentGroupKeyField = override.IncidentGroupKeyField
+	}
+	if override.NoUpdateStates != nil {
+		workflow.NoUpdateStates = override.NoUpdateStates
+	}
+	if override.IncidentUpdateFields != nil {
+		workflow.IncidentUpdateFields = override.IncidentUpdateFields
+	}
+	if override.Resolve != nil {
+		workflow.Resolve = *override.Resolve
+	}
+	if override.States != nil {
+		workflow.States = *override.States
+	}
+	return workflow
+}
+
+// groupKeyField returns the field of the records of the table holding their alert group key
+func groupKeyField(ctx context.Context, tableName string) string {
+	config := configFrom(ctx)
+	return config.tableWorkflow(tableName).IncidentGroupKeyField
+}
+
+// tableNoUpdateStates returns the states of the records of the table which are not updated anymore
+func tableNoUpdateStates(ctx context.Context, tableName string) map[json.Number]bool {
+	s := configSnapshotFrom(ctx)
+	override, ok := s.config.TableWorkflows[tableName]
+	if !ok || override.NoUpdateStates == nil {
+		return s.noUpdateStates
+	}
+	states := make(map[json.Number]bool, len(override.NoUpdateStates))
+	for _, s := range override.NoUpdateStates {
+		states[s] = true
+	}
+	return states
+}
+
+// tableUpdateFields returns the fields sent when updating the records of the table
+func tableUpdateFields(ctx context.Context, tableName string) map[string]bool {
+	s := configSnapshotFrom(ctx)
+	override, ok := s.config.TableWorkflows[tableName]
+	if !ok || override.IncidentUpdateFields == nil {
+		return s.incidentUpdateFields
+	}
+	fields := make(map[string]bool, len(override.IncidentUpdateFields))
+	for _, f := range override.IncidentUpdateFields {
+		fields[f] = true
+	}
+	return fields
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/mock"
+)
+
+func loadTableWorkflowsTestConfig() {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Workflow.IncidentPerAlert = false
+	currentConfig().TableWorkflows = map[string]TableWorkflowConfig{
+		"problem": {
+			IncidentGroupKeyField: "u_alert_group_key",
+			NoUpdateStates:        []json.Number{"106", "107"},
+			IncidentUpdateFields:  []string{"description"},
+			Resolve:               &ResolveConfig{State: "106"},
+		},
+	}
+	dedupStore = newMemoryDedupStore()
+}
+
+func TestConfig_TableWorkflow(t *testing.T) {
+	loadTableWorkflowsTestConfig()
+	workflow := currentConfig().tableWorkflow("problem")
+	if workflow.IncidentGroupKeyField != "u_alert_group_key" || workflow.Resolve.State != "106" || len(workflow.NoUpdateStates) != 2 {
+		t.Errorf("The workflow of the table should be overridden: %+v", workflow)
+	}
+	if workflow.MultipleIncidentsPolicy != currentConfig().Workflow.MultipleIncidentsPolicy {
+		t.Errorf("The settings not overridden should be the workflow ones: %+v", workflow)
+	}
+	if workflow := currentConfig().tableWorkflow("incident"); workflow.IncidentGroupKeyField != currentConfig().Workflow.IncidentGroupKeyField {
+		t.Errorf("A table without table_workflows entry should use the workflow: %+v", workflow)
+	}
+}
+
+func TestFilterUpdatableIncidents_Table(t *testing.T) {
+	loadTableWorkflowsTestConfig()
+	incidents := []Incident{{"state": "6"}, {"state": "106"}}
+	if updatable := filterUpdatableIncidents(context.Background(), "problem", incidents); len(updatable) != 1 || updatable[0]["state"] != "6" {
+		t.Errorf("The no_update_states of the table should be used: %+v", updatable)
+	}
+	if updatable := filterUpdatableIncidents(context.Background(), "incident", incidents); len(updatable) != 1 || updatable[0]["state"] != "106" {
+		t.Errorf("The no_update_states of the workflow should be used: %+v", updatable)
+	}
+	if update := filterForUpdate(context.Background(), "problem", Incident{"description": "d", "comments": "c"}); len(update) != 1 || update["description"] != "d" {
+		t.Errorf("The incident_update_fields of the table should be used: %+v", update)
+	}
+}
+
+func TestOnTableAlertGroup_Problem(t *testing.T) {
+	loadTableWorkflowsTestConfig()
+	data := template.Data{
+		Status:      "resolved",
+		GroupLabels: template.KV{"alertname": "DiskFull"},
+		Alerts:      template.Alerts{template.Alert{Status: "resolved", Labels: template.KV{"alertname": "DiskFull"}}},
+	}
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "problem", map[string]string{"u_alert_group_key": getGroupKey(context.Background(), data)}).Return([]Incident{
+		{"sys_id": "1", "number": "PRB1", "state": "106"},
+		{"sys_id": "2", "number": "PRB2", "state": "101"},
+	}, nil)
+	snClientMock.On("UpdateIncident", "problem", mock.MatchedBy(func(incident Incident) bool {
+		return incident["state"] == "106" && incident["comments"] == nil
+	}), "2").Return(Incident{"sys_id": "2", "number": "PRB2"}, nil)
+
+	if err := onTableAlertGroup(context.Background(), tableGroup{tableName: "problem", data: data}); err != nil {
+		t.Fatal(err)
+	}
+	snClientMock.AssertExpectations(t)
+}
+
+func TestValidateTableWorkflows(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().TableWorkflows = map[string]TableWorkflowConfig{"problem": {Resolve: &ResolveConfig{CloseCode: "Solved"}}}
+	var errs strings.Builder
+	validateTableWorkflows(*currentConfig(), &errs)
+	if !strings.Contains(errs.String(), "table_workflows.problem: resolve.state is missing") {
+		t.Errorf("Missing validation error: %q", errs.String())
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net"
+	"net/http"
+)
+
+// registerWebhookHandlers registers the Alertmanager webhook endpoints, and the ServiceNow callback one, on the mux
+func registerWebhookHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/webhook", tracedHandler("webhook", webhook))
+	mux.HandleFunc(receiverPathPrefix, tracedHandler("webhook", receiverWebhook))
+	mux.HandleFunc(callbackPath, callbackHandler)
+}
+
+// registerTelemetryHandlers registers the status page, the metrics, the health endpoints and the admin APIs on the mux
+func registerTelemetryHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/", homepage)
+	mux.HandleFunc("/-/reload", reload)
+	mux.HandleFunc("/-/healthy", healthy)
+	mux.HandleFunc("/-/ready", ready)
+	mux.HandleFunc("/-/dead-letters", deadLettersHandler)
+	mux.HandleFunc("/-/dead-letters/replay", replayDeadLettersHandler)
+	mux.HandleFunc("/-/cache/flush", flushCacheHandler)
+	mux.HandleFunc("/-/loglevel", logLevelHandler)
+	mux.HandleFunc(statusPath, statusHandler)
+	mux.HandleFunc(mappingsPathPrefix, mappingsHandler)
+	mux.HandleFunc(mappingsPathPrefix+"/", mappingsHandler)
+	mux.HandleFunc("/metrics", metricsHandler)
+}
+
+// webhookHandler returns the handler of the webhook listener: every endpoint when no telemetry address is set,
+// otherwise only the Alertmanager webhook ones, so that the webhook port can be restricted to Alertmanager
+func webhookHandler(telemetryAddress string) http.Handler {
+	if len(telemetryAddress) == 0 {
+		registerWebhookHandlers(http.DefaultServeMux)
+		registerTelemetryHandlers(http.DefaultServeMux)
+		return withoutDebugEndpoints(http.DefaultServeMux)
+	}
+	mux := http.NewServeMux()
+	registerWebhookHandlers(mux)
+	return mux
+}
+
+// telemetryHandler returns the handler of the telemetry listener: the status page, the metrics, the health
+// endpoints, the admin APIs and the pprof profiling endpoints
+func telemetryHandler() http.Handler {
+	mux := http.NewServeMux()
+	registerTelemetryHandlers(mux)
+	mux.Handle(debugPathPrefix, debugHandler())
+	return mux
+}
+
+// startTelemetryServer serves the telemetry endpoints on the telemetry listener address. It returns nil when no
+// address is set. Like the debug server, it has no write timeout, for the CPU profile and the execution trace.
+func startTelemetryServer(address string) (*http.Server, error) {
+	if len(address) == 0 {
+		return nil, nil
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	server := &http.Server{Handler: telemetryHandler(), ReadTimeout: *readTimeout, IdleTimeout: *idleTimeout}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			baseLogger.Errorf("Error serving the telemetry endpoints on %v: %v", address, err)
+		}
+	}()
+	baseLogger.Infof("Serving the telemetry endpoints on: %v", address)
+	return server, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhookHandler_TelemetryAddress(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	handler := webhookHandler("127.0.0.1:9878")
+	for path, want := range map[string]int{
+		"/metrics":           http.StatusNotFound,
+		"/-/healthy":         http.StatusNotFound,
+		"/-/dead-letters":    http.StatusNotFound,
+		"/debug/pprof/heap":  http.StatusNotFound,
+		"/webhook/unknown":   http.StatusNotFound,
+		"/api/v1/mappings/x": http.StatusNotFound,
+	} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if rr.Code != want {
+			t.Errorf("Wrong status code for %s: got %v, want %v", path, rr.Code, want)
+		}
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/webhook", nil))
+	if rr.Code == http.StatusNotFound {
+		t.Errorf("The webhook should be served on the webhook listener")
+	}
+}
+
+func TestTelemetryHandler(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	handler := telemetryHandler()
+	for _, path := range []string{"/metrics", "/-/healthy", "/debug/pprof/goroutine?debug=1"} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if rr.Code != http.StatusOK {
+			t.Errorf("Wrong status code for %s: got %v, want %v", path, rr.Code, http.StatusOK)
+		}
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/webhook", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("The webhook should not be served on the telemetry listener: got %v", rr.Code)
+	}
+}
+
+func TestStartTelemetryServer(t *testing.T) {
+	if server, err := startTelemetryServer(""); server != nil || err != nil {
+		t.Errorf("No telemetry server should be started without address: %v, %v", server, err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	server, err := startTelemetryServer(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	resp, err := http.Get("http://" + address + "/-/healthy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Wrong status code: got %v, want %v", resp.StatusCode, http.StatusOK)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+const (
+	defaultInstanceListLabel     = "instance"
+	defaultInstanceListMaxLength = 10
+)
+
+// InstanceListConfig - Configuration of the list of affected instances available in the incident templates
+type InstanceListConfig struct {
+	Label     string `yaml:"label"`
+	MaxLength int    `yaml:"max_length"`
+}
+
+// templateContext is the data of the incident templates: the Alertmanager data, with a summary of the alert group
+type templateContext struct {
+	template.Data
+	// AlertCount is the number of alerts of the group
+	AlertCount int
+	// Instances are the distinct values of the identifying label across the group, truncated to the max length
+	Instances []string
+	// MoreInstances is the number of instances truncated from Instances
+	MoreInstances int
+	// InstanceList is Instances joined with commas, followed by "...and N more" when truncated
+	InstanceList string
+	// Alert is the first alert of the group, the only one when managing an incident per alert
+	Alert template.Alert
+	// Labels are the labels of Alert
+	Labels template.KV
+	// Annotations are the annotations of Alert
+	Annotations template.KV
+	// AlertList lists the alerts of the group, one per line, to describe all of them in the single incident of the group
+	AlertList string
+	// Duration is how long the alert group fired, from the start of its earliest alert to the end of its latest
+	// resolved one, or to now while an alert still fires
+	Duration time.Duration
+}
+
+func newTemplateContext(c InstanceListConfig, data template.Data) templateContext {
+	label := c.Label
+	if len(label) == 0 {
+		label = defaultInstanceListLabel
+	}
+	maxLength := c.MaxLength
+	if maxLength <= 0 {
+		maxLength = defaultInstanceListMaxLength
+	}
+
+	var instances []string
+	seen := map[string]bool{}
+	for _, alert := range data.Alerts {
+		instance := alert.Labels[label]
+		if len(instance) > 0 && !seen[instance] {
+			seen[instance] = true
+			instances = append(instances, instance)
+		}
+	}
+
+	context := templateContext{Data: data, AlertCount: len(data.Alerts), Instances: instances}
+	if len(data.Alerts) > 0 {
+		context.Alert = data.Alerts[0]
+		context.Labels = context.Alert.Labels
+		context.Annotations = context.Alert.Annotations
+	}
+	if len(instances) > maxLength {
+		context.Instances = instances[:maxLength]
+		context.MoreInstances = len(instances) - maxLength
+	}
+	context.InstanceList = strings.Join(context.Instances, ", ")
+	if context.MoreInstances > 0 {
+		context.InstanceList += fmt.Sprintf(" ...and %d more", context.MoreInstances)
+	}
+
+	lines := make([]string, 0, len(data.Alerts))
+	for _, alert := range data.Alerts {
+		lines = append(lines, alertListLine(label, alert))
+	}
+	context.AlertList = strings.Join(lines, "\n")
+	context.Duration = alertGroupDuration(data, time.Now())
+	return context
+}
+
+// alertGroupDuration returns how long the alert group fired until now, zero when its start is unknown
+func alertGroupDuration(data template.Data, now time.Time) time.Duration {
+	var start, end time.Time
+	for _, alert := range data.Alerts {
+		if !alert.StartsAt.IsZero() && (start.IsZero() || alert.StartsAt.Before(start)) {
+			start = alert.StartsAt
+		}
+		alertEnd := now
+		if alert.Status == "resolved" && !alert.EndsAt.IsZero() {
+			alertEnd = alert.EndsAt
+		}
+		if alertEnd.After(end) {
+			end = alertEnd
+		}
+	}
+	if start.IsZero() || end.Before(start) {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+// alertListLine describes the alert as "- [FIRING] alertname on instance: summary", the instance and the summary
+// (or description) being omitted when the alert has none
+func alertListLine(label string, alert template.Alert) string {
+	line := fmt.Sprintf("- [%s] %s", strings.ToUpper(alert.Status), alert.Labels["alertname"])
+	if instance := alert.Labels[label]; len(instance) > 0 {
+		line += " on " + instance
+	}
+	summary := alert.Annotations["summary"]
+	if len(summary) == 0 {
+		summary = alert.Annotations["description"]
+	}
+	if len(summary) > 0 {
+		line += ": " + summary
+	}
+	return line
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+func instancesAlertGroup(instances ...string) template.Data {
+	data := template.Data{CommonLabels: template.KV{"alertname": "InstanceDown"}}
+	for _, instance := range instances {
+		data.Alerts = append(data.Alerts, template.Alert{Labels: template.KV{"alertname": "InstanceDown", "instance": instance}})
+	}
+	return data
+}
+
+func TestNewTemplateContext(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    InstanceListConfig
+		data      template.Data
+		wantCount int
+		wantList  string
+		wantMore  int
+	}{
+		{name: "single_alert", data: instancesAlertGroup("web01"), wantCount: 1, wantList: "web01"},
+		{name: "multi_instance", data: instancesAlertGroup("web01", "web02", "web01", "web03"), wantCount: 4, wantList: "web01, web02, web03"},
+		{name: "truncated", config: InstanceListConfig{MaxLength: 2}, data: instancesAlertGroup("web01", "web02", "web03", "web04"), wantCount: 4, wantList: "web01, web02 ...and 2 more", wantMore: 2},
+		{name: "other_label", config: InstanceListConfig{Label: "alertname"}, data: instancesAlertGroup("web01", "web02"), wantCount: 2, wantList: "InstanceDown"},
+		{name: "no_alert", data: template.Data{}, wantCount: 0, wantList: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			context := newTemplateContext(tt.config, tt.data)
+			if context.AlertCount != tt.wantCount {
+				t.Errorf("Unexpected alert count: got %v, want %v", context.AlertCount, tt.wantCount)
+			}
+			if context.InstanceList != tt.wantList {
+				t.Errorf("Unexpected instance list: got %q, want %q", context.InstanceList, tt.wantList)
+			}
+			if context.MoreInstances != tt.wantMore {
+				t.Errorf("Unexpected more instances: got %v, want %v", context.MoreInstances, tt.wantMore)
+			}
+		})
+	}
+}
+
+func TestApplyIncidentTemplate_InstanceList(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().InstanceList = InstanceListConfig{MaxLength: 3}
+	var instances []string
+	for i := 1; i <= 5; i++ {
+		instances = append(instances, fmt.Sprintf("web0%d", i))
+	}
+
+	incident := Incident{
+		"short_description": "{{ .CommonLabels.alertname }} on {{ .AlertCount }} instance(s)",
+		"description":       "Affected instances: {{ .InstanceList }}",
+	}
+	applyIncidentTemplate(context.Background(), incident, instancesAlertGroup(instances...))
+
+	want := Incident{
+		"short_description": "InstanceDown on 5 instance(s)",
+		"description":       "Affected instances: web01, web02, web03 ...and 2 more",
+	}
+	if !reflect.DeepEqual(incident, want) {
+		t.Errorf("Unexpected incident: got %v, want %v", incident, want)
+	}
+}
+
+func TestApplyIncidentTemplate_Alert(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	data := template.Data{Alerts: template.Alerts{
+		template.Alert{
+			Labels:      template.KV{"severity": "critical", "instance": "web01"},
+			Annotations: template.KV{"summary": "Disk full"},
+		},
+		template.Alert{Labels: template.KV{"severity": "warning", "instance": "web02"}},
+	}}
+
+	incident := Incident{
+		"short_description": "[{{ .Labels.severity }}] {{ .Annotations.summary }} on {{ .Labels.instance }}",
+		"description":       "{{ .Alert.Labels.instance }} and {{ .AlertCount }} alert(s)",
+	}
+	applyIncidentTemplate(context.Background(), incident, data)
+
+	want := Incident{
+		"short_description": "[critical] Disk full on web01",
+		"description":       "web01 and 2 alert(s)",
+	}
+	if !reflect.DeepEqual(incident, want) {
+		t.Errorf("Unexpected incident: got %v, want %v", incident, want)
+	}
+}
+
+func TestApplyIncidentTemplate_AlertList(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	data := template.Data{Alerts: template.Alerts{
+		template.Alert{
+			Status:      "firing",
+			Labels:      template.KV{"alertname": "DiskFull", "instance": "web01"},
+			Annotations: template.KV{"summary": "Disk full"},
+		},
+		template.Alert{
+			Status:      "resolved",
+			Labels:      template.KV{"alertname": "DiskFull", "instance": "web02"},
+			Annotations: template.KV{"description": "Disk almost full"},
+		},
+		template.Alert{Status: "firing", Labels: template.KV{"alertname": "Watchdog"}},
+	}}
+
+	incident := Incident{"description": "{{ .AlertCount }} alert(s):\n{{ .AlertList }}"}
+	applyIncidentTemplate(context.Background(), incident, data)
+
+	want := "3 alert(s):\n- [FIRING] DiskFull on web01: Disk full\n- [RESOLVED] DiskFull on web02: Disk almost full\n- [FIRING] Watchdog"
+	if incident["description"] != want {
+		t.Errorf("Unexpected description: got %q, want %q", incident["description"], want)
+	}
+}
+
+func TestAlertGroupDuration(t *testing.T) {
+	start := time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)
+	now := start.Add(time.Hour)
+	data := template.Data{Alerts: template.Alerts{
+		template.Alert{Status: "resolved", StartsAt: start.Add(10 * time.Minute), EndsAt: start.Add(20 * time.Minute)},
+		template.Alert{Status: "resolved", StartsAt: start, EndsAt: start.Add(30 * time.Minute)},
+	}}
+	if d := alertGroupDuration(data, now); d != 30*time.Minute {
+		t.Errorf("Unexpected duration of the resolved alert group: %v", d)
+	}
+	data.Alerts[0].Status = "firing"
+	if d := alertGroupDuration(data, now); d != time.Hour {
+		t.Errorf("Unexpected duration of the firing alert group: %v", d)
+	}
+	if d := alertGroupDuration(template.Data{Alerts: template.Alerts{template.Alert{}}}, now); d != 0 {
+		t.Errorf("The duration should be zero without start: %v", d)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	tmpltext "text/template"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+// templateFuncs are the functions of the incident templates: the ones of the Alertmanager templates, plus the
+// Prometheus humanize functions and a few helpers, so that the Alertmanager and Prometheus snippets can be reused
+var templateFuncs = tmpltext.FuncMap{
+	"regexReplaceAll":   regexReplaceAll,
+	"humanizeTimestamp": humanizeTimestamp,
+	"humanizeDuration":  humanizeDuration,
+	"since":             since,
+	"default":           defaultValue,
+	"hasLabel":          hasLabel,
+}
+
+func init() {
+	for name, f := range template.DefaultFuncs {
+		if _, ok := templateFuncs[name]; !ok {
+			templateFuncs[name] = f
+		}
+	}
+}
+
+// newTextTemplate returns a new template with the template functions
+func newTextTemplate(name string) *tmpltext.Template {
+	return tmpltext.New(name).Funcs(templateFuncs)
+}
+
+// regexReplaceAll replaces the matches of the pattern in the text, expanding $1 like references in the replacement
+func regexReplaceAll(pattern string, replacement string, text string) (string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", err
+	}
+	return re.ReplaceAllString(text, replacement), nil
+}
+
+// toSeconds converts a number, or a string holding one, to a float
+func toSeconds(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	default:
+		return 0, fmt.Errorf("%v of type %T is not a number", v, v)
+	}
+}
+
+// humanizeTimestamp formats a time, or a Unix timestamp in seconds, as the Prometheus humanizeTimestamp function
+func humanizeTimestamp(v interface{}) (string, error) {
+	if t, ok := v.(time.Time); ok {
+		return t.UTC().String(), nil
+	}
+	seconds, err := toSeconds(v)
+	if err != nil {
+		return "", err
+	}
+	if math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return fmt.Sprintf("%.4g", seconds), nil
+	}
+	integer, fraction := math.Modf(seconds)
+	return time.Unix(int64(integer), int64(fraction*1e9)).UTC().String(), nil
+}
+
+// humanizeDuration formats a duration, or a number of seconds, as the Prometheus humanizeDuration function, e.g. 1d 2h 3m 4s
+func humanizeDuration(v interface{}) (string, error) {
+	var seconds float64
+	if d, ok := v.(time.Duration); ok {
+		seconds = d.Seconds()
+	} else {
+		var err error
+		if seconds, err = toSeconds(v); err != nil {
+			return "", err
+		}
+	}
+	if math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return fmt.Sprintf("%.4g", seconds), nil
+	}
+	if seconds == 0 {
+		return "0s", nil
+	}
+	sign := ""
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+	if seconds < 1 {
+		prefixes := []string{"m", "u", "n", "p"}
+		for _, prefix := range prefixes {
+			seconds *= 1000
+			if seconds >= 1 || prefix == prefixes[len(prefixes)-1] {
+				return fmt.Sprintf("%s%.4g%ss", sign, seconds, prefix), nil
+			}
+		}
+	}
+
+	total := int64(seconds)
+	days, hours, minutes := total/86400, total/3600%24, total/60%60
+	remainder := math.Mod(seconds, 60)
+	var parts []string
+	if days > 0 {
+		parts = append(parts, fmt.Sprintf("%dd", days))
+	}
+	if days > 0 || hours > 0 {
+		parts = append(parts, fmt.Sprintf("%dh", hours))
+	}
+	if days > 0 || hours > 0 || minutes > 0 {
+		parts = append(parts, fmt.Sprintf("%dm", minutes))
+	}
+	parts = append(parts, fmt.Sprintf("%.4gs", remainder))
+	return sign + strings.Join(parts, " "), nil
+}
+
+// since returns the time elapsed since t, e.g. the firing duration of an alert with {{ .Alert.StartsAt | since }}
+func since(t time.Time) time.Duration {
+	return time.Since(t)
+}
+
+// defaultValue returns the value, or the default when it is empty, e.g. {{ .Labels.team | default "unknown" }}
+func defaultValue(def interface{}, value interface{}) interface{} {
+	if value == nil {
+		return def
+	}
+	if s, ok := value.(string); ok && len(s) == 0 {
+		return def
+	}
+	return value
+}
+
+// hasLabel returns whether the label is set, e.g. {{ if hasLabel .Labels "team" }}
+func hasLabel(labels template.KV, name string) bool {
+	_, ok := labels[name]
+	return ok
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	data := templateContext{
+		Alert:  template.Alert{StartsAt: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)},
+		Labels: template.KV{"alertname": "DiskFull", "instance": "db-1:9100", "team": ""},
+	}
+	tests := []struct {
+		text string
+		want string
+	}{
+		{text: `{{ .Labels.alertname | toUpper }} {{ .Labels.alertname | toLower }}`, want: "DISKFULL diskfull"},
+		{text: `{{ regexReplaceAll ":[0-9]+$" "" .Labels.instance }}`, want: "db-1"},
+		{text: `{{ reReplaceAll "(.*):.*" "$1" .Labels.instance }}`, want: "db-1"},
+		{text: `{{ stringSlice "a" "b" | join ", " }}`, want: "a, b"},
+		{text: `{{ .Alert.StartsAt | humanizeTimestamp }}`, want: "2020-01-02 03:04:05 +0000 UTC"},
+		{text: `{{ 1577934245 | humanizeTimestamp }}`, want: "2020-01-02 03:04:05 +0000 UTC"},
+		{text: `{{ 93784 | humanizeDuration }}`, want: "1d 2h 3m 4s"},
+		{text: `{{ humanizeDuration "0.25" }}`, want: "250ms"},
+		{text: `{{ .Labels.team | default "unknown" }} {{ .Labels.service | default "none" }}`, want: "unknown none"},
+		{text: `{{ hasLabel .Labels "team" }} {{ hasLabel .Labels "service" }}`, want: "true false"},
+		{text: `{{ .Labels.instance | urlquery }}`, want: "db-1%3A9100"},
+	}
+	for _, tt := range tests {
+		got, err := applyTemplate("test", tt.text, data)
+		if err != nil || got != tt.want {
+			t.Errorf("Unexpected result of %s: got %q, %v, want %q", tt.text, got, err, tt.want)
+		}
+	}
+	if _, err := applyTemplate("test", `{{ regexReplaceAll "(" "" "text" }}`, data); err == nil {
+		t.Errorf("The invalid pattern should fail the template")
+	}
+}
+
+func TestHumanizeDuration(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{value: 0, want: "0s"},
+		{value: 59.5, want: "59.5s"},
+		{value: 3600, want: "1h 0m 0s"},
+		{value: -90, want: "-1m 30s"},
+		{value: 90 * time.Minute, want: "1h 30m 0s"},
+	}
+	for _, tt := range tests {
+		if got, err := humanizeDuration(tt.value); err != nil || got != tt.want {
+			t.Errorf("Unexpected humanized duration of %v: got %q, %v, want %q", tt.value, got, err, tt.want)
+		}
+	}
+	if _, err := humanizeDuration("ten"); err == nil {
+		t.Errorf("A value which is not a number should fail")
+	}
+}
+
+func TestApplyIncidentTemplate_Funcs(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	incident := Incident{"short_description": `{{ .CommonLabels.alertname | title }} on {{ if hasLabel .Labels "instance" }}{{ .Labels.instance }}{{ else }}unknown{{ end }}`}
+	data := template.Data{
+		CommonLabels: template.KV{"alertname": "disk full"},
+		Alerts:       template.Alerts{template.Alert{Labels: template.KV{"alertname": "disk full"}}},
+	}
+	applyIncidentTemplate(context.Background(), incident, data)
+	if incident["short_description"] != "Disk Full on unknown" {
+		t.Errorf("Unexpected incident field: %q", incident["short_description"])
+	}
+}
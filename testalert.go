@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+const testAlertName = "WebhookTestAlert"
+
+// testNotification is the Alertmanager webhook message of a synthetic alert
+type testNotification struct {
+	Version  string `json:"version"`
+	GroupKey string `json:"groupKey"`
+	template.Data
+}
+
+// newTestNotification returns the notification of a single synthetic alert of the status, with the labels and
+// annotations, grouped by all its labels. The alertname label defaults to WebhookTestAlert.
+func newTestNotification(receiver string, status string, labels map[string]string, annotations map[string]string, now time.Time) testNotification {
+	kv := template.KV{"alertname": testAlertName}
+	for name, value := range labels {
+		kv[name] = value
+	}
+	alert := template.Alert{
+		Status:      status,
+		Labels:      kv,
+		Annotations: template.KV(annotations),
+		StartsAt:    now.Add(-time.Minute),
+	}
+	if alert.Annotations == nil {
+		alert.Annotations = template.KV{}
+	}
+	if status == "resolved" {
+		alert.EndsAt = now
+	}
+	alert.Fingerprint = hashLabels(kv)[:16]
+	pairs := make([]string, 0, len(kv))
+	for _, pair := range kv.SortedPairs() {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", pair.Name, pair.Value))
+	}
+	return testNotification{
+		Version:  "4",
+		GroupKey: fmt.Sprintf("{}:{%s}", strings.Join(pairs, ", ")),
+		Data: template.Data{
+			Receiver:          receiver,
+			Status:            status,
+			Alerts:            template.Alerts{alert},
+			GroupLabels:       kv,
+			CommonLabels:      kv,
+			CommonAnnotations: alert.Annotations,
+		},
+	}
+}
+
+// postTestNotification sends the notification to the webhook at the URL, with the bearer token when set, the basic
+// auth credentials being those of the URL, and returns an error unless it is accepted
+func postTestNotification(ctx context.Context, url string, bearerToken string, notification testNotification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(bearerToken) > 0 {
+		req.Header.Set("Authorization", "Bearer "+bearerToken)
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	response, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxLoggedBodySize))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("the webhook answered with a %d: %s", resp.StatusCode, bytes.TrimSpace(response))
+	}
+	return nil
+}
+
+// processTestNotification manages the incident of the notification in-process, as the webhook receiver would, and
+// returns the incident payloads sent to ServiceNow
+func processTestNotification(ctx context.Context, webhookReceiver string, notification testNotification) ([]archivedIncident, error) {
+	ctx = withConfigSnapshot(ctx)
+	config := configFrom(ctx)
+	data := notification.Data
+	if err := validateNotification(data); err != nil {
+		return nil, err
+	}
+	if _, ok := config.Receivers[webhookReceiver]; len(webhookReceiver) > 0 && !ok {
+		return nil, fmt.Errorf("unknown webhook receiver %q", webhookReceiver)
+	}
+	recorder := &archiveRecorder{receivedAt: time.Now()}
+	id := newRequestID()
+	caller := auditCaller{Source: "send-test-alert", RequestID: id, Receiver: data.Receiver, GroupKey: getGroupKey(ctx, data)}
+	ctx = withReceiver(withAuditCaller(withLogger(ctx, newRequestLogger(ctx, id, data)), caller), webhookReceiver)
+	ctx = context.WithValue(ctx, archiveRecorderContextKey{}, recorder)
+	if *dryRun {
+		ctx = withDryRun(ctx)
+	}
+	err := onAlertGroup(ctx, data)
+	return recorder.incidents, err
+}
+
+// runSendTestAlert sends a synthetic alert to the webhook at the URL, or through the pipeline of the webhook receiver
+// in-process when the URL is empty, prints the outcome and returns the exit status of the send-test-alert command
+func runSendTestAlert(w io.Writer, url string, bearerToken string, webhookReceiver string, notification testNotification) int {
+	if len(url) > 0 {
+		if err := postTestNotification(context.Background(), url, bearerToken, notification); err != nil {
+			fmt.Fprintf(w, "Error sending the test alert to %s: %v\n", url, err)
+			return 1
+		}
+		fmt.Fprintf(w, "Test alert %s sent to %s\n", notification.GroupKey, url)
+		return 0
+	}
+	incidents, err := processTestNotification(context.Background(), webhookReceiver, notification)
+	for _, incident := range incidents {
+		payload, _ := json.Marshal(incident.Payload)
+		fmt.Fprintf(w, "%s %s %s %s\n", incident.Operation, incident.Table, incident.SysID, payload)
+	}
+	if err != nil {
+		fmt.Fprintf(w, "Error processing the test alert: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(w, "Test alert %s processed\n", notification.GroupKey)
+	return 0
+}
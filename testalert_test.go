@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNewTestNotification(t *testing.T) {
+	now := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	notification := newTestNotification("r", "resolved", map[string]string{"severity": "critical"}, map[string]string{"summary": "s"}, now)
+	if err := validateNotification(notification.Data); err != nil {
+		t.Fatal(err)
+	}
+	alert := notification.Alerts[0]
+	if alert.Labels["alertname"] != testAlertName || alert.Labels["severity"] != "critical" || alert.Annotations["summary"] != "s" || !alert.EndsAt.Equal(now) {
+		t.Errorf("Unexpected alert: %+v", alert)
+	}
+	if notification.GroupKey != `{}:{alertname="WebhookTestAlert", severity="critical"}` || notification.GroupLabels["severity"] != "critical" {
+		t.Errorf("The alert should be grouped by all its labels: %q, %v", notification.GroupKey, notification.GroupLabels)
+	}
+}
+
+func TestRunSendTestAlert_URL(t *testing.T) {
+	var received template.Data
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer ts.Close()
+	notification := newTestNotification("r", "firing", nil, nil, time.Now())
+
+	var out bytes.Buffer
+	if status := runSendTestAlert(&out, ts.URL, "token", "", notification); status != 0 || received.Receiver != "r" || len(received.Alerts) != 1 {
+		t.Errorf("The test alert should be sent: %d, %s, %+v", status, out.String(), received)
+	}
+	out.Reset()
+	if status := runSendTestAlert(&out, ts.URL, "", "", notification); status != 1 || !strings.Contains(out.String(), "answered with a 401") {
+		t.Errorf("The rejected test alert should fail: %d, %s", status, out.String())
+	}
+}
+
+func TestRunSendTestAlert_InProcess(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	dedupStore = newMemoryDedupStore()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "incident", mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", "incident", mock.Anything).Return(Incident{"sys_id": "1", "number": "INC1"}, nil)
+
+	var out bytes.Buffer
+	if status := runSendTestAlert(&out, "", "", "", newTestNotification("r", "firing", nil, nil, time.Now())); status != 0 {
+		t.Fatalf("The test alert should be processed: %s", out.String())
+	}
+	if !strings.Contains(out.String(), "created incident") || !strings.Contains(out.String(), "processed") {
+		t.Errorf("The created incident should be printed: %s", out.String())
+	}
+	out.Reset()
+	if status := runSendTestAlert(&out, "", "", "unknown", newTestNotification("r", "firing", nil, nil, time.Now())); status != 1 || !strings.Contains(out.String(), `unknown webhook receiver "unknown"`) {
+		t.Errorf("The unknown webhook receiver should fail: %d, %s", status, out.String())
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var webhookTestNotifications = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Name: "webhook_test_notifications_total",
+		Help: "Total number of test notifications received and ignored.",
+	},
+)
+
+// TestNotificationConfig - Test notification detection configuration
+type TestNotificationConfig struct {
+	Enabled        bool              `yaml:"enabled"`
+	Matchers       map[string]string `yaml:"matchers"`
+	RequiredLabels []string          `yaml:"required_labels"`
+}
+
+// isTestNotification returns true when the alert group is a test notification, according to the configuration.
+// A group is a test notification when it has no alerts, or when every one of its alerts is a test alert: missing one
+// of the required labels, or matching every configured matcher.
+func isTestNotification(c TestNotificationConfig, data template.Data) bool {
+	if !c.Enabled {
+		return false
+	}
+
+	for _, alert := range data.Alerts {
+		if !isTestAlert(c, alert) {
+			return false
+		}
+	}
+	return true
+}
+
+// isTestAlert returns true when the alert is missing one of the required labels, or matches every configured matcher
+func isTestAlert(c TestNotificationConfig, alert template.Alert) bool {
+	for _, label := range c.RequiredLabels {
+		if len(alert.Labels[label]) == 0 {
+			return true
+		}
+	}
+
+	if len(c.Matchers) == 0 {
+		return false
+	}
+	for label, value := range c.Matchers {
+		if alert.Labels[label] != value {
+			return false
+		}
+	}
+	return true
+}
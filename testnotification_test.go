@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestIsTestNotification(t *testing.T) {
+	testAlert := template.Data{
+		Alerts: template.Alerts{
+			template.Alert{Labels: template.KV{"alertname": "TestAlert"}},
+		},
+	}
+	realAlert := template.Data{
+		Alerts: template.Alerts{
+			template.Alert{Labels: template.KV{"alertname": "something_happened", "instance": "server01.int:9100"}},
+		},
+	}
+	tests := []struct {
+		name   string
+		config TestNotificationConfig
+		data   template.Data
+		want   bool
+	}{
+		{
+			name:   "disabled",
+			config: TestNotificationConfig{Enabled: false, Matchers: map[string]string{"alertname": "TestAlert"}},
+			data:   testAlert,
+			want:   false,
+		},
+		{
+			name:   "no_alerts",
+			config: TestNotificationConfig{Enabled: true},
+			data:   template.Data{},
+			want:   true,
+		},
+		{
+			name:   "matcher_match",
+			config: TestNotificationConfig{Enabled: true, Matchers: map[string]string{"alertname": "TestAlert"}},
+			data:   testAlert,
+			want:   true,
+		},
+		{
+			name:   "matcher_no_match",
+			config: TestNotificationConfig{Enabled: true, Matchers: map[string]string{"alertname": "TestAlert"}},
+			data:   realAlert,
+			want:   false,
+		},
+		{
+			name:   "required_label_missing",
+			config: TestNotificationConfig{Enabled: true, RequiredLabels: []string{"instance"}},
+			data:   testAlert,
+			want:   true,
+		},
+		{
+			name:   "required_label_present",
+			config: TestNotificationConfig{Enabled: true, RequiredLabels: []string{"instance"}},
+			data:   realAlert,
+			want:   false,
+		},
+		{
+			name:   "required_label_missing_on_one_alert",
+			config: TestNotificationConfig{Enabled: true, RequiredLabels: []string{"instance"}},
+			data:   template.Data{Alerts: append(append(template.Alerts{}, testAlert.Alerts...), realAlert.Alerts...)},
+			want:   false,
+		},
+		{
+			name:   "matcher_match_on_one_alert",
+			config: TestNotificationConfig{Enabled: true, Matchers: map[string]string{"alertname": "TestAlert"}},
+			data:   template.Data{Alerts: append(append(template.Alerts{}, realAlert.Alerts...), testAlert.Alerts...)},
+			want:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTestNotification(tt.config, tt.data); got != tt.want {
+				t.Errorf("isTestNotification() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookHandler_TestNotification_OK(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().TestNotification = TestNotificationConfig{
+		Enabled:  true,
+		Matchers: map[string]string{"alertname": "TestAlert"},
+	}
+	defer func() { currentConfig().TestNotification = TestNotificationConfig{} }()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, errors.New("GetIncidents should not be called"))
+	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{}, errors.New("Create should not be called"))
+	snClientMock.On("UpdateIncident", mock.Anything, mock.Anything, mock.Anything).Return(Incident{}, errors.New("Update should not be called"))
+
+	// Load a test notification example of a body coming from AlertManager
+	data, err := ioutil.ReadFile("test/alertmanager_test_notification.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create a request to pass to the handler
+	req := httptest.NewRequest("GET", "/webhook", bytes.NewReader(data))
+
+	// Create a ResponseRecorder (which satisfies http.ResponseWriter) to record the response
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(webhook)
+
+	// Test the handler with the request and record the result
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("Wrong status code: got %v, want %v", status, http.StatusOK)
+	}
+
+	want := `{"Status":200,"Message":"Success"}`
+	if rr.Body.String() != want {
+		t.Errorf("Unexpected body: got %v, want %v", rr.Body.String(), want)
+	}
+	snClientMock.AssertNotCalled(t, "GetIncidents", mock.Anything, mock.Anything)
+}
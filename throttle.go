@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var coalescedNotifications = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Name: "webhook_coalesced_notifications_total",
+		Help: "Total number of repeat firing notifications not updating their incident, updated less than update_throttle.min_interval ago.",
+	},
+)
+
+// UpdateThrottleConfig - Minimum interval between the updates of an incident by the repeat firing notifications of its
+// alert group. The notifications received meanwhile are coalesced into a work note of the next update.
+type UpdateThrottleConfig struct {
+	MinInterval time.Duration `yaml:"min_interval"`
+}
+
+func (c UpdateThrottleConfig) validate(errs *strings.Builder) {
+	if c.MinInterval < 0 {
+		errs.WriteString("workflow.update_throttle.min_interval must not be negative\n")
+	}
+}
+
+// updateThrottle is the last update of the incident of an alert group, and the notifications coalesced since
+type updateThrottle struct {
+	UpdatedAt      time.Time `json:"updated_at"`
+	Coalesced      int       `json:"coalesced,omitempty"`
+	CoalescedSince time.Time `json:"coalesced_since,omitempty"`
+}
+
+// updateThrottleKey is the deduplication store key of the last update of the incident of the alert group key
+func updateThrottleKey(key string) string {
+	return "throttle:" + key
+}
+
+func loadUpdateThrottle(ctx context.Context, key string) updateThrottle {
+	var throttle updateThrottle
+	value, err := dedupStore.Get(updateThrottleKey(key))
+	if err != nil {
+		loggerFrom(ctx).Errorf("Error reading the last update of alert group key %s: %v", key, err)
+		return throttle
+	}
+	if len(value) > 0 {
+		if err := json.Unmarshal([]byte(value), &throttle); err != nil {
+			loggerFrom(ctx).Errorf("Error decoding the last update of alert group key %s: %v", key, err)
+		}
+	}
+	return throttle
+}
+
+func storeUpdateThrottle(ctx context.Context, key string, throttle updateThrottle) {
+	if isDryRun(ctx) {
+		return
+	}
+	value, _ := json.Marshal(throttle)
+	// Kept while the alert group keeps firing, so that the coalesced notifications are summarized on the next update
+	if err := dedupStore.Set(updateThrottleKey(key), string(value), defaultNotificationCounterTTL); err != nil {
+		loggerFrom(ctx).Errorf("Error storing the last update of alert group key %s: %v", key, err)
+	}
+}
+
+// throttleUpdate returns whether the incident of the repeat firing notification was updated less than min_interval
+// ago, in which case the notification is coalesced instead of updating it. It must be called with the incident lock of
+// the alert group held.
+func throttleUpdate(ctx context.Context, key string, now time.Time) bool {
+	config := configFrom(ctx)
+	c := config.Workflow.UpdateThrottle
+	if c.MinInterval <= 0 {
+		return false
+	}
+	throttle := loadUpdateThrottle(ctx, key)
+	if throttle.UpdatedAt.IsZero() || now.Sub(throttle.UpdatedAt) >= c.MinInterval {
+		return false
+	}
+
+	if throttle.Coalesced == 0 {
+		throttle.CoalescedSince = now
+	}
+	throttle.Coalesced++
+	storeUpdateThrottle(ctx, key, throttle)
+	if config.Workflow.RepeatWorkNotes.Enabled {
+		// The repeat work note of the next update still numbers the notifications received meanwhile
+		countNotification(ctx, key)
+	}
+	coalescedNotifications.Inc()
+	loggerFrom(ctx).Infof("Incident of alert group key %s updated %v ago, less than %v: the notification is coalesced into the next update", key, now.Sub(throttle.UpdatedAt).Round(time.Second), c.MinInterval)
+	return true
+}
+
+// applyCoalescedNotifications adds the timestamped work note summarizing the notifications coalesced since the last
+// update to the incident update, before the other work notes
+func applyCoalescedNotifications(ctx context.Context, incident Incident, key string, now time.Time) {
+	config := configFrom(ctx)
+	if config.Workflow.UpdateThrottle.MinInterval <= 0 {
+		return
+	}
+	throttle := loadUpdateThrottle(ctx, key)
+	if throttle.Coalesced == 0 {
+		return
+	}
+	text := fmt.Sprintf("%s - %d repeat notification(s) received since %s coalesced, the incident being updated at most every %v.", now.UTC().Format(workNoteTimeLayout), throttle.Coalesced, throttle.CoalescedSince.UTC().Format(workNoteTimeLayout), config.Workflow.UpdateThrottle.MinInterval)
+	if existing, ok := incident[workNotesField].(string); ok && len(existing) > 0 {
+		text += "\n\n" + existing
+	}
+	incident[workNotesField] = text
+}
+
+// recordUpdate stores the time of the incident update, starting a new throttling interval
+func recordUpdate(ctx context.Context, key string, now time.Time) {
+	config := configFrom(ctx)
+	if config.Workflow.UpdateThrottle.MinInterval <= 0 {
+		return
+	}
+	storeUpdateThrottle(ctx, key, updateThrottle{UpdatedAt: now})
+}
+
+// resetUpdateThrottle forgets the last update of the incident of the resolved alert group
+func resetUpdateThrottle(ctx context.Context, key string) {
+	config := configFrom(ctx)
+	if config.Workflow.UpdateThrottle.MinInterval <= 0 || isDryRun(ctx) {
+		return
+	}
+	if err := dedupStore.Delete(updateThrottleKey(key)); err != nil {
+		loggerFrom(ctx).Errorf("Error resetting the last update of alert group key %s: %v", key, err)
+	}
+}
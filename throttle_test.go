@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestThrottleUpdate(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	dedupStore = newMemoryDedupStore()
+	currentConfig().Workflow.UpdateThrottle = UpdateThrottleConfig{MinInterval: 10 * time.Minute}
+	ctx := context.Background()
+	now := time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)
+
+	if throttleUpdate(ctx, "key", now) {
+		t.Errorf("The incident never updated should not be throttled")
+	}
+	recordUpdate(ctx, "key", now)
+	for _, minutes := range []time.Duration{2, 5} {
+		if !throttleUpdate(ctx, "key", now.Add(minutes*time.Minute)) {
+			t.Errorf("The update %v after the last one should be throttled", minutes*time.Minute)
+		}
+	}
+
+	now = now.Add(10 * time.Minute)
+	if throttleUpdate(ctx, "key", now) {
+		t.Fatalf("The update after min_interval should not be throttled")
+	}
+	incident := Incident{workNotesField: "other note"}
+	applyCoalescedNotifications(ctx, incident, "key", now)
+	if incident[workNotesField] != "2024-01-02 15:10:00 UTC - 2 repeat notification(s) received since 2024-01-02 15:02:00 UTC coalesced, the incident being updated at most every 10m0s.\n\nother note" {
+		t.Errorf("Unexpected work note: %v", incident[workNotesField])
+	}
+	recordUpdate(ctx, "key", now)
+	incident = Incident{}
+	applyCoalescedNotifications(ctx, incident, "key", now)
+	if _, ok := incident[workNotesField]; ok {
+		t.Errorf("The coalesced notifications should be summarized once: %v", incident)
+	}
+
+	throttleUpdate(withDryRun(ctx), "key", now.Add(time.Minute))
+	if throttle := loadUpdateThrottle(ctx, "key"); throttle.Coalesced != 0 {
+		t.Errorf("The coalesced notifications should not be stored in dry run: %+v", throttle)
+	}
+	resetUpdateThrottle(ctx, "key")
+	if throttleUpdate(ctx, "key", now.Add(time.Minute)) {
+		t.Errorf("The last update of the resolved alert group should be forgotten")
+	}
+}
+
+func TestOnAlertGroup_UpdateThrottle(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	dedupStore = newMemoryDedupStore()
+	currentConfig().Workflow.UpdateThrottle = UpdateThrottleConfig{MinInterval: time.Hour}
+	currentConfig().Workflow.RepeatWorkNotes = RepeatWorkNotesConfig{Enabled: true}
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "incident", mock.Anything).Return([]Incident{Incident{"sys_id": "1", "number": "INC1", "state": "2"}}, nil)
+	snClientMock.On("UpdateIncident", "incident", mock.Anything, "1").Return(Incident{}, nil)
+
+	data := template.Data{
+		Status:      "firing",
+		GroupLabels: template.KV{"alertname": "DiskFull"},
+		Alerts:      template.Alerts{template.Alert{Status: "firing"}},
+	}
+	for i := 0; i < 3; i++ {
+		if err := onAlertGroup(context.Background(), data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snClientMock.AssertNumberOfCalls(t, "UpdateIncident", 1)
+
+	key := instanceKey(context.Background(), dedupKey(context.Background(), "incident", getGroupKey(context.Background(), data)))
+	throttle := loadUpdateThrottle(context.Background(), key)
+	throttle.UpdatedAt = throttle.UpdatedAt.Add(-time.Hour)
+	storeUpdateThrottle(context.Background(), key, throttle)
+	if err := onAlertGroup(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+	snClientMock.AssertNumberOfCalls(t, "UpdateIncident", 2)
+	incident := snClientMock.Calls[len(snClientMock.Calls)-1].Arguments.Get(1).(Incident)
+	note, _ := incident[workNotesField].(string)
+	if !strings.Contains(note, "2 repeat notification(s) received since") || !strings.Contains(note, "alert still firing, 5th notification") {
+		t.Errorf("Unexpected work note: %v", note)
+	}
+}
+
+func TestUpdateThrottleConfig_Validate(t *testing.T) {
+	var errs strings.Builder
+	UpdateThrottleConfig{MinInterval: -time.Second}.validate(&errs)
+	if !strings.Contains(errs.String(), "workflow.update_throttle.min_interval") {
+		t.Errorf("Unexpected validation errors: %q", errs.String())
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+const (
+	defaultOpenedAtField   = "opened_at"
+	defaultResolvedAtField = "resolved_at"
+	// defaultTimestampFormat is the format of the ServiceNow date/time fields
+	defaultTimestampFormat = "2006-01-02 15:04:05"
+)
+
+// TimestampsConfig - Incident opening and resolution times set from the alert times, instead of the times ServiceNow
+// received the requests
+type TimestampsConfig struct {
+	Enabled         bool   `yaml:"enabled"`
+	OpenedAtField   string `yaml:"opened_at_field"`
+	ResolvedAtField string `yaml:"resolved_at_field"`
+	// Timezone is the IANA name of the timezone the times are converted to, UTC by default
+	Timezone string `yaml:"timezone"`
+	Format   string `yaml:"format"`
+}
+
+func (c TimestampsConfig) validate(errs *strings.Builder) {
+	if _, err := time.LoadLocation(c.Timezone); err != nil {
+		errs.WriteString(fmt.Sprintf("timestamps.timezone %q is invalid: %v\n", c.Timezone, err))
+	}
+}
+
+func (c TimestampsConfig) openedAtField() string {
+	if len(c.OpenedAtField) == 0 {
+		return defaultOpenedAtField
+	}
+	return c.OpenedAtField
+}
+
+func (c TimestampsConfig) resolvedAtField() string {
+	if len(c.ResolvedAtField) == 0 {
+		return defaultResolvedAtField
+	}
+	return c.ResolvedAtField
+}
+
+// format returns the time in the timezone and format of the config
+func (c TimestampsConfig) format(t time.Time) string {
+	format := c.Format
+	if len(format) == 0 {
+		format = defaultTimestampFormat
+	}
+	// The timezone was validated with the config
+	location, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	return t.In(location).Format(format)
+}
+
+// resolvedSince returns the latest end time of the resolved alerts of the group, zero when unknown
+func resolvedSince(data template.Data) time.Time {
+	var since time.Time
+	for _, alert := range data.Alerts {
+		if alert.Status == "resolved" && alert.EndsAt.After(since) {
+			since = alert.EndsAt
+		}
+	}
+	return since
+}
+
+// applyOpenedAt sets the opening time of the incident of the firing alert group to the time its first alert started
+func applyOpenedAt(c TimestampsConfig, incident Incident, data template.Data) {
+	if !c.Enabled {
+		return
+	}
+	if since := firingSince(data); !since.IsZero() {
+		incident[c.openedAtField()] = c.format(since)
+	}
+}
+
+// applyResolvedAt sets the resolution time of the incident of the resolved alert group to the time its last alert ended
+func applyResolvedAt(c TimestampsConfig, incident Incident, data template.Data) {
+	if !c.Enabled {
+		return
+	}
+	if since := resolvedSince(data); !since.IsZero() {
+		incident[c.resolvedAtField()] = c.format(since)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+var timestampsData = template.Data{
+	Alerts: template.Alerts{
+		template.Alert{Status: "firing", StartsAt: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)},
+		template.Alert{Status: "firing", StartsAt: time.Date(2020, 1, 2, 2, 0, 0, 0, time.UTC)},
+	},
+}
+
+func TestApplyOpenedAt(t *testing.T) {
+	incident := Incident{}
+	applyOpenedAt(TimestampsConfig{Enabled: true}, incident, timestampsData)
+	if incident["opened_at"] != "2020-01-02 02:00:00" {
+		t.Errorf("The opening time should be the start of the earliest firing alert: %+v", incident)
+	}
+
+	incident = Incident{}
+	applyOpenedAt(TimestampsConfig{Enabled: true, OpenedAtField: "u_opened", Timezone: "America/New_York", Format: time.RFC3339}, incident, timestampsData)
+	if incident["u_opened"] != "2020-01-01T21:00:00-05:00" {
+		t.Errorf("The opening time should be converted to the timezone and format: %+v", incident)
+	}
+
+	incident = Incident{}
+	applyOpenedAt(TimestampsConfig{}, incident, timestampsData)
+	if len(incident) > 0 {
+		t.Errorf("The disabled timestamps should not be set: %+v", incident)
+	}
+}
+
+func TestApplyResolution_ResolvedAt(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Workflow.Resolve = ResolveConfig{State: "6"}
+	currentConfig().Timestamps = TimestampsConfig{Enabled: true}
+	data := template.Data{
+		Status: "resolved",
+		Alerts: template.Alerts{
+			template.Alert{Status: "resolved", EndsAt: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)},
+			template.Alert{Status: "resolved", EndsAt: time.Date(2020, 1, 2, 4, 0, 0, 0, time.UTC)},
+		},
+	}
+	incident := Incident{}
+	applyResolution(context.Background(), "incident", incident, data)
+	if incident["state"] != "6" || incident["resolved_at"] != "2020-01-02 04:00:00" {
+		t.Errorf("The resolution time should be the end of the latest resolved alert: %+v", incident)
+	}
+}
+
+func TestAlertGroupToIncident_OpenedAt(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Timestamps = TimestampsConfig{Enabled: true}
+	incident, err := alertGroupToIncident(context.Background(), "incident", timestampsData)
+	if err != nil || incident["opened_at"] != "2020-01-02 02:00:00" {
+		t.Errorf("The opening time should be set on the incident: %+v, %v", incident, err)
+	}
+	if update := filterForUpdate(context.Background(), "incident", incident); update["opened_at"] != nil {
+		t.Errorf("The opening time should not be updated: %+v", update)
+	}
+}
+
+func TestTimestampsConfig_Validate(t *testing.T) {
+	var errs strings.Builder
+	TimestampsConfig{Timezone: "Mars/Olympus"}.validate(&errs)
+	if !strings.Contains(errs.String(), `timestamps.timezone "Mars/Olympus" is invalid`) {
+		t.Errorf("Missing validation error: %q", errs.String())
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+)
+
+// validateTLSFlags checks that the TLS certificate and key files are either both set or both unset
+func validateTLSFlags(certFile string, keyFile string) error {
+	if (len(certFile) == 0) != (len(keyFile) == 0) {
+		return errors.New("--web.tls-cert-file and --web.tls-key-file must be set together")
+	}
+	return nil
+}
+
+// serve serves the server handler on the listener, over HTTPS when the TLS certificate and key files are set
+func serve(server *http.Server, listener net.Listener, certFile string, keyFile string) error {
+	if len(certFile) > 0 {
+		return server.ServeTLS(listener, certFile, keyFile)
+	}
+	return server.Serve(listener)
+}
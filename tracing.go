@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultTracingServiceName   = "alertmanager-webhook-servicenow"
+	defaultTracingBatchTimeout  = 5 * time.Second
+	tracingBatchSize            = 512
+	tracingQueueSize            = 4096
+	tracingExportTimeout        = 10 * time.Second
+	traceParentHeader           = "traceparent"
+	spanKindInternal            = trace.SpanKindInternal
+	spanKindServer              = trace.SpanKindServer
+	spanKindClient              = trace.SpanKindClient
+	tracingInstrumentationScope = "github.com/FXinnovation/alertmanager-webhook-servicenow"
+)
+
+var (
+	// tracerProvider records and exports the spans, nil when the tracing is disabled
+	tracerProvider *sdktrace.TracerProvider
+
+	// traceContext propagates the traces with the W3C Trace Context traceparent header
+	traceContext = propagation.TraceContext{}
+
+	tracingDroppedSpans = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "webhook_tracing_dropped_spans_total",
+			Help: "Total number of spans dropped, as their export failed.",
+		},
+	)
+)
+
+// TracingConfig - Export of the traces of the alert groups processing to an OpenTelemetry collector, with OTLP/HTTP
+type TracingConfig struct {
+	Endpoint     string            `yaml:"endpoint"`
+	Headers      map[string]string `yaml:"headers"`
+	ServiceName  string            `yaml:"service_name"`
+	SampleRatio  *float64          `yaml:"sample_ratio"`
+	BatchTimeout time.Duration     `yaml:"batch_timeout"`
+	TLSConfig    TLSConfig         `yaml:"tls_config"`
+}
+
+func (c TracingConfig) enabled() bool {
+	return len(c.Endpoint) > 0
+}
+
+func (c TracingConfig) validate(errs *strings.Builder) {
+	if !c.enabled() {
+		return
+	}
+	if !strings.HasPrefix(c.Endpoint, "http://") && !strings.HasPrefix(c.Endpoint, "https://") {
+		errs.WriteString("tracing.endpoint must be an http or https URL\n")
+	}
+	if c.SampleRatio != nil && (*c.SampleRatio < 0 || *c.SampleRatio > 1) {
+		errs.WriteString("tracing.sample_ratio must be between 0 and 1\n")
+	}
+	if c.BatchTimeout < 0 {
+		errs.WriteString("tracing.batch_timeout must not be negative\n")
+	}
+	var tlsErrs strings.Builder
+	c.TLSConfig.validate(&tlsErrs)
+	for _, err := range strings.SplitAfter(tlsErrs.String(), "\n") {
+		if len(err) > 0 {
+			errs.WriteString("tracing." + err)
+		}
+	}
+}
+
+func (c TracingConfig) serviceName() string {
+	if len(c.ServiceName) == 0 {
+		return defaultTracingServiceName
+	}
+	return c.ServiceName
+}
+
+func (c TracingConfig) sampleRatio() float64 {
+	if c.SampleRatio == nil {
+		return 1
+	}
+	return *c.SampleRatio
+}
+
+func (c TracingConfig) batchTimeout() time.Duration {
+	if c.BatchTimeout == 0 {
+		return defaultTracingBatchTimeout
+	}
+	return c.BatchTimeout
+}
+
+// span is a recorded span of a trace. A nil span is not recorded, its methods do nothing.
+type span struct {
+	trace.Span
+}
+
+// startSpan starts a span, child of the span of the context if any, returning the context of the new span.
+// No span is returned when the tracing is disabled or the trace is not sampled.
+func startSpan(ctx context.Context, name string, kind trace.SpanKind) (context.Context, *span) {
+	if tracerProvider == nil {
+		return ctx, nil
+	}
+	ctx, s := tracerProvider.Tracer(tracingInstrumentationScope).Start(ctx, name, trace.WithSpanKind(kind))
+	if !s.IsRecording() {
+		return ctx, nil
+	}
+	return ctx, &span{s}
+}
+
+// withRemoteParent returns a context continuing the trace of the traceparent header, when valid.
+// The trace is only recorded when the remote parent is sampled.
+func withRemoteParent(ctx context.Context, header string) context.Context {
+	return traceContext.Extract(ctx, propagation.HeaderCarrier(http.Header{http.CanonicalHeaderKey(traceParentHeader): {header}}))
+}
+
+// continueTrace returns the context with the span of the other context, for the work outliving that context
+func continueTrace(ctx context.Context, from context.Context) context.Context {
+	if s := trace.SpanFromContext(from); s.SpanContext().IsValid() {
+		return trace.ContextWithSpan(ctx, s)
+	}
+	return ctx
+}
+
+// spanFrom returns the span of the context, nil when it is not recorded
+func spanFrom(ctx context.Context) *span {
+	if s := trace.SpanFromContext(ctx); s.IsRecording() {
+		return &span{s}
+	}
+	return nil
+}
+
+func (s *span) setAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	switch v := value.(type) {
+	case string:
+		s.SetAttributes(attribute.String(key, v))
+	case int:
+		s.SetAttributes(attribute.Int(key, v))
+	default:
+		s.SetAttributes(attribute.String(key, fmt.Sprint(v)))
+	}
+}
+
+// finish ends the span, as failed when err is not nil
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	if err != nil {
+		s.SetStatus(codes.Error, err.Error())
+	}
+	s.End()
+}
+
+// injectTraceParent sets the traceparent header of the request with the span of the context, if any
+func injectTraceParent(ctx context.Context, req *http.Request) {
+	traceContext.Inject(ctx, propagation.HeaderCarrier(req.Header))
+}
+
+// countingExporter counts the spans whose export failed
+type countingExporter struct {
+	sdktrace.SpanExporter
+}
+
+func (e countingExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	err := e.SpanExporter.ExportSpans(ctx, spans)
+	if err != nil {
+		tracingDroppedSpans.Add(float64(len(spans)))
+		baseLogger.Warnf("Error exporting %d spans: %v", len(spans), err)
+	}
+	return err
+}
+
+// newTracerProvider returns the provider of the tracers, exporting the sampled spans by batch to the OTLP/HTTP
+// endpoint
+func newTracerProvider(c TracingConfig) (*sdktrace.TracerProvider, error) {
+	tlsConfig, err := newTLSConfig(c.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	endpoint, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	options := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(endpoint.Host),
+		otlptracehttp.WithHeaders(c.Headers),
+		otlptracehttp.WithTLSClientConfig(tlsConfig),
+		otlptracehttp.WithTimeout(tracingExportTimeout),
+	}
+	if len(endpoint.Path) > 0 {
+		options = append(options, otlptracehttp.WithURLPath(endpoint.Path))
+	}
+	if endpoint.Scheme == "http" {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		return nil, err
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(countingExporter{exporter},
+			sdktrace.WithBatchTimeout(c.batchTimeout()),
+			sdktrace.WithMaxExportBatchSize(tracingBatchSize),
+			sdktrace.WithMaxQueueSize(tracingQueueSize),
+		),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(c.sampleRatio()))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", c.serviceName()))),
+	), nil
+}
+
+// loadTracer starts the export of the spans when the tracing is configured. The queued spans are exported on
+// shutdown.
+func loadTracer(config Config) error {
+	tracerProvider = nil
+	if !config.Tracing.enabled() {
+		return nil
+	}
+	provider, err := newTracerProvider(config.Tracing)
+	if err != nil {
+		return err
+	}
+	tracerProvider = provider
+	backgroundTasks.Go("trace export", func(ctx context.Context) {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), tracingExportTimeout)
+		defer cancel()
+		if err := provider.Shutdown(shutdownCtx); err != nil {
+			baseLogger.Warnf("Error exporting the queued spans: %v", err)
+		}
+	})
+	baseLogger.Infof("Exporting traces to %s", config.Tracing.Endpoint)
+	return nil
+}
+
+// statusRecorder records the status code of the response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// tracedHandler records a server span for each request of the handler, continuing the trace of its traceparent header
+func tracedHandler(name string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, s := startSpan(withRemoteParent(r.Context(), r.Header.Get(traceParentHeader)), name, spanKindServer)
+		if s == nil {
+			handler(w, r.WithContext(ctx))
+			return
+		}
+		s.setAttribute("http.method", r.Method)
+		s.setAttribute("http.target", r.URL.Path)
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler(recorder, r.WithContext(ctx))
+		s.setAttribute("http.status_code", recorder.status)
+		var err error
+		if recorder.status >= 500 {
+			err = fmt.Errorf("HTTP %d", recorder.status)
+		}
+		s.finish(err)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const testTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+// newTestTracer enables the tracing, the finished spans being read from the returned function
+func newTestTracer(t *testing.T) (func() []sdktrace.ReadOnlySpan, func()) {
+	recorder := tracetest.NewSpanRecorder()
+	tracerProvider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	return recorder.Ended, func() { tracerProvider = nil }
+}
+
+func spansByName(spans []sdktrace.ReadOnlySpan) map[string]sdktrace.ReadOnlySpan {
+	byName := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range spans {
+		byName[s.Name()] = s
+	}
+	return byName
+}
+
+func spanAttributes(s sdktrace.ReadOnlySpan) map[string]string {
+	attributes := map[string]string{}
+	for _, attribute := range s.Attributes() {
+		attributes[string(attribute.Key)] = attribute.Value.Emit()
+	}
+	return attributes
+}
+
+func TestTracedHandler_Webhook(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	finished, cleanup := newTestTracer(t)
+	defer cleanup()
+	dedupStore = newMemoryDedupStore()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{"sys_id": "1", "number": "INC1"}, nil)
+
+	req := httptest.NewRequest("POST", "/webhook", strings.NewReader(twoAlertsNotification))
+	req.Header.Set(traceParentHeader, testTraceParent)
+	rr := httptest.NewRecorder()
+	tracedHandler("webhook", webhook).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: got %v, want %v", rr.Code, http.StatusOK)
+	}
+	spans := spansByName(finished())
+	server, manage, render := spans["webhook"], spans["manage incident"], spans["render incident"]
+	if server == nil || manage == nil || render == nil {
+		t.Fatalf("Missing spans: %+v", spans)
+	}
+	for _, s := range []sdktrace.ReadOnlySpan{server, manage, render} {
+		if s.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("Span %s should continue the trace of the traceparent header", s.Name())
+		}
+	}
+	if server.Parent().SpanID().String() != "00f067aa0ba902b7" || !server.Parent().IsRemote() {
+		t.Errorf("The webhook span should be a child of the remote parent")
+	}
+	if manage.Parent().SpanID() != server.SpanContext().SpanID() || render.Parent().SpanID() != manage.SpanContext().SpanID() {
+		t.Errorf("Unexpected span parents")
+	}
+	if attributes := spanAttributes(server); attributes["alerts"] != "2" || attributes["http.status_code"] != "200" {
+		t.Errorf("Unexpected webhook span attributes: %v", attributes)
+	}
+	if attributes := spanAttributes(manage); attributes["incident"] != "INC1" {
+		t.Errorf("Unexpected manage incident span attributes: %v", attributes)
+	}
+}
+
+func TestTracedHandler_NotSampled(t *testing.T) {
+	finished, cleanup := newTestTracer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(traceParentHeader, strings.TrimSuffix(testTraceParent, "01")+"00")
+	tracedHandler("test", func(w http.ResponseWriter, r *http.Request) {
+		_, s := startSpan(r.Context(), "child", spanKindInternal)
+		s.finish(nil)
+	}).ServeHTTP(httptest.NewRecorder(), req)
+
+	if spans := finished(); len(spans) != 0 {
+		t.Errorf("No span should be recorded for a trace not sampled: %+v", spans)
+	}
+}
+
+func TestStartSpan_Disabled(t *testing.T) {
+	tracerProvider = nil
+	ctx, s := startSpan(context.Background(), "test", spanKindInternal)
+	if s != nil || spanFrom(ctx) != nil {
+		t.Errorf("No span should be started when the tracing is disabled")
+	}
+	s.setAttribute("key", "value")
+	s.finish(nil)
+}
+
+func TestStartSpan_Sampled(t *testing.T) {
+	ratio := 0.0
+	provider, err := newTracerProvider(TracingConfig{Endpoint: "http://localhost:4318/v1/traces", SampleRatio: &ratio})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracerProvider = provider
+	defer func() { tracerProvider = nil }()
+	if _, s := startSpan(context.Background(), "root", spanKindServer); s != nil {
+		t.Errorf("The root span should not be sampled with a sample ratio of 0")
+	}
+	if _, s := startSpan(withRemoteParent(context.Background(), testTraceParent), "child", spanKindServer); s == nil {
+		t.Errorf("The child span of a sampled remote parent should be sampled")
+	}
+}
+
+func TestServiceNowClient_TraceParent(t *testing.T) {
+	finished, cleanup := newTestTracer(t)
+	defer cleanup()
+	var header string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get(traceParentHeader)
+		w.Write([]byte(`{"result":[]}`))
+	}))
+	defer ts.Close()
+	snClient, err := NewServiceNowClient("instancename", "username", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	snClient.baseURL = ts.URL
+
+	ctx, parent := startSpan(context.Background(), "parent", spanKindInternal)
+	if _, err := snClient.GetIncidents(ctx, "incident", map[string]string{}); err != nil {
+		t.Fatal(err)
+	}
+	spans := finished()
+	if len(spans) != 1 || spans[0].Name() != "ServiceNow GET" || spans[0].Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Fatalf("Unexpected spans: %+v", spans)
+	}
+	if want := fmt.Sprintf("00-%s-%s-01", spans[0].SpanContext().TraceID(), spans[0].SpanContext().SpanID()); header != want {
+		t.Errorf("Wrong traceparent header: got %q, want %q", header, want)
+	}
+	if attributes := spanAttributes(spans[0]); attributes["http.status_code"] != "200" {
+		t.Errorf("Unexpected span attributes: %v", attributes)
+	}
+}
+
+func TestTracerProvider_Export(t *testing.T) {
+	var request coltracepb.ExportTraceServiceRequest
+	var path, authorization string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, authorization = r.URL.Path, r.Header.Get("Authorization")
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := proto.Unmarshal(body, &request); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer ts.Close()
+
+	provider, err := newTracerProvider(TracingConfig{Endpoint: ts.URL + "/v1/traces", Headers: map[string]string{"Authorization": "Bearer token"}, BatchTimeout: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracerProvider = provider
+	defer func() { tracerProvider = nil }()
+	ctx, parent := startSpan(context.Background(), "parent", spanKindServer)
+	_, child := startSpan(ctx, "child", spanKindInternal)
+	child.finish(context.Canceled)
+	parent.finish(nil)
+	if err := provider.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "/v1/traces" || authorization != "Bearer token" {
+		t.Errorf("The spans should be exported to the endpoint with the configured headers: %s %q", path, authorization)
+	}
+	if len(request.ResourceSpans) != 1 || len(request.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("Unexpected export request: %+v", request.ResourceSpans)
+	}
+	if service := request.ResourceSpans[0].Resource.Attributes[0]; service.Key != "service.name" || service.Value.GetStringValue() != defaultTracingServiceName {
+		t.Errorf("Unexpected resource attribute: %v", service)
+	}
+	spans := request.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("Unexpected spans: %+v", spans)
+	}
+	if spans[0].Name != "child" || string(spans[0].ParentSpanId) != string(spans[1].SpanId) || string(spans[0].TraceId) != string(spans[1].TraceId) {
+		t.Errorf("Unexpected child span: %+v", spans[0])
+	}
+	if spans[0].Status.GetCode() != 2 || spans[1].Status.GetCode() != 0 {
+		t.Errorf("Only the failed span should have an error status")
+	}
+}
+
+func TestTracingConfig_Validate(t *testing.T) {
+	ratio := 2.0
+	var errs strings.Builder
+	TracingConfig{Endpoint: "localhost:4318", SampleRatio: &ratio}.validate(&errs)
+	for _, want := range []string{"tracing.endpoint", "tracing.sample_ratio"} {
+		if !strings.Contains(errs.String(), want) {
+			t.Errorf("Missing %s validation error: %q", want, errs.String())
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// Defaults of the transport settings, the ones of http.DefaultTransport
+const (
+	defaultDialTimeout         = 30 * time.Second
+	defaultKeepAlive           = 30 * time.Second
+	defaultMaxIdleConns        = 100
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultTLSHandshakeTimeout = 10 * time.Second
+)
+
+var tlsVersions = map[string]uint16{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+// TLSConfig - TLS configuration of the connections to the ServiceNow instance
+type TLSConfig struct {
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+	MinVersion         string `yaml:"min_version"`
+}
+
+func (c TLSConfig) validate(errs *strings.Builder) {
+	if (len(c.CertFile) == 0) != (len(c.KeyFile) == 0) {
+		errs.WriteString("tls_config.cert_file and tls_config.key_file must be set together\n")
+	}
+	if _, ok := tlsVersions[c.MinVersion]; len(c.MinVersion) > 0 && !ok {
+		errs.WriteString("tls_config.min_version must be one of TLS10, TLS11, TLS12 or TLS13\n")
+	}
+}
+
+// newTLSConfig loads the CA bundle and the client certificate of the TLS configuration. The client certificate is
+// reloaded once rotated on disk.
+func newTLSConfig(c TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		MinVersion:         tlsVersions[c.MinVersion],
+	}
+	if len(c.CAFile) > 0 {
+		ca, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the CA file: %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.New("no certificate found in the CA file")
+		}
+	}
+	if len(c.CertFile) > 0 {
+		cert, err := newClientCertificate(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.GetClientCertificate = cert.getClientCertificate
+	}
+	return tlsConfig, nil
+}
+
+// TransportConfig - Connection settings of the HTTP client of the ServiceNow instance, for the high-volume deployments
+// to tune the connection reuse. The unset ones default to the settings of http.DefaultTransport.
+type TransportConfig struct {
+	DialTimeout time.Duration `yaml:"dial_timeout"`
+	// RequestTimeout bounds each request, from the connection to the end of the response body, 0 for no timeout
+	RequestTimeout        time.Duration `yaml:"request_timeout"`
+	ResponseHeaderTimeout time.Duration `yaml:"response_header_timeout"`
+	TLSHandshakeTimeout   time.Duration `yaml:"tls_handshake_timeout"`
+	// KeepAlive is the interval of the TCP keep-alive probes, a negative one disabling them
+	KeepAlive         time.Duration `yaml:"keep_alive"`
+	DisableKeepAlives bool          `yaml:"disable_keep_alives"`
+	MaxIdleConns      int           `yaml:"max_idle_conns"`
+	// MaxIdleConnsPerHost defaults to http.DefaultMaxIdleConnsPerHost, 2
+	MaxIdleConnsPerHost int           `yaml:"max_idle_conns_per_host"`
+	MaxConnsPerHost     int           `yaml:"max_conns_per_host"`
+	IdleConnTimeout     time.Duration `yaml:"idle_conn_timeout"`
+	// HTTP2 negotiates HTTP/2 with the instance, multiplexing the requests over a connection
+	HTTP2 bool `yaml:"http2"`
+}
+
+func (c TransportConfig) validate(errs *strings.Builder) {
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"dial_timeout", c.DialTimeout},
+		{"request_timeout", c.RequestTimeout},
+		{"response_header_timeout", c.ResponseHeaderTimeout},
+		{"tls_handshake_timeout", c.TLSHandshakeTimeout},
+		{"idle_conn_timeout", c.IdleConnTimeout},
+	}
+	for _, d := range durations {
+		if d.value < 0 {
+			errs.WriteString(fmt.Sprintf("service_now.transport.%s must not be negative\n", d.name))
+		}
+	}
+	if c.MaxIdleConns < 0 || c.MaxIdleConnsPerHost < 0 || c.MaxConnsPerHost < 0 {
+		errs.WriteString("service_now.transport connection limits must not be negative\n")
+	}
+}
+
+func defaultDuration(d time.Duration, defaultValue time.Duration) time.Duration {
+	if d == 0 {
+		return defaultValue
+	}
+	return d
+}
+
+// newTransport returns the transport of the settings, with the TLS configuration and the proxy
+func (c TransportConfig) newTransport(tlsConfig *tls.Config, proxy func(*http.Request) (*url.URL, error)) (*http.Transport, error) {
+	maxIdleConns := c.MaxIdleConns
+	if maxIdleConns == 0 {
+		maxIdleConns = defaultMaxIdleConns
+	}
+	transport := &http.Transport{
+		Proxy:           proxy,
+		TLSClientConfig: tlsConfig,
+		DialContext: (&net.Dialer{
+			Timeout:   defaultDuration(c.DialTimeout, defaultDialTimeout),
+			KeepAlive: defaultDuration(c.KeepAlive, defaultKeepAlive),
+		}).DialContext,
+		DisableKeepAlives:     c.DisableKeepAlives,
+		MaxIdleConns:          maxIdleConns,
+		MaxIdleConnsPerHost:   c.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       c.MaxConnsPerHost,
+		IdleConnTimeout:       defaultDuration(c.IdleConnTimeout, defaultIdleConnTimeout),
+		TLSHandshakeTimeout:   defaultDuration(c.TLSHandshakeTimeout, defaultTLSHandshakeTimeout),
+		ResponseHeaderTimeout: c.ResponseHeaderTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	if c.HTTP2 {
+		// HTTP/2 is not negotiated by default by a transport with a TLS configuration of its own
+		if err := http2.ConfigureTransport(transport); err != nil {
+			return nil, fmt.Errorf("error enabling HTTP/2: %v", err)
+		}
+	}
+	return transport, nil
+}
+
+// validateProxyURL checks the proxy URL, without writing it to the errors since it may hold credentials
+func validateProxyURL(proxyURL string, errs *strings.Builder) {
+	if len(proxyURL) == 0 {
+		return
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil || len(u.Host) == 0 {
+		errs.WriteString("proxy_url is not a valid URL\n")
+		return
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		errs.WriteString("proxy_url scheme must be one of http, https or socks5\n")
+	}
+}
+
+// newHTTPClient returns the HTTP client of the ServiceNow instance. Requests go through the proxy_url when set, with
+// the basic auth credentials of its user info if any, or else through the proxy of the HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY environment variables. Connections use the TLS configuration and the transport settings, and requests are
+// rate limited and their concurrency bounded when configured.
+func newHTTPClient(c ServiceNowConfig) (*http.Client, error) {
+	tlsConfig, err := newTLSConfig(c.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	if c.TLSConfig.InsecureSkipVerify {
+		baseLogger.Warnf("The certificate of ServiceNow instance %s is not verified", c.instanceLabel())
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if len(c.ProxyURL) > 0 {
+		u, err := url.Parse(c.ProxyURL)
+		if err != nil {
+			return nil, err
+		}
+		proxy = http.ProxyURL(u)
+	}
+
+	transport, err := c.Transport.newTransport(tlsConfig, proxy)
+	if err != nil {
+		return nil, err
+	}
+	var roundTripper http.RoundTripper = transport
+	if len(c.Headers) > 0 {
+		roundTripper = newHeaderTransport(roundTripper, c.Headers)
+	}
+	if c.MaxConcurrentRequests > 0 {
+		roundTripper = newConcurrencyLimitedTransport(roundTripper, c.MaxConcurrentRequests)
+	}
+	if c.RateLimit.enabled() {
+		roundTripper = &rateLimitedTransport{next: roundTripper, bucket: newTokenBucket(c.RateLimit)}
+	}
+	return &http.Client{Transport: roundTripper, Timeout: c.Transport.RequestTimeout}, nil
+}
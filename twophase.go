@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const (
+	rollbackDelete = "delete"
+	rollbackCancel = "cancel"
+)
+
+// TwoPhaseCreateConfig - Two-phase (draft then submit) incident creation configuration
+type TwoPhaseCreateConfig struct {
+	Enabled      bool     `yaml:"enabled"`
+	DraftState   string   `yaml:"draft_state"`
+	SubmitState  string   `yaml:"submit_state"`
+	SubmitFields []string `yaml:"submit_fields"`
+	Rollback     string   `yaml:"rollback"`
+	CancelState  string   `yaml:"cancel_state"`
+}
+
+func (c TwoPhaseCreateConfig) validate(errs *strings.Builder) {
+	if !c.Enabled {
+		return
+	}
+	if len(c.DraftState) == 0 {
+		errs.WriteString("two_phase_create.draft_state is missing\n")
+	}
+	if len(c.SubmitState) == 0 {
+		errs.WriteString("two_phase_create.submit_state is missing\n")
+	}
+	switch c.Rollback {
+	case "", rollbackDelete:
+	case rollbackCancel:
+		if len(c.CancelState) == 0 {
+			errs.WriteString("two_phase_create.cancel_state is missing\n")
+		}
+	default:
+		errs.WriteString(fmt.Sprintf("two_phase_create.rollback must be one of %q or %q\n", rollbackDelete, rollbackCancel))
+	}
+}
+
+// createIncident creates the incident in ServiceNow, either directly or in two phases when configured:
+// the incident is first created in the draft state, then submitted with a patch of the submit state and the submit fields.
+// If the submit phase fails, the draft incident is rolled back (deleted or cancelled).
+func createIncident(ctx context.Context, tableName string, incident Incident) (Incident, error) {
+	config := configFrom(ctx)
+	c := config.Workflow.TwoPhaseCreate
+	if !c.Enabled {
+		applyFiringState(ctx, tableName, incident)
+		created, err := serviceNowFrom(ctx).CreateIncident(ctx, tableName, incident)
+		if err == nil {
+			checkPriority(ctx, incident, created)
+		}
+		return created, err
+	}
+
+	submitFields := make(map[string]bool, len(c.SubmitFields))
+	for _, f := range c.SubmitFields {
+		submitFields[f] = true
+	}
+
+	draftParam := Incident{}
+	submitParam := Incident{"state": c.SubmitState}
+	for field, value := range incident {
+		if submitFields[field] {
+			submitParam[field] = value
+		} else {
+			draftParam[field] = value
+		}
+	}
+	draftParam["state"] = c.DraftState
+
+	draft, err := serviceNowFrom(ctx).CreateIncident(ctx, tableName, draftParam)
+	if err != nil {
+		return nil, err
+	}
+	loggerFrom(ctx).Infof("Draft incident %s created, submitting it with state %s", draft.GetNumber(), c.SubmitState)
+
+	submitted, err := serviceNowFrom(ctx).PatchIncident(ctx, tableName, submitParam, draft.GetSysID())
+	if err != nil {
+		loggerFrom(ctx).Errorf("Error submitting draft incident %s, rolling it back: %v", draft.GetNumber(), err)
+		if rollbackErr := rollbackDraftIncident(ctx, c, tableName, draft); rollbackErr != nil {
+			loggerFrom(ctx).Errorf("Error rolling back draft incident %s: %v", draft.GetNumber(), rollbackErr)
+			return nil, fmt.Errorf("%v (rollback of draft incident %s failed: %v)", err, draft.GetNumber(), rollbackErr)
+		}
+		return nil, err
+	}
+	checkPriority(ctx, incident, submitted)
+	return submitted, nil
+}
+
+func rollbackDraftIncident(ctx context.Context, c TwoPhaseCreateConfig, tableName string, draft Incident) error {
+	if c.Rollback == rollbackCancel {
+		_, err := serviceNowFrom(ctx).UpdateIncident(ctx, tableName, Incident{"state": c.CancelState}, draft.GetSysID())
+		return err
+	}
+	return serviceNowFrom(ctx).DeleteIncident(ctx, tableName, draft.GetSysID())
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+)
+
+var twoPhaseCreateConfig = TwoPhaseCreateConfig{
+	Enabled:      true,
+	DraftState:   "-5",
+	SubmitState:  "1",
+	SubmitFields: []string{"assignment_group"},
+}
+
+func TestCreateIncident_TwoPhase_OK(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Workflow.TwoPhaseCreate = twoPhaseCreateConfig
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("CreateIncident", "incident", mock.Anything).Run(func(args mock.Arguments) {
+		incident := args.Get(1).(Incident)
+		if incident["state"] != "-5" {
+			t.Errorf("Wrong draft state: got %v, want %v", incident["state"], "-5")
+		}
+		if _, ok := incident["assignment_group"]; ok {
+			t.Errorf("Submit field assignment_group should not be sent in draft")
+		}
+	}).Return(Incident{"state": "-5", "number": "INC42", "sys_id": "42"}, nil)
+	snClientMock.On("PatchIncident", "incident", mock.Anything, "42").Run(func(args mock.Arguments) {
+		incident := args.Get(1).(Incident)
+		if incident["state"] != "1" {
+			t.Errorf("Wrong submit state: got %v, want %v", incident["state"], "1")
+		}
+		if incident["assignment_group"] != "Development" {
+			t.Errorf("Wrong submit assignment_group: got %v, want %v", incident["assignment_group"], "Development")
+		}
+	}).Return(Incident{"state": "1", "number": "INC42", "sys_id": "42"}, nil)
+
+	incident, err := createIncident(context.Background(), "incident", Incident{"short_description": "Oops", "assignment_group": "Development"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if incident.GetState() != "1" {
+		t.Errorf("Wrong incident state: got %v, want %v", incident.GetState(), "1")
+	}
+	snClientMock.AssertNotCalled(t, "DeleteIncident", mock.Anything, mock.Anything)
+}
+
+func TestCreateIncident_TwoPhase_SubmitPatch(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Workflow.TwoPhaseCreate = twoPhaseCreateConfig
+	var submitMethod string
+	var submitFields Incident
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.Write([]byte(`{"result": {"state": "-5", "number": "INC42", "sys_id": "42"}}`))
+			return
+		}
+		submitMethod = r.Method
+		json.NewDecoder(r.Body).Decode(&submitFields)
+		w.Write([]byte(`{"result": {"state": "1", "number": "INC42", "sys_id": "42"}}`))
+	}))
+	defer ts.Close()
+	client, err := newSnClient(ServiceNowConfig{APIURL: ts.URL + "/api/now", UserName: "user", Password: "password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	useServiceNow(client)
+
+	if _, err := createIncident(context.Background(), "incident", Incident{"short_description": "Oops", "assignment_group": "Development"}); err != nil {
+		t.Fatal(err)
+	}
+	if submitMethod != http.MethodPatch {
+		t.Errorf("Wrong submit method: got %v, want %v", submitMethod, http.MethodPatch)
+	}
+	want := Incident{"state": "1", "assignment_group": "Development"}
+	if len(submitFields) != len(want) || submitFields["state"] != want["state"] || submitFields["assignment_group"] != want["assignment_group"] {
+		t.Errorf("Wrong submit fields: got %v, want %v", submitFields, want)
+	}
+}
+
+func TestCreateIncident_TwoPhase_SubmitError_Delete(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Workflow.TwoPhaseCreate = twoPhaseCreateConfig
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("CreateIncident", "incident", mock.Anything).Return(Incident{"state": "-5", "number": "INC42", "sys_id": "42"}, nil)
+	snClientMock.On("PatchIncident", "incident", mock.Anything, "42").Return(Incident{}, errors.New("Business rule rejected the submission"))
+	snClientMock.On("DeleteIncident", "incident", "42").Return(nil)
+
+	_, err := createIncident(context.Background(), "incident", Incident{"short_description": "Oops"})
+	if err == nil {
+		t.Fatal("Expected an error, got none")
+	}
+	snClientMock.AssertCalled(t, "DeleteIncident", "incident", "42")
+}
+
+func TestCreateIncident_TwoPhase_SubmitError_Cancel(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Workflow.TwoPhaseCreate = twoPhaseCreateConfig
+	currentConfig().Workflow.TwoPhaseCreate.Rollback = rollbackCancel
+	currentConfig().Workflow.TwoPhaseCreate.CancelState = "8"
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("CreateIncident", "incident", mock.Anything).Return(Incident{"state": "-5", "number": "INC42", "sys_id": "42"}, nil)
+	snClientMock.On("PatchIncident", "incident", Incident{"state": "1"}, "42").Return(Incident{}, errors.New("Business rule rejected the submission"))
+	snClientMock.On("UpdateIncident", "incident", Incident{"state": "8"}, "42").Return(Incident{"state": "8", "number": "INC42", "sys_id": "42"}, nil)
+
+	_, err := createIncident(context.Background(), "incident", Incident{"short_description": "Oops"})
+	if err == nil {
+		t.Fatal("Expected an error, got none")
+	}
+	snClientMock.AssertCalled(t, "UpdateIncident", "incident", Incident{"state": "8"}, "42")
+	snClientMock.AssertNotCalled(t, "DeleteIncident", mock.Anything, mock.Anything)
+}
+
+func TestCreateIncident_TwoPhase_RollbackError(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().Workflow.TwoPhaseCreate = twoPhaseCreateConfig
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("CreateIncident", "incident", mock.Anything).Return(Incident{"state": "-5", "number": "INC42", "sys_id": "42"}, nil)
+	snClientMock.On("PatchIncident", "incident", mock.Anything, "42").Return(Incident{}, errors.New("submit error"))
+	snClientMock.On("DeleteIncident", "incident", "42").Return(errors.New("delete error"))
+
+	_, err := createIncident(context.Background(), "incident", Incident{"short_description": "Oops"})
+	if err == nil || !strings.Contains(err.Error(), "delete error") {
+		t.Errorf("Expected an error mentioning the rollback failure, got %v", err)
+	}
+}
+
+func TestTwoPhaseCreateConfig_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  TwoPhaseCreateConfig
+		wantErr bool
+	}{
+		{name: "disabled", config: TwoPhaseCreateConfig{}, wantErr: false},
+		{name: "ok", config: twoPhaseCreateConfig, wantErr: false},
+		{name: "missing_states", config: TwoPhaseCreateConfig{Enabled: true}, wantErr: true},
+		{name: "cancel_missing_state", config: TwoPhaseCreateConfig{Enabled: true, DraftState: "-5", SubmitState: "1", Rollback: rollbackCancel}, wantErr: true},
+		{name: "unknown_rollback", config: TwoPhaseCreateConfig{Enabled: true, DraftState: "-5", SubmitState: "1", Rollback: "ignore"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var errs strings.Builder
+			tt.config.validate(&errs)
+			if (errs.Len() > 0) != tt.wantErr {
+				t.Errorf("validate() errors = %v, wantErr %v", errs.String(), tt.wantErr)
+			}
+		})
+	}
+}
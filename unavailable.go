@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const defaultDeadLetterReplayInterval = time.Minute
+
+var deadLettersAccepted = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Name: "webhook_dead_letters_accepted_total",
+		Help: "Total number of alert groups answered with a 202 while ServiceNow was unavailable, persisted to the dead-letter queue.",
+	},
+)
+
+func (c DeadLetterConfig) replayInterval() time.Duration {
+	if c.ReplayInterval == 0 {
+		return defaultDeadLetterReplayInterval
+	}
+	return c.ReplayInterval
+}
+
+// serviceNowUnavailable tells whether the processing failed as ServiceNow was unavailable: overloaded, behind an open
+// circuit breaker, unreachable or failing with a transient error
+func serviceNowUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if _, ok := err.(*overloadError); ok {
+		return true
+	}
+	return errorClass(err) == errorClassTransient
+}
+
+// acceptAlertGroup persists the alert group failing while ServiceNow is unavailable, to be replayed automatically,
+// when dead_letter.accept_when_unavailable is enabled. It returns whether the alert group was accepted.
+func acceptAlertGroup(ctx context.Context, data template.Data, processingErr error) bool {
+	config := configFrom(ctx)
+	if !config.DeadLetter.AcceptWhenUnavailable || deadLetters == nil || isDryRun(ctx) || !serviceNowUnavailable(processingErr) {
+		return false
+	}
+	now := time.Now()
+	id, err := deadLetters.addEntry(deadLetter{
+		ID:              newDeadLetterID(now),
+		Time:            now,
+		Error:           processingErr.Error(),
+		Attempts:        1,
+		Data:            data,
+		WebhookReceiver: receiverFrom(ctx),
+		Accepted:        true,
+	})
+	if err != nil {
+		loggerFrom(ctx).Errorf("Error persisting the alert group to the dead-letter queue, it is answered with an error: %v", err)
+		return false
+	}
+	deadLettersAccepted.Inc()
+	loggerFrom(ctx).Warnf("ServiceNow is unavailable, alert group accepted and persisted to the dead-letter queue as %s: %v", id, processingErr)
+	return true
+}
+
+// replayAcceptedDeadLetters replays the accepted entries of the dead-letter queue, oldest first, stopping at the first
+// one failing as ServiceNow is still unavailable, so that the alert groups are delivered in order
+func replayAcceptedDeadLetters(ctx context.Context) {
+	deadLetters.mutex.Lock()
+	ids, err := deadLetters.list()
+	deadLetters.mutex.Unlock()
+	if err != nil {
+		baseLogger.Errorf("Error listing the dead-letters: %v", err)
+		return
+	}
+	for _, id := range ids {
+		deadLetters.mutex.Lock()
+		entry, err := deadLetters.read(id)
+		deadLetters.mutex.Unlock()
+		if err != nil || !entry.Accepted {
+			continue
+		}
+		if err := deadLetters.replay(ctx, id); err != nil {
+			baseLogger.Warnf("Error delivering the accepted dead-letter %s: %v", id, err)
+			if serviceNowUnavailable(err) {
+				return
+			}
+			continue
+		}
+		baseLogger.Infof("Accepted dead-letter %s delivered", id)
+	}
+}
+
+// startDeadLetterReplay starts the background task delivering the alert groups accepted while ServiceNow was
+// unavailable, checking the configuration on each run so that accept_when_unavailable can be enabled by a reload
+func startDeadLetterReplay(config Config) {
+	if deadLetters == nil {
+		return
+	}
+	backgroundTasks.Go("dead-letter replay", func(ctx context.Context) {
+		ctx = withAuditCaller(ctx, auditCaller{Source: "dead-letter replay"})
+		runEvery(ctx, config.DeadLetter.replayInterval(), func() {
+			// The dead-letters are replayed by the leader replica only
+			if !leader.isLeader() {
+				return
+			}
+			ctx := withConfigSnapshot(ctx)
+			if configFrom(ctx).DeadLetter.AcceptWhenUnavailable {
+				replayAcceptedDeadLetters(ctx)
+			}
+		})
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+func TestServiceNowUnavailable(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{&overloadError{message: "The circuit breaker of ServiceNow instance test is open"}, true},
+		{errors.New("dial tcp: connection refused"), true},
+		{&httpStatusError{statusCode: http.StatusForbidden}, false},
+		{&requiredFieldError{}, false},
+	} {
+		if got := serviceNowUnavailable(test.err); got != test.want {
+			t.Errorf("Wrong unavailability of %v: got %v, want %v", test.err, got, test.want)
+		}
+	}
+}
+
+func TestWebhookHandler_AcceptWhenUnavailable(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	q, cleanup := newTestDeadLetterQueue(t)
+	defer cleanup()
+	currentConfig().DeadLetter.AcceptWhenUnavailable = true
+	dedupStore = newMemoryDedupStore()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident(nil), &overloadError{message: "The circuit breaker of ServiceNow instance test is open"})
+
+	if rr := postAlertGroup(t, "test/alertmanager_firing.json"); rr.Code != http.StatusAccepted {
+		t.Fatalf("Wrong status code: got %v, want %v", rr.Code, http.StatusAccepted)
+	}
+	ids, _ := q.list()
+	if len(ids) != 1 {
+		t.Fatalf("The accepted alert group should be persisted: %v", ids)
+	}
+	if entry, _ := q.read(ids[0]); !entry.Accepted {
+		t.Errorf("The entry should be marked as accepted: %+v", entry)
+	}
+
+	// Still unavailable: the entry is kept
+	replayAcceptedDeadLetters(context.Background())
+	if ids, _ := q.list(); len(ids) != 1 {
+		t.Fatalf("The entry should be kept while ServiceNow is unavailable: %v", ids)
+	}
+
+	deliveredMock := new(MockedSnClient)
+	useServiceNow(deliveredMock)
+	deliveredMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+	deliveredMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{"sys_id": "1", "number": "INC1"}, nil)
+	replayAcceptedDeadLetters(context.Background())
+	if ids, _ := q.list(); len(ids) != 0 {
+		t.Errorf("The delivered entry should be removed: %v", ids)
+	}
+	deliveredMock.AssertNumberOfCalls(t, "CreateIncident", 1)
+}
+
+func TestWebhookHandler_AcceptWhenUnavailable_PermanentError(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	q, cleanup := newTestDeadLetterQueue(t)
+	defer cleanup()
+	currentConfig().DeadLetter.AcceptWhenUnavailable = true
+	currentConfig().Webhook.ErrorStatusCodes.Permanent = http.StatusBadRequest
+	dedupStore = newMemoryDedupStore()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", mock.Anything, mock.Anything).Return(Incident{}, &httpStatusError{statusCode: http.StatusForbidden})
+
+	if rr := postAlertGroup(t, "test/alertmanager_firing.json"); rr.Code == http.StatusAccepted {
+		t.Fatalf("A permanent error should not be accepted")
+	}
+	ids, _ := q.list()
+	if len(ids) != 1 {
+		t.Fatalf("The alert group should be dead-lettered: %v", ids)
+	}
+	if entry, _ := q.read(ids[0]); entry.Accepted {
+		t.Errorf("The alert group should be dead-lettered without being accepted: %+v", entry)
+	}
+}
+
+func TestAsyncQueue_AcceptWhenUnavailable(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	q, cleanup := newTestDeadLetterQueue(t)
+	defer cleanup()
+	currentConfig().DeadLetter.AcceptWhenUnavailable = true
+	dedupStore = newMemoryDedupStore()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", mock.Anything, mock.Anything).Return([]Incident(nil), errors.New("dial tcp: connection refused"))
+
+	tasks := newTaskGroup()
+	alertGroupQueue = newAsyncQueue(10)
+	defer func() { alertGroupQueue = nil }()
+	alertGroupQueue.start(tasks, 1)
+	if rr := postAlertGroup(t, "test/alertmanager_firing.json"); rr.Code != http.StatusAccepted {
+		t.Fatalf("Wrong status code: got %v, want %v", rr.Code, http.StatusAccepted)
+	}
+	if running := tasks.Stop(time.Second); len(running) > 0 {
+		t.Fatalf("Workers still running: %v", running)
+	}
+
+	ids, _ := q.list()
+	if len(ids) != 1 {
+		t.Fatalf("The alert group failing in the queue should be persisted once: %v", ids)
+	}
+	if entry, _ := q.read(ids[0]); !entry.Accepted {
+		t.Errorf("The entry should be marked as accepted, to be replayed automatically: %+v", entry)
+	}
+}
+
+func TestDeadLetterConfig_Validate(t *testing.T) {
+	var errs strings.Builder
+	DeadLetterConfig{AcceptWhenUnavailable: true, ReplayInterval: -1}.validate(&errs)
+	for _, want := range []string{"accept_when_unavailable requires dead_letter.directory", "replay_interval must not be negative"} {
+		if !strings.Contains(errs.String(), want) {
+			t.Errorf("Missing validation error %q: %q", want, errs.String())
+		}
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	defaultVaultMount           = "secret"
+	defaultVaultRefreshInterval = 5 * time.Minute
+	vaultTimeout                = 10 * time.Second
+)
+
+var vaultErrors = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Name: "webhook_vault_errors_total",
+		Help: "Total number of errors fetching the ServiceNow credentials from Vault or renewing the Vault token.",
+	},
+)
+
+// VaultConfig - ServiceNow credentials fetched from a HashiCorp Vault KV secret
+type VaultConfig struct {
+	Address         string          `yaml:"address"`
+	Token           string          `yaml:"token"`
+	TokenFile       string          `yaml:"token_file"`
+	Mount           string          `yaml:"mount"`
+	Path            string          `yaml:"path"`
+	KVVersion       int             `yaml:"kv_version"`
+	Keys            VaultKeysConfig `yaml:"keys"`
+	RefreshInterval time.Duration   `yaml:"refresh_interval"`
+	TLSConfig       TLSConfig       `yaml:"tls_config"`
+}
+
+// VaultKeysConfig - Keys of the Vault secret holding the ServiceNow credentials
+type VaultKeysConfig struct {
+	UserName     string `yaml:"user_name"`
+	Password     string `yaml:"password"`
+	ClientSecret string `yaml:"client_secret"`
+}
+
+func (c VaultConfig) enabled() bool {
+	return len(c.Path) > 0
+}
+
+func (c VaultConfig) validate(errs *strings.Builder) {
+	if !c.enabled() {
+		return
+	}
+	if len(c.address()) == 0 {
+		errs.WriteString("vault.address is missing, and VAULT_ADDR is not set\n")
+	}
+	if len(c.Token) > 0 && len(c.TokenFile) > 0 {
+		errs.WriteString("vault.token and vault.token_file are mutually exclusive\n")
+	}
+	if c.KVVersion != 0 && c.KVVersion != 1 && c.KVVersion != 2 {
+		errs.WriteString("vault.kv_version must be 1 or 2\n")
+	}
+	if c.RefreshInterval < 0 {
+		errs.WriteString("vault.refresh_interval must not be negative\n")
+	}
+	var tlsErrs strings.Builder
+	c.TLSConfig.validate(&tlsErrs)
+	for _, err := range strings.SplitAfter(tlsErrs.String(), "\n") {
+		if len(err) > 0 {
+			errs.WriteString("vault." + err)
+		}
+	}
+}
+
+// address returns the Vault address, defaulting to the VAULT_ADDR environment variable
+func (c VaultConfig) address() string {
+	if len(c.Address) > 0 {
+		return strings.TrimRight(c.Address, "/")
+	}
+	return strings.TrimRight(os.Getenv("VAULT_ADDR"), "/")
+}
+
+// token returns the Vault token, read from the token file on each call so that a rotated token is used, and
+// defaulting to the VAULT_TOKEN environment variable
+func (c VaultConfig) token() (string, error) {
+	if len(c.Token) > 0 {
+		return c.Token, nil
+	}
+	if len(c.TokenFile) > 0 {
+		token, err := ioutil.ReadFile(c.TokenFile)
+		if err != nil {
+			return "", fmt.Errorf("error reading the Vault token file: %v", err)
+		}
+		return strings.TrimSpace(string(token)), nil
+	}
+	return os.Getenv("VAULT_TOKEN"), nil
+}
+
+func (c VaultConfig) refreshInterval() time.Duration {
+	if c.RefreshInterval == 0 {
+		return defaultVaultRefreshInterval
+	}
+	return c.RefreshInterval
+}
+
+// mount returns the mount path of the KV secrets engine
+func (c VaultConfig) mount() string {
+	if len(c.Mount) == 0 {
+		return defaultVaultMount
+	}
+	return strings.Trim(c.Mount, "/")
+}
+
+// secretPath returns the path of the secret within the KV secrets engine
+func (c VaultConfig) secretPath() string {
+	return strings.Trim(c.Path, "/")
+}
+
+func (c VaultKeysConfig) userName() string {
+	if len(c.UserName) == 0 {
+		return "user_name"
+	}
+	return c.UserName
+}
+
+func (c VaultKeysConfig) password() string {
+	if len(c.Password) == 0 {
+		return "password"
+	}
+	return c.Password
+}
+
+func (c VaultKeysConfig) clientSecret() string {
+	if len(c.ClientSecret) == 0 {
+		return "client_secret"
+	}
+	return c.ClientSecret
+}
+
+// newVaultClient returns a client of the Vault API, authenticated with the Vault token
+func newVaultClient(c VaultConfig) (*vault.Client, error) {
+	tlsConfig, err := newTLSConfig(c.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	token, err := c.token()
+	if err != nil {
+		return nil, err
+	}
+	vaultConfig := vault.DefaultConfig()
+	if vaultConfig.Error != nil {
+		return nil, vaultConfig.Error
+	}
+	vaultConfig.Address = c.address()
+	vaultConfig.Timeout = vaultTimeout
+	vaultConfig.HttpClient.Transport.(*http.Transport).TLSClientConfig = tlsConfig
+	client, err := vault.NewClient(vaultConfig)
+	if err != nil {
+		return nil, err
+	}
+	client.SetToken(token)
+	return client, nil
+}
+
+// fetchVaultSecret reads the values of the Vault KV secret
+func fetchVaultSecret(ctx context.Context, c VaultConfig) (map[string]string, error) {
+	client, err := newVaultClient(c)
+	if err != nil {
+		return nil, err
+	}
+	var secret *vault.KVSecret
+	if c.KVVersion == 1 {
+		secret, err = client.KVv1(c.mount()).Get(ctx, c.secretPath())
+	} else {
+		secret, err = client.KVv2(c.mount()).Get(ctx, c.secretPath())
+	}
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(secret.Data))
+	for key, value := range secret.Data {
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("the value of the key %s of the Vault secret is not a string", key)
+		}
+		values[key] = s
+	}
+	return values, nil
+}
+
+// renewVaultToken extends the lease of the Vault token
+func renewVaultToken(ctx context.Context, c VaultConfig) error {
+	client, err := newVaultClient(c)
+	if err != nil {
+		return err
+	}
+	_, err = client.Auth().Token().RenewSelfWithContext(ctx, 0)
+	return err
+}
+
+// serviceNowCredentials are the credentials of a ServiceNow instance that can be fetched from Vault
+type serviceNowCredentials struct {
+	userName     string
+	password     string
+	clientSecret string
+}
+
+func (c ServiceNowConfig) credentials() serviceNowCredentials {
+	return serviceNowCredentials{userName: c.UserName, password: c.Password, clientSecret: c.OAuth2.ClientSecret}
+}
+
+// credentials returns the credentials of each ServiceNow instance, by instance name
+func (c Config) credentials() map[string]serviceNowCredentials {
+	credentials := map[string]serviceNowCredentials{"": c.ServiceNow.credentials()}
+	for name, instance := range c.Instances {
+		credentials[name] = instance.credentials()
+	}
+	return credentials
+}
+
+// applyVaultSecret sets the credentials found in the secret
+func (c *ServiceNowConfig) applyVaultSecret(secret map[string]string) {
+	set := func(credential *string, key string) {
+		if value, ok := secret[key]; ok {
+			*credential = value
+		}
+	}
+	set(&c.UserName, c.Vault.Keys.userName())
+	set(&c.Password, c.Vault.Keys.password())
+	set(&c.OAuth2.ClientSecret, c.Vault.Keys.clientSecret())
+}
+
+// fetchVaultSecrets reads the Vault secret of each ServiceNow instance using Vault, by instance name, the default
+// instance being named "". The Vault tokens are renewed first when requested.
+func fetchVaultSecrets(ctx context.Context, c Config, renew bool) (map[string]map[string]string, error) {
+	configs := map[string]VaultConfig{"": c.ServiceNow.Vault}
+	for name, instance := range c.Instances {
+		configs[name] = instance.Vault
+	}
+
+	secrets := map[string]map[string]string{}
+	for name, vault := range configs {
+		if !vault.enabled() {
+			continue
+		}
+		if renew {
+			if err := renewVaultToken(ctx, vault); err != nil {
+				vaultErrors.Inc()
+				baseLogger.Warnf("Error renewing the Vault token: %v", err)
+			}
+		}
+		secret, err := fetchVaultSecret(ctx, vault)
+		if err != nil {
+			vaultErrors.Inc()
+			return nil, fmt.Errorf("error fetching the ServiceNow credentials from Vault: %v", err)
+		}
+		secrets[name] = secret
+	}
+	return secrets, nil
+}
+
+// applyVaultSecrets sets the credentials of the ServiceNow instances with their Vault secret, returning whether they
+// changed. The environment variables still override the credentials. The maps of the config are copied first, as they
+// may be shared with the snapshot in use.
+func applyVaultSecrets(c *Config, secrets map[string]map[string]string) bool {
+	before := c.credentials()
+	if c.Instances != nil {
+		instances := make(map[string]ServiceNowConfig, len(c.Instances))
+		for name, instance := range c.Instances {
+			instances[name] = instance
+		}
+		c.Instances = instances
+	}
+	if c.DefaultIncident != nil {
+		defaultIncident := make(map[string]string, len(c.DefaultIncident))
+		for field, value := range c.DefaultIncident {
+			defaultIncident[field] = value
+		}
+		c.DefaultIncident = defaultIncident
+	}
+	for name, secret := range secrets {
+		if len(name) == 0 {
+			c.ServiceNow.applyVaultSecret(secret)
+			continue
+		}
+		instance := c.Instances[name]
+		instance.applyVaultSecret(secret)
+		c.Instances[name] = instance
+	}
+	loadEnvVars(c)
+
+	for name, credentials := range c.credentials() {
+		if credentials != before[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveVaultCredentials fetches the credentials of the ServiceNow instances using Vault
+func resolveVaultCredentials(ctx context.Context, c *Config) error {
+	secrets, err := fetchVaultSecrets(ctx, *c, false)
+	if err != nil {
+		return err
+	}
+	applyVaultSecrets(c, secrets)
+	return nil
+}
+
+// loadVaultCredentials fetches the credentials of the ServiceNow instances of the loaded config using Vault
+func loadVaultCredentials(ctx context.Context) error {
+	snapshotMutex.Lock()
+	defer snapshotMutex.Unlock()
+	s := currentConfigSnapshot()
+	c := s.config
+	if err := resolveVaultCredentials(ctx, &c); err != nil {
+		return err
+	}
+	currentSnapshot.Store(s.withServiceNow(c, s.serviceNow, s.serviceNowInstances))
+	return nil
+}
+
+// refreshVaultCredentials refetches the Vault secrets and publishes new ServiceNow clients when the credentials
+// changed, without waiting for the requests in flight
+func refreshVaultCredentials(ctx context.Context) error {
+	secrets, err := fetchVaultSecrets(ctx, *currentConfig(), true)
+	if err != nil {
+		return err
+	}
+
+	snapshotMutex.Lock()
+	defer snapshotMutex.Unlock()
+	s := currentConfigSnapshot()
+	c := s.config
+	if !applyVaultSecrets(&c, secrets) {
+		return nil
+	}
+	client, err := newSnClient(c.ServiceNow)
+	if err != nil {
+		return fmt.Errorf("Error loading ServiceNow client: %v", err)
+	}
+	instances, err := newSnClients(c)
+	if err != nil {
+		return fmt.Errorf("Error loading ServiceNow client: %v", err)
+	}
+	currentSnapshot.Store(s.withServiceNow(c, client, instances))
+	baseLogger.Info("ServiceNow credentials refreshed from Vault")
+	return nil
+}
+
+// startVaultRefresh starts the background task refetching the Vault secrets, at the shortest refresh interval of the
+// ServiceNow instances using Vault
+func startVaultRefresh(config Config) {
+	var interval time.Duration
+	vaults := []VaultConfig{config.ServiceNow.Vault}
+	for _, instance := range config.Instances {
+		vaults = append(vaults, instance.Vault)
+	}
+	for _, vault := range vaults {
+		if vault.enabled() && (interval == 0 || vault.refreshInterval() < interval) {
+			interval = vault.refreshInterval()
+		}
+	}
+	if interval == 0 {
+		return
+	}
+	backgroundTasks.Go("vault refresh", func(ctx context.Context) {
+		runEvery(ctx, interval, func() {
+			if err := refreshVaultCredentials(ctx); err != nil {
+				baseLogger.Errorf("Error refreshing the ServiceNow credentials from Vault, the current ones are kept: %v", err)
+			}
+		})
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// vaultServer serves a KV version 2 secret at secret/servicenow, requiring the token
+type vaultServer struct {
+	mutex    sync.Mutex
+	password string
+	renewals int
+}
+
+func (s *vaultServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Vault-Token") != "token" {
+		http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch r.URL.Path {
+	case "/v1/secret/data/servicenow":
+		fmt.Fprintf(w, `{"data":{"data":{"user_name":"vault_user","password":%q},"metadata":{"version":1}}}`, s.password)
+	case "/v1/auth/token/renew-self":
+		s.renewals++
+		fmt.Fprint(w, `{"auth":{"client_token":"token","renewable":true}}`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *vaultServer) setPassword(password string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.password = password
+}
+
+func TestResolveVaultCredentials(t *testing.T) {
+	vault := &vaultServer{password: "vault_password"}
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	loadConfig("config/servicenow_example.yml")
+	c := *currentConfig()
+	c.ServiceNow.Vault = VaultConfig{Address: server.URL, Token: "token", Path: "servicenow"}
+	if err := resolveVaultCredentials(context.Background(), &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.ServiceNow.UserName != "vault_user" || c.ServiceNow.Password != "vault_password" {
+		t.Errorf("Unexpected credentials: %+v", c.ServiceNow.credentials())
+	}
+
+	c.ServiceNow.Vault.Token = "other"
+	if err := resolveVaultCredentials(context.Background(), &c); err == nil || !strings.Contains(err.Error(), "Code: 403") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestRefreshVaultCredentials(t *testing.T) {
+	vault := &vaultServer{password: "vault_password"}
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().ServiceNow.Vault = VaultConfig{Address: server.URL, Token: "token", Path: "servicenow"}
+	if err := resolveVaultCredentials(context.Background(), currentConfig()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadSnClient(); err != nil {
+		t.Fatal(err)
+	}
+	client := currentConfigSnapshot().serviceNow
+
+	if err := refreshVaultCredentials(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if currentConfigSnapshot().serviceNow != client {
+		t.Errorf("The ServiceNow client should be kept when the credentials did not change")
+	}
+
+	vault.setPassword("rotated")
+	if err := refreshVaultCredentials(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if currentConfig().ServiceNow.Password != "rotated" || currentConfigSnapshot().serviceNow == client {
+		t.Errorf("The ServiceNow client should be reloaded with the rotated password, got %q", currentConfig().ServiceNow.Password)
+	}
+	if vault.renewals != 2 {
+		t.Errorf("Unexpected token renewals: got %d, want 2", vault.renewals)
+	}
+}
+
+func TestFetchVaultSecret_KVVersion1(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/kv/servicenow" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"data":{"user_name":"vault_user","password":"vault_password"}}`)
+	}))
+	defer server.Close()
+
+	secret, err := fetchVaultSecret(context.Background(), VaultConfig{Address: server.URL, Token: "token", Mount: "/kv/", Path: "/servicenow/", KVVersion: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret["user_name"] != "vault_user" || secret["password"] != "vault_password" {
+		t.Errorf("Unexpected secret: %v", secret)
+	}
+}
+
+func TestVaultConfig_Validate(t *testing.T) {
+	var errs strings.Builder
+	VaultConfig{Path: "servicenow", Token: "token", TokenFile: "token", KVVersion: 3, TLSConfig: TLSConfig{CertFile: "cert"}}.validate(&errs)
+	for _, want := range []string{"vault.address is missing", "mutually exclusive", "vault.kv_version", "vault.tls_config.cert_file"} {
+		if !strings.Contains(errs.String(), want) {
+			t.Errorf("Validation errors %q should contain %q", errs.String(), want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+const (
+	watchListField            = "watch_list"
+	defaultWatchListSeparator = ","
+)
+
+// WatchListConfig - Incident watch list population configuration
+type WatchListConfig struct {
+	Label      string `yaml:"label"`
+	Annotation string `yaml:"annotation"`
+	Separator  string `yaml:"separator"`
+}
+
+func (c WatchListConfig) enabled() bool {
+	return len(c.Label) > 0 || len(c.Annotation) > 0
+}
+
+// watchListUsers returns the distinct user names found in the configured label and annotation of the alerts
+func watchListUsers(c WatchListConfig, data template.Data) []string {
+	separator := c.Separator
+	if len(separator) == 0 {
+		separator = defaultWatchListSeparator
+	}
+
+	var users []string
+	seen := map[string]bool{}
+	for _, alert := range data.Alerts {
+		for _, value := range []string{alert.Labels[c.Label], alert.Annotations[c.Annotation]} {
+			for _, user := range strings.Split(value, separator) {
+				user = strings.TrimSpace(user)
+				if len(user) > 0 && !seen[user] {
+					seen[user] = true
+					users = append(users, user)
+				}
+			}
+		}
+	}
+	return users
+}
+
+// applyWatchList sets the incident watch list with the sys_ids of the users found in the configured label and
+// annotation. The field is omitted when no user is resolved.
+func applyWatchList(ctx context.Context, incident Incident, data template.Data) {
+	config := configFrom(ctx)
+	if !config.WatchList.enabled() {
+		return
+	}
+
+	sysIDs := resolveUsers(ctx, watchListUsers(config.WatchList, data))
+	if len(sysIDs) > 0 {
+		incident[watchListField] = strings.Join(sysIDs, ",")
+	}
+}
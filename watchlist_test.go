@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/mock"
+)
+
+func mockUserLookup(snClientMock *MockedSnClient, userName string, sysID string) {
+	var users []Incident
+	if len(sysID) > 0 {
+		users = []Incident{Incident{"sys_id": sysID}}
+	}
+	snClientMock.On("GetIncidents", "sys_user", map[string]string{"user_name": userName, "sysparm_fields": "sys_id", "sysparm_limit": "1"}).Return(users, nil)
+}
+
+func TestWatchListUsers(t *testing.T) {
+	data := template.Data{
+		Alerts: template.Alerts{
+			template.Alert{Labels: template.KV{"oncall_users": "jdoe, asmith"}},
+			template.Alert{Labels: template.KV{"oncall_users": "asmith,bwayne,"}},
+			template.Alert{Labels: template.KV{}},
+		},
+	}
+	got := watchListUsers(WatchListConfig{Label: "oncall_users"}, data)
+	want := []string{"jdoe", "asmith", "bwayne"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected users: got %v, want %v", got, want)
+	}
+
+	got = watchListUsers(WatchListConfig{Label: "oncall_users", Separator: ";"}, template.Data{
+		Alerts: template.Alerts{template.Alert{Labels: template.KV{"oncall_users": "jdoe;asmith"}}},
+	})
+	want = []string{"jdoe", "asmith"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected users: got %v, want %v", got, want)
+	}
+
+	got = watchListUsers(WatchListConfig{Label: "oncall_users", Annotation: "watchers"}, template.Data{
+		Alerts: template.Alerts{template.Alert{Labels: template.KV{"oncall_users": "jdoe"}, Annotations: template.KV{"watchers": "asmith,jdoe"}}},
+	})
+	want = []string{"jdoe", "asmith"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected users from the label and annotation: got %v, want %v", got, want)
+	}
+}
+
+func TestApplyWatchList_MultipleUsers(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().WatchList = WatchListConfig{Label: "oncall_users"}
+	userCache = newLookupCache("user", time.Minute)
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	mockUserLookup(snClientMock, "jdoe", "1")
+	mockUserLookup(snClientMock, "unknown", "")
+	mockUserLookup(snClientMock, "asmith", "2")
+	snClientMock.On("GetIncidents", "sys_user", map[string]string{"user_name": "failing", "sysparm_fields": "sys_id", "sysparm_limit": "1"}).Return([]Incident{}, errors.New("Error"))
+
+	incident := Incident{}
+	applyWatchList(context.Background(), incident, template.Data{
+		Alerts: template.Alerts{template.Alert{Labels: template.KV{"oncall_users": "jdoe,unknown,failing,asmith"}}},
+	})
+
+	if incident[watchListField] != "1,2" {
+		t.Errorf("Unexpected watch list: got %v, want %v", incident[watchListField], "1,2")
+	}
+}
+
+func TestApplyWatchList_Empty(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().WatchList = WatchListConfig{Label: "oncall_users"}
+	userCache = newLookupCache("user", time.Minute)
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	mockUserLookup(snClientMock, "unknown", "")
+
+	incident := Incident{}
+	applyWatchList(context.Background(), incident, template.Data{
+		Alerts: template.Alerts{template.Alert{Labels: template.KV{"oncall_users": "unknown"}}},
+	})
+
+	if _, ok := incident[watchListField]; ok {
+		t.Errorf("Watch list should be omitted when no user is resolved, got %v", incident[watchListField])
+	}
+}
+
+func TestOnAlertGroup_WatchList_OnCreate(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	currentConfig().WatchList = WatchListConfig{Label: "oncall_users"}
+	userCache = newLookupCache("user", time.Minute)
+	dedupStore = newMemoryDedupStore()
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	mockUserLookup(snClientMock, "jdoe", "1")
+	snClientMock.On("GetIncidents", "incident", mock.Anything).Return([]Incident{}, nil)
+	snClientMock.On("CreateIncident", "incident", mock.Anything).Run(func(args mock.Arguments) {
+		incident := args.Get(1).(Incident)
+		if incident[watchListField] != "1" {
+			t.Errorf("Unexpected watch list: got %v, want %v", incident[watchListField], "1")
+		}
+	}).Return(Incident{}, nil)
+
+	data := template.Data{
+		Status:      "firing",
+		GroupLabels: template.KV{"alertname": "watch_list"},
+		Alerts:      template.Alerts{template.Alert{Labels: template.KV{"oncall_users": "jdoe"}}},
+	}
+	if err := onAlertGroup(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+	snClientMock.AssertCalled(t, "CreateIncident", "incident", mock.Anything)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+const (
+	workNotesField                = "work_notes"
+	defaultRepeatWorkNote         = "{{ .AlertCount }} alert(s) firing: {{ .InstanceList }}"
+	defaultNotificationCounterTTL = 7 * 24 * time.Hour
+	workNoteTimeLayout            = "2006-01-02 15:04:05 MST"
+)
+
+// RepeatWorkNotesConfig - Work note added to the existing incident on each repeat firing notification of its alert group
+type RepeatWorkNotesConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	Template   string        `yaml:"template"`
+	CounterTTL time.Duration `yaml:"counter_ttl"`
+}
+
+func (c RepeatWorkNotesConfig) template() string {
+	if len(c.Template) == 0 {
+		return defaultRepeatWorkNote
+	}
+	return c.Template
+}
+
+func (c RepeatWorkNotesConfig) counterTTL() time.Duration {
+	if c.CounterTTL <= 0 {
+		return defaultNotificationCounterTTL
+	}
+	return c.CounterTTL
+}
+
+// notificationCounterKey is the deduplication store key of the number of notifications of the alert group key
+func notificationCounterKey(key string) string {
+	return "notifications:" + key
+}
+
+// countNotification returns the number of the repeat firing notification of the alert group, the first one having
+// created the incident, and stores it. It must be called with the incident lock of the alert group held.
+func countNotification(ctx context.Context, key string) int {
+	config := configFrom(ctx)
+	counterKey := notificationCounterKey(key)
+	count := 1
+	if value, err := dedupStore.Get(counterKey); err != nil {
+		loggerFrom(ctx).Errorf("Error reading the notification counter of alert group key %s: %v", key, err)
+	} else if n, err := strconv.Atoi(value); err == nil {
+		count = n
+	}
+	count++
+	if isDryRun(ctx) {
+		return count
+	}
+	if err := dedupStore.Set(counterKey, strconv.Itoa(count), config.Workflow.RepeatWorkNotes.counterTTL()); err != nil {
+		loggerFrom(ctx).Errorf("Error storing the notification counter of alert group key %s: %v", key, err)
+	}
+	return count
+}
+
+// resetNotifications forgets the number of notifications of the resolved alert group
+func resetNotifications(ctx context.Context, key string) {
+	config := configFrom(ctx)
+	if !config.Workflow.RepeatWorkNotes.Enabled || isDryRun(ctx) {
+		return
+	}
+	if err := dedupStore.Delete(notificationCounterKey(key)); err != nil {
+		loggerFrom(ctx).Errorf("Error resetting the notification counter of alert group key %s: %v", key, err)
+	}
+}
+
+// applyRepeatWorkNote adds the timestamped work note of the repeat firing notification to the incident update, before
+// the work notes of the incident_update_fields, if any
+func applyRepeatWorkNote(ctx context.Context, incident Incident, key string, data template.Data, now time.Time) {
+	config := configFrom(ctx)
+	c := config.Workflow.RepeatWorkNotes
+	if !c.Enabled {
+		return
+	}
+
+	note := Incident{workNotesField: c.template()}
+	applyIncidentTemplate(ctx, note, data)
+	text := fmt.Sprintf("%s - alert still firing, %s notification: %s", now.UTC().Format(workNoteTimeLayout), ordinal(countNotification(ctx, key)), note[workNotesField])
+	if existing, ok := incident[workNotesField].(string); ok && len(existing) > 0 {
+		text += "\n\n" + existing
+	}
+	incident[workNotesField] = text
+}
+
+// ordinal returns the English ordinal of the number, e.g. 1st, 2nd, 3rd, 4th, 11th or 22nd
+func ordinal(n int) string {
+	suffix := "th"
+	switch n % 10 {
+	case 1:
+		suffix = "st"
+	case 2:
+		suffix = "nd"
+	case 3:
+		suffix = "rd"
+	}
+	if n%100 >= 11 && n%100 <= 13 {
+		suffix = "th"
+	}
+	return strconv.Itoa(n) + suffix
+}
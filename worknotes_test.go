@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestOrdinal(t *testing.T) {
+	expected := map[int]string{1: "1st", 2: "2nd", 3: "3rd", 4: "4th", 11: "11th", 12: "12th", 13: "13th", 21: "21st", 102: "102nd", 111: "111th"}
+	for n, s := range expected {
+		if ordinal(n) != s {
+			t.Errorf("Unexpected ordinal of %d: %s", n, ordinal(n))
+		}
+	}
+}
+
+func TestApplyRepeatWorkNote(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	dedupStore = newMemoryDedupStore()
+	currentConfig().Workflow.RepeatWorkNotes = RepeatWorkNotesConfig{Enabled: true, Template: "value={{ (index .Alerts 0).Annotations.value }}"}
+	data := template.Data{Alerts: template.Alerts{template.Alert{Annotations: template.KV{"value": "95"}}}}
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	incident := Incident{}
+	applyRepeatWorkNote(context.Background(), incident, "key", data, now)
+	if incident[workNotesField] != "2024-01-02 15:04:05 UTC - alert still firing, 2nd notification: value=95" {
+		t.Errorf("Unexpected work note: %v", incident[workNotesField])
+	}
+
+	incident = Incident{workNotesField: "other note"}
+	applyRepeatWorkNote(context.Background(), incident, "key", data, now)
+	if incident[workNotesField] != "2024-01-02 15:04:05 UTC - alert still firing, 3rd notification: value=95\n\nother note" {
+		t.Errorf("Unexpected work note: %v", incident[workNotesField])
+	}
+
+	applyRepeatWorkNote(withDryRun(context.Background()), Incident{}, "key", data, now)
+	resetNotifications(context.Background(), "key")
+	incident = Incident{}
+	applyRepeatWorkNote(context.Background(), incident, "key", data, now)
+	if !strings.Contains(incident[workNotesField].(string), "2nd notification") {
+		t.Errorf("Notification count should be reset, and not stored in dry run: %v", incident[workNotesField])
+	}
+}
+
+func TestOnAlertGroup_RepeatWorkNote(t *testing.T) {
+	loadConfig("config/servicenow_example.yml")
+	dedupStore = newMemoryDedupStore()
+	currentConfig().Workflow.RepeatWorkNotes = RepeatWorkNotesConfig{Enabled: true}
+	snClientMock := new(MockedSnClient)
+	useServiceNow(snClientMock)
+	snClientMock.On("GetIncidents", "incident", mock.Anything).Return([]Incident{Incident{"sys_id": "1", "number": "INC1", "state": "2"}}, nil)
+	snClientMock.On("UpdateIncident", "incident", mock.Anything, "1").Return(Incident{}, nil)
+
+	data := template.Data{
+		Status:      "firing",
+		GroupLabels: template.KV{"alertname": "DiskFull"},
+		Alerts:      template.Alerts{template.Alert{Status: "firing"}},
+	}
+	if err := onAlertGroup(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+
+	snClientMock.AssertNotCalled(t, "CreateIncident", mock.Anything, mock.Anything)
+	incident := snClientMock.Calls[1].Arguments.Get(1).(Incident)
+	if note, _ := incident[workNotesField].(string); !strings.Contains(note, "alert still firing, 2nd notification: 1 alert(s) firing") {
+		t.Errorf("Unexpected work note: %v", incident)
+	}
+}